@@ -76,7 +76,7 @@ func (c *CreateMailboxCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/smailnail/pkg/mailutil"
+)
+
+// threadFields are the --message-id, --in-reply-to and --references flags
+// of the store commands, used to build reply chains by hand.
+func threadFields() []*fields.Definition {
+	return []*fields.Definition{
+		fields.New(
+			"message-id",
+			fields.TypeString,
+			fields.WithHelp("Message-ID, e.g. <first@example.com> (generated by default)"),
+		),
+		fields.New(
+			"in-reply-to",
+			fields.TypeString,
+			fields.WithHelp("Message-ID of the message this one replies to"),
+		),
+		fields.New(
+			"references",
+			fields.TypeStringList,
+			fields.WithHelp("Message-IDs of the thread, oldest first (default: --in-reply-to)"),
+		),
+	}
+}
+
+// messageHeaders are the header fields of a message built by the store
+// commands.
+type messageHeaders struct {
+	From       string
+	To         string
+	Cc         string
+	Subject    string
+	MessageID  string
+	InReplyTo  string
+	References []string
+}
+
+// header builds the mail header. A Message-ID is generated if none is set,
+// and written back to m.MessageID.
+func (m *messageHeaders) header() (mail.Header, error) {
+	h := mail.Header{}
+	h.SetDate(time.Now())
+	if err := mailutil.SetAddressList(&h, "From", m.From); err != nil {
+		return h, err
+	}
+	if err := mailutil.SetAddressList(&h, "To", m.To); err != nil {
+		return h, err
+	}
+	if err := mailutil.SetAddressList(&h, "Cc", m.Cc); err != nil {
+		return h, err
+	}
+	h.SetSubject(m.Subject)
+
+	if err := mailutil.SetThreadHeaders(&h, m.MessageID, m.InReplyTo, m.References); err != nil {
+		return h, err
+	}
+	if m.MessageID == "" {
+		if err := h.GenerateMessageID(); err != nil {
+			return h, err
+		}
+	}
+	id, err := h.MessageID()
+	if err != nil {
+		return h, err
+	}
+	m.MessageID = "<" + id + ">"
+	return h, nil
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+)
+
+// rawMessageFields are the --from-file and --stdin flags of the store
+// commands, which replace the generated message with a pre-built one.
+func rawMessageFields() []*fields.Definition {
+	return []*fields.Definition{
+		fields.New(
+			"from-file",
+			fields.TypeString,
+			fields.WithHelp("Store this .eml file instead of building a message"),
+		),
+		fields.New(
+			"stdin",
+			fields.TypeBool,
+			fields.WithHelp("Store the message read from stdin instead of building one"),
+			fields.WithDefault(false),
+		),
+	}
+}
+
+// rawMessage is a pre-built message with the header fields shown in the
+// command output.
+type rawMessage struct {
+	Data      []byte
+	From      string
+	To        string
+	Cc        string
+	Subject   string
+	MessageID string
+	// Date is the Date header, zero if missing or invalid
+	Date time.Time
+}
+
+// readRawMessage reads the message given by --from-file or --stdin. It
+// returns nil if neither is set.
+func readRawMessage(fromFile string, stdin bool) (*rawMessage, error) {
+	var data []byte
+	var err error
+	switch {
+	case fromFile != "" && stdin:
+		return nil, fmt.Errorf("--from-file and --stdin cannot be combined")
+	case fromFile != "":
+		// #nosec G304 -- the CLI intentionally accepts user-specified message paths.
+		data, err = os.ReadFile(fromFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message file: %w", err)
+		}
+	case stdin:
+		data, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error reading message from stdin: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+	return parseRawMessage(data)
+}
+
+func parseRawMessage(data []byte) (*rawMessage, error) {
+	// IMAP requires CRLF line endings, .eml files often have bare LFs
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing message header: %w", err)
+	}
+	if header.Len() == 0 {
+		return nil, fmt.Errorf("message has no header")
+	}
+
+	h := mail.Header{Header: message.Header{Header: header}}
+	msg := &rawMessage{Data: data}
+	msg.From = h.Get("From")
+	msg.To = h.Get("To")
+	msg.Cc = h.Get("Cc")
+	msg.MessageID = h.Get("Message-Id")
+	if msg.Subject, err = h.Subject(); err != nil {
+		msg.Subject = h.Get("Subject")
+	}
+	if date, err := h.Date(); err == nil {
+		msg.Date = date
+	}
+	return msg, nil
+}
+
+// date returns the internal date to store the message with: the Date
+// header, or now.
+func (m *rawMessage) date() time.Time {
+	if m.Date.IsZero() {
+		return time.Now()
+	}
+	return m.Date
+}
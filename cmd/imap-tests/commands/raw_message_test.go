@@ -0,0 +1,79 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rawMessageLF = "From: Alice <alice@example.com>\n" +
+	"To: bob@example.com\n" +
+	"Subject: =?UTF-8?Q?Caf=C3=A9?=\n" +
+	"Date: Mon, 03 Mar 2025 09:00:00 +0000\n" +
+	"Message-ID: <raw@example.com>\n" +
+	"\n" +
+	"Hello.\n"
+
+func TestReadRawMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		stdin   bool
+		wantErr string
+	}{
+		{name: "file", input: rawMessageLF},
+		{name: "stdin", input: rawMessageLF, stdin: true},
+		{name: "empty file", input: "", wantErr: "message has no header"},
+		{name: "empty stdin", input: "", stdin: true, wantErr: "message has no header"},
+		{name: "malformed file", input: "not a header\n\nbody\n", wantErr: "error parsing message header"},
+		{name: "malformed stdin", input: "not a header\n\nbody\n", stdin: true, wantErr: "error parsing message header"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "message.eml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.input), 0o600))
+			fromFile := path
+			if tt.stdin {
+				f, err := os.Open(path)
+				require.NoError(t, err)
+				stdin := os.Stdin
+				os.Stdin = f
+				t.Cleanup(func() {
+					os.Stdin = stdin
+					_ = f.Close()
+				})
+				fromFile = ""
+			}
+
+			msg, err := readRawMessage(fromFile, tt.stdin)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "Alice <alice@example.com>", msg.From)
+			assert.Equal(t, "bob@example.com", msg.To)
+			assert.Equal(t, "Café", msg.Subject)
+			assert.Equal(t, "<raw@example.com>", msg.MessageID)
+			assert.True(t, msg.Date.Equal(time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)))
+			assert.Contains(t, string(msg.Data), "Message-ID: <raw@example.com>\r\n\r\nHello.\r\n", "bare LFs become CRLF")
+			assert.NotContains(t, string(msg.Data), "\r\r\n")
+		})
+	}
+}
+
+func TestReadRawMessageArguments(t *testing.T) {
+	msg, err := readRawMessage("", false)
+	require.NoError(t, err)
+	assert.Nil(t, msg, "neither flag set")
+
+	_, err = readRawMessage("message.eml", true)
+	assert.ErrorContains(t, err, "cannot be combined")
+
+	_, err = readRawMessage(filepath.Join(t.TempDir(), "missing.eml"), false)
+	assert.ErrorContains(t, err, "error reading message file")
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+// skipExistingField is the --skip-existing flag of the store commands, which
+// makes seeding scripts safe to rerun.
+func skipExistingField() *fields.Definition {
+	return fields.New(
+		"skip-existing",
+		fields.TypeBool,
+		fields.WithHelp("Do not store the message if the mailbox already has one with its Message-ID (pass --message-id to make reruns idempotent)"),
+		fields.WithDefault(false),
+	)
+}
+
+// storeNewMessage stores the message like storeMessage, unless skipExisting
+// is set and mailbox already holds a message with messageID. It returns the
+// status of the output row: "success" or "skipped".
+func storeNewMessage(client *imapclient.Client, mailbox, messageID string,
+	messageData []byte, flags []imap.Flag, date time.Time, skipExisting bool) (string, error) {
+	if skipExisting {
+		exists, err := smailnail_imap.MessageIDExists(client, mailbox, messageID)
+		if err != nil {
+			return "", fmt.Errorf("error checking for an existing message: %w", err)
+		}
+		if exists {
+			return "skipped", nil
+		}
+	}
+	if err := storeMessage(client, mailbox, messageData, flags, date); err != nil {
+		return "", fmt.Errorf("error storing message: %w", err)
+	}
+	return "success", nil
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+// The fixture is hand-written: INBOX holds <a@example.com>, so it is
+// skipped, <b@example.com> is appended, and without --skip-existing
+// <a@example.com> is appended again.
+const skipExistingFixture = `S: * OK [CAPABILITY IMAP4rev1 AUTH=PLAIN] IMAP server ready
+C: T1 LOGIN <redacted>
+S: T1 OK [CAPABILITY IMAP4rev1] Logged in
+C: T2 SELECT INBOX
+S: * 1 EXISTS
+S: * OK [UIDVALIDITY 1] UIDs valid
+S: * FLAGS ()
+S: T2 OK [READ-WRITE] SELECT completed
+C: T3 UID SEARCH HEADER "Message-ID" "<a@example.com>"
+S: * SEARCH 1
+S: T3 OK SEARCH completed
+C: T4 UID SEARCH HEADER "Message-ID" "<b@example.com>"
+S: * SEARCH
+S: T4 OK SEARCH completed
+C: T5 APPEND INBOX " 2-Jan-2006 15:04:05 +0000" {35}
+S: + Ready for literal data
+C: Message-ID: <b@example.com>
+C: 
+C: hi
+C: 
+S: T5 OK [APPENDUID 1 2] APPEND completed
+C: T6 APPEND INBOX " 2-Jan-2006 15:04:05 +0000" {35}
+S: + Ready for literal data
+C: Message-ID: <a@example.com>
+C: 
+C: hi
+C: 
+S: T6 OK [APPENDUID 1 3] APPEND completed
+C: T7 LOGOUT
+S: * BYE Logging out
+S: T7 OK LOGOUT completed
+`
+
+func TestStoreNewMessage(t *testing.T) {
+	fixture, err := smailnail_imap.ParseFixture(strings.NewReader(skipExistingFixture))
+	require.NoError(t, err)
+	conn, done := fixture.Pipe()
+	client := imapclient.New(conn, nil)
+	defer func() { _ = client.Close() }()
+	require.NoError(t, client.Login("user", "password").Wait())
+	date := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	status, err := storeNewMessage(client, "INBOX", "<a@example.com>", []byte("Message-ID: <a@example.com>\r\n\r\nhi\r\n"), nil, date, true)
+	require.NoError(t, err)
+	assert.Equal(t, "skipped", status)
+
+	status, err = storeNewMessage(client, "INBOX", "<b@example.com>", []byte("Message-ID: <b@example.com>\r\n\r\nhi\r\n"), nil, date, true)
+	require.NoError(t, err)
+	assert.Equal(t, "success", status)
+
+	status, err = storeNewMessage(client, "INBOX", "<a@example.com>", []byte("Message-ID: <a@example.com>\r\n\r\nhi\r\n"), nil, date, false)
+	require.NoError(t, err)
+	assert.Equal(t, "success", status)
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
@@ -18,7 +18,6 @@ import (
 	"github.com/go-go-golems/glazed/pkg/settings"
 	"github.com/go-go-golems/glazed/pkg/types"
 	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
-	"github.com/go-go-golems/smailnail/pkg/mailutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,10 +28,20 @@ type StoreAttachmentCommand struct {
 type StoreAttachmentSettings struct {
 	From           string `glazed:"from"`
 	To             string `glazed:"to"`
+	Cc             string `glazed:"cc"`
 	Subject        string `glazed:"subject"`
 	Body           string `glazed:"body"`
 	AttachmentPath string `glazed:"attachment-path"`
 
+	// Threading
+	MessageID  string   `glazed:"message-id"`
+	InReplyTo  string   `glazed:"in-reply-to"`
+	References []string `glazed:"references"`
+
+	// Pre-built message input
+	FromFile string `glazed:"from-file"`
+	Stdin    bool   `glazed:"stdin"`
+
 	// IMAP flags
 	Seen     bool `glazed:"seen"`
 	Flagged  bool `glazed:"flagged"`
@@ -40,6 +49,9 @@ type StoreAttachmentSettings struct {
 	Draft    bool `glazed:"draft"`
 	Deleted  bool `glazed:"deleted"`
 
+	// Skip messages whose Message-ID is already in the mailbox
+	SkipExisting bool `glazed:"skip-existing"`
+
 	// IMAP settings
 	smailnail_imap.IMAPSettings
 }
@@ -64,14 +76,17 @@ func NewStoreAttachmentCommand() (*StoreAttachmentCommand, error) {
 				fields.New(
 					"from",
 					fields.TypeString,
-					fields.WithHelp("Sender email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Sender address, e.g. \"Alice <alice@example.com>\" (required unless --from-file or --stdin is given)"),
 				),
 				fields.New(
 					"to",
 					fields.TypeString,
-					fields.WithHelp("Recipient email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Comma-separated recipient addresses (required unless --from-file or --stdin is given)"),
+				),
+				fields.New(
+					"cc",
+					fields.TypeString,
+					fields.WithHelp("Comma-separated Cc addresses"),
 				),
 				fields.New(
 					"subject",
@@ -88,8 +103,7 @@ func NewStoreAttachmentCommand() (*StoreAttachmentCommand, error) {
 				fields.New(
 					"attachment-path",
 					fields.TypeString,
-					fields.WithHelp("Path to the file to attach"),
-					fields.WithRequired(true),
+					fields.WithHelp("Path to the file to attach (required unless --from-file or --stdin is given)"),
 				),
 				// IMAP flags
 				fields.New(
@@ -123,6 +137,9 @@ func NewStoreAttachmentCommand() (*StoreAttachmentCommand, error) {
 					fields.WithDefault(false),
 				),
 			),
+			cmds.WithFlags(threadFields()...),
+			cmds.WithFlags(rawMessageFields()...),
+			cmds.WithFlags(skipExistingField()),
 			cmds.WithSections(
 				glazedSection,
 				imapSection,
@@ -140,7 +157,7 @@ func (c *StoreAttachmentCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(smailnail_imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := smailnail_imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -149,37 +166,22 @@ func (c *StoreAttachmentCommand) RunIntoGlazeProcessor(
 		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
 	}
 
-	// Check if attachment file exists
-	if _, err := os.Stat(settings.AttachmentPath); os.IsNotExist(err) {
-		return fmt.Errorf("attachment file does not exist: %s", settings.AttachmentPath)
-	}
-
-	// Read attachment file
-	fileContent, err := os.ReadFile(settings.AttachmentPath)
+	raw, err := readRawMessage(settings.FromFile, settings.Stdin)
 	if err != nil {
-		return fmt.Errorf("error reading attachment file: %w", err)
+		return err
+	}
+	if raw == nil && (settings.From == "" || settings.To == "" || settings.AttachmentPath == "") {
+		return fmt.Errorf("--from, --to and --attachment-path are required unless --from-file or --stdin is given")
 	}
 
-	// Determine content type (simplistic implementation)
-	contentType := "application/octet-stream"
-	ext := filepath.Ext(settings.AttachmentPath)
-	switch ext {
-	case ".txt":
-		contentType = "text/plain"
-	case ".pdf":
-		contentType = "application/pdf"
-	case ".jpg", ".jpeg":
-		contentType = "image/jpeg"
-	case ".png":
-		contentType = "image/png"
-	case ".gif":
-		contentType = "image/gif"
-	case ".doc", ".docx":
-		contentType = "application/msword"
-	case ".xls", ".xlsx":
-		contentType = "application/vnd.ms-excel"
-	case ".zip":
-		contentType = "application/zip"
+	var fileContent []byte
+	var contentType, attachmentName string
+	if raw == nil {
+		fileContent, contentType, err = readAttachment(settings.AttachmentPath)
+		if err != nil {
+			return err
+		}
+		attachmentName = filepath.Base(settings.AttachmentPath)
 	}
 
 	// Connect to IMAP server
@@ -192,18 +194,34 @@ func (c *StoreAttachmentCommand) RunIntoGlazeProcessor(
 		_ = client.Close()
 	}()
 
-	// Create the message
-	messageData, err := createMessageWithAttachment(
-		settings.From,
-		settings.To,
-		settings.Subject,
-		settings.Body,
-		filepath.Base(settings.AttachmentPath),
-		fileContent,
-		contentType,
-	)
-	if err != nil {
-		return fmt.Errorf("error creating message: %w", err)
+	// Create the message, or store the pre-built one as is
+	var messageData []byte
+	date := time.Now()
+	if raw != nil {
+		messageData, date = raw.Data, raw.date()
+		settings.From, settings.To, settings.Cc, settings.Subject, settings.Body = raw.From, raw.To, raw.Cc, raw.Subject, ""
+		settings.MessageID = raw.MessageID
+	} else {
+		headers := &messageHeaders{
+			From:       settings.From,
+			To:         settings.To,
+			Cc:         settings.Cc,
+			Subject:    settings.Subject,
+			MessageID:  settings.MessageID,
+			InReplyTo:  settings.InReplyTo,
+			References: settings.References,
+		}
+		messageData, err = createMessageWithAttachment(
+			headers,
+			settings.Body,
+			attachmentName,
+			fileContent,
+			contentType,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating message: %w", err)
+		}
+		settings.MessageID = headers.MessageID
 	}
 
 	// Prepare flags
@@ -225,21 +243,23 @@ func (c *StoreAttachmentCommand) RunIntoGlazeProcessor(
 	}
 
 	// Store the message
-	err = storeMessage(client, settings.Mailbox, messageData, flags, time.Now())
+	status, err := storeNewMessage(client, settings.Mailbox, settings.MessageID, messageData, flags, date, settings.SkipExisting)
 	if err != nil {
-		return fmt.Errorf("error storing message: %w", err)
+		return err
 	}
 
 	// Output success information
 	row := types.NewRow(
-		types.MRP("status", "success"),
+		types.MRP("status", status),
 		types.MRP("server", settings.Server),
 		types.MRP("mailbox", settings.Mailbox),
 		types.MRP("from", settings.From),
 		types.MRP("to", settings.To),
+		types.MRP("cc", settings.Cc),
+		types.MRP("message_id", settings.MessageID),
 		types.MRP("subject", settings.Subject),
 		types.MRP("body_length", len(settings.Body)),
-		types.MRP("attachment", filepath.Base(settings.AttachmentPath)),
+		types.MRP("attachment", attachmentName),
 		types.MRP("attachment_size", len(fileContent)),
 		types.MRP("attachment_type", contentType),
 		types.MRP("message_size", len(messageData)),
@@ -254,22 +274,55 @@ func (c *StoreAttachmentCommand) RunIntoGlazeProcessor(
 	return nil
 }
 
+// readAttachment reads the file to attach and guesses its content type.
+func readAttachment(path string) ([]byte, string, error) {
+	// Check if attachment file exists
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, "", fmt.Errorf("attachment file does not exist: %s", path)
+	}
+
+	// Read attachment file
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading attachment file: %w", err)
+	}
+
+	// Determine content type (simplistic implementation)
+	contentType := "application/octet-stream"
+	ext := filepath.Ext(path)
+	switch ext {
+	case ".txt":
+		contentType = "text/plain"
+	case ".pdf":
+		contentType = "application/pdf"
+	case ".jpg", ".jpeg":
+		contentType = "image/jpeg"
+	case ".png":
+		contentType = "image/png"
+	case ".gif":
+		contentType = "image/gif"
+	case ".doc", ".docx":
+		contentType = "application/msword"
+	case ".xls", ".xlsx":
+		contentType = "application/vnd.ms-excel"
+	case ".zip":
+		contentType = "application/zip"
+	}
+
+	return fileContent, contentType, nil
+}
+
 // Helper function
-func createMessageWithAttachment(from, to, subject, body string,
+func createMessageWithAttachment(headers *messageHeaders, body string,
 	filename string, fileContent []byte, contentType string) ([]byte, error) {
 
 	var buf bytes.Buffer
 
 	// Create the mail header
-	h := mail.Header{}
-	h.SetDate(time.Now())
-	if err := mailutil.SetSingleAddress(&h, "From", from); err != nil {
-		return nil, err
-	}
-	if err := mailutil.SetSingleAddress(&h, "To", to); err != nil {
+	h, err := headers.header()
+	if err != nil {
 		return nil, err
 	}
-	h.SetSubject(subject)
 
 	// Create the multipart message
 	mw, err := mail.CreateWriter(&buf, h)
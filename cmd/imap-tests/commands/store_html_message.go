@@ -16,7 +16,6 @@ import (
 	"github.com/go-go-golems/glazed/pkg/settings"
 	"github.com/go-go-golems/glazed/pkg/types"
 	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
-	"github.com/go-go-golems/smailnail/pkg/mailutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -27,10 +26,20 @@ type StoreHTMLMessageCommand struct {
 type StoreHTMLMessageSettings struct {
 	From     string `glazed:"from"`
 	To       string `glazed:"to"`
+	Cc       string `glazed:"cc"`
 	Subject  string `glazed:"subject"`
 	TextBody string `glazed:"text-body"`
 	HTMLBody string `glazed:"html-body"`
 
+	// Threading
+	MessageID  string   `glazed:"message-id"`
+	InReplyTo  string   `glazed:"in-reply-to"`
+	References []string `glazed:"references"`
+
+	// Pre-built message input
+	FromFile string `glazed:"from-file"`
+	Stdin    bool   `glazed:"stdin"`
+
 	// IMAP flags
 	Seen     bool `glazed:"seen"`
 	Flagged  bool `glazed:"flagged"`
@@ -38,6 +47,9 @@ type StoreHTMLMessageSettings struct {
 	Draft    bool `glazed:"draft"`
 	Deleted  bool `glazed:"deleted"`
 
+	// Skip messages whose Message-ID is already in the mailbox
+	SkipExisting bool `glazed:"skip-existing"`
+
 	// IMAP settings
 	smailnail_imap.IMAPSettings
 }
@@ -62,14 +74,17 @@ func NewStoreHTMLMessageCommand() (*StoreHTMLMessageCommand, error) {
 				fields.New(
 					"from",
 					fields.TypeString,
-					fields.WithHelp("Sender email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Sender address, e.g. \"Alice <alice@example.com>\" (required unless --from-file or --stdin is given)"),
 				),
 				fields.New(
 					"to",
 					fields.TypeString,
-					fields.WithHelp("Recipient email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Comma-separated recipient addresses (required unless --from-file or --stdin is given)"),
+				),
+				fields.New(
+					"cc",
+					fields.TypeString,
+					fields.WithHelp("Comma-separated Cc addresses"),
 				),
 				fields.New(
 					"subject",
@@ -121,6 +136,9 @@ func NewStoreHTMLMessageCommand() (*StoreHTMLMessageCommand, error) {
 					fields.WithDefault(false),
 				),
 			),
+			cmds.WithFlags(threadFields()...),
+			cmds.WithFlags(rawMessageFields()...),
+			cmds.WithFlags(skipExistingField()),
 			cmds.WithSections(
 				glazedSection,
 				imapSection,
@@ -138,7 +156,7 @@ func (c *StoreHTMLMessageCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(smailnail_imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := smailnail_imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -147,6 +165,14 @@ func (c *StoreHTMLMessageCommand) RunIntoGlazeProcessor(
 		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
 	}
 
+	raw, err := readRawMessage(settings.FromFile, settings.Stdin)
+	if err != nil {
+		return err
+	}
+	if raw == nil && (settings.From == "" || settings.To == "") {
+		return fmt.Errorf("--from and --to are required unless --from-file or --stdin is given")
+	}
+
 	// Connect to IMAP server
 	log.Debug().Msg("Connecting to IMAP server")
 	client, err := settings.ConnectToIMAPServer()
@@ -157,16 +183,33 @@ func (c *StoreHTMLMessageCommand) RunIntoGlazeProcessor(
 		_ = client.Close()
 	}()
 
-	// Create the message
-	messageData, err := createHTMLMessage(
-		settings.From,
-		settings.To,
-		settings.Subject,
-		settings.TextBody,
-		settings.HTMLBody,
-	)
-	if err != nil {
-		return fmt.Errorf("error creating message: %w", err)
+	// Create the message, or store the pre-built one as is
+	var messageData []byte
+	date := time.Now()
+	if raw != nil {
+		messageData, date = raw.Data, raw.date()
+		settings.From, settings.To, settings.Cc, settings.Subject = raw.From, raw.To, raw.Cc, raw.Subject
+		settings.MessageID = raw.MessageID
+		settings.TextBody, settings.HTMLBody = "", ""
+	} else {
+		headers := &messageHeaders{
+			From:       settings.From,
+			To:         settings.To,
+			Cc:         settings.Cc,
+			Subject:    settings.Subject,
+			MessageID:  settings.MessageID,
+			InReplyTo:  settings.InReplyTo,
+			References: settings.References,
+		}
+		messageData, err = createHTMLMessage(
+			headers,
+			settings.TextBody,
+			settings.HTMLBody,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating message: %w", err)
+		}
+		settings.MessageID = headers.MessageID
 	}
 
 	// Prepare flags
@@ -188,18 +231,20 @@ func (c *StoreHTMLMessageCommand) RunIntoGlazeProcessor(
 	}
 
 	// Store the message
-	err = storeMessage(client, settings.Mailbox, messageData, flags, time.Now())
+	status, err := storeNewMessage(client, settings.Mailbox, settings.MessageID, messageData, flags, date, settings.SkipExisting)
 	if err != nil {
-		return fmt.Errorf("error storing message: %w", err)
+		return err
 	}
 
 	// Output success information
 	row := types.NewRow(
-		types.MRP("status", "success"),
+		types.MRP("status", status),
 		types.MRP("server", settings.Server),
 		types.MRP("mailbox", settings.Mailbox),
 		types.MRP("from", settings.From),
 		types.MRP("to", settings.To),
+		types.MRP("cc", settings.Cc),
+		types.MRP("message_id", settings.MessageID),
 		types.MRP("subject", settings.Subject),
 		types.MRP("text_body_length", len(settings.TextBody)),
 		types.MRP("html_body_length", len(settings.HTMLBody)),
@@ -216,19 +261,14 @@ func (c *StoreHTMLMessageCommand) RunIntoGlazeProcessor(
 }
 
 // Helper function
-func createHTMLMessage(from, to, subject, textBody, htmlBody string) ([]byte, error) {
+func createHTMLMessage(headers *messageHeaders, textBody, htmlBody string) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Create a new mail message
-	h := mail.Header{}
-	h.SetDate(time.Now())
-	if err := mailutil.SetSingleAddress(&h, "From", from); err != nil {
-		return nil, err
-	}
-	if err := mailutil.SetSingleAddress(&h, "To", to); err != nil {
+	h, err := headers.header()
+	if err != nil {
 		return nil, err
 	}
-	h.SetSubject(subject)
 
 	// Create a multipart message with alternatives
 	mw, err := mail.CreateWriter(&buf, h)
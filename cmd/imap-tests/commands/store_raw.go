@@ -0,0 +1,211 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/rs/zerolog/log"
+)
+
+type StoreRawCommand struct {
+	*cmds.CommandDescription
+}
+
+type StoreRawSettings struct {
+	FromFile string `glazed:"from-file"`
+	Stdin    bool   `glazed:"stdin"`
+	Date     string `glazed:"date"`
+
+	// IMAP flags
+	Seen     bool `glazed:"seen"`
+	Flagged  bool `glazed:"flagged"`
+	Answered bool `glazed:"answered"`
+	Draft    bool `glazed:"draft"`
+	Deleted  bool `glazed:"deleted"`
+
+	// Skip messages whose Message-ID is already in the mailbox
+	SkipExisting bool `glazed:"skip-existing"`
+
+	// IMAP settings
+	smailnail_imap.IMAPSettings
+}
+
+func NewStoreRawCommand() (*StoreRawCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := smailnail_imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &StoreRawCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"store-raw",
+			cmds.WithShort("Store a pre-built message in an IMAP mailbox"),
+			cmds.WithLong(`This command stores a message read from an .eml file (--from-file) or stdin
+(--stdin) unchanged in an IMAP mailbox. Bare LF line endings are converted to
+CRLF. The internal date is taken from --date, or else from the message's Date
+header.`),
+			cmds.WithFlags(rawMessageFields()...),
+			cmds.WithFlags(skipExistingField()),
+			cmds.WithFlags(
+				fields.New(
+					"date",
+					fields.TypeString,
+					fields.WithHelp("Internal date (RFC3339 or YYYY-MM-DD, default: the Date header or now)"),
+				),
+				// IMAP flags
+				fields.New(
+					"seen",
+					fields.TypeBool,
+					fields.WithHelp("Mark message as seen"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"flagged",
+					fields.TypeBool,
+					fields.WithHelp("Mark message as flagged"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"answered",
+					fields.TypeBool,
+					fields.WithHelp("Mark message as answered"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"draft",
+					fields.TypeBool,
+					fields.WithHelp("Mark message as draft"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"deleted",
+					fields.TypeBool,
+					fields.WithHelp("Mark message as deleted"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(
+				glazedSection,
+				imapSection,
+			),
+		),
+	}, nil
+}
+
+func (c *StoreRawCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &StoreRawSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := smailnail_imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	// Check if password is provided
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	raw, err := readRawMessage(settings.FromFile, settings.Stdin)
+	if err != nil {
+		return err
+	}
+	if raw == nil {
+		return fmt.Errorf("either --from-file or --stdin is required")
+	}
+
+	date := raw.date()
+	if settings.Date != "" {
+		date, err = parseInternalDate(settings.Date)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Connect to IMAP server
+	log.Debug().Msg("Connecting to IMAP server")
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// Prepare flags
+	var flags []imap.Flag
+	if settings.Seen {
+		flags = append(flags, imap.FlagSeen)
+	}
+	if settings.Flagged {
+		flags = append(flags, imap.FlagFlagged)
+	}
+	if settings.Answered {
+		flags = append(flags, imap.FlagAnswered)
+	}
+	if settings.Draft {
+		flags = append(flags, imap.FlagDraft)
+	}
+	if settings.Deleted {
+		flags = append(flags, imap.FlagDeleted)
+	}
+
+	// Store the message
+	status, err := storeNewMessage(client, settings.Mailbox, raw.MessageID, raw.Data, flags, date, settings.SkipExisting)
+	if err != nil {
+		return err
+	}
+
+	// Output success information
+	row := types.NewRow(
+		types.MRP("status", status),
+		types.MRP("server", settings.Server),
+		types.MRP("mailbox", settings.Mailbox),
+		types.MRP("from", raw.From),
+		types.MRP("to", raw.To),
+		types.MRP("cc", raw.Cc),
+		types.MRP("message_id", raw.MessageID),
+		types.MRP("subject", raw.Subject),
+		types.MRP("message_size", len(raw.Data)),
+		types.MRP("flags", flags),
+		types.MRP("internal_date", date.Format(time.RFC3339)),
+		types.MRP("timestamp", time.Now().Format(time.RFC3339)),
+	)
+
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to output: %w", err)
+	}
+
+	return nil
+}
+
+// parseInternalDate parses --date as RFC3339 or a YYYY-MM-DD date.
+func parseInternalDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --date %q (use RFC3339 or YYYY-MM-DD)", value)
+	}
+	return t, nil
+}
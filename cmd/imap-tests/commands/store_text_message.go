@@ -17,7 +17,6 @@ import (
 	"github.com/go-go-golems/glazed/pkg/settings"
 	"github.com/go-go-golems/glazed/pkg/types"
 	smailnail_imap "github.com/go-go-golems/smailnail/pkg/imap"
-	"github.com/go-go-golems/smailnail/pkg/mailutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -28,9 +27,19 @@ type StoreTextMessageCommand struct {
 type StoreTextMessageSettings struct {
 	From    string `glazed:"from"`
 	To      string `glazed:"to"`
+	Cc      string `glazed:"cc"`
 	Subject string `glazed:"subject"`
 	Body    string `glazed:"body"`
 
+	// Threading
+	MessageID  string   `glazed:"message-id"`
+	InReplyTo  string   `glazed:"in-reply-to"`
+	References []string `glazed:"references"`
+
+	// Pre-built message input
+	FromFile string `glazed:"from-file"`
+	Stdin    bool   `glazed:"stdin"`
+
 	// IMAP flags
 	Seen     bool `glazed:"seen"`
 	Flagged  bool `glazed:"flagged"`
@@ -38,6 +47,9 @@ type StoreTextMessageSettings struct {
 	Draft    bool `glazed:"draft"`
 	Deleted  bool `glazed:"deleted"`
 
+	// Skip messages whose Message-ID is already in the mailbox
+	SkipExisting bool `glazed:"skip-existing"`
+
 	// IMAP settings
 	smailnail_imap.IMAPSettings
 }
@@ -62,14 +74,17 @@ func NewStoreTextMessageCommand() (*StoreTextMessageCommand, error) {
 				fields.New(
 					"from",
 					fields.TypeString,
-					fields.WithHelp("Sender email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Sender address, e.g. \"Alice <alice@example.com>\" (required unless --from-file or --stdin is given)"),
 				),
 				fields.New(
 					"to",
 					fields.TypeString,
-					fields.WithHelp("Recipient email address"),
-					fields.WithRequired(true),
+					fields.WithHelp("Comma-separated recipient addresses (required unless --from-file or --stdin is given)"),
+				),
+				fields.New(
+					"cc",
+					fields.TypeString,
+					fields.WithHelp("Comma-separated Cc addresses"),
 				),
 				fields.New(
 					"subject",
@@ -115,6 +130,9 @@ func NewStoreTextMessageCommand() (*StoreTextMessageCommand, error) {
 					fields.WithDefault(false),
 				),
 			),
+			cmds.WithFlags(threadFields()...),
+			cmds.WithFlags(rawMessageFields()...),
+			cmds.WithFlags(skipExistingField()),
 			cmds.WithSections(
 				glazedSection,
 				imapSection,
@@ -132,7 +150,7 @@ func (c *StoreTextMessageCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(smailnail_imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := smailnail_imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -141,6 +159,14 @@ func (c *StoreTextMessageCommand) RunIntoGlazeProcessor(
 		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
 	}
 
+	raw, err := readRawMessage(settings.FromFile, settings.Stdin)
+	if err != nil {
+		return err
+	}
+	if raw == nil && (settings.From == "" || settings.To == "") {
+		return fmt.Errorf("--from and --to are required unless --from-file or --stdin is given")
+	}
+
 	// Connect to IMAP server
 	log.Debug().Msg("Connecting to IMAP server")
 	client, err := settings.ConnectToIMAPServer()
@@ -151,10 +177,28 @@ func (c *StoreTextMessageCommand) RunIntoGlazeProcessor(
 		_ = client.Close()
 	}()
 
-	// Create the message
-	messageData, err := createTextMessage(settings.From, settings.To, settings.Subject, settings.Body)
-	if err != nil {
-		return fmt.Errorf("error creating message: %w", err)
+	// Create the message, or store the pre-built one as is
+	var messageData []byte
+	date := time.Now()
+	if raw != nil {
+		messageData, date = raw.Data, raw.date()
+		settings.From, settings.To, settings.Cc, settings.Subject, settings.Body = raw.From, raw.To, raw.Cc, raw.Subject, ""
+		settings.MessageID = raw.MessageID
+	} else {
+		headers := &messageHeaders{
+			From:       settings.From,
+			To:         settings.To,
+			Cc:         settings.Cc,
+			Subject:    settings.Subject,
+			MessageID:  settings.MessageID,
+			InReplyTo:  settings.InReplyTo,
+			References: settings.References,
+		}
+		messageData, err = createTextMessage(headers, settings.Body)
+		if err != nil {
+			return fmt.Errorf("error creating message: %w", err)
+		}
+		settings.MessageID = headers.MessageID
 	}
 
 	// Prepare flags
@@ -176,18 +220,20 @@ func (c *StoreTextMessageCommand) RunIntoGlazeProcessor(
 	}
 
 	// Store the message
-	err = storeMessage(client, settings.Mailbox, messageData, flags, time.Now())
+	status, err := storeNewMessage(client, settings.Mailbox, settings.MessageID, messageData, flags, date, settings.SkipExisting)
 	if err != nil {
-		return fmt.Errorf("error storing message: %w", err)
+		return err
 	}
 
 	// Output success information
 	row := types.NewRow(
-		types.MRP("status", "success"),
+		types.MRP("status", status),
 		types.MRP("server", settings.Server),
 		types.MRP("mailbox", settings.Mailbox),
 		types.MRP("from", settings.From),
 		types.MRP("to", settings.To),
+		types.MRP("cc", settings.Cc),
+		types.MRP("message_id", settings.MessageID),
 		types.MRP("subject", settings.Subject),
 		types.MRP("body_length", len(settings.Body)),
 		types.MRP("message_size", len(messageData)),
@@ -203,19 +249,14 @@ func (c *StoreTextMessageCommand) RunIntoGlazeProcessor(
 }
 
 // Helper functions
-func createTextMessage(from, to, subject, body string) ([]byte, error) {
+func createTextMessage(headers *messageHeaders, body string) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Create a new mail message
-	h := mail.Header{}
-	h.SetDate(time.Now())
-	if err := mailutil.SetSingleAddress(&h, "From", from); err != nil {
-		return nil, err
-	}
-	if err := mailutil.SetSingleAddress(&h, "To", to); err != nil {
+	h, err := headers.header()
+	if err != nil {
 		return nil, err
 	}
-	h.SetSubject(subject)
 
 	// Create a message writer
 	w, err := mail.CreateSingleInlineWriter(&buf, h)
@@ -258,7 +299,8 @@ func storeMessage(client *imapclient.Client, mailbox string,
 		return err
 	}
 
-	// Wait for the command to complete
+	// Wait for the command to complete; an over-quota refusal explains how
+	// to make room
 	_, err := cmd.Wait()
-	return err
+	return smailnail_imap.WrapAppendError(err, mailbox)
 }
@@ -57,6 +57,11 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to create storeAttachment command")
 	}
 
+	storeRawCmd, err := commands.NewStoreRawCommand()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create storeRaw command")
+	}
+
 	// Convert glazed commands to cobra commands
 	createMailboxCobraCmd, err := cli.BuildCobraCommandFromCommand(createMailboxCmd,
 		cli.WithParserConfig(cli.CobraParserConfig{
@@ -94,12 +99,22 @@ func main() {
 		log.Fatal().Err(err).Msg("Failed to build storeAttachment cobra command")
 	}
 
+	storeRawCobraCmd, err := cli.BuildCobraCommandFromCommand(storeRawCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to build storeRaw cobra command")
+	}
+
 	// Add commands to root
 	rootCmd.AddCommand(
 		createMailboxCobraCmd,
 		storeTextMessageCobraCmd,
 		storeHTMLMessageCobraCmd,
 		storeAttachmentCobraCmd,
+		storeRawCobraCmd,
 	)
 
 	// Execute
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
@@ -75,6 +76,19 @@ func NewGenerateCommand() (*GenerateCommand, error) {
 					fields.WithHelp("Store generated emails in IMAP server"),
 					fields.WithDefault(false),
 				),
+				fields.New(
+					"quota-check",
+					fields.TypeChoice,
+					fields.WithHelp("With --store-imap, compare the size of the emails to the mailbox quota first and warn or abort when they will not fit"),
+					fields.WithChoices(smailnail_imap.QuotaCheckOff, smailnail_imap.QuotaCheckWarn, smailnail_imap.QuotaCheckAbort),
+					fields.WithDefault(smailnail_imap.QuotaCheckWarn),
+				),
+				fields.New(
+					"skip-existing",
+					fields.TypeBool,
+					fields.WithHelp("With --store-imap, skip emails whose Message-ID is already in the mailbox, so reruns do not store duplicates"),
+					fields.WithDefault(false),
+				),
 			),
 		),
 	}, nil
@@ -85,6 +99,10 @@ type GenerateSettings struct {
 	OutputDir  string   `glazed:"output-dir"`
 	WriteFiles bool     `glazed:"write-files"`
 	StoreIMAP  bool     `glazed:"store-imap"`
+	// QuotaCheck is off, warn or abort
+	QuotaCheck string `glazed:"quota-check"`
+	// SkipExisting skips emails whose Message-ID is already stored
+	SkipExisting bool `glazed:"skip-existing"`
 	smailnail_imap.IMAPSettings
 }
 
@@ -99,7 +117,7 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(smailnail_imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := smailnail_imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -122,6 +140,9 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 		if err := yaml.Unmarshal(configData, &config); err != nil {
 			return errors.Wrapf(err, "failed to parse config file '%s'", configFile)
 		}
+		if err := mailgen.LoadCorpus(&config, filepath.Dir(configFile)); err != nil {
+			return errors.Wrapf(err, "failed to load config file '%s'", configFile)
+		}
 
 		// Create mail generator for this config
 		generator := mailgen.NewMailGenerator(&config)
@@ -160,8 +181,55 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 		}
 	}
 
+	// Build the messages up front, so that their size can be checked
+	// against the quota before anything is stored
+	var messages [][]byte
+	var dates []time.Time
+	if settings.StoreIMAP {
+		var needed int64
+		for i, email := range allEmails {
+			internalDate := email.InternalDate
+			if internalDate.IsZero() {
+				internalDate = time.Now()
+			}
+			messageData, err := buildMessage(email, internalDate)
+			if err != nil {
+				return errors.Wrapf(err, "failed to build email %d", i)
+			}
+			messages = append(messages, messageData)
+			dates = append(dates, internalDate)
+			needed += int64(len(messageData))
+		}
+
+		if settings.QuotaCheck != smailnail_imap.QuotaCheckOff {
+			quota, err := smailnail_imap.CheckQuota(imapClient, settings.Mailbox, needed)
+			switch {
+			case errors.Is(err, smailnail_imap.ErrOverQuota) && settings.QuotaCheck == smailnail_imap.QuotaCheckWarn:
+				log.Warn().Err(err).Msg("The generated emails may not fit in the mailbox")
+			case err != nil:
+				return err
+			case quota != nil:
+				log.Info().
+					Str("quota_root", quota.Root).
+					Int64("needed", needed).
+					Int64("available", quota.Available()).
+					Msg("The generated emails fit in the mailbox quota")
+			}
+		}
+	}
+
 	// Process all generated emails
 	for i, email := range allEmails {
+		// Emails without a Message-ID cannot be recognized and are always stored
+		skipped := false
+		if settings.StoreIMAP && settings.SkipExisting {
+			exists, err := smailnail_imap.MessageIDExists(imapClient, settings.Mailbox, email.MessageID)
+			if err != nil {
+				return errors.Wrapf(err, "failed to check whether email %d is stored already", i)
+			}
+			skipped = exists
+		}
+
 		// Create a glazed row for each email
 		row := types.NewRow(
 			types.MRP("index", i),
@@ -172,7 +240,15 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 			types.MRP("bcc", email.Bcc),
 			types.MRP("reply_to", email.ReplyTo),
 			types.MRP("body", email.Body),
+			types.MRP("flags", strings.Join(email.Flags, " ")),
+			types.MRP("internal_date", formatInternalDate(email.InternalDate)),
+			types.MRP("message_id", email.MessageID),
+			types.MRP("in_reply_to", email.InReplyTo),
+			types.MRP("references", email.References),
 		)
+		if settings.StoreIMAP && settings.SkipExisting {
+			row.Set("skipped", skipped)
+		}
 
 		// Add row to processor
 		if err := gp.AddRow(ctx, row); err != nil {
@@ -198,6 +274,15 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 			if email.ReplyTo != "" {
 				emailText += fmt.Sprintf("Reply-To: %s\n", email.ReplyTo)
 			}
+			if email.MessageID != "" {
+				emailText += fmt.Sprintf("Message-ID: %s\n", email.MessageID)
+			}
+			if email.InReplyTo != "" {
+				emailText += fmt.Sprintf("In-Reply-To: %s\n", email.InReplyTo)
+			}
+			if email.References != "" {
+				emailText += fmt.Sprintf("References: %s\n", email.References)
+			}
 			emailText += fmt.Sprintf("\n%s", email.Body)
 
 			// Write to file
@@ -207,64 +292,13 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 		}
 
 		// Store email in IMAP server if requested
-		if settings.StoreIMAP {
-			// Create IMAP message
-			var buf bytes.Buffer
-
-			// Create mail header
-			h := mail.Header{}
-			h.SetDate(time.Now())
-			if err := mailutil.SetSingleAddress(&h, "From", email.From); err != nil {
-				return errors.Wrapf(err, "failed to parse From address for email %d", i)
-			}
-			if email.To != "" {
-				if err := mailutil.SetSingleAddress(&h, "To", email.To); err != nil {
-					return errors.Wrapf(err, "failed to parse To address for email %d", i)
-				}
-			}
-			if email.Cc != "" {
-				if err := mailutil.SetSingleAddress(&h, "Cc", email.Cc); err != nil {
-					return errors.Wrapf(err, "failed to parse Cc address for email %d", i)
-				}
-			}
-			if email.Bcc != "" {
-				if err := mailutil.SetSingleAddress(&h, "Bcc", email.Bcc); err != nil {
-					return errors.Wrapf(err, "failed to parse Bcc address for email %d", i)
-				}
-			}
-			if email.ReplyTo != "" {
-				if err := mailutil.SetSingleAddress(&h, "Reply-To", email.ReplyTo); err != nil {
-					return errors.Wrapf(err, "failed to parse Reply-To address for email %d", i)
-				}
-			}
-			h.SetSubject(email.Subject)
-
-			// Create message writer
-			w, err := mail.CreateSingleInlineWriter(&buf, h)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create message writer for email %d", i)
-			}
-
-			// Write body
-			if _, err := w.Write([]byte(email.Body)); err != nil {
-				return errors.Wrapf(err, "failed to write message body for email %d", i)
-			}
-
-			// Close writer
-			if err := w.Close(); err != nil {
-				return errors.Wrapf(err, "failed to close message writer for email %d", i)
-			}
-
-			messageData := buf.Bytes()
-
-			// Prepare flags
-			var flags []imap.Flag
-			flags = append(flags, imap.FlagSeen)
+		if settings.StoreIMAP && !skipped {
+			messageData := messages[i]
 
 			// Set the append options
 			options := &imap.AppendOptions{
-				Flags: flags,
-				Time:  time.Now(),
+				Flags: imapFlags(email.Flags),
+				Time:  dates[i],
 			}
 
 			// Create append command
@@ -282,10 +316,93 @@ func (c *GenerateCommand) RunIntoGlazeProcessor(
 
 			// Wait for command to complete
 			if _, err := cmd.Wait(); err != nil {
-				return errors.Wrapf(err, "failed to store email %d in IMAP server", i)
+				return errors.Wrapf(smailnail_imap.WrapAppendError(err, settings.Mailbox), "failed to store email %d in IMAP server", i)
 			}
 		}
 	}
 
 	return nil
 }
+
+// buildMessage formats email as an RFC 5322 message dated internalDate.
+func buildMessage(email *mailgenTypes.Email, internalDate time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	h := mail.Header{}
+	h.SetDate(internalDate)
+	if err := mailutil.SetAddressList(&h, "From", email.From); err != nil {
+		return nil, errors.Wrap(err, "failed to parse From address")
+	}
+	if email.To != "" {
+		if err := mailutil.SetAddressList(&h, "To", email.To); err != nil {
+			return nil, errors.Wrap(err, "failed to parse To address")
+		}
+	}
+	if email.Cc != "" {
+		if err := mailutil.SetAddressList(&h, "Cc", email.Cc); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Cc address")
+		}
+	}
+	if email.Bcc != "" {
+		if err := mailutil.SetAddressList(&h, "Bcc", email.Bcc); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Bcc address")
+		}
+	}
+	if email.ReplyTo != "" {
+		if err := mailutil.SetAddressList(&h, "Reply-To", email.ReplyTo); err != nil {
+			return nil, errors.Wrap(err, "failed to parse Reply-To address")
+		}
+	}
+	h.SetSubject(email.Subject)
+	if err := mailutil.SetThreadHeaders(&h, email.MessageID, email.InReplyTo, mailutil.ParseMsgIDs(email.References)); err != nil {
+		return nil, errors.Wrap(err, "invalid thread headers")
+	}
+
+	// Create message writer
+	w, err := mail.CreateSingleInlineWriter(&buf, h)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create message writer")
+	}
+
+	// Write body
+	if _, err := w.Write([]byte(email.Body)); err != nil {
+		return nil, errors.Wrap(err, "failed to write message body")
+	}
+
+	// Close writer
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to close message writer")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// imapFlags adds the backslash to standard flags written without one, so
+// templates may use "seen" as well as "\\Seen". Other flags are keywords.
+func imapFlags(flags []string) []imap.Flag {
+	ret := make([]imap.Flag, 0, len(flags))
+	for _, flag := range flags {
+		switch strings.ToLower(strings.TrimPrefix(flag, "\\")) {
+		case "seen":
+			ret = append(ret, imap.FlagSeen)
+		case "answered":
+			ret = append(ret, imap.FlagAnswered)
+		case "flagged":
+			ret = append(ret, imap.FlagFlagged)
+		case "deleted":
+			ret = append(ret, imap.FlagDeleted)
+		case "draft":
+			ret = append(ret, imap.FlagDraft)
+		default:
+			ret = append(ret, imap.Flag(flag))
+		}
+	}
+	return ret
+}
+
+func formatInternalDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
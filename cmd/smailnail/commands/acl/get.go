@@ -0,0 +1,81 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type GetCommand struct {
+	*cmds.CommandDescription
+}
+
+func NewGetCommand() (*GetCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &GetCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"get",
+			cmds.WithShort("List who has which rights on a mailbox"),
+			cmds.WithLong(`List the access control list (GETACL, RFC 4314) of --mailbox, one row per
+user or group with its rights. Reading the list needs the a (administer)
+right on the mailbox.
+
+  smailnail acl get --mailbox Shared/Support`),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *GetCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &imap.IMAPSettings{}
+	if err := imap.DecodeIMAPSettings(parsedValues, settings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	entries, err := dsl.GetACL(client, settings.Mailbox)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row := types.NewRow(
+			types.MRP("mailbox", settings.Mailbox),
+			types.MRP("identifier", entry.Identifier),
+			types.MRP("rights", entry.Rights),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
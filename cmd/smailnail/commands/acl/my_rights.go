@@ -0,0 +1,141 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type MyRightsCommand struct {
+	*cmds.CommandDescription
+}
+
+type MyRightsSettings struct {
+	Rule string `glazed:"rule"`
+	imap.IMAPSettings
+}
+
+func NewMyRightsCommand() (*MyRightsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &MyRightsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"my-rights",
+			cmds.WithShort("Show the rights of the account on a mailbox"),
+			cmds.WithLong(`Show the rights (MYRIGHTS, RFC 4314) the account has on --mailbox, for
+instance on a mailbox shared by another user:
+
+  smailnail acl my-rights --mailbox "Other Users/alice/Invoices"
+
+With --rule, one row is output per right the rule needs instead, on the
+mailbox it searches and the targets of its actions, telling whether the
+account has it. mail-rules runs the same check before executing a rule. The
+rights are letters: l lookup, r read, s keep seen, w write flags, i insert,
+p post, k create mailboxes, x delete mailboxes, t delete messages, e
+expunge, a administer.`),
+			cmds.WithFlags(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Rule file whose needed rights to check"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *MyRightsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &MyRightsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	var rule *dsl.Rule
+	if settings.Rule != "" {
+		var err error
+		rule, err = dsl.ParseRuleFile(settings.Rule)
+		if err != nil {
+			return fmt.Errorf("error parsing rule file %s: %w", settings.Rule, err)
+		}
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if rule == nil {
+		rights, err := dsl.MyRights(client, settings.Mailbox)
+		if err != nil {
+			return err
+		}
+		row := types.NewRow(
+			types.MRP("mailbox", settings.Mailbox),
+			types.MRP("rights", rights),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+		return nil
+	}
+
+	namespace, err := dsl.LoadNamespace(client)
+	if err != nil {
+		return err
+	}
+	rule.SetNamespace(namespace)
+
+	rights := make(map[string]string)
+	for _, required := range rule.RequiredRights(settings.Mailbox) {
+		have, ok := rights[required.Mailbox]
+		if !ok {
+			have, err = dsl.MyRights(client, required.Mailbox)
+			if err != nil {
+				return err
+			}
+			rights[required.Mailbox] = have
+		}
+		row := types.NewRow(
+			types.MRP("mailbox", required.Mailbox),
+			types.MRP("right", required.Right),
+			types.MRP("reason", required.Reason),
+			types.MRP("granted", dsl.HasRight(have, required.Right)),
+			types.MRP("rights", have),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cli"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/spf13/cobra"
+)
+
+func NewACLCommand() (*cobra.Command, error) {
+	root := &cobra.Command{
+		Use:   "acl",
+		Short: "Show and change the access control lists of mailboxes",
+	}
+	if err := addGlazedSubcommands(
+		root,
+		func() (cmds.Command, error) { return NewMyRightsCommand() },
+		func() (cmds.Command, error) { return NewGetCommand() },
+		func() (cmds.Command, error) { return NewSetCommand() },
+	); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func addGlazedSubcommands(root *cobra.Command, factories ...func() (cmds.Command, error)) error {
+	for _, factory := range factories {
+		command, err := factory()
+		if err != nil {
+			return err
+		}
+		cobraCmd, err := cli.BuildCobraCommandFromCommand(
+			command,
+			cli.WithParserConfig(cli.CobraParserConfig{
+				AppName: "smailnail",
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("build acl subcommand: %w", err)
+		}
+		root.AddCommand(cobraCmd)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type SetCommand struct {
+	*cmds.CommandDescription
+}
+
+type SetSettings struct {
+	Identifier string `glazed:"identifier"`
+	Rights     string `glazed:"rights"`
+	imap.IMAPSettings
+}
+
+func NewSetCommand() (*SetCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &SetCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"set",
+			cmds.WithShort("Change the rights of a user or group on a mailbox"),
+			cmds.WithLong(`Change the rights of --identifier on --mailbox (SETACL, RFC 4314). A
+leading + adds the rights, a leading - removes them, and otherwise they
+replace the current ones:
+
+  smailnail acl set --mailbox Shared/Support --identifier bob --rights +lrs
+  smailnail acl set --mailbox Shared/Support --identifier anyone --rights -r
+
+Changing the list needs the a (administer) right on the mailbox. The
+resulting rights of the identifier are output.`),
+			cmds.WithFlags(
+				fields.New(
+					"identifier",
+					fields.TypeString,
+					fields.WithHelp("User or group whose rights to change, e.g. anyone"),
+					fields.WithRequired(true),
+				),
+				fields.New(
+					"rights",
+					fields.TypeString,
+					fields.WithHelp("Rights to set, or to add with a leading + or remove with a leading -"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *SetCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &SetSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if err := dsl.SetACL(client, settings.Mailbox, settings.Identifier, settings.Rights); err != nil {
+		return err
+	}
+	entries, err := dsl.GetACL(client, settings.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	rights := ""
+	for _, entry := range entries {
+		if entry.Identifier == settings.Identifier {
+			rights = entry.Rights
+		}
+	}
+	row := types.NewRow(
+		types.MRP("mailbox", settings.Mailbox),
+		types.MRP("identifier", settings.Identifier),
+		types.MRP("rights", rights),
+	)
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to processor: %w", err)
+	}
+	return nil
+}
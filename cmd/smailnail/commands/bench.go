@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/bench"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type BenchCommand struct {
+	*cmds.CommandDescription
+}
+
+type BenchSettings struct {
+	BenchMailbox     string   `glazed:"bench-mailbox"`
+	Sizes            []string `glazed:"sizes"`
+	Messages         int      `glazed:"messages"`
+	SearchIterations int      `glazed:"search-iterations"`
+	Keep             bool     `glazed:"keep"`
+	imap.IMAPSettings
+}
+
+func NewBenchCommand() (*BenchCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &BenchCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"bench",
+			cmds.WithShort("Measure APPEND, SEARCH and FETCH performance of a server"),
+			cmds.WithLong(`Measure a server's performance on a scratch mailbox:
+
+- append: APPEND throughput for --messages messages at each of --sizes;
+- search: latency of representative rule searches (all, unread, within_days,
+  from, subject, larger_than, body_contains, text), each run
+  --search-iterations times;
+- fetch: bandwidth of fetching the envelopes and the full bodies of every
+  appended message.
+
+The scratch mailbox (--bench-mailbox) must not exist. It is created for the
+run and deleted afterwards unless --keep is set. Results are printed as one
+row per benchmark, so servers can be compared with --output csv or json.`),
+			cmds.WithFlags(
+				fields.New(
+					"bench-mailbox",
+					fields.TypeString,
+					fields.WithHelp("Scratch mailbox to create for the run"),
+					fields.WithDefault(bench.DefaultMailbox),
+				),
+				fields.New(
+					"sizes",
+					fields.TypeStringList,
+					fields.WithHelp("Message sizes to append (e.g. 1K,10K,1M)"),
+					fields.WithDefault(bench.DefaultSizes),
+				),
+				fields.New(
+					"messages",
+					fields.TypeInteger,
+					fields.WithHelp("Messages appended per size"),
+					fields.WithDefault(bench.DefaultMessages),
+				),
+				fields.New(
+					"search-iterations",
+					fields.TypeInteger,
+					fields.WithHelp("Times each search is run"),
+					fields.WithDefault(bench.DefaultSearchIterations),
+				),
+				fields.New(
+					"keep",
+					fields.TypeBool,
+					fields.WithHelp("Keep the scratch mailbox after the run"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *BenchCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &BenchSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	config := &bench.Config{
+		Mailbox:          settings.BenchMailbox,
+		Messages:         settings.Messages,
+		SearchIterations: settings.SearchIterations,
+		Keep:             settings.Keep,
+	}
+	for _, s := range settings.Sizes {
+		size, err := dsl.ParseSize(s)
+		if err != nil {
+			return fmt.Errorf("invalid --sizes: %w", err)
+		}
+		config.Sizes = append(config.Sizes, size)
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	return bench.Run(ctx, client, config, func(result bench.Result) error {
+		row := types.NewRow()
+		row.Set("phase", result.Phase)
+		row.Set("name", result.Name)
+		row.Set("operations", result.Operations)
+		row.Set("matches", result.Matches)
+		row.Set("bytes", result.Bytes)
+		row.Set("seconds", result.Duration.Seconds())
+		row.Set("ops_per_sec", round2(result.PerSecond()))
+		row.Set("mb_per_sec", round2(result.MBPerSecond()))
+		row.Set("p50_ms", milliseconds(result.Percentile(50)))
+		row.Set("p95_ms", milliseconds(result.Percentile(95)))
+		row.Set("max_ms", milliseconds(result.Percentile(100)))
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+		return nil
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return round2(float64(d) / float64(time.Millisecond))
+}
+
+func round2(f float64) float64 {
+	return float64(int64(f*100+0.5)) / 100
+}
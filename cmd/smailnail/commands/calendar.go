@@ -0,0 +1,147 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type CalendarCommand struct {
+	*cmds.CommandDescription
+}
+
+type CalendarSettings struct {
+	RuleFile   string `glazed:"rule"`
+	Name       string `glazed:"name"`
+	Listen     string `glazed:"listen"`
+	OutputFile string `glazed:"output-file"`
+	Refresh    string `glazed:"refresh"`
+	imap.IMAPSettings
+}
+
+var _ cmds.BareCommand = &CalendarCommand{}
+
+func NewCalendarCommand() (*CalendarCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &CalendarCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"calendar",
+			cmds.WithShort("Collect the meeting invites of the messages a rule matches into one calendar"),
+			cmds.WithLong(`Read the text/calendar parts and .ics attachments of the messages a rule
+matches, e.g. invites sent to a shared mailbox, and write their events as one
+iCalendar file that calendar apps can import or subscribe to.
+
+An event sent several times or updated is kept once, in its latest version
+(highest SEQUENCE, then latest DTSTAMP). Cancelled events are kept with
+STATUS:CANCELLED so subscribed calendars remove them; replies of attendees
+are ignored. The rule's output fields are replaced by the ones the calendar
+needs; its actions are not executed.
+
+Without --listen, the calendar is written once to --output-file or stdout.
+With --listen, it is served over HTTP at every path, and fetched again from
+the server once it is older than --refresh.
+
+Example:
+  smailnail calendar invites.yaml --output-file team.ics
+  smailnail calendar invites.yaml --listen 127.0.0.1:8081 --name "Team meetings"`),
+			cmds.WithFlags(
+				fields.New("name", fields.TypeString, fields.WithHelp("Calendar name shown by calendar apps (default: the rule name)")),
+				fields.New("listen", fields.TypeString, fields.WithHelp("Serve the calendar over HTTP on this address instead of writing it once")),
+				fields.New("output-file", fields.TypeString, fields.WithHelp("File to write the calendar to (default: stdout)")),
+				fields.New("refresh", fields.TypeString, fields.WithHelp("How long a served calendar is reused before fetching it again"), fields.WithDefault("5m")),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(imapSection),
+		),
+	}, nil
+}
+
+func (c *CalendarCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &CalendarSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	refresh, err := time.ParseDuration(settings.Refresh)
+	if err != nil || refresh < 0 {
+		return fmt.Errorf("invalid --refresh: %s", settings.Refresh)
+	}
+	if settings.Listen != "" && settings.OutputFile != "" {
+		return fmt.Errorf("--output-file cannot be combined with --listen")
+	}
+
+	rule, err := dsl.ParseRuleFile(settings.RuleFile)
+	if err != nil {
+		return fmt.Errorf("error parsing rule file: %w", err)
+	}
+	if !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
+		log.Warn().Str("rule", rule.Name).Msg("Rule actions are ignored by calendar")
+	}
+	rule.Output.Fields = []interface{}{
+		dsl.Field{Name: "uid"},
+		dsl.Field{Name: "mime_parts", Content: &dsl.ContentField{
+			Mode:        "filter",
+			Types:       dsl.CalendarMediaTypes,
+			ShowContent: true,
+		}},
+	}
+	rule.Output.GroupByThread = false
+	rule.Output.FlagSummary = false
+	rule.Output.Template = ""
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	name := settings.Name
+	if name == "" {
+		name = rule.Name
+	}
+	feed := &feedCache{
+		rule:        rule,
+		imap:        &settings.IMAPSettings,
+		listen:      settings.Listen,
+		outputFile:  settings.OutputFile,
+		refresh:     refresh,
+		contentType: "text/calendar; charset=utf-8",
+		render: func(msgs []*dsl.EmailMessage) ([]byte, error) {
+			calendar := dsl.NewCalendarFeed(name)
+			for _, msg := range msgs {
+				for _, cal := range rule.MessageCalendars(msg) {
+					calendar.Add(cal)
+				}
+			}
+			log.Debug().Str("rule", rule.Name).Int("events", calendar.Len()).Msg("Collected calendar events")
+			var b bytes.Buffer
+			if err := calendar.WriteICS(&b); err != nil {
+				return nil, err
+			}
+			return b.Bytes(), nil
+		},
+	}
+	return feed.publish(ctx)
+}
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type CapabilitiesCommand struct {
+	*cmds.CommandDescription
+}
+
+type CapabilitiesSettings struct {
+	FeaturesOnly bool `glazed:"features-only"`
+	imap.IMAPSettings
+}
+
+func NewCapabilitiesCommand() (*CapabilitiesCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &CapabilitiesCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"capabilities",
+			cmds.WithShort("List the server's capabilities and which smailnail features use them"),
+			cmds.WithLong(`Connect, log in and list the capabilities the server advertises. The first
+rows are a feature matrix for the extensions smailnail cares about (MOVE,
+UIDPLUS, CONDSTORE, IDLE, SORT, THREAD, QUOTA, COMPRESS): whether the server
+supports each one and, in the behavior column, what smailnail does on this
+server, natively or with its fallback. The remaining advertised capabilities
+follow.`),
+			cmds.WithFlags(
+				fields.New(
+					"features-only",
+					fields.TypeBool,
+					fields.WithHelp("Only print the feature matrix"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *CapabilitiesCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &CapabilitiesSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	caps := client.Caps()
+	covered := map[string]bool{}
+	for _, feature := range imap.CapabilityFeatures {
+		supported := feature.Supported(caps)
+		behavior := feature.Fallback
+		if supported {
+			behavior = feature.Native
+		}
+		covered[feature.Capability] = true
+
+		row := types.NewRow(
+			types.MRP("capability", feature.Capability),
+			types.MRP("supported", supported),
+			types.MRP("feature", feature.Feature),
+			types.MRP("behavior", behavior),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return err
+		}
+	}
+
+	if settings.FeaturesOnly {
+		return nil
+	}
+	for _, capability := range imap.SortedCapabilities(caps) {
+		if covered[capability] {
+			continue
+		}
+		row := types.NewRow(
+			types.MRP("capability", capability),
+			types.MRP("supported", true),
+			types.MRP("feature", ""),
+			types.MRP("behavior", ""),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type ChaosProxyCommand struct {
+	*cmds.CommandDescription
+}
+
+type ChaosProxySettings struct {
+	Listen           string  `glazed:"listen"`
+	Upstream         string  `glazed:"upstream"`
+	UpstreamNoTLS    bool    `glazed:"upstream-no-tls"`
+	UpstreamInsecure bool    `glazed:"upstream-insecure"`
+	NoTLS            bool    `glazed:"no-tls"`
+	Latency          string  `glazed:"latency"`
+	Jitter           string  `glazed:"jitter"`
+	DropRate         float64 `glazed:"drop-rate"`
+	DropAfter        int     `glazed:"drop-after"`
+	MalformedRate    float64 `glazed:"malformed-rate"`
+	Seed             int     `glazed:"seed"`
+}
+
+var _ cmds.BareCommand = &ChaosProxyCommand{}
+
+func NewChaosProxyCommand() (*ChaosProxyCommand, error) {
+	return &ChaosProxyCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"chaos-proxy",
+			cmds.WithShort("Run an IMAP proxy that injects latency, drops and malformed responses"),
+			cmds.WithLong(`Forward IMAP connections to --upstream while injecting faults into the
+server's responses, to exercise error handling and rule idempotency:
+
+  smailnail chaos-proxy --upstream imap.example.com:993 --drop-after 20 --latency 200ms
+  smailnail mail-rules --server 127.0.0.1 --port 1993 --insecure --rule archive.yaml
+
+--latency and --jitter delay every response. --drop-rate closes the
+connection instead of forwarding a response with the given probability, and
+--drop-after closes it after that many responses. --malformed-rate sends a
+malformed untagged response before a response with the given probability.
+The greeting is never dropped or preceded by garbage.
+
+Faults are drawn from a random source seeded with --seed and the connection
+number, so rerunning the same commands with the same seed injects the same
+faults. The proxy accepts TLS with a self-signed certificate, so clients
+connect with --insecure; --no-tls accepts plain connections instead.`),
+			cmds.WithFlags(
+				fields.New("listen", fields.TypeString, fields.WithHelp("Address to listen on"), fields.WithDefault("127.0.0.1:1993")),
+				fields.New("upstream", fields.TypeString, fields.WithHelp("IMAP server to forward to (host:port)"), fields.WithRequired(true)),
+				fields.New("upstream-no-tls", fields.TypeBool, fields.WithHelp("Connect to the upstream server without TLS"), fields.WithDefault(false)),
+				fields.New("upstream-insecure", fields.TypeBool, fields.WithHelp("Skip TLS verification of the upstream server"), fields.WithDefault(false)),
+				fields.New("no-tls", fields.TypeBool, fields.WithHelp("Accept plain connections instead of TLS"), fields.WithDefault(false)),
+				fields.New("latency", fields.TypeString, fields.WithHelp("Delay added to every server response (e.g. 200ms)")),
+				fields.New("jitter", fields.TypeString, fields.WithHelp("Random extra delay of up to this duration")),
+				fields.New("drop-rate", fields.TypeFloat, fields.WithHelp("Probability of dropping the connection at a response"), fields.WithDefault(0.0)),
+				fields.New("drop-after", fields.TypeInteger, fields.WithHelp("Drop each connection after this many responses (0 disables)"), fields.WithDefault(0)),
+				fields.New("malformed-rate", fields.TypeFloat, fields.WithHelp("Probability of sending a malformed untagged response before a response"), fields.WithDefault(0.0)),
+				fields.New("seed", fields.TypeInteger, fields.WithHelp("Seed of the fault injection"), fields.WithDefault(1)),
+			),
+		),
+	}, nil
+}
+
+func (c *ChaosProxyCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &ChaosProxySettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	config := imap.ChaosConfig{
+		DropRate:      settings.DropRate,
+		DropAfter:     settings.DropAfter,
+		MalformedRate: settings.MalformedRate,
+		Seed:          int64(settings.Seed),
+	}
+	var err error
+	if settings.Latency != "" {
+		if config.Latency, err = time.ParseDuration(settings.Latency); err != nil {
+			return fmt.Errorf("invalid --latency: %w", err)
+		}
+	}
+	if settings.Jitter != "" {
+		if config.Jitter, err = time.ParseDuration(settings.Jitter); err != nil {
+			return fmt.Errorf("invalid --jitter: %w", err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	proxy := &imap.ChaosProxy{
+		Upstream:         settings.Upstream,
+		UpstreamTLS:      !settings.UpstreamNoTLS,
+		UpstreamInsecure: settings.UpstreamInsecure,
+		Config:           config,
+	}
+	if !settings.NoTLS {
+		host, _, err := net.SplitHostPort(settings.Listen)
+		if err != nil {
+			return fmt.Errorf("invalid --listen: %w", err)
+		}
+		if proxy.TLSConfig, err = imap.SelfSignedTLSConfig(host, "localhost"); err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("tcp", settings.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", settings.Listen, err)
+	}
+	log.Info().Str("listen", ln.Addr().String()).Str("upstream", settings.Upstream).Msg("Chaos proxy started")
+	return proxy.Serve(ctx, ln)
+}
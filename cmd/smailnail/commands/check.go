@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+
+	"github.com/go-go-golems/smailnail/pkg/check"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type CheckCommand struct {
+	*cmds.CommandDescription
+}
+
+type CheckSettings struct {
+	Probe    bool   `glazed:"probe"`
+	Warning  string `glazed:"warning"`
+	Critical string `glazed:"critical"`
+	imap.IMAPSettings
+}
+
+var _ cmds.BareCommand = &CheckCommand{}
+
+func NewCheckCommand() (*CheckCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &CheckCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"check",
+			cmds.WithShort("Check that the IMAP server works, for monitoring"),
+			cmds.WithLong(`Log in, select --mailbox and log out, then print a JSON report with the
+status, the number of messages in the mailbox and the latency of each step.
+With --probe, a message is appended to the mailbox and deleted again, to
+check that the server accepts mail. Without UIDPLUS the probe is only marked
+\Deleted, as expunging would remove other deleted messages too.
+
+The exit code follows the Nagios plugin convention, so the command can run
+under cron, Nagios, Icinga or any monitoring system running plugins: 0 (OK),
+1 (WARNING) when the check took longer than --warning, 2 (CRITICAL) when a
+step failed or the check took longer than --critical, 3 (UNKNOWN) for
+invalid settings.
+
+  smailnail check --account work --probe --warning 2s --critical 10s`),
+			cmds.WithFlags(
+				fields.New("probe", fields.TypeBool, fields.WithHelp("Append and delete a probe message"), fields.WithDefault(false)),
+				fields.New("warning", fields.TypeString, fields.WithHelp("Report WARNING when the check takes longer than this (e.g. 2s)")),
+				fields.New("critical", fields.TypeString, fields.WithHelp("Report CRITICAL when the check takes longer than this (e.g. 10s)")),
+			),
+			cmds.WithSections(imapSection),
+		),
+	}, nil
+}
+
+// exitUnknown is the Nagios exit code for a check that could not run.
+const exitUnknown = 3
+
+func (c *CheckCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &CheckSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return unknown(err)
+	}
+
+	config := check.Config{Mailbox: settings.Mailbox, Probe: settings.Probe}
+	var err error
+	if settings.Warning != "" {
+		if config.Warning, err = time.ParseDuration(settings.Warning); err != nil {
+			return unknown(fmt.Errorf("invalid --warning: %w", err))
+		}
+	}
+	if settings.Critical != "" {
+		if config.Critical, err = time.ParseDuration(settings.Critical); err != nil {
+			return unknown(fmt.Errorf("invalid --critical: %w", err))
+		}
+	}
+	if settings.Password == "" {
+		return unknown(fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)"))
+	}
+
+	report := check.Run(ctx, func() (*imapclient.Client, error) {
+		return settings.ConnectToIMAPServer()
+	}, config)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return unknown(fmt.Errorf("error writing report: %w", err))
+	}
+	if code := report.ExitCode(); code != 0 {
+		fmt.Fprintln(os.Stderr, report.Message)
+		os.Exit(code)
+	}
+	return nil
+}
+
+// unknown prints err and exits with the UNKNOWN status, as glazed would
+// exit with 1, which means WARNING to monitoring systems.
+func unknown(err error) error {
+	fmt.Fprintf(os.Stderr, "UNKNOWN: %v\n", err)
+	os.Exit(exitUnknown)
+	return err
+}
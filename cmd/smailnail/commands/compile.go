@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+type CompileCommand struct {
+	*cmds.CommandDescription
+}
+
+type CompileSettings struct {
+	To        string   `glazed:"to"`
+	RuleFiles []string `glazed:"rules"`
+}
+
+func NewCompileCommand() (*CompileCommand, error) {
+	return &CompileCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"compile",
+			cmds.WithShort("Compile rule files into a server-side filter script"),
+			cmds.WithLong(`Translate the search criteria and actions of one or more rule files into a
+Sieve script, to be uploaded with ManageSieve so the server applies the rules
+at delivery time. Header, body, date, flag and size criteria and the flags,
+copy_to, move_to and delete actions are supported; rules using anything else
+are rejected.`),
+			cmds.WithFlags(
+				fields.New(
+					"to",
+					fields.TypeChoice,
+					fields.WithHelp("Target language"),
+					fields.WithChoices("sieve"),
+					fields.WithDefault("sieve"),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rules",
+					fields.TypeStringList,
+					fields.WithHelp("Paths to YAML rule files"),
+					fields.WithRequired(true),
+				),
+			),
+		),
+	}, nil
+}
+
+func (c *CompileCommand) RunIntoWriter(
+	ctx context.Context,
+	parsedValues *values.Values,
+	w io.Writer,
+) error {
+	settings := &CompileSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	rules := make([]*dsl.Rule, 0, len(settings.RuleFiles))
+	for _, file := range settings.RuleFiles {
+		rule, err := dsl.ParseRuleFile(file)
+		if err != nil {
+			return fmt.Errorf("error parsing rule file %s: %w", file, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	script, err := dsl.CompileSieve(rules...)
+	if err != nil {
+		return fmt.Errorf("error compiling rules: %w", err)
+	}
+	_, err = io.WriteString(w, script)
+	return err
+}
@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type ContactsCommand struct {
+	*cmds.CommandDescription
+}
+
+type ContactsSettings struct {
+	RuleFile    string   `glazed:"rule"`
+	Mailboxes   []string `glazed:"mailboxes"`
+	Mbox        string   `glazed:"mbox"`
+	Me          []string `glazed:"me"`
+	MinMessages int      `glazed:"min-messages"`
+	VCard       string   `glazed:"vcard"`
+	imap.IMAPSettings
+}
+
+func NewContactsCommand() (*ContactsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &ContactsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"contacts",
+			cmds.WithShort("Build a ranked address book from the messages matching a rule"),
+			cmds.WithLong(`Scan the messages matching a rule's search criteria and list every address
+seen in From, To, Cc and Bcc, most frequent first, with the display names
+seen, first and last message dates and message counts.
+
+Messages from your own addresses (--me, the IMAP username by default) count
+as sent to their recipients, so the direction column tells contacts who write
+to you (inbound), whom you write to (outbound), both, or who were only copied.
+Scan the Sent mailbox too (--mailboxes INBOX --mailboxes Sent) to see
+outbound contacts. Use --output csv for a spreadsheet or --vcard to write an
+address book file.`),
+			cmds.WithFlags(
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes to scan (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Scan a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+				fields.New(
+					"me",
+					fields.TypeStringList,
+					fields.WithHelp("Your own addresses (default: the IMAP username)"),
+				),
+				fields.New(
+					"min-messages",
+					fields.TypeInteger,
+					fields.WithHelp("Only list contacts seen in at least this many messages"),
+					fields.WithDefault(1),
+				),
+				fields.New(
+					"vcard",
+					fields.TypeString,
+					fields.WithHelp("Also write the contacts as vCards to this file"),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file selecting the messages"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *ContactsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &ContactsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	rule, err := dsl.ParseRuleFile(settings.RuleFile)
+	if err != nil {
+		return fmt.Errorf("error parsing rule file: %w", err)
+	}
+	// Only the envelope is needed, whatever the rule outputs
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}}
+	rule.Output.GroupByThread = false
+	rule.Output.Template = ""
+
+	me := myAddresses(settings.Me, settings.Username)
+
+	var msgs []*dsl.EmailMessage
+	if settings.Mbox != "" {
+		msgs, err = c.readLocal(rule, settings.Mbox)
+	} else {
+		msgs, err = c.fetch(rule, settings)
+	}
+	if err != nil {
+		return err
+	}
+
+	var contacts []*dsl.Contact
+	for _, contact := range dsl.CollectContacts(msgs, me) {
+		if contact.Messages >= settings.MinMessages {
+			contacts = append(contacts, contact)
+		}
+	}
+
+	if settings.VCard != "" {
+		f, err := os.Create(settings.VCard)
+		if err != nil {
+			return fmt.Errorf("error creating vCard file: %w", err)
+		}
+		if err := dsl.WriteVCards(f, contacts); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("error writing vCard file: %w", err)
+		}
+		log.Info().Int("contacts", len(contacts)).Str("path", settings.VCard).Msg("Wrote vCards")
+	}
+
+	for _, contact := range contacts {
+		if err := gp.AddRow(ctx, contactRow(rule, contact)); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *ContactsCommand) readLocal(rule *dsl.Rule, path string) ([]*dsl.EmailMessage, error) {
+	localMessages, err := dsl.ReadLocalMessages(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading local messages: %w", err)
+	}
+	msgs, err := rule.FilterLocalMessages(localMessages)
+	if err != nil {
+		return nil, fmt.Errorf("error matching messages: %w", err)
+	}
+	return msgs, nil
+}
+
+func (c *ContactsCommand) fetch(rule *dsl.Rule, settings *ContactsSettings) ([]*dsl.EmailMessage, error) {
+	if settings.Password == "" {
+		return nil, fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	mailboxes := settings.Mailboxes
+	if len(mailboxes) == 0 {
+		mailboxes = []string{settings.Mailbox}
+	}
+
+	var msgs []*dsl.EmailMessage
+	for _, mailbox := range mailboxes {
+		if _, err := dsl.SelectMailbox(client, mailbox); err != nil {
+			return nil, fmt.Errorf("error selecting mailbox: %w", err)
+		}
+		mailboxMsgs, err := rule.FetchMessages(client)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching messages from %s: %w", mailbox, err)
+		}
+		log.Debug().Str("mailbox", mailbox).Int("messages", len(mailboxMsgs)).Msg("Scanned mailbox for contacts")
+		msgs = append(msgs, mailboxMsgs...)
+	}
+	return msgs, nil
+}
+
+func contactRow(rule *dsl.Rule, contact *dsl.Contact) types.Row {
+	formatDate := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return rule.Output.FormatDate(t)
+	}
+
+	row := types.NewRow()
+	row.Set("address", contact.Address)
+	row.Set("names", strings.Join(contact.Names, "; "))
+	row.Set("messages", contact.Messages)
+	row.Set("received", contact.Received)
+	row.Set("sent", contact.Sent)
+	row.Set("copied", contact.Copied)
+	row.Set("direction", contact.Direction())
+	row.Set("first_seen", formatDate(contact.FirstSeen))
+	row.Set("last_seen", formatDate(contact.LastSeen))
+	return row
+}
+
+// myAddresses returns the --me addresses, defaulting to the IMAP username
+// when it is an address.
+func myAddresses(me []string, username string) []string {
+	if len(me) == 0 && strings.Contains(username, "@") {
+		return []string{username}
+	}
+	return me
+}
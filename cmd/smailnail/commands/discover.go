@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type DiscoverCommand struct {
+	*cmds.CommandDescription
+}
+
+type DiscoverSettings struct {
+	Email string `glazed:"email"`
+}
+
+func NewDiscoverCommand() (*DiscoverCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	return &DiscoverCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"discover",
+			cmds.WithShort("Find the IMAP server of an email address"),
+			cmds.WithLong(`Look up the IMAP servers of an email address, one row per server with its
+host, port, security (tls, starttls or none), login and where it was found:
+
+  smailnail discover user@example.com
+
+Common providers (Gmail, Outlook, Fastmail, iCloud, Yahoo) are answered
+without a lookup. Otherwise the _imaps._tcp and _imap._tcp SRV records of
+the domain (RFC 6186) are resolved and the domain's autoconfig file, or its
+entry in the Thunderbird ISP database, is fetched. Servers with implicit TLS
+come first: smailnail only connects with implicit TLS, and init offers the
+first of them.`),
+			cmds.WithArguments(
+				fields.New(
+					"email",
+					fields.TypeString,
+					fields.WithHelp("Email address to find the server of"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *DiscoverCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &DiscoverSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	configs, err := (&imap.Discoverer{}).DiscoverAll(ctx, settings.Email)
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		row := types.NewRow(
+			types.MRP("server", config.Server),
+			types.MRP("port", config.Port),
+			types.MRP("security", config.Security),
+			types.MRP("username", config.Username),
+			types.MRP("source", config.Source),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+)
+
+// Exit codes of the rule-running commands, besides 0 for success and 1 for
+// any other error.
+const (
+	// ExitActionFailures means messages matched but an action failed
+	ExitActionFailures = 3
+	// ExitNoMatches means nothing matched and --fail-on-empty was set
+	ExitNoMatches = 4
+	// ExitExpectationFailures means a check of smailnail test failed
+	ExitExpectationFailures = 5
+	// ExitLintIssues means smailnail lint found issues at or above --fail-on
+	ExitLintIssues = 6
+)
+
+// ExitError is an error that sets the process exit code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitWithCode flushes the rows output so far, prints err and exits with
+// its code. glazed exits with 1 on any command error without flushing the
+// output, so commands with their own exit codes exit themselves.
+func exitWithCode(ctx context.Context, gp middlewares.Processor, err *ExitError) {
+	if closeErr := gp.Close(ctx); closeErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", closeErr)
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(err.Code)
+}
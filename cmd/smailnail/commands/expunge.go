@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type ExpungeCommand struct {
+	*cmds.CommandDescription
+}
+
+type ExpungeSettings struct {
+	OlderThan          string   `glazed:"older-than"`
+	DeletedOnly        bool     `glazed:"deleted-only"`
+	BatchSize          int      `glazed:"batch-size"`
+	DryRun             bool     `glazed:"dry-run"`
+	ProtectedMailboxes []string `glazed:"protected-mailboxes"`
+	AllowProtected     []string `glazed:"allow-protected"`
+	imap.IMAPSettings
+}
+
+func NewExpungeCommand() (*ExpungeCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &ExpungeCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"expunge",
+			cmds.WithShort("Permanently remove old messages, e.g. to empty the trash"),
+			cmds.WithLong(`Permanently remove the messages of --mailbox that are at least --older-than
+old, for example to empty the trash:
+
+  smailnail expunge --mailbox Trash --older-than 90d --dry-run
+
+--older-than is required; use 0d to remove every message. With
+--deleted-only, only messages already flagged \Deleted are removed. Messages
+are removed in batches of --batch-size UIDs with UID EXPUNGE and progress is
+logged after each batch. The command reports the number of messages removed
+and the space reclaimed.
+
+As with rules, mailboxes matching --protected-mailboxes are refused unless
+they also match --allow-protected. On servers without UIDPLUS the command
+refuses to run while other messages are flagged \Deleted, since a plain
+EXPUNGE would remove them too.`),
+			cmds.WithFlags(
+				fields.New(
+					"older-than",
+					fields.TypeString,
+					fields.WithHelp("Only remove messages at least this old (e.g. 90d, 4w)"),
+					fields.WithRequired(true),
+				),
+				fields.New(
+					"deleted-only",
+					fields.TypeBool,
+					fields.WithHelp("Only remove messages already flagged \\Deleted"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"batch-size",
+					fields.TypeInteger,
+					fields.WithHelp("Number of messages removed per UID EXPUNGE"),
+					fields.WithDefault(dsl.DefaultExpungeBatchSize),
+				),
+				fields.New(
+					"dry-run",
+					fields.TypeBool,
+					fields.WithHelp("Count the matching messages and their size without removing them"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"protected-mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Glob patterns of mailboxes that may not be expunged unless they match --allow-protected"),
+					fields.WithDefault(dsl.DefaultProtectedMailboxes),
+				),
+				fields.New(
+					"allow-protected",
+					fields.TypeStringList,
+					fields.WithHelp("Glob patterns of protected mailboxes to expunge anyway"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *ExpungeCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &ExpungeSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	config := &dsl.ExpungeConfig{
+		Mailbox:        settings.Mailbox,
+		OlderThan:      settings.OlderThan,
+		DeletedOnly:    settings.DeletedOnly,
+		BatchSize:      settings.BatchSize,
+		DryRun:         settings.DryRun,
+		AllowProtected: settings.AllowProtected,
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if err := dsl.ValidateMailboxPatterns(settings.ProtectedMailboxes); err != nil {
+		return fmt.Errorf("invalid --protected-mailboxes: %w", err)
+	}
+	if err := config.CheckProtected(settings.ProtectedMailboxes); err != nil {
+		return err
+	}
+	before, err := config.Before(time.Now())
+	if err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	result, err := dsl.Expunge(client, config, func(done, total int) {
+		log.Info().
+			Str("mailbox", settings.Mailbox).
+			Int("done", done).
+			Int("total", total).
+			Msg("Expunged messages")
+	})
+	if err != nil {
+		return fmt.Errorf("error expunging messages: %w", err)
+	}
+
+	row := types.NewRow()
+	row.Set("mailbox", settings.Mailbox)
+	if before.IsZero() {
+		row.Set("before", "")
+	} else {
+		row.Set("before", before.Format("2006-01-02"))
+	}
+	row.Set("matched", result.Matched)
+	row.Set("expunged", result.Expunged)
+	row.Set("size", result.Size)
+	row.Set("reclaimed", dsl.HumanizeSize(uint64(result.Size)))
+	row.Set("dry_run", settings.DryRun)
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to processor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+// defaultFeedLimit caps the entries of feeds whose rule has no output limit.
+const defaultFeedLimit = 50
+
+type FeedCommand struct {
+	*cmds.CommandDescription
+}
+
+type FeedSettings struct {
+	RuleFile   string `glazed:"rule"`
+	Listen     string `glazed:"listen"`
+	OutputFile string `glazed:"output-file"`
+	Refresh    string `glazed:"refresh"`
+	Link       string `glazed:"link"`
+	imap.IMAPSettings
+}
+
+var _ cmds.BareCommand = &FeedCommand{}
+
+func NewFeedCommand() (*FeedCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &FeedCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"feed",
+			cmds.WithShort("Publish the messages a rule matches as an Atom feed"),
+			cmds.WithLong(`Fetch the messages a rule matches, e.g. newsletters, and write them as an
+Atom feed, to read triaged mail in a feed reader. Each message is an entry
+titled with its subject, with the text/html part as content (the text/plain
+part when there is none). The rule's output fields are replaced by the ones
+the feed needs; its actions are not executed. Without an output limit, the
+feed holds the last 50 messages.
+
+Without --listen, the feed is written once to --output-file or stdout. With
+--listen, the feed is served over HTTP at every path, and fetched again from
+the server once it is older than --refresh.
+
+Example:
+  smailnail feed newsletters.yaml --output-file newsletters.atom
+  smailnail feed newsletters.yaml --listen 127.0.0.1:8080 --refresh 15m`),
+			cmds.WithFlags(
+				fields.New("listen", fields.TypeString, fields.WithHelp("Serve the feed over HTTP on this address instead of writing it once")),
+				fields.New("output-file", fields.TypeString, fields.WithHelp("File to write the feed to (default: stdout)")),
+				fields.New("refresh", fields.TypeString, fields.WithHelp("How long a served feed is reused before fetching it again"), fields.WithDefault("5m")),
+				fields.New("link", fields.TypeString, fields.WithHelp("Public URL of the served feed, for its self link")),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(imapSection),
+		),
+	}, nil
+}
+
+func (c *FeedCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &FeedSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	refresh, err := time.ParseDuration(settings.Refresh)
+	if err != nil || refresh < 0 {
+		return fmt.Errorf("invalid --refresh: %s", settings.Refresh)
+	}
+	if settings.Listen != "" && settings.OutputFile != "" {
+		return fmt.Errorf("--output-file cannot be combined with --listen")
+	}
+
+	rule, err := dsl.ParseRuleFile(settings.RuleFile)
+	if err != nil {
+		return fmt.Errorf("error parsing rule file: %w", err)
+	}
+	if !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
+		log.Warn().Str("rule", rule.Name).Msg("Rule actions are ignored by feed")
+	}
+	prepareFeedRule(rule)
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	feed := &feedCache{
+		rule:        rule,
+		imap:        &settings.IMAPSettings,
+		listen:      settings.Listen,
+		outputFile:  settings.OutputFile,
+		refresh:     refresh,
+		contentType: "application/atom+xml; charset=utf-8",
+		render: func(msgs []*dsl.EmailMessage) ([]byte, error) {
+			var b bytes.Buffer
+			atom := dsl.AtomFeedForRule(rule)
+			atom.Link = settings.Link
+			if err := dsl.WriteAtom(&b, msgs, atom); err != nil {
+				return nil, err
+			}
+			return b.Bytes(), nil
+		},
+	}
+	return feed.publish(ctx)
+}
+
+// prepareFeedRule replaces the output of rule with the fields WriteAtom
+// reads.
+func prepareFeedRule(rule *dsl.Rule) {
+	rule.Output.Format = dsl.FormatAtom
+	rule.Output.Fields = []interface{}{
+		dsl.Field{Name: "uid"},
+		dsl.Field{Name: "envelope"},
+		dsl.Field{Name: "mime_parts", Content: &dsl.ContentField{
+			Mode:        "filter",
+			Types:       []string{"text/html", "text/plain"},
+			ShowContent: true,
+		}},
+	}
+	rule.Output.GroupByThread = false
+	rule.Output.FlagSummary = false
+	rule.Output.Template = ""
+	if rule.Output.Limit == 0 {
+		rule.Output.Limit = defaultFeedLimit
+	}
+}
+
+// feedCache holds the last rendered feed, so feed readers polling often do
+// not each cause a fetch. render turns the messages the rule matches into the
+// feed.
+type feedCache struct {
+	rule        *dsl.Rule
+	imap        *imap.IMAPSettings
+	listen      string
+	outputFile  string
+	refresh     time.Duration
+	contentType string
+	render      func(msgs []*dsl.EmailMessage) ([]byte, error)
+
+	mu      sync.Mutex
+	body    []byte
+	fetched time.Time
+}
+
+// publish writes the feed once to --output-file or stdout, or serves it on
+// --listen until ctx is done.
+func (f *feedCache) publish(ctx context.Context) error {
+	if f.listen != "" {
+		return serveFeed(ctx, f.listen, f)
+	}
+	body, err := f.get()
+	if err != nil {
+		return err
+	}
+	if f.outputFile == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	if err := os.WriteFile(f.outputFile, body, 0o600); err != nil {
+		return fmt.Errorf("error writing feed: %w", err)
+	}
+	log.Info().Str("path", f.outputFile).Msg("Wrote feed")
+	return nil
+}
+
+func (f *feedCache) get() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.body != nil && time.Since(f.fetched) < f.refresh {
+		return f.body, nil
+	}
+
+	client, err := f.imap.ConnectToIMAPServer()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	if _, err := dsl.SelectMailbox(client, f.rule.MailboxName(f.imap.Mailbox)); err != nil {
+		return nil, fmt.Errorf("error selecting mailbox: %w", err)
+	}
+	msgs, err := f.rule.FetchMessages(client)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching messages: %w", err)
+	}
+
+	body, err := f.render(msgs)
+	if err != nil {
+		return nil, err
+	}
+	f.body, f.fetched = body, time.Now()
+	log.Debug().Str("rule", f.rule.Name).Int("messages", len(msgs)).Msg("Fetched feed")
+	return f.body, nil
+}
+
+// serveFeed serves the feed on addr until ctx is done.
+func serveFeed(ctx context.Context, addr string, feed *feedCache) error {
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			body, err := feed.get()
+			if err != nil {
+				log.Error().Err(err).Str("rule", feed.rule.Name).Msg("Failed to fetch feed")
+				http.Error(w, "failed to fetch feed", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", feed.contentType)
+			_, _ = w.Write(body)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("listen", addr).Str("rule", feed.rule.Name).Msg("Serving feed")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving feed: %w", err)
+	}
+	return nil
+}
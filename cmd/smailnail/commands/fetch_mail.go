@@ -212,7 +212,7 @@ func (c *FetchMailCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -471,10 +471,8 @@ func (c *FetchMailCommand) buildRuleFromSettings(settings *FetchMailSettings) (*
 }
 
 func (c *FetchMailCommand) selectMailbox(client *imapclient.Client, mailbox string) error {
-	if _, err := client.Select(mailbox, nil).Wait(); err != nil {
-		return fmt.Errorf("failed to select mailbox %q: %w", mailbox, err)
-	}
-	return nil
+	_, err := dsl.SelectMailbox(client, mailbox)
+	return err
 }
 
 func (c *FetchMailCommand) shouldIncludeMimeType(mimeType string, filter string) bool {
@@ -0,0 +1,131 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type RestoreCommand struct {
+	*cmds.CommandDescription
+}
+
+type RestoreSettings struct {
+	In     string `glazed:"in"`
+	DryRun bool   `glazed:"dry-run"`
+	All    bool   `glazed:"all"`
+	imap.IMAPSettings
+}
+
+func NewRestoreCommand() (*RestoreCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &RestoreCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"restore",
+			cmds.WithShort("Put back the flags recorded by flags snapshot"),
+			cmds.WithLong(`Set the flags of the messages recorded by "smailnail flags snapshot" back to
+their recorded state, in the mailbox the snapshot was taken of (--mailbox is
+ignored). Messages are found by UID while the mailbox's UIDVALIDITY is
+unchanged and their Message-ID still agrees, and by Message-ID otherwise.
+Messages that arrived after the snapshot are left alone.
+
+One row is printed per message whose flags differ, with the flags added and
+removed; --all also prints unchanged and missing messages. --dry-run only
+reports the changes.`),
+			cmds.WithFlags(
+				fields.New(
+					"in",
+					fields.TypeString,
+					fields.WithHelp("Snapshot file to restore"),
+					fields.WithRequired(true),
+				),
+				fields.New(
+					"dry-run",
+					fields.TypeBool,
+					fields.WithHelp("Report the changes without storing flags"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"all",
+					fields.TypeBool,
+					fields.WithHelp("Also print unchanged and missing messages"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *RestoreCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &RestoreSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	snapshot, err := dsl.LoadFlagSnapshot(settings.In)
+	if err != nil {
+		return err
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	restores, err := dsl.RestoreFlagSnapshot(client, snapshot, settings.DryRun)
+	for _, restore := range restores {
+		if !settings.All && !restore.Changed() {
+			continue
+		}
+		row := types.NewRow()
+		row.Set("uid", restore.UID)
+		row.Set("snapshot_uid", restore.Entry.UID)
+		row.Set("message_id", restore.Entry.MessageID)
+		row.Set("found", restore.UID != 0)
+		row.Set("matched_by", restore.MatchedBy)
+		row.Set("flags", strings.Join(restore.Entry.Flags, " "))
+		row.Set("added", strings.Join(restore.Added, " "))
+		row.Set("removed", strings.Join(restore.Removed, " "))
+		row.Set("dry_run", settings.DryRun)
+		if addErr := gp.AddRow(ctx, row); addErr != nil {
+			return fmt.Errorf("error adding row to processor: %w", addErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error restoring flags: %w", err)
+	}
+	return nil
+}
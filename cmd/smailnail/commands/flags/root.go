@@ -0,0 +1,44 @@
+package flags
+
+import (
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cli"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/spf13/cobra"
+)
+
+func NewFlagsCommand() (*cobra.Command, error) {
+	root := &cobra.Command{
+		Use:   "flags",
+		Short: "Snapshot and restore the flags of a mailbox",
+	}
+	if err := addGlazedSubcommands(
+		root,
+		func() (cmds.Command, error) { return NewSnapshotCommand() },
+		func() (cmds.Command, error) { return NewRestoreCommand() },
+	); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func addGlazedSubcommands(root *cobra.Command, factories ...func() (cmds.Command, error)) error {
+	for _, factory := range factories {
+		command, err := factory()
+		if err != nil {
+			return err
+		}
+		cobraCmd, err := cli.BuildCobraCommandFromCommand(
+			command,
+			cli.WithParserConfig(cli.CobraParserConfig{
+				AppName: "smailnail",
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("build flags subcommand: %w", err)
+		}
+		root.AddCommand(cobraCmd)
+	}
+	return nil
+}
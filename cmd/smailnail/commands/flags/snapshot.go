@@ -0,0 +1,106 @@
+package flags
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type SnapshotCommand struct {
+	*cmds.CommandDescription
+}
+
+type SnapshotSettings struct {
+	Out string `glazed:"out"`
+	imap.IMAPSettings
+}
+
+func NewSnapshotCommand() (*SnapshotCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &SnapshotCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"snapshot",
+			cmds.WithShort("Record the flags of every message in a mailbox"),
+			cmds.WithLong(`Record the UID, Message-ID and flags of every message in --mailbox to a JSON
+file, opening the mailbox read-only. "smailnail flags restore" puts the
+recorded flags back, so runs that mutate flags on a shared test account can be
+reverted:
+
+  smailnail flags snapshot --mailbox INBOX --out flags.json
+  smailnail mail-rules --rule mark-read.yaml
+  smailnail flags restore --in flags.json`),
+			cmds.WithFlags(
+				fields.New(
+					"out",
+					fields.TypeString,
+					fields.WithHelp("File to write the snapshot to"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *SnapshotCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &SnapshotSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	snapshot, err := dsl.TakeFlagSnapshot(client, settings.Mailbox)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Save(settings.Out); err != nil {
+		return err
+	}
+
+	row := types.NewRow()
+	row.Set("mailbox", snapshot.Mailbox)
+	row.Set("uid_validity", snapshot.UIDValidity)
+	row.Set("messages", len(snapshot.Messages))
+	row.Set("taken_at", snapshot.TakenAt.Format(time.RFC3339))
+	row.Set("out", settings.Out)
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to processor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type FollowUpsCommand struct {
+	*cmds.CommandDescription
+}
+
+type FollowUpsSettings struct {
+	State          string   `glazed:"state"`
+	Check          bool     `glazed:"check"`
+	ReplyMailboxes []string `glazed:"reply-mailbox"`
+	Watch          string   `glazed:"watch"`
+	imap.IMAPSettings
+}
+
+func NewFollowUpsCommand() (*FollowUpsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &FollowUpsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"follow-ups",
+			cmds.WithShort("List messages awaiting a reply and send reminders for overdue ones"),
+			cmds.WithLong(`List the messages tracked by the follow_up action. With --check, the reply
+mailboxes are searched for messages whose In-Reply-To or References point at a
+tracked message; those are dropped as replied. Tracked messages still without a
+reply past their window get their reminder (a row, a message appended to INBOX
+or a webhook call) and are dropped too. With --watch, the check runs
+repeatedly at the given interval until interrupted.`),
+			cmds.WithFlags(
+				fields.New(
+					"state",
+					fields.TypeString,
+					fields.WithHelp("Path to the follow-up state file (default $XDG_STATE_HOME/smailnail/follow-ups.json)"),
+				),
+				fields.New(
+					"check",
+					fields.TypeBool,
+					fields.WithHelp("Look for replies and send reminders for overdue messages"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"reply-mailbox",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes searched for replies"),
+					fields.WithDefault([]string{dsl.DefaultFollowUpMailbox}),
+				),
+				fields.New(
+					"watch",
+					fields.TypeString,
+					fields.WithHelp("Check repeatedly at this interval (e.g. 15m), implies --check"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *FollowUpsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &FollowUpsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	if settings.Watch != "" {
+		var err error
+		interval, err = time.ParseDuration(settings.Watch)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid --watch interval: %s", settings.Watch)
+		}
+	}
+
+	if !settings.Check && interval == 0 {
+		store, err := dsl.LoadFollowUpStore(settings.State)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, entry := range store.Entries {
+			row := followUpRow(entry)
+			row.Set("overdue", !entry.DueAt.After(now))
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding row to processor: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	for {
+		if err := c.check(ctx, settings, gp); err != nil {
+			if interval == 0 {
+				return err
+			}
+			log.Error().Err(err).Msg("Checking follow-ups failed")
+		}
+		if interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// check connects, looks for replies and reports the outcome for each entry.
+func (c *FollowUpsCommand) check(ctx context.Context, settings *FollowUpsSettings, gp middlewares.Processor) error {
+	store, err := dsl.LoadFollowUpStore(settings.State)
+	if err != nil {
+		return err
+	}
+	if len(store.Entries) == 0 {
+		log.Debug().Msg("No messages are awaiting a reply")
+		return nil
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	results, err := dsl.CheckFollowUps(ctx, client, store, settings.ReplyMailboxes, time.Now())
+	for _, result := range results {
+		row := followUpRow(result.Entry)
+		row.Set("status", result.Status)
+		if addErr := gp.AddRow(ctx, row); addErr != nil {
+			return fmt.Errorf("error adding row to processor: %w", addErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error checking follow-ups: %w", err)
+	}
+	return nil
+}
+
+func followUpRow(entry dsl.FollowUpEntry) types.Row {
+	row := types.NewRow()
+	row.Set("message_id", entry.MessageID)
+	row.Set("subject", entry.Subject)
+	row.Set("to", entry.To)
+	row.Set("sent_at", entry.SentAt.Format(time.RFC3339))
+	row.Set("due_at", entry.DueAt.Format(time.RFC3339))
+	row.Set("remind", entry.Remind)
+	row.Set("rule", entry.Rule)
+	return row
+}
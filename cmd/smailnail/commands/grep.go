@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/rs/zerolog/log"
+)
+
+type GrepCommand struct {
+	*cmds.CommandDescription
+}
+
+type GrepSettings struct {
+	RuleFile             string `glazed:"rule"`
+	Mbox                 string `glazed:"mbox"`
+	ConcatenateMimeParts bool   `glazed:"concatenate-mime-parts"`
+	WithSource           bool   `glazed:"with-source"`
+}
+
+func NewGrepCommand() (*GrepCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	return &GrepCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"grep",
+			cmds.WithShort("Run a mail rule against a local mbox file or .eml directory"),
+			cmds.WithLong(`Evaluate a rule's search criteria and output configuration against local
+messages, without connecting to an IMAP server. --mbox accepts an mbox file,
+a single .eml file or a directory of .eml files (maildir cur/new included).
+Search criteria follow IMAP SEARCH semantics; actions are not executed.`),
+			cmds.WithFlags(
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Path to an mbox file, .eml file, JSONL corpus or directory of .eml files"),
+					fields.WithRequired(true),
+				),
+				fields.New(
+					"concatenate-mime-parts",
+					fields.TypeBool,
+					fields.WithHelp("Concatenate all MIME parts into a single content string instead of showing structured output"),
+					fields.WithDefault(true),
+				),
+				fields.New(
+					"with-source",
+					fields.TypeBool,
+					fields.WithHelp("Add the file (and mbox index) each message was read from"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *GrepCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &GrepSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	rule, err := dsl.ParseRuleFile(settings.RuleFile)
+	if err != nil {
+		return fmt.Errorf("error parsing rule file: %w", err)
+	}
+
+	localMessages, err := dsl.ReadLocalMessages(settings.Mbox)
+	if err != nil {
+		return fmt.Errorf("error reading local messages: %w", err)
+	}
+
+	msgs, err := rule.FilterLocalMessages(localMessages)
+	if err != nil {
+		return fmt.Errorf("error matching messages: %w", err)
+	}
+
+	if !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
+		log.Warn().Str("rule", rule.Name).Msg("Rule actions are ignored in grep mode")
+	}
+
+	if rule.Output.GroupByThread {
+		return addThreadRows(ctx, &ruleOutput{gp: gp}, rule, dsl.GroupThreads(msgs))
+	}
+	if rule.Output.FlagSummary {
+		flags := dsl.NewFlagTally()
+		flags.Add("", msgs)
+		return addFlagSummaryRows(ctx, gp, []*dsl.Rule{rule}, flags)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, msg := range msgs {
+		for _, row := range messageRows(rule, msg, settings.ConcatenateMimeParts) {
+			if settings.WithSource {
+				// Local messages are numbered by their position in the source
+				if idx := int(msg.SeqNum) - 1; idx >= 0 && idx < len(localMessages) {
+					row.Set("source", localMessages[idx].Source)
+				}
+			}
+			if rule.Output.Format == "ndjson" {
+				if err := encoder.Encode(row); err != nil {
+					return fmt.Errorf("error writing JSON line: %w", err)
+				}
+				continue
+			}
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding row to processor: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-go-golems/smailnail/pkg/filterimport"
+)
+
+type ImportFiltersCommand struct {
+	*cmds.CommandDescription
+}
+
+type ImportFiltersSettings struct {
+	File      string `glazed:"file"`
+	Format    string `glazed:"format"`
+	OutputDir string `glazed:"output-dir"`
+}
+
+func NewImportFiltersCommand() (*ImportFiltersCommand, error) {
+	return &ImportFiltersCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"import-filters",
+			cmds.WithShort("Convert Gmail or Thunderbird filters into rule files"),
+			cmds.WithLong(`Convert a Gmail filter export (mailFilters.xml) or a Thunderbird
+msgFilterRules.dat file into smailnail rules. Filters with criteria that cannot
+be translated are skipped and unsupported actions are dropped; both are
+reported as warnings. Rules are written to stdout as YAML documents, or as one
+file per rule with --output-dir.`),
+			cmds.WithFlags(
+				fields.New(
+					"format",
+					fields.TypeString,
+					fields.WithHelp("Filter format: gmail or thunderbird (detected from the file by default)"),
+				),
+				fields.New(
+					"output-dir",
+					fields.TypeString,
+					fields.WithHelp("Write one <rule-name>.yaml file per rule into this directory"),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"file",
+					fields.TypeString,
+					fields.WithHelp("Filter file to import"),
+					fields.WithRequired(true),
+				),
+			),
+		),
+	}, nil
+}
+
+func (c *ImportFiltersCommand) RunIntoWriter(
+	ctx context.Context,
+	parsedValues *values.Values,
+	w io.Writer,
+) error {
+	settings := &ImportFiltersSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	result, err := filterimport.Import(settings.File, settings.Format)
+	if err != nil {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		log.Warn().Str("filter", warning.Filter).Msg(warning.Message)
+	}
+
+	if settings.OutputDir != "" {
+		if err := os.MkdirAll(settings.OutputDir, 0o755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+
+	for i, rule := range result.Rules {
+		data, err := yaml.Marshal(rule)
+		if err != nil {
+			return fmt.Errorf("error marshaling rule %s: %w", rule.Name, err)
+		}
+
+		if settings.OutputDir != "" {
+			path := filepath.Join(settings.OutputDir, rule.Name+".yaml")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return fmt.Errorf("error writing rule file: %w", err)
+			}
+			log.Info().Str("rule", rule.Name).Str("path", path).Msg("Wrote rule file")
+			continue
+		}
+
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	log.Info().
+		Int("rules", len(result.Rules)).
+		Int("warnings", len(result.Warnings)).
+		Msg("Imported filters")
+	return nil
+}
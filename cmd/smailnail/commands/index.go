@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/smailnail/pkg/mirror"
+)
+
+type IndexCommand struct {
+	*cmds.CommandDescription
+}
+
+type IndexSettings struct {
+	SQLitePath string `glazed:"sqlite-path"`
+	MirrorRoot string `glazed:"mirror-root"`
+}
+
+func NewIndexCommand() (*IndexCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	return &IndexCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"index",
+			cmds.WithShort("Build the full-text index of a local mirror"),
+			cmds.WithLong(`Rebuild the full-text index of the headers and bodies of the messages synced
+by "smailnail mirror", so they can be searched offline with ranked results:
+
+  smailnail mirror --server imap.example.com ... --sqlite-path mail.sqlite
+  smailnail index --sqlite-path mail.sqlite
+  smailnail sqlite search 'invoice AND subject:march' --sqlite-path mail.sqlite
+
+The mirror keeps the index up to date as it syncs; rebuild it after editing
+the database by hand or when upgrading from a mirror created before headers
+were indexed.`),
+			cmds.WithFlags(
+				fields.New(
+					"sqlite-path",
+					fields.TypeString,
+					fields.WithHelp("SQLite path for the local mirror store"),
+					fields.WithDefault(mirror.DefaultSQLiteDBPath),
+				),
+				fields.New(
+					"mirror-root",
+					fields.TypeString,
+					fields.WithHelp("Mirror root used when bootstrapping the sqlite store"),
+					fields.WithDefault(mirror.DefaultMirrorRoot),
+				),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *IndexCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &IndexSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	store, err := mirror.OpenStore(settings.SQLitePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = store.Close() }()
+
+	bootstrap, err := store.Bootstrap(ctx, settings.MirrorRoot)
+	if err != nil {
+		return fmt.Errorf("error bootstrapping mirror store: %w", err)
+	}
+	report, err := store.RebuildIndex(ctx)
+	if err != nil {
+		return fmt.Errorf("error rebuilding index: %w", err)
+	}
+
+	row := types.NewRow(
+		types.MRP("sqlite_path", report.SQLitePath),
+		types.MRP("search_mode", bootstrap.SearchMode),
+		types.MRP("rows_indexed", report.RowsIndexed),
+	)
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to processor: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"golang.org/x/term"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type InitCommand struct {
+	*cmds.CommandDescription
+}
+
+type InitSettings struct {
+	Account      string `glazed:"account"`
+	AccountsFile string `glazed:"accounts-file"`
+	NoTest       bool   `glazed:"no-test"`
+}
+
+var _ cmds.BareCommand = &InitCommand{}
+
+func NewInitCommand() (*InitCommand, error) {
+	return &InitCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"init",
+			cmds.WithShort("Set up an account profile interactively"),
+			cmds.WithLong(`Ask for an email address, find its IMAP server and write an account profile
+that other commands select with --account:
+
+  smailnail init
+  smailnail mail-rules --account personal --rule archive.yaml
+
+The server is looked up among common providers (Gmail, Outlook, Fastmail,
+iCloud, Yahoo), then in the domain's _imaps._tcp SRV record and its
+autoconfig file, and can be changed at the prompt. The password can be
+stored in the profile or read from an environment variable when the profile
+is used. The connection is tested before the profile is written, unless
+--no-test is set.
+
+The profile is written to the accounts file (see --accounts-file), keeping
+the other accounts and settings of the file.`),
+			cmds.WithFlags(
+				fields.New("account", fields.TypeString, fields.WithHelp("Name of the profile to write (asked if not set)")),
+				fields.New("accounts-file", fields.TypeString, fields.WithHelp("Config file to write the profile to (default: smailnail/config.yaml in the user config directory)")),
+				fields.New("no-test", fields.TypeBool, fields.WithHelp("Write the profile without testing the connection"), fields.WithDefault(false)),
+			),
+		),
+	}, nil
+}
+
+func (c *InitCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &InitSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	path := settings.AccountsFile
+	if path == "" {
+		files := imap.DefaultAccountsFiles()
+		if len(files) == 0 {
+			return fmt.Errorf("no config directory found, set --accounts-file")
+		}
+		path = files[0]
+		for _, candidate := range files {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+
+	email, err := p.ask("Email address", "")
+	if err != nil {
+		return err
+	}
+	if email == "" {
+		return fmt.Errorf("an email address is required")
+	}
+
+	discovered, err := imap.DiscoverServer(ctx, email)
+	if err != nil {
+		_, _ = fmt.Fprintf(p.out, "%v, enter the server manually.\n", err)
+		discovered = &imap.ServerConfig{Port: 993, Username: email}
+	} else {
+		_, _ = fmt.Fprintf(p.out, "Found %s:%d with %s (%s).\n", discovered.Server, discovered.Port, discovered.Security, discovered.Source)
+	}
+
+	account := imap.Account{}
+	if account.Server, err = p.ask("IMAP server", discovered.Server); err != nil {
+		return err
+	}
+	port, err := p.ask("Port (implicit TLS)", strconv.Itoa(discovered.Port))
+	if err != nil {
+		return err
+	}
+	if account.Port, err = strconv.Atoi(port); err != nil || account.Port <= 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	if account.Insecure, err = p.confirm("Skip TLS certificate verification", false); err != nil {
+		return err
+	}
+	if account.Username, err = p.ask("Username", discovered.Username); err != nil {
+		return err
+	}
+	if account.PasswordEnv, err = p.ask("Environment variable holding the password (empty to store it in the profile)", ""); err != nil {
+		return err
+	}
+	password := ""
+	if account.PasswordEnv != "" {
+		password = os.Getenv(account.PasswordEnv)
+	}
+	if password == "" {
+		if password, err = p.password("Password"); err != nil {
+			return err
+		}
+	}
+	if account.PasswordEnv == "" {
+		account.Password = password
+	}
+
+	name := settings.Account
+	if name == "" {
+		defaultName := "default"
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			defaultName = strings.SplitN(email[at+1:], ".", 2)[0]
+		}
+		if name, err = p.ask("Profile name", defaultName); err != nil {
+			return err
+		}
+	}
+
+	if !settings.NoTest {
+		_, _ = fmt.Fprintf(p.out, "Testing the connection to %s:%d...\n", account.Server, account.Port)
+		imapSettings := &imap.IMAPSettings{
+			Server:   account.Server,
+			Port:     account.Port,
+			Username: account.Username,
+			Password: password,
+			Insecure: account.Insecure,
+		}
+		client, err := imapSettings.ConnectToIMAPServer()
+		if err != nil {
+			_, _ = fmt.Fprintf(p.out, "Connection failed: %v\n", err)
+			save, promptErr := p.confirm("Save the profile anyway", false)
+			if promptErr != nil {
+				return promptErr
+			}
+			if !save {
+				return err
+			}
+		} else {
+			_ = client.Logout().Wait()
+			_ = client.Close()
+			_, _ = fmt.Fprintln(p.out, "Connection OK.")
+		}
+	}
+
+	if err := imap.SaveAccount(path, name, account); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(p.out, "Wrote account %s to %s, use it with --account %s.\n", name, path, name)
+	return nil
+}
+
+// prompter asks the questions of init on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question and returns the answer, or def for an empty answer.
+func (p *prompter) ask(question string, def string) (string, error) {
+	if def != "" {
+		_, _ = fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		_, _ = fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := p.ask(question+" ("+hint+")", "")
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "":
+		return def, nil
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid answer %q, expected yes or no", answer)
+}
+
+// password reads a password without echoing it when stdin is a terminal.
+func (p *prompter) password(question string) (string, error) {
+	// #nosec G115 -- file descriptors fit in an int.
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return p.ask(question, "")
+	}
+	_, _ = fmt.Fprintf(p.out, "%s: ", question)
+	password, err := term.ReadPassword(fd)
+	_, _ = fmt.Fprintln(p.out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(password), nil
+}
@@ -0,0 +1,110 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type KeywordsCommand struct {
+	*cmds.CommandDescription
+}
+
+type KeywordsSettings struct {
+	Count bool `glazed:"count"`
+	imap.IMAPSettings
+}
+
+func NewKeywordsCommand() (*KeywordsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &KeywordsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"keywords",
+			cmds.WithShort("List the keywords of a mailbox"),
+			cmds.WithLong(`List the keywords (user-defined flags such as $Todo or $Receipt) that
+--mailbox reports in its FLAGS and PERMANENTFLAGS, to write search.keywords
+criteria and flag actions against:
+
+  smailnail keywords --mailbox INBOX --count
+
+One row is output per keyword, with whether it is permanent and, with
+--count, the number of messages having it. accepts_new tells whether the
+mailbox lets messages get keywords it does not list yet. The mailbox is
+opened read-only.`),
+			cmds.WithFlags(
+				fields.New(
+					"count",
+					fields.TypeBool,
+					fields.WithHelp("Count the messages having each keyword"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *KeywordsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &KeywordsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	report, err := dsl.ListKeywords(client, settings.Mailbox, settings.Count)
+	if err != nil {
+		return fmt.Errorf("error listing keywords: %w", err)
+	}
+
+	for _, keyword := range report.Keywords {
+		row := types.NewRow(
+			types.MRP("mailbox", report.Mailbox),
+			types.MRP("keyword", keyword.Keyword),
+			types.MRP("permanent", keyword.Permanent),
+			types.MRP("accepts_new", report.AcceptsNew),
+		)
+		if settings.Count {
+			row.Set("messages", keyword.Messages)
+		}
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/go-go-golems/smailnail/pkg/mailgen"
+)
+
+type LearnCommand struct {
+	*cmds.CommandDescription
+}
+
+type LearnSettings struct {
+	RuleFile       string `glazed:"rule"`
+	Mbox           string `glazed:"mbox"`
+	Out            string `glazed:"out"`
+	Limit          int    `glazed:"limit"`
+	MaxVariations  int    `glazed:"max-variations"`
+	MinClusterSize int    `glazed:"min-cluster-size"`
+	BodyChars      int    `glazed:"body-chars"`
+	imap.IMAPSettings
+}
+
+func NewLearnCommand() (*LearnCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &LearnCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"learn",
+			cmds.WithShort("Learn a mailgen template config from an existing mailbox"),
+			cmds.WithLong(`Cluster the messages of a mailbox by sender and subject pattern (numbers
+ignored, so "Invoice 1234" and "Invoice 1235" belong together) and write a
+mailgen configuration with one template per cluster, example messages as
+variations and generate counts matching the observed frequencies.
+
+The result is a skeleton for realistic test data: edit the templates and run
+it with mailgen. Example bodies are copied from real mail, so learn from an
+anonymized export (export.anonymize, then --mbox) before sharing the output.`),
+			cmds.WithFlags(
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Rule file selecting the messages (default: all messages)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Learn from a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+				fields.New(
+					"out",
+					fields.TypeString,
+					fields.WithHelp("Write the config to this file instead of stdout"),
+				),
+				fields.New(
+					"limit",
+					fields.TypeInteger,
+					fields.WithHelp("Maximum number of messages to learn from, unless the rule sets a limit"),
+					fields.WithDefault(1000),
+				),
+				fields.New(
+					"max-variations",
+					fields.TypeInteger,
+					fields.WithHelp("Maximum example messages per template"),
+					fields.WithDefault(5),
+				),
+				fields.New(
+					"min-cluster-size",
+					fields.TypeInteger,
+					fields.WithHelp("Messages a sender/subject pattern needs for its own template"),
+					fields.WithDefault(2),
+				),
+				fields.New(
+					"body-chars",
+					fields.TypeInteger,
+					fields.WithHelp("Truncate example bodies to this many characters (0 leaves bodies out)"),
+					fields.WithDefault(400),
+				),
+			),
+			cmds.WithSections(imapSection),
+		),
+	}, nil
+}
+
+func (c *LearnCommand) RunIntoWriter(
+	ctx context.Context,
+	parsedValues *values.Values,
+	w io.Writer,
+) error {
+	settings := &LearnSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	rule := &dsl.Rule{Name: "learn"}
+	if settings.RuleFile != "" {
+		var err error
+		rule, err = dsl.ParseRuleFile(settings.RuleFile)
+		if err != nil {
+			return fmt.Errorf("error parsing rule file: %w", err)
+		}
+	}
+	// Only the envelope and the text parts are needed, whatever the rule outputs
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}}
+	if settings.BodyChars > 0 {
+		rule.Output.Fields = append(rule.Output.Fields, dsl.Field{
+			Name:    "mime_parts",
+			Content: &dsl.ContentField{Mode: "text_only", MaxLength: settings.BodyChars},
+		})
+	}
+	rule.Output.GroupByThread = false
+	rule.Output.Template = ""
+	if rule.Output.Limit == 0 {
+		rule.Output.Limit = settings.Limit
+	}
+
+	var msgs []*dsl.EmailMessage
+	var err error
+	if settings.Mbox != "" {
+		msgs, err = c.readLocal(rule, settings.Mbox)
+	} else {
+		msgs, err = c.fetch(rule, settings)
+	}
+	if err != nil {
+		return err
+	}
+
+	config := mailgen.Learn(msgs, mailgen.LearnOptions{
+		MaxVariations:  settings.MaxVariations,
+		MinClusterSize: settings.MinClusterSize,
+		BodyChars:      settings.BodyChars,
+	})
+	if len(config.Generate) == 0 {
+		return fmt.Errorf("no messages to learn from")
+	}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("error marshaling template config: %w", err)
+	}
+	header := fmt.Sprintf("# Learned from %d messages in %d templates\n", len(msgs), len(config.Generate))
+	data = append([]byte(header), data...)
+
+	if settings.Out != "" {
+		if err := os.WriteFile(settings.Out, data, 0o644); err != nil {
+			return fmt.Errorf("error writing template config: %w", err)
+		}
+		log.Info().
+			Int("messages", len(msgs)).
+			Int("templates", len(config.Generate)).
+			Str("path", settings.Out).
+			Msg("Wrote template config")
+		return nil
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (c *LearnCommand) readLocal(rule *dsl.Rule, path string) ([]*dsl.EmailMessage, error) {
+	localMessages, err := dsl.ReadLocalMessages(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading local messages: %w", err)
+	}
+	msgs, err := rule.FilterLocalMessages(localMessages)
+	if err != nil {
+		return nil, fmt.Errorf("error matching messages: %w", err)
+	}
+	return msgs, nil
+}
+
+func (c *LearnCommand) fetch(rule *dsl.Rule, settings *LearnSettings) ([]*dsl.EmailMessage, error) {
+	if settings.Password == "" {
+		return nil, fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if _, err := dsl.SelectMailbox(client, settings.Mailbox); err != nil {
+		return nil, fmt.Errorf("error selecting mailbox: %w", err)
+	}
+	msgs, err := rule.FetchMessages(client)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching messages from %s: %w", settings.Mailbox, err)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+type LintCommand struct {
+	*cmds.CommandDescription
+}
+
+type LintSettings struct {
+	RuleFiles []string `glazed:"rules"`
+	FailOn    string   `glazed:"fail-on"`
+	Severity  string   `glazed:"min-severity"`
+}
+
+func NewLintCommand() (*LintCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	severities := []string{dsl.SeverityError, dsl.SeverityWarning, dsl.SeverityInfo}
+	return &LintCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"lint",
+			cmds.WithShort("Check rules for criteria and actions that do not do what they read like"),
+			cmds.WithLong(`Check rule files beyond schema validation, without connecting to an IMAP
+server:
+
+  smailnail lint rules/*.yaml --output json
+
+The checks, with their code:
+
+  substring_match   subject and address criteria IMAP matches as substrings
+  single_condition  and/or operators with a single condition
+  unbounded_delete  delete without output.limit (an error unless to Trash)
+  delete_false      delete: false, which still deletes
+  unknown_field     output fields that are neither built-in nor paths
+  ambiguous_date    slash dates such as 01/02/2006 that read both ways
+
+Rule files that fail validation are reported as invalid_rule errors. One row
+is output per issue; the command exits with code 6 if an issue is at least as
+severe as --fail-on.`),
+			cmds.WithFlags(
+				fields.New(
+					"fail-on",
+					fields.TypeChoice,
+					fields.WithHelp("Lowest severity that makes the command fail"),
+					fields.WithChoices(severities...),
+					fields.WithDefault(dsl.SeverityError),
+				),
+				fields.New(
+					"min-severity",
+					fields.TypeChoice,
+					fields.WithHelp("Lowest severity to output"),
+					fields.WithChoices(severities...),
+					fields.WithDefault(dsl.SeverityInfo),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rules",
+					fields.TypeStringList,
+					fields.WithHelp("Paths to YAML rule files"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *LintCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &LintSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	failing := 0
+	for _, file := range settings.RuleFiles {
+		ruleName := ""
+		var issues []dsl.LintIssue
+		rule, err := dsl.ParseRuleFile(file)
+		if err != nil {
+			issues = []dsl.LintIssue{{
+				Severity: dsl.SeverityError,
+				Code:     "invalid_rule",
+				Message:  err.Error(),
+			}}
+		} else {
+			ruleName = rule.Name
+			issues = rule.Lint()
+		}
+
+		for _, issue := range issues {
+			if dsl.SeverityRank(issue.Severity) >= dsl.SeverityRank(settings.FailOn) {
+				failing++
+			}
+			if dsl.SeverityRank(issue.Severity) < dsl.SeverityRank(settings.Severity) {
+				continue
+			}
+			row := types.NewRow(
+				types.MRP("file", file),
+				types.MRP("rule", ruleName),
+				types.MRP("severity", issue.Severity),
+				types.MRP("code", issue.Code),
+				types.MRP("path", issue.Path),
+				types.MRP("message", issue.Message),
+			)
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding row to processor: %w", err)
+			}
+		}
+	}
+
+	if failing > 0 {
+		exitWithCode(ctx, gp, &ExitError{
+			Code: ExitLintIssues,
+			Err:  fmt.Errorf("%d issues at or above %s", failing, settings.FailOn),
+		})
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package commands
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/v2/imapclient"
@@ -17,9 +21,14 @@ import (
 	"github.com/go-go-golems/glazed/pkg/settings"
 	"github.com/go-go-golems/glazed/pkg/types"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/go-go-golems/smailnail/pkg/dsl"
 	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/go-go-golems/smailnail/pkg/smtp"
+	"github.com/go-go-golems/smailnail/pkg/tracing"
 	"gopkg.in/yaml.v3"
 )
 
@@ -28,10 +37,26 @@ type MailRulesCommand struct {
 }
 
 type MailRulesSettings struct {
-	RuleFile             string `glazed:"rule"`
-	ConcatenateMimeParts bool   `glazed:"concatenate-mime-parts"`
-	PrintRule            bool   `glazed:"print-rule"`
+	RuleFiles            []string `glazed:"rule"`
+	Tags                 []string `glazed:"tags"`
+	Env                  string   `glazed:"env"`
+	Parallel             int      `glazed:"parallel"`
+	MaxConnections       int      `glazed:"max-connections"`
+	Dedup                bool     `glazed:"dedup"`
+	Watch                string   `glazed:"watch"`
+	Lock                 string   `glazed:"lock"`
+	LockTTL              string   `glazed:"lock-ttl"`
+	ConcatenateMimeParts bool     `glazed:"concatenate-mime-parts"`
+	PrintRule            bool     `glazed:"print-rule"`
+	Summary              string   `glazed:"summary"`
+	FailOnEmpty          bool     `glazed:"fail-on-empty"`
+	ProtectedMailboxes   []string `glazed:"protected-mailboxes"`
+	Me                   []string `glazed:"me"`
+	Progress             bool     `glazed:"progress"`
+	RawMailboxNames      bool     `glazed:"raw-mailbox-names"`
 	imap.IMAPSettings
+	SMTP    smtp.SMTPSettings
+	Tracing tracing.TracingSettings
 }
 
 func NewMailRulesCommand() (*MailRulesCommand, error) {
@@ -45,18 +70,125 @@ func NewMailRulesCommand() (*MailRulesCommand, error) {
 		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
 	}
 
+	smtpSection, err := smtp.NewSMTPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SMTP section: %w", err)
+	}
+
+	tracingSection, err := tracing.NewTracingSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing section: %w", err)
+	}
+
 	return &MailRulesCommand{
 		CommandDescription: cmds.NewCommandDescription(
 			"mail-rules",
 			cmds.WithShort("Process mail rules on an IMAP server"),
-			cmds.WithLong("This command connects to an IMAP server and processes mail rules defined in a YAML file"),
+			cmds.WithLong(`This command connects to an IMAP server and processes mail rules defined in a YAML file.
+
+--rule can be repeated to run several rules. Rules run concurrently, up to
+--parallel at a time, each on its own connection. A rule listing other rules
+in depends_on starts once they succeeded and is skipped if one failed. Rules
+acting on the same mailbox (the one they search, or a fixed move_to, copy_to
+or snooze folder) never run at the same time. Output rows then start with a
+rule column.
+
+Rules with enabled: false are skipped. --tags runs only the rules with one
+of the given tags (--tags cleanup,weekly), together with the rules they
+depend on.
+
+--env prod merges rules.prod.yaml, when it exists, over each rule file
+rules.yaml, to change mailboxes, limits or set dry_run: true per
+environment. A rule with dry_run: true outputs its messages without running
+its actions.
+
+A rule with input_from: other-rule searches only among the messages the
+other rule fetched, after it ran, for staged pipelines.
+
+Rules run by ascending priority. Once the actions of a rule with
+actions.stop succeeded, the later rules skip its messages.
+
+--dedup keeps rules with overlapping searches from acting twice on a
+message: once a rule's actions succeeded, the later rules with actions skip
+its messages. Rules with dedup: false are left out.
+
+--watch 5m runs the rules every 5 minutes until interrupted. Changed rule
+files and overlays, or a SIGHUP, reload the rules; rules that fail to parse
+or validate are logged and the previous rules keep running.
+
+--max-connections caps the IMAP connections open at once, for providers
+limiting them per account; rules then wait for a free connection in turn,
+and --parallel is lowered to fit.
+
+--lock file:/shared/smailnail.lock or --lock imap:smailnail-lock lets
+several instances share an account: only the one holding the lock runs the
+rules that delete, move or snooze messages, the others skip them. The lock
+is a lease of --lock-ttl, renewed during the run and released at its end.
+
+--summary writes a JSON run summary (matched and fetched counts, the outcome
+of each action, errors) to a file, or to stderr with "-"; with several rules
+it is an array with one summary per rule. The exit code is 0 on success, 3
+when messages matched but an action failed, 4 when nothing matched and
+--fail-on-empty is set, and 1 for any other error.
+
+--otlp-endpoint exports OpenTelemetry traces of each rule run, with spans for
+the search, each fetch batch and each action, to an OTLP/HTTP collector.
+
+Mailbox names in rules are logical: levels are separated by "/" and the
+server's personal namespace prefix is added, so INBOX/Receipts becomes
+INBOX.Receipts on a server with the prefix "INBOX." and the delimiter ".".
+--raw-mailbox-names uses the names as written.`),
 			cmds.WithFlags(
 				fields.New(
 					"rule",
-					fields.TypeString,
-					fields.WithHelp("Path to YAML rule file"),
+					fields.TypeStringList,
+					fields.WithHelp("Path to YAML rule file, can be repeated"),
 					fields.WithRequired(true),
 				),
+				fields.New(
+					"tags",
+					fields.TypeStringList,
+					fields.WithHelp("Only run the rules with one of these tags, and the rules they depend on"),
+				),
+				fields.New(
+					"env",
+					fields.TypeString,
+					fields.WithHelp("Merge the overlay of each rule file for this environment, e.g. prod reads rules.prod.yaml next to rules.yaml"),
+				),
+				fields.New(
+					"parallel",
+					fields.TypeInteger,
+					fields.WithHelp("Maximum number of rules run at once"),
+					fields.WithDefault(4),
+				),
+				fields.New(
+					"max-connections",
+					fields.TypeInteger,
+					fields.WithHelp("Maximum number of IMAP connections open at once, 0 for no limit"),
+					fields.WithDefault(0),
+				),
+				fields.New(
+					"watch",
+					fields.TypeString,
+					fields.WithHelp("Run the rules repeatedly at this interval (e.g. 5m), reloading changed rule files"),
+				),
+				fields.New(
+					"lock",
+					fields.TypeString,
+					fields.WithHelp("Only run rules that delete, move or snooze messages while holding this lock: file:PATH or imap:MAILBOX"),
+				),
+				fields.New(
+					"lock-ttl",
+					fields.TypeString,
+					fields.WithHelp("How long the lock is held before another instance may take it, renewed while the rules run"),
+					fields.WithDefault("15m"),
+				),
+				fields.New(
+					"dedup",
+					fields.TypeBool,
+					fields.WithHelp("Skip the messages another rule of the run already acted on"),
+					fields.WithDefault(false),
+				),
 				fields.New(
 					"concatenate-mime-parts",
 					fields.TypeBool,
@@ -69,8 +201,42 @@ func NewMailRulesCommand() (*MailRulesCommand, error) {
 					fields.WithHelp("Print the rule instead of executing it"),
 					fields.WithDefault(false),
 				),
+				fields.New(
+					"summary",
+					fields.TypeString,
+					fields.WithHelp("Write a JSON run summary to this file (- for stderr)"),
+				),
+				fields.New(
+					"fail-on-empty",
+					fields.TypeBool,
+					fields.WithHelp("Exit with code 4 when no messages match"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"protected-mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Glob patterns of mailboxes rules may not delete, move or snooze messages out of unless they set allow_protected"),
+					fields.WithDefault(dsl.DefaultProtectedMailboxes),
+				),
+				fields.New(
+					"me",
+					fields.TypeStringList,
+					fields.WithHelp("Your own addresses, for search.to_me (default: the IMAP username)"),
+				),
+				fields.New(
+					"progress",
+					fields.TypeBool,
+					fields.WithHelp("Show the progress of the rules on stderr"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"raw-mailbox-names",
+					fields.TypeBool,
+					fields.WithHelp("Use the mailbox names of rules as written instead of translating them with the server's namespace"),
+					fields.WithDefault(false),
+				),
 			),
-			cmds.WithSections(glazedSection, imapSection),
+			cmds.WithSections(glazedSection, imapSection, smtpSection, tracingSection),
 		),
 	}, nil
 }
@@ -84,28 +250,63 @@ func (c *MailRulesCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if err := parsedValues.DecodeSectionInto(smtp.SMTPSectionSlug, &settings.SMTP); err != nil {
+		return err
+	}
+	if err := parsedValues.DecodeSectionInto(tracing.TracingSectionSlug, &settings.Tracing); err != nil {
 		return err
 	}
 
-	// Parse rule file
-	rule, err := c.parseRuleFile(settings.RuleFile)
+	rules, err := c.loadRules(settings)
 	if err != nil {
-		return fmt.Errorf("error parsing rule file: %w", err)
+		return err
 	}
 
-	// If print-rule is set, output the rule and return
-	if settings.PrintRule {
-		yamlData, err := yaml.Marshal(rule)
-		if err != nil {
-			return fmt.Errorf("error marshaling rule to YAML: %w", err)
+	var interval time.Duration
+	if settings.Watch != "" {
+		interval, err = time.ParseDuration(settings.Watch)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid --watch interval: %s", settings.Watch)
+		}
+		if settings.Progress {
+			return fmt.Errorf("--progress cannot be combined with --watch")
+		}
+	}
+	if settings.MaxConnections < 0 {
+		return fmt.Errorf("invalid --max-connections: %d", settings.MaxConnections)
+	}
+	if settings.MaxConnections == 1 && strings.HasPrefix(settings.Lock, "imap:") {
+		return fmt.Errorf("--lock imap: holds a connection of its own, --max-connections must be at least 2")
+	}
+	if settings.Lock != "" {
+		if _, err := dsl.ParseLockSpec(settings.Lock, "", nil); err != nil {
+			return err
 		}
+		if ttl, err := time.ParseDuration(settings.LockTTL); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid --lock-ttl: %s", settings.LockTTL)
+		}
+	}
+	if len(rules) == 0 && interval == 0 {
+		return nil
+	}
 
-		// Create a row with the YAML data
-		row := types.NewRow()
-		row.Set("rule", string(yamlData))
-		if err := gp.AddRow(ctx, row); err != nil {
-			return fmt.Errorf("error adding rule to output: %w", err)
+	// If print-rule is set, output the rules and return
+	if settings.PrintRule {
+		for _, rule := range rules {
+			yamlData, err := yaml.Marshal(rule)
+			if err != nil {
+				return fmt.Errorf("error marshaling rule to YAML: %w", err)
+			}
+
+			// Create a row with the YAML data
+			row := types.NewRow()
+			row.Set("rule", string(yamlData))
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding rule to output: %w", err)
+			}
 		}
 		return nil
 	}
@@ -115,182 +316,633 @@ func (c *MailRulesCommand) RunIntoGlazeProcessor(
 		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
 	}
 
-	// Connect to IMAP server
-	client, err := settings.ConnectToIMAPServer()
+	shutdownTracing, err := settings.Tracing.Setup(ctx)
+	if err != nil {
+		return err
+	}
+	flushTraces := func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("Failed to flush traces")
+		}
+	}
+	defer flushTraces()
+
+	if interval > 0 {
+		return c.watchRules(ctx, gp, rules, settings, interval)
+	}
+	err = c.runRules(ctx, gp, rules, settings)
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		// os.Exit skips the deferred flush
+		flushTraces()
+		exitWithCode(ctx, gp, exitErr)
+	}
+	return err
+}
+
+// loadRules parses the rule files and selects the rules to run, checking
+// them before anything runs. No rules and no error means all rules are
+// disabled.
+func (c *MailRulesCommand) loadRules(settings *MailRulesSettings) ([]*dsl.Rule, error) {
+	var rules []*dsl.Rule
+	for _, path := range settings.RuleFiles {
+		rule, err := c.parseRuleFile(path, settings.Env)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing rule file %s: %w", path, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := dsl.ValidateDependencies(rules); err != nil {
+		return nil, err
+	}
+	rules, skipped, err := dsl.SelectRules(rules, settings.Tags)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range skipped {
+		log.Info().Str("rule", rule.Name).Msg("Skipping disabled rule")
+	}
+	dsl.SortRulesByPriority(rules)
+	if err := linkInputs(rules, settings.Mailbox); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 && len(settings.Tags) > 0 {
+		return nil, fmt.Errorf("no enabled rule has one of the tags %s", strings.Join(settings.Tags, ", "))
+	}
+
+	// Refuse destructive rules on protected mailboxes before anything runs
+	if err := dsl.ValidateMailboxPatterns(settings.ProtectedMailboxes); err != nil {
+		return nil, fmt.Errorf("invalid --protected-mailboxes: %w", err)
+	}
+	for _, rule := range rules {
+		if err := rule.CheckProtected(rule.SourceMailbox(settings.Mailbox), settings.ProtectedMailboxes); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// runRules runs the rules once. The error is an *ExitError when the exit
+// code is not 1.
+func (c *MailRulesCommand) runRules(
+	ctx context.Context,
+	gp middlewares.Processor,
+	rules []*dsl.Rule,
+	settings *MailRulesSettings,
+) error {
+	me := myAddresses(settings.Me, settings.Username)
+	sender := newSender(settings.SMTP, &settings.IMAPSettings)
+	handled, processed := dsl.NewHandledMessages(), dsl.NewHandledMessages()
+	for _, rule := range rules {
+		rule.SetMyAddresses(me)
+		rule.SetHandled(handled)
+		// Rules without actions only list messages, they are left out
+		if settings.Dedup && rule.Deduplicates() && !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
+			rule.SetProcessed(processed)
+		}
+		if sender != nil {
+			rule.SetSender(sender)
+		}
+	}
+
+	if settings.Progress {
+		progress := newProgressBar(os.Stderr)
+		for _, rule := range rules {
+			rule.Subscribe(progress)
+		}
+		defer progress.finish()
+	}
+
+	out := &ruleOutput{gp: gp, tagRule: len(rules) > 1, flags: dsl.NewFlagTally()}
+	pool := &clientPool{settings: &settings.IMAPSettings, max: settings.MaxConnections}
+	defer pool.close()
+
+	client, err := pool.get()
 	if err != nil {
 		return fmt.Errorf("error connecting to IMAP server: %w", err)
 	}
-	defer func() {
-		_ = client.Close()
+	err = prepareRules(client, rules, settings)
+	pool.put(client, err)
+	if err != nil {
+		return err
+	}
+
+	locked, lockConnections := true, 0
+	if settings.Lock != "" && slices.ContainsFunc(rules, (*dsl.Rule).RemovesMessages) {
+		var release func()
+		locked, release, err = acquireRunLock(pool, settings)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if locked && strings.HasPrefix(settings.Lock, "imap:") {
+			lockConnections = 1
+		}
+	}
+
+	// Each running rule holds a connection, and so does an IMAP lock
+	parallel := settings.Parallel
+	if settings.MaxConnections > 0 {
+		parallel = min(parallel, settings.MaxConnections-lockConnections)
+	}
+
+	summaries := make([]*dsl.RunSummary, len(rules))
+	for i, rule := range rules {
+		summaries[i] = &dsl.RunSummary{Rule: rule.Name, Mailbox: rule.SourceMailbox(settings.Mailbox)}
+	}
+	summaryOf := func(rule *dsl.Rule) *dsl.RunSummary {
+		for i := range rules {
+			if rules[i] == rule {
+				return summaries[i]
+			}
+		}
+		return nil
+	}
+
+	results, err := dsl.RunRules(ctx, rules, settings.Mailbox, parallel,
+		func(ctx context.Context, rule *dsl.Rule) error {
+			if !locked && rule.RemovesMessages() {
+				log.Warn().Str("rule", rule.Name).Msg("Skipping rule, another instance holds the lock")
+				return nil
+			}
+			summary := summaryOf(rule)
+			start := time.Now()
+			ctx, span := otel.Tracer(dsl.TracerName).Start(ctx, "smailnail.rule", trace.WithAttributes(
+				dsl.AttrRule.String(rule.Name),
+				dsl.AttrMailbox.String(summary.Mailbox),
+			))
+			rule.SetTraceContext(ctx)
+			defer func() {
+				summary.DurationMs = time.Since(start).Milliseconds()
+				span.End()
+			}()
+
+			client, err := pool.get()
+			if err != nil {
+				return fmt.Errorf("error connecting to IMAP server: %w", err)
+			}
+			err = c.runRule(ctx, out, client, rule, summary.Mailbox, settings, summary)
+			pool.put(client, err)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	if err := addFlagSummaryRows(ctx, gp, rules, out.flags); err != nil {
+		return err
+	}
+
+	// The exit code is that of the most severe outcome: an error, then a
+	// failed action, then no matches
+	var runErr error
+	fetched := 0
+	for i, result := range results {
+		summary := summaries[i]
+		fetched += summary.Fetched
+		if result.Err == nil {
+			continue
+		}
+		summary.Errors = append(summary.Errors, result.Err.Error())
+		summary.ExitCode = 1
+		var exitErr *ExitError
+		if errors.As(result.Err, &exitErr) {
+			summary.ExitCode = exitErr.Code
+		}
+		if runErr == nil || summary.ExitCode == 1 {
+			runErr = result.Err
+			if len(rules) > 1 {
+				runErr = &ExitError{Code: summary.ExitCode, Err: fmt.Errorf("rule %s: %w", result.Rule.Name, result.Err)}
+			}
+		}
+	}
+	if runErr == nil && settings.FailOnEmpty && fetched == 0 {
+		names := make([]string, len(rules))
+		for i, rule := range rules {
+			names[i] = rule.Name
+		}
+		runErr = &ExitError{Code: ExitNoMatches, Err: fmt.Errorf("rule %s matched no messages", strings.Join(names, ", "))}
+		for _, summary := range summaries {
+			summary.Errors = append(summary.Errors, runErr.Error())
+			summary.ExitCode = ExitNoMatches
+		}
+	}
+
+	if settings.Summary != "" {
+		var summaryErr error
+		if len(summaries) == 1 {
+			summaryErr = writeRunSummary(settings.Summary, summaries[0])
+		} else {
+			summaryErr = writeRunSummary(settings.Summary, summaries)
+		}
+		if summaryErr != nil && runErr == nil {
+			runErr = summaryErr
+		}
+	}
+	return runErr
+}
+
+// acquireRunLock takes --lock for a run. It returns whether the lock was
+// acquired and how to release it.
+func acquireRunLock(pool *clientPool, settings *MailRulesSettings) (bool, func(), error) {
+	ttl, err := time.ParseDuration(settings.LockTTL)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid --lock-ttl: %s", settings.LockTTL)
+	}
+
+	// The IMAP lock keeps its connection until it is released
+	var client *imapclient.Client
+	if strings.HasPrefix(settings.Lock, "imap:") {
+		client, err = pool.get()
+		if err != nil {
+			return false, nil, fmt.Errorf("error connecting to IMAP server: %w", err)
+		}
+	}
+	done := func(err error) {
+		if client != nil {
+			pool.put(client, err)
+		}
+	}
+
+	lock, err := dsl.ParseLockSpec(settings.Lock, dsl.DefaultLockHolder(), client)
+	if err != nil {
+		done(err)
+		return false, nil, err
+	}
+	acquired, holder, err := lock.Acquire(ttl)
+	if err != nil {
+		done(err)
+		return false, nil, fmt.Errorf("error acquiring lock %s: %w", settings.Lock, err)
+	}
+	if !acquired {
+		done(nil)
+		log.Warn().
+			Str("lock", settings.Lock).
+			Str("holder", holder).
+			Msg("Lock is held by another instance, skipping rules that delete, move or snooze messages")
+		return false, func() {}, nil
+	}
+
+	log.Debug().Str("lock", settings.Lock).Msg("Acquired lock")
+	stopRenewing := renewLock(lock, ttl, settings.Lock)
+	return true, func() {
+		stopRenewing()
+		err := lock.Release()
+		if err != nil {
+			log.Warn().Err(err).Str("lock", settings.Lock).Msg("Failed to release lock")
+		}
+		done(err)
+	}, nil
+}
+
+// renewLock extends the lease of lock every third of ttl, so that runs
+// longer than ttl keep it. The returned function stops the renewals.
+func renewLock(lock dsl.Lock, ttl time.Duration, name string) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(max(ttl/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			acquired, holder, err := lock.Acquire(ttl)
+			switch {
+			case err != nil:
+				log.Warn().Err(err).Str("lock", name).Msg("Failed to renew lock")
+			case !acquired:
+				log.Warn().Str("lock", name).Str("holder", holder).Msg("Lost lock to another instance")
+			}
+		}
 	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// prepareRules translates the mailbox names of the rules with the server's
+// namespace and refuses to run when the account lacks the rights a rule
+// needs.
+func prepareRules(client *imapclient.Client, rules []*dsl.Rule, settings *MailRulesSettings) error {
+	if !settings.RawMailboxNames {
+		namespace, err := dsl.LoadNamespace(client)
+		if err != nil {
+			return err
+		}
+		log.Debug().
+			Str("prefix", namespace.Prefix).
+			Str("delimiter", string(namespace.Delimiter)).
+			Msg("Translating mailbox names with the server namespace")
+		for _, rule := range rules {
+			rule.SetNamespace(namespace)
+		}
+	}
+
+	for _, rule := range rules {
+		if err := rule.CheckRights(client, settings.Mailbox); err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+// runRule runs rule on mailbox, filling in summary.
+func (c *MailRulesCommand) runRule(
+	ctx context.Context,
+	out *ruleOutput,
+	client *imapclient.Client,
+	rule *dsl.Rule,
+	mailbox string,
+	settings *MailRulesSettings,
+	summary *dsl.RunSummary,
+) error {
+	mailbox = rule.MailboxName(mailbox)
+
+	// Short-circuit on the mailbox status before selecting it
+	skip, err := rule.ShouldSkip(client, mailbox)
+	if err != nil {
+		return fmt.Errorf("error checking mailbox status: %w", err)
+	}
+	if skip {
+		summary.Skipped = true
+		return nil
+	}
 
 	// Select mailbox
-	if err := c.selectMailbox(client, settings.Mailbox); err != nil {
+	selectData, err := dsl.SelectMailbox(client, mailbox)
+	if err != nil {
 		return fmt.Errorf("error selecting mailbox: %w", err)
 	}
+	if err := rule.CheckKeywords(selectData); err != nil {
+		return fmt.Errorf("error checking keywords of mailbox %s: %w", mailbox, err)
+	}
 
-	msgs, err := rule.FetchMessages(client)
+	// Until its backfill is done, the rule processes a batch of older mail
+	// instead of its usual search
+	backfill, err := rule.NextBackfill(mailbox, selectData)
 	if err != nil {
-		return fmt.Errorf("error fetching messages: %w", err)
+		return err
+	}
+	if backfill != nil {
+		rule = backfill.Rule
+		summary.Backfill = true
 	}
 
-	for _, msg := range msgs {
-		// Create a new row for each message
-		row := types.NewRow()
-
-		// Process each field according to the rule's output configuration
-		for _, fieldInterface := range rule.Output.Fields {
-			field, ok := fieldInterface.(dsl.Field)
-			if !ok {
-				continue
+	var msgs []*dsl.EmailMessage
+	if rule.Output.FlagSummary {
+		// Only the flags are counted, the rows are added once all rules ran
+		msgs, err = rule.FetchMessages(client)
+		if err != nil {
+			return fmt.Errorf("error fetching messages: %w", err)
+		}
+		tallyMailbox := ""
+		if rule.Output.FlagSummaryPerMailbox {
+			tallyMailbox = mailbox
+		}
+		out.flags.Add(tallyMailbox, msgs)
+	} else if rule.Output.Format == "ndjson" && !rule.Output.GroupByThread {
+		// Stream one JSON object per message to stdout as soon as it is
+		// processed, bypassing the (buffering) glazed output
+		err = rule.StreamMessages(client, func(msg *dsl.EmailMessage) error {
+			msgs = append(msgs, msg)
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-
-			switch field.Name {
-			case "uid":
-				row.Set("uid", msg.UID)
-			case "subject":
-				if msg.Envelope != nil {
-					row.Set("subject", msg.Envelope.Subject)
-				}
-			case "from":
-				if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-					from := msg.Envelope.From[0]
-					row.Set("from", fmt.Sprintf("%s <%s>", from.Name, from.Address))
-				}
-			case "to":
-				if msg.Envelope != nil && len(msg.Envelope.To) > 0 {
-					var toAddresses []string
-					for _, to := range msg.Envelope.To {
-						toAddresses = append(toAddresses, fmt.Sprintf("%s <%s>", to.Name, to.Address))
-					}
-					row.Set("to", strings.Join(toAddresses, ", "))
-				}
-			case "date":
-				if msg.Envelope != nil {
-					row.Set("date", msg.Envelope.Date.Format(time.RFC3339))
-				}
-			case "flags":
-				row.Set("flags", strings.Join(msg.Flags, ", "))
-			case "size":
-				row.Set("size", msg.Size)
-			case "mime_parts":
-				if field.Content != nil && len(msg.MimeParts) > 0 {
-					log.Debug().
-						Str("mode", field.Content.Mode).
-						Strs("types", field.Content.Types).
-						Int("num_parts", len(msg.MimeParts)).
-						Msg("Processing MIME parts with content field configuration")
-
-					if settings.ConcatenateMimeParts {
-						// Concatenate all matching MIME parts into a single content string
-						var contents []string
-						for _, part := range msg.MimeParts {
-							// Fix: Only add slash if Subtype is not empty
-							mimeType := part.Type
-							if part.Subtype != "" {
-								mimeType = part.Type + "/" + part.Subtype
-							}
-							shouldInclude := field.Content.ShouldInclude(mimeType)
-							log.Debug().
-								Str("mime_type", mimeType).
-								Bool("should_include", shouldInclude).
-								Bool("show_content", field.Content.ShowContent).
-								Int("content_length", len(part.Content)).
-								Msg("Evaluating MIME part for inclusion")
-
-							if shouldInclude {
-								if field.Content.ShowContent && part.Content != "" {
-									contents = append(contents, part.Content)
-									log.Debug().
-										Str("mime_type", mimeType).
-										Int("content_length", len(part.Content)).
-										Msg("Added MIME part content")
-								}
-							}
-						}
-						content := strings.Join(contents, "\n\n")
-						if field.Content.MaxLength > 0 && len(content) > field.Content.MaxLength {
-							content = content[:field.Content.MaxLength] + "..."
-						}
-						row.Set("content", content)
-						log.Debug().
-							Int("total_parts", len(msg.MimeParts)).
-							Int("matched_parts", len(contents)).
-							Int("final_content_length", len(content)).
-							Msg("Finished processing MIME parts")
-					} else {
-						// Original structured MIME parts output
-						var parts []map[string]interface{}
-						for _, part := range msg.MimeParts {
-							// Fix: Only add slash if Subtype is not empty
-							mimeType := part.Type
-							if part.Subtype != "" {
-								mimeType = part.Type + "/" + part.Subtype
-							}
-							shouldInclude := field.Content.ShouldInclude(mimeType)
-							log.Debug().
-								Str("mime_type", mimeType).
-								Bool("should_include", shouldInclude).
-								Bool("show_content", field.Content.ShowContent).
-								Int("content_length", len(part.Content)).
-								Msg("Evaluating MIME part for structured output")
-
-							if shouldInclude {
-								partMap := map[string]interface{}{
-									"type":    mimeType,
-									"size":    part.Size,
-									"charset": part.Charset,
-								}
-								if part.Filename != "" {
-									partMap["filename"] = part.Filename
-								}
-
-								content := part.Content
-								if field.Content.MaxLength > 0 && len(content) > field.Content.MaxLength {
-									content = content[:field.Content.MaxLength] + "..."
-								}
-								partMap["content"] = content
-
-								parts = append(parts, partMap)
-								log.Debug().
-									Str("mime_type", mimeType).
-									Int("content_length", len(content)).
-									Msg("Added structured MIME part")
-							}
-						}
-						row.Set("mime_parts", parts)
-						log.Debug().
-							Int("total_parts", len(msg.MimeParts)).
-							Int("matched_parts", len(parts)).
-							Msg("Finished processing structured MIME parts")
-					}
+			for _, row := range messageRows(rule, msg, settings.ConcatenateMimeParts) {
+				if err := out.encodeRow(rule, row); err != nil {
+					return err
 				}
 			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error streaming messages: %w", err)
+		}
+	} else if rule.Output.GroupByThread {
+		threads, err := rule.FetchThreads(client)
+		if err != nil {
+			return fmt.Errorf("error fetching threads: %w", err)
+		}
+		for _, thread := range threads {
+			msgs = append(msgs, thread.Messages...)
+		}
+		if err := addThreadRows(ctx, out, rule, threads); err != nil {
+			return err
+		}
+	} else {
+		msgs, err = rule.FetchMessages(client)
+		if err != nil {
+			return fmt.Errorf("error fetching messages: %w", err)
 		}
 
-		// Add the row to the processor
-		if err := gp.AddRow(ctx, row); err != nil {
-			return fmt.Errorf("error adding row to processor: %w", err)
+		for _, msg := range msgs {
+			// Add the rows to the processor
+			for _, row := range messageRows(rule, msg, settings.ConcatenateMimeParts) {
+				if err := out.addRow(ctx, rule, row); err != nil {
+					return fmt.Errorf("error adding row to processor: %w", err)
+				}
+			}
 		}
 	}
 
+	summary.Matched = rule.Matched()
+	summary.Fetched = len(msgs)
+
 	if !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
-		if err := dsl.ExecuteActions(client, msgs, &rule.Actions); err != nil {
-			return fmt.Errorf("error executing rule actions: %w", err)
+		results, err := rule.ExecuteActionsWithResults(client, msgs)
+		summary.Actions = results
+		if err != nil {
+			return &ExitError{Code: ExitActionFailures, Err: fmt.Errorf("error executing rule actions: %w", err)}
+		}
+	}
+
+	// A dry run leaves the batch to the next real run
+	if backfill != nil && !rule.DryRun {
+		if err := backfill.Finish(msgs); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (c *MailRulesCommand) parseRuleFile(path string) (*dsl.Rule, error) {
+// ruleOutput serializes the rows of rules running concurrently. When several
+// rules share the output, rows start with the name of their rule.
+type ruleOutput struct {
+	mu      sync.Mutex
+	gp      middlewares.Processor
+	tagRule bool
+	// flags counts the flags of the rules with a flag summary
+	flags *dsl.FlagTally
+}
+
+func (o *ruleOutput) tag(rule *dsl.Rule, row types.Row) types.Row {
+	if !o.tagRule {
+		return row
+	}
+	tagged := types.NewRow(types.MRP("rule", rule.Name))
+	for pair := row.Oldest(); pair != nil; pair = pair.Next() {
+		tagged.Set(pair.Key, pair.Value)
+	}
+	return tagged
+}
+
+func (o *ruleOutput) addRow(ctx context.Context, rule *dsl.Rule, row types.Row) error {
+	row = o.tag(rule, row)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.gp.AddRow(ctx, row)
+}
+
+// encodeRow writes row as a JSON line straight to stdout.
+func (o *ruleOutput) encodeRow(rule *dsl.Rule, row types.Row) error {
+	row = o.tag(rule, row)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := json.NewEncoder(os.Stdout).Encode(row); err != nil {
+		return fmt.Errorf("error writing JSON line: %w", err)
+	}
+	return nil
+}
+
+// clientPool hands out logged-in connections, reusing those of finished
+// rules. With max set, at most max connections are open at once and callers
+// wait for one in the order they asked.
+type clientPool struct {
+	settings *imap.IMAPSettings
+	// dial opens a connection, settings.ConnectToIMAPServer when nil
+	dial func() (*imapclient.Client, error)
+	// max caps the open connections, 0 for no cap
+	max  int
+	mu   sync.Mutex
+	idle []*imapclient.Client
+	open int
+	// waiting receive a connection, or nil for the slot of a closed one
+	waiting []chan *imapclient.Client
+}
+
+func (p *clientPool) get() (*imapclient.Client, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		client := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return client, nil
+	}
+	if p.max > 0 && p.open >= p.max {
+		handoff := make(chan *imapclient.Client, 1)
+		p.waiting = append(p.waiting, handoff)
+		p.mu.Unlock()
+		log.Debug().Int("max_connections", p.max).Msg("Waiting for a free IMAP connection")
+		if client := <-handoff; client != nil {
+			return client, nil
+		}
+	} else {
+		p.open++
+		p.mu.Unlock()
+	}
+
+	dial := p.dial
+	if dial == nil {
+		dial = p.settings.ConnectToIMAPServer
+	}
+	client, err := dial()
+	if err != nil {
+		p.release(nil)
+		return nil, err
+	}
+	return client, nil
+}
+
+// put returns client to the pool. Connections of rules that failed may be
+// in an unknown state and are closed instead.
+func (p *clientPool) put(client *imapclient.Client, err error) {
+	var exitErr *ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		_ = client.Close()
+		p.release(nil)
+		return
+	}
+	p.release(client)
+}
+
+// release hands client, or the slot of a closed connection when nil, to the
+// first waiting caller, or keeps it for the next one.
+func (p *clientPool) release(client *imapclient.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiting) > 0 {
+		handoff := p.waiting[0]
+		p.waiting = p.waiting[1:]
+		handoff <- client
+		return
+	}
+	if client == nil {
+		p.open--
+		return
+	}
+	p.idle = append(p.idle, client)
+}
+
+func (p *clientPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, client := range p.idle {
+		_ = client.Close()
+	}
+	p.open -= len(p.idle)
+	p.idle = nil
+}
+
+// newSender returns the SMTP sender for commands that send mail, nil without
+// --smtp-server. Credentials default to the IMAP ones.
+func newSender(smtpSettings smtp.SMTPSettings, imapSettings *imap.IMAPSettings) *smtp.Sender {
+	if smtpSettings.Server == "" {
+		return nil
+	}
+	if smtpSettings.Username == "" {
+		smtpSettings.Username = imapSettings.Username
+		if smtpSettings.Password == "" {
+			smtpSettings.Password = imapSettings.Password
+		}
+	}
+	return smtpSettings.NewSender()
+}
+
+// writeRunSummary writes summary as JSON to path, or to stderr for "-".
+func writeRunSummary(path string, summary interface{}) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling run summary: %w", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stderr.Write(data)
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing run summary: %w", err)
+	}
+	return nil
+}
+
+func (c *MailRulesCommand) parseRuleFile(path string, env string) (*dsl.Rule, error) {
 	// Check if file exists
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return nil, fmt.Errorf("rule file does not exist: %s", path)
 	}
 
-	// Parse rule file
-	rule, err := dsl.ParseRuleFile(path)
+	// Parse rule file, with the overlay of the environment
+	rule, err := dsl.ParseRuleFileForEnv(path, env)
 	if err != nil {
 		return nil, err
 	}
@@ -298,10 +950,303 @@ func (c *MailRulesCommand) parseRuleFile(path string) (*dsl.Rule, error) {
 	return rule, nil
 }
 
-func (c *MailRulesCommand) selectMailbox(client *imapclient.Client, mailbox string) error {
-	// Select mailbox
-	if _, err := client.Select(mailbox, nil).Wait(); err != nil {
-		return fmt.Errorf("failed to select mailbox %q: %w", mailbox, err)
+// addThreadRows emits one row per thread. NDJSON rows are written straight to
+// stdout like message rows.
+func addThreadRows(ctx context.Context, out *ruleOutput, rule *dsl.Rule, threads []*dsl.Thread) error {
+	for _, thread := range threads {
+		row := threadRow(rule, thread)
+		if rule.Output.Format == "ndjson" {
+			if err := out.encodeRow(rule, row); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := out.addRow(ctx, rule, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
+
+// addFlagSummaryRows adds a row per flag counted by the rules with a flag
+// summary, summed across rules. NDJSON rules stream them as JSON lines.
+func addFlagSummaryRows(ctx context.Context, gp middlewares.Processor, rules []*dsl.Rule, flags *dsl.FlagTally) error {
+	ndjson := false
+	summarized := false
+	for _, rule := range rules {
+		if rule.Output.FlagSummary {
+			summarized = true
+			ndjson = ndjson || rule.Output.Format == "ndjson"
+		}
+	}
+	if !summarized {
+		return nil
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, count := range flags.Counts() {
+		row := types.NewRow()
+		if count.Mailbox != "" {
+			row.Set("mailbox", count.Mailbox)
+		}
+		row.Set("flag", count.Flag)
+		row.Set("messages", count.Messages)
+		row.Set("total", count.Total)
+		if ndjson {
+			if err := encoder.Encode(row); err != nil {
+				return fmt.Errorf("error writing JSON line: %w", err)
+			}
+			continue
+		}
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
+
+// threadRow converts a conversation into a glazed row summarizing it.
+func threadRow(rule *dsl.Rule, thread *dsl.Thread) types.Row {
+	participants := make([]string, len(thread.Participants))
+	for i, addr := range thread.Participants {
+		if addr.Name != "" {
+			participants[i] = fmt.Sprintf("%s <%s>", addr.Name, addr.Address)
+		} else {
+			participants[i] = addr.Address
+		}
+	}
+
+	row := types.NewRow()
+	row.Set("thread_id", thread.ID)
+	row.Set("subject", thread.Subject)
+	row.Set("message_count", len(thread.Messages))
+	row.Set("participants", strings.Join(participants, ", "))
+	row.Set("first_date", rule.Output.FormatDate(thread.FirstDate))
+	row.Set("latest_date", rule.Output.FormatDate(thread.LatestDate))
+	row.Set("uids", thread.UIDs())
+	return row
+}
+
+// messageRows converts a fetched message into its glazed rows: the message
+// row, or with output.html_tables.rows one row per row of its HTML tables,
+// holding the other output fields, the table index and the table columns.
+func messageRows(rule *dsl.Rule, msg *dsl.EmailMessage, concatenateMimeParts bool) []types.Row {
+	row := messageRow(rule, msg, concatenateMimeParts)
+	if rule.Output.HTMLTables == nil || !rule.Output.HTMLTables.Rows {
+		return []types.Row{row}
+	}
+
+	var rows []types.Row
+	for i, table := range msg.HTMLTables {
+		columns := table.Columns()
+		for _, cells := range table.Rows {
+			tableRow := types.NewRow()
+			for pair := row.Oldest(); pair != nil; pair = pair.Next() {
+				if _, ok := pair.Value.([]dsl.HTMLTable); !ok {
+					tableRow.Set(pair.Key, pair.Value)
+				}
+			}
+			tableRow.Set("table", i)
+			for j, column := range columns {
+				value := ""
+				if j < len(cells) {
+					value = cells[j]
+				}
+				tableRow.Set(column, value)
+			}
+			rows = append(rows, tableRow)
+		}
+	}
+	return rows
+}
+
+// messageRow converts a fetched message into a glazed row following the rule's
+// output fields.
+func messageRow(rule *dsl.Rule, msg *dsl.EmailMessage, concatenateMimeParts bool) types.Row {
+	row := types.NewRow()
+
+	// Process each field according to the rule's output configuration
+	for _, fieldInterface := range rule.Output.Fields {
+		field, ok := fieldInterface.(dsl.Field)
+		if !ok {
+			continue
+		}
+
+		if field.IsPath() {
+			value, err := dsl.SelectPath(msg, field.Name)
+			if err == nil {
+				row.Set(field.OutputName(), value)
+			}
+			continue
+		}
+
+		// Aliased fields replace the default column name
+		column := func(defaultName string) string {
+			if field.As != "" {
+				return field.As
+			}
+			return defaultName
+		}
+
+		switch field.Name {
+		case "uid":
+			row.Set(column("uid"), msg.UID)
+		case "subject":
+			if msg.Envelope != nil {
+				row.Set(column("subject"), msg.Envelope.Subject)
+			}
+		case "from":
+			if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
+				from := msg.Envelope.From[0]
+				row.Set(column("from"), fmt.Sprintf("%s <%s>", from.Name, from.Address))
+			}
+		case "to":
+			if msg.Envelope != nil && len(msg.Envelope.To) > 0 {
+				var toAddresses []string
+				for _, to := range msg.Envelope.To {
+					toAddresses = append(toAddresses, fmt.Sprintf("%s <%s>", to.Name, to.Address))
+				}
+				row.Set(column("to"), strings.Join(toAddresses, ", "))
+			}
+		case "date":
+			if msg.Envelope != nil {
+				row.Set(column("date"), rule.Output.FormatDate(msg.Envelope.Date))
+			}
+		case "flags":
+			row.Set(column("flags"), strings.Join(msg.Flags, ", "))
+		case "size":
+			row.Set(column("size"), rule.Output.SizeValue(msg.Size))
+		case "list_id":
+			row.Set(column("list_id"), msg.ListID)
+		case "label":
+			row.Set(column("label"), msg.Label)
+		case "summary":
+			row.Set(column("summary"), msg.Summary)
+		case "language":
+			row.Set(column("language"), msg.Language)
+		case "html_tables":
+			if len(msg.HTMLTables) > 0 {
+				row.Set(column("html_tables"), msg.HTMLTables)
+			}
+		case "mime_parts":
+			if field.Content != nil && len(msg.MimeParts) > 0 {
+				log.Debug().
+					Str("mode", field.Content.Mode).
+					Strs("types", field.Content.Types).
+					Int("num_parts", len(msg.MimeParts)).
+					Msg("Processing MIME parts with content field configuration")
+
+				if concatenateMimeParts {
+					// Concatenate all matching MIME parts into a single content string
+					var contents []string
+					for _, part := range msg.MimeParts {
+						// Fix: Only add slash if Subtype is not empty
+						mimeType := part.Type
+						if part.Subtype != "" {
+							mimeType = part.Type + "/" + part.Subtype
+						}
+						shouldInclude := field.Content.ShouldInclude(mimeType)
+						log.Debug().
+							Str("mime_type", mimeType).
+							Bool("should_include", shouldInclude).
+							Bool("show_content", field.Content.ShowContent).
+							Int("content_length", len(part.Content)).
+							Msg("Evaluating MIME part for inclusion")
+
+						if shouldInclude {
+							if field.Content.ShowContent && part.Content != "" {
+								contents = append(contents, part.Content)
+								log.Debug().
+									Str("mime_type", mimeType).
+									Int("content_length", len(part.Content)).
+									Msg("Added MIME part content")
+							}
+						}
+					}
+					content := strings.Join(contents, "\n\n")
+					if field.Content.MaxLength > 0 && len(content) > field.Content.MaxLength {
+						content = content[:field.Content.MaxLength] + "..."
+					}
+					row.Set(column("content"), content)
+					log.Debug().
+						Int("total_parts", len(msg.MimeParts)).
+						Int("matched_parts", len(contents)).
+						Int("final_content_length", len(content)).
+						Msg("Finished processing MIME parts")
+				} else {
+					// Original structured MIME parts output
+					var parts []map[string]interface{}
+					for _, part := range msg.MimeParts {
+						// Fix: Only add slash if Subtype is not empty
+						mimeType := part.Type
+						if part.Subtype != "" {
+							mimeType = part.Type + "/" + part.Subtype
+						}
+						shouldInclude := field.Content.ShouldInclude(mimeType)
+						log.Debug().
+							Str("mime_type", mimeType).
+							Bool("should_include", shouldInclude).
+							Bool("show_content", field.Content.ShowContent).
+							Int("content_length", len(part.Content)).
+							Msg("Evaluating MIME part for structured output")
+
+						if shouldInclude {
+							partMap := map[string]interface{}{
+								"type":    mimeType,
+								"size":    part.Size,
+								"charset": part.Charset,
+							}
+							if part.Filename != "" {
+								partMap["filename"] = part.Filename
+							}
+
+							content := part.Content
+							if field.Content.MaxLength > 0 && len(content) > field.Content.MaxLength {
+								content = content[:field.Content.MaxLength] + "..."
+							}
+							partMap["content"] = content
+
+							parts = append(parts, partMap)
+							log.Debug().
+								Str("mime_type", mimeType).
+								Int("content_length", len(content)).
+								Msg("Added structured MIME part")
+						}
+					}
+					row.Set(column("mime_parts"), parts)
+					log.Debug().
+						Int("total_parts", len(msg.MimeParts)).
+						Int("matched_parts", len(parts)).
+						Msg("Finished processing structured MIME parts")
+				}
+			}
+		default:
+			if value, ok := msg.Extracted[field.Name]; ok {
+				row.Set(field.OutputName(), value)
+			}
+		}
+	}
+
+	return row
+}
+
+// linkInputs points the rules with input_from at their input rule, which has
+// to search the same mailbox for its UIDs to mean anything.
+func linkInputs(rules []*dsl.Rule, defaultMailbox string) error {
+	byName := make(map[string]*dsl.Rule, len(rules))
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+	for _, rule := range rules {
+		if rule.InputFrom == "" {
+			continue
+		}
+		from := byName[rule.InputFrom]
+		if from.SourceMailbox(defaultMailbox) != rule.SourceMailbox(defaultMailbox) {
+			return fmt.Errorf("rule %q takes its input from rule %q, which searches mailbox %s instead of %s",
+				rule.Name, from.Name, from.SourceMailbox(defaultMailbox), rule.SourceMailbox(defaultMailbox))
+		}
+		rule.SetInput(from)
 	}
 	return nil
 }
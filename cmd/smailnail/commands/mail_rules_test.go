@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDialer opens clients over pipes whose server side is never read.
+type fakeDialer struct {
+	t     *testing.T
+	mu    sync.Mutex
+	dials int
+	err   error
+}
+
+func (d *fakeDialer) dial() (*imapclient.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if d.err != nil {
+		return nil, d.err
+	}
+	conn, server := net.Pipe()
+	d.t.Cleanup(func() { _ = server.Close() })
+	return imapclient.New(conn, nil), nil
+}
+
+func (d *fakeDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+type poolResult struct {
+	client *imapclient.Client
+	err    error
+}
+
+// getAsync calls pool.get in a goroutine and returns its result channel.
+func getAsync(pool *clientPool) <-chan poolResult {
+	results := make(chan poolResult, 1)
+	go func() {
+		client, err := pool.get()
+		results <- poolResult{client, err}
+	}()
+	return results
+}
+
+// waitForWaiters waits until n callers wait for a connection.
+func waitForWaiters(t *testing.T, pool *clientPool, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return len(pool.waiting) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestClientPoolWaitsForMaxConnections(t *testing.T) {
+	dialer := &fakeDialer{t: t}
+	pool := &clientPool{dial: dialer.dial, max: 1}
+	defer pool.close()
+
+	first, err := pool.get()
+	require.NoError(t, err)
+
+	results := getAsync(pool)
+	waitForWaiters(t, pool, 1)
+	select {
+	case <-results:
+		t.Fatal("get returned while the only connection was in use")
+	default:
+	}
+
+	// The connection is handed to the waiting caller instead of a new one
+	pool.put(first, nil)
+	result := <-results
+	require.NoError(t, result.err)
+	assert.Same(t, first, result.client)
+	assert.Equal(t, 1, dialer.count())
+}
+
+func TestClientPoolHandsOffInOrder(t *testing.T) {
+	dialer := &fakeDialer{t: t}
+	pool := &clientPool{dial: dialer.dial, max: 1}
+	defer pool.close()
+
+	first, err := pool.get()
+	require.NoError(t, err)
+	second := getAsync(pool)
+	waitForWaiters(t, pool, 1)
+	third := getAsync(pool)
+	waitForWaiters(t, pool, 2)
+
+	pool.put(first, nil)
+	result := <-second
+	require.NoError(t, result.err)
+	assert.Same(t, first, result.client)
+
+	// A rule ending with an exit code keeps its connection usable
+	pool.put(result.client, &ExitError{Code: 2, Err: errors.New("expectation failed")})
+	result = <-third
+	require.NoError(t, result.err)
+	assert.Same(t, first, result.client)
+	assert.Equal(t, 1, dialer.count())
+
+	pool.put(result.client, nil)
+	again, err := pool.get()
+	require.NoError(t, err)
+	assert.Same(t, first, again, "idle connections are reused")
+}
+
+func TestClientPoolReleasesAfterError(t *testing.T) {
+	dialer := &fakeDialer{t: t}
+	pool := &clientPool{dial: dialer.dial, max: 1}
+	defer pool.close()
+
+	first, err := pool.get()
+	require.NoError(t, err)
+	results := getAsync(pool)
+	waitForWaiters(t, pool, 1)
+
+	// The connection of a failed rule is closed, its slot goes to the
+	// waiting caller, which opens a new one
+	pool.put(first, errors.New("connection reset"))
+	result := <-results
+	require.NoError(t, result.err)
+	assert.NotSame(t, first, result.client)
+	assert.Equal(t, 2, dialer.count())
+
+	pool.put(result.client, nil)
+	pool.mu.Lock()
+	assert.Equal(t, 1, pool.open)
+	assert.Len(t, pool.idle, 1)
+	pool.mu.Unlock()
+}
+
+func TestClientPoolReleasesFailedDial(t *testing.T) {
+	dialer := &fakeDialer{t: t, err: errors.New("connection refused")}
+	pool := &clientPool{dial: dialer.dial, max: 1}
+	defer pool.close()
+
+	_, err := pool.get()
+	require.Error(t, err)
+
+	// The slot of the failed dial is free again
+	dialer.mu.Lock()
+	dialer.err = nil
+	dialer.mu.Unlock()
+	client, err := pool.get()
+	require.NoError(t, err)
+	assert.NotNil(t, client)
+	assert.Equal(t, 2, dialer.count())
+}
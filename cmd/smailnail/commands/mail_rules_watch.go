@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// ruleSettleDelay is how long a rule file has to stay unchanged before it is
+// reloaded, since editors save in several steps.
+const ruleSettleDelay = 500 * time.Millisecond
+
+// watchRules runs the rules every interval until ctx is done. A changed rule
+// file or overlay, or SIGHUP, reloads the rules; when they fail to load, the
+// previous rules keep running.
+func (c *MailRulesCommand) watchRules(
+	ctx context.Context,
+	gp middlewares.Processor,
+	rules []*dsl.Rule,
+	settings *MailRulesSettings,
+	interval time.Duration,
+) error {
+	watcher, err := newRuleFileWatcher(settings.RuleFiles, settings.Env)
+	if err != nil {
+		return err
+	}
+	defer watcher.close()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	reload := func(reason string) {
+		rules = c.reloadRules(rules, settings, reason)
+	}
+
+	for {
+		if len(rules) == 0 {
+			log.Info().Msg("No enabled rules to run")
+		} else if err := c.runRules(ctx, gp, rules, settings); err != nil {
+			log.Error().Err(err).Msg("Running rules failed")
+		}
+
+		next := time.After(interval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-next:
+				break wait
+			case path := <-watcher.changes:
+				reload("changed " + path)
+			case <-hangup:
+				reload("SIGHUP")
+			}
+		}
+	}
+}
+
+// reloadRules loads the rules again, and returns current when they fail to
+// load.
+func (c *MailRulesCommand) reloadRules(current []*dsl.Rule, settings *MailRulesSettings, reason string) []*dsl.Rule {
+	loaded, err := c.loadRules(settings)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("reason", reason).
+			Int("rules", len(current)).
+			Msg("Reloading rules failed, keeping the previous rules")
+		return current
+	}
+	log.Info().
+		Str("reason", reason).
+		Int("rules", len(loaded)).
+		Msg("Reloaded rules")
+	return loaded
+}
+
+// ruleFileWatcher reports changes to rule files and their overlays. It
+// watches their directories, since editors often replace a file instead of
+// writing it in place.
+type ruleFileWatcher struct {
+	watcher *fsnotify.Watcher
+	files   map[string]bool
+	// changes receives the path of a changed file once it settled
+	changes chan string
+}
+
+func newRuleFileWatcher(paths []string, env string) (*ruleFileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("error watching rule files: %w", err)
+	}
+	w := &ruleFileWatcher{watcher: watcher, files: map[string]bool{}, changes: make(chan string, 1)}
+
+	dirs := map[string]bool{}
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			_ = watcher.Close()
+			return nil, fmt.Errorf("error watching rule file %s: %w", path, err)
+		}
+		w.files[abs] = true
+		if env != "" {
+			w.files[dsl.OverlayPath(abs, env)] = true
+		}
+		dirs[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return nil, fmt.Errorf("error watching rule directory %s: %w", dir, err)
+		}
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *ruleFileWatcher) run() {
+	var settled <-chan time.Time
+	changed := ""
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !w.files[filepath.Clean(event.Name)] {
+				continue
+			}
+			changed = filepath.Clean(event.Name)
+			settled = time.After(ruleSettleDelay)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warn().Err(err).Msg("Error watching rule files")
+		case <-settled:
+			settled = nil
+			// A reload that is already queued picks up this change too
+			select {
+			case w.changes <- changed:
+			default:
+			}
+		}
+	}
+}
+
+func (w *ruleFileWatcher) close() {
+	_ = w.watcher.Close()
+}
@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const watchedRule = `name: %s
+search:
+  subject_contains: invoice
+output:
+  fields: [uid]
+`
+
+// waitForChange waits for the watcher to report a settled change of path.
+func waitForChange(t *testing.T, w *ruleFileWatcher, path string) {
+	t.Helper()
+	select {
+	case changed := <-w.changes:
+		assert.Equal(t, path, changed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rule file change was not reported")
+	}
+}
+
+func TestWatchRulesReloadsAndRetains(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	path := filepath.Join(dir, "rule.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(watchedRule, "first")), 0o644))
+
+	c := &MailRulesCommand{}
+	settings := &MailRulesSettings{RuleFiles: []string{path}}
+	rules, err := c.loadRules(settings)
+	require.NoError(t, err)
+
+	watcher, err := newRuleFileWatcher(settings.RuleFiles, "")
+	require.NoError(t, err)
+	defer watcher.close()
+
+	// A valid change replaces the rules
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(watchedRule, "second")), 0o644))
+	waitForChange(t, watcher, path)
+	rules = c.reloadRules(rules, settings, "changed "+path)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "second", rules[0].Name)
+
+	// An invalid one keeps the previous rules running
+	require.NoError(t, os.WriteFile(path, []byte("name: [broken\n"), 0o644))
+	waitForChange(t, watcher, path)
+	rules = c.reloadRules(rules, settings, "changed "+path)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "second", rules[0].Name)
+}
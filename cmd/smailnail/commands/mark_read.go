@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type MarkReadCommand struct {
+	*cmds.CommandDescription
+}
+
+type MarkReadSettings struct {
+	OlderThan string `glazed:"older-than"`
+	BatchSize int    `glazed:"batch-size"`
+	DryRun    bool   `glazed:"dry-run"`
+	imap.IMAPSettings
+}
+
+func NewMarkReadCommand() (*MarkReadCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &MarkReadCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"mark-read",
+			cmds.WithShort("Mark old unread messages as read"),
+			cmds.WithLong(`Mark the unread messages of --mailbox as read without writing a rule:
+
+  smailnail mark-read --mailbox INBOX --older-than 30d
+
+--older-than takes days (d), weeks (w) or Go durations (12h). As IMAP
+compares dates without the time of day, messages from the day of the cutoff
+are left unread. Without --older-than every unread message is marked.
+Messages are flagged \Seen in batches of --batch-size UIDs and progress is
+logged after each batch. --dry-run only counts the matching messages.`),
+			cmds.WithFlags(
+				fields.New(
+					"older-than",
+					fields.TypeString,
+					fields.WithHelp("Only mark messages at least this old (e.g. 30d, 2w)"),
+				),
+				fields.New(
+					"batch-size",
+					fields.TypeInteger,
+					fields.WithHelp("Number of messages flagged per STORE"),
+					fields.WithDefault(dsl.DefaultMarkReadBatchSize),
+				),
+				fields.New(
+					"dry-run",
+					fields.TypeBool,
+					fields.WithHelp("Count the matching messages without marking them"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *MarkReadCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &MarkReadSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	config := &dsl.MarkReadConfig{
+		Mailbox:   settings.Mailbox,
+		OlderThan: settings.OlderThan,
+		BatchSize: settings.BatchSize,
+		DryRun:    settings.DryRun,
+	}
+	before, err := config.Before(time.Now())
+	if err != nil {
+		return err
+	}
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	marked := 0
+	matched, err := dsl.MarkRead(client, config, func(done, total int) {
+		marked = done
+		log.Info().
+			Str("mailbox", settings.Mailbox).
+			Int("done", done).
+			Int("total", total).
+			Msg("Marked messages as read")
+	})
+	if err != nil {
+		return fmt.Errorf("error marking messages as read: %w", err)
+	}
+
+	row := types.NewRow()
+	row.Set("mailbox", settings.Mailbox)
+	if before.IsZero() {
+		row.Set("before", "")
+	} else {
+		row.Set("before", before.Format("2006-01-02"))
+	}
+	row.Set("matched", matched)
+	row.Set("marked", marked)
+	row.Set("dry_run", settings.DryRun)
+	if err := gp.AddRow(ctx, row); err != nil {
+		return fmt.Errorf("error adding row to processor: %w", err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"net"
 
 	"github.com/go-go-golems/glazed/pkg/cmds"
 	"github.com/go-go-golems/glazed/pkg/cmds/fields"
@@ -181,7 +182,7 @@ func (c *MirrorCommand) RunIntoGlazeProcessor(
 	if err := parsedValues.DecodeSectionInto("mirror", settings); err != nil {
 		return err
 	}
-	if err := parsedValues.DecodeSectionInto(imap.IMAPSectionSlug, &settings.IMAPSettings); err != nil {
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
 		return err
 	}
 
@@ -236,6 +237,15 @@ func (c *MirrorCommand) RunIntoGlazeProcessor(
 		report.StopOnError = settings.StopOnError
 		report.ResetState = settings.ResetMailboxState
 
+		tracer, err := settings.Tracer()
+		if err != nil {
+			return err
+		}
+		var wrapConn func(net.Conn) net.Conn
+		if tracer != nil {
+			wrapConn = tracer.Wrap
+		}
+
 		service := mirror.NewService(store)
 		syncReport, err = service.Sync(ctx, mirror.SyncOptions{
 			Server:                settings.Server,
@@ -256,6 +266,7 @@ func (c *MirrorCommand) RunIntoGlazeProcessor(
 			StopOnError:           settings.StopOnError,
 			ReconcileFull:         settings.ReconcileFull,
 			ResetMailboxState:     settings.ResetMailboxState,
+			WrapConn:              wrapConn,
 		})
 		if err != nil {
 			return err
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+
+	"github.com/go-go-golems/smailnail/pkg/check"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/go-go-golems/smailnail/pkg/smtp"
+)
+
+type ProbeCommand struct {
+	*cmds.CommandDescription
+}
+
+type ProbeSettings struct {
+	To       string `glazed:"to"`
+	Timeout  string `glazed:"timeout"`
+	Interval string `glazed:"interval"`
+	Keep     bool   `glazed:"keep"`
+	Warning  string `glazed:"warning"`
+	Critical string `glazed:"critical"`
+	imap.IMAPSettings
+	SMTP smtp.SMTPSettings
+}
+
+var _ cmds.BareCommand = &ProbeCommand{}
+
+func NewProbeCommand() (*ProbeCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	smtpSection, err := smtp.NewSMTPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SMTP section: %w", err)
+	}
+
+	return &ProbeCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"probe",
+			cmds.WithShort("Measure the end-to-end delivery latency of the mail system"),
+			cmds.WithLong(`Send a uniquely tagged message through the SMTP server and wait for it to
+appear in --mailbox of the IMAP account, then print a JSON report with the
+delivery latency and delete the probe (unless --keep).
+
+The probe is looked for with a header search every --interval. When the
+server supports IDLE, the search also runs as soon as the server announces
+a new message, so the latency is measured closely without polling fast.
+
+Like check, the exit code follows the Nagios plugin convention: 0 (OK), 1
+(WARNING) when delivery took longer than --warning, 2 (CRITICAL) when it
+took longer than --critical, the probe did not arrive within --timeout or a
+step failed, 3 (UNKNOWN) for invalid settings.
+
+  smailnail probe --account work --smtp-server smtp.example.com --warning 30s --critical 2m`),
+			cmds.WithFlags(
+				fields.New("to", fields.TypeString, fields.WithHelp("Address the probe is sent to (default: the IMAP username)")),
+				fields.New("timeout", fields.TypeString, fields.WithHelp("How long to wait for the probe"), fields.WithDefault("5m")),
+				fields.New("interval", fields.TypeString, fields.WithHelp("Time between searches for the probe"), fields.WithDefault("2s")),
+				fields.New("keep", fields.TypeBool, fields.WithHelp("Leave the probe in the mailbox"), fields.WithDefault(false)),
+				fields.New("warning", fields.TypeString, fields.WithHelp("Report WARNING when delivery takes longer than this (e.g. 30s)")),
+				fields.New("critical", fields.TypeString, fields.WithHelp("Report CRITICAL when delivery takes longer than this (e.g. 2m)")),
+			),
+			cmds.WithSections(imapSection, smtpSection),
+		),
+	}, nil
+}
+
+func (c *ProbeCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &ProbeSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return unknown(err)
+	}
+	if err := parsedValues.DecodeSectionInto(smtp.SMTPSectionSlug, &settings.SMTP); err != nil {
+		return unknown(err)
+	}
+
+	config := check.DeliveryConfig{To: settings.To, Mailbox: settings.Mailbox, Keep: settings.Keep}
+	if config.To == "" {
+		config.To = settings.Username
+	}
+	durations := []struct {
+		flag  string
+		value string
+		dst   *time.Duration
+	}{
+		{"timeout", settings.Timeout, &config.Timeout},
+		{"interval", settings.Interval, &config.Interval},
+		{"warning", settings.Warning, &config.Warning},
+		{"critical", settings.Critical, &config.Critical},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil {
+			return unknown(fmt.Errorf("invalid --%s: %w", d.flag, err))
+		}
+		*d.dst = value
+	}
+	if settings.Password == "" {
+		return unknown(fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)"))
+	}
+	sender := newSender(settings.SMTP, &settings.IMAPSettings)
+	if sender == nil {
+		return unknown(fmt.Errorf("--smtp-server is required to send the probe"))
+	}
+
+	report := check.Delivery(ctx, sender, func(handler *imapclient.UnilateralDataHandler) (*imapclient.Client, error) {
+		return settings.ConnectWithHandler(handler)
+	}, config)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return unknown(fmt.Errorf("error writing report: %w", err))
+	}
+	if code := report.ExitCode(); code != 0 {
+		fmt.Fprintln(os.Stderr, report.Message)
+		os.Exit(code)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// progressBar draws the progress of the running rules on a single line of
+// a terminal, from their events. It is shared by rules running concurrently.
+type progressBar struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	order []string
+	rules map[string]*ruleProgress
+	drawn time.Time
+}
+
+type ruleProgress struct {
+	status         string
+	fetched, total int
+}
+
+// progressWidth is the width of the bar of each rule.
+const progressWidth = 20
+
+// progressInterval throttles redraws while messages are fetched.
+const progressInterval = 100 * time.Millisecond
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{w: w, rules: map[string]*ruleProgress{}}
+}
+
+func (p *progressBar) HandleEvent(e dsl.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	name := e.RuleName()
+	rp, ok := p.rules[name]
+	if !ok {
+		rp = &ruleProgress{}
+		p.rules[name] = rp
+		p.order = append(p.order, name)
+	}
+	throttle := false
+	switch e := e.(type) {
+	case dsl.SearchStarted:
+		rp.status = "searching"
+	case dsl.SearchCompleted:
+		rp.status = fmt.Sprintf("%d matched", e.Matched)
+	case dsl.MessageFetched:
+		rp.status = "fetching"
+		rp.fetched, rp.total = e.Fetched, e.Total
+		throttle = e.Fetched < e.Total
+	case dsl.ActionApplied:
+		rp.status = e.Result.Action
+		if e.Result.Error != "" {
+			rp.status += " failed"
+		}
+	case dsl.ErrorOccurred:
+		rp.status = e.Stage + " failed"
+	}
+	if throttle && time.Since(p.drawn) < progressInterval {
+		return
+	}
+	p.draw()
+}
+
+// draw redraws the line. p.mu must be held.
+func (p *progressBar) draw() {
+	parts := make([]string, 0, len(p.order))
+	for _, name := range p.order {
+		rp := p.rules[name]
+		part := name + " " + rp.status
+		if rp.total > 0 {
+			done := rp.fetched * progressWidth / rp.total
+			part += fmt.Sprintf(" [%s%s] %d/%d", strings.Repeat("=", done), strings.Repeat(" ", progressWidth-done), rp.fetched, rp.total)
+		}
+		parts = append(parts, part)
+	}
+	_, _ = fmt.Fprintf(p.w, "\r\033[K%s", strings.Join(parts, " | "))
+	p.drawn = time.Now()
+}
+
+// finish ends the progress line.
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.order) > 0 {
+		p.draw()
+		_, _ = fmt.Fprintln(p.w)
+	}
+}
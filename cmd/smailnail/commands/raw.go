@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type RawCommand struct {
+	*cmds.CommandDescription
+}
+
+type RawSettings struct {
+	Commands  []string `glazed:"commands"`
+	Select    bool     `glazed:"select"`
+	ReadOnly  bool     `glazed:"read-only"`
+	ShowSetup bool     `glazed:"show-setup"`
+	imap.IMAPSettings
+}
+
+func NewRawCommand() (*RawCommand, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &RawCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"raw",
+			cmds.WithShort("Send raw IMAP commands and print the server's responses"),
+			cmds.WithLong(`Send IMAP commands verbatim on an authenticated connection and print the
+exchange, client lines prefixed with "C:" and server lines with "S:". This
+helps debug server-specific behavior that rules abstract away:
+
+  smailnail raw 'UID SEARCH HEADER List-Id foo' 'UID FETCH 1:5 (FLAGS)'
+
+Commands run in order after selecting --mailbox (--select=false stays in the
+authenticated state, --read-only uses EXAMINE). Tags are added automatically.
+The command stops at the first NO or BAD response and fails. Commands that
+wait for continuation data, such as IDLE or ones with literals, are not
+supported.`),
+			cmds.WithFlags(
+				fields.New(
+					"select",
+					fields.TypeBool,
+					fields.WithHelp("Select --mailbox before sending the commands"),
+					fields.WithDefault(true),
+				),
+				fields.New(
+					"read-only",
+					fields.TypeBool,
+					fields.WithHelp("Open --mailbox with EXAMINE instead of SELECT"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"show-setup",
+					fields.TypeBool,
+					fields.WithHelp("Also print the greeting and the mailbox selection"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"commands",
+					fields.TypeStringList,
+					fields.WithHelp("IMAP commands without tags, e.g. 'UID SEARCH UNSEEN'"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(imapSection),
+		),
+	}, nil
+}
+
+func (c *RawCommand) RunIntoWriter(
+	ctx context.Context,
+	parsedValues *values.Values,
+	w io.Writer,
+) error {
+	settings := &RawSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	for _, command := range settings.Commands {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("empty IMAP command")
+		}
+	}
+
+	client, err := settings.DialRaw()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if settings.ShowSetup {
+		printRawLine(w, "S:", client.Greeting)
+	}
+
+	if settings.Select {
+		verb := "SELECT"
+		if settings.ReadOnly {
+			verb = "EXAMINE"
+		}
+		if err := runRawCommand(w, client, verb+" "+imap.QuoteString(settings.Mailbox), settings.ShowSetup); err != nil {
+			return err
+		}
+	}
+
+	for _, command := range settings.Commands {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := runRawCommand(w, client, command, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runRawCommand sends command and prints the exchange if show is set, or
+// only the tagged response of a failed command otherwise.
+func runRawCommand(w io.Writer, client *imap.RawClient, command string, show bool) error {
+	resp, err := client.Command(command)
+	if err != nil {
+		return err
+	}
+	if show {
+		printRawLine(w, "C:", resp.Tag+" "+command)
+		for _, line := range resp.Lines {
+			printRawLine(w, "S:", line)
+		}
+	}
+	if show || resp.Status != "OK" {
+		printRawLine(w, "S:", resp.Line())
+	}
+	if resp.Status != "OK" {
+		return fmt.Errorf("%s failed: %s %s", strings.Fields(command)[0], resp.Status, resp.Text)
+	}
+	return nil
+}
+
+// printRawLine prints a protocol line with its prefix, indenting the lines
+// of inlined literals so message content stays readable.
+func printRawLine(w io.Writer, prefix, line string) {
+	lines := strings.Split(line, "\r\n")
+	_, _ = fmt.Fprintf(w, "%s %s\n", prefix, lines[0])
+	for _, l := range lines[1:] {
+		_, _ = fmt.Fprintf(w, "   %s\n", l)
+	}
+}
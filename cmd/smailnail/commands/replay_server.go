@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type ReplayServerCommand struct {
+	*cmds.CommandDescription
+}
+
+type ReplayServerSettings struct {
+	Fixture string `glazed:"fixture"`
+	Listen  string `glazed:"listen"`
+	NoTLS   bool   `glazed:"no-tls"`
+}
+
+var _ cmds.BareCommand = &ReplayServerCommand{}
+
+func NewReplayServerCommand() (*ReplayServerCommand, error) {
+	return &ReplayServerCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"replay-server",
+			cmds.WithShort("Serve a recorded IMAP session back to clients"),
+			cmds.WithLong(`Replay a fixture recorded with --record-imap to every client that connects,
+to rerun a command against captured server behavior without the account:
+
+  smailnail mail-rules --server imap.gmail.com --username me --rule archive.yaml --record-imap gmail.imap
+  smailnail replay-server --fixture gmail.imap
+  smailnail mail-rules --server 127.0.0.1 --port 1993 --insecure --username me --password x --rule archive.yaml
+
+Server responses are sent as recorded. Client commands must match the
+recording, apart from their tags and the redacted credentials; on the first
+difference the client gets a BYE and the mismatch is logged. The server
+accepts TLS with a self-signed certificate, so clients connect with
+--insecure; --no-tls accepts plain connections instead.`),
+			cmds.WithFlags(
+				fields.New("fixture", fields.TypeString, fields.WithHelp("Fixture file recorded with --record-imap"), fields.WithRequired(true)),
+				fields.New("listen", fields.TypeString, fields.WithHelp("Address to listen on"), fields.WithDefault("127.0.0.1:1993")),
+				fields.New("no-tls", fields.TypeBool, fields.WithHelp("Accept plain connections instead of TLS"), fields.WithDefault(false)),
+			),
+		),
+	}, nil
+}
+
+func (c *ReplayServerCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &ReplayServerSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	fixture, err := imap.LoadFixture(settings.Fixture)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", settings.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", settings.Listen, err)
+	}
+	if !settings.NoTLS {
+		host, _, err := net.SplitHostPort(settings.Listen)
+		if err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("invalid --listen: %w", err)
+		}
+		tlsConfig, err := imap.SelfSignedTLSConfig(host, "localhost")
+		if err != nil {
+			_ = ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	log.Info().Str("listen", ln.Addr().String()).Str("fixture", settings.Fixture).Int("events", len(fixture.Events)).Msg("Replay server started")
+	return imap.ServeFixture(ctx, ln, fixture, func(err error) {
+		log.Error().Err(err).Msg("Replay failed")
+	})
+}
@@ -0,0 +1,150 @@
+package report
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type AttachmentsCommand struct {
+	*cmds.CommandDescription
+}
+
+type AttachmentsSettings struct {
+	RuleFile  string   `glazed:"rule"`
+	MinSize   string   `glazed:"min-size"`
+	Sort      string   `glazed:"sort"`
+	Mailboxes []string `glazed:"mailboxes"`
+	Mbox      string   `glazed:"mbox"`
+	imap.IMAPSettings
+}
+
+func NewAttachmentsCommand() (*AttachmentsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &AttachmentsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"attachments",
+			cmds.WithShort("List the attachments of the matched messages, largest first"),
+			cmds.WithLong(`List every attachment of the messages matching --rule (all messages by
+default) with its filename, media type, decoded size, SHA-256, and the UID,
+sender, date and subject of its message.
+
+Start here to find what fills a quota: --min-size 1M skips small
+attachments, and also only searches messages larger than that on the
+server, since the attachments have to be downloaded to be measured. Equal
+sha256 values are the same file sent several times.
+
+Example:
+  smailnail report attachments --min-size 5M --mailboxes INBOX --mailboxes Sent`),
+			cmds.WithFlags(
+				fields.New(
+					"min-size",
+					fields.TypeString,
+					fields.WithHelp("Skip attachments smaller than this, e.g. 500K or 5M"),
+				),
+				fields.New(
+					"sort",
+					fields.TypeChoice,
+					fields.WithHelp("Order of the attachments: size (largest first), date (newest first), sender or filename"),
+					fields.WithChoices(dsl.AttachmentSortSize, dsl.AttachmentSortDate, dsl.AttachmentSortSender, dsl.AttachmentSortFilename),
+					fields.WithDefault(dsl.AttachmentSortSize),
+				),
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file narrowing the messages"),
+				),
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes to scan (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Scan a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *AttachmentsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &AttachmentsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	options := dsl.AttachmentReportOptions{MinSize: settings.MinSize, SortBy: settings.Sort}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	rule, err := loadRule("attachments", settings.RuleFile)
+	if err != nil {
+		return err
+	}
+	// A message holding an attachment of at least the minimum size is larger
+	if settings.MinSize != "" && rule.Search.Size == nil {
+		rule.Search.Size = &dsl.SizeCriteria{LargerThan: settings.MinSize}
+	}
+	// Only the envelope and the attachments are needed, whatever the rule outputs
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}, dsl.Field{Name: "attachments"}}
+	rule.Output.GroupByThread = false
+	rule.Output.Template = ""
+
+	msgs, err := scanMessages(rule, settings.Mbox, settings.Mailboxes, &settings.IMAPSettings)
+	if err != nil {
+		return err
+	}
+
+	entries, err := dsl.CollectAttachmentReport(msgs, options)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		row := types.NewRow()
+		row.Set("filename", entry.Filename)
+		row.Set("type", entry.Type)
+		row.Set("size", rule.Output.SizeValue(entry.Size))
+		row.Set("sha256", entry.SHA256)
+		row.Set("uid", entry.UID)
+		row.Set("from", entry.From)
+		date := ""
+		if !entry.Date.IsZero() {
+			date = rule.Output.FormatDate(entry.Date)
+		}
+		row.Set("date", date)
+		row.Set("subject", entry.Subject)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
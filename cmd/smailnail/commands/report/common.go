@@ -0,0 +1,136 @@
+package report
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+// loadRule parses the rule file narrowing a report, or returns a rule
+// matching every message when there is none.
+func loadRule(name, path string) (*dsl.Rule, error) {
+	if path == "" {
+		return &dsl.Rule{Name: name}, nil
+	}
+	rule, err := dsl.ParseRuleFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing rule file: %w", err)
+	}
+	return rule, nil
+}
+
+// applyWindow restricts the search to the last window (e.g. 90d), unless the
+// rule has date criteria of its own.
+func applyWindow(rule *dsl.Rule, window string) error {
+	search := &rule.Search
+	if window == "" || search.Since != "" || search.Before != "" || search.On != "" || search.WithinDays != 0 {
+		return nil
+	}
+	days, err := dsl.WindowDays(window)
+	if err != nil {
+		return err
+	}
+	search.WithinDays = days
+	return nil
+}
+
+// scanMessages returns the messages matching rule, read from the local mail
+// at mbox when set, or fetched from the mailboxes (default: --mailbox).
+func scanMessages(rule *dsl.Rule, mbox string, mailboxes []string, settings *imap.IMAPSettings) ([]*dsl.EmailMessage, error) {
+	if mbox != "" {
+		localMessages, err := dsl.ReadLocalMessages(mbox)
+		if err != nil {
+			return nil, fmt.Errorf("error reading local messages: %w", err)
+		}
+		msgs, err := rule.FilterLocalMessages(localMessages)
+		if err != nil {
+			return nil, fmt.Errorf("error matching messages: %w", err)
+		}
+		return msgs, nil
+	}
+
+	if settings.Password == "" {
+		return nil, fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if len(mailboxes) == 0 {
+		mailboxes = []string{settings.Mailbox}
+	}
+
+	var msgs []*dsl.EmailMessage
+	for _, mailbox := range mailboxes {
+		if _, err := dsl.SelectMailbox(client, mailbox); err != nil {
+			return nil, fmt.Errorf("error selecting mailbox: %w", err)
+		}
+		mailboxMsgs, err := rule.FetchMessages(client)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching messages from %s: %w", mailbox, err)
+		}
+		log.Debug().Str("mailbox", mailbox).Int("messages", len(mailboxMsgs)).Str("report", rule.Name).Msg("Scanned mailbox for report")
+		msgs = append(msgs, mailboxMsgs...)
+	}
+	return msgs, nil
+}
+
+// scanConversations returns the received messages matching rule and the
+// messages of sentMailbox, both limited to window and fetched for threading.
+// Local mail at mbox holds both, so sentMailbox is not read then.
+func scanConversations(rule *dsl.Rule, window, sentMailbox, mbox string, mailboxes []string, settings *imap.IMAPSettings) ([]*dsl.EmailMessage, error) {
+	if err := prepareThreadRule(rule, window); err != nil {
+		return nil, err
+	}
+	msgs, err := scanMessages(rule, mbox, mailboxes, settings)
+	if err != nil {
+		return nil, err
+	}
+	if mbox != "" || sentMailbox == "" {
+		return msgs, nil
+	}
+
+	sentRule := &dsl.Rule{Name: rule.Name + "-sent"}
+	if err := prepareThreadRule(sentRule, window); err != nil {
+		return nil, err
+	}
+	sent, err := scanMessages(sentRule, "", []string{sentMailbox}, settings)
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, sent...), nil
+}
+
+// prepareThreadRule limits rule to the window and fetches what threading
+// needs, whatever the rule outputs.
+func prepareThreadRule(rule *dsl.Rule, window string) error {
+	if err := applyWindow(rule, window); err != nil {
+		return err
+	}
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}}
+	rule.Output.Template = ""
+	// Thread grouping also fetches the References header
+	rule.Output.GroupByThread = true
+	return nil
+}
+
+// myAddresses returns the --me addresses, defaulting to the IMAP username
+// when it is an address.
+func myAddresses(me []string, username string) ([]string, error) {
+	if len(me) == 0 && strings.Contains(username, "@") {
+		return []string{username}, nil
+	}
+	if len(me) == 0 {
+		return nil, fmt.Errorf("--me is required when the IMAP username is not an address")
+	}
+	return me, nil
+}
@@ -0,0 +1,195 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type HeatmapCommand struct {
+	*cmds.CommandDescription
+}
+
+type HeatmapSettings struct {
+	RuleFile  string   `glazed:"rule"`
+	Window    string   `glazed:"window"`
+	Timezone  string   `glazed:"timezone"`
+	Shade     bool     `glazed:"shade"`
+	HTML      string   `glazed:"html"`
+	Mailboxes []string `glazed:"mailboxes"`
+	Mbox      string   `glazed:"mbox"`
+	imap.IMAPSettings
+}
+
+func NewHeatmapCommand() (*HeatmapCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &HeatmapCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"heatmap",
+			cmds.WithShort("Count messages per day of the week and hour of the day"),
+			cmds.WithLong(`Count the messages of the last --window by the day of the week and the hour
+of their Date header, in --timezone. There is one row per day, Monday
+first, with a column per hour (00 to 23) and the day's total.
+
+--shade replaces the counts with block characters scaled to the busiest
+hour, so --output table draws a heatmap in the terminal. --html also writes
+the heatmap as an HTML page.
+
+Example:
+  smailnail report heatmap --window 30d --shade --output table
+  smailnail report heatmap --mailboxes INBOX --mailboxes Lists --html heatmap.html`),
+			cmds.WithFlags(
+				fields.New(
+					"window",
+					fields.TypeString,
+					fields.WithHelp("How far back to count, e.g. 30d, 12w or 720h; empty for all messages"),
+					fields.WithDefault("90d"),
+				),
+				fields.New(
+					"timezone",
+					fields.TypeString,
+					fields.WithHelp(`Zone of the hours: "Local", an IANA name like Europe/Berlin, or empty for the zone of each Date header`),
+					fields.WithDefault("Local"),
+				),
+				fields.New(
+					"shade",
+					fields.TypeBool,
+					fields.WithHelp("Show block characters instead of counts"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"html",
+					fields.TypeString,
+					fields.WithHelp("Also write the heatmap as an HTML page to this file"),
+				),
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file narrowing the messages"),
+				),
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes to scan (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Scan a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *HeatmapCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &HeatmapSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	loc, err := dsl.LoadTimezone(settings.Timezone)
+	if err != nil {
+		return err
+	}
+
+	rule, err := loadRule("heatmap", settings.RuleFile)
+	if err != nil {
+		return err
+	}
+	if err := applyWindow(rule, settings.Window); err != nil {
+		return err
+	}
+	// Only the envelope is needed, whatever the rule outputs
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}}
+	rule.Output.GroupByThread = false
+	rule.Output.Template = ""
+
+	msgs, err := scanMessages(rule, settings.Mbox, settings.Mailboxes, &settings.IMAPSettings)
+	if err != nil {
+		return err
+	}
+	heatmap := dsl.CollectHeatmap(msgs, loc)
+
+	if settings.HTML != "" {
+		if err := writeHeatmapHTML(heatmap, settings); err != nil {
+			return err
+		}
+	}
+
+	for day, name := range heatmap.Days() {
+		row := types.NewRow()
+		row.Set("day", name)
+		for hour, count := range heatmap.Counts[day] {
+			column := fmt.Sprintf("%02d", hour)
+			if settings.Shade {
+				row.Set(column, heatmap.Shade(count))
+			} else {
+				row.Set(column, count)
+			}
+		}
+		row.Set("total", heatmap.DayTotal(day))
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeHeatmapHTML(heatmap *dsl.Heatmap, settings *HeatmapSettings) error {
+	source := settings.Mbox
+	if source == "" {
+		mailboxes := settings.Mailboxes
+		if len(mailboxes) == 0 {
+			mailboxes = []string{settings.Mailbox}
+		}
+		source = strings.Join(mailboxes, ", ")
+	}
+	title := "Mail volume of " + source
+	if settings.Window != "" {
+		title += " over the last " + settings.Window
+	}
+
+	f, err := os.Create(settings.HTML)
+	if err != nil {
+		return fmt.Errorf("error creating HTML file: %w", err)
+	}
+	if err := heatmap.WriteHTML(f, title); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing HTML file: %w", err)
+	}
+	log.Info().Str("path", settings.HTML).Msg("Wrote heatmap")
+	return nil
+}
@@ -0,0 +1,167 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type ResponseTimesCommand struct {
+	*cmds.CommandDescription
+}
+
+type ResponseTimesSettings struct {
+	RuleFile    string   `glazed:"rule"`
+	Window      string   `glazed:"window"`
+	By          string   `glazed:"by"`
+	MinReplies  int      `glazed:"min-replies"`
+	Me          []string `glazed:"me"`
+	SentMailbox string   `glazed:"sent-mailbox"`
+	Mailboxes   []string `glazed:"mailboxes"`
+	Mbox        string   `glazed:"mbox"`
+	imap.IMAPSettings
+}
+
+func NewResponseTimesCommand() (*ResponseTimesCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &ResponseTimesCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"response-times",
+			cmds.WithShort("Measure how fast you reply, per correspondent"),
+			cmds.WithLong(`Measure the time between a received message and your reply to it, and
+summarize it per correspondent: the number of replies and the median, 90th
+percentile, mean, fastest and slowest reply time in hours.
+
+Messages of the last --window are read from --mailboxes (default: --mailbox)
+and from --sent-mailbox, and grouped into threads by Message-ID, In-Reply-To
+and References. Each of your messages (from --me, the IMAP username by
+default; "@example.com" counts a whole domain as yours) answers the message
+its In-Reply-To names, or else the latest message from someone else since
+your previous message in the thread. --by domain groups correspondents by
+their domain.
+
+Example:
+  smailnail report response-times --window 180d --by domain --output table`),
+			cmds.WithFlags(
+				fields.New(
+					"window",
+					fields.TypeString,
+					fields.WithHelp("How far back to read messages, e.g. 90d; empty for all messages"),
+					fields.WithDefault("90d"),
+				),
+				fields.New(
+					"by",
+					fields.TypeChoice,
+					fields.WithHelp("Group correspondents by address or domain"),
+					fields.WithChoices("address", "domain"),
+					fields.WithDefault("address"),
+				),
+				fields.New(
+					"min-replies",
+					fields.TypeInteger,
+					fields.WithHelp("Only report correspondents you replied to at least this many times"),
+					fields.WithDefault(1),
+				),
+				fields.New(
+					"me",
+					fields.TypeStringList,
+					fields.WithHelp("Your own addresses, or @domain for a whole domain (default: the IMAP username)"),
+				),
+				fields.New(
+					"sent-mailbox",
+					fields.TypeString,
+					fields.WithHelp("Mailbox holding your replies (empty to skip)"),
+					fields.WithDefault("Sent"),
+				),
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file narrowing the received messages"),
+				),
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes holding the received messages (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Read received and sent messages from a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *ResponseTimesCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &ResponseTimesSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	me, err := myAddresses(settings.Me, settings.Username)
+	if err != nil {
+		return err
+	}
+
+	rule, err := loadRule("response-times", settings.RuleFile)
+	if err != nil {
+		return err
+	}
+	rule.SetMyAddresses(me)
+	msgs, err := scanConversations(rule, settings.Window, settings.SentMailbox, settings.Mbox, settings.Mailboxes, &settings.IMAPSettings)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range dsl.CollectResponseTimes(msgs, me, settings.By == "domain") {
+		if stat.Replies() < settings.MinReplies {
+			continue
+		}
+		row := types.NewRow()
+		row.Set("correspondent", stat.Correspondent)
+		row.Set("replies", stat.Replies())
+		row.Set("median_hours", hours(stat.Percentile(50)))
+		row.Set("p90_hours", hours(stat.Percentile(90)))
+		row.Set("mean_hours", hours(stat.Mean()))
+		row.Set("fastest_hours", hours(stat.Latencies[0]))
+		row.Set("slowest_hours", hours(stat.Latencies[len(stat.Latencies)-1]))
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
+
+// hours returns d in hours, rounded to a tenth.
+func hours(d time.Duration) float64 {
+	return math.Round(d.Hours()*10) / 10
+}
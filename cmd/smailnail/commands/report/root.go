@@ -0,0 +1,47 @@
+package report
+
+import (
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cli"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/spf13/cobra"
+)
+
+func NewReportCommand() (*cobra.Command, error) {
+	root := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize what fills a mailbox",
+	}
+	if err := addGlazedSubcommands(
+		root,
+		func() (cmds.Command, error) { return NewSendersCommand() },
+		func() (cmds.Command, error) { return NewAttachmentsCommand() },
+		func() (cmds.Command, error) { return NewStaleThreadsCommand() },
+		func() (cmds.Command, error) { return NewResponseTimesCommand() },
+		func() (cmds.Command, error) { return NewHeatmapCommand() },
+	); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func addGlazedSubcommands(root *cobra.Command, factories ...func() (cmds.Command, error)) error {
+	for _, factory := range factories {
+		command, err := factory()
+		if err != nil {
+			return err
+		}
+		cobraCmd, err := cli.BuildCobraCommandFromCommand(
+			command,
+			cli.WithParserConfig(cli.CobraParserConfig{
+				AppName: "smailnail",
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("build report subcommand: %w", err)
+		}
+		root.AddCommand(cobraCmd)
+	}
+	return nil
+}
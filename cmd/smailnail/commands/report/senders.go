@@ -0,0 +1,147 @@
+package report
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type SendersCommand struct {
+	*cmds.CommandDescription
+}
+
+type SendersSettings struct {
+	RuleFile  string   `glazed:"rule"`
+	Window    string   `glazed:"window"`
+	Bucket    string   `glazed:"bucket"`
+	Top       int      `glazed:"top"`
+	Mailboxes []string `glazed:"mailboxes"`
+	Mbox      string   `glazed:"mbox"`
+	imap.IMAPSettings
+}
+
+func NewSendersCommand() (*SendersCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &SendersCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"senders",
+			cmds.WithShort("Count messages and bytes per sender domain over time"),
+			cmds.WithLong(`Count the messages of the last --window per sender domain and time bucket.
+Each row holds a domain, the start of a bucket (a day, a Monday or the first
+of a month), the messages and bytes the domain sent in it, the distinct
+sender addresses, and the change in messages from the previous bucket.
+
+Rows are ordered by bucket, busiest domains first. Use --top to keep only the
+domains that sent the most messages over the whole window, and --rule to
+narrow the messages further. The window is ignored when the rule has its own
+date criteria.
+
+Example:
+  smailnail report senders --window 90d --bucket week --top 10 --output csv`),
+			cmds.WithFlags(
+				fields.New(
+					"window",
+					fields.TypeString,
+					fields.WithHelp("How far back to count, e.g. 90d, 12w or 720h; empty for all messages"),
+					fields.WithDefault("90d"),
+				),
+				fields.New(
+					"bucket",
+					fields.TypeChoice,
+					fields.WithHelp("Size of the time buckets"),
+					fields.WithChoices(dsl.WindowDay, dsl.WindowWeek, dsl.WindowMonth),
+					fields.WithDefault(dsl.WindowWeek),
+				),
+				fields.New(
+					"top",
+					fields.TypeInteger,
+					fields.WithHelp("Only report the domains with the most messages (0 for all)"),
+					fields.WithDefault(0),
+				),
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file narrowing the messages"),
+				),
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes to scan (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Scan a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *SendersCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &SendersSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if err := dsl.ValidateBucket(settings.Bucket); err != nil {
+		return err
+	}
+
+	rule, err := loadRule("senders", settings.RuleFile)
+	if err != nil {
+		return err
+	}
+	if err := applyWindow(rule, settings.Window); err != nil {
+		return err
+	}
+	// Only the envelope and the size are needed, whatever the rule outputs
+	rule.Output.Fields = []interface{}{dsl.Field{Name: "uid"}, dsl.Field{Name: "envelope"}, dsl.Field{Name: "size"}}
+	rule.Output.GroupByThread = false
+	rule.Output.Template = ""
+
+	msgs, err := scanMessages(rule, settings.Mbox, settings.Mailboxes, &settings.IMAPSettings)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range dsl.CollectSenderStats(msgs, settings.Bucket, settings.Top) {
+		row := types.NewRow()
+		row.Set("domain", stat.Domain)
+		row.Set("bucket", stat.Bucket.Format("2006-01-02"))
+		row.Set("messages", stat.Messages)
+		row.Set("bytes", stat.Bytes)
+		row.Set("senders", stat.Senders)
+		row.Set("change", stat.Change)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type StaleThreadsCommand struct {
+	*cmds.CommandDescription
+}
+
+type StaleThreadsSettings struct {
+	RuleFile    string   `glazed:"rule"`
+	Days        int      `glazed:"days"`
+	Window      string   `glazed:"window"`
+	Me          []string `glazed:"me"`
+	SentMailbox string   `glazed:"sent-mailbox"`
+	Mailboxes   []string `glazed:"mailboxes"`
+	Mbox        string   `glazed:"mbox"`
+	imap.IMAPSettings
+}
+
+func NewStaleThreadsCommand() (*StaleThreadsCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &StaleThreadsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"stale-threads",
+			cmds.WithShort("Find conversations whose last message got no reply for days"),
+			cmds.WithLong(`Find the dropped balls: conversations where the last message is from
+someone else and nobody replied for --days days.
+
+Messages of the last --window are read from --mailboxes (default: --mailbox)
+and from --sent-mailbox, and grouped into threads by Message-ID, In-Reply-To
+and References. A thread is stale when its last message is not from one of
+your addresses (--me, the IMAP username by default; "@example.com" counts a
+whole domain as yours) and is at least --days old. The threads waiting
+longest come first, with the UID and sender of the message to answer.
+
+--rule narrows the received messages, e.g. with to_me: true to skip mailing
+lists. The Sent mailbox is always read in full for the window.
+
+Example:
+  smailnail report stale-threads --days 5 --me @example.com --output table`),
+			cmds.WithFlags(
+				fields.New(
+					"days",
+					fields.TypeInteger,
+					fields.WithHelp("Report threads unanswered for at least this many days"),
+					fields.WithDefault(3),
+				),
+				fields.New(
+					"window",
+					fields.TypeString,
+					fields.WithHelp("How far back to read messages, e.g. 90d; empty for all messages"),
+					fields.WithDefault("90d"),
+				),
+				fields.New(
+					"me",
+					fields.TypeStringList,
+					fields.WithHelp("Your own addresses, or @domain for a whole domain (default: the IMAP username)"),
+				),
+				fields.New(
+					"sent-mailbox",
+					fields.TypeString,
+					fields.WithHelp("Mailbox holding your replies (empty to skip)"),
+					fields.WithDefault("Sent"),
+				),
+				fields.New(
+					"rule",
+					fields.TypeString,
+					fields.WithHelp("Path to YAML rule file narrowing the received messages"),
+				),
+				fields.New(
+					"mailboxes",
+					fields.TypeStringList,
+					fields.WithHelp("Mailboxes holding the received messages (default: --mailbox)"),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Read received and sent messages from a local mbox file, .eml file, JSONL corpus or directory of .eml files instead of the IMAP server"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *StaleThreadsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &StaleThreadsSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+	if settings.Days < 0 {
+		return fmt.Errorf("--days must not be negative")
+	}
+	me, err := myAddresses(settings.Me, settings.Username)
+	if err != nil {
+		return err
+	}
+
+	rule, err := loadRule("stale-threads", settings.RuleFile)
+	if err != nil {
+		return err
+	}
+	rule.SetMyAddresses(me)
+	msgs, err := scanConversations(rule, settings.Window, settings.SentMailbox, settings.Mbox, settings.Mailboxes, &settings.IMAPSettings)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, stale := range dsl.FindStaleThreads(msgs, me, time.Duration(settings.Days)*24*time.Hour, now) {
+		last := stale.Last.Envelope
+		row := types.NewRow()
+		row.Set("subject", stale.Subject)
+		row.Set("from", last.From[0].Address)
+		row.Set("date", rule.Output.FormatDate(last.Date))
+		row.Set("waiting_days", int(stale.Waiting/(24*time.Hour)))
+		row.Set("uid", stale.Last.UID)
+		row.Set("message_id", last.MessageID)
+		row.Set("messages", len(stale.Messages))
+		row.Set("thread_id", stale.ID)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
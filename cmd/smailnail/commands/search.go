@@ -0,0 +1,182 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"gopkg.in/yaml.v3"
+)
+
+type SearchCommand struct {
+	*cmds.CommandDescription
+}
+
+type SearchSettings struct {
+	Query     string   `glazed:"query"`
+	Fields    []string `glazed:"fields"`
+	Limit     int      `glazed:"limit"`
+	Mbox      string   `glazed:"mbox"`
+	PrintRule bool     `glazed:"print-rule"`
+
+	imap.IMAPSettings
+}
+
+func NewSearchCommand() (*SearchCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &SearchCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"search",
+			cmds.WithShort("Search a mailbox with a query string"),
+			cmds.WithLong(`Search a mailbox with a compact query string instead of a YAML rule:
+
+  smailnail search 'from:boss@example.com subject:"quarterly" -flag:seen since:7d size:>5M'
+
+Terms are key:value pairs or bare words searched in the text of the message;
+all of them must match and a leading "-" negates a term. The keys are from,
+to, cc, bcc, subject, body, text, list, header (header:X-Spam:yes), flag, is
+(unread, read, flagged, answered, list), since and before (a date, or 7d, 2w),
+on, and size (>5M, <100K). The same query can be used in a rule file as
+search.query.
+
+With --mbox the query runs against local messages instead of the server;
+--print-rule prints the rule the query compiles to.`),
+			cmds.WithFlags(
+				fields.New(
+					"fields",
+					fields.TypeStringList,
+					fields.WithHelp("Output fields"),
+					fields.WithDefault([]string{"uid", "date", "from", "subject"}),
+				),
+				fields.New(
+					"limit",
+					fields.TypeInteger,
+					fields.WithHelp("Maximum number of messages to output"),
+					fields.WithDefault(50),
+				),
+				fields.New(
+					"mbox",
+					fields.TypeString,
+					fields.WithHelp("Search an mbox file, .eml file, JSONL corpus or directory of .eml files instead of the server"),
+				),
+				fields.New(
+					"print-rule",
+					fields.TypeBool,
+					fields.WithHelp("Print the equivalent YAML rule instead of executing it"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"query",
+					fields.TypeString,
+					fields.WithHelp("Query string"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *SearchCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &SearchSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	search, err := dsl.ParseQuery(settings.Query)
+	if err != nil {
+		return fmt.Errorf("error parsing query: %w", err)
+	}
+	outputFields := make([]interface{}, 0, len(settings.Fields))
+	for _, name := range settings.Fields {
+		outputFields = append(outputFields, dsl.Field{Name: name})
+	}
+	rule := &dsl.Rule{
+		Name:        "search",
+		Description: "Rule generated from the query " + settings.Query,
+		Search:      search,
+		Output: dsl.OutputConfig{
+			Format: "table",
+			Limit:  settings.Limit,
+			Fields: outputFields,
+		},
+	}
+	if err := rule.Validate(); err != nil {
+		return fmt.Errorf("error building rule from query: %w", err)
+	}
+
+	if settings.PrintRule {
+		yamlData, err := yaml.Marshal(rule)
+		if err != nil {
+			return fmt.Errorf("error marshaling rule to YAML: %w", err)
+		}
+		row := types.NewRow(types.MRP("rule", string(yamlData)))
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding rule to output: %w", err)
+		}
+		return nil
+	}
+
+	var msgs []*dsl.EmailMessage
+	if settings.Mbox != "" {
+		localMessages, err := dsl.ReadLocalMessages(settings.Mbox)
+		if err != nil {
+			return fmt.Errorf("error reading local messages: %w", err)
+		}
+		msgs, err = rule.FilterLocalMessages(localMessages)
+		if err != nil {
+			return fmt.Errorf("error matching messages: %w", err)
+		}
+	} else {
+		if settings.Password == "" {
+			return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+		}
+		client, err := settings.ConnectToIMAPServer()
+		if err != nil {
+			return fmt.Errorf("error connecting to IMAP server: %w", err)
+		}
+		defer func() {
+			_ = client.Close()
+		}()
+		if _, err := dsl.SelectMailbox(client, settings.Mailbox); err != nil {
+			return fmt.Errorf("error selecting mailbox: %w", err)
+		}
+		msgs, err = rule.FetchMessages(client)
+		if err != nil {
+			return fmt.Errorf("error fetching messages: %w", err)
+		}
+	}
+
+	for _, msg := range msgs {
+		if err := gp.AddRow(ctx, messageRow(rule, msg, true)); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
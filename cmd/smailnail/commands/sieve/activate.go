@@ -0,0 +1,66 @@
+package sieve
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+)
+
+type ActivateCommand struct {
+	*cmds.CommandDescription
+}
+
+type activateSettings struct {
+	Name string `glazed:"name"`
+}
+
+func NewActivateCommand() (*ActivateCommand, error) {
+	sections, err := newSieveSections()
+	if err != nil {
+		return nil, err
+	}
+	return &ActivateCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"activate",
+			cmds.WithShort("Make a Sieve script the active one"),
+			cmds.WithLong(`Make the named script the active one. Without a name, the active script is
+deactivated so that no server-side filtering happens.`),
+			cmds.WithArguments(
+				fields.New("name", fields.TypeString, fields.WithHelp("Script to activate")),
+			),
+			cmds.WithSections(sections...),
+		),
+	}, nil
+}
+
+func (c *ActivateCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &activateSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	client, err := connectSieve(parsedValues)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	if settings.Name == "" {
+		if err := client.Deactivate(); err != nil {
+			return fmt.Errorf("error deactivating scripts: %w", err)
+		}
+		log.Info().Msg("Deactivated the active Sieve script")
+		return nil
+	}
+	if err := client.Activate(settings.Name); err != nil {
+		return fmt.Errorf("error activating script %s: %w", settings.Name, err)
+	}
+	log.Info().Str("script", settings.Name).Msg("Activated Sieve script")
+	return nil
+}
@@ -0,0 +1,78 @@
+package sieve
+
+import (
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/go-go-golems/smailnail/pkg/mailruntime"
+)
+
+const sieveSectionSlug = "sieve"
+
+type sieveSettings struct {
+	Server string `glazed:"sieve-server"`
+	Port   int    `glazed:"sieve-port"`
+	NoTLS  bool   `glazed:"sieve-no-tls"`
+}
+
+// newSieveSections returns the ManageSieve section together with the IMAP
+// section whose server and credentials it reuses.
+func newSieveSections() ([]schema.Section, error) {
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+	sieveSection, err := schema.NewSection(
+		sieveSectionSlug,
+		"ManageSieve Connection Settings",
+		schema.WithFields(
+			fields.New("sieve-server", fields.TypeString, fields.WithHelp("ManageSieve server (defaults to the IMAP server)")),
+			fields.New("sieve-port", fields.TypeInteger, fields.WithHelp("ManageSieve port"), fields.WithDefault(4190)),
+			fields.New("sieve-no-tls", fields.TypeBool, fields.WithHelp("Authenticate without STARTTLS"), fields.WithDefault(false)),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sieve section: %w", err)
+	}
+	return []schema.Section{imapSection, sieveSection}, nil
+}
+
+// connectSieve logs into the ManageSieve server with the IMAP credentials.
+func connectSieve(parsedValues *values.Values) (*mailruntime.SieveClient, error) {
+	imapSettings := &imap.IMAPSettings{}
+	if err := imap.DecodeIMAPSettings(parsedValues, imapSettings); err != nil {
+		return nil, err
+	}
+	settings := &sieveSettings{}
+	if err := parsedValues.DecodeSectionInto(sieveSectionSlug, settings); err != nil {
+		return nil, err
+	}
+
+	server := settings.Server
+	if server == "" {
+		server = imapSettings.Server
+	}
+	if server == "" {
+		return nil, fmt.Errorf("server is required (provide via --sieve-server or --server)")
+	}
+	if imapSettings.Password == "" {
+		return nil, fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	client, err := mailruntime.ConnectSieve(mailruntime.SieveOptions{
+		Host:     server,
+		Port:     settings.Port,
+		Username: imapSettings.Username,
+		Password: imapSettings.Password,
+		StartTLS: !settings.NoTLS,
+		Insecure: imapSettings.Insecure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to ManageSieve server: %w", err)
+	}
+	return client, nil
+}
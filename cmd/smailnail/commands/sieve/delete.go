@@ -0,0 +1,59 @@
+package sieve
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+)
+
+type DeleteCommand struct {
+	*cmds.CommandDescription
+}
+
+type deleteSettings struct {
+	Name string `glazed:"name"`
+}
+
+func NewDeleteCommand() (*DeleteCommand, error) {
+	sections, err := newSieveSections()
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"delete",
+			cmds.WithShort("Delete a Sieve script"),
+			cmds.WithLong(`Delete the named script. Servers refuse to delete the active script; run
+'smailnail sieve activate' without a name first.`),
+			cmds.WithArguments(
+				fields.New("name", fields.TypeString, fields.WithHelp("Script to delete"), fields.WithRequired(true)),
+			),
+			cmds.WithSections(sections...),
+		),
+	}, nil
+}
+
+func (c *DeleteCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &deleteSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	client, err := connectSieve(parsedValues)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	if err := client.DeleteScript(settings.Name); err != nil {
+		return fmt.Errorf("error deleting script %s: %w", settings.Name, err)
+	}
+	log.Info().Str("script", settings.Name).Msg("Deleted Sieve script")
+	return nil
+}
@@ -0,0 +1,58 @@
+package sieve
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+)
+
+type ListCommand struct {
+	*cmds.CommandDescription
+}
+
+func NewListCommand() (*ListCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+	sections, err := newSieveSections()
+	if err != nil {
+		return nil, err
+	}
+	return &ListCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"list",
+			cmds.WithShort("List the Sieve scripts on the server"),
+			cmds.WithSections(append(sections, glazedSection)...),
+		),
+	}, nil
+}
+
+func (c *ListCommand) RunIntoGlazeProcessor(ctx context.Context, parsedValues *values.Values, gp middlewares.Processor) error {
+	client, err := connectSieve(parsedValues)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	scripts, err := client.ListScripts()
+	if err != nil {
+		return fmt.Errorf("error listing scripts: %w", err)
+	}
+	for _, script := range scripts {
+		row := types.NewRow()
+		row.Set("name", script.Name)
+		row.Set("active", script.Active)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return fmt.Errorf("error adding row to processor: %w", err)
+		}
+	}
+	return nil
+}
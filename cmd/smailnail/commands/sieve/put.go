@@ -0,0 +1,137 @@
+package sieve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+type PutCommand struct {
+	*cmds.CommandDescription
+}
+
+type putSettings struct {
+	Name     string   `glazed:"name"`
+	Files    []string `glazed:"files"`
+	Activate bool     `glazed:"activate"`
+	Check    bool     `glazed:"check"`
+}
+
+func NewPutCommand() (*PutCommand, error) {
+	sections, err := newSieveSections()
+	if err != nil {
+		return nil, err
+	}
+	return &PutCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"put",
+			cmds.WithShort("Upload a Sieve script, compiling rule files if given"),
+			cmds.WithLong(`Upload a script under the given name, replacing any script of that name.
+The source is either a single Sieve file ("-" reads stdin) or one or more YAML
+rule files, which are compiled the same way as 'smailnail compile --to sieve'.`),
+			cmds.WithFlags(
+				fields.New("activate", fields.TypeBool, fields.WithHelp("Make the script the active one after uploading"), fields.WithDefault(false)),
+				fields.New("check", fields.TypeBool, fields.WithHelp("Only check the script with the server, without uploading it"), fields.WithDefault(false)),
+			),
+			cmds.WithArguments(
+				fields.New("name", fields.TypeString, fields.WithHelp("Script name on the server"), fields.WithRequired(true)),
+				fields.New("files", fields.TypeStringList, fields.WithHelp("Sieve file or YAML rule files"), fields.WithRequired(true)),
+			),
+			cmds.WithSections(sections...),
+		),
+	}, nil
+}
+
+func (c *PutCommand) Run(ctx context.Context, parsedValues *values.Values) error {
+	settings := &putSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	script, err := readScript(settings.Files)
+	if err != nil {
+		return err
+	}
+
+	client, err := connectSieve(parsedValues)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Logout()
+	}()
+
+	if settings.Check {
+		if err := client.CheckScript(script); err != nil {
+			return fmt.Errorf("script rejected by the server: %w", err)
+		}
+		log.Info().Str("script", settings.Name).Msg("Sieve script is valid")
+		return nil
+	}
+
+	if err := client.PutScript(settings.Name, script, settings.Activate); err != nil {
+		return fmt.Errorf("error uploading script %s: %w", settings.Name, err)
+	}
+	log.Info().
+		Str("script", settings.Name).
+		Bool("active", settings.Activate).
+		Msg("Uploaded Sieve script")
+	return nil
+}
+
+// readScript returns the script to upload: a Sieve file as is, or YAML rule
+// files compiled to Sieve.
+func readScript(files []string) (string, error) {
+	var ruleFiles int
+	for _, file := range files {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".yaml", ".yml":
+			ruleFiles++
+		}
+	}
+
+	if ruleFiles == 0 {
+		if len(files) != 1 {
+			return "", fmt.Errorf("expected a single Sieve file or YAML rule files, got %d files", len(files))
+		}
+		var data []byte
+		var err error
+		if files[0] == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(files[0])
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading script: %w", err)
+		}
+		return string(data), nil
+	}
+	if ruleFiles != len(files) {
+		return "", fmt.Errorf("cannot mix YAML rule files with Sieve files")
+	}
+
+	rules := make([]*dsl.Rule, 0, len(files))
+	for _, file := range files {
+		rule, err := dsl.ParseRuleFile(file)
+		if err != nil {
+			return "", fmt.Errorf("error parsing rule file %s: %w", file, err)
+		}
+		rules = append(rules, rule)
+	}
+	script, err := dsl.CompileSieve(rules...)
+	if err != nil {
+		return "", fmt.Errorf("error compiling rules: %w", err)
+	}
+	return script, nil
+}
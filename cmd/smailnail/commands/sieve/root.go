@@ -0,0 +1,46 @@
+package sieve
+
+import (
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cli"
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/spf13/cobra"
+)
+
+func NewSieveCommand() (*cobra.Command, error) {
+	root := &cobra.Command{
+		Use:   "sieve",
+		Short: "Manage server-side Sieve scripts over ManageSieve",
+	}
+	if err := addGlazedSubcommands(
+		root,
+		func() (cmds.Command, error) { return NewListCommand() },
+		func() (cmds.Command, error) { return NewPutCommand() },
+		func() (cmds.Command, error) { return NewActivateCommand() },
+		func() (cmds.Command, error) { return NewDeleteCommand() },
+	); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func addGlazedSubcommands(root *cobra.Command, factories ...func() (cmds.Command, error)) error {
+	for _, factory := range factories {
+		command, err := factory()
+		if err != nil {
+			return err
+		}
+		cobraCmd, err := cli.BuildCobraCommandFromCommand(
+			command,
+			cli.WithParserConfig(cli.CobraParserConfig{
+				AppName: "smailnail",
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("build sieve subcommand: %w", err)
+		}
+		root.AddCommand(cobraCmd)
+	}
+	return nil
+}
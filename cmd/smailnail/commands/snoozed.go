@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+type SnoozedCommand struct {
+	*cmds.CommandDescription
+}
+
+type SnoozedSettings struct {
+	State string `glazed:"state"`
+	Wake  bool   `glazed:"wake"`
+	Watch string `glazed:"watch"`
+	imap.IMAPSettings
+}
+
+func NewSnoozedCommand() (*SnoozedCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	imapSection, err := imap.NewIMAPSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP section: %w", err)
+	}
+
+	return &SnoozedCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"snoozed",
+			cmds.WithShort("List snoozed messages and wake the ones that are due"),
+			cmds.WithLong(`List the messages snoozed by the snooze action. With --wake, messages whose
+wake time has passed are marked unread and moved back to their return mailbox
+(INBOX by default). With --watch, the wake-up runs repeatedly at the given
+interval until interrupted, so it can be left running as a daemon.`),
+			cmds.WithFlags(
+				fields.New(
+					"state",
+					fields.TypeString,
+					fields.WithHelp("Path to the snooze state file (default $XDG_STATE_HOME/smailnail/snoozed.json)"),
+				),
+				fields.New(
+					"wake",
+					fields.TypeBool,
+					fields.WithHelp("Move due messages back to their return mailbox"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"watch",
+					fields.TypeString,
+					fields.WithHelp("Wake due messages repeatedly at this interval (e.g. 5m), implies --wake"),
+				),
+			),
+			cmds.WithSections(glazedSection, imapSection),
+		),
+	}, nil
+}
+
+func (c *SnoozedCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &SnoozedSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+	if err := imap.DecodeIMAPSettings(parsedValues, &settings.IMAPSettings); err != nil {
+		return err
+	}
+
+	var interval time.Duration
+	if settings.Watch != "" {
+		var err error
+		interval, err = time.ParseDuration(settings.Watch)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid --watch interval: %s", settings.Watch)
+		}
+	}
+
+	if !settings.Wake && interval == 0 {
+		store, err := dsl.LoadSnoozeStore(settings.State)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, entry := range store.Entries {
+			row := snoozeRow(entry)
+			row.Set("due", !entry.WakeAt.After(now))
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding row to processor: %w", err)
+			}
+		}
+		return nil
+	}
+
+	if settings.Password == "" {
+		return fmt.Errorf("password is required (provide via --password flag or IMAP_PASSWORD environment variable)")
+	}
+
+	for {
+		if err := c.wake(ctx, settings, gp); err != nil {
+			if interval == 0 {
+				return err
+			}
+			log.Error().Err(err).Msg("Waking snoozed messages failed")
+		}
+		if interval == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// wake connects, moves the due messages back and reports them.
+func (c *SnoozedCommand) wake(ctx context.Context, settings *SnoozedSettings, gp middlewares.Processor) error {
+	store, err := dsl.LoadSnoozeStore(settings.State)
+	if err != nil {
+		return err
+	}
+	if len(store.Due(time.Now())) == 0 {
+		log.Debug().Msg("No snoozed messages are due")
+		return nil
+	}
+
+	client, err := settings.ConnectToIMAPServer()
+	if err != nil {
+		return fmt.Errorf("error connecting to IMAP server: %w", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	woken, err := dsl.WakeSnoozed(client, store, time.Now())
+	for _, w := range woken {
+		row := snoozeRow(w.Entry)
+		row.Set("found", w.Found)
+		if addErr := gp.AddRow(ctx, row); addErr != nil {
+			return fmt.Errorf("error adding row to processor: %w", addErr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error waking snoozed messages: %w", err)
+	}
+	return nil
+}
+
+func snoozeRow(entry dsl.SnoozeEntry) types.Row {
+	row := types.NewRow()
+	row.Set("message_id", entry.MessageID)
+	row.Set("subject", entry.Subject)
+	row.Set("folder", entry.Folder)
+	row.Set("return_to", entry.ReturnTo)
+	row.Set("wake_at", entry.WakeAt.Format(time.RFC3339))
+	row.Set("rule", entry.Rule)
+	return row
+}
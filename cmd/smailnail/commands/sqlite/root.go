@@ -28,5 +28,20 @@ func NewSQLiteCommand() (*cobra.Command, error) {
 	}
 	root.AddCommand(cobraCommand)
 
+	searchCommand, err := NewSearchCommand()
+	if err != nil {
+		return nil, err
+	}
+	cobraCommand, err = cli.BuildCobraCommandFromCommand(
+		searchCommand,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("build sqlite search command: %w", err)
+	}
+	root.AddCommand(cobraCommand)
+
 	return root, nil
 }
@@ -0,0 +1,110 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/smailnail/pkg/mirror"
+	"github.com/pkg/errors"
+)
+
+type SearchCommand struct {
+	*cmds.CommandDescription
+}
+
+type searchSettings struct {
+	Query          string `glazed:"query"`
+	SQLitePath     string `glazed:"sqlite-path"`
+	Account        string `glazed:"account"`
+	Mailbox        string `glazed:"mailbox"`
+	Limit          int    `glazed:"limit"`
+	IncludeDeleted bool   `glazed:"include-deleted"`
+}
+
+var _ cmds.GlazeCommand = &SearchCommand{}
+
+func NewSearchCommand() (*SearchCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SearchCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"search",
+			cmds.WithShort("Search the full-text index of the local mirror"),
+			cmds.WithLong(`Search the headers and bodies of the mirrored messages, best matches first.
+
+The query uses the SQLite FTS5 syntax: words, "quoted phrases", prefix*,
+AND/OR/NOT and column filters such as subject:invoice or from_summary:alice.
+Matches in the subject and senders rank above matches in the body. Build the
+index with "smailnail index".
+
+Examples:
+  smailnail sqlite search invoice
+  smailnail sqlite search '"quarterly report" NOT subject:draft' --mailbox INBOX --limit 5`),
+			cmds.WithFlags(
+				fields.New("sqlite-path", fields.TypeString, fields.WithHelp("SQLite path for the local mirror store"), fields.WithDefault(mirror.DefaultSQLiteDBPath)),
+				fields.New("account", fields.TypeString, fields.WithHelp("Only search messages of this account key")),
+				fields.New("mailbox", fields.TypeString, fields.WithHelp("Only search messages of this mailbox")),
+				fields.New("limit", fields.TypeInteger, fields.WithHelp("Maximum number of results"), fields.WithDefault(mirror.DefaultSearchLimit)),
+				fields.New("include-deleted", fields.TypeBool, fields.WithHelp("Include messages deleted on the server since the last sync"), fields.WithDefault(false)),
+			),
+			cmds.WithArguments(
+				fields.New("query", fields.TypeString, fields.WithHelp("FTS5 query"), fields.WithRequired(true)),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *SearchCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &searchSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	store, err := mirror.OpenStore(settings.SQLitePath)
+	if err != nil {
+		return errors.Wrap(err, "open mirror store")
+	}
+	defer func() { _ = store.Close() }()
+
+	hits, err := store.Search(ctx, mirror.SearchOptions{
+		Query:          settings.Query,
+		AccountKey:     settings.Account,
+		Mailbox:        settings.Mailbox,
+		Limit:          settings.Limit,
+		IncludeDeleted: settings.IncludeDeleted,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hit := range hits {
+		row := types.NewRow(
+			types.MRP("rank", hit.Rank),
+			types.MRP("account_key", hit.AccountKey),
+			types.MRP("mailbox", hit.MailboxName),
+			types.MRP("uid", hit.UID),
+			types.MRP("sent_date", hit.SentDate),
+			types.MRP("from", hit.FromSummary),
+			types.MRP("subject", hit.Subject),
+			types.MRP("snippet", hit.Snippet),
+		)
+		if err := gp.AddRow(ctx, row); err != nil {
+			return errors.Wrap(err, "add search row")
+		}
+	}
+	return nil
+}
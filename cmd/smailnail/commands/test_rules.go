@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+type TestRulesCommand struct {
+	*cmds.CommandDescription
+}
+
+type TestRulesSettings struct {
+	RuleFiles []string `glazed:"rules"`
+	Fixtures  string   `glazed:"fixtures"`
+	Strict    bool     `glazed:"strict"`
+	Failures  bool     `glazed:"failures-only"`
+	Golden    string   `glazed:"golden"`
+	Formats   []string `glazed:"golden-formats"`
+	Update    bool     `glazed:"update"`
+}
+
+func NewTestRulesCommand() (*TestRulesCommand, error) {
+	glazedSection, err := settings.NewGlazedSection()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create glazed section: %w", err)
+	}
+
+	return &TestRulesCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"test",
+			cmds.WithShort("Check rules against fixture messages and their expect block"),
+			cmds.WithLong(`Evaluate rules against fixture messages and check the expectations of their
+expect block, without connecting to an IMAP server:
+
+  smailnail test rules/*.yaml --fixtures corpus/
+
+--fixtures accepts a directory of .eml files, an mbox file or a single .eml
+file. Each expect entry names a fixture by its path relative to --fixtures
+(file.mbox#2 for the second message of an mbox file) and states whether it
+matches, and optionally the classify label it gets and the actions that would
+run on it. Actions are planned, not executed.
+
+With --golden, the output of each rule on the fixtures is also compared with
+a golden file in that directory, named after the rule file and the format
+(archive.yaml in text gives archive.text). --golden-formats renders other
+formats than the rule's own; --update rewrites the golden files after an
+intended output change.
+
+One row is output per check. The command exits with code 5 if a check fails,
+so it can run in CI.`),
+			cmds.WithFlags(
+				fields.New(
+					"fixtures",
+					fields.TypeString,
+					fields.WithHelp("Directory of .eml files, mbox file, JSONL corpus or .eml file holding the fixture messages"),
+					fields.WithRequired(true),
+				),
+				fields.New(
+					"strict",
+					fields.TypeBool,
+					fields.WithHelp("Fail on matched fixtures missing from the expect block"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"golden",
+					fields.TypeString,
+					fields.WithHelp("Directory of golden files to compare the rendered output with"),
+				),
+				fields.New(
+					"golden-formats",
+					fields.TypeStringList,
+					fields.WithHelp("Output formats compared with golden files (json, ndjson, text, table; default: the rule's format)"),
+				),
+				fields.New(
+					"update",
+					fields.TypeBool,
+					fields.WithHelp("Write the golden files instead of comparing with them"),
+					fields.WithDefault(false),
+				),
+				fields.New(
+					"failures-only",
+					fields.TypeBool,
+					fields.WithHelp("Only output the failed checks"),
+					fields.WithDefault(false),
+				),
+			),
+			cmds.WithArguments(
+				fields.New(
+					"rules",
+					fields.TypeStringList,
+					fields.WithHelp("Paths to YAML rule files with an expect block"),
+					fields.WithRequired(true),
+				),
+			),
+			cmds.WithSections(glazedSection),
+		),
+	}, nil
+}
+
+func (c *TestRulesCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	parsedValues *values.Values,
+	gp middlewares.Processor,
+) error {
+	settings := &TestRulesSettings{}
+	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
+		return err
+	}
+
+	if settings.Update && settings.Golden == "" {
+		return fmt.Errorf("--update needs --golden")
+	}
+
+	rules := make([]*dsl.Rule, 0, len(settings.RuleFiles))
+	for _, file := range settings.RuleFiles {
+		rule, err := dsl.ParseRuleFile(file)
+		if err != nil {
+			return fmt.Errorf("error parsing rule file %s: %w", file, err)
+		}
+		if len(rule.Expect) == 0 && settings.Golden == "" {
+			return fmt.Errorf("rule file %s has no expect block", file)
+		}
+		rules = append(rules, rule)
+	}
+
+	messages, err := dsl.ReadLocalMessages(settings.Fixtures)
+	if err != nil {
+		return fmt.Errorf("error reading fixtures: %w", err)
+	}
+
+	checks, failed := 0, 0
+	for i, rule := range rules {
+		results, err := rule.CheckExpectations(settings.Fixtures, messages, settings.Strict)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+		if settings.Golden != "" {
+			formats := settings.Formats
+			if len(formats) == 0 {
+				formats = []string{rule.Output.Format}
+			}
+			name := strings.TrimSuffix(filepath.Base(settings.RuleFiles[i]), filepath.Ext(settings.RuleFiles[i]))
+			for _, format := range formats {
+				path := filepath.Join(settings.Golden, name+"."+format)
+				result, err := rule.CheckGolden(path, format, messages, settings.Update)
+				if err != nil {
+					return fmt.Errorf("rule %s: %w", rule.Name, err)
+				}
+				results = append(results, result)
+			}
+		}
+		for _, result := range results {
+			checks++
+			if !result.Passed {
+				failed++
+			} else if settings.Failures {
+				continue
+			}
+			row := types.NewRow(
+				types.MRP("rule", result.Rule),
+				types.MRP("fixture", result.Fixture),
+				types.MRP("check", result.Check),
+				types.MRP("expected", result.Expected),
+				types.MRP("actual", result.Actual),
+				types.MRP("passed", result.Passed),
+			)
+			if err := gp.AddRow(ctx, row); err != nil {
+				return fmt.Errorf("error adding row to processor: %w", err)
+			}
+		}
+	}
+
+	if failed > 0 {
+		exitWithCode(ctx, gp, &ExitError{
+			Code: ExitExpectationFailures,
+			Err:  fmt.Errorf("%d of %d checks failed", failed, checks),
+		})
+	}
+	return nil
+}
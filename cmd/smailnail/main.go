@@ -11,11 +11,22 @@ import (
 	"github.com/go-go-golems/glazed/pkg/help"
 	help_cmd "github.com/go-go-golems/glazed/pkg/help/cmd"
 	"github.com/go-go-golems/smailnail/cmd/smailnail/commands"
+	aclcommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/acl"
 	annotatecommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/annotate"
 	enrichcommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/enrich"
+	flagscommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/flags"
+	reportcommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/report"
+	sievecommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/sieve"
 	sqlitecommands "github.com/go-go-golems/smailnail/cmd/smailnail/commands/sqlite"
 	smailnaildocs "github.com/go-go-golems/smailnail/cmd/smailnail/docs"
 	pkgdoc "github.com/go-go-golems/smailnail/pkg/doc"
+	// SQL drivers of the database action
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	// Registers export.format: parquet
+	_ "github.com/go-go-golems/smailnail/pkg/parquetexport"
+	// Registers export.format: sqlite
+	_ "github.com/go-go-golems/smailnail/pkg/sqliteexport"
 	"github.com/spf13/cobra"
 )
 
@@ -61,6 +72,74 @@ func main() {
 	}
 	rootCmd.AddCommand(cobraMailRulesCmd)
 
+	initCmd, err := commands.NewInitCommand()
+	if err != nil {
+		fmt.Printf("Error creating init command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraInitCmd, err := cli.BuildCobraCommandFromCommand(initCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building init Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraInitCmd)
+
+	discoverCmd, err := commands.NewDiscoverCommand()
+	if err != nil {
+		fmt.Printf("Error creating discover command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraDiscoverCmd, err := cli.BuildCobraCommandFromCommand(discoverCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building discover Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraDiscoverCmd)
+
+	checkCmd, err := commands.NewCheckCommand()
+	if err != nil {
+		fmt.Printf("Error creating check command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraCheckCmd, err := cli.BuildCobraCommandFromCommand(checkCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building check Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraCheckCmd)
+
+	probeCmd, err := commands.NewProbeCommand()
+	if err != nil {
+		fmt.Printf("Error creating probe command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraProbeCmd, err := cli.BuildCobraCommandFromCommand(probeCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building probe Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraProbeCmd)
+
 	// Create and add the fetch-mail command
 	fetchMailCmd, err := commands.NewFetchMailCommand()
 	if err != nil {
@@ -79,6 +158,346 @@ func main() {
 	}
 	rootCmd.AddCommand(cobraFetchMailCmd)
 
+	grepCmd, err := commands.NewGrepCommand()
+	if err != nil {
+		fmt.Printf("Error creating grep command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraGrepCmd, err := cli.BuildCobraCommandFromCommand(grepCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building grep Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraGrepCmd)
+
+	searchCmd, err := commands.NewSearchCommand()
+	if err != nil {
+		fmt.Printf("Error creating search command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraSearchCmd, err := cli.BuildCobraCommandFromCommand(searchCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building search Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraSearchCmd)
+
+	lintCmd, err := commands.NewLintCommand()
+	if err != nil {
+		fmt.Printf("Error creating lint command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraLintCmd, err := cli.BuildCobraCommandFromCommand(lintCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building lint Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraLintCmd)
+
+	testRulesCmd, err := commands.NewTestRulesCommand()
+	if err != nil {
+		fmt.Printf("Error creating test command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraTestRulesCmd, err := cli.BuildCobraCommandFromCommand(testRulesCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building test Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraTestRulesCmd)
+
+	snoozedCmd, err := commands.NewSnoozedCommand()
+	if err != nil {
+		fmt.Printf("Error creating snoozed command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraSnoozedCmd, err := cli.BuildCobraCommandFromCommand(snoozedCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building snoozed Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraSnoozedCmd)
+
+	markReadCmd, err := commands.NewMarkReadCommand()
+	if err != nil {
+		fmt.Printf("Error creating mark-read command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraMarkReadCmd, err := cli.BuildCobraCommandFromCommand(markReadCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building mark-read Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraMarkReadCmd)
+
+	keywordsCmd, err := commands.NewKeywordsCommand()
+	if err != nil {
+		fmt.Printf("Error creating keywords command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraKeywordsCmd, err := cli.BuildCobraCommandFromCommand(keywordsCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building keywords Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraKeywordsCmd)
+
+	expungeCmd, err := commands.NewExpungeCommand()
+	if err != nil {
+		fmt.Printf("Error creating expunge command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraExpungeCmd, err := cli.BuildCobraCommandFromCommand(expungeCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building expunge Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraExpungeCmd)
+
+	benchCmd, err := commands.NewBenchCommand()
+	if err != nil {
+		fmt.Printf("Error creating bench command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraBenchCmd, err := cli.BuildCobraCommandFromCommand(benchCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building bench Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraBenchCmd)
+
+	chaosProxyCmd, err := commands.NewChaosProxyCommand()
+	if err != nil {
+		fmt.Printf("Error creating chaos-proxy command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraChaosProxyCmd, err := cli.BuildCobraCommandFromCommand(chaosProxyCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building chaos-proxy Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraChaosProxyCmd)
+
+	replayServerCmd, err := commands.NewReplayServerCommand()
+	if err != nil {
+		fmt.Printf("Error creating replay-server command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraReplayServerCmd, err := cli.BuildCobraCommandFromCommand(replayServerCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building replay-server Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraReplayServerCmd)
+
+	feedCmd, err := commands.NewFeedCommand()
+	if err != nil {
+		fmt.Printf("Error creating feed command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraFeedCmd, err := cli.BuildCobraCommandFromCommand(feedCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building feed Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraFeedCmd)
+
+	calendarCmd, err := commands.NewCalendarCommand()
+	if err != nil {
+		fmt.Printf("Error creating calendar command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraCalendarCmd, err := cli.BuildCobraCommandFromCommand(calendarCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building calendar Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraCalendarCmd)
+
+	followUpsCmd, err := commands.NewFollowUpsCommand()
+	if err != nil {
+		fmt.Printf("Error creating follow-ups command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraFollowUpsCmd, err := cli.BuildCobraCommandFromCommand(followUpsCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building follow-ups Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraFollowUpsCmd)
+
+	compileCmd, err := commands.NewCompileCommand()
+	if err != nil {
+		fmt.Printf("Error creating compile command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraCompileCmd, err := cli.BuildCobraCommandFromCommand(compileCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building compile Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraCompileCmd)
+
+	importFiltersCmd, err := commands.NewImportFiltersCommand()
+	if err != nil {
+		fmt.Printf("Error creating import-filters command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraImportFiltersCmd, err := cli.BuildCobraCommandFromCommand(importFiltersCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building import-filters Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraImportFiltersCmd)
+
+	contactsCmd, err := commands.NewContactsCommand()
+	if err != nil {
+		fmt.Printf("Error creating contacts command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraContactsCmd, err := cli.BuildCobraCommandFromCommand(contactsCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building contacts Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraContactsCmd)
+
+	learnCmd, err := commands.NewLearnCommand()
+	if err != nil {
+		fmt.Printf("Error creating learn command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraLearnCmd, err := cli.BuildCobraCommandFromCommand(learnCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building learn Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraLearnCmd)
+
+	rawCmd, err := commands.NewRawCommand()
+	if err != nil {
+		fmt.Printf("Error creating raw command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraRawCmd, err := cli.BuildCobraCommandFromCommand(rawCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building raw Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraRawCmd)
+
+	capabilitiesCmd, err := commands.NewCapabilitiesCommand()
+	if err != nil {
+		fmt.Printf("Error creating capabilities command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraCapabilitiesCmd, err := cli.BuildCobraCommandFromCommand(capabilitiesCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building capabilities Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraCapabilitiesCmd)
+
 	mirrorCmd, err := commands.NewMirrorCommand()
 	if err != nil {
 		fmt.Printf("Error creating mirror command: %v\n", err)
@@ -113,6 +532,23 @@ func main() {
 	}
 	rootCmd.AddCommand(cobraMergeMirrorCmd)
 
+	indexCmd, err := commands.NewIndexCommand()
+	if err != nil {
+		fmt.Printf("Error creating index command: %v\n", err)
+		os.Exit(1)
+	}
+
+	cobraIndexCmd, err := cli.BuildCobraCommandFromCommand(indexCmd,
+		cli.WithParserConfig(cli.CobraParserConfig{
+			AppName: "smailnail",
+		}),
+	)
+	if err != nil {
+		fmt.Printf("Error building index Cobra command: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(cobraIndexCmd)
+
 	enrichCmd, err := enrichcommands.NewEnrichCommand()
 	if err != nil {
 		fmt.Printf("Error creating enrich command group: %v\n", err)
@@ -127,6 +563,34 @@ func main() {
 	}
 	rootCmd.AddCommand(annotateCmd)
 
+	flagsCmd, err := flagscommands.NewFlagsCommand()
+	if err != nil {
+		fmt.Printf("Error creating flags command group: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(flagsCmd)
+
+	aclCmd, err := aclcommands.NewACLCommand()
+	if err != nil {
+		fmt.Printf("Error creating acl command group: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(aclCmd)
+
+	reportCmd, err := reportcommands.NewReportCommand()
+	if err != nil {
+		fmt.Printf("Error creating report command group: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(reportCmd)
+
+	sieveCmd, err := sievecommands.NewSieveCommand()
+	if err != nil {
+		fmt.Printf("Error creating sieve command group: %v\n", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(sieveCmd)
+
 	sqliteCmd, err := sqlitecommands.NewSQLiteCommand()
 	if err != nil {
 		fmt.Printf("Error creating sqlite command group: %v\n", err)
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -28,8 +29,10 @@ type ServeCommand struct {
 }
 
 type ServeSettings struct {
-	ListenHost string `glazed:"listen-host"`
-	ListenPort int    `glazed:"listen-port"`
+	ListenHost        string   `glazed:"listen-host"`
+	ListenPort        int      `glazed:"listen-port"`
+	PrefetchInterval  string   `glazed:"prefetch-interval"`
+	PrefetchMailboxes []string `glazed:"prefetch-mailboxes"`
 }
 
 var _ cmds.BareCommand = &ServeCommand{}
@@ -51,6 +54,18 @@ func NewServeCommand() (*ServeCommand, error) {
 				fields.WithHelp("Port to listen on"),
 				fields.WithDefault(8080),
 			),
+			fields.New(
+				"prefetch-interval",
+				fields.TypeString,
+				fields.WithHelp("Refresh the prefetched envelopes and flags of every account at this interval (e.g. 2m); empty disables prefetching"),
+				fields.WithDefault(""),
+			),
+			fields.New(
+				"prefetch-mailboxes",
+				fields.TypeStringList,
+				fields.WithHelp("Mailboxes to prefetch for every account, in addition to its default mailbox"),
+				fields.WithDefault([]string{}),
+			),
 		),
 	)
 	if err != nil {
@@ -94,6 +109,8 @@ This slice provides:
 - mounted MCP transport and bearer-token auth on the same HTTP server
 - encrypted IMAP credential storage using the encryption section
 - hosted account CRUD, account test, mailbox preview, and rule dry-run APIs
+- optional background prefetching of envelopes and flags (--prefetch-interval),
+  so mailbox previews are answered without live FETCHes
 - health, readiness, and service metadata endpoints`),
 			cmds.WithSections(defaultSection, sqlSection, dbtSection, encryptionSection, authSection, mcpSection),
 		),
@@ -105,6 +122,14 @@ func (c *ServeCommand) Run(ctx context.Context, parsedValues *values.Values) err
 	if err := parsedValues.DecodeSectionInto(schema.DefaultSlug, settings); err != nil {
 		return err
 	}
+	var prefetchInterval time.Duration
+	if settings.PrefetchInterval != "" {
+		interval, err := time.ParseDuration(settings.PrefetchInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid --prefetch-interval %q: must be a positive duration such as 2m", settings.PrefetchInterval)
+		}
+		prefetchInterval = interval
+	}
 
 	db, dbInfo, err := hostedapp.OpenApplicationDB(ctx, parsedValues)
 	if err != nil {
@@ -134,6 +159,13 @@ func (c *ServeCommand) Run(ctx context.Context, parsedValues *values.Values) err
 
 	accountService := accounts.NewService(accounts.NewRepository(db), secretConfig)
 	ruleService := rules.NewService(rules.NewRepository(db), accountService)
+	if prefetchInterval > 0 {
+		prefetcher := accounts.NewPrefetcher(accountService, accounts.PrefetchOptions{
+			Interval:  prefetchInterval,
+			Mailboxes: settings.PrefetchMailboxes,
+		})
+		go prefetcher.Run(ctx)
+	}
 	identityRepo := identity.NewRepository(db)
 	identityService := identity.NewService(identityRepo)
 
@@ -0,0 +1,272 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/rs/zerolog/log"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// Defaults of Config.
+const (
+	DefaultMailbox          = "smailnail-bench"
+	DefaultMessages         = 100
+	DefaultSearchIterations = 10
+)
+
+// DefaultSizes are the message sizes APPEND is measured at.
+var DefaultSizes = []string{"1K", "10K", "100K"}
+
+// needle is the word every tenth message contains, for body and text
+// searches that match a few messages.
+const needle = "smailnailneedle"
+
+// Config configures a benchmark run.
+type Config struct {
+	// Mailbox is the scratch mailbox messages are appended to. It must not
+	// exist; it is created for the run and deleted afterwards unless Keep.
+	Mailbox string
+	// Sizes are the message sizes in bytes, Messages messages per size
+	Sizes    []int64
+	Messages int
+	// SearchIterations is how often each search is run
+	SearchIterations int
+	Keep             bool
+}
+
+// Result is the measurement of one benchmark.
+type Result struct {
+	// Phase is append, search or fetch
+	Phase string
+	// Name identifies the benchmark within the phase: the message size for
+	// append, the criteria for search, the fetched items for fetch
+	Name string
+	// Operations counts appends, searches or fetched messages; a fetch
+	// phase is a single FETCH of the whole mailbox
+	Operations int
+	// Matches is the number of messages the search returned
+	Matches  int
+	Bytes    int64
+	Duration time.Duration
+	// Latencies holds the duration of each operation, sorted
+	Latencies []time.Duration
+}
+
+// PerSecond returns the operations per second.
+func (r Result) PerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Operations) / r.Duration.Seconds()
+}
+
+// MBPerSecond returns the throughput in MiB per second.
+func (r Result) MBPerSecond() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) / (1024 * 1024) / r.Duration.Seconds()
+}
+
+// Percentile returns the latency below which p percent of the operations
+// completed, using the nearest rank.
+func (r Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(r.Latencies))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(r.Latencies) {
+		rank = len(r.Latencies) - 1
+	}
+	return r.Latencies[rank]
+}
+
+// searchCase is a representative search, expressed as a rule search so it
+// goes through the same criteria building as rules.
+type searchCase struct {
+	name   string
+	search dsl.SearchConfig
+}
+
+func searchCases() []searchCase {
+	unread := true
+	return []searchCase{
+		{"all", dsl.SearchConfig{}},
+		{"unread", dsl.SearchConfig{Unread: &unread}},
+		{"within_days", dsl.SearchConfig{WithinDays: 1}},
+		{"from", dsl.SearchConfig{From: "bench-sender"}},
+		{"subject", dsl.SearchConfig{Subject: "message 7"}},
+		{"larger_than", dsl.SearchConfig{Size: &dsl.SizeCriteria{LargerThan: "50K"}}},
+		{"body_contains", dsl.SearchConfig{BodyContains: needle}},
+		{"text", dsl.SearchConfig{Text: needle}},
+	}
+}
+
+// Run appends the messages to the scratch mailbox, then measures searches
+// and fetches on it, calling emit with each result as it completes.
+func Run(ctx context.Context, client *imapclient.Client, config *Config, emit func(Result) error) (err error) {
+	if config.Mailbox == "" {
+		return fmt.Errorf("bench requires a scratch mailbox")
+	}
+	if config.Messages <= 0 || len(config.Sizes) == 0 {
+		return fmt.Errorf("bench requires at least one message size and message count")
+	}
+
+	// Refuse existing mailboxes: the scratch mailbox is deleted afterwards
+	listed, err := client.List("", config.Mailbox, nil).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to list mailboxes: %w", err)
+	}
+	if len(listed) > 0 {
+		return fmt.Errorf("mailbox %s already exists, pick a new scratch mailbox", config.Mailbox)
+	}
+	if err := client.Create(config.Mailbox, nil).Wait(); err != nil {
+		return fmt.Errorf("failed to create mailbox %s: %w", config.Mailbox, err)
+	}
+	if !config.Keep {
+		defer func() {
+			if _, unselectErr := client.Select("INBOX", &imap.SelectOptions{ReadOnly: true}).Wait(); unselectErr != nil {
+				log.Debug().Err(unselectErr).Msg("Could not leave the bench mailbox")
+			}
+			if deleteErr := client.Delete(config.Mailbox).Wait(); deleteErr != nil && err == nil {
+				err = fmt.Errorf("failed to delete mailbox %s: %w", config.Mailbox, deleteErr)
+			}
+		}()
+	}
+
+	n := 0
+	for _, size := range config.Sizes {
+		result := Result{Phase: "append", Name: dsl.HumanizeSize(uint64(size))}
+		for i := 0; i < config.Messages; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			msg := benchMessage(n, size)
+			n++
+			start := time.Now()
+			cmd := client.Append(config.Mailbox, int64(len(msg)), nil)
+			if _, err := cmd.Write(msg); err != nil {
+				return fmt.Errorf("failed to append message: %w", err)
+			}
+			if err := cmd.Close(); err != nil {
+				return fmt.Errorf("failed to append message: %w", err)
+			}
+			if _, err := cmd.Wait(); err != nil {
+				return fmt.Errorf("failed to append message to %s: %w", config.Mailbox, err)
+			}
+			result.add(time.Since(start), int64(len(msg)))
+		}
+		if err := emit(result.done()); err != nil {
+			return err
+		}
+	}
+
+	if _, err := client.Select(config.Mailbox, nil).Wait(); err != nil {
+		return fmt.Errorf("failed to select mailbox %s: %w", config.Mailbox, err)
+	}
+
+	iterations := config.SearchIterations
+	if iterations <= 0 {
+		iterations = DefaultSearchIterations
+	}
+	for _, sc := range searchCases() {
+		criteria, _, err := dsl.BuildSearchCriteria(sc.search, nil)
+		if err != nil {
+			return fmt.Errorf("failed to build %s search: %w", sc.name, err)
+		}
+		result := Result{Phase: "search", Name: sc.name}
+		for i := 0; i < iterations; i++ {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			start := time.Now()
+			data, err := client.UIDSearch(criteria, nil).Wait()
+			if err != nil {
+				return fmt.Errorf("%s search failed: %w", sc.name, err)
+			}
+			result.add(time.Since(start), 0)
+			result.Matches = len(data.AllUIDs())
+		}
+		if err := emit(result.done()); err != nil {
+			return err
+		}
+	}
+
+	all := imap.SeqSet{}
+	all.AddRange(1, 0)
+	fullBody := &imap.FetchItemBodySection{Peek: true}
+	fetches := []struct {
+		name    string
+		options *imap.FetchOptions
+	}{
+		{"envelope", &imap.FetchOptions{UID: true, Envelope: true, Flags: true, RFC822Size: true}},
+		{"body", &imap.FetchOptions{UID: true, BodySection: []*imap.FetchItemBodySection{fullBody}}},
+	}
+	for _, f := range fetches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result := Result{Phase: "fetch", Name: f.name}
+		start := time.Now()
+		fetched, err := client.Fetch(all, f.options).Collect()
+		if err != nil {
+			return fmt.Errorf("%s fetch failed: %w", f.name, err)
+		}
+		var bytes int64
+		for _, msg := range fetched {
+			bytes += int64(len(msg.FindBodySection(fullBody)))
+		}
+		result.add(time.Since(start), bytes)
+		result.Operations = len(fetched)
+		if err := emit(result.done()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Result) add(d time.Duration, bytes int64) {
+	r.Operations++
+	r.Bytes += bytes
+	r.Duration += d
+	r.Latencies = append(r.Latencies, d)
+}
+
+func (r Result) done() Result {
+	sort.Slice(r.Latencies, func(i, j int) bool { return r.Latencies[i] < r.Latencies[j] })
+	return r
+}
+
+// benchMessage returns message n, padded to about size bytes. Every tenth
+// message contains the needle.
+func benchMessage(n int, size int64) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: Bench Sender <bench-sender-%d@bench.smailnail.invalid>\r\n", n%10)
+	b.WriteString("To: bench@bench.smailnail.invalid\r\n")
+	fmt.Fprintf(&b, "Subject: smailnail bench message %d\r\n", n)
+	fmt.Fprintf(&b, "Message-ID: <bench-%d@bench.smailnail.invalid>\r\n", n)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=us-ascii\r\n\r\n")
+	if n%10 == 0 {
+		b.WriteString(needle + "\r\n")
+	}
+	const line = "The quick brown fox jumps over the lazy dog while benchmarks run.\r\n"
+	for int64(b.Len()+len(line)) <= size {
+		b.WriteString(line)
+	}
+	if pad := size - int64(b.Len()) - 2; pad > 0 {
+		b.WriteString(strings.Repeat("x", int(pad)))
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
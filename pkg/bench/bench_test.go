@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+func TestBenchMessage(t *testing.T) {
+	for _, size := range []int64{100, 1024, 100 * 1024} {
+		msg := benchMessage(20, size)
+		if size > 400 {
+			assert.Equal(t, size, int64(len(msg)))
+		}
+		local, err := dsl.ParseLocalMessage(msg, 1, nil, time.Time{})
+		require.NoError(t, err)
+		assert.Equal(t, "smailnail bench message 20", local.Message.Envelope.Subject)
+	}
+	assert.Contains(t, string(benchMessage(30, 1024)), needle)
+	assert.NotContains(t, string(benchMessage(31, 1024)), needle)
+	assert.True(t, strings.HasSuffix(string(benchMessage(1, 1024)), "\r\n"))
+}
+
+func TestResultStats(t *testing.T) {
+	r := Result{}
+	for _, ms := range []int{5, 1, 3, 2, 4} {
+		r.add(time.Duration(ms)*time.Millisecond, 1024*1024)
+	}
+	r = r.done()
+	assert.Equal(t, 5, r.Operations)
+	assert.Equal(t, time.Millisecond, r.Percentile(0))
+	assert.Equal(t, 3*time.Millisecond, r.Percentile(50))
+	assert.Equal(t, 5*time.Millisecond, r.Percentile(95))
+	assert.InDelta(t, 5/0.015, r.PerSecond(), 0.01)
+	assert.InDelta(t, 5/0.015, r.MBPerSecond(), 0.01)
+	assert.Equal(t, time.Duration(0), Result{}.Percentile(50))
+}
+
+func TestSearchCasesBuild(t *testing.T) {
+	for _, sc := range searchCases() {
+		_, _, err := dsl.BuildSearchCriteria(sc.search, nil)
+		assert.NoError(t, err, sc.name)
+	}
+}
@@ -0,0 +1,240 @@
+package check
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Statuses of a Report, in the Nagios plugin convention.
+const (
+	StatusOK       = "OK"
+	StatusWarning  = "WARNING"
+	StatusCritical = "CRITICAL"
+)
+
+// probeHeader marks the probe messages, so a probe left behind by an
+// interrupted check can be recognized.
+const probeHeader = "X-Smailnail-Probe"
+
+// Config configures a check.
+type Config struct {
+	Mailbox string
+	// Probe appends a message to Mailbox and deletes it again
+	Probe bool
+	// Warning and Critical are thresholds of the total latency, 0 disables
+	// them
+	Warning  time.Duration
+	Critical time.Duration
+}
+
+// Step is the outcome of one step of the check.
+type Step struct {
+	// Name is login, select, append, delete or logout
+	Name      string  `json:"name"`
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	// Detail describes a step that succeeded in a degraded way
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the outcome of a check.
+type Report struct {
+	Status  string `json:"status"`
+	Mailbox string `json:"mailbox"`
+	// Messages is the number of messages in the mailbox when selected
+	Messages  uint32    `json:"messages"`
+	LatencyMs float64   `json:"latency_ms"`
+	Steps     []Step    `json:"steps"`
+	Time      time.Time `json:"time"`
+	// Message summarizes the status in one line, for monitoring systems
+	Message string `json:"message"`
+}
+
+// ExitCode returns the Nagios plugin exit code of the report's status.
+func (r *Report) ExitCode() int {
+	switch r.Status {
+	case StatusOK:
+		return 0
+	case StatusWarning:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Run runs a check: it logs in with dial, selects the mailbox, appends and
+// deletes a probe message if asked to, and logs out. A failing step ends the
+// check with StatusCritical; otherwise the total latency is compared to the
+// thresholds.
+func Run(ctx context.Context, dial func() (*imapclient.Client, error), config Config) *Report {
+	report := &Report{Mailbox: config.Mailbox, Time: time.Now().UTC()}
+	start := time.Now()
+
+	var client *imapclient.Client
+	ok := report.step("login", func() (string, error) {
+		var err error
+		client, err = dial()
+		return "", err
+	})
+	if !ok {
+		return report.finish(config, time.Since(start))
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	steps := []checkStep{
+		{"select", func() (string, error) {
+			data, err := client.Select(config.Mailbox, nil).Wait()
+			if err != nil {
+				return "", err
+			}
+			report.Messages = data.NumMessages
+			return "", nil
+		}},
+	}
+	if config.Probe {
+		var uid imap.UID
+		steps = append(steps,
+			checkStep{"append", func() (string, error) {
+				var err error
+				uid, err = appendProbe(client, config.Mailbox)
+				return "", err
+			}},
+			checkStep{"delete", func() (string, error) {
+				return deleteProbe(client, uid)
+			}},
+		)
+	}
+	steps = append(steps, checkStep{"logout", func() (string, error) {
+		return "", client.Logout().Wait()
+	}})
+
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			report.Steps = append(report.Steps, Step{Name: step.name, Error: err.Error()})
+			break
+		}
+		if !report.step(step.name, step.run) {
+			break
+		}
+	}
+	return report.finish(config, time.Since(start))
+}
+
+// checkStep is a step of Run after the login. run returns the Detail of the
+// step.
+type checkStep struct {
+	name string
+	run  func() (string, error)
+}
+
+// step runs fn as the step name and records its outcome.
+func (r *Report) step(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	step := Step{Name: name, OK: err == nil, LatencyMs: milliseconds(time.Since(start)), Detail: detail}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	r.Steps = append(r.Steps, step)
+	return err == nil
+}
+
+// finish sets the status, latency and message of the report.
+func (r *Report) finish(config Config, latency time.Duration) *Report {
+	r.LatencyMs = milliseconds(latency)
+	for _, step := range r.Steps {
+		if !step.OK {
+			r.Status = StatusCritical
+			r.Message = fmt.Sprintf("%s failed: %s", step.Name, step.Error)
+			return r
+		}
+	}
+	r.Status = StatusOK
+	switch {
+	case config.Critical > 0 && latency >= config.Critical:
+		r.Status = StatusCritical
+	case config.Warning > 0 && latency >= config.Warning:
+		r.Status = StatusWarning
+	}
+	r.Message = fmt.Sprintf("%s: %d messages in %s, checked in %s", r.Status, r.Messages, r.Mailbox, latency.Round(time.Millisecond))
+	return r
+}
+
+// appendProbe appends a probe message to mailbox and returns its UID.
+func appendProbe(client *imapclient.Client, mailbox string) (imap.UID, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return 0, err
+	}
+	id := hex.EncodeToString(token)
+	msg := []byte(fmt.Sprintf("From: smailnail check <check@smailnail.invalid>\r\n"+
+		"To: smailnail check <check@smailnail.invalid>\r\n"+
+		"Subject: smailnail check probe\r\n"+
+		"Date: %s\r\n"+
+		"Message-ID: <%s@smailnail.invalid>\r\n"+
+		"%s: %s\r\n"+
+		"\r\n"+
+		"This message is appended and deleted by smailnail check.\r\n",
+		time.Now().Format(time.RFC1123Z), id, probeHeader, id))
+
+	cmd := client.Append(mailbox, int64(len(msg)), &imap.AppendOptions{Flags: []imap.Flag{imap.FlagSeen}})
+	if _, err := cmd.Write(msg); err != nil {
+		return 0, fmt.Errorf("failed to append probe: %w", err)
+	}
+	if err := cmd.Close(); err != nil {
+		return 0, fmt.Errorf("failed to append probe: %w", err)
+	}
+	data, err := cmd.Wait()
+	if err != nil {
+		return 0, fmt.Errorf("failed to append probe: %w", err)
+	}
+	if data.UID != 0 {
+		return data.UID, nil
+	}
+
+	// Without UIDPLUS, find the probe by its header
+	found, err := client.UIDSearch(&imap.SearchCriteria{
+		Header: []imap.SearchCriteriaHeaderField{{Key: probeHeader, Value: id}},
+	}, nil).Wait()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find probe: %w", err)
+	}
+	uids := found.AllUIDs()
+	if len(uids) != 1 {
+		return 0, fmt.Errorf("found %d probe messages instead of 1", len(uids))
+	}
+	return uids[0], nil
+}
+
+// deleteProbe deletes the probe message. Without UIDPLUS, expunging would
+// also remove other messages marked deleted, so the probe is only marked.
+func deleteProbe(client *imapclient.Client, uid imap.UID) (string, error) {
+	uids := imap.UIDSetNum(uid)
+	if _, err := client.Store(uids, &imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+	}, nil).Collect(); err != nil {
+		return "", fmt.Errorf("failed to mark probe as deleted: %w", err)
+	}
+	if !client.Caps().Has(imap.CapUIDPlus) {
+		return "probe marked deleted but not expunged, the server lacks UIDPLUS", nil
+	}
+	if err := client.UIDExpunge(uids).Close(); err != nil {
+		return "", fmt.Errorf("failed to expunge probe: %w", err)
+	}
+	return "", nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
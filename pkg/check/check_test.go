@@ -0,0 +1,96 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	smailnailimap "github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+func replayDial(t *testing.T, fixture string) func() (*imapclient.Client, error) {
+	t.Helper()
+	f, err := smailnailimap.LoadFixture(fixture)
+	require.NoError(t, err)
+	return func() (*imapclient.Client, error) {
+		conn, _ := f.Pipe()
+		client := imapclient.New(conn, nil)
+		if err := client.Login("user", "password").Wait(); err != nil {
+			_ = client.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+type fakeSender struct {
+	to  []string
+	msg string
+}
+
+func (s *fakeSender) From() string { return "probe@example.com" }
+
+func (s *fakeSender) Send(to []string, msg []byte) error {
+	s.to = to
+	s.msg = string(msg)
+	return nil
+}
+
+func TestDelivery(t *testing.T) {
+	dial := replayDial(t, "testdata/delivery.imap")
+	sender := &fakeSender{}
+	report := Delivery(context.Background(), sender, func(*imapclient.UnilateralDataHandler) (*imapclient.Client, error) {
+		return dial()
+	}, DeliveryConfig{To: "me@example.com", Mailbox: "INBOX", Interval: time.Millisecond, ID: "0123abcd"})
+
+	assert.Equal(t, StatusOK, report.Status, report.Message)
+	assert.True(t, report.Delivered)
+	assert.Equal(t, "poll", report.Method)
+	assert.Equal(t, []string{"me@example.com"}, sender.to)
+	assert.Contains(t, sender.msg, "X-Smailnail-Probe: 0123abcd\r\n")
+	assert.Contains(t, sender.msg, "From: probe@example.com\r\n")
+}
+
+func TestRunOK(t *testing.T) {
+	report := Run(context.Background(), replayDial(t, "testdata/select.imap"), Config{Mailbox: "INBOX"})
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, 0, report.ExitCode())
+	assert.Equal(t, uint32(3), report.Messages)
+	require.Len(t, report.Steps, 3)
+	for i, name := range []string{"login", "select", "logout"} {
+		assert.Equal(t, name, report.Steps[i].Name)
+		assert.True(t, report.Steps[i].OK)
+	}
+	assert.Contains(t, report.Message, "OK: 3 messages in INBOX")
+}
+
+func TestRunFailures(t *testing.T) {
+	report := Run(context.Background(), replayDial(t, "testdata/missing_mailbox.imap"), Config{Mailbox: "Missing"})
+	assert.Equal(t, StatusCritical, report.Status)
+	assert.Equal(t, 2, report.ExitCode())
+	require.Len(t, report.Steps, 2)
+	assert.False(t, report.Steps[1].OK)
+	assert.Contains(t, report.Message, "select failed")
+
+	report = Run(context.Background(), func() (*imapclient.Client, error) {
+		return nil, errors.New("connection refused")
+	}, Config{Mailbox: "INBOX"})
+	assert.Equal(t, StatusCritical, report.Status)
+	assert.Equal(t, "login failed: connection refused", report.Message)
+}
+
+func TestFinishThresholds(t *testing.T) {
+	config := Config{Warning: time.Second, Critical: 5 * time.Second}
+	report := (&Report{Steps: []Step{{Name: "login", OK: true}}}).finish(config, 2*time.Second)
+	assert.Equal(t, StatusWarning, report.Status)
+	assert.Equal(t, 1, report.ExitCode())
+	assert.Equal(t, 2000.0, report.LatencyMs)
+
+	report = (&Report{Steps: []Step{{Name: "login", OK: true}}}).finish(config, 5*time.Second)
+	assert.Equal(t, StatusCritical, report.Status)
+}
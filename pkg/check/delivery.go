@@ -0,0 +1,225 @@
+package check
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Defaults of DeliveryConfig.
+const (
+	DefaultDeliveryTimeout  = 5 * time.Minute
+	DefaultDeliveryInterval = 2 * time.Second
+)
+
+// Sender sends the probe message of Delivery. *smtp.Sender implements it.
+type Sender interface {
+	From() string
+	Send(to []string, msg []byte) error
+}
+
+// DeliveryConfig configures a delivery probe.
+type DeliveryConfig struct {
+	// To is the address the probe is sent to, the owner of the IMAP account
+	To string
+	// Mailbox is where the probe is expected to arrive
+	Mailbox string
+	// Timeout is how long to wait for the probe, DefaultDeliveryTimeout if 0
+	Timeout time.Duration
+	// Interval is the time between searches for the probe,
+	// DefaultDeliveryInterval if 0. With IDLE, a search also runs as soon as
+	// the server reports a new message.
+	Interval time.Duration
+	// Keep leaves the probe in the mailbox instead of deleting it
+	Keep bool
+	// Warning and Critical are thresholds of the delivery latency, 0
+	// disables them
+	Warning  time.Duration
+	Critical time.Duration
+	// ID tags the probe, a random token if empty
+	ID string
+}
+
+// DeliveryReport is the outcome of a delivery probe.
+type DeliveryReport struct {
+	Status  string `json:"status"`
+	ID      string `json:"id"`
+	To      string `json:"to"`
+	Mailbox string `json:"mailbox"`
+	// SendMs is how long the SMTP server took to accept the probe
+	SendMs    float64 `json:"send_ms"`
+	Delivered bool    `json:"delivered"`
+	// LatencyMs is the time from sending the probe to finding it
+	LatencyMs float64 `json:"latency_ms"`
+	// Method is idle when the server pushed the new messages, poll
+	// otherwise
+	Method  string    `json:"method"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+	Message string    `json:"message"`
+}
+
+// ExitCode returns the Nagios plugin exit code of the report's status.
+func (r *DeliveryReport) ExitCode() int {
+	return (&Report{Status: r.Status}).ExitCode()
+}
+
+// Delivery sends a probe message through sender and measures how long it
+// takes to appear in the mailbox, logging in with dial. dial must pass the
+// handler to the client, for the probe to notice new messages during IDLE.
+func Delivery(
+	ctx context.Context,
+	sender Sender,
+	dial func(handler *imapclient.UnilateralDataHandler) (*imapclient.Client, error),
+	config DeliveryConfig,
+) *DeliveryReport {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultDeliveryTimeout
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultDeliveryInterval
+	}
+	report := &DeliveryReport{ID: config.ID, To: config.To, Mailbox: config.Mailbox, Method: "poll", Time: time.Now().UTC()}
+	fail := func(format string, args ...interface{}) *DeliveryReport {
+		report.Status = StatusCritical
+		report.Error = fmt.Sprintf(format, args...)
+		report.Message = report.Error
+		return report
+	}
+	if report.ID == "" {
+		token := make([]byte, 8)
+		if _, err := rand.Read(token); err != nil {
+			return fail("failed to generate probe id: %v", err)
+		}
+		report.ID = hex.EncodeToString(token)
+	}
+
+	// EXISTS responses wake the wait for the next search
+	arrived := make(chan struct{}, 1)
+	handler := &imapclient.UnilateralDataHandler{
+		Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+			if data.NumMessages == nil {
+				return
+			}
+			select {
+			case arrived <- struct{}{}:
+			default:
+			}
+		},
+	}
+	client, err := dial(handler)
+	if err != nil {
+		return fail("login failed: %v", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	if _, err := client.Select(config.Mailbox, nil).Wait(); err != nil {
+		return fail("select failed: %v", err)
+	}
+	if client.Caps().Has(imap.CapIdle) {
+		report.Method = "idle"
+	}
+
+	sent := time.Now()
+	if err := sender.Send([]string{config.To}, deliveryProbe(sender.From(), config.To, report.ID)); err != nil {
+		return fail("send failed: %v", err)
+	}
+	report.SendMs = milliseconds(time.Since(sent))
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	criteria := &imap.SearchCriteria{
+		Header: []imap.SearchCriteriaHeaderField{{Key: probeHeader, Value: report.ID}},
+	}
+	var uids []imap.UID
+	for {
+		found, err := client.UIDSearch(criteria, nil).Wait()
+		if err != nil {
+			return fail("search failed: %v", err)
+		}
+		if uids = found.AllUIDs(); len(uids) > 0 {
+			break
+		}
+		if err := waitForMessages(ctx, client, report.Method == "idle", arrived, config.Interval); err != nil {
+			if ctx.Err() != nil {
+				return fail("probe %s not delivered to %s within %s", report.ID, config.Mailbox, config.Timeout)
+			}
+			return fail("waiting for the probe failed: %v", err)
+		}
+	}
+	latency := time.Since(sent)
+	report.Delivered = true
+	report.LatencyMs = milliseconds(latency)
+
+	if !config.Keep {
+		for _, uid := range uids {
+			if _, err := deleteProbe(client, uid); err != nil {
+				return fail("%v", err)
+			}
+		}
+	}
+	_ = client.Logout().Wait()
+
+	report.Status = StatusOK
+	switch {
+	case config.Critical > 0 && latency >= config.Critical:
+		report.Status = StatusCritical
+	case config.Warning > 0 && latency >= config.Warning:
+		report.Status = StatusWarning
+	}
+	report.Message = fmt.Sprintf("%s: probe delivered to %s in %s", report.Status, config.Mailbox, latency.Round(time.Millisecond))
+	return report
+}
+
+// waitForMessages waits for interval, or until the server reports a new
+// message during IDLE. Without IDLE, a NOOP then asks the server for news.
+func waitForMessages(ctx context.Context, client *imapclient.Client, idle bool, arrived <-chan struct{}, interval time.Duration) error {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	if !idle {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		return client.Noop().Wait()
+	}
+
+	idleCmd, err := client.Idle()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	case <-arrived:
+	}
+	if err := idleCmd.Close(); err != nil {
+		return err
+	}
+	if err := idleCmd.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// deliveryProbe builds the probe message sent by Delivery.
+func deliveryProbe(from string, to string, id string) []byte {
+	return []byte(fmt.Sprintf("From: %s\r\n"+
+		"To: %s\r\n"+
+		"Subject: smailnail delivery probe %s\r\n"+
+		"Date: %s\r\n"+
+		"Message-ID: <%s@smailnail.invalid>\r\n"+
+		"%s: %s\r\n"+
+		"\r\n"+
+		"This message measures the delivery latency of the mail system and is\r\n"+
+		"deleted by smailnail probe once found.\r\n",
+		from, to, id, time.Now().Format(time.RFC1123Z), id, probeHeader, id))
+}
@@ -0,0 +1,247 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// validRights are the ACL rights of RFC 4314, the obsolete c and d rights of
+// RFC 2086, and the digits servers may use for their own rights.
+const validRights = "lrswipkxteacd0123456789"
+
+// RequiredRight is an ACL right a rule needs on a mailbox, and the part of
+// the rule that needs it.
+type RequiredRight struct {
+	Mailbox string `json:"mailbox"`
+	Right   string `json:"right"`
+	Reason  string `json:"reason"`
+}
+
+// RightsError is returned when the account lacks rights a rule needs on a
+// mailbox. It matches ErrInsufficientRights.
+type RightsError struct {
+	Mailbox string
+	// Rights are the account's rights on the mailbox, as MYRIGHTS reports them
+	Rights  string
+	Missing []RequiredRight
+}
+
+func (e *RightsError) Error() string {
+	missing := make([]string, len(e.Missing))
+	for i, right := range e.Missing {
+		missing[i] = fmt.Sprintf("%s (%s)", right.Right, right.Reason)
+	}
+	rights := e.Rights
+	if rights == "" {
+		rights = "none"
+	}
+	return fmt.Sprintf("mailbox %q: missing rights %s; the account has %s",
+		e.Mailbox, strings.Join(missing, ", "), rights)
+}
+
+func (e *RightsError) Is(target error) bool {
+	return target == ErrInsufficientRights
+}
+
+// ValidateRights checks that rights only holds known ACL rights.
+func ValidateRights(rights string) error {
+	if rights == "" {
+		return fmt.Errorf("empty rights")
+	}
+	for _, r := range rights {
+		if !strings.ContainsRune(validRights, r) {
+			return fmt.Errorf("unknown right %q in %q (rights are letters of %s)", r, rights, validRights)
+		}
+	}
+	return nil
+}
+
+// RequiredRights lists the rights the rule's search and actions need, on the
+// mailbox it searches and on the fixed targets of its actions, in the order
+// of the actions. Templated targets are only known per message and are left
+// out.
+func (rule *Rule) RequiredRights(defaultMailbox string) []RequiredRight {
+	var required []RequiredRight
+	seen := make(map[RequiredRight]bool)
+	add := func(mailbox, rights, reason string) {
+		for _, r := range rights {
+			right := RequiredRight{Mailbox: mailbox, Right: string(r)}
+			if seen[right] {
+				continue
+			}
+			seen[right] = true
+			right.Reason = reason
+			required = append(required, right)
+		}
+	}
+
+	source := rule.MailboxName(rule.SourceMailbox(defaultMailbox))
+	add(source, "r", "search")
+
+	actions := []*ActionConfig{&rule.Actions}
+	for _, label := range sortedLabels(rule.Actions.ByLabel) {
+		actions = append(actions, rule.Actions.ByLabel[label])
+	}
+	for _, a := range actions {
+		if a.Flags != nil {
+			for _, flag := range convertToIMAPFlags(append(append([]string(nil), a.Flags.Add...), a.Flags.Remove...)) {
+				switch {
+				case strings.EqualFold(string(flag), string(imap.FlagSeen)):
+					add(source, "s", "flags")
+				case strings.EqualFold(string(flag), string(imap.FlagDeleted)):
+					add(source, "t", "flags")
+				default:
+					add(source, "w", "flags")
+				}
+			}
+		}
+		if a.CopyTo != "" && !isTemplate(a.CopyTo) {
+			add(rule.MailboxName(a.CopyTo), "i", "copy_to")
+		}
+		if a.MoveTo != "" {
+			add(source, "te", "move_to")
+			if !isTemplate(a.MoveTo) {
+				add(rule.MailboxName(a.MoveTo), "i", "move_to")
+			}
+		}
+		if a.Delete != nil {
+			add(source, "te", "delete")
+			if deletesToTrash(a.Delete) {
+				add(rule.MailboxName("Trash"), "i", "delete")
+			}
+		}
+		if a.Snooze != nil {
+			add(source, "te", "snooze")
+			add(rule.MailboxName(a.Snooze.folder()), "i", "snooze")
+		}
+		if a.FollowUp != nil {
+			add(source, "w", "follow_up")
+		}
+	}
+	return required
+}
+
+// CheckRights asks the server for the account's rights (MYRIGHTS, RFC 4314)
+// on every mailbox the rule acts on, and returns a *RightsError for the first
+// mailbox missing rights the rule needs, before anything runs. Servers
+// without the ACL extension are not checked.
+func (rule *Rule) CheckRights(client *imapclient.Client, defaultMailbox string) error {
+	if !client.Caps().Has(imap.CapACL) {
+		return nil
+	}
+
+	byMailbox := make(map[string][]RequiredRight)
+	var mailboxes []string
+	for _, right := range rule.RequiredRights(defaultMailbox) {
+		if _, ok := byMailbox[right.Mailbox]; !ok {
+			mailboxes = append(mailboxes, right.Mailbox)
+		}
+		byMailbox[right.Mailbox] = append(byMailbox[right.Mailbox], right)
+	}
+
+	for _, mailbox := range mailboxes {
+		rights, err := MyRights(client, mailbox)
+		if err != nil {
+			return err
+		}
+		var missing []RequiredRight
+		for _, right := range byMailbox[mailbox] {
+			if !HasRight(rights, right.Right) {
+				missing = append(missing, right)
+			}
+		}
+		if len(missing) > 0 {
+			return &RightsError{Mailbox: mailbox, Rights: rights, Missing: missing}
+		}
+	}
+	return nil
+}
+
+// HasRight reports whether rights grants right. The obsolete d right of RFC
+// 2086 grants t, e and x, and c grants k.
+func HasRight(rights, right string) bool {
+	if strings.Contains(rights, right) {
+		return true
+	}
+	switch right {
+	case "t", "e", "x":
+		return strings.Contains(rights, "d")
+	case "k":
+		return strings.Contains(rights, "c")
+	}
+	return false
+}
+
+// ACLEntry is the rights an identifier, a user or a group, has on a mailbox.
+type ACLEntry struct {
+	Identifier string `json:"identifier"`
+	Rights     string `json:"rights"`
+}
+
+// MyRights returns the rights of the logged in account on mailbox.
+func MyRights(client *imapclient.Client, mailbox string) (string, error) {
+	data, err := client.MyRights(mailbox).Wait()
+	if err != nil {
+		return "", fmt.Errorf("failed to get rights on mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+	return formatRights(data.Rights), nil
+}
+
+// GetACL returns the access control list of mailbox, sorted by identifier.
+// Reading it requires the a right.
+func GetACL(client *imapclient.Client, mailbox string) ([]ACLEntry, error) {
+	data, err := client.GetACL(mailbox).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the ACL of mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+	entries := make([]ACLEntry, 0, len(data.Rights))
+	for identifier, rights := range data.Rights {
+		entries = append(entries, ACLEntry{Identifier: string(identifier), Rights: formatRights(rights)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Identifier < entries[j].Identifier
+	})
+	return entries, nil
+}
+
+// SetACL changes the rights of identifier on mailbox. A leading + adds the
+// rights, a leading - removes them, and otherwise they replace the current
+// ones.
+func SetACL(client *imapclient.Client, mailbox, identifier, rights string) error {
+	if identifier == "" {
+		return fmt.Errorf("empty identifier")
+	}
+	modification := imap.RightModificationReplace
+	switch {
+	case strings.HasPrefix(rights, "+"):
+		modification = imap.RightModificationAdd
+		rights = rights[1:]
+	case strings.HasPrefix(rights, "-"):
+		modification = imap.RightModificationRemove
+		rights = rights[1:]
+	}
+	if err := ValidateRights(rights); err != nil {
+		return err
+	}
+
+	set := make(imap.RightSet, 0, len(rights))
+	for _, r := range rights {
+		set = append(set, imap.Right(r))
+	}
+	if err := client.SetACL(mailbox, imap.RightsIdentifier(identifier), modification, set).Wait(); err != nil {
+		return fmt.Errorf("failed to set the rights of %s on mailbox %q: %w", identifier, mailbox, wrapMailboxError(err, mailbox))
+	}
+	return nil
+}
+
+func formatRights(rights imap.RightSet) string {
+	var b strings.Builder
+	for _, r := range rights {
+		b.WriteRune(rune(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,72 @@
+package dsl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredRights(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: file-invoices
+mailbox: Shared/Billing
+search:
+  subject_contains: invoice
+classify: {}
+output:
+  fields: [subject]
+actions:
+  flags:
+    add: [seen, $Invoice]
+  move_to: Archive/Invoices
+  by_label:
+    spam:
+      delete:
+        trash: true
+`)
+	require.NoError(t, err)
+	rule.SetNamespace(&Namespace{Delimiter: '.'})
+
+	got := rule.RequiredRights("INBOX")
+	assert.Equal(t, []RequiredRight{
+		{Mailbox: "Shared.Billing", Right: "r", Reason: "search"},
+		{Mailbox: "Shared.Billing", Right: "s", Reason: "flags"},
+		{Mailbox: "Shared.Billing", Right: "w", Reason: "flags"},
+		{Mailbox: "Shared.Billing", Right: "t", Reason: "move_to"},
+		{Mailbox: "Shared.Billing", Right: "e", Reason: "move_to"},
+		{Mailbox: "Archive.Invoices", Right: "i", Reason: "move_to"},
+		{Mailbox: "Trash", Right: "i", Reason: "delete"},
+	}, got)
+}
+
+func TestRequiredRightsSearchOnly(t *testing.T) {
+	rule := &Rule{Name: "read-only"}
+	assert.Equal(t, []RequiredRight{{Mailbox: "INBOX", Right: "r", Reason: "search"}}, rule.RequiredRights("INBOX"))
+}
+
+func TestHasRight(t *testing.T) {
+	assert.True(t, HasRight("lrswite", "t"))
+	assert.False(t, HasRight("lrs", "w"))
+	// Obsolete RFC 2086 rights
+	assert.True(t, HasRight("lrswd", "e"))
+	assert.True(t, HasRight("lrswc", "k"))
+	assert.False(t, HasRight("lrswc", "t"))
+}
+
+func TestValidateRights(t *testing.T) {
+	require.NoError(t, ValidateRights("lrswipkxtea"))
+	require.Error(t, ValidateRights(""))
+	require.Error(t, ValidateRights("lrz"))
+}
+
+func TestRightsError(t *testing.T) {
+	err := error(&RightsError{
+		Mailbox: "Shared/Billing",
+		Rights:  "lr",
+		Missing: []RequiredRight{{Mailbox: "Shared/Billing", Right: "t", Reason: "move_to"}},
+	})
+	assert.True(t, errors.Is(err, ErrInsufficientRights))
+	assert.EqualError(t, err, `mailbox "Shared/Billing": missing rights t (move_to); the account has lr`)
+}
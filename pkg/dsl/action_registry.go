@@ -0,0 +1,164 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"gopkg.in/yaml.v3"
+)
+
+// ActionHandler implements a custom action that can be referenced from rule
+// YAML by name. The raw config blob found under the action's key is passed
+// to both methods as-is (typically a map[string]interface{}, a scalar or a
+// list); use DecodeActionConfig to turn it into a typed struct.
+//
+//	actions:
+//	  flags:
+//	    add: [seen]
+//	  index_into_meilisearch:
+//	    url: http://localhost:7700
+//	    index: mail
+type ActionHandler interface {
+	// Validate checks the config blob when the rule is parsed.
+	Validate(config interface{}) error
+	// Execute applies the action to the matched messages. The client has the
+	// rule's mailbox selected.
+	Execute(client *imapclient.Client, messages []*EmailMessage, config interface{}) error
+}
+
+// ActionHandlerFuncs adapts plain functions to the ActionHandler interface.
+// A nil ValidateFunc accepts any config.
+type ActionHandlerFuncs struct {
+	ValidateFunc func(config interface{}) error
+	ExecuteFunc  func(client *imapclient.Client, messages []*EmailMessage, config interface{}) error
+}
+
+func (f ActionHandlerFuncs) Validate(config interface{}) error {
+	if f.ValidateFunc == nil {
+		return nil
+	}
+	return f.ValidateFunc(config)
+}
+
+func (f ActionHandlerFuncs) Execute(client *imapclient.Client, messages []*EmailMessage, config interface{}) error {
+	if f.ExecuteFunc == nil {
+		return nil
+	}
+	return f.ExecuteFunc(client, messages, config)
+}
+
+// builtinActionNames are the keys handled directly by ActionConfig. Custom
+// actions cannot shadow them.
+var builtinActionNames = map[string]bool{
+	"flags":               true,
+	"move_to":             true,
+	"copy_to":             true,
+	"delete":              true,
+	"export":              true,
+	"snooze":              true,
+	"follow_up":           true,
+	"unsubscribe":         true,
+	"notify":              true,
+	"index_elasticsearch": true,
+	"publish":             true,
+	"database":            true,
+	"create_issue":        true,
+	"by_label":            true,
+	"stop":                true,
+}
+
+// ActionRegistry maps action names to handlers.
+type ActionRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]ActionHandler
+}
+
+// NewActionRegistry creates an empty registry.
+func NewActionRegistry() *ActionRegistry {
+	return &ActionRegistry{
+		handlers: make(map[string]ActionHandler),
+	}
+}
+
+// DefaultActionRegistry is consulted when validating and executing rules.
+var DefaultActionRegistry = NewActionRegistry()
+
+// RegisterAction registers a handler in DefaultActionRegistry.
+func RegisterAction(name string, handler ActionHandler) error {
+	return DefaultActionRegistry.Register(name, handler)
+}
+
+// Register adds a handler under name. Names must be unique and must not
+// collide with built-in actions.
+func (r *ActionRegistry) Register(name string, handler ActionHandler) error {
+	if name == "" {
+		return fmt.Errorf("action name is required")
+	}
+	if handler == nil {
+		return fmt.Errorf("action handler for %s is nil", name)
+	}
+	if builtinActionNames[name] {
+		return fmt.Errorf("action %s is a built-in action and cannot be overridden", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.handlers[name]; exists {
+		return fmt.Errorf("action %s is already registered", name)
+	}
+	r.handlers[name] = handler
+	return nil
+}
+
+// Unregister removes a handler. It is a no-op for unknown names.
+func (r *ActionRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.handlers, name)
+}
+
+// Lookup returns the handler registered under name.
+func (r *ActionRegistry) Lookup(name string) (ActionHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// Names returns the registered action names in sorted order.
+func (r *ActionRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DecodeActionConfig converts a raw config blob into target, which must be a
+// pointer to a struct with yaml tags.
+func DecodeActionConfig(config interface{}, target interface{}) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal action config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("failed to decode action config: %w", err)
+	}
+	return nil
+}
+
+// sortedCustomActionNames returns the custom action keys of a config in a
+// stable order so execution is deterministic.
+func sortedCustomActionNames(custom map[string]interface{}) []string {
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
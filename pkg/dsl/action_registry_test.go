@@ -0,0 +1,113 @@
+package dsl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingConfig struct {
+	Index string `yaml:"index"`
+}
+
+func registerRecordingAction(t *testing.T, name string, calls *[]uint32, seen *recordingConfig) {
+	t.Helper()
+	handler := ActionHandlerFuncs{
+		ValidateFunc: func(config interface{}) error {
+			var cfg recordingConfig
+			if err := DecodeActionConfig(config, &cfg); err != nil {
+				return err
+			}
+			if cfg.Index == "" {
+				return errors.New("index is required")
+			}
+			return nil
+		},
+		ExecuteFunc: func(_ *imapclient.Client, messages []*EmailMessage, config interface{}) error {
+			if err := DecodeActionConfig(config, seen); err != nil {
+				return err
+			}
+			for _, msg := range messages {
+				*calls = append(*calls, msg.UID)
+			}
+			return nil
+		},
+	}
+	require.NoError(t, RegisterAction(name, handler))
+	t.Cleanup(func() { DefaultActionRegistry.Unregister(name) })
+}
+
+func TestActionRegistryRejectsDuplicatesAndBuiltins(t *testing.T) {
+	registry := NewActionRegistry()
+	handler := ActionHandlerFuncs{}
+
+	require.NoError(t, registry.Register("notify_me", handler))
+	assert.Error(t, registry.Register("notify_me", handler))
+	assert.Error(t, registry.Register("move_to", handler))
+	assert.Error(t, registry.Register("", handler))
+	assert.Equal(t, []string{"notify_me"}, registry.Names())
+}
+
+func TestCustomActionFromRuleYAML(t *testing.T) {
+	var calls []uint32
+	var seen recordingConfig
+	registerRecordingAction(t, "index_into_test", &calls, &seen)
+
+	rule, err := ParseRuleString(`
+name: custom
+search:
+  subject_contains: invoice
+output:
+  fields: [uid]
+actions:
+  index_into_test:
+    index: mail
+`)
+	require.NoError(t, err)
+	require.Contains(t, rule.Actions.Custom, "index_into_test")
+
+	err = ExecuteActions(nil, []*EmailMessage{{UID: 3}, {UID: 5}}, &rule.Actions)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{3, 5}, calls)
+	assert.Equal(t, "mail", seen.Index)
+}
+
+func TestCustomActionValidation(t *testing.T) {
+	var calls []uint32
+	var seen recordingConfig
+	registerRecordingAction(t, "index_into_test", &calls, &seen)
+
+	_, err := ParseRuleString(`
+name: custom
+output:
+  fields: [uid]
+actions:
+  index_into_test: {}
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "index is required")
+
+	_, err = ParseRuleString(`
+name: custom
+output:
+  fields: [uid]
+actions:
+  not_registered: true
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown action: not_registered")
+}
+
+func TestRuleWithoutCustomActionsHasEmptyActions(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: plain
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	assert.True(t, reflect.DeepEqual(rule.Actions, ActionConfig{}))
+}
@@ -1,6 +1,8 @@
 package dsl
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,65 +12,264 @@ import (
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ExecuteActions performs the specified actions on the matched messages
 func ExecuteActions(client *imapclient.Client, messages []*EmailMessage, actions *ActionConfig) error {
+	_, err := executeActions(client, messages, actions, nil)
+	return err
+}
+
+// ExecuteActions performs the rule's actions on the matched messages. Unlike
+// the package-level ExecuteActions, templates see the rule metadata.
+func (rule *Rule) ExecuteActions(client *imapclient.Client, messages []*EmailMessage) error {
+	_, err := rule.ExecuteActionsWithResults(client, messages)
+	return err
+}
+
+// ExecuteActionsWithResults is ExecuteActions, also returning the outcome
+// of each action attempted, in execution order. As with ExecuteActions, the
+// actions after a failed one are not run.
+func (rule *Rule) ExecuteActionsWithResults(client *imapclient.Client, messages []*EmailMessage) ([]ActionResult, error) {
+	if rule.DryRun {
+		logger := rule.Logger()
+		logger.Info().
+			Int("message_count", len(messages)).
+			Msg("Dry run, not executing actions")
+		return nil, nil
+	}
+	results, err := executeActions(client, messages, &rule.Actions, rule)
+	if err == nil && rule.Actions.Stop && rule.handled != nil {
+		rule.handled.Add(selectedMailbox(client), messages)
+	}
+	if err == nil && rule.processed != nil {
+		rule.processed.Add(selectedMailbox(client), messages)
+	}
+	return results, err
+}
+
+// ActionResult is the outcome of one action applied to the matched
+// messages.
+type ActionResult struct {
+	// Action is the action's key in the rule, e.g. "move_to"
+	Action   string `json:"action"`
+	Messages int    `json:"messages"`
+	// Failed is the number of messages the action failed for
+	Failed int    `json:"failed,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Details describe what the action triggered, e.g. the unsubscribe
+	// requests sent
+	Details []string `json:"details,omitempty"`
+}
+
+// actionRecorder collects the ActionResults of one executeActions call.
+type actionRecorder struct {
+	messages int
+	results  []ActionResult
+	// rule receives the ActionApplied and ErrorOccurred events, if not nil
+	rule *Rule
+}
+
+func (r *actionRecorder) run(action string, fn func() error) error {
+	return r.runWithDetails(action, func() ([]string, error) {
+		return nil, fn()
+	})
+}
+
+func (r *actionRecorder) runWithDetails(action string, fn func() ([]string, error)) error {
+	start := time.Now()
+	var span trace.Span
+	if r.rule != nil {
+		span = r.rule.startSpan("smailnail.action", AttrAction.String(action))
+	}
+	details, err := fn()
+	result := ActionResult{Action: action, Messages: r.messages, Details: details}
+	if err != nil {
+		result.Error = err.Error()
+		result.Failed = r.messages
+		var partial *ActionPartialFailureError
+		if errors.As(err, &partial) {
+			result.Failed = len(partial.Failed)
+		}
+	}
+	r.results = append(r.results, result)
+	if span != nil {
+		span.SetAttributes(AttrMessages.Int(result.Messages), AttrFailed.Int(result.Failed))
+		endSpan(span, err)
+	}
+	if r.rule != nil {
+		r.rule.publish(ActionApplied{Rule: r.rule.Name, Result: result, Duration: time.Since(start)})
+		if err != nil {
+			r.rule.publish(ErrorOccurred{Rule: r.rule.Name, Stage: StageAction, Err: fmt.Errorf("%s: %w", action, err)})
+		}
+	}
+	return err
+}
+
+func executeActions(client *imapclient.Client, messages []*EmailMessage, actions *ActionConfig, rule *Rule) ([]ActionResult, error) {
+	logger := rule.Logger()
 	if actions == nil || reflect.DeepEqual(*actions, ActionConfig{}) {
-		return nil
+		return nil, nil
 	}
+	if len(actions.ByLabel) > 0 {
+		return executeLabelActions(client, messages, actions, rule)
+	}
+	rec := &actionRecorder{messages: len(messages), rule: rule}
 
 	startTime := time.Now()
-	log.Debug().
+	logger.Debug().
 		Int("message_count", len(messages)).
 		Msg("Starting to execute actions on messages")
 
 	// Execute flag operations
 	if actions.Flags != nil {
-		if err := executeFlags(client, messages, actions.Flags); err != nil {
-			return fmt.Errorf("failed to execute flag actions: %w", err)
+		if err := rec.run("flags", func() error { return executeFlags(client, messages, actions.Flags, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to execute flag actions: %w", err)
 		}
 	}
 
 	// Execute copy operation before move or delete
 	if actions.CopyTo != "" {
-		if err := executeCopy(client, messages, actions.CopyTo); err != nil {
-			return fmt.Errorf("failed to copy messages to %s: %w", actions.CopyTo, err)
+		if err := rec.run("copy_to", func() error { return executeCopy(client, messages, actions.CopyTo, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to copy messages to %s: %w", actions.CopyTo, err)
+		}
+	}
+
+	// Execute custom actions while the messages are still in the selected mailbox
+	if len(actions.Custom) > 0 {
+		if err := executeCustomActions(client, messages, actions.Custom, rec, rule); err != nil {
+			return rec.results, err
+		}
+	}
+
+	// Unsubscribing reads the list headers of the messages in place
+	if actions.Unsubscribe != nil {
+		err := rec.runWithDetails("unsubscribe", func() ([]string, error) {
+			return executeUnsubscribe(client, messages, actions.Unsubscribe, rule)
+		})
+		if err != nil {
+			return rec.results, fmt.Errorf("failed to unsubscribe: %w", err)
+		}
+	}
+
+	// Notify while the messages are still in place, so a failing move does
+	// not hide them from the humans
+	if actions.Notify != nil {
+		if err := rec.run("notify", func() error { return executeNotify(messages, actions.Notify, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to notify: %w", err)
+		}
+	}
+
+	// Index before the messages are moved away
+	if actions.IndexElasticsearch != nil {
+		err := rec.run("index_elasticsearch", func() error {
+			return executeIndexElasticsearch(client, messages, actions.IndexElasticsearch, rule)
+		})
+		if err != nil {
+			return rec.results, fmt.Errorf("failed to index messages: %w", err)
 		}
 	}
 
+	if actions.Publish != nil {
+		if err := rec.run("publish", func() error { return executePublish(client, messages, actions.Publish, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to publish events: %w", err)
+		}
+	}
+
+	if actions.Database != nil {
+		if err := rec.run("database", func() error { return executeDatabase(client, messages, actions.Database, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to write messages to %s: %w", actions.Database.Table, err)
+		}
+	}
+
+	if actions.CreateIssue != nil {
+		err := rec.runWithDetails("create_issue", func() ([]string, error) {
+			return executeCreateIssue(messages, actions.CreateIssue, rule)
+		})
+		if err != nil {
+			return rec.results, fmt.Errorf("failed to create issues: %w", err)
+		}
+	}
+
+	// Start tracking replies before the messages are moved away
+	if actions.FollowUp != nil {
+		if err := rec.run("follow_up", func() error { return executeFollowUp(client, messages, actions.FollowUp, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to track follow-ups: %w", err)
+		}
+	}
+
+	// Snoozing moves the messages away, like move_to
+	if actions.Snooze != nil {
+		if err := rec.run("snooze", func() error { return executeSnooze(client, messages, actions.Snooze, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to snooze messages: %w", err)
+		}
+		logger.Debug().
+			Str("duration", time.Since(startTime).String()).
+			Msg("Actions executed successfully")
+		return rec.results, nil
+	}
+
 	// Execute move operation
 	if actions.MoveTo != "" {
-		if err := executeMove(client, messages, actions.MoveTo); err != nil {
-			return fmt.Errorf("failed to move messages to %s: %w", actions.MoveTo, err)
+		if err := rec.run("move_to", func() error { return executeMove(client, messages, actions.MoveTo, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to move messages to %s: %w", actions.MoveTo, err)
 		}
 		// If we've moved the messages, we don't need to delete them separately
-		log.Debug().
+		logger.Debug().
 			Str("duration", time.Since(startTime).String()).
 			Msg("Actions executed successfully")
-		return nil
+		return rec.results, nil
 	}
 
 	// Execute delete operation if specified
 	if actions.Delete != nil {
-		if err := executeDelete(client, messages, actions.Delete); err != nil {
-			return fmt.Errorf("failed to delete messages: %w", err)
+		if err := rec.run("delete", func() error { return executeDelete(client, messages, actions.Delete, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to delete messages: %w", err)
 		}
 	}
 
 	// Execute export operation if specified
 	if actions.Export != nil {
-		if err := executeExport(client, messages, actions.Export); err != nil {
-			return fmt.Errorf("failed to export messages: %w", err)
+		if err := rec.run("export", func() error { return executeExport(client, messages, actions.Export, rule) }); err != nil {
+			return rec.results, fmt.Errorf("failed to export messages: %w", err)
 		}
 	}
 
-	log.Debug().
+	logger.Debug().
 		Str("duration", time.Since(startTime).String()).
 		Msg("Actions executed successfully")
 
-	return nil
+	return rec.results, nil
+}
+
+// executeLabelActions runs the by_label actions on the messages with those
+// labels and the other actions on the rest. Results of by_label actions are
+// named by_label.<label>.<action>.
+func executeLabelActions(client *imapclient.Client, messages []*EmailMessage, actions *ActionConfig, rule *Rule) ([]ActionResult, error) {
+	rest := *actions
+	rest.ByLabel = nil
+
+	var results []ActionResult
+	keys, groups := labelGroups(messages, actions.ByLabel)
+	for _, label := range keys {
+		groupActions, prefix := &rest, ""
+		if label != "" {
+			groupActions, prefix = actions.ByLabel[label], "by_label."+label+"."
+		}
+		groupResults, err := executeActions(client, groups[label], groupActions, rule)
+		for _, result := range groupResults {
+			result.Action = prefix + result.Action
+			results = append(results, result)
+		}
+		if err != nil {
+			if label != "" {
+				err = fmt.Errorf("by_label %s: %w", label, err)
+			}
+			return results, err
+		}
+	}
+	return results, nil
 }
 
 func buildUIDSet(messages []*EmailMessage) imap.UIDSet {
@@ -80,7 +281,8 @@ func buildUIDSet(messages []*EmailMessage) imap.UIDSet {
 }
 
 // executeFlags adds or removes flags from messages
-func executeFlags(client *imapclient.Client, messages []*EmailMessage, flagActions *FlagActions) error {
+func executeFlags(client *imapclient.Client, messages []*EmailMessage, flagActions *FlagActions, rule *Rule) error {
+	logger := rule.Logger()
 	if flagActions == nil || (len(flagActions.Add) == 0 && len(flagActions.Remove) == 0) {
 		return nil
 	}
@@ -91,7 +293,7 @@ func executeFlags(client *imapclient.Client, messages []*EmailMessage, flagActio
 	if len(flagActions.Add) > 0 {
 		flags := convertToIMAPFlags(flagActions.Add)
 
-		log.Debug().
+		logger.Debug().
 			Strs("flags", flagActions.Add).
 			Int("message_count", len(messages)).
 			Msg("Adding flags to messages")
@@ -112,7 +314,7 @@ func executeFlags(client *imapclient.Client, messages []*EmailMessage, flagActio
 	if len(flagActions.Remove) > 0 {
 		flags := convertToIMAPFlags(flagActions.Remove)
 
-		log.Debug().
+		logger.Debug().
 			Strs("flags", flagActions.Remove).
 			Int("message_count", len(messages)).
 			Msg("Removing flags from messages")
@@ -133,51 +335,106 @@ func executeFlags(client *imapclient.Client, messages []*EmailMessage, flagActio
 }
 
 // executeCopy copies messages to another mailbox
-func executeCopy(client *imapclient.Client, messages []*EmailMessage, targetMailbox string) error {
+func executeCopy(client *imapclient.Client, messages []*EmailMessage, targetMailbox string, rule *Rule) error {
+	logger := rule.Logger()
 	if targetMailbox == "" {
 		return nil
 	}
 
-	log.Debug().
-		Str("target_mailbox", targetMailbox).
-		Int("message_count", len(messages)).
-		Msg("Copying messages to target mailbox")
+	groups, err := groupByMailboxTemplate(messages, targetMailbox, rule)
+	if err != nil {
+		return err
+	}
 
-	uidSet := buildUIDSet(messages)
+	for _, group := range groups {
+		logger.Debug().
+			Str("target_mailbox", group.mailbox).
+			Int("message_count", len(group.messages)).
+			Msg("Copying messages to target mailbox")
 
-	_, err := client.Copy(uidSet, targetMailbox).Wait()
-	if err != nil {
-		return fmt.Errorf("failed to copy messages to %s: %w", targetMailbox, err)
+		uidSet := buildUIDSet(group.messages)
+
+		_, err := client.Copy(uidSet, group.mailbox).Wait()
+		if err != nil {
+			return fmt.Errorf("failed to copy messages to %s: %w", group.mailbox, wrapMailboxError(err, group.mailbox))
+		}
 	}
 
 	return nil
 }
 
 // executeMove moves messages to another mailbox
-func executeMove(client *imapclient.Client, messages []*EmailMessage, targetMailbox string) error {
+func executeMove(client *imapclient.Client, messages []*EmailMessage, targetMailbox string, rule *Rule) error {
+	logger := rule.Logger()
 	if targetMailbox == "" {
 		return nil
 	}
 
-	log.Debug().
-		Str("target_mailbox", targetMailbox).
-		Int("message_count", len(messages)).
-		Msg("Moving messages to target mailbox")
+	groups, err := groupByMailboxTemplate(messages, targetMailbox, rule)
+	if err != nil {
+		return err
+	}
 
-	uidSet := buildUIDSet(messages)
+	for _, group := range groups {
+		logger.Debug().
+			Str("target_mailbox", group.mailbox).
+			Int("message_count", len(group.messages)).
+			Msg("Moving messages to target mailbox")
 
-	// The Move method automatically handles the fallback if server
-	// doesn't support MOVE capability
-	_, err := client.Move(uidSet, targetMailbox).Wait()
-	if err != nil {
-		return fmt.Errorf("failed to move messages to %s: %w", targetMailbox, err)
+		uidSet := buildUIDSet(group.messages)
+
+		// The Move method automatically handles the fallback if server
+		// doesn't support MOVE capability
+		_, err := client.Move(uidSet, group.mailbox).Wait()
+		if err != nil {
+			return fmt.Errorf("failed to move messages to %s: %w", group.mailbox, wrapMailboxError(err, group.mailbox))
+		}
 	}
 
 	return nil
 }
 
+// mailboxGroup is a set of messages that share a (rendered) target mailbox.
+type mailboxGroup struct {
+	mailbox  string
+	messages []*EmailMessage
+}
+
+// groupByMailboxTemplate renders a templated mailbox name for each message and
+// groups the messages by result, in order of first appearance. Plain mailbox
+// names yield a single group. The names are translated with the rule's
+// namespace.
+func groupByMailboxTemplate(messages []*EmailMessage, mailbox string, rule *Rule) ([]mailboxGroup, error) {
+	if !isTemplate(mailbox) {
+		return []mailboxGroup{{mailbox: rule.MailboxName(mailbox), messages: messages}}, nil
+	}
+
+	var groups []mailboxGroup
+	index := make(map[string]int)
+	for _, msg := range messages {
+		target, err := RenderTemplate(mailbox, NewTemplateContext(msg, rule))
+		if err != nil {
+			return nil, err
+		}
+		target = strings.TrimSpace(target)
+		if target == "" {
+			return nil, fmt.Errorf("mailbox template %q rendered an empty name for message %d", mailbox, msg.UID)
+		}
+		target = rule.MailboxName(target)
+		i, ok := index[target]
+		if !ok {
+			i = len(groups)
+			index[target] = i
+			groups = append(groups, mailboxGroup{mailbox: target})
+		}
+		groups[i].messages = append(groups[i].messages, msg)
+	}
+	return groups, nil
+}
+
 // executeDelete marks messages as deleted and optionally expunges them or moves them to Trash
-func executeDelete(client *imapclient.Client, messages []*EmailMessage, deleteConfig interface{}) error {
+func executeDelete(client *imapclient.Client, messages []*EmailMessage, deleteConfig interface{}, rule *Rule) error {
+	logger := rule.Logger()
 	if deleteConfig == nil {
 		return nil
 	}
@@ -201,7 +458,7 @@ func executeDelete(client *imapclient.Client, messages []*EmailMessage, deleteCo
 		return fmt.Errorf("invalid delete configuration type: %T", deleteConfig)
 	}
 
-	log.Debug().
+	logger.Debug().
 		Bool("move_to_trash", moveToTrash).
 		Int("message_count", len(messages)).
 		Msg("Deleting messages")
@@ -210,9 +467,10 @@ func executeDelete(client *imapclient.Client, messages []*EmailMessage, deleteCo
 
 	if moveToTrash {
 		// Move to trash folder using the MOVE command
-		_, err := client.Move(uidSet, "Trash").Wait()
+		trash := rule.MailboxName("Trash")
+		_, err := client.Move(uidSet, trash).Wait()
 		if err != nil {
-			return fmt.Errorf("failed to move messages to Trash: %w", err)
+			return fmt.Errorf("failed to move messages to %s: %w", trash, wrapMailboxError(err, trash))
 		}
 	} else {
 		// Mark as deleted and expunge
@@ -238,7 +496,8 @@ func executeDelete(client *imapclient.Client, messages []*EmailMessage, deleteCo
 }
 
 // executeExport exports messages to files
-func executeExport(client *imapclient.Client, messages []*EmailMessage, exportConfig *ExportConfig) error {
+func executeExport(client *imapclient.Client, messages []*EmailMessage, exportConfig *ExportConfig, rule *Rule) error {
+	logger := rule.Logger()
 	if exportConfig == nil {
 		return nil
 	}
@@ -250,21 +509,67 @@ func executeExport(client *imapclient.Client, messages []*EmailMessage, exportCo
 	if exportConfig.Format == "" {
 		exportConfig.Format = "eml"
 	}
-	if exportConfig.Format != "eml" && exportConfig.Format != "mbox" {
+	if !isExportFormat(exportConfig.Format) {
 		return fmt.Errorf("unsupported export format: %s", exportConfig.Format)
 	}
 
-	log.Debug().
+	logger.Debug().
 		Str("directory", exportConfig.Directory).
 		Str("format", exportConfig.Format).
+		Str("archive", exportConfig.Archive).
 		Int("message_count", len(messages)).
 		Msg("Exporting messages")
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(exportConfig.Directory, 0700); err != nil {
-		return fmt.Errorf("failed to create export directory: %w", err)
+	// Registered formats write all messages into a single database file
+	storeFormat, isStore := lookupExportStore(exportConfig.Format)
+	var storeRun *exportStoreRun
+	if isStore {
+		var err error
+		if storeRun, err = newExportStoreRun(storeFormat, exportConfig); err != nil {
+			return err
+		}
+	}
+
+	// Write into a single archive, or into the export directory
+	var sink exportSink
+	var archive *archiveSink
+	if exportConfig.Archive != "" {
+		var err error
+		archive, err = newArchiveSink(exportConfig.Archive)
+		if err != nil {
+			if storeRun != nil {
+				storeRun.abort()
+			}
+			return err
+		}
+		sink = archive
+	} else {
+		if err := os.MkdirAll(exportConfig.Directory, 0700); err != nil {
+			if storeRun != nil {
+				storeRun.abort()
+			}
+			return fmt.Errorf("failed to create export directory: %w", err)
+		}
+		sink = &dirSink{dir: exportConfig.Directory}
+	}
+
+	partial := &ActionPartialFailureError{Action: "export"}
+
+	// One anonymizer per run keeps pseudonyms consistent across messages
+	var anonymizer *Anonymizer
+	if exportConfig.Anonymize != nil {
+		anonymizer = exportConfig.Anonymize.Anonymizer()
 	}
 
+	// Attachments shared by many messages are stored once
+	var blobs *blobStore
+	if exportConfig.DedupAttachments {
+		blobs = &blobStore{sink: sink}
+	}
+
+	// A jsonl export collects one line per message into a single file
+	var corpus bytes.Buffer
+
 	// For each message, fetch full content and save to file
 	for i, msg := range messages {
 		// Create a sequence set for this message
@@ -273,7 +578,8 @@ func executeExport(client *imapclient.Client, messages []*EmailMessage, exportCo
 
 		// Fetch the full message content
 		fetchOptions := &imap.FetchOptions{
-			UID: true,
+			UID:          true,
+			InternalDate: true,
 			BodySection: []*imap.FetchItemBodySection{
 				{Peek: true}, // Fetch the entire message without marking as seen
 			},
@@ -281,11 +587,15 @@ func executeExport(client *imapclient.Client, messages []*EmailMessage, exportCo
 
 		fetchedMsgs, err := client.Fetch(uidSet, fetchOptions).Collect()
 		if err != nil {
-			return fmt.Errorf("failed to fetch message %d for export: %w", i, err)
+			partial.Failed = append(partial.Failed, UIDError{
+				UID: msg.UID,
+				Err: fmt.Errorf("failed to fetch message %d for export: %w", i, err),
+			})
+			continue
 		}
 
 		if len(fetchedMsgs) == 0 {
-			log.Warn().
+			logger.Warn().
 				Uint32("uid", msg.UID).
 				Msg("Could not fetch message for export, skipping")
 			continue
@@ -295,7 +605,7 @@ func executeExport(client *imapclient.Client, messages []*EmailMessage, exportCo
 
 		// Get the message body
 		if len(fetchedMsg.BodySection) == 0 {
-			log.Warn().
+			logger.Warn().
 				Uint32("uid", msg.UID).
 				Msg("Message body section is empty, skipping export")
 			continue
@@ -303,36 +613,208 @@ func executeExport(client *imapclient.Client, messages []*EmailMessage, exportCo
 
 		messageContent := fetchedMsg.BodySection[0].Bytes
 		if len(messageContent) == 0 {
-			log.Warn().
+			logger.Warn().
 				Uint32("uid", msg.UID).
 				Msg("Message body is empty, skipping export")
 			continue
 		}
 
+		// Export the decrypted message when the rule decrypts
+		if rule != nil && rule.Decrypt != nil {
+			decrypted, err := rule.Decrypt.Decrypt(messageContent)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to decrypt message %d for export: %w", msg.UID, err),
+				})
+				continue
+			}
+			messageContent = decrypted
+		}
+
+		if exportConfig.Redact != nil {
+			redacted, err := exportConfig.Redact.Redact(messageContent)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to redact message %d for export: %w", msg.UID, err),
+				})
+				continue
+			}
+			messageContent = redacted
+		}
+
+		if anonymizer != nil {
+			anonymized, err := anonymizer.Anonymize(messageContent)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to anonymize message %d for export: %w", msg.UID, err),
+				})
+				continue
+			}
+			messageContent = anonymized
+		}
+
 		// Determine the filename
-		var filename string
-		if exportConfig.FilenameTemplate != "" {
-			// TODO: Implement template parsing for filenames
-			filename = fmt.Sprintf("%s-%d.%s",
-				strings.ReplaceAll(msg.Envelope.Subject, "/", "_"),
-				msg.UID,
-				exportConfig.Format)
-		} else {
-			filename = fmt.Sprintf("message-%d.%s", msg.UID, exportConfig.Format)
+		filename := fmt.Sprintf("message-%d.%s", msg.UID, exportExtension(exportConfig.Format))
+		if exportConfig.Format == ExportFormatJSONL {
+			filename = CorpusFile
+		} else if isStore {
+			filename = storeFormat.file
+		} else if exportConfig.FilenameTemplate != "" {
+			filename, err = renderExportFilename(exportConfig, msg, rule)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: err})
+				continue
+			}
 		}
 
-		// Create the output file
-		filePath := filepath.Join(exportConfig.Directory, filename)
-		if err := os.WriteFile(filePath, messageContent, 0600); err != nil {
-			return fmt.Errorf("failed to write message to file %s: %w", filePath, err)
+		manifest := &ExportManifest{UID: msg.UID, MessageFile: filepath.ToSlash(filename)}
+		if blobs != nil {
+			stripped, attachments, err := dedupAttachments(messageContent, blobs)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to store the attachments of message %d: %w", msg.UID, err),
+				})
+				continue
+			}
+			messageContent = stripped
+			manifest.Attachments = attachments
 		}
 
-		log.Debug().
+		if exportConfig.Format == ExportFormatMarkdown {
+			note, err := messageToMarkdown(messageContent, msg, filepath.ToSlash(filename), manifest.Attachments)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to convert message %d to Markdown: %w", msg.UID, err),
+				})
+				continue
+			}
+			messageContent = note
+		}
+
+		if exportConfig.Format == ExportFormatJSONL || isStore {
+			if isStore {
+				var record *CorpusRecord
+				if record, err = newCorpusRecord(messageContent, msg, fetchedMsg.InternalDate); err == nil {
+					err = storeRun.store.Add(record)
+				}
+			} else {
+				var line []byte
+				if line, err = corpusLine(messageContent, msg, fetchedMsg.InternalDate); err == nil {
+					corpus.Write(line)
+				}
+			}
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to add message %d to %s: %w", msg.UID, filename, err),
+				})
+				continue
+			}
+			if archive != nil {
+				archive.index = append(archive.index, manifest)
+			}
+			partial.Succeeded = append(partial.Succeeded, msg.UID)
+			continue
+		}
+
+		// Write the message file
+		if err := sink.write(filename, messageContent); err != nil {
+			partial.Failed = append(partial.Failed, UIDError{
+				UID: msg.UID,
+				Err: fmt.Errorf("failed to write message to file %s: %w", filename, err),
+			})
+			continue
+		}
+		if archive != nil {
+			archive.index = append(archive.index, manifest)
+		} else if len(manifest.Attachments) > 0 {
+			if err := writeExportManifest(sink, filename, manifest); err != nil {
+				partial.Failed = append(partial.Failed, UIDError{
+					UID: msg.UID,
+					Err: fmt.Errorf("failed to write the manifest of %s: %w", filename, err),
+				})
+				continue
+			}
+		}
+		partial.Succeeded = append(partial.Succeeded, msg.UID)
+
+		logger.Debug().
 			Str("filename", filename).
 			Uint32("uid", msg.UID).
 			Msg("Exported message to file")
 	}
 
+	if blobs != nil {
+		logger.Debug().
+			Int("blobs_stored", blobs.stored).
+			Int("blobs_reused", blobs.reused).
+			Msg("Deduplicated exported attachments")
+	}
+	if exportConfig.Format == ExportFormatJSONL {
+		if err := sink.write(CorpusFile, corpus.Bytes()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", CorpusFile, err)
+		}
+	}
+	if storeRun != nil {
+		if err := storeRun.finish(sink); err != nil {
+			return err
+		}
+	}
+	if err := sink.close(); err != nil {
+		return err
+	}
+
+	if len(partial.Failed) > 0 {
+		if len(partial.Succeeded) == 0 {
+			return partial.Failed[0].Err
+		}
+		return partial
+	}
+
+	return nil
+}
+
+// renderExportFilename renders the export filename template for msg. The
+// result must stay inside the export directory; the export format is appended
+// as extension when the rendered name has none.
+func renderExportFilename(exportConfig *ExportConfig, msg *EmailMessage, rule *Rule) (string, error) {
+	rendered, err := RenderTemplate(exportConfig.FilenameTemplate, NewTemplateContext(msg, rule))
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Clean(strings.TrimSpace(rendered))
+	if filename == "." || filepath.IsAbs(filename) || filename == ".." || strings.HasPrefix(filename, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("export filename template rendered invalid path %q for message %d", rendered, msg.UID)
+	}
+	if filepath.Ext(filename) == "" {
+		filename += "." + exportExtension(exportConfig.Format)
+	}
+	return filename, nil
+}
+
+// executeCustomActions runs the registered handlers for custom actions
+func executeCustomActions(client *imapclient.Client, messages []*EmailMessage, custom map[string]interface{}, rec *actionRecorder, rule *Rule) error {
+	logger := rule.Logger()
+	for _, name := range sortedCustomActionNames(custom) {
+		handler, ok := DefaultActionRegistry.Lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown action: %s", name)
+		}
+
+		logger.Debug().
+			Str("action", name).
+			Int("message_count", len(messages)).
+			Msg("Executing custom action")
+
+		if err := rec.run(name, func() error { return handler.Execute(client, messages, custom[name]) }); err != nil {
+			return fmt.Errorf("failed to execute action %s: %w", name, err)
+		}
+	}
 	return nil
 }
 
@@ -1,6 +1,8 @@
 package dsl
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/emersion/go-imap/v2"
@@ -29,3 +31,29 @@ func TestBuildUIDSetUsesUIDs(t *testing.T) {
 		t.Fatalf("expected 2 UIDs, got %d", len(nums))
 	}
 }
+
+func TestActionRecorderCountsFailures(t *testing.T) {
+	rec := &actionRecorder{messages: 3}
+
+	if err := rec.run("flags", func() error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	partial := &ActionPartialFailureError{
+		Action:    "move_to",
+		Succeeded: []uint32{1, 2},
+		Failed:    []UIDError{{UID: 3, Err: fmt.Errorf("boom")}},
+	}
+	if err := rec.run("move_to", func() error { return fmt.Errorf("wrapped: %w", partial) }); err == nil {
+		t.Fatalf("expected the action error to be returned")
+	}
+	_ = rec.run("export", func() error { return fmt.Errorf("disk full") })
+
+	want := []ActionResult{
+		{Action: "flags", Messages: 3},
+		{Action: "move_to", Messages: 3, Failed: 1, Error: "wrapped: " + partial.Error()},
+		{Action: "export", Messages: 3, Failed: 3, Error: "disk full"},
+	}
+	if !reflect.DeepEqual(rec.results, want) {
+		t.Fatalf("unexpected results:\n got %+v\nwant %+v", rec.results, want)
+	}
+}
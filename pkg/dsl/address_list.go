@@ -0,0 +1,213 @@
+package dsl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// maxInlineAddressList is the largest from_in_file / from_not_in_file list
+// that is compiled into the server-side SEARCH. Larger lists are matched
+// client-side against the fetched envelopes, which keeps the SEARCH command
+// small at the cost of fetching every message matching the other criteria.
+const maxInlineAddressList = 20
+
+// AddressList is a set of addresses and domains loaded from a file.
+//
+// The file holds one entry per line. Blank lines and comments (from a # at
+// the start of a line or after whitespace) are ignored. An entry containing
+// a local part (alice@example.com) matches that address; anything else
+// (example.com or @example.com) matches every address of that domain.
+// Matching is case-insensitive and domains do not match their subdomains.
+type AddressList struct {
+	Path      string
+	Addresses []string
+	Domains   []string
+
+	addresses map[string]bool
+	domains   map[string]bool
+}
+
+// LoadAddressList reads an address list file.
+func LoadAddressList(path string) (*AddressList, error) {
+	// #nosec G304 -- address lists are user-specified files referenced by rules.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open address list: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	list := &AddressList{
+		Path:      path,
+		addresses: make(map[string]bool),
+		domains:   make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		entry := strings.ToLower(strings.TrimSpace(stripListComment(scanner.Text())))
+		if entry == "" {
+			continue
+		}
+		if strings.ContainsAny(entry, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid entry %q", path, lineNo, entry)
+		}
+		local, domain, ok := strings.Cut(entry, "@")
+		if !ok || local == "" {
+			if domain == "" {
+				domain = local
+			}
+			if domain == "" || strings.Contains(domain, "@") {
+				return nil, fmt.Errorf("%s:%d: invalid entry %q", path, lineNo, entry)
+			}
+			if !list.domains[domain] {
+				list.domains[domain] = true
+				list.Domains = append(list.Domains, domain)
+			}
+			continue
+		}
+		if domain == "" || strings.Contains(domain, "@") {
+			return nil, fmt.Errorf("%s:%d: invalid entry %q", path, lineNo, entry)
+		}
+		if !list.addresses[entry] {
+			list.addresses[entry] = true
+			list.Addresses = append(list.Addresses, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read address list: %w", err)
+	}
+	if list.Len() == 0 {
+		return nil, fmt.Errorf("address list %s is empty", path)
+	}
+	return list, nil
+}
+
+func stripListComment(line string) string {
+	for i, r := range line {
+		if r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Len returns the number of entries in the list.
+func (l *AddressList) Len() int {
+	return len(l.Addresses) + len(l.Domains)
+}
+
+// Contains reports whether addr is one of the listed addresses or belongs to
+// one of the listed domains.
+func (l *AddressList) Contains(addr string) bool {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	if l.addresses[addr] {
+		return true
+	}
+	_, domain, ok := strings.Cut(addr, "@")
+	return ok && l.domains[domain]
+}
+
+// headerCriteria returns one From header criterion per entry. Domains are
+// searched as "@domain", so like every IMAP header search they are substring
+// matches.
+func (l *AddressList) headerCriteria() []imap.SearchCriteria {
+	ret := make([]imap.SearchCriteria, 0, l.Len())
+	for _, addr := range l.Addresses {
+		ret = append(ret, imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "From", Value: addr}}})
+	}
+	for _, domain := range l.Domains {
+		ret = append(ret, imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "From", Value: "@" + domain}}})
+	}
+	return ret
+}
+
+// anyOf nests binary ORs so that any of criteria matches.
+func anyOf(criteria []imap.SearchCriteria) imap.SearchCriteria {
+	if len(criteria) == 1 {
+		return criteria[0]
+	}
+	return imap.SearchCriteria{Or: [][2]imap.SearchCriteria{{criteria[0], anyOf(criteria[1:])}}}
+}
+
+// addAddressListCriteria compiles small from_in_file / from_not_in_file lists
+// into criteria. Larger lists are left to addressListFilter.
+func addAddressListCriteria(criteria *imap.SearchCriteria, config SearchConfig) error {
+	if config.FromInFile != "" {
+		list, err := LoadAddressList(config.FromInFile)
+		if err != nil {
+			return fmt.Errorf("invalid 'from_in_file': %w", err)
+		}
+		if list.Len() <= maxInlineAddressList {
+			match := anyOf(list.headerCriteria())
+			criteria.And(&match)
+		}
+	}
+	if config.FromNotInFile != "" {
+		list, err := LoadAddressList(config.FromNotInFile)
+		if err != nil {
+			return fmt.Errorf("invalid 'from_not_in_file': %w", err)
+		}
+		if list.Len() <= maxInlineAddressList {
+			criteria.Not = append(criteria.Not, list.headerCriteria()...)
+		}
+	}
+	return nil
+}
+
+// addressListFilter returns a predicate for the from_in_file /
+// from_not_in_file lists that are too large for the server-side search, or
+// nil if there are none.
+func addressListFilter(config SearchConfig) (func(*EmailMessage) bool, error) {
+	var in, notIn *AddressList
+	if config.FromInFile != "" {
+		list, err := LoadAddressList(config.FromInFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'from_in_file': %w", err)
+		}
+		if list.Len() > maxInlineAddressList {
+			in = list
+		}
+	}
+	if config.FromNotInFile != "" {
+		list, err := LoadAddressList(config.FromNotInFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'from_not_in_file': %w", err)
+		}
+		if list.Len() > maxInlineAddressList {
+			notIn = list
+		}
+	}
+	if in == nil && notIn == nil {
+		return nil, nil
+	}
+
+	return func(msg *EmailMessage) bool {
+		var from []EmailAddress
+		if msg.Envelope != nil {
+			from = msg.Envelope.From
+		}
+		if in != nil && !anyAddressIn(in, from) {
+			return false
+		}
+		if notIn != nil && anyAddressIn(notIn, from) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+func anyAddressIn(list *AddressList, addrs []EmailAddress) bool {
+	for _, addr := range addrs {
+		if list.Contains(addr.Address) {
+			return true
+		}
+	}
+	return false
+}
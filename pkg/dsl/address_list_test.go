@@ -0,0 +1,129 @@
+package dsl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAddressList(t *testing.T, dir, name string, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644))
+	return path
+}
+
+func TestLoadAddressList(t *testing.T) {
+	dir := t.TempDir()
+	path := writeAddressList(t, dir, "vip.txt",
+		"# VIPs",
+		"Alice@Example.com   # the boss",
+		"",
+		"@news.example.net",
+		"partner.org",
+		"alice@example.com",
+		"odd#local@example.com",
+	)
+
+	list, err := LoadAddressList(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"alice@example.com", "odd#local@example.com"}, list.Addresses)
+	assert.Equal(t, []string{"news.example.net", "partner.org"}, list.Domains)
+
+	assert.True(t, list.Contains("ALICE@example.com"))
+	assert.True(t, list.Contains("anyone@partner.org"))
+	assert.False(t, list.Contains("bob@example.com"))
+	assert.False(t, list.Contains("anyone@mail.partner.org"), "domains do not match subdomains")
+
+	_, err = LoadAddressList(writeAddressList(t, dir, "empty.txt", "# nothing here"))
+	assert.ErrorContains(t, err, "is empty")
+	_, err = LoadAddressList(writeAddressList(t, dir, "bad.txt", "alice@"))
+	assert.ErrorContains(t, err, "bad.txt:1: invalid entry")
+}
+
+func TestAddressListSearch(t *testing.T) {
+	messages := readSampleMbox(t)
+	dir := t.TempDir()
+	small := writeAddressList(t, dir, "small.txt", "alice@example.com", "news.example.net")
+	large := []string{"carol@example.com"}
+	for i := 0; i < maxInlineAddressList; i++ {
+		large = append(large, fmt.Sprintf("user%d@example.org", i))
+	}
+	largePath := writeAddressList(t, dir, "large.txt", large...)
+
+	criteria, _, err := BuildSearchCriteria(SearchConfig{FromInFile: small}, nil)
+	require.NoError(t, err)
+	require.Len(t, criteria.Or, 1)
+	assert.Equal(t, "alice@example.com", criteria.Or[0][0].Header[0].Value)
+	assert.Equal(t, "@news.example.net", criteria.Or[0][1].Header[0].Value)
+
+	criteria, _, err = BuildSearchCriteria(SearchConfig{FromNotInFile: small}, nil)
+	require.NoError(t, err)
+	assert.Len(t, criteria.Not, 2)
+
+	criteria, _, err = BuildSearchCriteria(SearchConfig{FromInFile: largePath}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, criteria.Or, "large lists are filtered client-side")
+
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   []uint32
+	}{
+		{"small in", SearchConfig{FromInFile: small}, []uint32{1, 2}},
+		{"small not in", SearchConfig{FromNotInFile: small}, []uint32{3}},
+		{"large in", SearchConfig{FromInFile: largePath}, []uint32{3}},
+		{"large not in", SearchConfig{FromNotInFile: largePath}, []uint32{1, 2}},
+		{"with operator", SearchConfig{
+			Operator:   OperatorOr,
+			Conditions: []ComplexSearchConfig{{SearchConfig: SearchConfig{From: "alice"}}, {SearchConfig: SearchConfig{From: "carol"}}},
+			FromInFile: small,
+		}, []uint32{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			var got []uint32
+			for _, msg := range messages {
+				ok, err := rule.MatchLocal(msg)
+				require.NoError(t, err)
+				if ok {
+					got = append(got, msg.Message.UID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseRuleFileResolvesAddressLists(t *testing.T) {
+	dir := t.TempDir()
+	rulePath := filepath.Join(dir, "vip.yaml")
+	require.NoError(t, os.WriteFile(rulePath, []byte(`name: vip
+search:
+  from_in_file: lists/vip.txt
+  from_not_in_file: /etc/blocked.txt
+output:
+  fields: ["uid"]
+`), 0o644))
+
+	rule, err := ParseRuleFile(rulePath)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "lists", "vip.txt"), rule.Search.FromInFile)
+	assert.Equal(t, "/etc/blocked.txt", rule.Search.FromNotInFile)
+
+	_, err = ParseRuleString(`name: nested
+search:
+  operator: and
+  conditions:
+    - from_in_file: vip.txt
+output:
+  fields: ["uid"]
+`)
+	assert.ErrorContains(t, err, "only supported at the top level")
+}
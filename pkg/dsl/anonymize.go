@@ -0,0 +1,228 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// AnonymizeConfig pseudonymizes addresses, display names and domains in
+// exported messages, e.g. to turn a real mailbox into a mailgen test corpus.
+//
+//	export:
+//	  directory: corpus
+//	  anonymize:
+//	    salt: "project-x"
+//	    keep_domains: [gmail.com]
+//
+// Pseudonyms are derived from a keyed hash of the original value, so the same
+// address always maps to the same fake address (with the same salt), across
+// messages and across runs. Without a salt anyone can recompute the mapping
+// for a guessed address.
+type AnonymizeConfig struct {
+	Salt        string   `yaml:"salt,omitempty"`
+	KeepDomains []string `yaml:"keep_domains,omitempty"`
+}
+
+// Validate checks if the anonymization config is valid
+func (a *AnonymizeConfig) Validate() error {
+	for _, domain := range a.KeepDomains {
+		if strings.TrimSpace(domain) == "" || strings.Contains(domain, "@") {
+			return fmt.Errorf("invalid keep_domains entry %q", domain)
+		}
+	}
+	return nil
+}
+
+// addressHeaders are rewritten address by address, all other headers go
+// through the same text replacement as bodies.
+var addressHeaders = map[string]bool{
+	"from":                        true,
+	"to":                          true,
+	"cc":                          true,
+	"bcc":                         true,
+	"reply-to":                    true,
+	"sender":                      true,
+	"return-path":                 true,
+	"delivered-to":                true,
+	"x-original-to":               true,
+	"errors-to":                   true,
+	"mail-followup-to":            true,
+	"mail-reply-to":               true,
+	"disposition-notification-to": true,
+	"resent-from":                 true,
+	"resent-to":                   true,
+	"resent-cc":                   true,
+	"resent-bcc":                  true,
+	"resent-sender":               true,
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+
+// Anonymizer pseudonymizes messages for one export run. Display names and
+// domains seen in address headers are remembered, so later mentions of them
+// in subjects and bodies are replaced too.
+type Anonymizer struct {
+	key         []byte
+	keepDomains []string
+
+	names   map[string]string
+	domains map[string]string
+
+	namePattern   *regexp.Regexp
+	domainPattern *regexp.Regexp
+}
+
+// Anonymizer returns a new anonymizer for one export run.
+func (a *AnonymizeConfig) Anonymizer() *Anonymizer {
+	ret := &Anonymizer{
+		key:     []byte(a.Salt),
+		names:   map[string]string{},
+		domains: map[string]string{},
+	}
+	for _, domain := range a.KeepDomains {
+		ret.keepDomains = append(ret.keepDomains, strings.ToLower(strings.TrimSpace(domain)))
+	}
+	return ret
+}
+
+// Anonymize returns a pseudonymized copy of the raw message.
+func (a *Anonymizer) Anonymize(raw []byte) ([]byte, error) {
+	// Learn the names and domains of this message first, so a Subject before
+	// the From header is rewritten too
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message header: %w", err)
+	}
+	fields := header.Fields()
+	for fields.Next() {
+		if !addressHeaders[strings.ToLower(fields.Key())] {
+			continue
+		}
+		addresses, err := mail.ParseAddressList(fields.Value())
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			a.name(address.Name)
+			a.address(address.Address)
+		}
+	}
+	return rewriteMessage(raw, a)
+}
+
+func (a *Anonymizer) rewriteHeader(lower, value string) string {
+	if addressHeaders[lower] {
+		if addresses, err := mail.ParseAddressList(value); err == nil {
+			parts := make([]string, 0, len(addresses))
+			for _, address := range addresses {
+				fake := &mail.Address{Name: a.name(address.Name), Address: a.address(address.Address)}
+				parts = append(parts, fake.String())
+			}
+			return strings.Join(parts, ", ")
+		}
+	}
+	return string(a.rewriteText([]byte(value)))
+}
+
+func (a *Anonymizer) rewriteText(content []byte) []byte {
+	content = emailPattern.ReplaceAllFunc(content, func(match []byte) []byte {
+		return []byte(a.address(string(match)))
+	})
+	if pattern := a.knownNames(); pattern != nil {
+		content = pattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			return []byte(a.names[string(match)])
+		})
+	}
+	if pattern := a.knownDomains(); pattern != nil {
+		content = pattern.ReplaceAllFunc(content, func(match []byte) []byte {
+			return []byte(a.domains[strings.ToLower(string(match))])
+		})
+	}
+	return content
+}
+
+func (a *Anonymizer) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(kind + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
+// address maps local@domain to user-<hash>@<fake domain>. The local part is
+// derived from the whole address, so alice@a.com and alice@b.com differ.
+func (a *Anonymizer) address(address string) string {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" {
+		return address
+	}
+	return "user-" + a.hash("address", strings.ToLower(address)) + "@" + a.domain(domain)
+}
+
+func (a *Anonymizer) domain(domain string) string {
+	lower := strings.ToLower(domain)
+	for _, keep := range a.keepDomains {
+		if lower == keep || strings.HasSuffix(lower, "."+keep) {
+			return domain
+		}
+	}
+	if fake, ok := a.domains[lower]; ok {
+		return fake
+	}
+	fake := "d-" + a.hash("domain", lower) + ".example"
+	a.domains[lower] = fake
+	a.domainPattern = nil
+	return fake
+}
+
+func (a *Anonymizer) name(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ""
+	}
+	if fake, ok := a.names[name]; ok {
+		return fake
+	}
+	fake := "Person " + a.hash("name", strings.ToLower(name))
+	a.names[name] = fake
+	a.namePattern = nil
+	return fake
+}
+
+func (a *Anonymizer) knownNames() *regexp.Regexp {
+	if a.namePattern == nil && len(a.names) > 0 {
+		a.namePattern = alternationPattern("", a.names)
+	}
+	return a.namePattern
+}
+
+func (a *Anonymizer) knownDomains() *regexp.Regexp {
+	if a.domainPattern == nil && len(a.domains) > 0 {
+		a.domainPattern = alternationPattern("(?i)", a.domains)
+	}
+	return a.domainPattern
+}
+
+// alternationPattern matches any key of values as a whole word, longest
+// first so "Ann Lee" wins over "Ann".
+func alternationPattern(flags string, values map[string]string) *regexp.Regexp {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, regexp.QuoteMeta(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return regexp.MustCompile(flags + `\b(?:` + strings.Join(keys, "|") + `)\b`)
+}
@@ -0,0 +1,76 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymize(t *testing.T) {
+	first := "Subject: Lunch with Alice Smith\r\n" +
+		"From: Alice Smith <alice@acme.com>\r\n" +
+		"To: bob@gmail.com, \"Carol\" <carol@mail.acme.com>\r\n" +
+		"Message-ID: <123@mx.acme.com>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hi Bob, Alice Smith here. Mail alice@acme.com or visit www.acme.com.\r\n"
+	reply := "From: bob@gmail.com\r\n" +
+		"To: Alice Smith <ALICE@acme.com>\r\n" +
+		"Subject: Re: Lunch with Alice Smith\r\n" +
+		"In-Reply-To: <123@mx.acme.com>\r\n" +
+		"\r\n" +
+		"> Alice Smith here.\r\n"
+
+	config := &AnonymizeConfig{Salt: "test", KeepDomains: []string{"gmail.com"}}
+	require.NoError(t, config.Validate())
+
+	run := config.Anonymizer()
+	got, err := run.Anonymize([]byte(first))
+	require.NoError(t, err)
+	gotReply, err := run.Anonymize([]byte(reply))
+	require.NoError(t, err)
+
+	alice := run.address("alice@acme.com")
+	acme := run.domain("acme.com")
+	name := run.name("Alice Smith")
+	assert.True(t, strings.HasPrefix(alice, "user-"))
+	assert.True(t, strings.HasSuffix(alice, "@"+acme))
+	assert.Regexp(t, `^d-[0-9a-f]{8}\.example$`, acme)
+
+	msg, err := ParseLocalMessage(got, 1, nil, time.Time{})
+	require.NoError(t, err)
+	envelope := msg.Message.Envelope
+	assert.Equal(t, "Lunch with "+name, envelope.Subject)
+	require.Len(t, envelope.From, 1)
+	assert.Equal(t, name, envelope.From[0].Name)
+	assert.Equal(t, alice, envelope.From[0].Address)
+	require.Len(t, envelope.To, 2)
+	assert.Equal(t, run.address("bob@gmail.com"), envelope.To[0].Address)
+	assert.True(t, strings.HasSuffix(envelope.To[0].Address, "@gmail.com"), "kept domain")
+	assert.Equal(t, run.name("Carol"), envelope.To[1].Name)
+
+	text := string(got)
+	assert.Contains(t, text, "Hi Bob, "+name+" here. Mail "+alice+" or visit www."+acme+".\r\n")
+	assert.NotContains(t, strings.ToLower(text), "acme")
+	assert.NotContains(t, text, "Alice")
+	assert.Contains(t, text, "Content-Type: text/plain\r\n")
+
+	// The same input maps to the same pseudonyms, so threads stay intact
+	messageID := run.address("123@mx.acme.com")
+	assert.Contains(t, text, "Message-Id: <"+messageID+">\r\n")
+	assert.Contains(t, string(gotReply), "In-Reply-To: <"+messageID+">\r\n")
+	assert.Contains(t, string(gotReply), "To: \""+name+"\" <"+alice+">\r\n")
+
+	again, err := config.Anonymizer().Anonymize([]byte(first))
+	require.NoError(t, err)
+	assert.Equal(t, string(got), string(again))
+
+	other, err := (&AnonymizeConfig{Salt: "other"}).Anonymizer().Anonymize([]byte(first))
+	require.NoError(t, err)
+	assert.NotEqual(t, string(got), string(other))
+
+	assert.ErrorContains(t, (&AnonymizeConfig{KeepDomains: []string{"a@b.com"}}).Validate(), "invalid keep_domains entry")
+}
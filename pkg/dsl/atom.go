@@ -0,0 +1,171 @@
+package dsl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatAtom writes the matched messages as an Atom feed (RFC 4287).
+const FormatAtom = "atom"
+
+// AtomFeed describes the feed written by WriteAtom.
+type AtomFeed struct {
+	Title string
+	// ID is the permanent identifier of the feed, an URI
+	ID string
+	// Link is the URL the feed is served at, optional
+	Link string
+}
+
+// AtomFeedForRule returns the feed of rule, identified by the rule name.
+func AtomFeedForRule(rule *Rule) AtomFeed {
+	if rule == nil || rule.Name == "" {
+		return AtomFeed{Title: "smailnail", ID: "urn:smailnail:feed"}
+	}
+	title := rule.Name
+	if rule.Description != "" {
+		title = rule.Description
+	}
+	return AtomFeed{Title: title, ID: "urn:smailnail:rule:" + url.PathEscape(rule.Name)}
+}
+
+type atomXML struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Updated string       `xml:"updated"`
+	Links   []atomLink   `xml:"link"`
+	Author  *atomPerson  `xml:"author"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  *atomPerson `xml:"author"`
+	Content *atomText   `xml:"content"`
+}
+
+// WriteAtom writes messages as an Atom feed, one entry per message, newest
+// first. The entry content is the text/html MIME part of the message, or the
+// text/plain part when it has none, so the messages need a mime_parts field
+// fetching them. Entries are identified by their Message-ID, falling back to
+// the UID.
+func WriteAtom(w io.Writer, messages []*EmailMessage, feed AtomFeed) error {
+	doc := atomXML{
+		Title:  feed.Title,
+		ID:     feed.ID,
+		Author: &atomPerson{Name: "smailnail"},
+	}
+	if feed.Link != "" {
+		doc.Links = append(doc.Links, atomLink{Href: feed.Link, Rel: "self"})
+	}
+
+	// Feed readers sort the entries themselves, but some only read the
+	// first ones
+	sorted := slices.Clone(messages)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return messageDate(sorted[i]).After(messageDate(sorted[j]))
+	})
+	for _, msg := range sorted {
+		doc.Entries = append(doc.Entries, atomEntryFor(msg, feed))
+	}
+	updated := time.Now()
+	if len(sorted) > 0 && !messageDate(sorted[0]).IsZero() {
+		updated = messageDate(sorted[0])
+	}
+	doc.Updated = updated.UTC().Format(time.RFC3339)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("failed to write feed: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode feed: %w", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func atomEntryFor(msg *EmailMessage, feed AtomFeed) *atomEntry {
+	entry := &atomEntry{
+		ID:      fmt.Sprintf("%s:uid:%d", feed.ID, msg.UID),
+		Title:   "(no subject)",
+		Updated: messageDate(msg).UTC().Format(time.RFC3339),
+	}
+	if env := msg.Envelope; env != nil {
+		if env.Subject != "" {
+			entry.Title = env.Subject
+		}
+		if id := strings.Trim(env.MessageID, "<> "); id != "" {
+			// RFC 2392 mid: URL
+			entry.ID = "mid:" + url.PathEscape(id)
+		}
+		if len(env.From) > 0 {
+			from := env.From[0]
+			entry.Author = &atomPerson{Name: from.Name, Email: from.Address}
+			if entry.Author.Name == "" {
+				entry.Author.Name = from.Address
+			}
+		}
+	}
+	if entry.Author == nil {
+		entry.Author = &atomPerson{Name: "unknown"}
+	}
+
+	if content, ok := messagePartText(msg, "text/html"); ok {
+		entry.Content = &atomText{Type: "html", Body: content}
+	} else if content, ok := messagePartText(msg, "text/plain"); ok {
+		entry.Content = &atomText{Type: "text", Body: content}
+	}
+	return entry
+}
+
+// messagePartText returns the decoded content of the first fetched MIME part
+// of msg with mediaType that is not an attachment.
+func messagePartText(msg *EmailMessage, mediaType string) (string, bool) {
+	for _, part := range msg.MimeParts {
+		if part.Filename != "" || !strings.EqualFold(partMediaType(part), mediaType) {
+			continue
+		}
+		content, err := decodeTransferEncoding([]byte(part.Content), part.Encoding)
+		if err != nil {
+			// Truncated parts may not decode, show them as fetched
+			return part.Content, true
+		}
+		return string(content), true
+	}
+	return "", false
+}
+
+// partMediaType is the type/subtype of part. Fetched parts carry the full
+// media type in Type.
+func partMediaType(part MimePart) string {
+	if part.Subtype == "" {
+		return part.Type
+	}
+	return part.Type + "/" + part.Subtype
+}
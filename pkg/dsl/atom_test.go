@@ -0,0 +1,51 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAtom(t *testing.T) {
+	messages := []*EmailMessage{
+		{
+			UID: 1,
+			Envelope: &EmailEnvelope{
+				Subject: "Weekly digest",
+				From:    []EmailAddress{{Name: "News", Address: "news@example.com"}},
+				Date:    time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+			},
+			MimeParts: []MimePart{{Type: "text/plain", Content: "plain"}},
+		},
+		{
+			UID: 2,
+			Envelope: &EmailEnvelope{
+				Subject:   "Release <notes>",
+				MessageID: "<abc@example.com>",
+				Date:      time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC),
+			},
+			MimeParts: []MimePart{
+				{Type: "text/plain", Content: "plain"},
+				{Type: "text/html", Encoding: "quoted-printable", Content: "<p class=3D\"x\">Hi</p>"},
+			},
+		},
+	}
+
+	var b strings.Builder
+	require.NoError(t, WriteAtom(&b, messages, AtomFeedForRule(&Rule{Name: "newsletters"})))
+	feed := b.String()
+	assert.Contains(t, feed, `<feed xmlns="http://www.w3.org/2005/Atom">`)
+	assert.Contains(t, feed, "<id>urn:smailnail:rule:newsletters</id>")
+	assert.Contains(t, feed, "<updated>2025-03-04T09:00:00Z</updated>")
+	assert.Contains(t, feed, "<title>Release &lt;notes&gt;</title>")
+	assert.Contains(t, feed, "<id>mid:abc@example.com</id>")
+	assert.Contains(t, feed, `<content type="html">&lt;p class=&#34;x&#34;&gt;Hi&lt;/p&gt;</content>`)
+	assert.Contains(t, feed, "<id>urn:smailnail:rule:newsletters:uid:1</id>")
+	assert.Contains(t, feed, `<content type="text">plain</content>`)
+	assert.Contains(t, feed, "<email>news@example.com</email>")
+	// Newest first
+	assert.Less(t, strings.Index(feed, "Release"), strings.Index(feed, "Weekly digest"))
+}
@@ -0,0 +1,99 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orders of the attachment report.
+const (
+	AttachmentSortSize     = "size"
+	AttachmentSortDate     = "date"
+	AttachmentSortSender   = "sender"
+	AttachmentSortFilename = "filename"
+)
+
+// AttachmentReportEntry is one attachment of a matched message.
+type AttachmentReportEntry struct {
+	Attachment
+	UID     uint32
+	Subject string
+	From    string
+	Date    time.Time
+}
+
+// AttachmentReportOptions selects and orders the attachment report.
+type AttachmentReportOptions struct {
+	// MinSize skips smaller attachments, e.g. 1M
+	MinSize string
+	// SortBy is size (largest first), date (newest first), sender or
+	// filename
+	SortBy string
+}
+
+// Validate checks the minimum size and order.
+func (o AttachmentReportOptions) Validate() error {
+	if o.MinSize != "" {
+		if _, err := parseSize(o.MinSize); err != nil {
+			return fmt.Errorf("invalid minimum size: %w", err)
+		}
+	}
+	switch o.SortBy {
+	case "", AttachmentSortSize, AttachmentSortDate, AttachmentSortSender, AttachmentSortFilename:
+		return nil
+	default:
+		return fmt.Errorf("sort must be size, date, sender or filename, got %q", o.SortBy)
+	}
+}
+
+// CollectAttachmentReport lists the attachments of messages, which must have
+// been fetched with the attachments field.
+func CollectAttachmentReport(messages []*EmailMessage, options AttachmentReportOptions) ([]*AttachmentReportEntry, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	var minSize int64
+	if options.MinSize != "" {
+		minSize, _ = parseSize(options.MinSize)
+	}
+
+	var ret []*AttachmentReportEntry
+	for _, msg := range messages {
+		for _, attachment := range msg.Attachments {
+			if int64(attachment.Size) < minSize {
+				continue
+			}
+			entry := &AttachmentReportEntry{Attachment: attachment, UID: msg.UID}
+			entry.Content = nil
+			if msg.Envelope != nil {
+				entry.Subject = msg.Envelope.Subject
+				entry.Date = msg.Envelope.Date
+				if len(msg.Envelope.From) > 0 {
+					entry.From = strings.ToLower(msg.Envelope.From[0].Address)
+				}
+			}
+			ret = append(ret, entry)
+		}
+	}
+
+	less := func(a, b *AttachmentReportEntry) bool {
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.UID < b.UID
+	}
+	switch options.SortBy {
+	case AttachmentSortDate:
+		less = func(a, b *AttachmentReportEntry) bool { return a.Date.After(b.Date) }
+	case AttachmentSortSender:
+		less = func(a, b *AttachmentReportEntry) bool { return a.From < b.From }
+	case AttachmentSortFilename:
+		less = func(a, b *AttachmentReportEntry) bool {
+			return strings.ToLower(a.Filename) < strings.ToLower(b.Filename)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return less(ret[i], ret[j]) })
+	return ret, nil
+}
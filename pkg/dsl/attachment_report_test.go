@@ -0,0 +1,52 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectAttachmentReport(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 9, 0, 0, 0, time.UTC) }
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Date: day(1), Subject: "Slides", From: []EmailAddress{{Address: "Bob@example.com"}}},
+			Attachments: []Attachment{
+				{Filename: "deck.pdf", Type: "application/pdf", Size: 3 << 20, Content: []byte("pdf")},
+				{Filename: "logo.png", Type: "image/png", Size: 2048},
+			}},
+		{UID: 2, Envelope: &EmailEnvelope{Date: day(5), Subject: "Video", From: []EmailAddress{{Address: "alice@example.com"}}},
+			Attachments: []Attachment{{Filename: "Clip.mp4", Type: "video/mp4", Size: 9 << 20}}},
+		{UID: 3, Envelope: &EmailEnvelope{Date: day(3), Subject: "No attachments"}},
+	}
+
+	entries, err := CollectAttachmentReport(messages, AttachmentReportOptions{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "Clip.mp4", entries[0].Filename)
+	assert.Equal(t, "deck.pdf", entries[1].Filename)
+	assert.Equal(t, uint32(1), entries[1].UID)
+	assert.Equal(t, "bob@example.com", entries[1].From)
+	assert.Equal(t, "Slides", entries[1].Subject)
+	assert.Nil(t, entries[1].Content, "contents are not kept")
+
+	entries, err = CollectAttachmentReport(messages, AttachmentReportOptions{MinSize: "1M", SortBy: AttachmentSortFilename})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "Clip.mp4", entries[0].Filename)
+	assert.Equal(t, "deck.pdf", entries[1].Filename)
+
+	entries, err = CollectAttachmentReport(messages, AttachmentReportOptions{SortBy: AttachmentSortDate})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), entries[0].UID)
+
+	entries, err = CollectAttachmentReport(messages, AttachmentReportOptions{SortBy: AttachmentSortSender})
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", entries[0].From)
+
+	_, err = CollectAttachmentReport(messages, AttachmentReportOptions{MinSize: "big"})
+	assert.Error(t, err)
+	_, err = CollectAttachmentReport(messages, AttachmentReportOptions{SortBy: "type"})
+	assert.Error(t, err)
+}
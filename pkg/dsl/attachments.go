@@ -0,0 +1,385 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Attachment is a decoded attachment of a message. Attachments are only
+// downloaded when the rule searches them or outputs the attachments field.
+type Attachment struct {
+	Filename string `json:"filename,omitempty"`
+	Type     string `json:"type"`
+	Size     uint32 `json:"size"`
+	SHA256   string `json:"sha256"`
+	// Content is the decoded attachment, available to attachment matchers
+	Content []byte `json:"-"`
+}
+
+func newAttachment(filename, mediaType string, content []byte) (Attachment, error) {
+	size, err := checkedUint32FromInt(len(content), "attachment_size")
+	if err != nil {
+		return Attachment{}, err
+	}
+	sum := sha256.Sum256(content)
+	return Attachment{
+		Filename: filename,
+		Type:     strings.ToLower(mediaType),
+		Size:     size,
+		SHA256:   hex.EncodeToString(sum[:]),
+		Content:  content,
+	}, nil
+}
+
+// AttachmentCriteria matches messages with at least one attachment
+// satisfying every set condition.
+type AttachmentCriteria struct {
+	// Type is a media type, or a type/* wildcard
+	Type string `yaml:"type,omitempty"`
+	// Filename is a case-insensitive glob, e.g. "*.exe"
+	Filename    string `yaml:"filename,omitempty"`
+	LargerThan  string `yaml:"larger_than,omitempty"`
+	SmallerThan string `yaml:"smaller_than,omitempty"`
+	// Matcher names a matcher registered with RegisterAttachmentMatcher
+	Matcher string `yaml:"matcher,omitempty"`
+}
+
+// Validate checks if the attachment criteria are valid
+func (a *AttachmentCriteria) Validate() error {
+	if a.Filename != "" {
+		if _, err := path.Match(a.Filename, ""); err != nil {
+			return fmt.Errorf("invalid attachment filename pattern %q: %w", a.Filename, err)
+		}
+	}
+	for _, size := range []string{a.LargerThan, a.SmallerThan} {
+		if size == "" {
+			continue
+		}
+		if _, err := parseSize(size); err != nil {
+			return fmt.Errorf("invalid attachment size: %w", err)
+		}
+	}
+	if a.Matcher != "" {
+		if _, ok := lookupAttachmentMatcher(a.Matcher); !ok {
+			return fmt.Errorf("unknown attachment matcher: %s", a.Matcher)
+		}
+	}
+	return nil
+}
+
+// AttachmentMatcher is a user-supplied check run against each downloaded
+// attachment, e.g. a YARA scan.
+type AttachmentMatcher func(msg *EmailMessage, attachment *Attachment) (bool, error)
+
+var attachmentMatchers = struct {
+	sync.RWMutex
+	matchers map[string]AttachmentMatcher
+}{matchers: make(map[string]AttachmentMatcher)}
+
+// RegisterAttachmentMatcher makes matcher available to rules as
+// search.attachment.matcher. Register matchers before parsing rules.
+func RegisterAttachmentMatcher(name string, matcher AttachmentMatcher) error {
+	if name == "" {
+		return fmt.Errorf("attachment matcher name is required")
+	}
+	if matcher == nil {
+		return fmt.Errorf("attachment matcher %s is nil", name)
+	}
+	attachmentMatchers.Lock()
+	defer attachmentMatchers.Unlock()
+	if _, exists := attachmentMatchers.matchers[name]; exists {
+		return fmt.Errorf("attachment matcher %s is already registered", name)
+	}
+	attachmentMatchers.matchers[name] = matcher
+	return nil
+}
+
+// UnregisterAttachmentMatcher removes a matcher. It is a no-op for unknown
+// names.
+func UnregisterAttachmentMatcher(name string) {
+	attachmentMatchers.Lock()
+	defer attachmentMatchers.Unlock()
+	delete(attachmentMatchers.matchers, name)
+}
+
+func lookupAttachmentMatcher(name string) (AttachmentMatcher, bool) {
+	attachmentMatchers.RLock()
+	defer attachmentMatchers.RUnlock()
+	matcher, ok := attachmentMatchers.matchers[name]
+	return matcher, ok
+}
+
+// LoadSHA256List reads a file of SHA-256 digests, one per line. Blank lines
+// and # comments are ignored, as in address lists.
+func LoadSHA256List(path string) ([]string, error) {
+	// #nosec G304 -- hash lists are user-specified files referenced by rules.
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hash list: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var hashes []string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		entry := strings.TrimSpace(stripListComment(scanner.Text()))
+		if entry == "" {
+			continue
+		}
+		if err := validateSHA256(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		hashes = append(hashes, strings.ToLower(entry))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hash list: %w", err)
+	}
+	return hashes, nil
+}
+
+func validateSHA256(hash string) error {
+	if len(hash) != sha256.Size*2 {
+		return fmt.Errorf("invalid SHA-256 digest %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return fmt.Errorf("invalid SHA-256 digest %q", hash)
+	}
+	return nil
+}
+
+// searchesAttachments reports whether the search has attachment conditions.
+func (s SearchConfig) searchesAttachments() bool {
+	return len(s.AttachmentSHA256In) > 0 || s.AttachmentSHA256InFile != "" || s.Attachment != nil
+}
+
+// needsAttachments reports whether messages must be fetched with their
+// attachments, either to search them or to output them.
+func (rule *Rule) needsAttachments() bool {
+	if rule.Search.searchesAttachments() {
+		return true
+	}
+	for _, fieldInterface := range rule.Output.Fields {
+		field, ok := fieldInterface.(Field)
+		if !ok {
+			continue
+		}
+		if field.Name == "attachments" || strings.HasPrefix(field.Name, "attachments.") || strings.HasPrefix(field.Name, "attachments[") {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentFilter returns a predicate for the attachment conditions of the
+// search, or nil if there are none.
+func attachmentFilter(config SearchConfig) (func(*EmailMessage) (bool, error), error) {
+	if !config.searchesAttachments() {
+		return nil, nil
+	}
+
+	var hashes map[string]bool
+	if len(config.AttachmentSHA256In) > 0 || config.AttachmentSHA256InFile != "" {
+		hashes = make(map[string]bool)
+		for _, hash := range config.AttachmentSHA256In {
+			hashes[strings.ToLower(hash)] = true
+		}
+		if config.AttachmentSHA256InFile != "" {
+			list, err := LoadSHA256List(config.AttachmentSHA256InFile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid 'attachment_sha256_in_file': %w", err)
+			}
+			for _, hash := range list {
+				hashes[hash] = true
+			}
+		}
+	}
+
+	var larger, smaller int64
+	var matcher AttachmentMatcher
+	criteria := config.Attachment
+	if criteria != nil {
+		var err error
+		if criteria.LargerThan != "" {
+			if larger, err = parseSize(criteria.LargerThan); err != nil {
+				return nil, fmt.Errorf("invalid 'larger_than' size: %w", err)
+			}
+		}
+		if criteria.SmallerThan != "" {
+			if smaller, err = parseSize(criteria.SmallerThan); err != nil {
+				return nil, fmt.Errorf("invalid 'smaller_than' size: %w", err)
+			}
+		}
+		if criteria.Matcher != "" {
+			var ok bool
+			if matcher, ok = lookupAttachmentMatcher(criteria.Matcher); !ok {
+				return nil, fmt.Errorf("unknown attachment matcher: %s", criteria.Matcher)
+			}
+		}
+	}
+
+	match := func(msg *EmailMessage, attachment *Attachment) (bool, error) {
+		if hashes != nil && !hashes[attachment.SHA256] {
+			return false, nil
+		}
+		if criteria == nil {
+			return true, nil
+		}
+		if criteria.Type != "" && !matchMediaType(criteria.Type, attachment.Type) {
+			return false, nil
+		}
+		if criteria.Filename != "" {
+			ok, _ := path.Match(strings.ToLower(criteria.Filename), strings.ToLower(attachment.Filename))
+			if !ok {
+				return false, nil
+			}
+		}
+		size := int64(attachment.Size)
+		if larger > 0 && size <= larger {
+			return false, nil
+		}
+		if smaller > 0 && size >= smaller {
+			return false, nil
+		}
+		if matcher != nil {
+			return matcher(msg, attachment)
+		}
+		return true, nil
+	}
+
+	return func(msg *EmailMessage) (bool, error) {
+		for i := range msg.Attachments {
+			ok, err := match(msg, &msg.Attachments[i])
+			if err != nil {
+				return false, fmt.Errorf("failed to match attachment %q of message %d: %w", msg.Attachments[i].Filename, msg.UID, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, nil
+}
+
+func matchMediaType(pattern, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(mediaType, prefix+"/")
+	}
+	return mediaType == pattern
+}
+
+// isAttachmentPart reports whether a body part is an attachment: it has an
+// attachment disposition or a file name.
+func isAttachmentPart(part *imap.BodyStructureSinglePart) bool {
+	if disp := part.Disposition(); disp != nil && strings.EqualFold(disp.Value, "attachment") {
+		return true
+	}
+	return part.Filename() != ""
+}
+
+// fetchAttachments downloads and decodes the attachments of messages, which
+// must have been fetched with their extended body structure. The result is
+// keyed by sequence number.
+func fetchAttachments(client *imapclient.Client, messages []*imapclient.FetchMessageBuffer, rule *Rule) (map[uint32][]Attachment, error) {
+	logger := rule.Logger()
+
+	type attachmentPart struct {
+		section *imap.FetchItemBodySection
+		part    *imap.BodyStructureSinglePart
+	}
+	parts := make(map[uint32][]attachmentPart)
+	var seqSet imap.SeqSet
+	var sections []*imap.FetchItemBodySection
+	seen := make(map[string]bool)
+
+	for _, msg := range messages {
+		if msg.BodyStructure == nil {
+			continue
+		}
+		msg.BodyStructure.Walk(func(partPath []int, bs imap.BodyStructure) bool {
+			single, ok := bs.(*imap.BodyStructureSinglePart)
+			if !ok || !isAttachmentPart(single) {
+				return true
+			}
+			section := &imap.FetchItemBodySection{Peek: true, Part: partPath}
+			parts[msg.SeqNum] = append(parts[msg.SeqNum], attachmentPart{section: section, part: single})
+			if key := fmt.Sprint(partPath); !seen[key] {
+				seen[key] = true
+				sections = append(sections, section)
+			}
+			return true
+		})
+		if len(parts[msg.SeqNum]) > 0 {
+			seqSet.AddNum(msg.SeqNum)
+		}
+	}
+
+	ret := make(map[uint32][]Attachment)
+	if len(sections) == 0 {
+		return ret, nil
+	}
+
+	fetched, err := client.Fetch(seqSet, &imap.FetchOptions{BodySection: sections}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments: %w", err)
+	}
+	for _, msg := range fetched {
+		for _, p := range parts[msg.SeqNum] {
+			raw := msg.FindBodySection(p.section)
+			if raw == nil {
+				logger.Warn().
+					Uint32("seq_num", msg.SeqNum).
+					Str("path", fmt.Sprint(p.section.Part)).
+					Msg("Attachment not found in fetch results")
+				continue
+			}
+			content, err := decodeTransferEncoding(raw, p.part.Encoding)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode attachment of message %d: %w", msg.SeqNum, err)
+			}
+			attachment, err := newAttachment(p.part.Filename(), p.part.MediaType(), content)
+			if err != nil {
+				return nil, err
+			}
+			ret[msg.SeqNum] = append(ret[msg.SeqNum], attachment)
+		}
+	}
+
+	logger.Debug().
+		Int("messages", len(ret)).
+		Int("sections", len(sections)).
+		Msg("Fetched attachments")
+	return ret, nil
+}
+
+// decodeTransferEncoding decodes a body part fetched with BODY[part], which
+// the server returns in its Content-Transfer-Encoding.
+func decodeTransferEncoding(content []byte, encoding string) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(encoding) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, bytes.NewReader(content))
+	case "quoted-printable":
+		r = quotedprintable.NewReader(bytes.NewReader(content))
+	default:
+		return content, nil
+	}
+	return io.ReadAll(r)
+}
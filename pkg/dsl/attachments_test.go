@@ -0,0 +1,105 @@
+package dsl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const attachmentEML = "From: a@example.com\r\n" +
+	"Subject: invoice\r\n" +
+	"Date: Mon, 03 Mar 2025 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=sep\r\n" +
+	"\r\n" +
+	"--sep\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"see attached\r\n" +
+	"--sep\r\n" +
+	"Content-Type: application/x-msdownload\r\n" +
+	"Content-Disposition: attachment; filename=\"Invoice.EXE\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"TVqQAAME\r\n" +
+	"--sep--\r\n"
+
+func readAttachmentMessage(t *testing.T) []*LocalMessage {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.eml"), []byte(attachmentEML), 0o600))
+	messages, err := ReadLocalMessages(dir)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	return messages
+}
+
+func TestAttachmentCriteria(t *testing.T) {
+	messages := readAttachmentMessage(t)
+	sum := sha256.Sum256([]byte("MZ\x90\x00\x03\x04"))
+	hash := hex.EncodeToString(sum[:])
+
+	attachments := messages[0].Message.Attachments
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "Invoice.EXE", attachments[0].Filename)
+	assert.Equal(t, "application/x-msdownload", attachments[0].Type)
+	assert.Equal(t, uint32(6), attachments[0].Size)
+	assert.Equal(t, hash, attachments[0].SHA256)
+
+	var scanned []string
+	require.NoError(t, RegisterAttachmentMatcher("test-mz", func(msg *EmailMessage, attachment *Attachment) (bool, error) {
+		scanned = append(scanned, attachment.Filename)
+		return strings.HasPrefix(string(attachment.Content), "MZ"), nil
+	}))
+	t.Cleanup(func() { UnregisterAttachmentMatcher("test-mz") })
+
+	hashFile := filepath.Join(t.TempDir(), "bad.txt")
+	require.NoError(t, os.WriteFile(hashFile, []byte("# known bad\n"+strings.ToUpper(hash)+"\n"), 0o600))
+
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   bool
+	}{
+		{"hash", SearchConfig{AttachmentSHA256In: []string{hash}}, true},
+		{"other hash", SearchConfig{AttachmentSHA256In: []string{strings.Repeat("0", 64)}}, false},
+		{"hash file", SearchConfig{AttachmentSHA256InFile: hashFile}, true},
+		{"filename glob", SearchConfig{Attachment: &AttachmentCriteria{Filename: "*.exe"}}, true},
+		{"type wildcard", SearchConfig{Attachment: &AttachmentCriteria{Type: "application/*", SmallerThan: "1K"}}, true},
+		{"size", SearchConfig{Attachment: &AttachmentCriteria{LargerThan: "1K"}}, false},
+		{"matcher", SearchConfig{Attachment: &AttachmentCriteria{Matcher: "test-mz"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.search.Validate())
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			ok, err := rule.MatchLocal(messages[0])
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+	assert.Equal(t, []string{"Invoice.EXE"}, scanned)
+
+	assert.ErrorContains(t, (&SearchConfig{AttachmentSHA256In: []string{"abc"}}).Validate(), "invalid SHA-256 digest")
+	assert.ErrorContains(t, (&SearchConfig{Attachment: &AttachmentCriteria{Matcher: "missing"}}).Validate(), "unknown attachment matcher")
+}
+
+func TestDecodeTransferEncoding(t *testing.T) {
+	decoded, err := decodeTransferEncoding([]byte("aGVs\r\nbG8=\r\n"), "BASE64")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(decoded))
+
+	decoded, err = decodeTransferEncoding([]byte("caf=C3=A9=\r\n!"), "quoted-printable")
+	require.NoError(t, err)
+	assert.Equal(t, "café!", string(decoded))
+
+	decoded, err = decodeTransferEncoding([]byte("raw"), "7bit")
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(decoded))
+}
@@ -0,0 +1,211 @@
+package dsl
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// DefaultBackfillBatch is the number of messages a backfill processes per
+// run unless the rule sets batch.
+const DefaultBackfillBatch = 500
+
+// BackfillConfig processes the mail a rule missed because it did not exist
+// yet. Until the backfill is done, each run of the rule processes the next
+// batch of messages since Since that were in the mailbox when the backfill
+// started, newest first, instead of its usual search. Progress is kept in a
+// state file so restarts carry on where the last batch stopped.
+type BackfillConfig struct {
+	// Since is the date the backfill goes back to, e.g. 2023-01-01
+	Since string `yaml:"since"`
+	// Batch is the number of messages per run, DefaultBackfillBatch by
+	// default
+	Batch int `yaml:"batch,omitempty"`
+	// State overrides the path of the backfill state file
+	State string `yaml:"state,omitempty"`
+}
+
+// Validate checks if the backfill config is valid
+func (b *BackfillConfig) Validate() error {
+	if b.Since == "" {
+		return fmt.Errorf("since is required")
+	}
+	if _, err := parseDate(b.Since); err != nil {
+		return fmt.Errorf("invalid 'since' date: %w", err)
+	}
+	if b.Batch < 0 {
+		return fmt.Errorf("batch must not be negative")
+	}
+	return nil
+}
+
+func (b *BackfillConfig) batch() int {
+	if b.Batch > 0 {
+		return b.Batch
+	}
+	return DefaultBackfillBatch
+}
+
+// BackfillEntry is the progress of the backfill of a rule in a mailbox.
+type BackfillEntry struct {
+	Rule        string `json:"rule"`
+	Mailbox     string `json:"mailbox"`
+	UIDValidity uint32 `json:"uid_validity"`
+	// BeforeUID bounds the messages left to process: the UIDNEXT of the
+	// mailbox when the backfill started, then the lowest UID processed so
+	// far. 0 means no bound yet.
+	BeforeUID uint32    `json:"before_uid,omitempty"`
+	Processed int       `json:"processed"`
+	Done      bool      `json:"done,omitempty"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+// BackfillStore is the JSON file holding the progress of backfills.
+type BackfillStore struct {
+	Path    string
+	Entries []BackfillEntry
+}
+
+// DefaultBackfillStatePath returns the default backfill state file in
+// DefaultStateDir.
+func DefaultBackfillStatePath() string {
+	return filepath.Join(DefaultStateDir(), "backfill.json")
+}
+
+// LoadBackfillStore reads the store at path. A missing file is an empty
+// store.
+func LoadBackfillStore(path string) (*BackfillStore, error) {
+	if path == "" {
+		path = DefaultBackfillStatePath()
+	}
+	store := &BackfillStore{Path: path}
+	if err := readStateFile(path, &store.Entries); err != nil {
+		return nil, fmt.Errorf("failed to load backfill state: %w", err)
+	}
+	return store, nil
+}
+
+// Save writes the store atomically.
+func (s *BackfillStore) Save() error {
+	if err := writeStateFile(s.Path, s.Entries); err != nil {
+		return fmt.Errorf("failed to save backfill state: %w", err)
+	}
+	return nil
+}
+
+// put replaces the entry of the same rule and mailbox, or adds it.
+func (s *BackfillStore) put(entry BackfillEntry) {
+	for i := range s.Entries {
+		if s.Entries[i].Rule == entry.Rule && s.Entries[i].Mailbox == entry.Mailbox {
+			s.Entries[i] = entry
+			return
+		}
+	}
+	s.Entries = append(s.Entries, entry)
+}
+
+// entry returns the entry of rule in mailbox.
+func (s *BackfillStore) entry(rule string, mailbox string) (BackfillEntry, bool) {
+	for _, entry := range s.Entries {
+		if entry.Rule == rule && entry.Mailbox == mailbox {
+			return entry, true
+		}
+	}
+	return BackfillEntry{}, false
+}
+
+// backfillMu serializes the updates of backfill state files by rules
+// running concurrently.
+var backfillMu sync.Mutex
+
+// Backfill is the next batch of a rule's backfill.
+type Backfill struct {
+	// Rule is a copy of the rule searching the batch
+	Rule   *Rule
+	parent *Rule
+	entry  BackfillEntry
+}
+
+// NextBackfill returns the next batch of the rule's backfill in mailbox,
+// selected with selectData, or nil when the rule has no backfill or it is
+// done. A changed UIDVALIDITY starts the backfill over.
+func (r *Rule) NextBackfill(mailbox string, selectData *imap.SelectData) (*Backfill, error) {
+	if r.Backfill == nil {
+		return nil, nil
+	}
+	backfillMu.Lock()
+	store, err := LoadBackfillStore(r.Backfill.State)
+	backfillMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := store.entry(r.Name, mailbox)
+	if ok && entry.UIDValidity != selectData.UIDValidity {
+		logger := r.Logger()
+		logger.Warn().
+			Str("mailbox", mailbox).
+			Msg("UIDVALIDITY of the mailbox changed, starting the backfill over")
+		ok = false
+	}
+	if !ok {
+		entry = BackfillEntry{
+			Rule:        r.Name,
+			Mailbox:     mailbox,
+			UIDValidity: selectData.UIDValidity,
+			BeforeUID:   uint32(selectData.UIDNext),
+			Started:     time.Now(),
+		}
+	}
+	if entry.Done {
+		return nil, nil
+	}
+
+	batch := *r
+	batch.Search.Since = r.Backfill.Since
+	batch.Search.Before = ""
+	batch.Search.On = ""
+	batch.Search.WithinDays = 0
+	batch.Output.Limit = r.Backfill.batch()
+	batch.Output.Offset = 0
+	batch.Output.SortBy = ""
+	batch.Output.BeforeUID = entry.BeforeUID
+	return &Backfill{Rule: &batch, parent: r, entry: entry}, nil
+}
+
+// Finish records the batch as processed, messages being the messages it
+// fetched, and saves the progress. The backfill is done once a batch
+// covered all the messages left.
+func (b *Backfill) Finish(messages []*EmailMessage) error {
+	b.parent.matched = b.Rule.matched
+	b.parent.fetched = b.Rule.fetched
+
+	entry := b.entry
+	for _, msg := range messages {
+		if entry.BeforeUID == 0 || msg.UID < entry.BeforeUID {
+			entry.BeforeUID = msg.UID
+		}
+	}
+	entry.Processed += len(messages)
+	entry.Updated = time.Now()
+	entry.Done = b.Rule.matched <= b.Rule.Output.Limit || entry.BeforeUID == 1
+	logger := b.Rule.Logger()
+	logger.Info().
+		Int("processed", entry.Processed).
+		Uint32("before_uid", entry.BeforeUID).
+		Bool("done", entry.Done).
+		Msg("Processed backfill batch")
+
+	backfillMu.Lock()
+	defer backfillMu.Unlock()
+	store, err := LoadBackfillStore(b.parent.Backfill.State)
+	if err != nil {
+		return err
+	}
+	store.put(entry)
+	return store.Save()
+}
@@ -0,0 +1,64 @@
+package dsl
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfill(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "backfill.json")
+	rule := &Rule{
+		Name:     "archive",
+		Search:   SearchConfig{WithinDays: 7, From: "billing@example.com"},
+		Output:   OutputConfig{Limit: 20},
+		Backfill: &BackfillConfig{Since: "2023-01-01", Batch: 2, State: state},
+	}
+	selectData := &imap.SelectData{UIDValidity: 42, UIDNext: 100}
+
+	backfill, err := rule.NextBackfill("INBOX", selectData)
+	require.NoError(t, err)
+	require.NotNil(t, backfill)
+	batch := backfill.Rule
+	assert.Equal(t, "2023-01-01", batch.Search.Since)
+	assert.Zero(t, batch.Search.WithinDays)
+	assert.Equal(t, "billing@example.com", batch.Search.From)
+	assert.Equal(t, 2, batch.Output.Limit)
+	assert.Equal(t, uint32(100), batch.Output.BeforeUID)
+	// The rule itself keeps its usual search
+	assert.Equal(t, 7, rule.Search.WithinDays)
+
+	batch.matched = 5
+	require.NoError(t, backfill.Finish([]*EmailMessage{{UID: 97}, {UID: 90}}))
+	assert.Equal(t, 5, rule.Matched())
+
+	backfill, err = rule.NextBackfill("INBOX", selectData)
+	require.NoError(t, err)
+	require.NotNil(t, backfill)
+	assert.Equal(t, uint32(90), backfill.Rule.Output.BeforeUID)
+
+	// The last batch covers the rest
+	backfill.Rule.matched = 2
+	require.NoError(t, backfill.Finish([]*EmailMessage{{UID: 12}, {UID: 3}}))
+	backfill, err = rule.NextBackfill("INBOX", selectData)
+	require.NoError(t, err)
+	assert.Nil(t, backfill)
+
+	store, err := LoadBackfillStore(state)
+	require.NoError(t, err)
+	require.Len(t, store.Entries, 1)
+	assert.Equal(t, 4, store.Entries[0].Processed)
+	assert.True(t, store.Entries[0].Done)
+
+	// A new UIDVALIDITY starts over
+	backfill, err = rule.NextBackfill("INBOX", &imap.SelectData{UIDValidity: 43, UIDNext: 10})
+	require.NoError(t, err)
+	require.NotNil(t, backfill)
+	assert.Equal(t, uint32(10), backfill.Rule.Output.BeforeUID)
+
+	assert.ErrorContains(t, (&BackfillConfig{}).Validate(), "since is required")
+	assert.ErrorContains(t, (&BackfillConfig{Since: "yesterday"}).Validate(), "invalid 'since' date")
+}
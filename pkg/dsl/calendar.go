@@ -0,0 +1,324 @@
+package dsl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CalendarMediaTypes are the MIME types of the calendar parts read by
+// MessageCalendars: iTIP invites (RFC 6047) and attached .ics files.
+var CalendarMediaTypes = []string{"text/calendar", "application/ics"}
+
+// maxCalendarLine is the length in octets iCalendar lines are folded at.
+const maxCalendarLine = 75
+
+// CalendarComponent is a component of an iCalendar object (RFC 5545) such as
+// a VEVENT or VTIMEZONE, kept as its unfolded content lines so that it can be
+// written back unchanged. Nested components (VALARM in a VEVENT, STANDARD in
+// a VTIMEZONE) are part of the lines.
+type CalendarComponent struct {
+	Name  string
+	Lines []string
+}
+
+// Property returns the value of the first property called name, without its
+// parameters.
+func (c *CalendarComponent) Property(name string) string {
+	depth := 0
+	for _, line := range c.Lines[1 : len(c.Lines)-1] {
+		prop, value := splitCalendarLine(line)
+		switch {
+		case prop == "BEGIN":
+			depth++
+		case prop == "END":
+			depth--
+		case depth == 0 && prop == name:
+			return value
+		}
+	}
+	return ""
+}
+
+// setProperty replaces the top-level property called name, or adds it
+// before the end of the component.
+func (c *CalendarComponent) setProperty(name, value string) {
+	line := name + ":" + value
+	depth := 0
+	for i, l := range c.Lines[1 : len(c.Lines)-1] {
+		prop, _ := splitCalendarLine(l)
+		switch {
+		case prop == "BEGIN":
+			depth++
+		case prop == "END":
+			depth--
+		case depth == 0 && prop == name:
+			c.Lines[i+1] = line
+			return
+		}
+	}
+	end := len(c.Lines) - 1
+	c.Lines = append(c.Lines[:end:end], line, c.Lines[end])
+}
+
+// Calendar is a parsed iCalendar object.
+type Calendar struct {
+	// Method is the iTIP method of an invite (REQUEST, CANCEL, REPLY, ...)
+	Method    string
+	Events    []*CalendarComponent
+	Timezones []*CalendarComponent
+}
+
+// ParseCalendar parses an iCalendar object. Only the VEVENT and VTIMEZONE
+// components are kept.
+func ParseCalendar(data []byte) (*Calendar, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	// Unfold continuation lines
+	text = strings.ReplaceAll(text, "\n ", "")
+	text = strings.ReplaceAll(text, "\n\t", "")
+
+	cal := &Calendar{}
+	var stack []string
+	var current *CalendarComponent
+	inCalendar := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		prop, value := splitCalendarLine(line)
+		switch prop {
+		case "BEGIN":
+			value = strings.ToUpper(value)
+			if len(stack) == 0 && value != "VCALENDAR" {
+				return nil, fmt.Errorf("calendar does not start with BEGIN:VCALENDAR")
+			}
+			if len(stack) == 0 {
+				inCalendar = true
+			}
+			if len(stack) == 1 && (value == "VEVENT" || value == "VTIMEZONE") {
+				current = &CalendarComponent{Name: value}
+			}
+			stack = append(stack, value)
+		case "END":
+			value = strings.ToUpper(value)
+			if len(stack) == 0 || stack[len(stack)-1] != value {
+				return nil, fmt.Errorf("unexpected END:%s", value)
+			}
+			stack = stack[:len(stack)-1]
+		}
+		if current != nil {
+			current.Lines = append(current.Lines, line)
+		}
+		if prop == "END" && len(stack) == 1 && current != nil {
+			if current.Name == "VEVENT" {
+				cal.Events = append(cal.Events, current)
+			} else {
+				cal.Timezones = append(cal.Timezones, current)
+			}
+			current = nil
+		}
+		if prop == "METHOD" && len(stack) == 1 {
+			cal.Method = strings.ToUpper(value)
+		}
+	}
+	if !inCalendar {
+		return nil, fmt.Errorf("no VCALENDAR found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("calendar ends inside %s", stack[len(stack)-1])
+	}
+	return cal, nil
+}
+
+// splitCalendarLine returns the upper-cased property name and the value of
+// a content line. Colons inside quoted parameter values do not end the name.
+func splitCalendarLine(line string) (name, value string) {
+	quoted := false
+	nameEnd := -1
+	for i, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == ';' && nameEnd < 0:
+			nameEnd = i
+		case r == ':':
+			if nameEnd < 0 {
+				nameEnd = i
+			}
+			return strings.ToUpper(line[:nameEnd]), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
+
+// MessageCalendars parses the calendar parts among the fetched MIME parts of
+// msg. Messages without calendar parts return nil; parts that do not parse
+// are skipped with a warning, like mail clients do.
+func (rule *Rule) MessageCalendars(msg *EmailMessage) []*Calendar {
+	var ret []*Calendar
+	for _, part := range msg.MimeParts {
+		mediaType := strings.ToLower(partMediaType(part))
+		isCalendar := false
+		for _, t := range CalendarMediaTypes {
+			isCalendar = isCalendar || mediaType == t
+		}
+		if !isCalendar {
+			continue
+		}
+		content, err := decodeTransferEncoding([]byte(part.Content), part.Encoding)
+		if err == nil {
+			var cal *Calendar
+			if cal, err = ParseCalendar(content); err == nil {
+				ret = append(ret, cal)
+				continue
+			}
+		}
+		logger := rule.Logger()
+		logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Skipping calendar part that does not parse")
+	}
+	return ret
+}
+
+// CalendarFeed aggregates the events of many invites into one calendar. An
+// event sent several times, updated or cancelled is kept once, in its latest
+// version.
+type CalendarFeed struct {
+	Name      string
+	events    map[string]*CalendarComponent
+	timezones map[string]*CalendarComponent
+}
+
+// NewCalendarFeed returns an empty calendar called name.
+func NewCalendarFeed(name string) *CalendarFeed {
+	return &CalendarFeed{
+		Name:      name,
+		events:    make(map[string]*CalendarComponent),
+		timezones: make(map[string]*CalendarComponent),
+	}
+}
+
+// Add adds the events of cal. Events are identified by their UID and
+// RECURRENCE-ID; of several versions the one with the highest SEQUENCE and
+// then the latest DTSTAMP wins. Cancelled events stay in the feed with
+// STATUS:CANCELLED, so subscribed calendars drop them. Replies and other
+// iTIP methods that do not describe the event itself are ignored.
+func (f *CalendarFeed) Add(cal *Calendar) {
+	switch cal.Method {
+	case "", "PUBLISH", "REQUEST", "ADD", "CANCEL":
+	default:
+		return
+	}
+	for _, event := range cal.Events {
+		uid := event.Property("UID")
+		if uid == "" {
+			continue
+		}
+		event = &CalendarComponent{Name: event.Name, Lines: append([]string(nil), event.Lines...)}
+		if cal.Method == "CANCEL" {
+			event.setProperty("STATUS", "CANCELLED")
+		}
+		key := uid + "\x00" + event.Property("RECURRENCE-ID")
+		if old, ok := f.events[key]; ok && calendarVersionLess(event, old) {
+			continue
+		}
+		f.events[key] = event
+	}
+	for _, tz := range cal.Timezones {
+		if id := tz.Property("TZID"); id != "" {
+			if _, ok := f.timezones[id]; !ok {
+				f.timezones[id] = tz
+			}
+		}
+	}
+}
+
+// Len returns the number of events in the feed.
+func (f *CalendarFeed) Len() int {
+	return len(f.events)
+}
+
+// calendarVersionLess reports whether a is an older version of an event
+// than b.
+func calendarVersionLess(a, b *CalendarComponent) bool {
+	seqA, _ := strconv.Atoi(a.Property("SEQUENCE"))
+	seqB, _ := strconv.Atoi(b.Property("SEQUENCE"))
+	if seqA != seqB {
+		return seqA < seqB
+	}
+	// UTC date-times compare as strings
+	return a.Property("DTSTAMP") < b.Property("DTSTAMP")
+}
+
+// WriteICS writes the feed as an iCalendar file, events ordered by start.
+func (f *CalendarFeed) WriteICS(w io.Writer) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//go-go-golems//smailnail//EN",
+		"CALSCALE:GREGORIAN",
+	}
+	if f.Name != "" {
+		lines = append(lines, "X-WR-CALNAME:"+escapeCalendarText(f.Name))
+	}
+
+	tzids := make([]string, 0, len(f.timezones))
+	for id := range f.timezones {
+		tzids = append(tzids, id)
+	}
+	sort.Strings(tzids)
+	for _, id := range tzids {
+		lines = append(lines, f.timezones[id].Lines...)
+	}
+
+	events := make([]*CalendarComponent, 0, len(f.events))
+	for _, event := range f.events {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		si, sj := events[i].Property("DTSTART"), events[j].Property("DTSTART")
+		if si != sj {
+			return si < sj
+		}
+		return events[i].Property("UID") < events[j].Property("UID")
+	})
+	for _, event := range events {
+		lines = append(lines, event.Lines...)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var b strings.Builder
+	for _, line := range lines {
+		writeFoldedCalendarLine(&b, line)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeFoldedCalendarLine writes line, folded at 75 octets without splitting
+// UTF-8 sequences.
+func writeFoldedCalendarLine(b *strings.Builder, line string) {
+	limit := maxCalendarLine
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space counts towards the next line
+		limit = maxCalendarLine - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// escapeCalendarText escapes a TEXT value.
+func escapeCalendarText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
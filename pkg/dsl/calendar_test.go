@@ -0,0 +1,91 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func invite(method, sequence, dtstamp, summary string) string {
+	return strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"METHOD:" + method,
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Berlin",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:standup@example.com",
+		"SEQUENCE:" + sequence,
+		"DTSTAMP:" + dtstamp,
+		"DTSTART;TZID=Europe/Berlin:20250303T090000",
+		"SUMMARY:" + summary,
+		`ORGANIZER;CN="Boss: Team":mailto:boss@example.com`,
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"SUMMARY:alarm",
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+}
+
+func TestParseCalendar(t *testing.T) {
+	folded := strings.Replace(invite("REQUEST", "0", "20250101T000000Z", "Daily standup"), "SUMMARY:Daily standup", "SUMMARY:Daily\r\n  standup", 1)
+	cal, err := ParseCalendar([]byte(folded))
+	require.NoError(t, err)
+	assert.Equal(t, "REQUEST", cal.Method)
+	require.Len(t, cal.Events, 1)
+	require.Len(t, cal.Timezones, 1)
+	event := cal.Events[0]
+	assert.Equal(t, "Daily standup", event.Property("SUMMARY"))
+	assert.Equal(t, "mailto:boss@example.com", event.Property("ORGANIZER"))
+	assert.Equal(t, "20250303T090000", event.Property("DTSTART"))
+
+	_, err = ParseCalendar([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.Error(t, err)
+	_, err = ParseCalendar([]byte("hello"))
+	assert.Error(t, err)
+}
+
+func TestCalendarFeed(t *testing.T) {
+	parse := func(s string) *Calendar {
+		cal, err := ParseCalendar([]byte(s))
+		require.NoError(t, err)
+		return cal
+	}
+
+	feed := NewCalendarFeed("Team, meetings")
+	feed.Add(parse(invite("REQUEST", "1", "20250102T000000Z", "Moved standup")))
+	feed.Add(parse(invite("REQUEST", "0", "20250101T000000Z", "Daily standup")))
+	feed.Add(parse(invite("REPLY", "5", "20250105T000000Z", "Accepted")))
+	assert.Equal(t, 1, feed.Len())
+
+	var b strings.Builder
+	require.NoError(t, feed.WriteICS(&b))
+	ics := b.String()
+	assert.Contains(t, ics, "X-WR-CALNAME:Team\\, meetings\r\n")
+	assert.Contains(t, ics, "SUMMARY:Moved standup\r\n")
+	assert.NotContains(t, ics, "METHOD")
+	assert.Equal(t, 1, strings.Count(ics, "BEGIN:VTIMEZONE"))
+	assert.NotContains(t, ics, "STATUS:CANCELLED")
+
+	feed.Add(parse(invite("CANCEL", "1", "20250103T000000Z", "Moved standup")))
+	b.Reset()
+	require.NoError(t, feed.WriteICS(&b))
+	// The status goes after the alarm, at the end of the event
+	assert.Contains(t, b.String(), "END:VALARM\r\nSTATUS:CANCELLED\r\nEND:VEVENT\r\n")
+}
+
+func TestWriteFoldedCalendarLine(t *testing.T) {
+	var b strings.Builder
+	writeFoldedCalendarLine(&b, "DESCRIPTION:"+strings.Repeat("ä", 60))
+	for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+	cal, err := ParseCalendar([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n" + b.String() + "END:VEVENT\r\nEND:VCALENDAR\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("ä", 60), cal.Events[0].Property("DESCRIPTION"))
+}
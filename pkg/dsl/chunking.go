@@ -0,0 +1,209 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Search window sizes, from the largest to the smallest.
+const (
+	WindowMonth = "month"
+	WindowWeek  = "week"
+	WindowDay   = "day"
+)
+
+var windowSizes = []string{WindowMonth, WindowWeek, WindowDay}
+
+// ChunkingConfig splits the search into date windows on servers that cap
+// the number of SEARCH results. Results are chunked when the server's ESEARCH
+// count exceeds the results it returned, even without a chunking section.
+type ChunkingConfig struct {
+	// Window is the initial window size: month (the default), week or day.
+	// Windows still looking truncated are split into the next smaller size.
+	Window string `yaml:"window,omitempty"`
+	// Threshold is the server's result cap: searches returning at least this
+	// many results are assumed truncated and repeated in windows
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
+// Validate checks the window size and the threshold.
+func (c *ChunkingConfig) Validate() error {
+	if c.Window != "" && windowIndex(c.Window) < 0 {
+		return fmt.Errorf("window must be month, week or day, got %q", c.Window)
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("threshold must be non-negative")
+	}
+	return nil
+}
+
+func windowIndex(window string) int {
+	for i, size := range windowSizes {
+		if size == window {
+			return i
+		}
+	}
+	return -1
+}
+
+// looksTruncated reports whether search results seem cut off by the server:
+// the ESEARCH count exceeds the returned results, or they reach the
+// configured threshold.
+func (c *ChunkingConfig) looksTruncated(data *imap.SearchData) bool {
+	returned := len(data.AllSeqNums())
+	if returned == 0 {
+		return false
+	}
+	if data.Count > uint32(returned) {
+		return true
+	}
+	return c != nil && c.Threshold > 0 && returned >= c.Threshold
+}
+
+// searchWindow is a [since, before) range of internal dates.
+type searchWindow struct {
+	since, before time.Time
+}
+
+// splitWindows splits the days from since to before into windows of the
+// given size. Months and weeks start on the first of the month and on
+// Mondays, so windows line up across runs.
+func splitWindows(since, before time.Time, size string) []searchWindow {
+	var windows []searchWindow
+	start := since
+	for start.Before(before) {
+		var end time.Time
+		switch size {
+		case WindowMonth:
+			end = time.Date(start.Year(), start.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case WindowWeek:
+			days := (8 - int(start.Weekday())) % 7
+			if days == 0 {
+				days = 7
+			}
+			end = start.AddDate(0, 0, days)
+		default:
+			end = start.AddDate(0, 0, 1)
+		}
+		if end.After(before) {
+			end = before
+		}
+		windows = append(windows, searchWindow{since: start, before: end})
+		start = end
+	}
+	return windows
+}
+
+// mailboxDateRange returns the days spanned by the internal dates of the first
+// and last messages of the selected mailbox, widened by a day on each side
+// since SINCE and BEFORE compare dates in the server's time zone. Messages
+// appended with older or newer dates can lie outside of it.
+func mailboxDateRange(client *imapclient.Client) (time.Time, time.Time, error) {
+	var seqSet imap.SeqSet
+	// 0 is "*", the last message
+	seqSet.AddNum(1, 0)
+	buffers, err := client.Fetch(seqSet, &imap.FetchOptions{InternalDate: true}).Collect()
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to fetch the dates of the mailbox: %w", err)
+	}
+	if len(buffers) == 0 {
+		return time.Time{}, time.Time{}, fmt.Errorf("mailbox is empty")
+	}
+	first, last := buffers[0].InternalDate, buffers[0].InternalDate
+	for _, buf := range buffers {
+		if buf.InternalDate.Before(first) {
+			first = buf.InternalDate
+		}
+		if buf.InternalDate.After(last) {
+			last = buf.InternalDate
+		}
+	}
+	day := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return day(first).AddDate(0, 0, -1), day(last).AddDate(0, 0, 2), nil
+}
+
+// searchInWindows repeats the search in date windows spanning the selected
+// mailbox, plus two open windows before and after it, and returns the merged
+// sequence numbers in ascending order. Windows whose results still look
+// truncated are split further; a day or open window that is still truncated
+// is returned as is, with a warning.
+func (rule *Rule) searchInWindows(client *imapclient.Client, criteria *imap.SearchCriteria) ([]uint32, error) {
+	since, before, err := mailboxDateRange(client)
+	if err != nil {
+		return nil, err
+	}
+	size := WindowMonth
+	if rule.Chunking != nil && rule.Chunking.Window != "" {
+		size = rule.Chunking.Window
+	}
+
+	seen := make(map[uint32]bool)
+	windows := 0
+	var search func(window searchWindow, size string) error
+	search = func(window searchWindow, size string) error {
+		windows++
+		windowCriteria := *criteria
+		windowCriteria.And(&imap.SearchCriteria{Since: window.since, Before: window.before})
+		data, err := client.Search(&windowCriteria, &imap.SearchOptions{ReturnAll: true, ReturnCount: true}).Wait()
+		if err != nil {
+			return fmt.Errorf("failed to search from %s to %s: %w", formatWindowDate(window.since), formatWindowDate(window.before), wrapSearchError(err))
+		}
+		if rule.Chunking.looksTruncated(data) {
+			next := windowIndex(size) + 1
+			if next > 0 && next < len(windowSizes) {
+				for _, smaller := range splitWindows(window.since, window.before, windowSizes[next]) {
+					if err := search(smaller, windowSizes[next]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			logger := rule.Logger()
+			logger.Warn().
+				Time("since", window.since).
+				Time("before", window.before).
+				Int("returned", len(data.AllSeqNums())).
+				Msg("Search results of a date window still look truncated and cannot be split further")
+		}
+		for _, seqNum := range data.AllSeqNums() {
+			seen[seqNum] = true
+		}
+		return nil
+	}
+	if err := search(searchWindow{before: since}, ""); err != nil {
+		return nil, err
+	}
+	for _, window := range splitWindows(since, before, size) {
+		if err := search(window, size); err != nil {
+			return nil, err
+		}
+	}
+	if err := search(searchWindow{since: before}, ""); err != nil {
+		return nil, err
+	}
+
+	seqNums := make([]uint32, 0, len(seen))
+	for seqNum := range seen {
+		seqNums = append(seqNums, seqNum)
+	}
+	sort.Slice(seqNums, func(i, j int) bool { return seqNums[i] < seqNums[j] })
+	logger := rule.Logger()
+	logger.Debug().
+		Int("windows", windows).
+		Int("matched", len(seqNums)).
+		Msg("Searched in date windows")
+	return seqNums, nil
+}
+
+func formatWindowDate(t time.Time) string {
+	if t.IsZero() {
+		return "any date"
+	}
+	return t.Format(time.DateOnly)
+}
@@ -0,0 +1,91 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDate(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestSplitWindows(t *testing.T) {
+	dates := func(windows []searchWindow) [][2]string {
+		ret := make([][2]string, len(windows))
+		for i, w := range windows {
+			ret[i] = [2]string{w.since.Format(time.DateOnly), w.before.Format(time.DateOnly)}
+		}
+		return ret
+	}
+
+	assert.Equal(t, [][2]string{
+		{"2024-11-20", "2024-12-01"},
+		{"2024-12-01", "2025-01-01"},
+		{"2025-01-01", "2025-01-10"},
+	}, dates(splitWindows(mustDate("2024-11-20"), mustDate("2025-01-10"), WindowMonth)))
+
+	// 2024-12-04 is a Wednesday, weeks start on Mondays
+	assert.Equal(t, [][2]string{
+		{"2024-12-04", "2024-12-09"},
+		{"2024-12-09", "2024-12-16"},
+		{"2024-12-16", "2024-12-18"},
+	}, dates(splitWindows(mustDate("2024-12-04"), mustDate("2024-12-18"), WindowWeek)))
+
+	assert.Equal(t, [][2]string{
+		{"2024-12-30", "2024-12-31"},
+		{"2024-12-31", "2025-01-01"},
+	}, dates(splitWindows(mustDate("2024-12-30"), mustDate("2025-01-01"), WindowDay)))
+}
+
+func TestLooksTruncated(t *testing.T) {
+	returned := func(n int, count uint32) *imap.SearchData {
+		var set imap.SeqSet
+		for i := 1; i <= n; i++ {
+			set.AddNum(uint32(i))
+		}
+		return &imap.SearchData{All: set, Count: count}
+	}
+
+	var none *ChunkingConfig
+	assert.False(t, none.looksTruncated(returned(10, 10)))
+	assert.True(t, none.looksTruncated(returned(10, 250)))
+	assert.False(t, none.looksTruncated(returned(0, 250)), "a count without results is not truncation")
+
+	capped := &ChunkingConfig{Threshold: 10}
+	assert.True(t, capped.looksTruncated(returned(10, 0)))
+	assert.False(t, capped.looksTruncated(returned(9, 0)))
+}
+
+func TestChunkingValidation(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: archive
+chunking:
+  window: week
+  threshold: 1000
+search:
+  before: "2020-01-01"
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	assert.Equal(t, &ChunkingConfig{Window: WindowWeek, Threshold: 1000}, rule.Chunking)
+
+	_, err = ParseRuleString(`
+name: archive
+chunking:
+  window: year
+search:
+  before: "2020-01-01"
+output:
+  fields: [uid]
+`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,325 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Labels assigned by the built-in classifier.
+const (
+	LabelNewsletter    = "newsletter"
+	LabelNotification  = "notification"
+	LabelPersonal      = "personal"
+	LabelTransactional = "transactional"
+	LabelSpamLikely    = "spam-likely"
+)
+
+// defaultClassifyTimeout bounds each call to an external classifier.
+const defaultClassifyTimeout = 10 * time.Second
+
+// Classifier labels a message. An empty label means no opinion, leaving the
+// message to the next classifier.
+type Classifier interface {
+	Classify(msg *EmailMessage) (string, error)
+}
+
+// ClassifierFunc adapts a function to the Classifier interface.
+type ClassifierFunc func(msg *EmailMessage) (string, error)
+
+// Classify calls f.
+func (f ClassifierFunc) Classify(msg *EmailMessage) (string, error) {
+	return f(msg)
+}
+
+// AddClassifier adds a classifier that runs before the ones configured in
+// the rule's classify section.
+func (rule *Rule) AddClassifier(classifier Classifier) {
+	rule.classifiers = append(rule.classifiers, classifier)
+}
+
+// ClassifyConfig labels the matched messages. External classifiers run
+// first, in the order command, http; the first non-empty label wins and the
+// built-in heuristics label the rest.
+type ClassifyConfig struct {
+	// Builtin enables the built-in heuristics (default: true)
+	Builtin *bool `yaml:"builtin,omitempty"`
+	// Command is run for each message with its JSON on stdin and prints the
+	// label
+	Command []string `yaml:"command,omitempty"`
+	// HTTP is an endpoint the message JSON is POSTed to, answering
+	// {"label": "..."}
+	HTTP string `yaml:"http,omitempty"`
+	// Timeout bounds each external classifier call (default: 10s)
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// Validate checks if the classify configuration is valid
+func (c *ClassifyConfig) Validate() error {
+	if c.HTTP != "" {
+		u, err := url.Parse(c.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("http must be an http or https URL, got %q", c.HTTP)
+		}
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	if !c.builtin() && len(c.Command) == 0 && c.HTTP == "" {
+		return fmt.Errorf("classify needs a command or http classifier when builtin is false")
+	}
+	return nil
+}
+
+func (c *ClassifyConfig) builtin() bool {
+	return c.Builtin == nil || *c.Builtin
+}
+
+func (c *ClassifyConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && c.Timeout != "" {
+		return d
+	}
+	return defaultClassifyTimeout
+}
+
+// classifyHeaderFields are the headers the built-in heuristics look at.
+var classifyHeaderFields = []string{
+	"List-Id", "List-Unsubscribe", "Precedence", "Auto-Submitted",
+	"X-Spam-Flag", "X-Spam-Status",
+}
+
+// classifySection fetches the headers for the built-in classifier.
+var classifySection = &imap.FetchItemBodySection{
+	Specifier:    imap.PartSpecifierHeader,
+	HeaderFields: classifyHeaderFields,
+	Peek:         true,
+}
+
+// parseClassifyHeader reads a fetched header section.
+func parseClassifyHeader(raw []byte) textproto.Header {
+	if len(raw) == 0 {
+		return textproto.Header{}
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return textproto.Header{}
+	}
+	return header
+}
+
+var transactionalSubjectPattern = regexp.MustCompile(`(?i)\b(receipt|invoice|order|payment|shipped|shipping|delivery|booking|reservation|confirm(ation|ed)?|verif(y|ication)|password|security code|one-time|sign-in|login code)\b`)
+
+var automatedLocalParts = map[string]bool{
+	"noreply": true, "no-reply": true, "donotreply": true, "do-not-reply": true,
+	"notification": true, "notifications": true, "alerts": true, "alert": true,
+	"mailer-daemon": true, "postmaster": true, "bounce": true, "bounces": true,
+}
+
+// builtinClassify labels a message from its headers: spam-likely when a
+// spam filter flagged it, transactional for receipts, orders and account
+// codes, newsletter for list and bulk mail, notification for other automated
+// mail and personal for the rest.
+func builtinClassify(msg *EmailMessage) string {
+	header := msg.classifyHeader
+	if strings.EqualFold(strings.TrimSpace(header.Get("X-Spam-Flag")), "yes") ||
+		strings.HasPrefix(strings.ToLower(strings.TrimSpace(header.Get("X-Spam-Status"))), "yes") {
+		return LabelSpamLikely
+	}
+
+	var subject, fromLocal string
+	if msg.Envelope != nil {
+		subject = msg.Envelope.Subject
+		if len(msg.Envelope.From) > 0 {
+			fromLocal = exprAddress(msg.Envelope.From[0])["local"].(string)
+		}
+	}
+	automated := automatedLocalParts[fromLocal] ||
+		(header.Has("Auto-Submitted") && !strings.EqualFold(strings.TrimSpace(header.Get("Auto-Submitted")), "no"))
+
+	if transactionalSubjectPattern.MatchString(subject) && (automated || !header.Has("List-Id")) {
+		return LabelTransactional
+	}
+	precedence := strings.ToLower(strings.TrimSpace(header.Get("Precedence")))
+	if header.Has("List-Id") || header.Has("List-Unsubscribe") || precedence == "bulk" || precedence == "list" {
+		return LabelNewsletter
+	}
+	if automated || precedence == "junk" {
+		return LabelNotification
+	}
+	return LabelPersonal
+}
+
+// classifyMessage is the JSON external classifiers receive.
+type classifyMessage struct {
+	UID     uint32            `json:"uid"`
+	Subject string            `json:"subject"`
+	From    string            `json:"from"`
+	To      []string          `json:"to"`
+	Date    string            `json:"date,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body holds the text parts the rule fetched
+	Body string `json:"body,omitempty"`
+}
+
+func newClassifyMessage(msg *EmailMessage) classifyMessage {
+	ret := classifyMessage{UID: msg.UID, To: []string{}, Body: exprBody(msg.MimeParts)}
+	if msg.Envelope != nil {
+		ret.Subject = msg.Envelope.Subject
+		if len(msg.Envelope.From) > 0 {
+			ret.From = msg.Envelope.From[0].Address
+		}
+		for _, addr := range msg.Envelope.To {
+			ret.To = append(ret.To, addr.Address)
+		}
+		if !msg.Envelope.Date.IsZero() {
+			ret.Date = msg.Envelope.Date.Format(time.RFC3339)
+		}
+	}
+	for _, name := range classifyHeaderFields {
+		if value := msg.classifyHeader.Get(name); value != "" {
+			if ret.Headers == nil {
+				ret.Headers = map[string]string{}
+			}
+			ret.Headers[name] = value
+		}
+	}
+	return ret
+}
+
+// commandClassifier runs a program per message. The label is the first line
+// it prints.
+func commandClassifier(command []string, timeout time.Duration) Classifier {
+	return ClassifierFunc(func(msg *EmailMessage) (string, error) {
+		input, err := json.Marshal(newClassifyMessage(msg))
+		if err != nil {
+			return "", err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		// #nosec G204 -- the classifier command comes from the rule file.
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = bytes.NewReader(input)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("classifier %s failed: %w: %s", command[0], err, strings.TrimSpace(stderr.String()))
+		}
+		label, _, _ := strings.Cut(stdout.String(), "\n")
+		return strings.TrimSpace(label), nil
+	})
+}
+
+// httpClassifier POSTs the message JSON to endpoint.
+func httpClassifier(endpoint string, timeout time.Duration) Classifier {
+	client := &http.Client{Timeout: timeout}
+	return ClassifierFunc(func(msg *EmailMessage) (string, error) {
+		input, err := json.Marshal(newClassifyMessage(msg))
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(input))
+		if err != nil {
+			return "", fmt.Errorf("classifier request failed: %w", err)
+		}
+		defer func() {
+			_ = resp.Body.Close()
+		}()
+		if resp.StatusCode >= 300 {
+			return "", fmt.Errorf("classifier %s returned %s", endpoint, resp.Status)
+		}
+		var result struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return "", fmt.Errorf("invalid classifier response: %w", err)
+		}
+		return strings.TrimSpace(result.Label), nil
+	})
+}
+
+// labeler returns the function setting the label of messages, or nil if
+// the rule does not classify. A failing external classifier is logged and
+// the next one tried.
+func (rule *Rule) labeler() func(*EmailMessage) {
+	logger := rule.Logger()
+	if rule.Classify == nil && len(rule.classifiers) == 0 {
+		return nil
+	}
+	classifiers := append([]Classifier{}, rule.classifiers...)
+	builtin := true
+	if c := rule.Classify; c != nil {
+		if len(c.Command) > 0 {
+			classifiers = append(classifiers, commandClassifier(c.Command, c.timeout()))
+		}
+		if c.HTTP != "" {
+			classifiers = append(classifiers, httpClassifier(c.HTTP, c.timeout()))
+		}
+		builtin = c.builtin()
+	}
+	return func(msg *EmailMessage) {
+		for _, classifier := range classifiers {
+			label, err := classifier.Classify(msg)
+			if err != nil {
+				logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Classifier failed")
+				continue
+			}
+			if label != "" {
+				msg.Label = label
+				return
+			}
+		}
+		if builtin {
+			msg.Label = builtinClassify(msg)
+		}
+	}
+}
+
+// classifies reports whether the rule labels its messages.
+func (rule *Rule) classifies() bool {
+	return rule.Classify != nil || len(rule.classifiers) > 0
+}
+
+// sortedLabels returns the labels of by_label in order.
+func sortedLabels(byLabel map[string]*ActionConfig) []string {
+	labels := make([]string, 0, len(byLabel))
+	for label := range byLabel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// labelGroups splits messages by the by_label entry that applies to them,
+// keyed by label, with the remaining messages under "".
+func labelGroups(messages []*EmailMessage, byLabel map[string]*ActionConfig) ([]string, map[string][]*EmailMessage) {
+	groups := map[string][]*EmailMessage{}
+	for _, msg := range messages {
+		key := ""
+		if _, ok := byLabel[msg.Label]; ok && msg.Label != "" {
+			key = msg.Label
+		}
+		groups[key] = append(groups[key], msg)
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, groups
+}
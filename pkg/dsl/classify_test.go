@@ -0,0 +1,190 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseClassifyTestMessage(t *testing.T, headers string) *EmailMessage {
+	t.Helper()
+	raw := headers + "Date: Mon, 03 Mar 2025 10:00:00 +0000\r\n\r\nbody\r\n"
+	local, err := ParseLocalMessage([]byte(raw), 1, nil, time.Time{})
+	require.NoError(t, err)
+	return local.Message
+}
+
+func TestBuiltinClassify(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		want    string
+	}{
+		{"spam", "From: a@example.com\r\nSubject: hi\r\nX-Spam-Flag: YES\r\n", LabelSpamLikely},
+		{"spam status", "From: a@example.com\r\nSubject: hi\r\nX-Spam-Status: Yes, score=9.1\r\n", LabelSpamLikely},
+		{"receipt", "From: shop@example.com\r\nSubject: Your receipt from Shop\r\n", LabelTransactional},
+		{"shipped from noreply", "From: no-reply@shop.example.com\r\nSubject: Your order has shipped\r\nList-Id: <orders.shop.example.com>\r\n", LabelTransactional},
+		{"list mail about orders", "From: news@shop.example.com\r\nSubject: Order now\r\nList-Id: <news.shop.example.com>\r\n", LabelNewsletter},
+		{"newsletter", "From: news@example.net\r\nSubject: Weekly digest\r\nList-Unsubscribe: <mailto:leave@example.net>\r\n", LabelNewsletter},
+		{"bulk", "From: news@example.net\r\nSubject: Weekly digest\r\nPrecedence: bulk\r\n", LabelNewsletter},
+		{"notification", "From: notifications@github.example.com\r\nSubject: New comment\r\n", LabelNotification},
+		{"auto-submitted", "From: ci@example.com\r\nSubject: Build failed\r\nAuto-Submitted: auto-generated\r\n", LabelNotification},
+		{"personal", "From: alice@example.com\r\nSubject: Lunch?\r\nAuto-Submitted: no\r\n", LabelPersonal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, builtinClassify(parseClassifyTestMessage(t, tt.headers)))
+		})
+	}
+}
+
+func TestClassifyConfigValidate(t *testing.T) {
+	no := false
+	assert.NoError(t, (&ClassifyConfig{}).Validate())
+	assert.NoError(t, (&ClassifyConfig{Builtin: &no, Command: []string{"classify"}, Timeout: "2s"}).Validate())
+	assert.ErrorContains(t, (&ClassifyConfig{Builtin: &no}).Validate(), "needs a command or http classifier")
+	assert.ErrorContains(t, (&ClassifyConfig{HTTP: "ftp://example.com"}).Validate(), "http must be")
+	assert.ErrorContains(t, (&ClassifyConfig{Timeout: "soon"}).Validate(), "invalid timeout")
+}
+
+func TestLabelerOrder(t *testing.T) {
+	msg := parseClassifyTestMessage(t, "From: alice@example.com\r\nSubject: Lunch?\r\n")
+
+	rule := &Rule{Name: "classify", Classify: &ClassifyConfig{}}
+	rule.AddClassifier(ClassifierFunc(func(*EmailMessage) (string, error) {
+		return "", errors.New("model unavailable")
+	}))
+	rule.AddClassifier(ClassifierFunc(func(m *EmailMessage) (string, error) {
+		if m.Envelope.Subject == "Lunch?" {
+			return "social", nil
+		}
+		return "", nil
+	}))
+	rule.labeler()(msg)
+	assert.Equal(t, "social", msg.Label)
+
+	// No opinion falls back to the built-in heuristics
+	msg = parseClassifyTestMessage(t, "From: alice@example.com\r\nSubject: Report\r\n")
+	rule.labeler()(msg)
+	assert.Equal(t, LabelPersonal, msg.Label)
+
+	no := false
+	rule.Classify.Builtin = &no
+	msg = parseClassifyTestMessage(t, "From: alice@example.com\r\nSubject: Report\r\n")
+	rule.labeler()(msg)
+	assert.Equal(t, "", msg.Label)
+
+	assert.Nil(t, (&Rule{}).labeler())
+}
+
+func TestExternalClassifiers(t *testing.T) {
+	msg := parseClassifyTestMessage(t, "From: alice@example.com\r\nTo: me@example.com\r\nSubject: Lunch?\r\nList-Id: <x.example.org>\r\n")
+
+	var received classifyMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		_, _ = w.Write([]byte(`{"label": "social"}`))
+	}))
+	defer server.Close()
+
+	label, err := httpClassifier(server.URL, time.Second).Classify(msg)
+	require.NoError(t, err)
+	assert.Equal(t, "social", label)
+	assert.Equal(t, "Lunch?", received.Subject)
+	assert.Equal(t, "alice@example.com", received.From)
+	assert.Equal(t, []string{"me@example.com"}, received.To)
+	assert.Equal(t, "<x.example.org>", received.Headers["List-Id"])
+
+	label, err = commandClassifier([]string{"sh", "-c", `grep -q '"subject":"Lunch?"' && printf 'social\nignored\n'`}, time.Second).Classify(msg)
+	require.NoError(t, err)
+	assert.Equal(t, "social", label)
+
+	_, err = commandClassifier([]string{"sh", "-c", "echo broken >&2; exit 1"}, time.Second).Classify(msg)
+	assert.ErrorContains(t, err, "broken")
+}
+
+func TestClassifyFilterAndByLabel(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: triage
+search:
+  expr: label != "spam-likely"
+classify: {}
+output:
+  fields: [uid, label, subject]
+actions:
+  flags:
+    add: [Seen]
+  by_label:
+    newsletter:
+      move_to: Newsletters
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"INBOX", "Newsletters"}, rule.Mailboxes("INBOX"))
+
+	messages := readSampleMbox(t)
+	var emails []*EmailMessage
+	for _, msg := range messages {
+		emails = append(emails, msg.Message)
+	}
+	filtered, err := rule.applyClientFilter(emails)
+	require.NoError(t, err)
+	require.Len(t, filtered, len(emails))
+	assert.Equal(t, LabelNewsletter, filtered[1].Label)
+
+	keys, groups := labelGroups(filtered, rule.Actions.ByLabel)
+	assert.Equal(t, []string{"", LabelNewsletter}, keys)
+	assert.Len(t, groups[LabelNewsletter], 1)
+	assert.Len(t, groups[""], len(emails)-1)
+
+	_, err = CompileSieve(rule)
+	assert.ErrorContains(t, err, "classify cannot be compiled to Sieve")
+}
+
+func TestByLabelValidation(t *testing.T) {
+	_, err := ParseRuleString(`
+name: no-classify
+search: {}
+output:
+  fields: [uid]
+actions:
+  by_label:
+    newsletter:
+      move_to: Newsletters
+`)
+	assert.ErrorContains(t, err, "by_label needs a classify section")
+
+	_, err = ParseRuleString(`
+name: nested
+search: {}
+classify: {}
+output:
+  fields: [uid]
+actions:
+  by_label:
+    newsletter:
+      by_label:
+        personal:
+          move_to: Personal
+`)
+	assert.ErrorContains(t, err, "cannot contain by_label")
+
+	rule, err := ParseRuleString(`
+name: protected
+search: {}
+classify: {}
+output:
+  fields: [uid]
+actions:
+  by_label:
+    spam-likely:
+      delete: true
+`)
+	require.NoError(t, err)
+	assert.ErrorContains(t, rule.CheckProtected("Sent", DefaultProtectedMailboxes), "by_label.spam-likely.delete")
+}
@@ -0,0 +1,243 @@
+package dsl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message"
+)
+
+// DefaultClientSideBodyConcurrency is the number of text part downloads
+// client-side body matching keeps in flight.
+const DefaultClientSideBodyConcurrency = 4
+
+// clientBodyBatchSize is the number of messages whose text parts one FETCH
+// downloads.
+const clientBodyBatchSize = 50
+
+// clientSideBody reports whether body_contains and text are matched on the
+// client.
+func (s SearchConfig) clientSideBody() bool {
+	return s.ClientSideBody || s.BodyRegex != ""
+}
+
+func (s SearchConfig) validateClientSideBody() error {
+	if s.BodyRegex != "" {
+		if _, err := regexp.Compile(s.BodyRegex); err != nil {
+			return fmt.Errorf("invalid 'body_regex': %w", err)
+		}
+	}
+	if s.ClientSideBodyConcurrency < 0 {
+		return fmt.Errorf("invalid 'client_side_body_concurrency': %d", s.ClientSideBodyConcurrency)
+	}
+	if s.clientSideBody() && s.Operator != "" {
+		return fmt.Errorf("'client_side_body' and 'body_regex' cannot be combined with operator")
+	}
+	return nil
+}
+
+// bodyMatcher returns the client-side body criteria as a predicate on the
+// decoded text parts and header of a message, or nil if the body is
+// searched by the server. body_contains and text keep the case-insensitive
+// substring semantics of IMAP SEARCH.
+func (s SearchConfig) bodyMatcher() func(body string, header string) bool {
+	if !s.clientSideBody() {
+		return nil
+	}
+	var re *regexp.Regexp
+	if s.BodyRegex != "" {
+		// Validate compiled it already
+		re = regexp.MustCompile(s.BodyRegex)
+	}
+	return func(body string, header string) bool {
+		if s.BodyContains != "" && !containsFold(body, s.BodyContains) {
+			return false
+		}
+		if s.Text != "" && !containsFold(header, s.Text) && !containsFold(body, s.Text) {
+			return false
+		}
+		return re == nil || re.MatchString(body)
+	}
+}
+
+// filterBody downloads the text parts of the messages in seqNums and
+// returns, in the same order, those matching the client-side body criteria.
+// Batches of messages are fetched concurrently, up to
+// search.client_side_body_concurrency at a time.
+func (rule *Rule) filterBody(client *imapclient.Client, seqNums []uint32) ([]uint32, error) {
+	logger := rule.Logger()
+	match := rule.Search.bodyMatcher()
+	concurrency := rule.Search.ClientSideBodyConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultClientSideBodyConcurrency
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		matched  = make(map[uint32]bool)
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for start := 0; start < len(seqNums); start += clientBodyBatchSize {
+		end := start + clientBodyBatchSize
+		if end > len(seqNums) {
+			end = len(seqNums)
+		}
+		batch := seqNums[start:end]
+
+		sem <- struct{}{}
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			texts, err := fetchTextParts(client, batch, rule.Search.Text != "")
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for seqNum, text := range texts {
+				if match(text.body, text.header) {
+					matched[seqNum] = true
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	ret := make([]uint32, 0, len(matched))
+	for _, seqNum := range seqNums {
+		if matched[seqNum] {
+			ret = append(ret, seqNum)
+		}
+	}
+	logger.Debug().
+		Int("candidates", len(seqNums)).
+		Int("matched", len(ret)).
+		Int("concurrency", concurrency).
+		Msg("Matched message bodies on the client")
+	return ret, nil
+}
+
+// messageText is the decoded text of a message, as matched by bodyMatcher.
+type messageText struct {
+	body   string
+	header string
+}
+
+// fetchTextParts downloads the text parts of the messages in seqNums, leaving
+// out attachments, and decodes them. With header, the header is fetched too.
+func fetchTextParts(client *imapclient.Client, seqNums []uint32, header bool) (map[uint32]messageText, error) {
+	var seqSet imap.SeqSet
+	seqSet.AddNum(seqNums...)
+	// Dispositions, which tell attachments apart, are in the extended structure
+	options := &imap.FetchOptions{BodyStructure: &imap.FetchItemBodyStructure{Extended: true}}
+	headerSection := &imap.FetchItemBodySection{Peek: true, Specifier: imap.PartSpecifierHeader}
+	if header {
+		options.BodySection = []*imap.FetchItemBodySection{headerSection}
+	}
+	structures, err := client.Fetch(seqSet, options).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch body structures: %w", err)
+	}
+
+	type textPart struct {
+		section *imap.FetchItemBodySection
+		part    *imap.BodyStructureSinglePart
+	}
+	parts := make(map[uint32][]textPart)
+	texts := make(map[uint32]messageText, len(structures))
+	var partSet imap.SeqSet
+	var sections []*imap.FetchItemBodySection
+	seen := make(map[string]bool)
+	for _, msg := range structures {
+		texts[msg.SeqNum] = messageText{header: string(msg.FindBodySection(headerSection))}
+		if msg.BodyStructure == nil {
+			continue
+		}
+		msg.BodyStructure.Walk(func(partPath []int, bs imap.BodyStructure) bool {
+			single, ok := bs.(*imap.BodyStructureSinglePart)
+			if !ok || !strings.EqualFold(single.Type, "text") || isAttachmentPart(single) {
+				return true
+			}
+			section := &imap.FetchItemBodySection{Peek: true, Part: partPath}
+			parts[msg.SeqNum] = append(parts[msg.SeqNum], textPart{section: section, part: single})
+			if key := fmt.Sprint(partPath); !seen[key] {
+				seen[key] = true
+				sections = append(sections, section)
+			}
+			return true
+		})
+		if len(parts[msg.SeqNum]) > 0 {
+			partSet.AddNum(msg.SeqNum)
+		}
+	}
+	if len(sections) == 0 {
+		return texts, nil
+	}
+
+	fetched, err := client.Fetch(partSet, &imap.FetchOptions{BodySection: sections}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch text parts: %w", err)
+	}
+	for _, msg := range fetched {
+		var body []string
+		for _, p := range parts[msg.SeqNum] {
+			raw := msg.FindBodySection(p.section)
+			if raw == nil {
+				continue
+			}
+			content, err := decodeTransferEncoding(raw, p.part.Encoding)
+			if err != nil {
+				// Match undecodable parts as sent, like the server would
+				content = raw
+			}
+			body = append(body, decodeCharset(content, p.part.Params["charset"]))
+		}
+		text := texts[msg.SeqNum]
+		text.body = strings.Join(body, "\n")
+		texts[msg.SeqNum] = text
+	}
+	return texts, nil
+}
+
+// decodeCharset converts content from charset to UTF-8 through
+// message.CharsetReader, returning it unchanged if the charset is unknown.
+func decodeCharset(content []byte, charset string) string {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "us-ascii":
+		return string(content)
+	}
+	if message.CharsetReader == nil {
+		return string(content)
+	}
+	r, err := message.CharsetReader(charset, bytes.NewReader(content))
+	if err != nil {
+		return string(content)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(content)
+	}
+	return string(decoded)
+}
@@ -0,0 +1,108 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientSideBodyCriteria(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: invoices
+search:
+  from: example.com
+  body_contains: invoice
+  client_side_body: true
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+
+	criteria, _, err := rule.buildSearchCriteria()
+	require.NoError(t, err)
+	assert.Empty(t, criteria.Body, "the body is matched on the client")
+	assert.Equal(t, []imap.SearchCriteriaHeaderField{{Key: "From", Value: "example.com"}}, criteria.Header)
+
+	got, err := rule.FilterLocalMessages(readSampleMbox(t))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint32(1), got[0].UID)
+}
+
+func TestBodyRegex(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: questions
+search:
+  body_regex: '(?i)free for \w+ tomorrow\?'
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	assert.True(t, rule.Search.clientSideBody())
+
+	messages := readSampleMbox(t)
+	got, err := rule.FilterLocalMessages(messages)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint32(3), got[0].UID)
+
+	ok, err := rule.MatchLocal(messages[0])
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestBodyMatcher(t *testing.T) {
+	match := SearchConfig{ClientSideBody: true, BodyContains: "Stories", Text: "digest"}.bodyMatcher()
+	assert.True(t, match("Top stories this week.", "Subject: Weekly digest\n"))
+	assert.False(t, match("Top stories this week.", "Subject: Weekly news\n"))
+	assert.False(t, match("Nothing here", "Subject: Weekly digest\n"))
+
+	assert.Nil(t, SearchConfig{BodyContains: "x"}.bodyMatcher(), "the server searches the body")
+}
+
+func TestClientSideBodyValidation(t *testing.T) {
+	for name, yaml := range map[string]string{
+		"invalid regex": `
+name: r
+search:
+  body_regex: '('
+output:
+  fields: [uid]
+`,
+		"negative concurrency": `
+name: r
+search:
+  client_side_body: true
+  client_side_body_concurrency: -1
+output:
+  fields: [uid]
+`,
+		"operator": `
+name: r
+search:
+  client_side_body: true
+  operator: or
+  conditions:
+    - from: a@example.com
+    - from: b@example.com
+output:
+  fields: [uid]
+`,
+		"decrypt": `
+name: r
+search:
+  body_regex: secret
+decrypt:
+  gpg: true
+output:
+  fields: [uid]
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRuleString(yaml)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package dsl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Contact directions.
+const (
+	ContactInbound  = "inbound"  // the contact wrote to you
+	ContactOutbound = "outbound" // you wrote to the contact
+	ContactBoth     = "both"
+	ContactCopied   = "copied" // only seen as a co-recipient
+)
+
+// Contact aggregates the messages an address appears in.
+type Contact struct {
+	Address string
+	// Names are the display names seen for the address, in order of first
+	// appearance
+	Names     []string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// Messages counts the messages the address appears in
+	Messages int
+	// Received counts messages from the contact, Sent messages from you to
+	// the contact and Copied messages not from you with the contact among
+	// the recipients
+	Received int
+	Sent     int
+	Copied   int
+}
+
+// Direction summarizes how you correspond with the contact.
+func (c *Contact) Direction() string {
+	switch {
+	case c.Received > 0 && c.Sent > 0:
+		return ContactBoth
+	case c.Sent > 0:
+		return ContactOutbound
+	case c.Received > 0:
+		return ContactInbound
+	default:
+		return ContactCopied
+	}
+}
+
+// CollectContacts builds the address book of messages, most frequent
+// contacts first. me lists your own addresses: messages from them count
+// towards Sent for their recipients, and they are left out of the result.
+func CollectContacts(messages []*EmailMessage, me []string) []*Contact {
+	self := make(map[string]bool, len(me))
+	for _, addr := range me {
+		self[strings.ToLower(strings.TrimSpace(addr))] = true
+	}
+
+	contacts := make(map[string]*Contact)
+	for _, msg := range messages {
+		if msg.Envelope == nil {
+			continue
+		}
+		env := msg.Envelope
+
+		fromMe := false
+		for _, addr := range env.From {
+			if self[strings.ToLower(addr.Address)] {
+				fromMe = true
+			}
+		}
+
+		seen := make(map[string]bool)
+		note := func(addr EmailAddress, count func(*Contact)) {
+			key := strings.ToLower(addr.Address)
+			if self[key] || !validContactAddress(key) {
+				return
+			}
+			contact, ok := contacts[key]
+			if !ok {
+				contact = &Contact{Address: key}
+				contacts[key] = contact
+			}
+			if name := strings.TrimSpace(addr.Name); name != "" && !containsString(contact.Names, name) {
+				contact.Names = append(contact.Names, name)
+			}
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+			contact.Messages++
+			count(contact)
+			if !env.Date.IsZero() {
+				if contact.FirstSeen.IsZero() || env.Date.Before(contact.FirstSeen) {
+					contact.FirstSeen = env.Date
+				}
+				if env.Date.After(contact.LastSeen) {
+					contact.LastSeen = env.Date
+				}
+			}
+		}
+
+		for _, addr := range env.From {
+			note(addr, func(c *Contact) { c.Received++ })
+		}
+		for _, list := range [][]EmailAddress{env.To, env.Cc, env.Bcc} {
+			for _, addr := range list {
+				if fromMe {
+					note(addr, func(c *Contact) { c.Sent++ })
+				} else {
+					note(addr, func(c *Contact) { c.Copied++ })
+				}
+			}
+		}
+	}
+
+	ret := make([]*Contact, 0, len(contacts))
+	for _, contact := range contacts {
+		ret = append(ret, contact)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Messages != ret[j].Messages {
+			return ret[i].Messages > ret[j].Messages
+		}
+		if !ret[i].LastSeen.Equal(ret[j].LastSeen) {
+			return ret[i].LastSeen.After(ret[j].LastSeen)
+		}
+		return ret[i].Address < ret[j].Address
+	})
+	return ret
+}
+
+// validContactAddress skips group syntax and undisclosed recipients, which
+// the envelope reports without a mailbox or host.
+func validContactAddress(addr string) bool {
+	local, domain, ok := strings.Cut(addr, "@")
+	return ok && local != "" && domain != ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteVCards writes contacts as vCard 3.0 (RFC 2426) entries.
+func WriteVCards(w io.Writer, contacts []*Contact) error {
+	for _, contact := range contacts {
+		name := contact.Address
+		if len(contact.Names) > 0 {
+			name = contact.Names[0]
+		}
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			"FN:" + vCardEscape(name),
+			"N:;;;;",
+			"EMAIL;TYPE=INTERNET:" + vCardEscape(contact.Address),
+			"NOTE:" + vCardEscape(fmt.Sprintf("%d messages (%s), last seen %s",
+				contact.Messages, contact.Direction(), contact.LastSeen.Format("2006-01-02"))),
+			"END:VCARD",
+		}
+		if _, err := io.WriteString(w, strings.Join(lines, "\r\n")+"\r\n"); err != nil {
+			return fmt.Errorf("failed to write vCard: %w", err)
+		}
+	}
+	return nil
+}
+
+func vCardEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,67 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectContacts(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 9, 0, 0, 0, time.UTC) }
+	messages := []*EmailMessage{
+		{Envelope: &EmailEnvelope{
+			Date: day(1),
+			From: []EmailAddress{{Name: "Alice", Address: "Alice@Example.com"}},
+			To:   []EmailAddress{{Address: "me@example.com"}},
+			Cc:   []EmailAddress{{Name: "Bob", Address: "bob@example.com"}},
+		}},
+		{Envelope: &EmailEnvelope{
+			Date: day(3),
+			From: []EmailAddress{{Address: "me@example.com"}},
+			To:   []EmailAddress{{Name: "Alice Smith", Address: "alice@example.com"}, {Address: "undisclosed-recipients:@"}},
+		}},
+		{Envelope: &EmailEnvelope{
+			Date: day(2),
+			From: []EmailAddress{{Name: "Carol", Address: "carol@example.com"}},
+			To:   []EmailAddress{{Address: "carol@example.com"}},
+		}},
+	}
+
+	contacts := CollectContacts(messages, []string{"ME@example.com"})
+	require.Len(t, contacts, 3)
+
+	alice := contacts[0]
+	assert.Equal(t, "alice@example.com", alice.Address)
+	assert.Equal(t, []string{"Alice", "Alice Smith"}, alice.Names)
+	assert.Equal(t, 2, alice.Messages)
+	assert.Equal(t, 1, alice.Received)
+	assert.Equal(t, 1, alice.Sent)
+	assert.Equal(t, ContactBoth, alice.Direction())
+	assert.Equal(t, day(1), alice.FirstSeen)
+	assert.Equal(t, day(3), alice.LastSeen)
+
+	// Ties are broken by the most recent message
+	assert.Equal(t, "carol@example.com", contacts[1].Address)
+	assert.Equal(t, 1, contacts[1].Messages, "an address is counted once per message")
+	assert.Equal(t, ContactInbound, contacts[1].Direction())
+	assert.Equal(t, "bob@example.com", contacts[2].Address)
+	assert.Equal(t, ContactCopied, contacts[2].Direction())
+}
+
+func TestWriteVCards(t *testing.T) {
+	var b strings.Builder
+	err := WriteVCards(&b, []*Contact{{
+		Address:  "alice@example.com",
+		Names:    []string{"Smith, Alice"},
+		Messages: 2,
+		Received: 2,
+		LastSeen: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Smith\\, Alice\r\nN:;;;;\r\n"+
+		"EMAIL;TYPE=INTERNET:alice@example.com\r\n"+
+		"NOTE:2 messages (inbound)\\, last seen 2025-03-01\r\nEND:VCARD\r\n", b.String())
+}
@@ -0,0 +1,260 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// ExportFormatJSONL exports all messages into a single corpus.jsonl file, one
+// CorpusRecord per line.
+const ExportFormatJSONL = "jsonl"
+
+// CorpusFile is the file a jsonl export writes, in the export directory or
+// archive.
+const CorpusFile = "corpus.jsonl"
+
+// CorpusRecord is a message of a JSONL corpus. Raw is the full message, so
+// the corpus can be read back like an mbox; the other fields are the
+// structure tools need without parsing it. Addresses are formatted like
+// mailgen templates ("Name <address>, address").
+type CorpusRecord struct {
+	UID          uint32         `json:"uid"`
+	Flags        []string       `json:"flags,omitempty"`
+	InternalDate time.Time      `json:"internal_date,omitempty"`
+	Subject      string         `json:"subject"`
+	From         string         `json:"from,omitempty"`
+	To           string         `json:"to,omitempty"`
+	Cc           string         `json:"cc,omitempty"`
+	Date         string         `json:"date,omitempty"`
+	MessageID    string         `json:"message_id,omitempty"`
+	InReplyTo    string         `json:"in_reply_to,omitempty"`
+	References   string         `json:"references,omitempty"`
+	Headers      []CorpusHeader `json:"headers"`
+	// Body is the plain text body, or the HTML body converted to Markdown
+	Body  string       `json:"body"`
+	Parts []CorpusPart `json:"parts"`
+	Raw   string       `json:"raw"`
+}
+
+// CorpusHeader is a header field, in message order.
+type CorpusHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CorpusPart describes a MIME part. Path is the IMAP part specifier ("" for
+// the message itself, "1.2" for the second part of the first part).
+type CorpusPart struct {
+	Path        string `json:"path"`
+	Type        string `json:"type"`
+	Charset     string `json:"charset,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	// Size is the decoded size, 0 for multipart parts
+	Size int `json:"size"`
+	// SHA256 is set for attachments moved to the blob store
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// newCorpusRecord builds the corpus record of raw, the exported content of
+// msg.
+func newCorpusRecord(raw []byte, msg *EmailMessage, internalDate time.Time) (*CorpusRecord, error) {
+	record := &CorpusRecord{
+		UID:          msg.UID,
+		Flags:        msg.Flags,
+		InternalDate: internalDate,
+		Raw:          string(raw),
+	}
+
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse header of message %d: %w", msg.UID, err)
+	}
+	fields := header.Fields()
+	for fields.Next() {
+		record.Headers = append(record.Headers, CorpusHeader{Name: fields.Key(), Value: fields.Value()})
+	}
+
+	h := mail.Header{Header: message.Header{Header: header}}
+	record.Subject, _ = h.Subject()
+	record.From = corpusAddresses(h, "From")
+	record.To = corpusAddresses(h, "To")
+	record.Cc = corpusAddresses(h, "Cc")
+	if date, err := h.Date(); err == nil && !date.IsZero() {
+		record.Date = date.Format(time.RFC3339)
+	}
+	if id, err := h.MessageID(); err == nil && id != "" {
+		record.MessageID = "<" + id + ">"
+	}
+	record.InReplyTo = corpusMsgIDs(h, "In-Reply-To")
+	record.References = corpusMsgIDs(h, "References")
+
+	entity, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, fmt.Errorf("failed to parse message %d: %w", msg.UID, err)
+	}
+	var plain, htmlBody []string
+	err = entity.Walk(func(path []int, part *message.Entity, err error) error {
+		if err != nil {
+			if message.IsUnknownCharset(err) || message.IsUnknownEncoding(err) {
+				return nil
+			}
+			return err
+		}
+		mediaType, params, _ := part.Header.ContentType()
+		disposition, dispositionParams, _ := part.Header.ContentDisposition()
+		p := CorpusPart{
+			Path:        corpusPartPath(path),
+			Type:        strings.ToLower(mediaType),
+			Charset:     params["charset"],
+			Disposition: strings.ToLower(disposition),
+			Filename:    dispositionParams["filename"],
+			Encoding:    strings.ToLower(part.Header.Get("Content-Transfer-Encoding")),
+			SHA256:      strings.TrimSpace(part.Header.Get(BlobHeader)),
+		}
+		if p.Filename == "" {
+			p.Filename = params["name"]
+		}
+		if p.Type == "" {
+			p.Type = "text/plain"
+		}
+		if !strings.HasPrefix(p.Type, "multipart/") {
+			content, err := io.ReadAll(part.Body)
+			if err != nil {
+				return err
+			}
+			p.Size = len(content)
+			if p.Disposition != "attachment" {
+				switch p.Type {
+				case "text/plain":
+					plain = append(plain, string(content))
+				case "text/html":
+					htmlBody = append(htmlBody, string(content))
+				}
+			}
+		}
+		record.Parts = append(record.Parts, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parts of message %d: %w", msg.UID, err)
+	}
+
+	record.Body = strings.TrimSpace(strings.ReplaceAll(strings.Join(plain, "\n\n"), "\r\n", "\n"))
+	if record.Body == "" && len(htmlBody) > 0 {
+		record.Body = htmlToMarkdown(strings.Join(htmlBody, "\n"))
+	}
+	return record, nil
+}
+
+// corpusLine returns the corpus record of raw as a JSON line.
+func corpusLine(raw []byte, msg *EmailMessage, internalDate time.Time) ([]byte, error) {
+	record, err := newCorpusRecord(raw, msg, internalDate)
+	if err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+func corpusPartPath(path []int) string {
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = strconv.Itoa(n + 1)
+	}
+	return strings.Join(parts, ".")
+}
+
+func corpusAddresses(h mail.Header, key string) string {
+	addrs, err := h.AddressList(key)
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if addr.Name != "" {
+			parts[i] = fmt.Sprintf("%s <%s>", addr.Name, addr.Address)
+		} else {
+			parts[i] = addr.Address
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func corpusMsgIDs(h mail.Header, key string) string {
+	ids, err := h.MsgIDList(key)
+	if err != nil {
+		return ""
+	}
+	for i, id := range ids {
+		ids[i] = "<" + id + ">"
+	}
+	return strings.Join(ids, " ")
+}
+
+// ReadCorpus reads the records of a JSONL corpus.
+func ReadCorpus(path string) ([]*CorpusRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open corpus: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var records []*CorpusRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record := &CorpusRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read corpus %s: %w", path, err)
+	}
+	return records, nil
+}
+
+// readCorpusMessages reads a JSONL corpus as local messages, parsed from the
+// raw content of each record.
+func readCorpusMessages(path string) ([]*LocalMessage, error) {
+	records, err := ReadCorpus(path)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*LocalMessage, 0, len(records))
+	for i, record := range records {
+		seqNum, err := checkedUint32FromInt(i+1, "seq_num")
+		if err != nil {
+			return nil, err
+		}
+		msg, err := ParseLocalMessage([]byte(record.Raw), seqNum, record.Flags, record.InternalDate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		msg.Source = fmt.Sprintf("%s#%d", path, seqNum)
+		ret = append(ret, msg)
+	}
+	return ret, nil
+}
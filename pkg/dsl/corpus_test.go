@@ -0,0 +1,85 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const corpusTestMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com, Carol <carol@example.com>\r\n" +
+	"Subject: Build {{ broken }}\r\n" +
+	"Date: Mon, 02 Dec 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <build-2@example.com>\r\n" +
+	"In-Reply-To: <build-1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=sep\r\n" +
+	"\r\n" +
+	"--sep\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"The build is red.\r\n" +
+	"--sep\r\n" +
+	"Content-Type: text/plain; name=log.txt\r\n" +
+	"Content-Disposition: attachment; filename=log.txt\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"ZmFpbGVk\r\n" +
+	"--sep--\r\n"
+
+func TestNewCorpusRecord(t *testing.T) {
+	internalDate := time.Date(2024, 12, 2, 10, 0, 5, 0, time.UTC)
+	record, err := newCorpusRecord([]byte(corpusTestMessage), &EmailMessage{UID: 7, Flags: []string{"\\Seen"}}, internalDate)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(7), record.UID)
+	assert.Equal(t, internalDate, record.InternalDate)
+	assert.Equal(t, "Build {{ broken }}", record.Subject)
+	assert.Equal(t, "Alice <alice@example.com>", record.From)
+	assert.Equal(t, "bob@example.com, Carol <carol@example.com>", record.To)
+	assert.Equal(t, "2024-12-02T10:00:00Z", record.Date)
+	assert.Equal(t, "<build-2@example.com>", record.MessageID)
+	assert.Equal(t, "<build-1@example.com>", record.InReplyTo)
+	assert.Equal(t, "The build is red.", record.Body, "attachments are not part of the body")
+	assert.Equal(t, corpusTestMessage, record.Raw)
+
+	require.Len(t, record.Headers, 8)
+	assert.Equal(t, CorpusHeader{Name: "From", Value: "Alice <alice@example.com>"}, record.Headers[0])
+	assert.Equal(t, "Content-Type", record.Headers[7].Name)
+
+	assert.Equal(t, []CorpusPart{
+		{Path: "", Type: "multipart/mixed"},
+		{Path: "1", Type: "text/plain", Charset: "utf-8", Size: 17},
+		{Path: "2", Type: "text/plain", Disposition: "attachment", Filename: "log.txt", Encoding: "base64", Size: 6},
+	}, record.Parts)
+}
+
+func TestReadLocalMessagesCorpus(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CorpusFile)
+	var data []byte
+	for _, uid := range []uint32{3, 4} {
+		line, err := corpusLine([]byte(corpusTestMessage), &EmailMessage{UID: uid, Flags: []string{"\\Flagged"}}, time.Date(2024, 12, 2, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		data = append(data, line...)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0600))
+
+	records, err := ReadCorpus(path)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, uint32(4), records[1].UID)
+
+	messages, err := ReadLocalMessages(path)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	msg := messages[1].Message
+	assert.Equal(t, uint32(2), msg.UID, "messages are numbered in corpus order")
+	assert.Equal(t, "Build {{ broken }}", msg.Envelope.Subject)
+	assert.Equal(t, []string{"\\Flagged"}, msg.Flags)
+	assert.Equal(t, 2, messages[1].InternalDate.Day())
+	assert.Equal(t, path+"#2", messages[1].Source)
+}
@@ -0,0 +1,323 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// cssSelector is a parsed CSS selector list. It supports the subset useful
+// to pick values out of HTML mail: type and universal selectors, #id,
+// .class, attribute selectors ([a], [a=v], [a~=v], [a^=v], [a$=v], [a*=v])
+// and the descendant and child combinators. Pseudo-classes are rejected.
+type cssSelector []cssComplex
+
+// cssComplex is a chain of compound selectors; combinators[i] (' ' or '>')
+// joins compounds[i] and compounds[i+1].
+type cssComplex struct {
+	compounds   []cssCompound
+	combinators []byte
+}
+
+type cssCompound struct {
+	tag   string
+	conds []cssAttrCond
+}
+
+type cssAttrCond struct {
+	name, op, value string
+}
+
+func parseCSSSelector(s string) (cssSelector, error) {
+	p := &cssParser{s: s}
+	var selector cssSelector
+	for {
+		chain, err := p.chain()
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, chain)
+		if p.pos >= len(p.s) {
+			return selector, nil
+		}
+		// chain stops at the end or at a comma
+		p.pos++
+	}
+}
+
+type cssParser struct {
+	s   string
+	pos int
+}
+
+func (p *cssParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid selector %q at offset %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n\f", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *cssParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c == '-' || c == '_' || c >= 0x80 ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *cssParser) chain() (cssComplex, error) {
+	var chain cssComplex
+	p.skipSpace()
+	for {
+		compound, err := p.compound()
+		if err != nil {
+			return chain, err
+		}
+		chain.compounds = append(chain.compounds, compound)
+		spaced := p.skipSpace()
+		if p.pos >= len(p.s) || p.s[p.pos] == ',' {
+			return chain, nil
+		}
+		combinator := byte(' ')
+		switch {
+		case p.s[p.pos] == '>':
+			combinator = '>'
+			p.pos++
+			p.skipSpace()
+		case p.s[p.pos] == '+' || p.s[p.pos] == '~':
+			return chain, p.errorf("sibling combinators are not supported")
+		case !spaced:
+			return chain, p.errorf("unexpected %q", p.s[p.pos])
+		}
+		chain.combinators = append(chain.combinators, combinator)
+	}
+}
+
+func (p *cssParser) compound() (cssCompound, error) {
+	var compound cssCompound
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '*' {
+		p.pos++
+	} else {
+		compound.tag = strings.ToLower(p.ident())
+	}
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case '#', '.':
+			kind := p.s[p.pos]
+			p.pos++
+			name := p.ident()
+			if name == "" {
+				return compound, p.errorf("expected a name after %q", kind)
+			}
+			if kind == '#' {
+				compound.conds = append(compound.conds, cssAttrCond{name: "id", op: "=", value: name})
+			} else {
+				compound.conds = append(compound.conds, cssAttrCond{name: "class", op: "~=", value: name})
+			}
+		case '[':
+			p.pos++
+			cond, err := p.attr()
+			if err != nil {
+				return compound, err
+			}
+			compound.conds = append(compound.conds, cond)
+		case ':':
+			return compound, p.errorf("pseudo-classes are not supported")
+		default:
+			if p.pos == start {
+				return compound, p.errorf("expected a selector")
+			}
+			return compound, nil
+		}
+	}
+	if p.pos == start {
+		return compound, p.errorf("expected a selector")
+	}
+	return compound, nil
+}
+
+// attr parses an attribute selector after its opening bracket.
+func (p *cssParser) attr() (cssAttrCond, error) {
+	var cond cssAttrCond
+	p.skipSpace()
+	if cond.name = strings.ToLower(p.ident()); cond.name == "" {
+		return cond, p.errorf("expected an attribute name")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return cond, p.errorf("unterminated attribute selector")
+	}
+	if p.s[p.pos] == ']' {
+		p.pos++
+		return cond, nil
+	}
+	switch {
+	case p.s[p.pos] == '=':
+		cond.op = "="
+		p.pos++
+	case p.pos+1 < len(p.s) && p.s[p.pos+1] == '=' && strings.IndexByte("~^$*", p.s[p.pos]) >= 0:
+		cond.op = p.s[p.pos : p.pos+2]
+		p.pos += 2
+	default:
+		return cond, p.errorf("unexpected %q in attribute selector", p.s[p.pos])
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) && (p.s[p.pos] == '"' || p.s[p.pos] == '\'') {
+		quote := p.s[p.pos]
+		end := strings.IndexByte(p.s[p.pos+1:], quote)
+		if end < 0 {
+			return cond, p.errorf("unterminated string")
+		}
+		cond.value = p.s[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+	} else if cond.value = p.ident(); cond.value == "" {
+		return cond, p.errorf("expected an attribute value")
+	}
+	p.skipSpace()
+	if p.pos >= len(p.s) || p.s[p.pos] != ']' {
+		return cond, p.errorf("unterminated attribute selector")
+	}
+	p.pos++
+	return cond, nil
+}
+
+// selectAll returns the elements below root matching s, in document order.
+func (s cssSelector) selectAll(root *html.Node) []*html.Node {
+	var matches []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, chain := range s {
+				if chain.match(n, len(chain.compounds)-1) {
+					matches = append(matches, n)
+					break
+				}
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(root)
+	return matches
+}
+
+// match reports whether n matches the chain up to compounds[i].
+func (c cssComplex) match(n *html.Node, i int) bool {
+	if !c.compounds[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for parent := n.Parent; parent != nil; parent = parent.Parent {
+		if parent.Type != html.ElementNode {
+			continue
+		}
+		if c.match(parent, i-1) {
+			return true
+		}
+		if c.combinators[i-1] == '>' {
+			return false
+		}
+	}
+	return false
+}
+
+func (c cssCompound) match(n *html.Node) bool {
+	if c.tag != "" && n.Data != c.tag {
+		return false
+	}
+	for _, cond := range c.conds {
+		if !cond.match(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c cssAttrCond) match(n *html.Node) bool {
+	value, ok := htmlNodeAttr(n, c.name)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "":
+		return true
+	case "=":
+		return value == c.value
+	case "~=":
+		for _, word := range strings.Fields(value) {
+			if word == c.value {
+				return true
+			}
+		}
+		return false
+	case "^=":
+		return strings.HasPrefix(value, c.value)
+	case "$=":
+		return strings.HasSuffix(value, c.value)
+	case "*=":
+		return strings.Contains(value, c.value)
+	}
+	return false
+}
+
+// inlineElements are the elements htmlNodeText does not separate from the
+// text around them.
+var inlineElements = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "cite": true,
+	"code": true, "em": true, "font": true, "i": true, "kbd": true, "mark": true,
+	"q": true, "s": true, "small": true, "span": true, "strong": true,
+	"sub": true, "sup": true, "u": true,
+}
+
+// htmlNodeText returns the text below n with whitespace collapsed. Block
+// elements and table cells are separated by a space.
+func htmlNodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "script" || n.Data == "style" {
+				return
+			}
+			if !inlineElements[n.Data] {
+				sb.WriteByte(' ')
+				defer sb.WriteByte(' ')
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// htmlNodeAttr returns the value of the attribute name of n.
+func htmlNodeAttr(n *html.Node, name string) (string, bool) {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == name {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
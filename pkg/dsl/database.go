@@ -0,0 +1,327 @@
+package dsl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog"
+)
+
+// Database drivers of the database action. The programs running rules link
+// the drivers; smailnail links all three.
+const (
+	DatabasePostgres = "postgres"
+	DatabaseMySQL    = "mysql"
+	DatabaseSQLite   = "sqlite3"
+)
+
+// DefaultDatabaseKeyColumn is the column holding the Message-ID rows are
+// upserted on.
+const DefaultDatabaseKeyColumn = "message_id"
+
+const defaultDatabaseTimeout = time.Minute
+
+// sqlIdentifier matches the table and column names the database action
+// accepts, which are used unquoted. Tables may be schema-qualified.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// DatabaseConfig upserts a row per matched message into a MySQL, Postgres
+// or SQLite table, keyed on the Message-ID, so a scheduled rule maintains a
+// table of, say, all invoices received. Columns are filled from the rule's
+// output fields.
+type DatabaseConfig struct {
+	// Driver is postgres, mysql or sqlite3
+	Driver string `yaml:"driver"`
+	// DSN is the data source name; DSNEnv names an environment variable
+	// holding it instead, to keep passwords out of rule files
+	DSN    string `yaml:"dsn,omitempty"`
+	DSNEnv string `yaml:"dsn_env,omitempty"`
+	Table  string `yaml:"table"`
+	// KeyColumn holds the Message-ID, DefaultDatabaseKeyColumn by default.
+	// It needs a primary key or unique index.
+	KeyColumn string `yaml:"key_column,omitempty"`
+	// Columns maps column names to output field names (the field's "as"
+	// name when set)
+	Columns map[string]string `yaml:"columns"`
+	// CreateTable creates the table if it does not exist, with column types
+	// guessed from the first message
+	CreateTable bool `yaml:"create_table,omitempty"`
+	// Timeout bounds each run of the action (default: 1m)
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// Validate checks if the database config is valid
+func (c *DatabaseConfig) Validate() error {
+	switch c.Driver {
+	case DatabasePostgres, DatabaseMySQL, DatabaseSQLite:
+	default:
+		return fmt.Errorf("invalid driver: %q (must be 'postgres', 'mysql' or 'sqlite3')", c.Driver)
+	}
+	if (c.DSN == "") == (c.DSNEnv == "") {
+		return fmt.Errorf("exactly one of dsn and dsn_env is required")
+	}
+	for _, part := range strings.Split(c.Table, ".") {
+		if !sqlIdentifier.MatchString(part) {
+			return fmt.Errorf("invalid table name %q", c.Table)
+		}
+	}
+	if c.KeyColumn != "" && !sqlIdentifier.MatchString(c.KeyColumn) {
+		return fmt.Errorf("invalid key_column %q", c.KeyColumn)
+	}
+	if len(c.Columns) == 0 {
+		return fmt.Errorf("at least one column is required")
+	}
+	for column, field := range c.Columns {
+		if !sqlIdentifier.MatchString(column) {
+			return fmt.Errorf("invalid column name %q", column)
+		}
+		if strings.EqualFold(column, c.keyColumn()) {
+			return fmt.Errorf("column %s is the key column, which holds the Message-ID", column)
+		}
+		if field == "" {
+			return fmt.Errorf("column %s has no output field", column)
+		}
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	return nil
+}
+
+// checkFields reports columns mapped to fields the output does not have.
+func (c *DatabaseConfig) checkFields(output OutputConfig) error {
+	names := map[string]bool{}
+	for _, f := range output.Fields {
+		if field, ok := f.(Field); ok {
+			names[field.OutputName()] = true
+		}
+	}
+	for _, column := range c.columns() {
+		if !names[c.Columns[column]] {
+			return fmt.Errorf("column %s maps to %s, which is not an output field", column, c.Columns[column])
+		}
+	}
+	return nil
+}
+
+func (c *DatabaseConfig) keyColumn() string {
+	if c.KeyColumn != "" {
+		return c.KeyColumn
+	}
+	return DefaultDatabaseKeyColumn
+}
+
+// columns returns the mapped columns in a stable order.
+func (c *DatabaseConfig) columns() []string {
+	columns := make([]string, 0, len(c.Columns))
+	for column := range c.Columns {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func (c *DatabaseConfig) sqlDriver() string {
+	if c.Driver == DatabasePostgres {
+		return "pgx"
+	}
+	return c.Driver
+}
+
+// upsertQuery returns the statement inserting a row or updating the row
+// with the same key.
+func (c *DatabaseConfig) upsertQuery() string {
+	key, columns := c.keyColumn(), c.columns()
+	all := append([]string{key}, columns...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", c.Table, strings.Join(all, ", "), placeholders)
+
+	updates := make([]string, len(columns))
+	for i, column := range columns {
+		if c.Driver == DatabaseMySQL {
+			updates[i] = fmt.Sprintf("%s = VALUES(%s)", column, column)
+		} else {
+			updates[i] = fmt.Sprintf("%s = excluded.%s", column, column)
+		}
+	}
+	if c.Driver == DatabaseMySQL {
+		return query + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+	return query + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", key, strings.Join(updates, ", "))
+}
+
+// createTableQuery returns the statement creating the table, with column
+// types guessed from the values of a row.
+func (c *DatabaseConfig) createTableQuery(values map[string]interface{}) string {
+	keyType := "TEXT"
+	if c.Driver == DatabaseMySQL {
+		// MySQL cannot index TEXT without a prefix length
+		keyType = "VARCHAR(255)"
+	}
+	definitions := []string{fmt.Sprintf("%s %s PRIMARY KEY", c.keyColumn(), keyType)}
+	for _, column := range c.columns() {
+		columnType := "TEXT"
+		switch values[column].(type) {
+		case int64:
+			columnType = "BIGINT"
+		case float64:
+			columnType = "DOUBLE PRECISION"
+		case bool:
+			columnType = "BOOLEAN"
+		}
+		definitions = append(definitions, column+" "+columnType)
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", c.Table, strings.Join(definitions, ", "))
+}
+
+// databaseRow returns the column values of msg from its output fields.
+func (c *DatabaseConfig) databaseRow(msg *EmailMessage, output OutputConfig) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, member := range jsonOutput(msg, output) {
+		fields[member.Key] = member.Value
+	}
+	row := make(map[string]interface{}, len(c.Columns))
+	for column, field := range c.Columns {
+		row[column] = databaseValue(fields[field])
+	}
+	return row
+}
+
+// databaseValue converts an output value to a column value: numbers,
+// booleans and strings are kept, address lists and string lists are joined
+// with commas, anything else is stored as JSON.
+func databaseValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, bool, int64, float64:
+		return v
+	case int:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case []string:
+		return strings.Join(v, ", ")
+	case []EmailAddress:
+		addrs := make([]string, len(v))
+		for i, addr := range v {
+			addrs[i] = formatEmailAddress(addr)
+		}
+		return strings.Join(addrs, ", ")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// databaseKey keys rows by Message-ID, or by mailbox, UIDVALIDITY and UID
+// for messages without one, like index_elasticsearch.
+func databaseKey(msg *EmailMessage, mailbox string, uidValidity uint32) string {
+	if msg.Envelope != nil && msg.Envelope.MessageID != "" {
+		return "<" + strings.Trim(msg.Envelope.MessageID, "<>") + ">"
+	}
+	return fmt.Sprintf("%s:%d:%d", mailbox, uidValidity, msg.UID)
+}
+
+// executeDatabase upserts the rows of messages in one transaction.
+func executeDatabase(client *imapclient.Client, messages []*EmailMessage, config *DatabaseConfig, rule *Rule) error {
+	logger := rule.Logger()
+	if len(messages) == 0 {
+		return nil
+	}
+	dsn := config.DSN
+	if config.DSNEnv != "" {
+		if dsn = os.Getenv(config.DSNEnv); dsn == "" {
+			return fmt.Errorf("environment variable %s is not set", config.DSNEnv)
+		}
+	}
+	var output OutputConfig
+	if rule != nil {
+		output = rule.Output
+	}
+	mailbox := ""
+	var uidValidity uint32
+	if client != nil {
+		mailbox = selectedMailbox(client)
+		uidValidity = selectedUIDValidity(client)
+	}
+
+	timeout := defaultDatabaseTimeout
+	if d, err := time.ParseDuration(config.Timeout); err == nil && config.Timeout != "" {
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	db, err := sqlx.Open(config.sqlDriver(), dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open %s database: %w", config.Driver, err)
+	}
+	defer func() { _ = db.Close() }()
+	return upsertDatabaseRows(ctx, db, messages, config, output, func(msg *EmailMessage) string {
+		return databaseKey(msg, mailbox, uidValidity)
+	}, logger)
+}
+
+// upsertDatabaseRows writes the rows of messages in one transaction, so a
+// failing run leaves the table as it was.
+func upsertDatabaseRows(
+	ctx context.Context,
+	db *sqlx.DB,
+	messages []*EmailMessage,
+	config *DatabaseConfig,
+	output OutputConfig,
+	key func(*EmailMessage) string,
+	logger zerolog.Logger,
+) error {
+	rows := make([]map[string]interface{}, len(messages))
+	for i, msg := range messages {
+		rows[i] = config.databaseRow(msg, output)
+	}
+
+	if config.CreateTable {
+		if _, err := db.ExecContext(ctx, config.createTableQuery(rows[0])); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", config.Table, err)
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	stmt, err := tx.PreparexContext(ctx, db.Rebind(config.upsertQuery()))
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("failed to prepare upsert into %s: %w", config.Table, err)
+	}
+	columns := config.columns()
+	for i, msg := range messages {
+		args := []interface{}{key(msg)}
+		for _, column := range columns {
+			args = append(args, rows[i][column])
+		}
+		if _, err := stmt.ExecContext(ctx, args...); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("failed to upsert message %d into %s: %w", msg.UID, config.Table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upserts into %s: %w", config.Table, err)
+	}
+	logger.Debug().Str("table", config.Table).Int("rows", len(messages)).Msg("Upserted rows")
+	return nil
+}
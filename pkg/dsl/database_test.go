@@ -0,0 +1,108 @@
+package dsl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseAction(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: invoices
+search:
+  subject_contains: invoice
+output:
+  fields:
+    - uid
+    - subject
+    - from
+    - name: size
+      as: bytes
+actions:
+  database:
+    driver: sqlite3
+    dsn_env: INVOICES_DSN
+    table: invoices
+    create_table: true
+    columns:
+      title: subject
+      sender: from
+      bytes: bytes
+`)
+	require.NoError(t, err)
+	config := rule.Actions.Database
+
+	assert.Equal(t, "INSERT INTO invoices (message_id, bytes, sender, title) VALUES (?, ?, ?, ?)"+
+		" ON CONFLICT (message_id) DO UPDATE SET bytes = excluded.bytes, sender = excluded.sender, title = excluded.title",
+		config.upsertQuery())
+
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	db.SetMaxOpenConns(1)
+
+	message := func(uid uint32, subject string) *EmailMessage {
+		return &EmailMessage{UID: uid, Size: 100, Envelope: &EmailEnvelope{
+			Subject:   subject,
+			MessageID: "invoice@example.com",
+			From:      []EmailAddress{{Name: "Billing", Address: "billing@example.com"}},
+			Date:      time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+		}}
+	}
+	key := func(msg *EmailMessage) string { return databaseKey(msg, "INBOX", 1) }
+	ctx := context.Background()
+	require.NoError(t, upsertDatabaseRows(ctx, db, []*EmailMessage{message(1, "Invoice")}, config, rule.Output, key, zerolog.Nop()))
+	require.NoError(t, upsertDatabaseRows(ctx, db, []*EmailMessage{message(1, "Invoice (corrected)")}, config, rule.Output, key, zerolog.Nop()))
+
+	var rows []struct {
+		MessageID string `db:"message_id"`
+		Title     string `db:"title"`
+		Sender    string `db:"sender"`
+		Bytes     int64  `db:"bytes"`
+	}
+	require.NoError(t, db.Select(&rows, `SELECT message_id, title, sender, bytes FROM invoices`))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "<invoice@example.com>", rows[0].MessageID)
+	assert.Equal(t, "Invoice (corrected)", rows[0].Title)
+	assert.Equal(t, "Billing <billing@example.com>", rows[0].Sender)
+	assert.Equal(t, int64(100), rows[0].Bytes)
+}
+
+func TestDatabaseConfigValidate(t *testing.T) {
+	_, err := ParseRuleString(`
+name: invoices
+search:
+  subject_contains: invoice
+output:
+  fields: [uid, subject]
+actions:
+  database:
+    driver: postgres
+    dsn: postgres://localhost/mail
+    table: invoices
+    columns:
+      sender: from
+`)
+	assert.ErrorContains(t, err, "not an output field")
+
+	for _, config := range []DatabaseConfig{
+		{Driver: "oracle", DSN: "x", Table: "t", Columns: map[string]string{"a": "subject"}},
+		{Driver: DatabaseMySQL, Table: "t", Columns: map[string]string{"a": "subject"}},
+		{Driver: DatabaseMySQL, DSN: "x", Table: "t; DROP TABLE users", Columns: map[string]string{"a": "subject"}},
+		{Driver: DatabaseMySQL, DSN: "x", Table: "t", Columns: map[string]string{"message_id": "subject"}},
+		{Driver: DatabaseMySQL, DSN: "x", Table: "t"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+	mysql := DatabaseConfig{Driver: DatabaseMySQL, DSN: "x", Table: "mail.t", Columns: map[string]string{"a": "subject"}}
+	require.NoError(t, mysql.Validate())
+	assert.Equal(t, "INSERT INTO mail.t (message_id, a) VALUES (?, ?) ON DUPLICATE KEY UPDATE a = VALUES(a)", mysql.upsertQuery())
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS mail.t (message_id VARCHAR(255) PRIMARY KEY, a BIGINT)",
+		mysql.createTableQuery(map[string]interface{}{"a": int64(1)}))
+}
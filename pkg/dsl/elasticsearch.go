@@ -0,0 +1,419 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message/mail"
+)
+
+const (
+	// DefaultElasticsearchIndex is the index template of the
+	// index_elasticsearch action.
+	DefaultElasticsearchIndex = "smailnail-{{ .Rule.Name }}"
+	// DefaultElasticsearchBulkSize is the number of documents per bulk
+	// request.
+	DefaultElasticsearchBulkSize = 500
+
+	defaultElasticsearchTimeout = 30 * time.Second
+)
+
+// ElasticsearchConfig indexes the matched messages as JSON documents in
+// Elasticsearch or OpenSearch through the bulk API, e.g. for Kibana
+// dashboards. Documents are keyed by Message-ID, so indexing a message again
+// updates its document.
+type ElasticsearchConfig struct {
+	// Endpoint is the cluster URL, e.g. https://localhost:9200
+	Endpoint string `yaml:"endpoint"`
+	// Index is a template rendered for each message (see TemplateContext),
+	// lowercased; it defaults to DefaultElasticsearchIndex. Time-based
+	// indices like mail-{{ dateFormat "2006.01" .Date }} need the envelope
+	// field.
+	Index string `yaml:"index,omitempty"`
+	// Username and the password in PasswordEnv use basic authentication
+	Username    string `yaml:"username,omitempty"`
+	PasswordEnv string `yaml:"password_env,omitempty"`
+	// APIKeyEnv names the environment variable holding an API key, the
+	// base64 "id:key" encoding Elasticsearch returns
+	APIKeyEnv string `yaml:"api_key_env,omitempty"`
+	// BulkSize defaults to DefaultElasticsearchBulkSize
+	BulkSize int `yaml:"bulk_size,omitempty"`
+	// BodyChars truncates the indexed bodies to that many characters; 0
+	// indexes whole bodies
+	BodyChars int `yaml:"body_chars,omitempty"`
+	// Timeout bounds each bulk request (default: 30s)
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// Validate checks if the index_elasticsearch config is valid
+func (c *ElasticsearchConfig) Validate() error {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an http or https URL, got %q", c.Endpoint)
+	}
+	if c.Index != "" {
+		if err := ValidateTemplate(c.Index); err != nil {
+			return fmt.Errorf("invalid index: %w", err)
+		}
+	}
+	if c.APIKeyEnv != "" && (c.Username != "" || c.PasswordEnv != "") {
+		return fmt.Errorf("api_key_env cannot be combined with username and password_env")
+	}
+	if c.PasswordEnv != "" && c.Username == "" {
+		return fmt.Errorf("password_env requires username")
+	}
+	if c.BulkSize < 0 {
+		return fmt.Errorf("bulk_size cannot be negative")
+	}
+	if c.BodyChars < 0 {
+		return fmt.Errorf("body_chars cannot be negative")
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func (c *ElasticsearchConfig) bulkSize() int {
+	if c.BulkSize > 0 {
+		return c.BulkSize
+	}
+	return DefaultElasticsearchBulkSize
+}
+
+// ElasticsearchDocument is the document indexed for a message.
+type ElasticsearchDocument struct {
+	UID          uint32                    `json:"uid"`
+	Mailbox      string                    `json:"mailbox"`
+	Rule         string                    `json:"rule,omitempty"`
+	Label        string                    `json:"label,omitempty"`
+	MessageID    string                    `json:"message_id,omitempty"`
+	Subject      string                    `json:"subject"`
+	From         string                    `json:"from,omitempty"`
+	FromAddress  string                    `json:"from_address,omitempty"`
+	To           []string                  `json:"to,omitempty"`
+	Cc           []string                  `json:"cc,omitempty"`
+	Date         *time.Time                `json:"date,omitempty"`
+	InternalDate *time.Time                `json:"internal_date,omitempty"`
+	Flags        []string                  `json:"flags,omitempty"`
+	InReplyTo    string                    `json:"in_reply_to,omitempty"`
+	References   []string                  `json:"references,omitempty"`
+	Size         int                       `json:"size"`
+	Body         string                    `json:"body,omitempty"`
+	Attachments  []ElasticsearchAttachment `json:"attachments,omitempty"`
+}
+
+// ElasticsearchAttachment describes an attachment of an indexed message.
+type ElasticsearchAttachment struct {
+	Filename string `json:"filename,omitempty"`
+	Type     string `json:"type"`
+	Size     int    `json:"size"`
+}
+
+// newElasticsearchDocument builds the document of the raw message msg.
+func newElasticsearchDocument(raw []byte, msg *EmailMessage, internalDate time.Time, mailbox string, bodyChars int) (*ElasticsearchDocument, error) {
+	record, err := newCorpusRecord(raw, msg, internalDate)
+	if err != nil {
+		return nil, err
+	}
+	doc := &ElasticsearchDocument{
+		UID:        record.UID,
+		Mailbox:    mailbox,
+		Label:      msg.Label,
+		MessageID:  record.MessageID,
+		Subject:    record.Subject,
+		From:       record.From,
+		Flags:      record.Flags,
+		InReplyTo:  record.InReplyTo,
+		References: strings.Fields(record.References),
+		Size:       len(raw),
+		Body:       record.Body,
+	}
+
+	var header mail.Header
+	for _, h := range record.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	if from, err := header.AddressList("From"); err == nil && len(from) > 0 {
+		doc.FromAddress = from[0].Address
+	}
+	for _, key := range []string{"To", "Cc"} {
+		addrs, err := header.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if key == "To" {
+				doc.To = append(doc.To, addr.Address)
+			} else {
+				doc.Cc = append(doc.Cc, addr.Address)
+			}
+		}
+	}
+	if date, err := time.Parse(time.RFC3339, record.Date); err == nil {
+		doc.Date = &date
+	}
+	if !record.InternalDate.IsZero() {
+		doc.InternalDate = &record.InternalDate
+	}
+	if bodyChars > 0 {
+		if runes := []rune(doc.Body); len(runes) > bodyChars {
+			doc.Body = string(runes[:bodyChars])
+		}
+	}
+	for _, part := range record.Parts {
+		if part.Disposition == "attachment" || (part.Filename != "" && !strings.HasPrefix(part.Type, "multipart/")) {
+			doc.Attachments = append(doc.Attachments, ElasticsearchAttachment{Filename: part.Filename, Type: part.Type, Size: part.Size})
+		}
+	}
+	return doc, nil
+}
+
+// elasticsearchDocumentID keys documents by Message-ID, so copies of a
+// message in several mailboxes share one document. Messages without one are
+// keyed by their mailbox, UIDVALIDITY and UID.
+func elasticsearchDocumentID(doc *ElasticsearchDocument, uidValidity uint32) string {
+	if doc.MessageID != "" {
+		return doc.MessageID
+	}
+	return fmt.Sprintf("%s:%d:%d", doc.Mailbox, uidValidity, doc.UID)
+}
+
+// elasticsearchItem is a document of a bulk request.
+type elasticsearchItem struct {
+	uid   uint32
+	index string
+	id    string
+	doc   *ElasticsearchDocument
+}
+
+// elasticsearchIndexer sends bulk requests.
+type elasticsearchIndexer struct {
+	endpoint string
+	header   http.Header
+	client   *http.Client
+}
+
+func newElasticsearchIndexer(c *ElasticsearchConfig) *elasticsearchIndexer {
+	indexer := &elasticsearchIndexer{
+		endpoint: strings.TrimRight(c.Endpoint, "/") + "/_bulk",
+		header:   http.Header{},
+		client:   &http.Client{Timeout: defaultElasticsearchTimeout},
+	}
+	indexer.header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case c.APIKeyEnv != "":
+		if key := os.Getenv(c.APIKeyEnv); key != "" {
+			indexer.header.Set("Authorization", "ApiKey "+key)
+		}
+	case c.Username != "":
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(c.Username, os.Getenv(c.PasswordEnv))
+		indexer.header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	if d, err := time.ParseDuration(c.Timeout); err == nil && c.Timeout != "" {
+		indexer.client.Timeout = d
+	}
+	return indexer
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// index sends items in one bulk request and returns the error of each item
+// that was not indexed, by position. The error return is for requests that
+// failed as a whole.
+func (e *elasticsearchIndexer) index(ctx context.Context, items []elasticsearchItem) (map[int]error, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, item := range items {
+		action := map[string]map[string]string{"index": {"_index": item.index, "_id": item.id}}
+		if err := encoder.Encode(action); err != nil {
+			return nil, fmt.Errorf("failed to encode bulk action: %w", err)
+		}
+		if err := encoder.Encode(item.doc); err != nil {
+			return nil, fmt.Errorf("failed to encode document of message %d: %w", item.uid, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bulk request: %w", err)
+	}
+	for key, values := range e.header {
+		req.Header[key] = values
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call bulk API: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bulk response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bulk API returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var result elasticsearchBulkResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	failed := map[int]error{}
+	for i, item := range result.Items {
+		for _, status := range item {
+			if status.Status >= 300 || len(status.Error) > 0 {
+				failed[i] = fmt.Errorf("indexing returned status %d: %s", status.Status, status.Error)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// selectedUIDValidity returns the UIDVALIDITY of the mailbox selected on
+// client, or 0 when none is selected or the server does not report it. go-imap
+// does not keep it after SELECT, so it is asked for with STATUS.
+func selectedUIDValidity(client *imapclient.Client) uint32 {
+	selected := client.Mailbox()
+	if selected == nil {
+		return 0
+	}
+	data, err := client.Status(selected.Name, &imap.StatusOptions{UIDValidity: true}).Wait()
+	if err != nil {
+		return 0
+	}
+	return data.UIDValidity
+}
+
+// executeIndexElasticsearch fetches the matched messages and indexes them
+// in batches of bulk_size. Messages that fail to fetch or index, including
+// whole batches whose fetch or bulk request failed, are reported as a
+// partial failure; the others stay indexed.
+func executeIndexElasticsearch(client *imapclient.Client, messages []*EmailMessage, config *ElasticsearchConfig, rule *Rule) error {
+	logger := rule.Logger()
+	if len(messages) == 0 {
+		return nil
+	}
+	mailbox := selectedMailbox(client)
+	uidValidity := selectedUIDValidity(client)
+	indexer := newElasticsearchIndexer(config)
+	indexTemplate := config.Index
+	if indexTemplate == "" {
+		indexTemplate = DefaultElasticsearchIndex
+	}
+
+	partial := &ActionPartialFailureError{Action: "index_elasticsearch"}
+	bulkSize := config.bulkSize()
+	for start := 0; start < len(messages); start += bulkSize {
+		batch := messages[start:min(start+bulkSize, len(messages))]
+
+		var uidSet imap.UIDSet
+		for _, msg := range batch {
+			uidSet.AddNum(imap.UID(msg.UID))
+		}
+		section := &imap.FetchItemBodySection{Peek: true}
+		fetched, err := client.Fetch(uidSet, &imap.FetchOptions{
+			UID:          true,
+			InternalDate: true,
+			BodySection:  []*imap.FetchItemBodySection{section},
+		}).Collect()
+		if err != nil {
+			// The batches indexed before stay reported
+			partial.Failed = append(partial.Failed, batchUIDErrors(batch, fmt.Errorf("failed to fetch messages for indexing: %w", err))...)
+			continue
+		}
+		byUID := make(map[uint32]*imapclient.FetchMessageBuffer, len(fetched))
+		for _, f := range fetched {
+			byUID[uint32(f.UID)] = f
+		}
+
+		var items []elasticsearchItem
+		for _, msg := range batch {
+			f, ok := byUID[msg.UID]
+			if !ok {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("message %d was not returned by the fetch", msg.UID)})
+				continue
+			}
+			raw := f.FindBodySection(section)
+			if rule != nil && rule.Decrypt != nil {
+				if raw, err = rule.Decrypt.Decrypt(raw); err != nil {
+					partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("failed to decrypt message %d: %w", msg.UID, err)})
+					continue
+				}
+			}
+			doc, err := newElasticsearchDocument(raw, msg, f.InternalDate, mailbox, config.BodyChars)
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: err})
+				continue
+			}
+			if rule != nil {
+				doc.Rule = rule.Name
+			}
+			index, err := RenderTemplate(indexTemplate, NewTemplateContext(msg, rule))
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("failed to render index: %w", err)})
+				continue
+			}
+			items = append(items, elasticsearchItem{
+				uid:   msg.UID,
+				index: strings.ToLower(strings.TrimSpace(index)),
+				id:    elasticsearchDocumentID(doc, uidValidity),
+				doc:   doc,
+			})
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		failed, err := indexer.index(context.Background(), items)
+		if err != nil {
+			for _, item := range items {
+				partial.Failed = append(partial.Failed, UIDError{UID: item.uid, Err: err})
+			}
+			continue
+		}
+		for i, item := range items {
+			if err, ok := failed[i]; ok {
+				partial.Failed = append(partial.Failed, UIDError{UID: item.uid, Err: err})
+			} else {
+				partial.Succeeded = append(partial.Succeeded, item.uid)
+			}
+		}
+		logger.Debug().Int("documents", len(items)).Int("failed", len(failed)).Msg("Indexed messages")
+	}
+
+	if len(partial.Failed) > 0 {
+		return partial
+	}
+	return nil
+}
+
+// batchUIDErrors returns err for every message of batch.
+func batchUIDErrors(batch []*EmailMessage, err error) []UIDError {
+	errs := make([]UIDError, 0, len(batch))
+	for _, msg := range batch {
+		errs = append(errs, UIDError{UID: msg.UID, Err: err})
+	}
+	return errs
+}
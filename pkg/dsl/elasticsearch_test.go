@@ -0,0 +1,145 @@
+package dsl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const elasticsearchTestMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com, Carol <carol@example.com>\r\n" +
+	"Subject: Quarterly report\r\n" +
+	"Date: Mon, 03 Mar 2025 09:00:00 +0000\r\n" +
+	"Message-ID: <report@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"The numbers look great.\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/csv\r\n" +
+	"Content-Disposition: attachment; filename=q3.csv\r\n" +
+	"\r\n" +
+	"a,b\r\n" +
+	"--b--\r\n"
+
+func TestElasticsearchConfigValidate(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: reports
+search:
+  subject_contains: report
+output:
+  fields: [uid]
+actions:
+  index_elasticsearch:
+    endpoint: https://localhost:9200
+    index: 'mail-{{ dateFormat "2006.01" .Date }}'
+    username: elastic
+    password_env: ES_PASSWORD
+`)
+	require.NoError(t, err)
+	assert.Equal(t, "https://localhost:9200", rule.Actions.IndexElasticsearch.Endpoint)
+
+	for _, config := range []ElasticsearchConfig{
+		{},
+		{Endpoint: "localhost:9200"},
+		{Endpoint: "http://localhost:9200", Index: "{{ .Nope"},
+		{Endpoint: "http://localhost:9200", APIKeyEnv: "ES_KEY", Username: "elastic"},
+		{Endpoint: "http://localhost:9200", PasswordEnv: "ES_PASSWORD"},
+		{Endpoint: "http://localhost:9200", BulkSize: -1},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestNewElasticsearchDocument(t *testing.T) {
+	internalDate := time.Date(2025, 3, 3, 9, 1, 0, 0, time.UTC)
+	doc, err := newElasticsearchDocument([]byte(elasticsearchTestMessage), &EmailMessage{UID: 7, Flags: []string{"\\Seen"}}, internalDate, "INBOX", 9)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(7), doc.UID)
+	assert.Equal(t, "INBOX", doc.Mailbox)
+	assert.Equal(t, "<report@example.com>", doc.MessageID)
+	assert.Equal(t, "alice@example.com", doc.FromAddress)
+	assert.Equal(t, []string{"bob@example.com", "carol@example.com"}, doc.To)
+	assert.Equal(t, "The numbe", doc.Body)
+	require.NotNil(t, doc.Date)
+	assert.True(t, doc.Date.Equal(time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []ElasticsearchAttachment{{Filename: "q3.csv", Type: "text/csv", Size: 3}}, doc.Attachments)
+	assert.Equal(t, "<report@example.com>", elasticsearchDocumentID(doc, 1))
+
+	doc.MessageID = ""
+	assert.Equal(t, "INBOX:1:7", elasticsearchDocumentID(doc, 1))
+}
+
+func TestElasticsearchIndexer(t *testing.T) {
+	t.Setenv("ES_KEY", "c2VjcmV0")
+	var lines []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "ApiKey c2VjcmV0", r.Header.Get("Authorization"))
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		_, _ = w.Write([]byte(`{"errors":true,"items":[
+			{"index":{"_id":"a","status":201}},
+			{"index":{"_id":"b","status":400,"error":{"type":"mapper_parsing_exception"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	indexer := newElasticsearchIndexer(&ElasticsearchConfig{Endpoint: server.URL + "/", APIKeyEnv: "ES_KEY"})
+	failed, err := indexer.index(context.Background(), []elasticsearchItem{
+		{uid: 1, index: "mail", id: "a", doc: &ElasticsearchDocument{UID: 1, Subject: "one"}},
+		{uid: 2, index: "mail", id: "b", doc: &ElasticsearchDocument{UID: 2, Subject: "two"}},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, lines, 4)
+	assert.Equal(t, map[string]interface{}{"index": map[string]interface{}{"_index": "mail", "_id": "a"}}, lines[0])
+	assert.Equal(t, "two", lines[3]["subject"])
+	require.Len(t, failed, 1)
+	assert.Contains(t, failed[1].Error(), "mapper_parsing_exception")
+}
+
+// The fixture is hand-written: the server leaves out UID 2 and the second
+// bulk request fails.
+func TestIndexElasticsearchPartialFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			http.Error(w, "cluster unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"_id":"a","status":201}}]}`))
+	}))
+	defer server.Close()
+	client, done := replayClient(t, "testdata/imap/index_elasticsearch.imap")
+
+	messages := []*EmailMessage{{UID: 1}, {UID: 2}, {UID: 3}}
+	err := executeIndexElasticsearch(client, messages, &ElasticsearchConfig{Endpoint: server.URL, BulkSize: 2}, nil)
+	var partial *ActionPartialFailureError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []uint32{1}, partial.Succeeded, "the first batch stays reported")
+	assert.Equal(t, []uint32{2, 3}, partial.FailedUIDs())
+	assert.Contains(t, partial.Failed[0].Err.Error(), "not returned by the fetch")
+	assert.Contains(t, partial.Failed[1].Err.Error(), "cluster unavailable")
+	assert.Equal(t, 2, requests)
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
@@ -0,0 +1,350 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message"
+)
+
+// Encryption schemes reported by the encrypted output field.
+const (
+	EncryptionNone  = "none"
+	EncryptionPGP   = "pgp"   // PGP/MIME, multipart/encrypted (RFC 3156)
+	EncryptionSMIME = "smime" // S/MIME enveloped data, application/pkcs7-mime (RFC 8551)
+)
+
+// DefaultPKCS12PasswordEnv is the environment variable read for the PKCS#12
+// password when decrypt.pkcs12_password_env is not set.
+const DefaultPKCS12PasswordEnv = "SMAILNAIL_PKCS12_PASSWORD"
+
+// decryptTimeout bounds a single gpg or openssl invocation.
+const decryptTimeout = 30 * time.Second
+
+// DecryptConfig enables decryption of PGP/MIME and S/MIME messages. PGP
+// messages are decrypted with the local gpg keyring, S/MIME messages with
+// openssl and a PKCS#12 certificate. Decrypted messages replace the
+// encrypted ones in mime_parts output, search.expr, exports and a top-level
+// search.body_contains.
+type DecryptConfig struct {
+	// GPG decrypts PGP/MIME messages with the gpg binary
+	GPG bool `yaml:"gpg,omitempty"`
+	// GPGHome overrides the keyring directory (GNUPGHOME)
+	GPGHome string `yaml:"gpg_home,omitempty"`
+	// PKCS12 is the certificate and private key used for S/MIME messages
+	PKCS12 string `yaml:"pkcs12,omitempty"`
+	// PKCS12PasswordEnv names the environment variable holding the PKCS#12
+	// password
+	PKCS12PasswordEnv string `yaml:"pkcs12_password_env,omitempty"`
+}
+
+// Validate checks if the decrypt configuration is valid
+func (d *DecryptConfig) Validate() error {
+	if !d.GPG && d.PKCS12 == "" {
+		return fmt.Errorf("decrypt requires gpg: true or a pkcs12 certificate")
+	}
+	if d.GPGHome != "" && !d.GPG {
+		return fmt.Errorf("gpg_home requires gpg: true")
+	}
+	return nil
+}
+
+func (d *DecryptConfig) passwordEnv() string {
+	if d.PKCS12PasswordEnv != "" {
+		return d.PKCS12PasswordEnv
+	}
+	return DefaultPKCS12PasswordEnv
+}
+
+// runDecryptCommand runs an external decryption tool with stdin as input.
+// Tests replace it to avoid depending on gpg and openssl.
+var runDecryptCommand = func(env []string, name string, args []string, stdin []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+
+	// #nosec G204 -- the command is gpg or openssl with arguments from the rule.
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// encryptionScheme returns the encryption scheme of a part with the given
+// media type and parameters, or EncryptionNone.
+func encryptionScheme(mediaType string, params map[string]string) string {
+	switch strings.ToLower(mediaType) {
+	case "multipart/encrypted":
+		if strings.EqualFold(params["protocol"], "application/pgp-encrypted") {
+			return EncryptionPGP
+		}
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		// signed-data is an opaque signature, not encryption
+		smimeType := strings.ToLower(params["smime-type"])
+		if smimeType == "" || smimeType == "enveloped-data" || smimeType == "authenveloped-data" {
+			return EncryptionSMIME
+		}
+	}
+	return EncryptionNone
+}
+
+// bodyStructureEncryption reports the encryption scheme of a message from its
+// body structure. Only the top-level part is considered, as encrypted parts
+// nested in other multiparts are not decryptable as a message.
+func bodyStructureEncryption(bs imap.BodyStructure) string {
+	switch part := bs.(type) {
+	case *imap.BodyStructureMultiPart:
+		var params map[string]string
+		if part.Extended != nil {
+			params = part.Extended.Params
+		}
+		return encryptionScheme(part.MediaType(), params)
+	case *imap.BodyStructureSinglePart:
+		return encryptionScheme(part.MediaType(), part.Params)
+	}
+	return EncryptionNone
+}
+
+// contentTypeEncryption reports the encryption scheme of a message from its
+// Content-Type header.
+func contentTypeEncryption(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return EncryptionNone
+	}
+	return encryptionScheme(mediaType, params)
+}
+
+// needsEncryption reports whether messages must be checked for encryption,
+// to decrypt them or to output the encrypted field.
+func (rule *Rule) needsEncryption() bool {
+	if rule.Decrypt != nil {
+		return true
+	}
+	for _, fieldInterface := range rule.Output.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "encrypted" {
+			return true
+		}
+	}
+	return false
+}
+
+// Decrypt decrypts a raw encrypted message. The result keeps the outer
+// headers (From, Subject, ...) and replaces the content with the decrypted
+// MIME entity. Messages that are not encrypted are returned unchanged.
+func (d *DecryptConfig) Decrypt(raw []byte) ([]byte, error) {
+	entity, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, fmt.Errorf("failed to parse encrypted message: %w", err)
+	}
+
+	var decrypted []byte
+	switch scheme := contentTypeEncryption(entity.Header.Get("Content-Type")); scheme {
+	case EncryptionPGP:
+		decrypted, err = d.decryptPGP(entity)
+	case EncryptionSMIME:
+		decrypted, err = d.decryptSMIME(entity)
+	default:
+		return raw, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	fields := entity.Header.Fields()
+	for fields.Next() {
+		key := strings.ToLower(fields.Key())
+		if strings.HasPrefix(key, "content-") || key == "mime-version" {
+			continue
+		}
+		raw, err := fields.Raw()
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy header %s: %w", fields.Key(), err)
+		}
+		b.Write(raw)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.Write(decrypted)
+	return b.Bytes(), nil
+}
+
+func (d *DecryptConfig) decryptPGP(entity *message.Entity) ([]byte, error) {
+	if !d.GPG {
+		return nil, fmt.Errorf("PGP message but decrypt.gpg is not enabled")
+	}
+	mr := entity.MultipartReader()
+	if mr == nil {
+		return nil, fmt.Errorf("PGP message is not multipart")
+	}
+	var ciphertext []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PGP message part: %w", err)
+		}
+		mediaType, _, _ := part.Header.ContentType()
+		if mediaType == "application/octet-stream" {
+			if ciphertext, err = io.ReadAll(part.Body); err != nil {
+				return nil, fmt.Errorf("failed to read PGP ciphertext: %w", err)
+			}
+			break
+		}
+	}
+	if ciphertext == nil {
+		return nil, fmt.Errorf("PGP message has no encrypted part")
+	}
+
+	args := []string{"--batch", "--quiet", "--decrypt"}
+	if d.GPGHome != "" {
+		args = append([]string{"--homedir", d.GPGHome}, args...)
+	}
+	return runDecryptCommand(nil, "gpg", args, ciphertext)
+}
+
+func (d *DecryptConfig) decryptSMIME(entity *message.Entity) ([]byte, error) {
+	if d.PKCS12 == "" {
+		return nil, fmt.Errorf("S/MIME message but decrypt.pkcs12 is not set")
+	}
+	ciphertext, err := io.ReadAll(entity.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S/MIME ciphertext: %w", err)
+	}
+
+	// openssl cms cannot read PKCS#12 directly, so convert the certificate
+	// and key to PEM first and hand them over through a private temp file
+	env := []string{"SMAILNAIL_PKCS12_PASS=" + os.Getenv(d.passwordEnv())}
+	pem, err := runDecryptCommand(env, "openssl",
+		[]string{"pkcs12", "-in", d.PKCS12, "-nodes", "-passin", "env:SMAILNAIL_PKCS12_PASS"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKCS#12 certificate: %w", err)
+	}
+	keyFile, err := os.CreateTemp("", "smailnail-smime-*.pem")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(keyFile.Name())
+	}()
+	if _, err := keyFile.Write(pem); err != nil {
+		_ = keyFile.Close()
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := keyFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return runDecryptCommand(nil, "openssl",
+		[]string{"cms", "-decrypt", "-inform", "DER", "-recip", keyFile.Name(), "-inkey", keyFile.Name()}, ciphertext)
+}
+
+// decryptMessage decrypts raw, the full encrypted message msg was fetched
+// from, and replaces the message content with the decrypted content.
+// Failures are logged and leave the message as is, with Decrypted false.
+func (rule *Rule) decryptMessage(msg *EmailMessage, raw []byte) {
+	logger := rule.Logger()
+	decrypted, err := rule.Decrypt.Decrypt(raw)
+	if err == nil {
+		var local *LocalMessage
+		local, err = ParseLocalMessage(decrypted, msg.SeqNum, msg.Flags, time.Time{})
+		if err == nil {
+			msg.Decrypted = true
+			msg.decryptedText = local.bodyText
+			if contentField, ok := mimePartsField(rule.Output); ok {
+				msg.MimeParts = nil
+				for _, part := range local.Message.MimeParts {
+					if contentField == nil || contentField.ShouldInclude(part.Type) {
+						msg.MimeParts = append(msg.MimeParts, part)
+					}
+				}
+			}
+			if msg.Attachments != nil || rule.needsAttachments() {
+				msg.Attachments = local.Message.Attachments
+			}
+			return
+		}
+	}
+	logger.Warn().
+		Err(err).
+		Uint32("uid", msg.UID).
+		Str("encryption", msg.Encrypted).
+		Msg("Failed to decrypt message")
+}
+
+// fetchAndDecrypt fetches the full encrypted message and decrypts it.
+func (rule *Rule) fetchAndDecrypt(client *imapclient.Client, msg *EmailMessage) error {
+	logger := rule.Logger()
+	var uidSet imap.UIDSet
+	uidSet.AddNum(imap.UID(msg.UID))
+	section := &imap.FetchItemBodySection{Peek: true}
+	fetched, err := client.Fetch(uidSet, &imap.FetchOptions{UID: true, BodySection: []*imap.FetchItemBodySection{section}}).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to fetch encrypted message %d: %w", msg.UID, err)
+	}
+	if len(fetched) == 0 {
+		logger.Warn().Uint32("uid", msg.UID).Msg("Could not fetch encrypted message")
+		return nil
+	}
+	rule.decryptMessage(msg, fetched[0].FindBodySection(section))
+	return nil
+}
+
+// buildSearchCriteria builds the rule's server-side search. With decryption
+// enabled, a top-level body_contains cannot be checked by the server for
+// encrypted messages, so they are searched too and body_contains is checked
+// against their decrypted text client-side.
+func (rule *Rule) buildSearchCriteria() (*imap.SearchCriteria, *imap.SearchOptions, error) {
+	criteria, options, err := BuildSearchCriteria(rule.Search, &rule.Output)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rule.input != nil {
+		criteria.UID = append(criteria.UID, rule.input.fetched)
+	}
+	// The body criteria are matched by filterBody instead
+	if rule.Search.clientSideBody() {
+		criteria.Body = nil
+		criteria.Text = nil
+		return criteria, options, nil
+	}
+	if rule.Decrypt != nil && rule.Search.Operator == "" && rule.Search.BodyContains != "" {
+		contentType := func(value string) imap.SearchCriteria {
+			return imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: "Content-Type", Value: value}}}
+		}
+		encrypted := anyOf([]imap.SearchCriteria{contentType("multipart/encrypted"), contentType("pkcs7-mime")})
+		criteria.Or = append(criteria.Or, [2]imap.SearchCriteria{{Body: criteria.Body}, encrypted})
+		criteria.Body = nil
+	}
+	return criteria, options, nil
+}
+
+// decryptedBodyFilter returns the client-side half of body_contains for
+// decrypted messages, or nil if decryption is off.
+func (rule *Rule) decryptedBodyFilter() func(*EmailMessage) bool {
+	if rule.Decrypt == nil || rule.Search.BodyContains == "" || rule.Search.Operator != "" {
+		return nil
+	}
+	return func(msg *EmailMessage) bool {
+		if msg.Encrypted == "" || msg.Encrypted == EncryptionNone {
+			return true
+		}
+		return msg.Decrypted && containsFold(msg.decryptedText, rule.Search.BodyContains)
+	}
+}
@@ -0,0 +1,145 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pgpEML = "From: alice@example.com\r\n" +
+	"To: me@example.com\r\n" +
+	"Subject: secret\r\n" +
+	"Date: Mon, 03 Mar 2025 10:00:00 +0000\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/encrypted; protocol=\"application/pgp-encrypted\"; boundary=enc\r\n" +
+	"\r\n" +
+	"--enc\r\n" +
+	"Content-Type: application/pgp-encrypted\r\n" +
+	"\r\n" +
+	"Version: 1\r\n" +
+	"--enc\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"\r\n" +
+	"-----BEGIN PGP MESSAGE-----\r\n" +
+	"ciphertext\r\n" +
+	"-----END PGP MESSAGE-----\r\n" +
+	"--enc--\r\n"
+
+const plainEML = "From: bob@example.com\r\n" +
+	"Subject: plain\r\n" +
+	"Date: Mon, 03 Mar 2025 11:00:00 +0000\r\n" +
+	"\r\n" +
+	"the launch code is not here\r\n"
+
+func stubDecryptCommand(t *testing.T, output string) *[][]string {
+	t.Helper()
+	var calls [][]string
+	previous := runDecryptCommand
+	runDecryptCommand = func(env []string, name string, args []string, stdin []byte) ([]byte, error) {
+		calls = append(calls, append([]string{name}, args...))
+		if name == "gpg" && !strings.Contains(string(stdin), "ciphertext") {
+			t.Errorf("unexpected gpg input %q", stdin)
+		}
+		return []byte(output), nil
+	}
+	t.Cleanup(func() { runDecryptCommand = previous })
+	return &calls
+}
+
+func TestEncryptionScheme(t *testing.T) {
+	assert.Equal(t, EncryptionPGP, encryptionScheme("multipart/encrypted", map[string]string{"protocol": "application/pgp-encrypted"}))
+	assert.Equal(t, EncryptionSMIME, encryptionScheme("application/pkcs7-mime", map[string]string{"smime-type": "enveloped-data"}))
+	assert.Equal(t, EncryptionSMIME, encryptionScheme("application/x-pkcs7-mime", nil))
+	assert.Equal(t, EncryptionNone, encryptionScheme("application/pkcs7-mime", map[string]string{"smime-type": "signed-data"}))
+	assert.Equal(t, EncryptionNone, encryptionScheme("text/plain", nil))
+
+	assert.Equal(t, EncryptionPGP, bodyStructureEncryption(&imap.BodyStructureMultiPart{
+		Subtype:  "encrypted",
+		Extended: &imap.BodyStructureMultiPartExt{Params: map[string]string{"protocol": "application/pgp-encrypted"}},
+	}))
+}
+
+func TestDecryptPGP(t *testing.T) {
+	calls := stubDecryptCommand(t, "Content-Type: text/plain\r\n\r\nthe launch code is 1234\r\n")
+
+	decrypted, err := (&DecryptConfig{GPG: true, GPGHome: "/keys"}).Decrypt([]byte(pgpEML))
+	require.NoError(t, err)
+	assert.Equal(t, "From: alice@example.com\r\n"+
+		"To: me@example.com\r\n"+
+		"Subject: secret\r\n"+
+		"Date: Mon, 03 Mar 2025 10:00:00 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain\r\n\r\nthe launch code is 1234\r\n", string(decrypted))
+	assert.Equal(t, [][]string{{"gpg", "--homedir", "/keys", "--batch", "--quiet", "--decrypt"}}, *calls)
+
+	_, err = (&DecryptConfig{PKCS12: "me.p12"}).Decrypt([]byte(pgpEML))
+	assert.ErrorContains(t, err, "decrypt.gpg is not enabled")
+
+	plain, err := (&DecryptConfig{GPG: true}).Decrypt([]byte(plainEML))
+	require.NoError(t, err)
+	assert.Equal(t, plainEML, string(plain))
+}
+
+func TestDecryptLocalMessages(t *testing.T) {
+	stubDecryptCommand(t, "Content-Type: text/plain\r\n\r\nthe launch code is 1234\r\n")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.eml"), []byte(pgpEML), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.eml"), []byte(plainEML), 0o600))
+	messages, err := ReadLocalMessages(dir)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, EncryptionPGP, messages[0].Message.Encrypted)
+	assert.Equal(t, EncryptionNone, messages[1].Message.Encrypted)
+
+	rule, err := ParseRuleString(`
+name: decrypt
+search:
+  body_contains: "launch code is 1234"
+decrypt:
+  gpg: true
+output:
+  fields:
+    - uid
+    - encrypted
+    - mime_parts:
+        mode: text_only
+`)
+	require.NoError(t, err)
+
+	criteria, _, err := rule.buildSearchCriteria()
+	require.NoError(t, err)
+	assert.Empty(t, criteria.Body, "body_contains is widened to encrypted messages")
+	require.Len(t, criteria.Or, 1)
+
+	got, err := rule.FilterLocalMessages(messages)
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint32(1), got[0].UID)
+	assert.True(t, got[0].Decrypted)
+	require.Len(t, got[0].MimeParts, 1)
+	assert.Equal(t, "the launch code is 1234\r\n", got[0].MimeParts[0].Content)
+	assert.False(t, messages[0].Message.Decrypted, "source messages are not modified")
+
+	ok, err := rule.MatchLocal(messages[0])
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDecryptConfigValidation(t *testing.T) {
+	_, err := ParseRuleString(`
+name: decrypt
+search:
+  unread: true
+decrypt:
+  gpg_home: /keys
+output:
+  fields: [uid]
+`)
+	assert.ErrorContains(t, err, "decrypt requires gpg: true or a pkcs12 certificate")
+}
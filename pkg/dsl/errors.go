@@ -0,0 +1,148 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Sentinel errors returned (wrapped) by the dsl package. Callers embedding
+// pkg/dsl can branch on them with errors.Is.
+var (
+	// ErrInvalidRule is returned when a rule fails validation.
+	ErrInvalidRule = errors.New("invalid rule")
+	// ErrMailboxNotFound is returned when the server reports that a source or
+	// target mailbox does not exist.
+	ErrMailboxNotFound = errors.New("mailbox not found")
+	// ErrSearchUnsupported is returned when the server rejects the generated
+	// SEARCH command (unsupported criteria, charset or extension).
+	ErrSearchUnsupported = errors.New("search not supported by server")
+	// ErrActionPartialFailure is returned when an action succeeded for some
+	// messages but failed for others. Use errors.As with
+	// *ActionPartialFailureError to get per-UID details.
+	ErrActionPartialFailure = errors.New("action partially failed")
+	// ErrKeywordUnsupported is returned when a rule searches keywords the
+	// selected mailbox neither lists nor can store.
+	ErrKeywordUnsupported = errors.New("keyword not supported by mailbox")
+	// ErrInsufficientRights is returned when the account lacks the ACL
+	// rights a rule needs on a mailbox. Use errors.As with *RightsError to
+	// get the missing rights.
+	ErrInsufficientRights = errors.New("insufficient mailbox rights")
+)
+
+// MailboxError describes a failure tied to a specific mailbox.
+type MailboxError struct {
+	Mailbox string
+	Err     error
+}
+
+func (e *MailboxError) Error() string {
+	return fmt.Sprintf("mailbox %q: %v", e.Mailbox, e.Err)
+}
+
+// Is matches ErrMailboxNotFound when the server reported that the mailbox
+// does not exist.
+func (e *MailboxError) Is(target error) bool {
+	return target == ErrMailboxNotFound && isMailboxNotFound(e.Err)
+}
+
+func (e *MailboxError) Unwrap() error {
+	return e.Err
+}
+
+// UIDError records why an action failed for a single message.
+type UIDError struct {
+	UID uint32
+	Err error
+}
+
+// ActionPartialFailureError is returned when an action could only be applied
+// to a subset of the matched messages.
+type ActionPartialFailureError struct {
+	Action    string
+	Succeeded []uint32
+	Failed    []UIDError
+}
+
+func (e *ActionPartialFailureError) Error() string {
+	uids := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		uids = append(uids, fmt.Sprintf("%d", f.UID))
+	}
+	return fmt.Sprintf("action %s failed for %d of %d message(s) (uids: %s)",
+		e.Action, len(e.Failed), len(e.Failed)+len(e.Succeeded), strings.Join(uids, ", "))
+}
+
+func (e *ActionPartialFailureError) Is(target error) bool {
+	return target == ErrActionPartialFailure
+}
+
+func (e *ActionPartialFailureError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// FailedUIDs returns the UIDs for which the action failed, sorted ascending.
+func (e *ActionPartialFailureError) FailedUIDs() []uint32 {
+	uids := make([]uint32, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		uids = append(uids, f.UID)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// wrapMailboxError wraps err in a *MailboxError when the server response
+// indicates the mailbox does not exist. Other errors are returned unchanged.
+func wrapMailboxError(err error, mailbox string) error {
+	if isMailboxNotFound(err) {
+		return &MailboxError{Mailbox: mailbox, Err: err}
+	}
+	return err
+}
+
+// isMailboxNotFound reports whether err is a server response saying that a
+// mailbox does not exist.
+func isMailboxNotFound(err error) bool {
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) {
+		return false
+	}
+	switch imapErr.Code {
+	case imap.ResponseCodeNonExistent, imap.ResponseCodeTryCreate:
+		return true
+	}
+	return false
+}
+
+// wrapSearchError tags SEARCH failures that stem from the server not
+// understanding the request with ErrSearchUnsupported.
+func wrapSearchError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var imapErr *imap.Error
+	if errors.As(err, &imapErr) {
+		if imapErr.Type == imap.StatusResponseTypeBad || imapErr.Code == imap.ResponseCodeBadCharset {
+			return fmt.Errorf("%w: %w", ErrSearchUnsupported, err)
+		}
+	}
+	return err
+}
+
+// SelectMailbox selects mailbox on client. When the server reports that the
+// mailbox does not exist, the returned error matches ErrMailboxNotFound.
+func SelectMailbox(client *imapclient.Client, mailbox string) (*imap.SelectData, error) {
+	data, err := client.Select(mailbox, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapMailboxErrorMatchesNotFound(t *testing.T) {
+	serverErr := &imap.Error{
+		Type: imap.StatusResponseTypeNo,
+		Code: imap.ResponseCodeTryCreate,
+		Text: "Mailbox doesn't exist: Archive",
+	}
+
+	err := fmt.Errorf("failed to move: %w", wrapMailboxError(serverErr, "Archive"))
+
+	assert.True(t, errors.Is(err, ErrMailboxNotFound))
+	var mailboxErr *MailboxError
+	require.True(t, errors.As(err, &mailboxErr))
+	assert.Equal(t, "Archive", mailboxErr.Mailbox)
+
+	var imapErr *imap.Error
+	assert.True(t, errors.As(err, &imapErr), "original server error should stay reachable")
+}
+
+func TestWrapMailboxErrorLeavesOtherErrorsAlone(t *testing.T) {
+	serverErr := &imap.Error{Type: imap.StatusResponseTypeNo, Text: "permission denied"}
+	err := wrapMailboxError(serverErr, "Archive")
+
+	assert.False(t, errors.Is(err, ErrMailboxNotFound))
+	assert.Same(t, serverErr, err)
+}
+
+func TestMailboxErrorMatchesOnlyMissingMailboxes(t *testing.T) {
+	err := &MailboxError{Mailbox: "Archive", Err: &imap.Error{Type: imap.StatusResponseTypeNo, Code: imap.ResponseCodeNoPerm}}
+	assert.False(t, errors.Is(err, ErrMailboxNotFound))
+
+	err = &MailboxError{Mailbox: "Archive", Err: errors.New("connection reset")}
+	assert.False(t, errors.Is(err, ErrMailboxNotFound))
+
+	err = &MailboxError{Mailbox: "Archive", Err: &imap.Error{Type: imap.StatusResponseTypeNo, Code: imap.ResponseCodeNonExistent}}
+	assert.True(t, errors.Is(err, ErrMailboxNotFound))
+}
+
+func TestWrapSearchErrorMatchesUnsupported(t *testing.T) {
+	err := wrapSearchError(&imap.Error{Type: imap.StatusResponseTypeBad, Text: "unknown search key"})
+	assert.True(t, errors.Is(err, ErrSearchUnsupported))
+
+	err = wrapSearchError(&imap.Error{Type: imap.StatusResponseTypeNo, Code: imap.ResponseCodeBadCharset})
+	assert.True(t, errors.Is(err, ErrSearchUnsupported))
+
+	err = wrapSearchError(errors.New("connection reset"))
+	assert.False(t, errors.Is(err, ErrSearchUnsupported))
+}
+
+func TestActionPartialFailureError(t *testing.T) {
+	diskErr := errors.New("disk full")
+	partial := &ActionPartialFailureError{
+		Action:    "export",
+		Succeeded: []uint32{1, 2},
+		Failed: []UIDError{
+			{UID: 9, Err: diskErr},
+			{UID: 4, Err: errors.New("fetch failed")},
+		},
+	}
+
+	err := fmt.Errorf("failed to export messages: %w", partial)
+
+	assert.True(t, errors.Is(err, ErrActionPartialFailure))
+	assert.True(t, errors.Is(err, diskErr))
+
+	var target *ActionPartialFailureError
+	require.True(t, errors.As(err, &target))
+	assert.Equal(t, []uint32{4, 9}, target.FailedUIDs())
+	assert.Contains(t, target.Error(), "2 of 4")
+}
+
+func TestParseRuleStringWrapsInvalidRule(t *testing.T) {
+	_, err := ParseRuleString("description: no name\noutput:\n  fields: [uid]\n")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrInvalidRule))
+}
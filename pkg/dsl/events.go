@@ -0,0 +1,100 @@
+package dsl
+
+import (
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Event is emitted while a rule runs, to the subscribers added with
+// Rule.Subscribe. It is one of SearchStarted, SearchCompleted,
+// MessageFetched, ActionApplied or ErrorOccurred.
+type Event interface {
+	// RuleName is the name of the rule the event is about
+	RuleName() string
+}
+
+// Subscriber receives the events of the rules it subscribed to. Events are
+// delivered synchronously, from the goroutine running the rule, so
+// HandleEvent must return quickly; subscribers shared by rules that run
+// concurrently must be safe for concurrent use.
+type Subscriber interface {
+	HandleEvent(Event)
+}
+
+// SubscriberFunc adapts a function to a Subscriber.
+type SubscriberFunc func(Event)
+
+func (f SubscriberFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// Subscribe adds a subscriber to the rule's events.
+func (rule *Rule) Subscribe(subscriber Subscriber) {
+	rule.subscribers = append(rule.subscribers, subscriber)
+}
+
+// publish delivers an event to the rule's subscribers. It can be called on
+// a nil rule.
+func (rule *Rule) publish(event Event) {
+	if rule == nil {
+		return
+	}
+	for _, subscriber := range rule.subscribers {
+		subscriber.HandleEvent(event)
+	}
+}
+
+// SearchStarted is emitted before the rule's search is sent to the server.
+type SearchStarted struct {
+	Rule     string
+	Criteria *imap.SearchCriteria
+}
+
+// SearchCompleted is emitted when the server answered the search.
+type SearchCompleted struct {
+	Rule string
+	// Matched is the number of messages the search returned, before
+	// output.limit and output.offset
+	Matched  int
+	Duration time.Duration
+}
+
+// MessageFetched is emitted for each message fetched from the server,
+// before client-side filters.
+type MessageFetched struct {
+	Rule    string
+	Message *EmailMessage
+	// Fetched counts the messages fetched so far, out of Total
+	Fetched int
+	Total   int
+}
+
+// ActionApplied is emitted after each action attempted on the matched
+// messages, whether it succeeded or not.
+type ActionApplied struct {
+	Rule     string
+	Result   ActionResult
+	Duration time.Duration
+}
+
+// Stages of a rule run, reported by ErrorOccurred.
+const (
+	StageSearch = "search"
+	StageFetch  = "fetch"
+	StageAction = "action"
+)
+
+// ErrorOccurred is emitted when a stage of the rule run fails.
+type ErrorOccurred struct {
+	Rule string
+	// Stage is StageSearch, StageFetch or StageAction
+	Stage string
+	Err   error
+}
+
+func (e SearchStarted) RuleName() string   { return e.Rule }
+func (e SearchCompleted) RuleName() string { return e.Rule }
+func (e MessageFetched) RuleName() string  { return e.Rule }
+func (e ActionApplied) RuleName() string   { return e.Rule }
+func (e ErrorOccurred) RuleName() string   { return e.Rule }
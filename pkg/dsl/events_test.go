@@ -0,0 +1,63 @@
+package dsl
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleEvents(t *testing.T) {
+	rule, err := ParseRuleFile("testdata/imap/move_invoices.yaml")
+	require.NoError(t, err)
+	var events []Event
+	rule.Subscribe(SubscriberFunc(func(e Event) {
+		events = append(events, e)
+	}))
+
+	client, done := replayClient(t, "testdata/imap/move_invoices.imap")
+	_, err = SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages, err := rule.FetchMessages(client)
+	require.NoError(t, err)
+	require.NoError(t, rule.ExecuteActions(client, messages))
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done)
+
+	require.Len(t, events, 6)
+	for _, e := range events {
+		assert.Equal(t, "move-invoices", e.RuleName())
+	}
+	started, ok := events[0].(SearchStarted)
+	require.True(t, ok)
+	assert.Equal(t, "invoice", started.Criteria.Header[0].Value)
+	assert.Equal(t, 2, events[1].(SearchCompleted).Matched)
+
+	fetched := events[2].(MessageFetched)
+	assert.Equal(t, 1, fetched.Fetched)
+	assert.Equal(t, 2, fetched.Total)
+	assert.Equal(t, "invoice 1", fetched.Message.Envelope.Subject)
+	assert.Equal(t, 2, events[3].(MessageFetched).Fetched)
+
+	assert.Equal(t, ActionResult{Action: "flags", Messages: 2}, events[4].(ActionApplied).Result)
+	assert.Equal(t, "move_to", events[5].(ActionApplied).Result.Action)
+}
+
+func TestActionErrorEvents(t *testing.T) {
+	rule := &Rule{Name: "r"}
+	var events []Event
+	rule.Subscribe(SubscriberFunc(func(e Event) {
+		events = append(events, e)
+	}))
+
+	rec := &actionRecorder{messages: 2, rule: rule}
+	err := rec.run("move_to", func() error { return errors.New("no such mailbox") })
+	require.Error(t, err)
+
+	require.Len(t, events, 2)
+	assert.Equal(t, "no such mailbox", events[0].(ActionApplied).Result.Error)
+	failed := events[1].(ErrorOccurred)
+	assert.Equal(t, StageAction, failed.Stage)
+	assert.EqualError(t, failed.Err, "move_to: no such mailbox")
+}
@@ -0,0 +1,289 @@
+package dsl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expectation is an entry of a rule's expect block: whether a fixture message
+// matches the rule, and with which label and actions.
+type Expectation struct {
+	// Fixture names the message: its path relative to the fixtures
+	// directory, with #<n> appended for the nth message of an mbox file
+	Fixture string `yaml:"fixture"`
+	// Match is whether the message matches the rule, true if omitted
+	Match *bool `yaml:"match,omitempty"`
+	// Label is the classify label the message should get
+	Label string `yaml:"label,omitempty"`
+	// Actions are the actions that should run on the message, in any order,
+	// named like the action results (e.g. move_to, by_label.spam.delete). An
+	// empty list expects no actions; omitting it skips the check.
+	Actions []string `yaml:"actions,omitempty"`
+}
+
+// Validate checks if the expectation is valid
+func (e *Expectation) Validate() error {
+	if e.Fixture == "" {
+		return fmt.Errorf("fixture is required")
+	}
+	if !e.expectsMatch() && (e.Label != "" || len(e.Actions) > 0) {
+		return fmt.Errorf("fixture %s: label and actions need match: true", e.Fixture)
+	}
+	return nil
+}
+
+func (e *Expectation) expectsMatch() bool {
+	return e.Match == nil || *e.Match
+}
+
+// Checks of an ExpectationResult.
+const (
+	CheckMatch           = "match"
+	CheckLabel           = "label"
+	CheckActions         = "actions"
+	CheckUnexpectedMatch = "unexpected_match"
+	CheckGolden          = "golden"
+)
+
+// ExpectationResult is the outcome of one check of an expectation.
+type ExpectationResult struct {
+	Rule    string `json:"rule"`
+	Fixture string `json:"fixture"`
+	// Check is CheckMatch, CheckLabel, CheckActions, CheckUnexpectedMatch or
+	// CheckGolden. Golden checks name the golden file in Fixture.
+	Check    string `json:"check"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Passed   bool   `json:"passed"`
+}
+
+// FixtureName returns the name an expectation refers to the message read
+// from source by: source relative to root, the path given to
+// ReadLocalMessages, keeping the #<n> suffix of mbox messages.
+func FixtureName(root string, source string) string {
+	path, index := source, ""
+	if i := strings.LastIndex(source, "#"); i >= 0 {
+		if _, err := strconv.Atoi(source[i+1:]); err == nil {
+			path, index = source[:i], source[i:]
+		}
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		rel = filepath.Base(path)
+	}
+	return filepath.ToSlash(rel) + index
+}
+
+// CheckExpectations evaluates the rule against the fixture messages, read
+// with ReadLocalMessages(root), and checks the rule's expect block. Matching
+// follows FilterLocalMessages, so output.limit and output.offset apply;
+// actions are planned, not executed. With strict, matched messages missing
+// from the expect block fail as CheckUnexpectedMatch.
+func (rule *Rule) CheckExpectations(root string, messages []*LocalMessage, strict bool) ([]ExpectationResult, error) {
+	matched, err := rule.FilterLocalMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]*LocalMessage, len(messages))
+	for _, msg := range messages {
+		names[FixtureName(root, msg.Source)] = msg
+	}
+	// Local messages are numbered by their position in the source
+	matchedByName := make(map[string]*EmailMessage, len(matched))
+	for _, msg := range matched {
+		if idx := int(msg.SeqNum) - 1; idx >= 0 && idx < len(messages) {
+			matchedByName[FixtureName(root, messages[idx].Source)] = msg
+		}
+	}
+
+	var results []ExpectationResult
+	add := func(fixture, check, expected, actual string) {
+		results = append(results, ExpectationResult{
+			Rule:     rule.Name,
+			Fixture:  fixture,
+			Check:    check,
+			Expected: expected,
+			Actual:   actual,
+			Passed:   expected == actual,
+		})
+	}
+
+	expected := make(map[string]bool, len(rule.Expect))
+	for _, expect := range rule.Expect {
+		expected[expect.Fixture] = true
+		if _, ok := names[expect.Fixture]; !ok {
+			add(expect.Fixture, CheckMatch, strconv.FormatBool(expect.expectsMatch()), "fixture not found")
+			continue
+		}
+		msg, isMatch := matchedByName[expect.Fixture]
+		add(expect.Fixture, CheckMatch, strconv.FormatBool(expect.expectsMatch()), strconv.FormatBool(isMatch))
+		if !isMatch || !expect.expectsMatch() {
+			continue
+		}
+		if expect.Label != "" {
+			add(expect.Fixture, CheckLabel, expect.Label, msg.Label)
+		}
+		if expect.Actions != nil {
+			want := append([]string(nil), expect.Actions...)
+			sort.Strings(want)
+			got := plannedActions(&rule.Actions, msg.Label)
+			sort.Strings(got)
+			add(expect.Fixture, CheckActions, strings.Join(want, ","), strings.Join(got, ","))
+		}
+	}
+
+	if strict {
+		unexpected := make([]string, 0, len(matchedByName))
+		for name := range matchedByName {
+			if !expected[name] {
+				unexpected = append(unexpected, name)
+			}
+		}
+		sort.Strings(unexpected)
+		for _, name := range unexpected {
+			add(name, CheckUnexpectedMatch, "false", "true")
+		}
+	}
+	return results, nil
+}
+
+// CheckGolden renders the fixture messages the rule matches in format (json,
+// ndjson, text or table; the rule's output format if empty) and compares the
+// output with the golden file at path. With update, the golden file is
+// written instead and the check passes.
+func (rule *Rule) CheckGolden(path string, format string, messages []*LocalMessage, update bool) (ExpectationResult, error) {
+	result := ExpectationResult{Rule: rule.Name, Fixture: path, Check: CheckGolden}
+	matched, err := rule.FilterLocalMessages(messages)
+	if err != nil {
+		return result, err
+	}
+	config := rule.Output
+	if format != "" {
+		config.Format = format
+	}
+	switch config.Format {
+	case "json", "ndjson", "text", "table":
+	default:
+		return result, fmt.Errorf("invalid format: %s (must be 'json', 'ndjson', 'text', or 'table')", config.Format)
+	}
+	var rendered bytes.Buffer
+	if err := writeMessages(&rendered, matched, config, rule); err != nil {
+		return result, err
+	}
+
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return result, fmt.Errorf("failed to create golden directory: %w", err)
+		}
+		if err := os.WriteFile(path, rendered.Bytes(), 0o600); err != nil {
+			return result, fmt.Errorf("failed to write golden file: %w", err)
+		}
+		result.Expected, result.Actual, result.Passed = "updated", "updated", true
+		return result, nil
+	}
+
+	// #nosec G304 -- golden files are chosen by the user running the tests.
+	golden, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		result.Expected, result.Actual = "golden file", "missing, run with --update"
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to read golden file: %w", err)
+	}
+	result.Expected, result.Actual = firstDifference(string(golden), rendered.String())
+	result.Passed = bytes.Equal(golden, rendered.Bytes())
+	return result, nil
+}
+
+// firstDifference returns the first line where want and got differ,
+// prefixed with its number, or "identical" twice.
+func firstDifference(want string, got string) (string, string) {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		w, g := "<end of output>", "<end of output>"
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d: %s", i+1, w), fmt.Sprintf("line %d: %s", i+1, g)
+		}
+	}
+	return "identical", "identical"
+}
+
+// plannedActions returns the names of the actions executeActions runs on a
+// message with label, in the order it runs them.
+func plannedActions(actions *ActionConfig, label string) []string {
+	if actions == nil {
+		return nil
+	}
+	if len(actions.ByLabel) > 0 {
+		if group, ok := actions.ByLabel[label]; ok && label != "" {
+			var names []string
+			for _, name := range plannedActions(group, label) {
+				names = append(names, "by_label."+label+"."+name)
+			}
+			return names
+		}
+		rest := *actions
+		rest.ByLabel = nil
+		return plannedActions(&rest, label)
+	}
+
+	var names []string
+	if actions.Flags != nil {
+		names = append(names, "flags")
+	}
+	if actions.CopyTo != "" {
+		names = append(names, "copy_to")
+	}
+	names = append(names, sortedCustomActionNames(actions.Custom)...)
+	if actions.Unsubscribe != nil {
+		names = append(names, "unsubscribe")
+	}
+	if actions.Notify != nil {
+		names = append(names, "notify")
+	}
+	if actions.IndexElasticsearch != nil {
+		names = append(names, "index_elasticsearch")
+	}
+	if actions.Publish != nil {
+		names = append(names, "publish")
+	}
+	if actions.Database != nil {
+		names = append(names, "database")
+	}
+	if actions.CreateIssue != nil {
+		names = append(names, "create_issue")
+	}
+	if actions.FollowUp != nil {
+		names = append(names, "follow_up")
+	}
+	// Snoozing and moving end the actions, like in executeActions
+	if actions.Snooze != nil {
+		return append(names, "snooze")
+	}
+	if actions.MoveTo != "" {
+		return append(names, "move_to")
+	}
+	if actions.Delete != nil {
+		names = append(names, "delete")
+	}
+	if actions.Export != nil {
+		names = append(names, "export")
+	}
+	return names
+}
@@ -0,0 +1,137 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixtureName(t *testing.T) {
+	assert.Equal(t, "invoices/march.eml", FixtureName("corpus", filepath.Join("corpus", "invoices", "march.eml")))
+	assert.Equal(t, "sample.mbox#2", FixtureName(filepath.Join("testdata", "sample.mbox"), filepath.Join("testdata", "sample.mbox")+"#2"))
+	assert.Equal(t, "one.eml", FixtureName("one.eml", "one.eml"))
+}
+
+func TestCheckExpectations(t *testing.T) {
+	messages := readSampleMbox(t)
+	root := filepath.Join("testdata", "sample.mbox")
+
+	rule, err := ParseRuleString(`
+name: unread-news
+search:
+  flags:
+    not_has: ["seen"]
+output:
+  fields: [subject]
+actions:
+  flags:
+    add: ["seen"]
+  move_to: News
+expect:
+  - fixture: sample.mbox#1
+    match: false
+  - fixture: sample.mbox#2
+    actions: [move_to, flags]
+  - fixture: sample.mbox#3
+    actions: [delete]
+  - fixture: sample.mbox#9
+`)
+	require.NoError(t, err)
+
+	results, err := rule.CheckExpectations(root, messages, false)
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+
+	assert.Equal(t, ExpectationResult{Rule: "unread-news", Fixture: "sample.mbox#1", Check: CheckMatch, Expected: "false", Actual: "false", Passed: true}, results[0])
+	assert.True(t, results[1].Passed)
+	assert.Equal(t, ExpectationResult{Rule: "unread-news", Fixture: "sample.mbox#2", Check: CheckActions, Expected: "flags,move_to", Actual: "flags,move_to", Passed: true}, results[2])
+	assert.True(t, results[3].Passed)
+	assert.Equal(t, CheckActions, results[4].Check)
+	assert.Equal(t, "delete", results[4].Expected)
+	assert.Equal(t, "flags,move_to", results[4].Actual)
+	assert.False(t, results[4].Passed)
+	assert.Equal(t, "fixture not found", results[5].Actual)
+	assert.False(t, results[5].Passed)
+}
+
+func TestCheckExpectationsStrict(t *testing.T) {
+	messages := readSampleMbox(t)
+	rule, err := ParseRuleString(`
+name: all
+search:
+  from: example
+output:
+  fields: [subject]
+expect:
+  - fixture: sample.mbox#1
+`)
+	require.NoError(t, err)
+
+	results, err := rule.CheckExpectations(filepath.Join("testdata", "sample.mbox"), messages, true)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, ExpectationResult{Rule: "all", Fixture: "sample.mbox#2", Check: CheckUnexpectedMatch, Expected: "false", Actual: "true"}, results[1])
+	assert.Equal(t, "sample.mbox#3", results[2].Fixture)
+}
+
+func TestCheckGolden(t *testing.T) {
+	messages := readSampleMbox(t)
+	rule, err := ParseRuleString(`
+name: invoices
+search:
+  subject_contains: Invoice
+output:
+  format: json
+  fields: [subject, from]
+`)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "golden", "invoices.json")
+
+	result, err := rule.CheckGolden(path, "", messages, false)
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Equal(t, "missing, run with --update", result.Actual)
+
+	result, err = rule.CheckGolden(path, "", messages, true)
+	require.NoError(t, err)
+	assert.True(t, result.Passed)
+	golden, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(golden), `"subject": "Invoice for March"`)
+
+	result, err = rule.CheckGolden(path, "", messages, false)
+	require.NoError(t, err)
+	assert.Equal(t, ExpectationResult{Rule: "invoices", Fixture: path, Check: CheckGolden, Expected: "identical", Actual: "identical", Passed: true}, result)
+
+	result, err = rule.CheckGolden(path, "text", messages, false)
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Equal(t, "line 1: {", result.Expected)
+
+	_, err = rule.CheckGolden(path, "yaml", messages, false)
+	assert.Error(t, err)
+}
+
+func TestPlannedActionsByLabel(t *testing.T) {
+	actions := &ActionConfig{
+		Flags:  &FlagActions{Add: []string{"seen"}},
+		Export: &ExportConfig{Format: "eml", Directory: "out"},
+		ByLabel: map[string]*ActionConfig{
+			"spam": {MoveTo: "Junk"},
+		},
+	}
+	assert.Equal(t, []string{"by_label.spam.move_to"}, plannedActions(actions, "spam"))
+	assert.Equal(t, []string{"flags", "export"}, plannedActions(actions, "personal"))
+	assert.Equal(t, []string{"flags", "export"}, plannedActions(actions, ""))
+}
+
+func TestExpectationValidate(t *testing.T) {
+	no := false
+	assert.Error(t, (&Expectation{}).Validate())
+	assert.Error(t, (&Expectation{Fixture: "a.eml", Match: &no, Actions: []string{"delete"}}).Validate())
+	assert.NoError(t, (&Expectation{Fixture: "a.eml", Match: &no}).Validate())
+}
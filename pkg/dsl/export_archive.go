@@ -0,0 +1,203 @@
+package dsl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveIndexFile is the name of the index written at the end of an export
+// archive.
+const ArchiveIndexFile = "index.json"
+
+// ExportIndex lists the messages of an export archive.
+type ExportIndex struct {
+	Created  time.Time         `json:"created"`
+	Messages []*ExportManifest `json:"messages"`
+}
+
+// exportSink receives the files of an export, by path relative to the export
+// root.
+type exportSink interface {
+	// has reports whether name was already written
+	has(name string) bool
+	write(name string, content []byte) error
+	close() error
+}
+
+// dirSink writes the files of an export into a directory.
+type dirSink struct {
+	dir string
+}
+
+func (s *dirSink) has(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+// write writes to a temporary file first, so an interrupted export never
+// leaves a truncated file behind under the final name.
+func (s *dirSink) write(name string, content []byte) error {
+	full := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+		return fmt.Errorf("failed to create export directory for %s: %w", full, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(full), filepath.Base(full)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), full); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *dirSink) close() error {
+	return nil
+}
+
+// archiveSink streams the files of an export into a zip or tar.gz archive and
+// adds an index of the exported messages at the end. The archive is written
+// to a temporary file and renamed when complete.
+type archiveSink struct {
+	path    string
+	file    *os.File
+	zw      *zip.Writer
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	created time.Time
+	names   map[string]bool
+	index   []*ExportManifest
+}
+
+// archiveFormat returns "zip" or "tar.gz" from the extension of path.
+func archiveFormat(path string) (string, error) {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return "zip", nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar.gz", nil
+	default:
+		return "", fmt.Errorf("archive %q must end in .zip, .tar.gz or .tgz", path)
+	}
+}
+
+func newArchiveSink(path string) (*archiveSink, error) {
+	format, err := archiveFormat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	s := &archiveSink{
+		path:    path,
+		file:    file,
+		created: time.Now().UTC(),
+		names:   make(map[string]bool),
+	}
+	if format == "zip" {
+		s.zw = zip.NewWriter(file)
+	} else {
+		s.gz = gzip.NewWriter(file)
+		s.tw = tar.NewWriter(s.gz)
+	}
+	return s, nil
+}
+
+func (s *archiveSink) has(name string) bool {
+	return s.names[filepath.ToSlash(name)]
+}
+
+func (s *archiveSink) write(name string, content []byte) error {
+	name = filepath.ToSlash(name)
+	if s.names[name] {
+		return fmt.Errorf("archive already contains %s", name)
+	}
+
+	var w io.Writer
+	if s.zw != nil {
+		var err error
+		w, err = s.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.created})
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := s.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(content)),
+			ModTime:  s.created,
+		}); err != nil {
+			return err
+		}
+		w = s.tw
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	s.names[name] = true
+	return nil
+}
+
+// close writes the index and moves the archive to its final name.
+func (s *archiveSink) close() error {
+	err := s.finish()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(s.file.Name())
+		return fmt.Errorf("failed to write archive %s: %w", s.path, err)
+	}
+	if err := os.Rename(s.file.Name(), s.path); err != nil {
+		_ = os.Remove(s.file.Name())
+		return fmt.Errorf("failed to write archive %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *archiveSink) finish() error {
+	index := ExportIndex{Created: s.created, Messages: s.index}
+	if index.Messages == nil {
+		index.Messages = []*ExportManifest{}
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.write(ArchiveIndexFile, data); err != nil {
+		return err
+	}
+	if s.zw != nil {
+		return s.zw.Close()
+	}
+	if err := s.tw.Close(); err != nil {
+		return err
+	}
+	return s.gz.Close()
+}
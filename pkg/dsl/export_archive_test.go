@@ -0,0 +1,107 @@
+package dsl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestArchive(t *testing.T, path string) {
+	sink, err := newArchiveSink(path)
+	require.NoError(t, err)
+	store := &blobStore{sink: sink}
+	for _, uid := range []uint32{1, 2} {
+		filename := filepath.Join("2024", fmt.Sprintf("message-%d.eml", uid))
+		content, blobs, err := dedupAttachments(blobTestMessage("Issue"), store)
+		require.NoError(t, err)
+		require.NoError(t, sink.write(filename, content))
+		sink.index = append(sink.index, &ExportManifest{UID: uid, MessageFile: filepath.ToSlash(filename), Attachments: blobs})
+	}
+	assert.Equal(t, 1, store.stored)
+	assert.Equal(t, 1, store.reused)
+	require.NoError(t, sink.close())
+
+	matches, err := filepath.Glob(path + ".tmp*")
+	require.NoError(t, err)
+	assert.Empty(t, matches, "the temporary archive is renamed")
+}
+
+func TestArchiveSinkZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out", "results.zip")
+	writeTestArchive(t, path)
+
+	zr, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer func() { _ = zr.Close() }()
+
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		files[f.Name] = data
+	}
+	assertArchiveFiles(t, files)
+}
+
+func TestArchiveSinkTarGz(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.tar.gz")
+	writeTestArchive(t, path)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = data
+	}
+	assertArchiveFiles(t, files)
+}
+
+func assertArchiveFiles(t *testing.T, files map[string][]byte) {
+	require.Len(t, files, 4)
+	assert.Contains(t, files, "2024/message-1.eml")
+	assert.Contains(t, files, "2024/message-2.eml")
+
+	var index ExportIndex
+	require.NoError(t, json.Unmarshal(files[ArchiveIndexFile], &index))
+	require.Len(t, index.Messages, 2)
+	assert.Equal(t, uint32(2), index.Messages[1].UID)
+	assert.Equal(t, "2024/message-2.eml", index.Messages[1].MessageFile)
+	require.Len(t, index.Messages[1].Attachments, 1)
+	blob := index.Messages[1].Attachments[0]
+	assert.Equal(t, "%PDF-1.4 report", string(files[blob.Path]))
+}
+
+func TestArchiveFormat(t *testing.T) {
+	format, err := archiveFormat("results.ZIP")
+	require.NoError(t, err)
+	assert.Equal(t, "zip", format)
+	format, err = archiveFormat("out/results.tgz")
+	require.NoError(t, err)
+	assert.Equal(t, "tar.gz", format)
+	_, err = archiveFormat("results.rar")
+	assert.Error(t, err)
+}
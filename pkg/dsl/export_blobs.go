@@ -0,0 +1,211 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// BlobHeader marks an attachment part of a deduplicated export whose content
+// was moved to the blob store. Its value is the SHA-256 of the decoded
+// content.
+const BlobHeader = "X-Smailnail-Blob"
+
+// ExportBlob is an attachment of an exported message kept in the blob store.
+type ExportBlob struct {
+	Filename string `json:"filename,omitempty"`
+	Type     string `json:"type"`
+	Size     int    `json:"size"`
+	SHA256   string `json:"sha256"`
+	// Path is the blob file, relative to the export directory
+	Path string `json:"path"`
+}
+
+// ExportManifest lists the attachments of an exported message that are kept
+// in the blob store. It is written next to the message as
+// <file>.manifest.json, or into the index of an export archive.
+type ExportManifest struct {
+	UID         uint32       `json:"uid"`
+	MessageFile string       `json:"message_file"`
+	Attachments []ExportBlob `json:"attachments,omitempty"`
+}
+
+// blobStore stores attachment contents once by hash under
+// blobs/<first two hex digits>/<sha256> of an export.
+type blobStore struct {
+	sink exportSink
+	// stored and reused count the blobs written and found already stored
+	stored, reused int
+}
+
+func blobPath(hash string) string {
+	return filepath.Join("blobs", hash[:2], hash)
+}
+
+// put stores content unless a blob with its hash exists, and returns the hash
+// and the blob path relative to the export root.
+func (s *blobStore) put(content []byte) (string, string, error) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	rel := blobPath(hash)
+	if s.sink.has(rel) {
+		s.reused++
+		return hash, rel, nil
+	}
+	if err := s.sink.write(rel, content); err != nil {
+		return "", "", fmt.Errorf("failed to store blob %s: %w", hash, err)
+	}
+	s.stored++
+	return hash, rel, nil
+}
+
+// dedupAttachments moves the attachments of raw to store. Each attachment
+// part keeps its headers, gets a BlobHeader with the hash of its content and
+// an empty body. Parts whose transfer encoding cannot be decoded are left in
+// place.
+func dedupAttachments(raw []byte, store *blobStore) ([]byte, []ExportBlob, error) {
+	var blobs []ExportBlob
+	stripped, err := rewriteLeaves(raw, func(w io.Writer, header textproto.Header, body io.Reader) error {
+		mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if !strings.EqualFold(disposition, "attachment") && filename == "" {
+			return copyPart(w, header, body)
+		}
+
+		content, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("failed to read MIME part: %w", err)
+		}
+		decoded, err := decodeTransferEncoding(content, strings.TrimSpace(header.Get("Content-Transfer-Encoding")))
+		if err != nil {
+			return copyPart(w, header, bytes.NewReader(content))
+		}
+
+		hash, rel, err := store.put(decoded)
+		if err != nil {
+			return err
+		}
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		blobs = append(blobs, ExportBlob{
+			Filename: filename,
+			Type:     strings.ToLower(mediaType),
+			Size:     len(decoded),
+			SHA256:   hash,
+			Path:     filepath.ToSlash(rel),
+		})
+
+		header.Del("Content-Transfer-Encoding")
+		header.Set(BlobHeader, hash)
+		return textproto.WriteHeader(w, header)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return stripped, blobs, nil
+}
+
+// RestoreAttachments puts the attachments of a message exported with
+// dedup_attachments back from the blob store of the export directory dir,
+// base64 encoded.
+func RestoreAttachments(raw []byte, dir string) ([]byte, error) {
+	return rewriteLeaves(raw, func(w io.Writer, header textproto.Header, body io.Reader) error {
+		hash := strings.TrimSpace(header.Get(BlobHeader))
+		if hash == "" {
+			return copyPart(w, header, body)
+		}
+		if err := validateSHA256(hash); err != nil {
+			return fmt.Errorf("invalid %s header: %w", BlobHeader, err)
+		}
+		content, err := os.ReadFile(filepath.Join(dir, blobPath(hash)))
+		if err != nil {
+			return fmt.Errorf("failed to read blob: %w", err)
+		}
+		header.Del(BlobHeader)
+		header.Set("Content-Transfer-Encoding", "base64")
+		if err := textproto.WriteHeader(w, header); err != nil {
+			return err
+		}
+		return encodeTransferEncoding(w, content, "base64")
+	})
+}
+
+// writeExportManifest writes the manifest of an exported message next to it.
+func writeExportManifest(sink exportSink, filename string, manifest *ExportManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return sink.write(filename+".manifest.json", data)
+}
+
+// rewriteLeaves copies raw and its multipart structure, passing each
+// single part to leaf, which writes the part's header and body.
+func rewriteLeaves(raw []byte, leaf func(w io.Writer, header textproto.Header, body io.Reader) error) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message header: %w", err)
+	}
+	var b bytes.Buffer
+	if err := rewriteLeafEntity(&b, header, br, leaf); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func rewriteLeafEntity(w io.Writer, header textproto.Header, body io.Reader, leaf func(w io.Writer, header textproto.Header, body io.Reader) error) error {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return leaf(w, header, body)
+	}
+
+	if err := textproto.WriteHeader(w, header); err != nil {
+		return err
+	}
+	boundary := params["boundary"]
+	mr := textproto.NewMultipartReader(body, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read MIME part: %w", err)
+		}
+		if _, err := io.WriteString(w, "--"+boundary+"\r\n"); err != nil {
+			return err
+		}
+		if err := rewriteLeafEntity(w, part.Header, part, leaf); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "--"+boundary+"--\r\n")
+	return err
+}
+
+func copyPart(w io.Writer, header textproto.Header, body io.Reader) error {
+	if err := textproto.WriteHeader(w, header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, body)
+	return err
+}
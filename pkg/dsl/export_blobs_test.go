@@ -0,0 +1,89 @@
+package dsl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emersion/go-message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func blobTestMessage(subject string) []byte {
+	return []byte("From: list@example.com\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=sep\r\n" +
+		"\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"See the attached report.\r\n" +
+		"--sep\r\n" +
+		"Content-Type: application/pdf; name=report.pdf\r\n" +
+		"Content-Disposition: attachment; filename=report.pdf\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"JVBERi0xLjQgcmVwb3J0\r\n" +
+		"--sep--\r\n")
+}
+
+func TestDedupAttachments(t *testing.T) {
+	dir := t.TempDir()
+	store := &blobStore{sink: &dirSink{dir: dir}}
+	sum := sha256.Sum256([]byte("%PDF-1.4 report"))
+	hash := hex.EncodeToString(sum[:])
+
+	var stripped [][]byte
+	for _, subject := range []string{"Issue 1", "Issue 2"} {
+		out, blobs, err := dedupAttachments(blobTestMessage(subject), store)
+		require.NoError(t, err)
+		require.Len(t, blobs, 1)
+		assert.Equal(t, ExportBlob{
+			Filename: "report.pdf",
+			Type:     "application/pdf",
+			Size:     15,
+			SHA256:   hash,
+			Path:     "blobs/" + hash[:2] + "/" + hash,
+		}, blobs[0])
+		assert.Contains(t, string(out), BlobHeader+": "+hash)
+		assert.NotContains(t, string(out), "JVBERi0xLjQgcmVwb3J0")
+		assert.Contains(t, string(out), "See the attached report.")
+		stripped = append(stripped, out)
+	}
+	assert.Equal(t, 1, store.stored)
+	assert.Equal(t, 1, store.reused)
+
+	content, err := os.ReadFile(filepath.Join(dir, "blobs", hash[:2], hash))
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4 report", string(content))
+
+	restored, err := RestoreAttachments(stripped[0], dir)
+	require.NoError(t, err)
+	assert.NotContains(t, string(restored), BlobHeader)
+	entity, err := message.Read(bytes.NewReader(restored))
+	require.NoError(t, err)
+	mr := entity.MultipartReader()
+	require.NotNil(t, mr)
+	_, err = mr.NextPart()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	var body bytes.Buffer
+	_, err = body.ReadFrom(part.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4 report", body.String())
+}
+
+func TestDedupAttachmentsWithoutAttachments(t *testing.T) {
+	store := &blobStore{sink: &dirSink{dir: t.TempDir()}}
+	raw := []byte("From: a@example.com\r\nSubject: hi\r\n\r\nplain text\r\n")
+	out, blobs, err := dedupAttachments(raw, store)
+	require.NoError(t, err)
+	assert.Empty(t, blobs)
+	assert.Equal(t, string(raw), string(out))
+}
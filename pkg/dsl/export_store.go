@@ -0,0 +1,133 @@
+package dsl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ExportStore receives the messages of an export format that writes all of
+// them into a single database file, like the sqlite format registered by
+// the sqliteexport package. Records are the ones a jsonl export writes,
+// after redaction, anonymization and attachment deduplication.
+type ExportStore interface {
+	Add(record *CorpusRecord) error
+	// Close finishes the database file
+	Close() error
+}
+
+// ExportStoreFactory creates the database file of an export at path, for
+// the export action configured by config.
+type ExportStoreFactory func(path string, config *ExportConfig) (ExportStore, error)
+
+type exportStoreFormat struct {
+	file    string
+	factory ExportStoreFactory
+}
+
+var (
+	exportStoresMu sync.RWMutex
+	exportStores   = map[string]exportStoreFormat{}
+)
+
+// RegisterExportStore adds the export format called format, which writes
+// file into the export directory or archive through the stores factory
+// creates. Programs register formats before parsing rules, usually from an
+// init function.
+func RegisterExportStore(format, file string, factory ExportStoreFactory) error {
+	if format == "" || file == "" {
+		return fmt.Errorf("export format and file are required")
+	}
+	if factory == nil {
+		return fmt.Errorf("export store factory for %s is nil", format)
+	}
+	switch format {
+	case "eml", "mbox", ExportFormatMarkdown, ExportFormatJSONL:
+		return fmt.Errorf("export format %s is a built-in format and cannot be overridden", format)
+	}
+	exportStoresMu.Lock()
+	defer exportStoresMu.Unlock()
+	if _, ok := exportStores[format]; ok {
+		return fmt.Errorf("export format %s is already registered", format)
+	}
+	exportStores[format] = exportStoreFormat{file: file, factory: factory}
+	return nil
+}
+
+func lookupExportStore(format string) (exportStoreFormat, bool) {
+	exportStoresMu.RLock()
+	defer exportStoresMu.RUnlock()
+	store, ok := exportStores[format]
+	return store, ok
+}
+
+// exportFormatNames lists the valid export formats, for errors.
+func exportFormatNames() string {
+	names := []string{"'eml'", "'mbox'", "'markdown'", "'jsonl'"}
+	exportStoresMu.RLock()
+	var registered []string
+	for format := range exportStores {
+		registered = append(registered, "'"+format+"'")
+	}
+	exportStoresMu.RUnlock()
+	sort.Strings(registered)
+	names = append(names, registered...)
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// isExportFormat reports whether format is built in or registered.
+func isExportFormat(format string) bool {
+	switch format {
+	case "eml", "mbox", ExportFormatMarkdown, ExportFormatJSONL:
+		return true
+	}
+	_, ok := lookupExportStore(format)
+	return ok
+}
+
+// exportStoreRun is the store of a running export. The database is built in
+// a temporary file and written to the export sink once complete, like the
+// corpus of a jsonl export.
+type exportStoreRun struct {
+	format exportStoreFormat
+	dir    string
+	store  ExportStore
+}
+
+func newExportStoreRun(format exportStoreFormat, config *ExportConfig) (*exportStoreRun, error) {
+	dir, err := os.MkdirTemp("", "smailnail-export-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary export directory: %w", err)
+	}
+	store, err := format.factory(filepath.Join(dir, format.file), config)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create %s: %w", format.file, err)
+	}
+	return &exportStoreRun{format: format, dir: dir, store: store}, nil
+}
+
+// finish closes the store and writes its file to sink.
+func (r *exportStoreRun) finish(sink exportSink) error {
+	defer func() { _ = os.RemoveAll(r.dir) }()
+	if err := r.store.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s: %w", r.format.file, err)
+	}
+	content, err := os.ReadFile(filepath.Join(r.dir, r.format.file))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", r.format.file, err)
+	}
+	if err := sink.write(r.format.file, content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.format.file, err)
+	}
+	return nil
+}
+
+// abort closes the store and drops its file.
+func (r *exportStoreRun) abort() {
+	_ = r.store.Close()
+	_ = os.RemoveAll(r.dir)
+}
@@ -0,0 +1,35 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExportStore struct {
+	records []*CorpusRecord
+}
+
+func (s *fakeExportStore) Add(record *CorpusRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *fakeExportStore) Close() error { return nil }
+
+func TestRegisterExportStore(t *testing.T) {
+	factory := func(path string, config *ExportConfig) (ExportStore, error) {
+		return &fakeExportStore{}, nil
+	}
+	require.NoError(t, RegisterExportStore("test-store", "test.db", factory))
+	assert.Error(t, RegisterExportStore("test-store", "test.db", factory))
+	assert.Error(t, RegisterExportStore(ExportFormatJSONL, "test.db", factory))
+	assert.Contains(t, exportFormatNames(), "'test-store'")
+
+	assert.NoError(t, (&ExportConfig{Format: "test-store", BodyChars: 100}).Validate())
+	assert.Error(t, (&ExportConfig{Format: "test-store", FilenameTemplate: "{{ .UID }}"}).Validate())
+	assert.Error(t, (&ExportConfig{Format: "test-store", BodyChars: -1}).Validate())
+	assert.Error(t, (&ExportConfig{Format: "eml", BodyChars: 100}).Validate())
+	assert.Error(t, (&ExportConfig{Format: "unknown"}).Validate())
+}
@@ -0,0 +1,148 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// ExprFilter evaluates a `search.expr` predicate against fetched messages.
+//
+// The expression is an expr-lang (https://expr-lang.org) expression that
+// must return a boolean. It sees the following variables:
+//
+//	uid, size, subject, date (RFC 3339 string), timestamp (unix seconds),
+//	age_days, from / to ({address, name, local, domain}; to is a list),
+//	flags ({seen, answered, flagged, deleted, draft, list}), body (text parts),
+//	label (the classify label, "" when the rule does not classify)
+//
+// Example: `from.domain == "example.com" && size > 1_000_000 && !flags.seen`.
+type ExprFilter struct {
+	source  string
+	program *vm.Program
+	now     func() time.Time
+}
+
+// CompileExpr parses an expression and returns a filter for it. Unknown
+// variables and non-boolean results are rejected.
+func CompileExpr(source string) (*ExprFilter, error) {
+	f := &ExprFilter{source: source, now: time.Now}
+	program, err := expr.Compile(source, expr.Env(f.environment(&EmailMessage{})), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	f.program = program
+	return f, nil
+}
+
+// Match reports whether msg satisfies the expression.
+func (f *ExprFilter) Match(msg *EmailMessage) (bool, error) {
+	value, err := expr.Run(f.program, f.environment(msg))
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate expression %q for message %d: %w", f.source, msg.UID, err)
+	}
+	ok, _ := value.(bool)
+	return ok, nil
+}
+
+// Filter returns the messages matching the expression, preserving order.
+func (f *ExprFilter) Filter(messages []*EmailMessage) ([]*EmailMessage, error) {
+	ret := make([]*EmailMessage, 0, len(messages))
+	for _, msg := range messages {
+		ok, err := f.Match(msg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, msg)
+		}
+	}
+	return ret, nil
+}
+
+func (f *ExprFilter) environment(msg *EmailMessage) map[string]interface{} {
+	env := map[string]interface{}{
+		"uid":       msg.UID,
+		"size":      msg.Size,
+		"subject":   "",
+		"date":      "",
+		"timestamp": int64(0),
+		"age_days":  0.0,
+		"from":      exprAddress(EmailAddress{}),
+		"to":        []interface{}{},
+		"flags":     exprFlags(msg.Flags),
+		"body":      exprBody(msg.MimeParts),
+		"label":     msg.Label,
+	}
+
+	if msg.Envelope != nil {
+		env["subject"] = msg.Envelope.Subject
+		if !msg.Envelope.Date.IsZero() {
+			env["date"] = msg.Envelope.Date.Format(time.RFC3339)
+			env["timestamp"] = msg.Envelope.Date.Unix()
+			env["age_days"] = f.now().Sub(msg.Envelope.Date).Hours() / 24
+		}
+		if len(msg.Envelope.From) > 0 {
+			env["from"] = exprAddress(msg.Envelope.From[0])
+		}
+		to := make([]interface{}, 0, len(msg.Envelope.To))
+		for _, addr := range msg.Envelope.To {
+			to = append(to, exprAddress(addr))
+		}
+		env["to"] = to
+	}
+
+	return env
+}
+
+func exprAddress(addr EmailAddress) map[string]interface{} {
+	local, domain := addr.Address, ""
+	if idx := strings.LastIndex(addr.Address, "@"); idx >= 0 {
+		local, domain = addr.Address[:idx], addr.Address[idx+1:]
+	}
+	return map[string]interface{}{
+		"address": strings.ToLower(addr.Address),
+		"name":    addr.Name,
+		"local":   strings.ToLower(local),
+		"domain":  strings.ToLower(domain),
+	}
+}
+
+func exprFlags(flags []string) map[string]interface{} {
+	ret := map[string]interface{}{
+		"seen":     false,
+		"answered": false,
+		"flagged":  false,
+		"deleted":  false,
+		"draft":    false,
+	}
+	list := make([]interface{}, 0, len(flags))
+	for _, flag := range flags {
+		list = append(list, flag)
+		name := strings.ToLower(strings.TrimPrefix(flag, "\\"))
+		if _, ok := ret[name]; ok && strings.HasPrefix(flag, "\\") {
+			ret[name] = true
+		}
+	}
+	ret["list"] = list
+	return ret
+}
+
+func exprBody(parts []MimePart) string {
+	var sb strings.Builder
+	for _, part := range parts {
+		// Fetched parts carry the full media type ("text/plain") in Type
+		mediaType := strings.ToLower(part.Type)
+		if mediaType != "text" && !strings.HasPrefix(mediaType, "text/") {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(part.Content)
+	}
+	return sb.String()
+}
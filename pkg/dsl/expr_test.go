@@ -0,0 +1,139 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprFilterMatch(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	msg := &EmailMessage{
+		UID:   42,
+		Size:  2_000_000,
+		Flags: []string{"\\Flagged", "$Important"},
+		Envelope: &EmailEnvelope{
+			Subject: "Quarterly report",
+			Date:    now.Add(-72 * time.Hour),
+			From:    []EmailAddress{{Name: "Alice", Address: "Alice@Example.com"}},
+			To:      []EmailAddress{{Address: "bob@example.org"}, {Address: "carol@example.net"}},
+		},
+		MimeParts: []MimePart{
+			{Type: "text/plain", Content: "see attached numbers"},
+			{Type: "image/png", Content: "binary"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{"domain size and unseen", `from.domain == "example.com" && size > 1_000_000 && !flags.seen`, true},
+		{"seen flag", `flags.seen`, false},
+		{"flagged", `flags.flagged && "$Important" in flags.list`, true},
+		{"recipient count", `len(to) == 2 && to[1].domain == "example.net"`, true},
+		{"age", `age_days > 2 && age_days < 4`, true},
+		{"subject regex", `subject matches "(?i)quarterly"`, true},
+		{"body", `body contains "numbers" && !(body contains "binary")`, true},
+		{"uid", `uid == 41`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := CompileExpr(tt.expr)
+			require.NoError(t, err)
+			filter.now = func() time.Time { return now }
+
+			got, err := filter.Match(msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExprFilterWithoutEnvelope(t *testing.T) {
+	filter, err := CompileExpr(`from.domain == "" && subject == "" && len(to) == 0`)
+	require.NoError(t, err)
+
+	got, err := filter.Filter([]*EmailMessage{{UID: 1}, {UID: 2}})
+	require.NoError(t, err)
+	assert.Len(t, got, 2)
+}
+
+func TestExprFilterRuntimeError(t *testing.T) {
+	filter, err := CompileExpr(`to[3].domain == "example.com"`)
+	require.NoError(t, err)
+
+	_, err = filter.Match(&EmailMessage{UID: 7})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "message 7")
+}
+
+func TestExprFilterCompileErrors(t *testing.T) {
+	for _, source := range []string{
+		`nope.field == 1`,
+		`subject`,
+		`size >`,
+	} {
+		_, err := CompileExpr(source)
+		assert.Error(t, err, source)
+	}
+}
+
+func TestSearchExprValidation(t *testing.T) {
+	_, err := ParseRuleString(`
+name: expr
+search:
+  expr: "from.domain == "
+output:
+  fields: [uid]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid expression")
+
+	_, err = ParseRuleString(`
+name: expr
+search:
+  operator: or
+  conditions:
+    - expr: "size > 1"
+output:
+  fields: [uid]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "top level")
+
+	rule, err := ParseRuleString(`
+name: expr
+search:
+  expr: "size > 1_000"
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	criteria, _, err := BuildSearchCriteria(rule.Search, nil)
+	require.NoError(t, err)
+	assert.Empty(t, criteria.Header)
+}
+
+// The fixture is hand-written: the newest candidate does not pass the
+// expression, so the one before it is fetched to fill the limit.
+func TestReplayExprLimit(t *testing.T) {
+	rule, err := ParseRuleFile("testdata/imap/expr_limit.yaml")
+	require.NoError(t, err)
+	client, done := replayClient(t, "testdata/imap/expr_limit.imap")
+
+	_, err = SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages, err := rule.FetchMessages(client)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, uint32(2), messages[0].UID)
+	assert.Equal(t, "invoice 2", messages[0].Envelope.Subject)
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
@@ -0,0 +1,226 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// DefaultExpungeBatchSize is the number of messages Expunge removes per
+// round trip.
+const DefaultExpungeBatchSize = 500
+
+// ExpungeConfig selects the messages Expunge permanently removes.
+type ExpungeConfig struct {
+	Mailbox string
+	// OlderThan is an age such as "90d"; messages whose internal date is at
+	// least that old are removed
+	OlderThan string
+	// DeletedOnly restricts Expunge to messages already flagged \Deleted
+	DeletedOnly bool
+	BatchSize   int
+	// DryRun only counts the matching messages and their size
+	DryRun bool
+	// AllowProtected are glob patterns of protected mailboxes to expunge
+	// anyway
+	AllowProtected []string
+}
+
+// Validate checks if the expunge configuration is valid
+func (c *ExpungeConfig) Validate() error {
+	if c.Mailbox == "" {
+		return fmt.Errorf("expunge requires a mailbox")
+	}
+	if c.OlderThan == "" {
+		return fmt.Errorf("expunge requires an age threshold (use 0d to remove every message)")
+	}
+	if _, err := c.Before(time.Now()); err != nil {
+		return err
+	}
+	return ValidateMailboxPatterns(c.AllowProtected)
+}
+
+// Before returns the date messages have to be older than, or the zero time
+// for an age of 0, which matches every message.
+func (c *ExpungeConfig) Before(now time.Time) (time.Time, error) {
+	d, err := parseRelativeDuration(c.OlderThan)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid older-than %q: %w", c.OlderThan, err)
+	}
+	if d == 0 {
+		return time.Time{}, nil
+	}
+	return now.Add(-d), nil
+}
+
+// CheckProtected returns an error when the mailbox matches one of protected
+// and none of AllowProtected, as Rule.CheckProtected does for rules.
+func (c *ExpungeConfig) CheckProtected(protected []string) error {
+	pattern, ok := matchMailbox(protected, c.Mailbox)
+	if !ok {
+		return nil
+	}
+	if _, allowed := matchMailbox(c.AllowProtected, c.Mailbox); allowed {
+		return nil
+	}
+	return fmt.Errorf("expunge would delete messages in protected mailbox %s (matches %q), pass --allow-protected to proceed",
+		c.Mailbox, pattern)
+}
+
+// searchConfig returns the rule search for the messages to remove. IMAP
+// compares dates without the time of day, so messages from the day of the
+// cutoff are kept.
+func (c *ExpungeConfig) searchConfig(now time.Time) (SearchConfig, error) {
+	before, err := c.Before(now)
+	if err != nil {
+		return SearchConfig{}, err
+	}
+	search := SearchConfig{}
+	if !before.IsZero() {
+		search.Before = before.Format("2006-01-02")
+	}
+	if c.DeletedOnly {
+		search.Flags = &FlagCriteria{Has: []string{"deleted"}}
+	}
+	return search, nil
+}
+
+// ExpungeResult reports what Expunge removed.
+type ExpungeResult struct {
+	Matched  int
+	Expunged int
+	// Size is the total RFC822 size of the matched messages in bytes
+	Size int64
+}
+
+// Expunge permanently removes the messages of the mailbox matching config in
+// batches of config.BatchSize UIDs, flagging them \Deleted and expunging
+// them with UID EXPUNGE. Servers without UIDPLUS only offer EXPUNGE, which
+// removes every \Deleted message, so Expunge refuses to run there while
+// messages it did not select are flagged \Deleted. progress, if not nil, is
+// called after each batch with the number of messages removed so far and
+// the total.
+func Expunge(client *imapclient.Client, config *ExpungeConfig, progress func(done, total int)) (*ExpungeResult, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	search, err := config.searchConfig(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	criteria, _, err := BuildSearchCriteria(search, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.Select(config.Mailbox, &imap.SelectOptions{ReadOnly: config.DryRun}).Wait(); err != nil {
+		return nil, fmt.Errorf("failed to select mailbox %q: %w", config.Mailbox, wrapMailboxError(err, config.Mailbox))
+	}
+	data, err := client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages to expunge: %w", wrapSearchError(err))
+	}
+	uids := data.AllUIDs()
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	result := &ExpungeResult{Matched: len(uids)}
+	if len(uids) == 0 {
+		return result, nil
+	}
+
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultExpungeBatchSize
+	}
+	batches := uidBatches(uids, batchSize)
+	for _, batch := range batches {
+		size, err := fetchTotalSize(client, batch)
+		if err != nil {
+			return result, err
+		}
+		result.Size += size
+	}
+	if config.DryRun {
+		return result, nil
+	}
+
+	uidPlus := client.Caps().Has(imap.CapUIDPlus)
+	if !uidPlus {
+		var selected imap.UIDSet
+		for _, batch := range batches {
+			selected = append(selected, batch...)
+		}
+		if err := checkNoOtherDeleted(client, selected); err != nil {
+			return result, err
+		}
+	}
+
+	for _, batch := range batches {
+		if _, err := client.Store(batch, &imap.StoreFlags{
+			Op:     imap.StoreFlagsAdd,
+			Silent: true,
+			Flags:  []imap.Flag{imap.FlagDeleted},
+		}, nil).Collect(); err != nil {
+			return result, fmt.Errorf("failed to mark messages as deleted: %w", err)
+		}
+		var expunge *imapclient.ExpungeCommand
+		if uidPlus {
+			expunge = client.UIDExpunge(batch)
+		} else {
+			expunge = client.Expunge()
+		}
+		seqNums, err := expunge.Collect()
+		if err != nil {
+			return result, fmt.Errorf("failed to expunge messages: %w", err)
+		}
+		result.Expunged += len(seqNums)
+		if progress != nil {
+			progress(result.Expunged, result.Matched)
+		}
+	}
+	return result, nil
+}
+
+// uidBatches splits uids into sets of at most size UIDs.
+func uidBatches(uids []imap.UID, size int) []imap.UIDSet {
+	var ret []imap.UIDSet
+	for start := 0; start < len(uids); start += size {
+		end := start + size
+		if end > len(uids) {
+			end = len(uids)
+		}
+		ret = append(ret, imap.UIDSetNum(uids[start:end]...))
+	}
+	return ret
+}
+
+// fetchTotalSize returns the total RFC822 size of the messages.
+func fetchTotalSize(client *imapclient.Client, uids imap.UIDSet) (int64, error) {
+	fetched, err := client.Fetch(uids, &imap.FetchOptions{UID: true, RFC822Size: true}).Collect()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch message sizes: %w", err)
+	}
+	var total int64
+	for _, msg := range fetched {
+		total += msg.RFC822Size
+	}
+	return total, nil
+}
+
+// checkNoOtherDeleted returns an error if messages outside selected are
+// flagged \Deleted, since EXPUNGE would remove them too.
+func checkNoOtherDeleted(client *imapclient.Client, selected imap.UIDSet) error {
+	data, err := client.UIDSearch(&imap.SearchCriteria{
+		Flag: []imap.Flag{imap.FlagDeleted},
+		Not:  []imap.SearchCriteria{{UID: []imap.UIDSet{selected}}},
+	}, nil).Wait()
+	if err != nil {
+		return fmt.Errorf("failed to search deleted messages: %w", wrapSearchError(err))
+	}
+	if others := data.AllUIDs(); len(others) > 0 {
+		return fmt.Errorf("server lacks UIDPLUS and %d other messages are flagged \\Deleted, EXPUNGE would remove them too", len(others))
+	}
+	return nil
+}
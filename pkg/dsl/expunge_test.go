@@ -0,0 +1,49 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpungeConfig(t *testing.T) {
+	now := time.Date(2025, 6, 30, 8, 0, 0, 0, time.UTC)
+
+	config := &ExpungeConfig{Mailbox: "Trash", OlderThan: "90d", DeletedOnly: true}
+	require.NoError(t, config.Validate())
+	search, err := config.searchConfig(now)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04-01", search.Before)
+
+	criteria, _, err := BuildSearchCriteria(search, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []imap.Flag{imap.FlagDeleted}, criteria.Flag)
+
+	search, err = (&ExpungeConfig{Mailbox: "Trash", OlderThan: "0d"}).searchConfig(now)
+	require.NoError(t, err)
+	assert.Empty(t, search.Before)
+
+	assert.ErrorContains(t, (&ExpungeConfig{Mailbox: "Trash"}).Validate(), "requires an age threshold")
+	assert.ErrorContains(t, (&ExpungeConfig{Mailbox: "Trash", OlderThan: "old"}).Validate(), "invalid older-than")
+}
+
+func TestExpungeCheckProtected(t *testing.T) {
+	config := &ExpungeConfig{Mailbox: "Sent", OlderThan: "90d"}
+	assert.ErrorContains(t, config.CheckProtected(DefaultProtectedMailboxes), "protected mailbox Sent")
+
+	config.AllowProtected = []string{"sent"}
+	assert.NoError(t, config.CheckProtected(DefaultProtectedMailboxes))
+
+	assert.NoError(t, (&ExpungeConfig{Mailbox: "Trash"}).CheckProtected(DefaultProtectedMailboxes))
+}
+
+func TestUIDBatches(t *testing.T) {
+	batches := uidBatches([]imap.UID{1, 2, 3, 7, 9}, 2)
+	require.Len(t, batches, 3)
+	assert.Equal(t, "1:2", batches[0].String())
+	assert.Equal(t, "3,7", batches[1].String())
+	assert.Equal(t, "9", batches[2].String())
+}
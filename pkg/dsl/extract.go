@@ -0,0 +1,321 @@
+package dsl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"golang.org/x/net/html"
+)
+
+// ExtractConfig pulls output fields out of message bodies, such as the
+// order number and amount of a receipt. A pattern is matched against the
+// plain text (the HTML parts converted to text when there is none) and each
+// of its named groups becomes a field; a selector picks elements of the
+// HTML parts, whose text becomes the field Name.
+type ExtractConfig struct {
+	// Pattern is a regular expression; a pattern without named groups sets
+	// Name to its first group, or to the whole match
+	Pattern string `yaml:"pattern,omitempty"`
+	// Selector is a CSS selector evaluated against the HTML parts
+	Selector string `yaml:"selector,omitempty"`
+	// Name is the field set by a selector or by a pattern without named
+	// groups
+	Name string `yaml:"name,omitempty"`
+	// Attr takes the value of an attribute of the selected elements, such
+	// as href, instead of their text
+	Attr string `yaml:"attr,omitempty"`
+	// All collects every match into a list instead of keeping the first
+	All bool `yaml:"all,omitempty"`
+}
+
+// Validate checks if the extract config is valid
+func (c *ExtractConfig) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+// extractor is a compiled ExtractConfig.
+type extractor struct {
+	config   *ExtractConfig
+	pattern  *regexp.Regexp
+	selector cssSelector
+	// fields are the names the extractor sets
+	fields []string
+}
+
+func (c *ExtractConfig) compile() (*extractor, error) {
+	e := &extractor{config: c}
+	switch {
+	case c.Pattern != "" && c.Selector != "":
+		return nil, fmt.Errorf("pattern and selector cannot be combined")
+	case c.Pattern != "":
+		pattern, err := regexp.Compile(c.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		e.pattern = pattern
+		for _, name := range pattern.SubexpNames() {
+			if name != "" {
+				e.fields = append(e.fields, name)
+			}
+		}
+		if c.Attr != "" {
+			return nil, fmt.Errorf("attr is only used with a selector")
+		}
+		if len(e.fields) > 0 && c.Name != "" {
+			return nil, fmt.Errorf("name cannot be combined with the named groups of the pattern")
+		}
+	case c.Selector != "":
+		selector, err := parseCSSSelector(c.Selector)
+		if err != nil {
+			return nil, err
+		}
+		e.selector = selector
+		if c.Name == "" {
+			return nil, fmt.Errorf("a selector needs a name")
+		}
+	default:
+		return nil, fmt.Errorf("pattern or selector is required")
+	}
+	if len(e.fields) == 0 {
+		if c.Name == "" {
+			return nil, fmt.Errorf("a pattern without named groups needs a name")
+		}
+		e.fields = []string{c.Name}
+	}
+	for _, name := range e.fields {
+		if outputFieldNames[name] {
+			return nil, fmt.Errorf("extracted field %s shadows the built-in output field", name)
+		}
+		if strings.ContainsAny(name, ".[ ") {
+			return nil, fmt.Errorf("invalid extracted field name %q", name)
+		}
+	}
+	return e, nil
+}
+
+// extractedFields returns the names set by the extract patterns and
+// selectors.
+func (o *OutputConfig) extractedFields() map[string]bool {
+	names := map[string]bool{}
+	for i := range o.Extract {
+		e, err := o.Extract[i].compile()
+		if err != nil {
+			continue
+		}
+		for _, name := range e.fields {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// extractBody holds the bodies of a message, parsed when first needed.
+type extractBody struct {
+	parts []MimePart
+	text  *string
+	docs  []*html.Node
+}
+
+func (b *extractBody) plainText() string {
+	if b.text != nil {
+		return *b.text
+	}
+	var plain, htmlParts []MimePart
+	for _, part := range b.parts {
+		switch {
+		case isMediaType(part, "text/plain"):
+			plain = append(plain, part)
+		case isMediaType(part, "text/html"):
+			htmlParts = append(htmlParts, part)
+		}
+	}
+	text := exprBody(plain)
+	if len(plain) == 0 {
+		texts := make([]string, 0, len(htmlParts))
+		for _, part := range htmlParts {
+			doc, err := html.Parse(strings.NewReader(part.Content))
+			if err == nil {
+				texts = append(texts, htmlNodeText(doc))
+			}
+		}
+		text = strings.Join(texts, "\n")
+	}
+	b.text = &text
+	return text
+}
+
+func (b *extractBody) htmlDocs() []*html.Node {
+	if b.docs != nil {
+		return b.docs
+	}
+	b.docs = []*html.Node{}
+	for _, part := range b.parts {
+		if !isMediaType(part, "text/html") {
+			continue
+		}
+		if doc, err := html.Parse(strings.NewReader(part.Content)); err == nil {
+			b.docs = append(b.docs, doc)
+		}
+	}
+	return b.docs
+}
+
+// isMediaType reports whether part has the media type mediaType. Fetched
+// parts carry it in Type, or split over Type and Subtype.
+func isMediaType(part MimePart, mediaType string) bool {
+	t := strings.ToLower(part.Type)
+	if part.Subtype != "" && !strings.Contains(t, "/") {
+		t += "/" + strings.ToLower(part.Subtype)
+	}
+	return t == mediaType
+}
+
+// apply sets the fields of e from body.
+func (e *extractor) apply(body *extractBody, values map[string]interface{}) {
+	if e.pattern != nil {
+		e.applyPattern(body.plainText(), values)
+		return
+	}
+	var found []string
+	for _, doc := range body.htmlDocs() {
+		for _, n := range e.selector.selectAll(doc) {
+			value := htmlNodeText(n)
+			if e.config.Attr != "" {
+				value, _ = htmlNodeAttr(n, strings.ToLower(e.config.Attr))
+				value = strings.TrimSpace(value)
+			}
+			if value == "" {
+				continue
+			}
+			found = append(found, value)
+			if !e.config.All {
+				values[e.config.Name] = value
+				return
+			}
+		}
+	}
+	if e.config.All && len(found) > 0 {
+		values[e.config.Name] = found
+	}
+}
+
+func (e *extractor) applyPattern(text string, values map[string]interface{}) {
+	matches := e.pattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return
+	}
+	if !e.config.All {
+		matches = matches[:1]
+	}
+	collected := map[string][]string{}
+	for _, match := range matches {
+		if e.config.Name != "" {
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			collected[e.config.Name] = append(collected[e.config.Name], strings.TrimSpace(value))
+			continue
+		}
+		for i, name := range e.pattern.SubexpNames() {
+			if name != "" {
+				collected[name] = append(collected[name], strings.TrimSpace(match[i]))
+			}
+		}
+	}
+	for name, found := range collected {
+		if e.config.All {
+			values[name] = found
+		} else {
+			values[name] = found[0]
+		}
+	}
+}
+
+// extract returns the function setting the extracted fields and the HTML
+// tables of messages, or nil if the output has neither. fetchParts retrieves the parts of
+// messages whose content was not fetched; it may be nil. Failures are
+// logged and leave the fields unset.
+func (rule *Rule) extract(fetchParts func(*EmailMessage) ([]MimePart, error)) func(*EmailMessage) {
+	logger := rule.Logger()
+	tables := rule.outputsHTMLTables()
+	if len(rule.Output.Extract) == 0 && !tables {
+		return nil
+	}
+	extractors := make([]*extractor, 0, len(rule.Output.Extract))
+	for i := range rule.Output.Extract {
+		e, err := rule.Output.Extract[i].compile()
+		if err != nil {
+			logger.Warn().Err(err).Int("extract", i).Msg("Skipping invalid extract")
+			continue
+		}
+		extractors = append(extractors, e)
+	}
+
+	return func(msg *EmailMessage) {
+		parts := msg.MimeParts
+		if !partsHaveContent(parts) {
+			parts = rawMessageParts(msg.RawContent[""])
+		}
+		if !partsHaveContent(parts) && fetchParts != nil {
+			fetched, err := fetchParts(msg)
+			if err != nil {
+				logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Failed to fetch message body for extraction")
+				return
+			}
+			parts = fetched
+		}
+		body := &extractBody{parts: parts}
+		if len(extractors) > 0 {
+			values := map[string]interface{}{}
+			for _, e := range extractors {
+				e.apply(body, values)
+			}
+			msg.Extracted = values
+		}
+		if tables {
+			msg.HTMLTables = parseHTMLTables(body.htmlDocs(), rule.Output.HTMLTables)
+		}
+	}
+}
+
+func partsHaveContent(parts []MimePart) bool {
+	for _, part := range parts {
+		if part.Content != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// rawMessageParts returns the parts of a raw message, with their content.
+func rawMessageParts(raw []byte) []MimePart {
+	if len(raw) == 0 {
+		return nil
+	}
+	local, err := ParseLocalMessage(raw, 0, nil, time.Time{})
+	if err != nil {
+		return nil
+	}
+	return local.Message.MimeParts
+}
+
+// fetchMessageParts fetches a full message and returns its parts.
+func fetchMessageParts(client *imapclient.Client, msg *EmailMessage) ([]MimePart, error) {
+	var uidSet imap.UIDSet
+	uidSet.AddNum(imap.UID(msg.UID))
+	section := &imap.FetchItemBodySection{Peek: true}
+	fetched, err := client.Fetch(uidSet, &imap.FetchOptions{UID: true, BodySection: []*imap.FetchItemBodySection{section}}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message %d: %w", msg.UID, err)
+	}
+	if len(fetched) == 0 {
+		return nil, nil
+	}
+	return rawMessageParts(fetched[0].FindBodySection(section)), nil
+}
@@ -0,0 +1,147 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+const receiptHTML = `<html><head><style>.total { color: red }</style></head><body>
+<table id="items">
+  <tr class="item"><td class="name">Coffee beans</td><td class="price">$12.50</td></tr>
+  <tr class="item gift"><td class="name">Mug</td><td class="price">$8.00</td></tr>
+</table>
+<p class="total">Total: <b>$20.50</b></p>
+<a href="https://shop.example.com/orders/4711" data-kind="order">View order</a>
+</body></html>`
+
+func TestOutputExtract(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: receipts
+search:
+  subject_contains: receipt
+output:
+  fields: [uid, order, amount, items, order_url, currency]
+  extract:
+    - pattern: 'Order #(?P<order>\d+)'
+    - pattern: 'Total: \$(?P<amount>[\d.]+)'
+    - selector: "#items tr.item > td.name"
+      name: items
+      all: true
+    - selector: 'a[data-kind="order"]'
+      attr: href
+      name: order_url
+    - pattern: 'paid in ([A-Z]{3})'
+      name: currency
+`)
+	require.NoError(t, err)
+	require.Len(t, rule.Output.Extract, 5)
+	for _, issue := range rule.Lint() {
+		assert.NotEqual(t, LintUnknownField, issue.Code, issue.Message)
+	}
+
+	msg := &EmailMessage{
+		UID: 3,
+		MimeParts: []MimePart{
+			{Type: "text/plain", Content: "Thanks for your order!\nOrder #4711\nTotal: $20.50, paid in EUR\n"},
+			{Type: "text/html", Content: receiptHTML},
+		},
+	}
+	rule.extract(nil)(msg)
+	assert.Equal(t, map[string]interface{}{
+		"order":     "4711",
+		"amount":    "20.50",
+		"items":     []string{"Coffee beans", "Mug"},
+		"order_url": "https://shop.example.com/orders/4711",
+		"currency":  "EUR",
+	}, msg.Extracted)
+
+	output := jsonOutput(msg, rule.Output)
+	require.Len(t, output, 6)
+	assert.Equal(t, "order", output[1].Key)
+	assert.Equal(t, "4711", output[1].Value)
+
+	item, err := SelectPath(msg, "extracted.items[1]")
+	require.NoError(t, err)
+	assert.Equal(t, "Mug", item)
+
+	// Messages the patterns do not match leave the fields out
+	other := &EmailMessage{UID: 4, MimeParts: []MimePart{{Type: "text/plain", Content: "Hello"}}}
+	rule.extract(nil)(other)
+	assert.Empty(t, other.Extracted)
+	assert.Len(t, jsonOutput(other, rule.Output), 1)
+}
+
+func TestOutputExtractHTMLOnly(t *testing.T) {
+	config := &ExtractConfig{Pattern: `Total: \$(?P<amount>[\d.]+)`}
+	e, err := config.compile()
+	require.NoError(t, err)
+
+	values := map[string]interface{}{}
+	e.apply(&extractBody{parts: []MimePart{{Type: "text", Subtype: "html", Content: receiptHTML}}}, values)
+	assert.Equal(t, "20.50", values["amount"])
+}
+
+func TestOutputExtractRawMessage(t *testing.T) {
+	rule := &Rule{Output: OutputConfig{Extract: []ExtractConfig{{Pattern: `ticket (\w+-\d+)`, Name: "ticket"}}}}
+	raw := "From: desk@example.com\r\nSubject: Update\r\nContent-Type: text/plain\r\n\r\nYour ticket SUP-12 was closed.\r\n"
+	msg := &EmailMessage{UID: 1, RawContent: map[string][]byte{"": []byte(raw)}}
+	rule.extract(nil)(msg)
+	assert.Equal(t, "SUP-12", msg.Extracted["ticket"])
+}
+
+func TestExtractConfigValidate(t *testing.T) {
+	for _, config := range []ExtractConfig{
+		{},
+		{Pattern: `(\d+)`},
+		{Pattern: `(?P<a>\d+)`, Name: "b"},
+		{Pattern: `(?P<a>\d+`},
+		{Pattern: `(?P<subject>.+)`},
+		{Pattern: `\d+`, Name: "n", Attr: "href"},
+		{Selector: "td"},
+		{Selector: "td:first-child", Name: "n"},
+		{Selector: "a + b", Name: "n"},
+		{Selector: "td[class", Name: "n"},
+		{Pattern: `\d+`, Selector: "td", Name: "n"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+
+	output := OutputConfig{
+		Fields: []interface{}{Field{Name: "uid"}},
+		Extract: []ExtractConfig{
+			{Pattern: `(?P<order>\d+)`},
+			{Selector: "b", Name: "order"},
+		},
+	}
+	assert.ErrorContains(t, output.Validate(), "extracted twice")
+}
+
+func TestCSSSelector(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(receiptHTML))
+	require.NoError(t, err)
+
+	for selector, want := range map[string][]string{
+		"td.price":                {"$12.50", "$8.00"},
+		"tr.gift td":              {"Mug", "$8.00"},
+		"table > td":              nil,
+		"p.total b, a":            {"$20.50", "View order"},
+		"[href^='https://shop']":  {"View order"},
+		"a[href$=\"4711\"]":       {"View order"},
+		"*[class~=gift] .name":    {"Mug"},
+		"TD[class*=ric]":          {"$12.50", "$8.00"},
+		"body p":                  {"Total: $20.50"},
+		"#items tr.item>td.name ": {"Coffee beans", "Mug"},
+	} {
+		parsed, err := parseCSSSelector(selector)
+		require.NoError(t, err, selector)
+		var got []string
+		for _, n := range parsed.selectAll(doc) {
+			got = append(got, htmlNodeText(n))
+		}
+		assert.Equal(t, want, got, selector)
+	}
+}
@@ -13,6 +13,7 @@ type MimePartMetadata struct {
 	Type         string
 	Subtype      string
 	Params       map[string]string
+	Encoding     string
 	IsAttachment bool
 	Filename     string
 	Path         []int
@@ -118,9 +119,15 @@ func determineRequiredBodySections(
 				}
 			}
 
+			encoding := ""
+			if single, ok := part.(*imap.BodyStructureSinglePart); ok {
+				encoding = single.Encoding
+			}
+
 			metadata := MimePartMetadata{
 				FetchSection: section,
 				Type:         mimeType,
+				Encoding:     encoding,
 				Params:       map[string]string{}, // Initialize empty map since we can't access params directly
 				IsAttachment: isAttachment,
 				Filename:     filename,
@@ -137,7 +144,10 @@ func determineRequiredBodySections(
 	return parts, nil
 }
 
-// BuildFetchOptions converts OutputConfig to imap.FetchOptions
+// BuildFetchOptions converts OutputConfig to imap.FetchOptions, requesting
+// only the items the output fields need: a uid and subject rule fetches the
+// envelope, but not the flags, size or body structure. Field paths need the
+// item their first key comes from.
 func BuildFetchOptions(config OutputConfig) (*imap.FetchOptions, error) {
 	options := &imap.FetchOptions{}
 
@@ -149,7 +159,16 @@ func BuildFetchOptions(config OutputConfig) (*imap.FetchOptions, error) {
 			continue
 		}
 
-		switch field.Name {
+		name := field.Name
+		if field.IsPath() {
+			segments, err := parseFieldPath(field.Name)
+			if err != nil {
+				return nil, err
+			}
+			name = segments[0].Key
+		}
+
+		switch name {
 		case "uid":
 			options.UID = true
 		case "envelope", "subject", "from", "to", "date":
@@ -159,8 +178,13 @@ func BuildFetchOptions(config OutputConfig) (*imap.FetchOptions, error) {
 			options.Flags = true
 		case "size":
 			options.RFC822Size = true
+		case "attachments", "encrypted":
+			options.BodyStructure = &imap.FetchItemBodyStructure{
+				Extended: true,
+			}
 		case "mime_parts":
-			// We need the body structure for MIME parts
+			// We need the body structure for MIME parts, and the extended
+			// one for their dispositions and filenames
 			options.BodyStructure = &imap.FetchItemBodyStructure{
 				Extended: true,
 			}
@@ -169,3 +193,59 @@ func BuildFetchOptions(config OutputConfig) (*imap.FetchOptions, error) {
 
 	return options, nil
 }
+
+// outputsMimeParts reports whether the output fetches MIME part contents in a
+// second fetch.
+func (o OutputConfig) outputsMimeParts() bool {
+	for _, fieldInterface := range o.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "mime_parts" {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchItemNames names the items options requests, as in the FETCH command,
+// for logs.
+func fetchItemNames(options *imap.FetchOptions) []string {
+	var names []string
+	if options.UID {
+		names = append(names, "UID")
+	}
+	if options.Flags {
+		names = append(names, "FLAGS")
+	}
+	if options.Envelope {
+		names = append(names, "ENVELOPE")
+	}
+	if options.InternalDate {
+		names = append(names, "INTERNALDATE")
+	}
+	if options.RFC822Size {
+		names = append(names, "RFC822.SIZE")
+	}
+	if options.BodyStructure != nil {
+		if options.BodyStructure.Extended {
+			names = append(names, "BODYSTRUCTURE")
+		} else {
+			names = append(names, "BODY")
+		}
+	}
+	for _, section := range options.BodySection {
+		name := "BODY.PEEK["
+		if !section.Peek {
+			name = "BODY["
+		}
+		if len(section.HeaderFields) > 0 {
+			name += "HEADER.FIELDS (" + strings.Join(section.HeaderFields, " ") + ")"
+		} else {
+			parts := make([]string, len(section.Part))
+			for i, part := range section.Part {
+				parts[i] = fmt.Sprintf("%d", part)
+			}
+			name += strings.Join(parts, ".")
+		}
+		names = append(names, name+"]")
+	}
+	return names
+}
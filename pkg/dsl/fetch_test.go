@@ -18,3 +18,65 @@ func TestDetermineRequiredBodySectionsWithoutMimePartsDoesNotNeedStructure(t *te
 		t.Fatalf("expected no MIME parts, got %d", len(parts))
 	}
 }
+
+func TestBuildFetchOptionsRequestsOnlyNeededItems(t *testing.T) {
+	options, err := BuildFetchOptions(OutputConfig{
+		Fields: []interface{}{
+			Field{Name: "uid"},
+			Field{Name: "subject"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !options.UID || !options.Envelope {
+		t.Fatalf("expected UID and ENVELOPE, got %v", fetchItemNames(options))
+	}
+	if options.Flags || options.RFC822Size || options.BodyStructure != nil {
+		t.Fatalf("expected no FLAGS, RFC822.SIZE or BODYSTRUCTURE, got %v", fetchItemNames(options))
+	}
+
+	options, err = BuildFetchOptions(OutputConfig{
+		Fields: []interface{}{
+			Field{Name: "flags[0]", As: "first_flag"},
+			Field{Name: "mime_parts[0].filename"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !options.Flags || options.Envelope || options.BodyStructure == nil || !options.BodyStructure.Extended {
+		t.Fatalf("expected FLAGS and BODYSTRUCTURE for field paths, got %v", fetchItemNames(options))
+	}
+}
+
+func TestPlanFetch(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: threads
+search:
+  from: example.com
+output:
+  group_by_thread: true
+  fields: [subject]
+actions:
+  move_to: Archive
+`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	options, err := rule.planFetch()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got := fetchItemNames(options)
+	want := []string{"UID", "ENVELOPE", "BODY.PEEK[HEADER.FIELDS (References)]"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pathSegment is one step of a dotted field path: a key, optionally followed
+// by list indexes (`from[0]`).
+type pathSegment struct {
+	Key     string
+	Indexes []int
+}
+
+// OutputName returns the name the field is emitted under: its alias if one was
+// given with `as`, otherwise its name.
+func (f Field) OutputName() string {
+	if f.As != "" {
+		return f.As
+	}
+	return f.Name
+}
+
+// IsPath reports whether the field selects into the message structure with a
+// dotted path (`envelope.from[0].address`) rather than naming a built-in field.
+func (f Field) IsPath() bool {
+	return strings.ContainsAny(f.Name, ".[")
+}
+
+// parseFieldSpec parses the string form of an output field, which may carry an
+// alias: "envelope.from[0].address as sender".
+func parseFieldSpec(spec string) Field {
+	spec = strings.TrimSpace(spec)
+	lower := strings.ToLower(spec)
+	if idx := strings.LastIndex(lower, " as "); idx >= 0 {
+		return Field{
+			Name: strings.TrimSpace(spec[:idx]),
+			As:   strings.TrimSpace(spec[idx+len(" as "):]),
+		}
+	}
+	return Field{Name: spec}
+}
+
+// sortFieldsByOrder moves fields with an explicit `order` to the front, sorted
+// by it. Fields without an order keep their relative position after them.
+func sortFieldsByOrder(fields []interface{}) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		oi, oj := fieldOrder(fields[i]), fieldOrder(fields[j])
+		switch {
+		case oi == 0:
+			return false
+		case oj == 0:
+			return true
+		default:
+			return oi < oj
+		}
+	})
+}
+
+func fieldOrder(fieldInterface interface{}) int {
+	if field, ok := fieldInterface.(Field); ok {
+		return field.Order
+	}
+	return 0
+}
+
+// parseFieldPath splits a dotted path with optional list indexes.
+func parseFieldPath(path string) ([]pathSegment, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty field path")
+	}
+	var segments []pathSegment
+	for _, part := range strings.Split(path, ".") {
+		key := part
+		var indexes []int
+		if open := strings.Index(part, "["); open >= 0 {
+			key = part[:open]
+			rest := part[open:]
+			for rest != "" {
+				if rest[0] != '[' {
+					return nil, fmt.Errorf("invalid field path %q", path)
+				}
+				end := strings.Index(rest, "]")
+				if end < 0 {
+					return nil, fmt.Errorf("invalid field path %q: missing ']'", path)
+				}
+				n, err := strconv.Atoi(rest[1:end])
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid field path %q: bad index %q", path, rest[1:end])
+				}
+				indexes = append(indexes, n)
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("invalid field path %q: empty segment", path)
+		}
+		segments = append(segments, pathSegment{Key: key, Indexes: indexes})
+	}
+	return segments, nil
+}
+
+// validateFieldPath checks the syntax of path and that it starts at a known
+// message key.
+func validateFieldPath(path string) error {
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := messageTree(&EmailMessage{Envelope: &EmailEnvelope{}})[segments[0].Key]; !ok {
+		return fmt.Errorf("unknown field %q in path %q", segments[0].Key, path)
+	}
+	return nil
+}
+
+// SelectPath returns the value at path in msg, e.g. "envelope.from[0].address"
+// or "mime_parts[1].filename". Missing keys and out of range indexes yield nil.
+func SelectPath(msg *EmailMessage, path string) (interface{}, error) {
+	segments, err := parseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var current interface{} = messageTree(msg)
+	for _, segment := range segments {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		current, ok = m[segment.Key]
+		if !ok {
+			return nil, nil
+		}
+		for _, idx := range segment.Indexes {
+			list, ok := current.([]interface{})
+			if !ok || idx >= len(list) {
+				return nil, nil
+			}
+			current = list[idx]
+		}
+	}
+	return current, nil
+}
+
+// messageTree exposes msg as nested maps and lists for path selection. The
+// envelope is available both under "envelope" and, for convenience, as the
+// top-level subject/from/to/date keys.
+func messageTree(msg *EmailMessage) map[string]interface{} {
+	flags := make([]interface{}, 0, len(msg.Flags))
+	for _, flag := range msg.Flags {
+		flags = append(flags, flag)
+	}
+
+	parts := make([]interface{}, 0, len(msg.MimeParts))
+	for _, part := range msg.MimeParts {
+		parts = append(parts, map[string]interface{}{
+			"type":        part.Type,
+			"subtype":     part.Subtype,
+			"disposition": part.Disposition,
+			"encoding":    part.Encoding,
+			"size":        part.Size,
+			"content":     part.Content,
+			"filename":    part.Filename,
+			"charset":     part.Charset,
+		})
+	}
+
+	attachments := make([]interface{}, 0, len(msg.Attachments))
+	for _, attachment := range msg.Attachments {
+		attachments = append(attachments, map[string]interface{}{
+			"filename": attachment.Filename,
+			"type":     attachment.Type,
+			"size":     attachment.Size,
+			"sha256":   attachment.SHA256,
+		})
+	}
+
+	tree := map[string]interface{}{
+		"uid":         msg.UID,
+		"seq_num":     msg.SeqNum,
+		"size":        msg.Size,
+		"flags":       flags,
+		"total_count": msg.TotalCount,
+		"mime_parts":  parts,
+		"attachments": attachments,
+		"encrypted":   msg.encryption(),
+		"decrypted":   msg.Decrypted,
+		"list_id":     msg.ListID,
+		"label":       msg.Label,
+		"summary":     msg.Summary,
+		"extracted":   extractedTree(msg.Extracted),
+		"language":    msg.Language,
+		"envelope":    nil,
+		"subject":     nil,
+		"from":        nil,
+		"to":          nil,
+		"date":        nil,
+	}
+
+	if msg.Envelope != nil {
+		envelope := map[string]interface{}{
+			"subject": msg.Envelope.Subject,
+			"from":    addressTree(msg.Envelope.From),
+			"to":      addressTree(msg.Envelope.To),
+			"date":    msg.Envelope.Date.Format(time.RFC3339),
+		}
+		tree["envelope"] = envelope
+		for _, key := range []string{"subject", "from", "to", "date"} {
+			tree[key] = envelope[key]
+		}
+	}
+
+	return tree
+}
+
+func addressTree(addrs []EmailAddress) []interface{} {
+	ret := make([]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		ret = append(ret, map[string]interface{}{
+			"name":    addr.Name,
+			"address": addr.Address,
+		})
+	}
+	return ret
+}
+
+// extractedTree converts the lists of extracted values so paths can index
+// them.
+func extractedTree(extracted map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(extracted))
+	for name, value := range extracted {
+		if list, ok := value.([]string); ok {
+			items := make([]interface{}, len(list))
+			for i, item := range list {
+				items[i] = item
+			}
+			value = items
+		}
+		ret[name] = value
+	}
+	return ret
+}
@@ -0,0 +1,114 @@
+package dsl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fieldPathTestMessage() *EmailMessage {
+	return &EmailMessage{
+		UID:   9,
+		Size:  512,
+		Flags: []string{"\\Seen"},
+		Envelope: &EmailEnvelope{
+			Subject: "Hello",
+			Date:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+			From:    []EmailAddress{{Name: "Alice", Address: "alice@example.com"}},
+			To:      []EmailAddress{{Address: "bob@example.org"}},
+		},
+		MimeParts: []MimePart{{Type: "text/plain", Content: "hi"}, {Type: "application/pdf", Filename: "a.pdf"}},
+	}
+}
+
+func TestSelectPath(t *testing.T) {
+	msg := fieldPathTestMessage()
+
+	tests := []struct {
+		path string
+		want interface{}
+	}{
+		{"envelope.from[0].address", "alice@example.com"},
+		{"from[0].name", "Alice"},
+		{"envelope.subject", "Hello"},
+		{"mime_parts[1].filename", "a.pdf"},
+		{"flags[0]", "\\Seen"},
+		{"uid", uint32(9)},
+		{"envelope.from[3].address", nil},
+		{"envelope.nope", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := SelectPath(msg, tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := SelectPath(msg, "from[x]")
+	assert.Error(t, err)
+}
+
+func TestOutputFieldAliasesAndOrder(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: aliases
+output:
+  format: json
+  fields:
+    - subject as title
+    - envelope.from[0].address as sender
+    - name: uid
+      as: id
+      order: 1
+    - date
+`)
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range rule.Output.Fields {
+		names = append(names, f.(Field).OutputName())
+	}
+	assert.Equal(t, []string{"id", "title", "sender", "date"}, names)
+
+	out, err := FormatOutput(fieldPathTestMessage(), rule.Output)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &decoded))
+	assert.Equal(t, "Hello", decoded["title"])
+	assert.Equal(t, "alice@example.com", decoded["sender"])
+	assert.Equal(t, float64(9), decoded["id"])
+
+	// JSON keys follow the field order
+	assert.Less(t, strings.Index(out, `"id"`), strings.Index(out, `"title"`))
+	assert.Less(t, strings.Index(out, `"title"`), strings.Index(out, `"sender"`))
+
+	rule.Output.Format = "text"
+	text, err := FormatOutput(fieldPathTestMessage(), rule.Output)
+	require.NoError(t, err)
+	assert.Contains(t, text, "title: Hello\n")
+	assert.Contains(t, text, "sender: alice@example.com\n")
+	assert.Contains(t, text, "Date: 2025-01-02T03:04:05Z\n")
+}
+
+func TestOutputFieldValidation(t *testing.T) {
+	_, err := ParseRuleString(`
+name: bad-path
+output:
+  fields: ["bogus.field"]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown field")
+
+	_, err = ParseRuleString(`
+name: duplicate
+output:
+  fields: ["subject as x", "uid as x"]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate output field name")
+}
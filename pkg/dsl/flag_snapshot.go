@@ -0,0 +1,246 @@
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// FlagSnapshot records the flags of the messages of a mailbox so that
+// RestoreFlagSnapshot can put them back.
+type FlagSnapshot struct {
+	Mailbox     string              `json:"mailbox"`
+	UIDValidity uint32              `json:"uid_validity"`
+	TakenAt     time.Time           `json:"taken_at"`
+	Messages    []FlagSnapshotEntry `json:"messages"`
+}
+
+// FlagSnapshotEntry records the flags of one message.
+type FlagSnapshotEntry struct {
+	UID       uint32   `json:"uid"`
+	MessageID string   `json:"message_id,omitempty"`
+	Flags     []string `json:"flags"`
+}
+
+// TakeFlagSnapshot records the flags of every message in mailbox. The
+// mailbox is opened read-only.
+func TakeFlagSnapshot(client *imapclient.Client, mailbox string) (*FlagSnapshot, error) {
+	selectData, err := client.Select(mailbox, &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+	current, err := fetchFlagState(client, selectData.NumMessages)
+	if err != nil {
+		return nil, err
+	}
+	return &FlagSnapshot{
+		Mailbox:     mailbox,
+		UIDValidity: selectData.UIDValidity,
+		TakenAt:     time.Now().UTC(),
+		Messages:    current,
+	}, nil
+}
+
+// LoadFlagSnapshot reads a snapshot written by Save.
+func LoadFlagSnapshot(path string) (*FlagSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flag snapshot: %w", err)
+	}
+	snapshot := &FlagSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse flag snapshot %s: %w", path, err)
+	}
+	if snapshot.Mailbox == "" {
+		return nil, fmt.Errorf("flag snapshot %s has no mailbox", path)
+	}
+	return snapshot, nil
+}
+
+// Save writes the snapshot to path atomically.
+func (s *FlagSnapshot) Save(path string) error {
+	if err := writeStateFile(path, s); err != nil {
+		return fmt.Errorf("failed to save flag snapshot: %w", err)
+	}
+	return nil
+}
+
+// FlagRestore reports what RestoreFlagSnapshot did for a snapshot entry.
+type FlagRestore struct {
+	Entry FlagSnapshotEntry
+	// UID is the message's current UID, 0 if it was not found
+	UID uint32
+	// MatchedBy is "uid" or "message_id"
+	MatchedBy string
+	Added     []string
+	Removed   []string
+}
+
+// Changed reports whether the message's flags differ from the snapshot.
+func (r FlagRestore) Changed() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0
+}
+
+// RestoreFlagSnapshot sets the flags of the messages of the snapshot's
+// mailbox back to the recorded state. Messages are found by UID while the
+// mailbox's UIDVALIDITY is unchanged and the Message-ID still agrees, and by
+// Message-ID otherwise. Messages not in the snapshot are left alone. With
+// dryRun the changes are only reported.
+func RestoreFlagSnapshot(client *imapclient.Client, snapshot *FlagSnapshot, dryRun bool) ([]FlagRestore, error) {
+	selectData, err := client.Select(snapshot.Mailbox, &imap.SelectOptions{ReadOnly: dryRun}).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select mailbox %q: %w", snapshot.Mailbox, wrapMailboxError(err, snapshot.Mailbox))
+	}
+	current, err := fetchFlagState(client, selectData.NumMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	restores := planFlagRestore(snapshot, selectData.UIDValidity, current)
+	if dryRun {
+		return restores, nil
+	}
+
+	// Messages ending up with the same flags share one STORE
+	groups := map[string]imap.UIDSet{}
+	groupFlags := map[string][]imap.Flag{}
+	var keys []string
+	for _, restore := range restores {
+		if !restore.Changed() {
+			continue
+		}
+		flags := storableFlags(restore.Entry.Flags)
+		key := strings.Join(flags, " ")
+		set, ok := groups[key]
+		if !ok {
+			keys = append(keys, key)
+			for _, flag := range flags {
+				groupFlags[key] = append(groupFlags[key], imap.Flag(flag))
+			}
+		}
+		set.AddNum(imap.UID(restore.UID))
+		groups[key] = set
+	}
+	for _, key := range keys {
+		if _, err := client.Store(groups[key], &imap.StoreFlags{
+			Op:     imap.StoreFlagsSet,
+			Silent: true,
+			Flags:  groupFlags[key],
+		}, nil).Collect(); err != nil {
+			return restores, fmt.Errorf("failed to restore flags: %w", err)
+		}
+	}
+	return restores, nil
+}
+
+// fetchFlagState returns the UID, Message-ID and flags of the messages of
+// the selected mailbox.
+func fetchFlagState(client *imapclient.Client, numMessages uint32) ([]FlagSnapshotEntry, error) {
+	entries := []FlagSnapshotEntry{}
+	if numMessages == 0 {
+		return entries, nil
+	}
+	seqSet := imap.SeqSet{}
+	seqSet.AddRange(1, 0)
+	fetched, err := client.Fetch(seqSet, &imap.FetchOptions{UID: true, Flags: true, Envelope: true}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch flags: %w", err)
+	}
+	for _, msg := range fetched {
+		entry := FlagSnapshotEntry{UID: uint32(msg.UID), Flags: []string{}}
+		if msg.Envelope != nil {
+			entry.MessageID = msg.Envelope.MessageID
+		}
+		for _, flag := range msg.Flags {
+			entry.Flags = append(entry.Flags, string(flag))
+		}
+		entry.Flags = storableFlags(entry.Flags)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UID < entries[j].UID })
+	return entries, nil
+}
+
+// planFlagRestore matches the snapshot entries to the current messages and
+// computes the flags to add and remove.
+func planFlagRestore(snapshot *FlagSnapshot, uidValidity uint32, current []FlagSnapshotEntry) []FlagRestore {
+	byUID := make(map[uint32]FlagSnapshotEntry, len(current))
+	byMessageID := make(map[string]FlagSnapshotEntry, len(current))
+	for _, entry := range current {
+		byUID[entry.UID] = entry
+		if entry.MessageID != "" {
+			// Duplicates resolve to the newest copy, as when waking snoozed mail
+			byMessageID[entry.MessageID] = entry
+		}
+	}
+
+	restores := make([]FlagRestore, 0, len(snapshot.Messages))
+	for _, entry := range snapshot.Messages {
+		restore := FlagRestore{Entry: entry}
+		var found FlagSnapshotEntry
+		var ok bool
+		if snapshot.UIDValidity == uidValidity && entry.UID != 0 {
+			found, ok = byUID[entry.UID]
+			if ok && entry.MessageID != "" && found.MessageID != entry.MessageID {
+				ok = false
+			}
+			if ok {
+				restore.MatchedBy = "uid"
+			}
+		}
+		if !ok && entry.MessageID != "" {
+			found, ok = byMessageID[entry.MessageID]
+			if ok {
+				restore.MatchedBy = "message_id"
+			}
+		}
+		if ok {
+			restore.UID = found.UID
+			restore.Added, restore.Removed = flagDiff(found.Flags, storableFlags(entry.Flags))
+		}
+		restores = append(restores, restore)
+	}
+	return restores
+}
+
+// flagDiff returns the flags of want missing from have and the flags of have
+// missing from want, comparing case-insensitively as IMAP does.
+func flagDiff(have, want []string) (added, removed []string) {
+	haveSet := map[string]bool{}
+	for _, flag := range have {
+		haveSet[strings.ToLower(flag)] = true
+	}
+	wantSet := map[string]bool{}
+	for _, flag := range want {
+		wantSet[strings.ToLower(flag)] = true
+		if !haveSet[strings.ToLower(flag)] {
+			added = append(added, flag)
+		}
+	}
+	for _, flag := range have {
+		if !wantSet[strings.ToLower(flag)] {
+			removed = append(removed, flag)
+		}
+	}
+	return added, removed
+}
+
+// storableFlags returns flags sorted and without \Recent, which clients
+// cannot set.
+func storableFlags(flags []string) []string {
+	ret := make([]string, 0, len(flags))
+	for _, flag := range flags {
+		if strings.EqualFold(flag, `\Recent`) {
+			continue
+		}
+		ret = append(ret, flag)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,78 @@
+package dsl
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagSnapshotSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flags.json")
+	snapshot := &FlagSnapshot{
+		Mailbox:     "INBOX",
+		UIDValidity: 7,
+		TakenAt:     time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		Messages: []FlagSnapshotEntry{
+			{UID: 1, MessageID: "<a@x>", Flags: []string{`\Seen`}},
+			{UID: 2, Flags: []string{}},
+		},
+	}
+	require.NoError(t, snapshot.Save(path))
+
+	loaded, err := LoadFlagSnapshot(path)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, loaded)
+
+	_, err = LoadFlagSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestPlanFlagRestore(t *testing.T) {
+	snapshot := &FlagSnapshot{
+		Mailbox:     "INBOX",
+		UIDValidity: 7,
+		Messages: []FlagSnapshotEntry{
+			{UID: 1, MessageID: "<a@x>", Flags: []string{`\Seen`}},
+			{UID: 2, MessageID: "<b@x>", Flags: []string{`\Flagged`, `\Seen`}},
+			{UID: 3, Flags: []string{}},
+			{UID: 4, MessageID: "<gone@x>", Flags: []string{`\Seen`}},
+		},
+	}
+	current := []FlagSnapshotEntry{
+		{UID: 1, MessageID: "<a@x>", Flags: []string{`\Seen`}},
+		{UID: 2, MessageID: "<other@x>", Flags: []string{}},
+		{UID: 3, Flags: []string{`\Deleted`, `\Seen`}},
+		{UID: 9, MessageID: "<b@x>", Flags: []string{`\seen`, "$Junk"}},
+	}
+
+	restores := planFlagRestore(snapshot, 7, current)
+	require.Len(t, restores, 4)
+
+	assert.Equal(t, "uid", restores[0].MatchedBy)
+	assert.False(t, restores[0].Changed())
+
+	// UID 2 now holds another message, so the Message-ID wins
+	assert.Equal(t, "message_id", restores[1].MatchedBy)
+	assert.Equal(t, uint32(9), restores[1].UID)
+	assert.Equal(t, []string{`\Flagged`}, restores[1].Added)
+	assert.Equal(t, []string{"$Junk"}, restores[1].Removed)
+
+	assert.Equal(t, "uid", restores[2].MatchedBy)
+	assert.Empty(t, restores[2].Added)
+	assert.Equal(t, []string{`\Deleted`, `\Seen`}, restores[2].Removed)
+
+	assert.Equal(t, uint32(0), restores[3].UID)
+	assert.Equal(t, "", restores[3].MatchedBy)
+
+	// A new UIDVALIDITY leaves only Message-ID matches
+	restores = planFlagRestore(snapshot, 8, current)
+	assert.Equal(t, "message_id", restores[0].MatchedBy)
+	assert.Equal(t, uint32(0), restores[2].UID)
+}
+
+func TestStorableFlags(t *testing.T) {
+	assert.Equal(t, []string{`\Answered`, `\Seen`}, storableFlags([]string{`\Seen`, `\Recent`, `\Answered`}))
+}
@@ -0,0 +1,159 @@
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// FlagSummaryUnread is the name a flag summary counts the messages without
+// \Seen under.
+const FlagSummaryUnread = "unread"
+
+// flagRecent is the IMAP4rev1 \Recent flag, which IMAP4rev2 dropped and
+// go-imap v2 does not define. It is session state, not a flag of the message.
+const flagRecent = "\\Recent"
+
+// FlagCount is the number of matched messages having a flag or keyword.
+type FlagCount struct {
+	// Mailbox is only set when the summary is broken down per mailbox
+	Mailbox  string `json:"mailbox,omitempty"`
+	Flag     string `json:"flag"`
+	Messages int    `json:"messages"`
+	// Total is the number of matched messages the count is out of
+	Total int `json:"total"`
+}
+
+// FlagTally counts the flags of matched messages, across the rules of a run.
+// It is safe for concurrent use.
+type FlagTally struct {
+	mu        sync.Mutex
+	mailboxes []string
+	totals    map[string]int
+	counts    map[string]map[string]int
+}
+
+// NewFlagTally returns an empty tally.
+func NewFlagTally() *FlagTally {
+	return &FlagTally{
+		totals: make(map[string]int),
+		counts: make(map[string]map[string]int),
+	}
+}
+
+// Add counts the flags of messages matched in mailbox. The counts of each
+// mailbox are kept apart; messages added with an empty mailbox are counted
+// together.
+func (t *FlagTally) Add(mailbox string, messages []*EmailMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts, ok := t.counts[mailbox]
+	if !ok {
+		// Unread is counted even when zero, the other flags when present
+		counts = map[string]int{FlagSummaryUnread: 0}
+		t.counts[mailbox] = counts
+		t.mailboxes = append(t.mailboxes, mailbox)
+	}
+	for _, msg := range messages {
+		t.totals[mailbox]++
+		if !hasFlag(msg.Flags, string(imap.FlagSeen)) {
+			counts[FlagSummaryUnread]++
+		}
+		seen := make(map[string]bool, len(msg.Flags))
+		for _, flag := range msg.Flags {
+			if flag == flagRecent || seen[flag] {
+				continue
+			}
+			seen[flag] = true
+			counts[flag]++
+		}
+	}
+}
+
+// Counts returns the counts, per mailbox in the order they were added, then
+// unread first, system flags and keywords last.
+func (t *FlagTally) Counts() []FlagCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var result []FlagCount
+	for _, mailbox := range t.mailboxes {
+		counts := t.counts[mailbox]
+		flags := make([]string, 0, len(counts))
+		for flag := range counts {
+			flags = append(flags, flag)
+		}
+		sort.Slice(flags, func(i, j int) bool {
+			ri, rj := flagSummaryRank(flags[i]), flagSummaryRank(flags[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return flags[i] < flags[j]
+		})
+		for _, flag := range flags {
+			result = append(result, FlagCount{
+				Mailbox:  mailbox,
+				Flag:     flag,
+				Messages: counts[flag],
+				Total:    t.totals[mailbox],
+			})
+		}
+	}
+	return result
+}
+
+func flagSummaryRank(flag string) int {
+	switch {
+	case flag == FlagSummaryUnread:
+		return 0
+	case strings.HasPrefix(flag, "\\"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// SummarizeFlags counts the flags and keywords of messages.
+func SummarizeFlags(messages []*EmailMessage) []FlagCount {
+	tally := NewFlagTally()
+	tally.Add("", messages)
+	return tally.Counts()
+}
+
+func writeFlagSummary(w io.Writer, counts []FlagCount, config OutputConfig) error {
+	switch config.Format {
+	case "ndjson":
+		for _, count := range counts {
+			data, err := json.Marshal(count)
+			if err != nil {
+				return fmt.Errorf("failed to marshal JSON: %w", err)
+			}
+			if _, err := fmt.Fprintln(w, string(data)); err != nil {
+				return fmt.Errorf("failed to write JSON line: %w", err)
+			}
+		}
+		return nil
+	case "json":
+		data, err := json.MarshalIndent(counts, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	default:
+		for _, count := range counts {
+			name := count.Flag
+			if count.Mailbox != "" {
+				name = count.Mailbox + " " + name
+			}
+			if _, err := fmt.Fprintf(w, "%s: %d of %d\n", name, count.Messages, count.Total); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package dsl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlagTally(t *testing.T) {
+	tally := NewFlagTally()
+	tally.Add("INBOX", []*EmailMessage{
+		{UID: 1, Flags: []string{"\\Seen", "$Todo"}},
+		{UID: 2, Flags: []string{"\\Flagged", "\\Recent", "$Todo"}},
+		{UID: 3},
+	})
+	tally.Add("Archive", []*EmailMessage{{UID: 1, Flags: []string{"\\Seen"}}})
+	tally.Add("INBOX", []*EmailMessage{{UID: 4, Flags: []string{"\\Seen"}}})
+
+	assert.Equal(t, []FlagCount{
+		{Mailbox: "INBOX", Flag: "unread", Messages: 2, Total: 4},
+		{Mailbox: "INBOX", Flag: "\\Flagged", Messages: 1, Total: 4},
+		{Mailbox: "INBOX", Flag: "\\Seen", Messages: 2, Total: 4},
+		{Mailbox: "INBOX", Flag: "$Todo", Messages: 2, Total: 4},
+		{Mailbox: "Archive", Flag: "unread", Messages: 0, Total: 1},
+		{Mailbox: "Archive", Flag: "\\Seen", Messages: 1, Total: 1},
+	}, tally.Counts())
+}
+
+func TestFlagSummaryOutput(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: status
+search:
+  from: example
+output:
+  flag_summary: true
+`)
+	require.NoError(t, err)
+
+	var messages []*EmailMessage
+	for _, msg := range readSampleMbox(t) {
+		messages = append(messages, msg.Message)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, rule.WriteOutput(&buf, messages))
+	assert.Contains(t, buf.String(), "unread: 2 of 3\n")
+	assert.Contains(t, buf.String(), "\\Seen: 1 of 3\n")
+
+	_, err = ParseRuleString(`
+name: status
+search:
+  from: example
+output:
+  flag_summary_per_mailbox: true
+  fields: [uid]
+`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Defaults and values of the follow_up action.
+const (
+	DefaultFollowUpFlag    = "$AwaitingReply"
+	DefaultFollowUpMailbox = "INBOX"
+
+	FollowUpRemindRow     = "row"
+	FollowUpRemindAppend  = "append"
+	FollowUpRemindWebhook = "webhook"
+)
+
+// FollowUpConfig flags messages that await a reply and records them in the
+// follow-up state file. CheckFollowUps later drops the ones that got a reply
+// and sends a reminder for those still unanswered after Within.
+type FollowUpConfig struct {
+	// Within is how long to wait for a reply: "3d", "+12h", "1w"
+	Within string `yaml:"within"`
+	Flag   string `yaml:"flag,omitempty"`
+	// Remind is how the reminder is delivered: row, append or webhook
+	Remind  string `yaml:"remind,omitempty"`
+	Webhook string `yaml:"webhook,omitempty"`
+	// Mailbox receives appended reminders
+	Mailbox string `yaml:"mailbox,omitempty"`
+	State   string `yaml:"state,omitempty"`
+}
+
+// Validate checks if the follow-up config is valid
+func (f *FollowUpConfig) Validate() error {
+	if f.Within == "" {
+		return fmt.Errorf("follow_up requires 'within'")
+	}
+	if _, err := f.window(); err != nil {
+		return err
+	}
+	if f.Flag != "" && !isValidFlag(f.Flag) {
+		return fmt.Errorf("invalid follow_up flag: %s", f.Flag)
+	}
+	switch f.Remind {
+	case "", FollowUpRemindRow, FollowUpRemindAppend:
+	case FollowUpRemindWebhook:
+		if f.Webhook == "" {
+			return fmt.Errorf("follow_up remind: webhook requires 'webhook'")
+		}
+	default:
+		return fmt.Errorf("invalid follow_up remind: %s (must be 'row', 'append' or 'webhook')", f.Remind)
+	}
+	return nil
+}
+
+func (f *FollowUpConfig) window() (time.Duration, error) {
+	d, err := parseRelativeDuration(strings.TrimPrefix(strings.TrimSpace(f.Within), "+"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid follow_up 'within' %q: %w", f.Within, err)
+	}
+	return d, nil
+}
+
+func (f *FollowUpConfig) flag() string {
+	if f.Flag == "" {
+		return DefaultFollowUpFlag
+	}
+	return f.Flag
+}
+
+// FollowUpEntry records a message awaiting a reply.
+type FollowUpEntry struct {
+	MessageID string    `json:"message_id"`
+	Subject   string    `json:"subject,omitempty"`
+	To        []string  `json:"to,omitempty"`
+	SentAt    time.Time `json:"sent_at"`
+	DueAt     time.Time `json:"due_at"`
+	Remind    string    `json:"remind,omitempty"`
+	Webhook   string    `json:"webhook,omitempty"`
+	Mailbox   string    `json:"mailbox,omitempty"`
+	Rule      string    `json:"rule,omitempty"`
+}
+
+// FollowUpStore is the JSON file holding the messages awaiting a reply.
+type FollowUpStore struct {
+	Path    string
+	Entries []FollowUpEntry
+}
+
+// DefaultFollowUpStatePath returns the default follow-up state file in
+// DefaultStateDir.
+func DefaultFollowUpStatePath() string {
+	return filepath.Join(DefaultStateDir(), "follow-ups.json")
+}
+
+// LoadFollowUpStore reads the store at path. A missing file is an empty store.
+func LoadFollowUpStore(path string) (*FollowUpStore, error) {
+	if path == "" {
+		path = DefaultFollowUpStatePath()
+	}
+	store := &FollowUpStore{Path: path}
+	if err := readStateFile(path, &store.Entries); err != nil {
+		return nil, fmt.Errorf("failed to load follow-up state: %w", err)
+	}
+	return store, nil
+}
+
+// Save writes the store atomically.
+func (s *FollowUpStore) Save() error {
+	if err := writeStateFile(s.Path, s.Entries); err != nil {
+		return fmt.Errorf("failed to save follow-up state: %w", err)
+	}
+	return nil
+}
+
+func (s *FollowUpStore) has(messageID string) bool {
+	for _, entry := range s.Entries {
+		if entry.MessageID == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// executeFollowUp flags messages as awaiting a reply and starts tracking them.
+func executeFollowUp(client *imapclient.Client, messages []*EmailMessage, config *FollowUpConfig, rule *Rule) error {
+	logger := rule.Logger()
+	window, err := config.window()
+	if err != nil {
+		return err
+	}
+	// The store is loaded first so a broken state file fails before the
+	// messages are flagged
+	followUpMu.Lock()
+	_, err = LoadFollowUpStore(config.State)
+	followUpMu.Unlock()
+	if err != nil {
+		return err
+	}
+	messageIDs, err := fetchMessageIDs(client, messages)
+	if err != nil {
+		return err
+	}
+
+	if err := executeFlags(client, messages, &FlagActions{Add: []string{config.flag()}}, rule); err != nil {
+		return err
+	}
+
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+	remind := config.Remind
+	if remind == "" {
+		remind = FollowUpRemindRow
+	}
+	mailbox := config.Mailbox
+	if mailbox == "" {
+		mailbox = DefaultFollowUpMailbox
+	}
+
+	entries := make([]FollowUpEntry, 0, len(messages))
+	for _, msg := range messages {
+		messageID := messageIDs[msg.UID]
+		if messageID == "" {
+			logger.Warn().Uint32("uid", msg.UID).Msg("Message has no Message-ID, replies to it cannot be tracked")
+			continue
+		}
+
+		entry := FollowUpEntry{
+			MessageID: messageID,
+			SentAt:    time.Now(),
+			Remind:    remind,
+			Webhook:   config.Webhook,
+			Mailbox:   mailbox,
+			Rule:      ruleName,
+		}
+		if msg.Envelope != nil {
+			entry.Subject = msg.Envelope.Subject
+			if !msg.Envelope.Date.IsZero() {
+				entry.SentAt = msg.Envelope.Date
+			}
+			for _, addr := range msg.Envelope.To {
+				entry.To = append(entry.To, addr.Address)
+			}
+		}
+		entry.DueAt = entry.SentAt.Add(window)
+		entries = append(entries, entry)
+	}
+
+	added, err := addFollowUpEntries(config.State, entries)
+	if err != nil {
+		return err
+	}
+	logger.Debug().
+		Int("message_count", len(messages)).
+		Int("tracked", added).
+		Msg("Tracking messages for follow-up")
+	return nil
+}
+
+// followUpMu serializes the updates of follow-up state files by rules
+// running concurrently.
+var followUpMu sync.Mutex
+
+// addFollowUpEntries adds the entries not tracked yet to the follow-up state
+// file at path, and returns how many were added.
+func addFollowUpEntries(path string, entries []FollowUpEntry) (int, error) {
+	followUpMu.Lock()
+	defer followUpMu.Unlock()
+	store, err := LoadFollowUpStore(path)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, entry := range entries {
+		if store.has(entry.MessageID) {
+			continue
+		}
+		store.Entries = append(store.Entries, entry)
+		added++
+	}
+	return added, store.Save()
+}
+
+// Outcomes reported by CheckFollowUps.
+const (
+	FollowUpReplied  = "replied"
+	FollowUpReminded = "reminded"
+	FollowUpWaiting  = "waiting"
+)
+
+// FollowUpResult is the outcome of checking one tracked message.
+type FollowUpResult struct {
+	Entry  FollowUpEntry
+	Status string
+}
+
+// CheckFollowUps searches replyMailboxes for replies to the tracked messages,
+// matching In-Reply-To and References like thread grouping does. Entries with
+// a reply are dropped; entries past their due time get their reminder sent
+// and are dropped too. The selected mailbox changes; callers have to reselect.
+func CheckFollowUps(ctx context.Context, client *imapclient.Client, store *FollowUpStore, replyMailboxes []string, now time.Time) ([]FollowUpResult, error) {
+	if len(store.Entries) == 0 {
+		return nil, nil
+	}
+	if len(replyMailboxes) == 0 {
+		replyMailboxes = []string{DefaultFollowUpMailbox}
+	}
+
+	replied := make(map[string]bool)
+	for _, mailbox := range replyMailboxes {
+		if _, err := SelectMailbox(client, mailbox); err != nil {
+			return nil, err
+		}
+		for _, entry := range store.Entries {
+			if replied[entry.MessageID] {
+				continue
+			}
+			found, err := hasReply(client, entry.MessageID)
+			if err != nil {
+				return nil, err
+			}
+			replied[entry.MessageID] = found
+		}
+	}
+
+	var results []FollowUpResult
+	done := make(map[string]bool)
+	for _, entry := range store.Entries {
+		switch {
+		case replied[entry.MessageID]:
+			results = append(results, FollowUpResult{Entry: entry, Status: FollowUpReplied})
+			done[entry.MessageID] = true
+		case !entry.DueAt.After(now):
+			if err := sendReminder(ctx, client, entry, now); err != nil {
+				return results, err
+			}
+			results = append(results, FollowUpResult{Entry: entry, Status: FollowUpReminded})
+			done[entry.MessageID] = true
+		default:
+			results = append(results, FollowUpResult{Entry: entry, Status: FollowUpWaiting})
+		}
+	}
+
+	kept := store.Entries[:0]
+	for _, entry := range store.Entries {
+		if !done[entry.MessageID] {
+			kept = append(kept, entry)
+		}
+	}
+	store.Entries = kept
+	return results, store.Save()
+}
+
+// hasReply reports whether the selected mailbox holds a message referring to
+// messageID.
+func hasReply(client *imapclient.Client, messageID string) (bool, error) {
+	id := "<" + messageID + ">"
+	data, err := client.UIDSearch(&imap.SearchCriteria{
+		Or: [][2]imap.SearchCriteria{{
+			{Header: []imap.SearchCriteriaHeaderField{{Key: "In-Reply-To", Value: id}}},
+			{Header: []imap.SearchCriteriaHeaderField{{Key: "References", Value: id}}},
+		}},
+	}, nil).Wait()
+	if err != nil {
+		return false, fmt.Errorf("failed to search replies: %w", wrapSearchError(err))
+	}
+	return len(data.AllUIDs()) > 0, nil
+}
+
+func sendReminder(ctx context.Context, client *imapclient.Client, entry FollowUpEntry, now time.Time) error {
+	switch entry.Remind {
+	case FollowUpRemindAppend:
+		msg := reminderMessage(entry, now)
+		cmd := client.Append(entry.Mailbox, int64(len(msg)), &imap.AppendOptions{Time: now})
+		if _, err := cmd.Write(msg); err != nil {
+			return fmt.Errorf("failed to append reminder: %w", err)
+		}
+		if err := cmd.Close(); err != nil {
+			return fmt.Errorf("failed to append reminder: %w", err)
+		}
+		if _, err := cmd.Wait(); err != nil {
+			return fmt.Errorf("failed to append reminder to %s: %w", entry.Mailbox, wrapMailboxError(err, entry.Mailbox))
+		}
+	case FollowUpRemindWebhook:
+		body, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to marshal reminder: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, entry.Webhook, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create reminder request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to call reminder webhook: %w", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("reminder webhook returned %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// reminderMessage builds the message appended for an unanswered entry. It
+// refers to the original so that it threads with it.
+func reminderMessage(entry FollowUpEntry, now time.Time) []byte {
+	subject := entry.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "From: smailnail <follow-up@smailnail.invalid>\r\n")
+	_, _ = fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "Follow up: "+subject))
+	_, _ = fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&b, "Message-ID: <follow-up.%d.%s>\r\n", now.UnixNano(), entry.MessageID)
+	_, _ = fmt.Fprintf(&b, "In-Reply-To: <%s>\r\n", entry.MessageID)
+	_, _ = fmt.Fprintf(&b, "References: <%s>\r\n", entry.MessageID)
+	_, _ = fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	_, _ = fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	_, _ = fmt.Fprintf(&b, "\r\n")
+	_, _ = fmt.Fprintf(&b, "No reply yet to %q", subject)
+	if len(entry.To) > 0 {
+		_, _ = fmt.Fprintf(&b, " sent to %s", strings.Join(entry.To, ", "))
+	}
+	_, _ = fmt.Fprintf(&b, " on %s.\r\n", entry.SentAt.Format("2006-01-02 15:04"))
+	return []byte(b.String())
+}
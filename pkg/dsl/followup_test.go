@@ -0,0 +1,145 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollowUpValidation(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: awaiting
+search:
+  from: me@example.com
+output:
+  fields: [uid]
+actions:
+  follow_up:
+    within: 3d
+    remind: append
+`)
+	require.NoError(t, err)
+	require.NotNil(t, rule.Actions.FollowUp)
+	assert.Equal(t, DefaultFollowUpFlag, rule.Actions.FollowUp.flag())
+	window, err := rule.Actions.FollowUp.window()
+	require.NoError(t, err)
+	assert.Equal(t, 72*time.Hour, window)
+	assert.NotContains(t, rule.Actions.Custom, "follow_up")
+
+	tests := []struct {
+		name   string
+		config FollowUpConfig
+		errMsg string
+	}{
+		{"missing within", FollowUpConfig{}, "requires 'within'"},
+		{"bad within", FollowUpConfig{Within: "soon"}, "invalid follow_up 'within'"},
+		{"bad remind", FollowUpConfig{Within: "+1d", Remind: "email"}, "invalid follow_up remind"},
+		{"webhook without url", FollowUpConfig{Within: "1w", Remind: FollowUpRemindWebhook}, "requires 'webhook'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestFollowUpStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "follow-ups.json")
+	sent := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := LoadFollowUpStore(path)
+	require.NoError(t, err)
+	assert.Empty(t, store.Entries)
+
+	store.Entries = append(store.Entries, FollowUpEntry{
+		MessageID: "question@example.com",
+		Subject:   "Question",
+		To:        []string{"bob@example.com"},
+		SentAt:    sent,
+		DueAt:     sent.AddDate(0, 0, 3),
+		Remind:    FollowUpRemindRow,
+	})
+	require.NoError(t, store.Save())
+
+	loaded, err := LoadFollowUpStore(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Entries, 1)
+	assert.True(t, loaded.has("question@example.com"))
+	assert.False(t, loaded.has("other@example.com"))
+	assert.Equal(t, sent.AddDate(0, 0, 3), loaded.Entries[0].DueAt)
+}
+
+func TestReminderMessage(t *testing.T) {
+	now := time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC)
+	entry := FollowUpEntry{
+		MessageID: "question@example.com",
+		Subject:   "Café plans",
+		To:        []string{"bob@example.com"},
+		SentAt:    time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(reminderMessage(entry, now)))
+	require.NoError(t, err)
+	assert.Equal(t, "<question@example.com>", msg.Header.Get("In-Reply-To"))
+	assert.Equal(t, "<question@example.com>", msg.Header.Get("References"))
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Follow up: Café plans", subject)
+}
+
+func TestSendReminderWebhook(t *testing.T) {
+	var got FollowUpEntry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	entry := FollowUpEntry{
+		MessageID: "question@example.com",
+		Subject:   "Question",
+		Remind:    FollowUpRemindWebhook,
+		Webhook:   server.URL,
+	}
+	require.NoError(t, sendReminder(context.Background(), nil, entry, time.Now()))
+	assert.Equal(t, "question@example.com", got.MessageID)
+}
+
+func TestAddFollowUpEntriesConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "followups.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every rule tracks its own message and a shared one
+			entries := []FollowUpEntry{
+				{MessageID: fmt.Sprintf("<%d@example.com>", i)},
+				{MessageID: "<shared@example.com>"},
+			}
+			_, err := addFollowUpEntries(path, entries)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := LoadFollowUpStore(path)
+	require.NoError(t, err)
+	assert.Len(t, store.Entries, 21)
+}
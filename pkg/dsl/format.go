@@ -0,0 +1,73 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Size formats accepted by output.size_format.
+const (
+	SizeFormatHuman = "human"
+	SizeFormatBytes = "bytes"
+)
+
+// HumanizeSize formats a byte count with binary units, matching the units
+// accepted by size criteria: 512 B, 1.5 KB, 23.0 MB.
+func HumanizeSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	suffixes := []string{"KB", "MB", "GB"}
+	i := -1
+	for value >= unit && i < len(suffixes)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", value, suffixes[i])
+}
+
+// FormatDate renders t using output.date_format (a Go layout, RFC 3339 by
+// default) in output.timezone (UTC by default as sent by the server, "Local"
+// for the machine's zone, or an IANA name like "Europe/Berlin").
+func (o OutputConfig) FormatDate(t time.Time) string {
+	if loc, err := o.location(); err == nil && loc != nil {
+		t = t.In(loc)
+	}
+	layout := o.DateFormat
+	if layout == "" {
+		layout = time.RFC3339
+	}
+	return t.Format(layout)
+}
+
+// SizeValue returns size as shown in structured output (JSON, rows): the raw
+// byte count unless output.size_format is "human".
+func (o OutputConfig) SizeValue(size uint32) interface{} {
+	if o.SizeFormat == SizeFormatHuman {
+		return HumanizeSize(uint64(size))
+	}
+	return size
+}
+
+// sizeText returns size as shown in text and table output, humanized unless
+// output.size_format is "bytes".
+func (o OutputConfig) sizeText(size uint32) string {
+	if o.SizeFormat == SizeFormatBytes {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	return HumanizeSize(uint64(size))
+}
+
+func (o OutputConfig) location() (*time.Location, error) {
+	switch strings.ToLower(o.Timezone) {
+	case "":
+		return nil, nil
+	case "local":
+		return time.Local, nil
+	default:
+		return time.LoadLocation(o.Timezone)
+	}
+}
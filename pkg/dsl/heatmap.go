@@ -0,0 +1,124 @@
+package dsl
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// heatmapDays are the rows of a heatmap, weeks starting on Monday.
+var heatmapDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// heatmapShades render counts from none to the busiest hour.
+var heatmapShades = []string{".", "░", "▒", "▓", "█"}
+
+// Heatmap counts messages per day of the week and hour of the day.
+type Heatmap struct {
+	// Counts is indexed by day (Monday first) and hour
+	Counts [7][24]int
+}
+
+// LoadTimezone returns the zone named like output.timezone: "Local", an
+// IANA name, or empty for nil, which keeps the zone of each date.
+func LoadTimezone(name string) (*time.Location, error) {
+	loc, err := OutputConfig{Timezone: name}.location()
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// CollectHeatmap counts messages by the day and hour of their Date header in
+// loc, or in the zone of the header when loc is nil.
+func CollectHeatmap(messages []*EmailMessage, loc *time.Location) *Heatmap {
+	h := &Heatmap{}
+	for _, msg := range messages {
+		if msg.Envelope == nil || msg.Envelope.Date.IsZero() {
+			continue
+		}
+		date := msg.Envelope.Date
+		if loc != nil {
+			date = date.In(loc)
+		}
+		h.Counts[(int(date.Weekday())+6)%7][date.Hour()]++
+	}
+	return h
+}
+
+// Days returns the names of the heatmap's rows.
+func (h *Heatmap) Days() []string {
+	return heatmapDays
+}
+
+// Max returns the count of the busiest hour.
+func (h *Heatmap) Max() int {
+	busiest := 0
+	for _, day := range h.Counts {
+		for _, count := range day {
+			if count > busiest {
+				busiest = count
+			}
+		}
+	}
+	return busiest
+}
+
+// DayTotal returns the messages of a day, Monday being 0.
+func (h *Heatmap) DayTotal(day int) int {
+	total := 0
+	for _, count := range h.Counts[day] {
+		total += count
+	}
+	return total
+}
+
+// Shade renders a count as a block character scaled to the busiest hour.
+func (h *Heatmap) Shade(count int) string {
+	return heatmapShades[h.level(count, len(heatmapShades)-1)]
+}
+
+// level scales count to 0..levels, where only 0 maps to 0.
+func (h *Heatmap) level(count, levels int) int {
+	busiest := h.Max()
+	if count <= 0 || busiest == 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(count) * float64(levels) / float64(busiest)))
+}
+
+// WriteHTML writes the heatmap as a standalone HTML page.
+func (h *Heatmap) WriteHTML(w io.Writer, title string) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	b.WriteString("<style>\n" +
+		"body { font-family: sans-serif; }\n" +
+		"table { border-collapse: collapse; }\n" +
+		"th, td { padding: 4px; text-align: center; font-size: 12px; }\n" +
+		"td { min-width: 24px; border: 1px solid #eee; }\n" +
+		"</style>\n</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%s</h1>\n<table>\n<tr><th></th>", html.EscapeString(title))
+	for hour := 0; hour < 24; hour++ {
+		fmt.Fprintf(&b, "<th>%02d</th>", hour)
+	}
+	b.WriteString("<th>total</th></tr>\n")
+	busiest := h.Max()
+	for day, name := range heatmapDays {
+		fmt.Fprintf(&b, "<tr><th>%s</th>", name)
+		for hour, count := range h.Counts[day] {
+			alpha := 0.0
+			if busiest > 0 {
+				alpha = float64(count) / float64(busiest)
+			}
+			fmt.Fprintf(&b, "<td style=\"background: rgba(200, 40, 40, %.2f)\" title=\"%s %02d:00: %d\">%d</td>",
+				alpha, name, hour, count, count)
+		}
+		fmt.Fprintf(&b, "<th>%d</th></tr>\n", h.DayTotal(day))
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
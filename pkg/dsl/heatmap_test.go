@@ -0,0 +1,43 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectHeatmap(t *testing.T) {
+	at := func(day, hour int) *EmailMessage {
+		return &EmailMessage{Envelope: &EmailEnvelope{Date: time.Date(2025, 3, day, hour, 30, 0, 0, time.UTC)}}
+	}
+	// March 3, 2025 is a Monday
+	messages := []*EmailMessage{at(3, 9), at(3, 9), at(3, 9), at(3, 9), at(4, 23), at(9, 0), {Envelope: &EmailEnvelope{}}}
+
+	h := CollectHeatmap(messages, nil)
+	assert.Equal(t, 4, h.Counts[0][9])
+	assert.Equal(t, 1, h.Counts[1][23])
+	assert.Equal(t, 1, h.Counts[6][0])
+	assert.Equal(t, 4, h.Max())
+	assert.Equal(t, 4, h.DayTotal(0))
+	assert.Equal(t, "█", h.Shade(4))
+	assert.Equal(t, "░", h.Shade(1))
+	assert.Equal(t, ".", h.Shade(0))
+
+	// Tuesday 23:30 UTC is Wednesday in Tokyo
+	tokyo, err := LoadTimezone("Asia/Tokyo")
+	require.NoError(t, err)
+	h = CollectHeatmap(messages, tokyo)
+	assert.Equal(t, 1, h.Counts[2][8])
+	assert.Equal(t, 4, h.Counts[0][18])
+
+	_, err = LoadTimezone("Nowhere/City")
+	assert.Error(t, err)
+
+	var b strings.Builder
+	require.NoError(t, h.WriteHTML(&b, "INBOX <all>"))
+	assert.Contains(t, b.String(), "<title>INBOX &lt;all&gt;</title>")
+	assert.Contains(t, b.String(), `title="Mon 18:00: 4">4</td>`)
+}
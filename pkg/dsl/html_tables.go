@@ -0,0 +1,194 @@
+package dsl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxHTMLColspan bounds the cells a colspan expands to.
+const maxHTMLColspan = 100
+
+// HTMLTable is a table of a text/html part, as cell text. Header is the
+// header row, when the table has one.
+type HTMLTable struct {
+	Caption string     `json:"caption,omitempty"`
+	Header  []string   `json:"header,omitempty"`
+	Rows    [][]string `json:"rows"`
+}
+
+// Columns names the columns of t after its header cells, made unique, with
+// column_1, column_2, ... for cells without a header.
+func (t HTMLTable) Columns() []string {
+	width := len(t.Header)
+	for _, row := range t.Rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	columns := make([]string, width)
+	seen := map[string]bool{}
+	for i := range columns {
+		name := ""
+		if i < len(t.Header) {
+			name = t.Header[i]
+		}
+		if name == "" || seen[name] {
+			name = fmt.Sprintf("column_%d", i+1)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	return columns
+}
+
+// HTMLTablesConfig configures the html_tables output field.
+type HTMLTablesConfig struct {
+	// Selector picks the tables to parse. By default every table without
+	// nested tables is parsed, which skips the layout tables of most HTML
+	// mail.
+	Selector string `yaml:"selector,omitempty"`
+	// MinRows skips tables with fewer data rows (default: 1)
+	MinRows int `yaml:"min_rows,omitempty"`
+	// Rows makes smailnail output one row per table row instead of one per
+	// message, with the columns of the table, so rules convert tables to
+	// CSV
+	Rows bool `yaml:"rows,omitempty"`
+}
+
+// Validate checks if the html_tables config is valid
+func (c *HTMLTablesConfig) Validate() error {
+	if c.Selector != "" {
+		if _, err := parseCSSSelector(c.Selector); err != nil {
+			return err
+		}
+	}
+	if c.MinRows < 0 {
+		return fmt.Errorf("min_rows cannot be negative")
+	}
+	return nil
+}
+
+// outputsHTMLTables reports whether the rule outputs the html_tables field.
+func (rule *Rule) outputsHTMLTables() bool {
+	for _, fieldInterface := range rule.Output.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "html_tables" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHTMLTables returns the tables of docs picked by config.
+func parseHTMLTables(docs []*html.Node, config *HTMLTablesConfig) []HTMLTable {
+	if config == nil {
+		config = &HTMLTablesConfig{}
+	}
+	var selector cssSelector
+	if config.Selector != "" {
+		// The selector was validated with the rule
+		selector, _ = parseCSSSelector(config.Selector)
+	}
+	minRows := config.MinRows
+	if minRows == 0 {
+		minRows = 1
+	}
+
+	var tables []HTMLTable
+	for _, doc := range docs {
+		var nodes []*html.Node
+		if selector != nil {
+			for _, n := range selector.selectAll(doc) {
+				if n.Data == "table" {
+					nodes = append(nodes, n)
+				}
+			}
+		} else {
+			for _, n := range tableSelector.selectAll(doc) {
+				if len(tableSelector.selectAll(n)) == 1 {
+					nodes = append(nodes, n)
+				}
+			}
+		}
+		for _, n := range nodes {
+			if table := parseHTMLTable(n); len(table.Rows) >= minRows {
+				tables = append(tables, table)
+			}
+		}
+	}
+	return tables
+}
+
+var tableSelector = cssSelector{{compounds: []cssCompound{{tag: "table"}}}}
+
+// parseHTMLTable reads the rows of n, leaving out those of nested tables.
+// The first row is the header when it is in a thead or made of th cells.
+// Cells spanning several columns are followed by empty cells; row spans
+// are not expanded.
+func parseHTMLTable(n *html.Node) HTMLTable {
+	var table HTMLTable
+	first := true
+	var readRows func(*html.Node, bool)
+	readRows = func(parent *html.Node, inHead bool) {
+		for child := parent.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			switch child.Data {
+			case "caption":
+				table.Caption = htmlNodeText(child)
+			case "thead":
+				readRows(child, true)
+			case "tbody", "tfoot":
+				readRows(child, false)
+			case "tr":
+				cells, allHeaders := htmlTableRow(child)
+				if len(cells) == 0 {
+					continue
+				}
+				if first && (inHead || allHeaders) {
+					table.Header = cells
+				} else {
+					table.Rows = append(table.Rows, cells)
+				}
+				first = false
+			}
+		}
+	}
+	readRows(n, false)
+	return table
+}
+
+// htmlTableRow returns the cell text of tr, nil for a row without text,
+// and whether all its cells are th cells.
+func htmlTableRow(tr *html.Node) ([]string, bool) {
+	var cells []string
+	allHeaders, hasText := true, false
+	for cell := tr.FirstChild; cell != nil; cell = cell.NextSibling {
+		if cell.Type != html.ElementNode || (cell.Data != "td" && cell.Data != "th") {
+			continue
+		}
+		if cell.Data == "td" {
+			allHeaders = false
+		}
+		text := htmlNodeText(cell)
+		hasText = hasText || text != ""
+		cells = append(cells, text)
+		if value, ok := htmlNodeAttr(cell, "colspan"); ok {
+			if span, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && span > 1 {
+				if span > maxHTMLColspan {
+					span = maxHTMLColspan
+				}
+				for i := 1; i < span; i++ {
+					cells = append(cells, "")
+				}
+			}
+		}
+	}
+	if !hasText {
+		return nil, false
+	}
+	return cells, allHeaders
+}
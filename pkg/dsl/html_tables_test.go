@@ -0,0 +1,100 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+const statementHTML = `<html><body>
+<table width="600"><tr><td>
+  <h1>Monthly statement</h1>
+  <table class="transactions">
+    <caption>March 2025</caption>
+    <thead><tr><td>Date</td><td>Description</td><td>Amount</td></tr></thead>
+    <tbody>
+      <tr><td>2025-03-01</td><td>Coffee</td><td>-4.50</td></tr>
+      <tr><td>2025-03-02</td><td>Salary</td><td>3000.00</td></tr>
+      <tr><td colspan="2">Total</td><td>2995.50</td></tr>
+      <tr><td> </td><td></td><td></td></tr>
+    </tbody>
+  </table>
+  <table class="summary">
+    <tr><th>Balance</th><th>Balance</th></tr>
+    <tr><td>1000.00</td><td>3995.50</td><td>extra</td></tr>
+  </table>
+</td></tr></table>
+</body></html>`
+
+func parseStatement(t *testing.T, config *HTMLTablesConfig) []HTMLTable {
+	doc, err := html.Parse(strings.NewReader(statementHTML))
+	require.NoError(t, err)
+	return parseHTMLTables([]*html.Node{doc}, config)
+}
+
+func TestParseHTMLTables(t *testing.T) {
+	tables := parseStatement(t, nil)
+	require.Len(t, tables, 2, "the layout table is skipped")
+
+	assert.Equal(t, HTMLTable{
+		Caption: "March 2025",
+		Header:  []string{"Date", "Description", "Amount"},
+		Rows: [][]string{
+			{"2025-03-01", "Coffee", "-4.50"},
+			{"2025-03-02", "Salary", "3000.00"},
+			{"Total", "", "2995.50"},
+		},
+	}, tables[0])
+	assert.Equal(t, []string{"Date", "Description", "Amount"}, tables[0].Columns())
+
+	assert.Equal(t, []string{"Balance", "Balance"}, tables[1].Header)
+	assert.Equal(t, []string{"Balance", "column_2", "column_3"}, tables[1].Columns())
+
+	tables = parseStatement(t, &HTMLTablesConfig{Selector: "table.transactions"})
+	require.Len(t, tables, 1)
+	assert.Equal(t, "March 2025", tables[0].Caption)
+
+	tables = parseStatement(t, &HTMLTablesConfig{MinRows: 2})
+	require.Len(t, tables, 1)
+}
+
+func TestOutputHTMLTables(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: statements
+search:
+  subject_contains: statement
+output:
+  fields: [uid, html_tables]
+  html_tables:
+    selector: table.transactions
+    rows: true
+`)
+	require.NoError(t, err)
+	assert.True(t, rule.Output.HTMLTables.Rows)
+
+	msg := &EmailMessage{UID: 5, MimeParts: []MimePart{{Type: "text/html", Content: statementHTML}}}
+	extract := rule.extract(nil)
+	require.NotNil(t, extract)
+	extract(msg)
+	require.Len(t, msg.HTMLTables, 1)
+	assert.Len(t, msg.HTMLTables[0].Rows, 3)
+	assert.Nil(t, msg.Extracted)
+
+	output := jsonOutput(msg, rule.Output)
+	require.Len(t, output, 2)
+	assert.Equal(t, "html_tables", output[1].Key)
+
+	_, err = ParseRuleString(`
+name: statements
+search:
+  subject_contains: statement
+output:
+  fields: [uid, html_tables]
+  html_tables:
+    selector: "tr:first-child"
+`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,514 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Issue trackers built into the create_issue action.
+const (
+	IssueProviderGitHub = "github"
+	IssueProviderJira   = "jira"
+)
+
+// Defaults of the create_issue action.
+const (
+	DefaultIssueTitle = "{{ .Subject }}"
+	DefaultIssueBody  = "From: {{ .From.Address }}\nDate: {{ .Date }}\n\n{{ .Body }}"
+
+	DefaultGitHubAPIURL     = "https://api.github.com"
+	DefaultGitHubTokenEnv   = "GITHUB_TOKEN"
+	DefaultJiraTokenEnv     = "JIRA_API_TOKEN"
+	DefaultJiraIssueType    = "Task"
+	defaultIssueHTTPTimeout = 30 * time.Second
+)
+
+// CreateIssueConfig opens an issue per matched message in GitHub Issues or
+// Jira. Title and body are templates rendered against each message (see
+// TemplateContext); .Body needs text mime_parts in the output. Issues are
+// recorded in the issue state file by Message-ID, so a message never opens
+// two issues in the same repository or project.
+type CreateIssueConfig struct {
+	// Provider is github, jira or a tracker registered with
+	// RegisterIssueTracker
+	Provider string `yaml:"provider"`
+	// Repository is the GitHub repository, owner/name
+	Repository string `yaml:"repository,omitempty"`
+	// Project is the Jira project key and IssueType its issue type
+	// (default: Task)
+	Project   string `yaml:"project,omitempty"`
+	IssueType string `yaml:"issue_type,omitempty"`
+	// URL is the Jira site, or a GitHub Enterprise API URL
+	URL string `yaml:"url,omitempty"`
+	// Username is the Jira account email; without it the token is sent as a
+	// bearer token (Jira Data Center personal access tokens)
+	Username string `yaml:"username,omitempty"`
+	// TokenEnv names the environment variable holding the API token
+	// (default: GITHUB_TOKEN or JIRA_API_TOKEN)
+	TokenEnv string   `yaml:"token_env,omitempty"`
+	Title    string   `yaml:"title,omitempty"`
+	Body     string   `yaml:"body,omitempty"`
+	Labels   []string `yaml:"labels,omitempty"`
+	// CommentReplies adds replies to a message that opened an issue as
+	// comments on that issue instead of opening new ones
+	CommentReplies bool `yaml:"comment_replies,omitempty"`
+	// State is the issue state file (default: issues.json in
+	// DefaultStateDir)
+	State string `yaml:"state,omitempty"`
+}
+
+// Issue is a rendered issue.
+type Issue struct {
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// IssueRef identifies a created issue: "owner/repo#12" or "PROJ-12".
+type IssueRef struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// IssueTracker opens issues and comments on them.
+type IssueTracker interface {
+	CreateIssue(ctx context.Context, issue Issue) (IssueRef, error)
+	Comment(ctx context.Context, ref IssueRef, body string) error
+}
+
+// IssueTrackerFactory creates the IssueTracker of a create_issue action.
+type IssueTrackerFactory func(config *CreateIssueConfig) (IssueTracker, error)
+
+var (
+	issueTrackersMu sync.RWMutex
+	issueTrackers   = map[string]IssueTrackerFactory{
+		IssueProviderGitHub: newGitHubIssueTracker,
+		IssueProviderJira:   newJiraIssueTracker,
+	}
+)
+
+// RegisterIssueTracker adds a provider to the create_issue action.
+func RegisterIssueTracker(provider string, factory IssueTrackerFactory) error {
+	if provider == "" {
+		return fmt.Errorf("issue provider name is required")
+	}
+	if factory == nil {
+		return fmt.Errorf("issue tracker factory for %s is nil", provider)
+	}
+	issueTrackersMu.Lock()
+	defer issueTrackersMu.Unlock()
+	if _, ok := issueTrackers[provider]; ok {
+		return fmt.Errorf("issue provider %s is already registered", provider)
+	}
+	issueTrackers[provider] = factory
+	return nil
+}
+
+func lookupIssueTracker(provider string) (IssueTrackerFactory, bool) {
+	issueTrackersMu.RLock()
+	defer issueTrackersMu.RUnlock()
+	factory, ok := issueTrackers[provider]
+	return factory, ok
+}
+
+// Validate checks if the create_issue config is valid
+func (c *CreateIssueConfig) Validate() error {
+	if _, ok := lookupIssueTracker(c.Provider); !ok {
+		return fmt.Errorf("unknown provider %q", c.Provider)
+	}
+	switch c.Provider {
+	case IssueProviderGitHub:
+		if parts := strings.Split(c.Repository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("github issues need 'repository' as owner/name, got %q", c.Repository)
+		}
+	case IssueProviderJira:
+		if c.Project == "" {
+			return fmt.Errorf("jira issues need 'project'")
+		}
+		if c.URL == "" {
+			return fmt.Errorf("jira issues need 'url'")
+		}
+	}
+	if c.URL != "" {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http or https URL, got %q", c.URL)
+		}
+	}
+	for _, text := range []string{c.Title, c.Body} {
+		if err := ValidateTemplate(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// target names the repository or project issues go to, which the issue
+// state is keyed by.
+func (c *CreateIssueConfig) target() string {
+	switch c.Provider {
+	case IssueProviderGitHub:
+		return c.Provider + ":" + c.Repository
+	case IssueProviderJira:
+		return c.Provider + ":" + c.Project
+	}
+	return c.Provider + ":" + c.Repository + c.Project
+}
+
+func (c *CreateIssueConfig) token(defaultEnv string) string {
+	if c.TokenEnv != "" {
+		return os.Getenv(c.TokenEnv)
+	}
+	return os.Getenv(defaultEnv)
+}
+
+// IssueEntry records the issue a message opened.
+type IssueEntry struct {
+	MessageID string    `json:"message_id"`
+	Target    string    `json:"target"`
+	Issue     IssueRef  `json:"issue"`
+	Rule      string    `json:"rule,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IssueStore is the JSON file mapping messages to the issues they opened.
+type IssueStore struct {
+	Path    string
+	Entries []IssueEntry
+}
+
+// DefaultIssueStatePath returns the default issue state file in
+// DefaultStateDir.
+func DefaultIssueStatePath() string {
+	return filepath.Join(DefaultStateDir(), "issues.json")
+}
+
+// LoadIssueStore reads the store at path. A missing file is an empty store.
+func LoadIssueStore(path string) (*IssueStore, error) {
+	if path == "" {
+		path = DefaultIssueStatePath()
+	}
+	store := &IssueStore{Path: path}
+	if err := readStateFile(path, &store.Entries); err != nil {
+		return nil, fmt.Errorf("failed to load issue state: %w", err)
+	}
+	return store, nil
+}
+
+// Save writes the store atomically.
+func (s *IssueStore) Save() error {
+	if err := writeStateFile(s.Path, s.Entries); err != nil {
+		return fmt.Errorf("failed to save issue state: %w", err)
+	}
+	return nil
+}
+
+// Lookup returns the issue messageID opened in target.
+func (s *IssueStore) Lookup(target, messageID string) (IssueRef, bool) {
+	for _, entry := range s.Entries {
+		if entry.Target == target && entry.MessageID == messageID {
+			return entry.Issue, true
+		}
+	}
+	return IssueRef{}, false
+}
+
+// issueMu serializes the updates of issue state files by rules running
+// concurrently.
+var issueMu sync.Mutex
+
+// normalizeMessageID strips the angle brackets of a Message-ID.
+func normalizeMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// executeCreateIssue opens an issue per message that has none yet in the
+// target, or comments on the issue of the message it replies to. The state
+// is saved even when a message fails, so created issues are not repeated.
+// The state is locked for the whole run, as concurrent rules matching the
+// same message would otherwise both open an issue for it.
+func executeCreateIssue(messages []*EmailMessage, config *CreateIssueConfig, rule *Rule) ([]string, error) {
+	logger := rule.Logger()
+	if len(messages) == 0 {
+		return nil, nil
+	}
+	factory, ok := lookupIssueTracker(config.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown issue provider %q", config.Provider)
+	}
+	tracker, err := factory(config)
+	if err != nil {
+		return nil, err
+	}
+	issueMu.Lock()
+	defer issueMu.Unlock()
+	store, err := LoadIssueStore(config.State)
+	if err != nil {
+		return nil, err
+	}
+	title, body := config.Title, config.Body
+	if title == "" {
+		title = DefaultIssueTitle
+	}
+	if body == "" {
+		body = DefaultIssueBody
+	}
+	target := config.target()
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+
+	var details []string
+	changed := false
+	partial := &ActionPartialFailureError{Action: "create_issue"}
+	for _, msg := range messages {
+		if msg.Envelope == nil || msg.Envelope.MessageID == "" {
+			partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("message %d has no Message-ID, fetch the envelope", msg.UID)})
+			continue
+		}
+		messageID := normalizeMessageID(msg.Envelope.MessageID)
+		if ref, ok := store.Lookup(target, messageID); ok {
+			details = append(details, fmt.Sprintf("uid %d: already %s", msg.UID, ref.ID))
+			partial.Succeeded = append(partial.Succeeded, msg.UID)
+			continue
+		}
+
+		var parent *IssueRef
+		if config.CommentReplies {
+			for _, id := range msg.Envelope.InReplyTo {
+				if ref, ok := store.Lookup(target, normalizeMessageID(id)); ok {
+					parent = &ref
+					break
+				}
+			}
+		}
+
+		// A template failing for one message fails that message only, so the
+		// issues of the others are still recorded
+		data := NewTemplateContext(msg, rule)
+		renderedBody, err := RenderTemplate(body, data)
+		if err != nil {
+			partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("failed to render issue body: %w", err)})
+			continue
+		}
+		var renderedTitle string
+		if parent == nil {
+			if renderedTitle, err = RenderTemplate(title, data); err != nil {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: fmt.Errorf("failed to render issue title: %w", err)})
+				continue
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultIssueHTTPTimeout)
+		var ref IssueRef
+		if parent != nil {
+			ref = *parent
+			err = tracker.Comment(ctx, ref, renderedBody)
+		} else {
+			ref, err = tracker.CreateIssue(ctx, Issue{
+				Title:  strings.TrimSpace(renderedTitle),
+				Body:   renderedBody,
+				Labels: config.Labels,
+			})
+		}
+		cancel()
+		if err != nil {
+			logger.Warn().Err(err).Uint32("uid", msg.UID).Str("target", target).Msg("Failed to create issue")
+			partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: err})
+			continue
+		}
+
+		store.Entries = append(store.Entries, IssueEntry{
+			MessageID: messageID,
+			Target:    target,
+			Issue:     ref,
+			Rule:      ruleName,
+			CreatedAt: time.Now().UTC(),
+		})
+		changed = true
+		partial.Succeeded = append(partial.Succeeded, msg.UID)
+		if parent != nil {
+			details = append(details, fmt.Sprintf("uid %d: commented on %s", msg.UID, ref.ID))
+		} else {
+			details = append(details, fmt.Sprintf("uid %d: opened %s", msg.UID, ref.ID))
+		}
+	}
+
+	if changed {
+		if err := store.Save(); err != nil {
+			return details, err
+		}
+	}
+	if len(partial.Failed) > 0 {
+		return details, partial
+	}
+	return details, nil
+}
+
+// issueClient sends the JSON requests of the built-in trackers.
+type issueClient struct {
+	header http.Header
+	client *http.Client
+}
+
+func (c *issueClient) do(ctx context.Context, method, endpoint string, payload, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", endpoint, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// gitHubIssueTracker uses the GitHub REST API.
+type gitHubIssueTracker struct {
+	api        string
+	repository string
+	client     *issueClient
+}
+
+func newGitHubIssueTracker(config *CreateIssueConfig) (IssueTracker, error) {
+	token := config.token(DefaultGitHubTokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("github issues need a token (set %s)", tokenEnvName(config, DefaultGitHubTokenEnv))
+	}
+	api := DefaultGitHubAPIURL
+	if config.URL != "" {
+		api = strings.TrimRight(config.URL, "/")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	return &gitHubIssueTracker{
+		api:        api,
+		repository: config.Repository,
+		client:     &issueClient{header: header, client: &http.Client{}},
+	}, nil
+}
+
+func (g *gitHubIssueTracker) CreateIssue(ctx context.Context, issue Issue) (IssueRef, error) {
+	payload := map[string]interface{}{"title": issue.Title, "body": issue.Body}
+	if len(issue.Labels) > 0 {
+		payload["labels"] = issue.Labels
+	}
+	var result struct {
+		Number  int    `json:"number"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.client.do(ctx, http.MethodPost, g.api+"/repos/"+g.repository+"/issues", payload, &result); err != nil {
+		return IssueRef{}, err
+	}
+	return IssueRef{ID: fmt.Sprintf("%s#%d", g.repository, result.Number), URL: result.HTMLURL}, nil
+}
+
+func (g *gitHubIssueTracker) Comment(ctx context.Context, ref IssueRef, body string) error {
+	_, number, ok := strings.Cut(ref.ID, "#")
+	if !ok {
+		return fmt.Errorf("invalid github issue %q", ref.ID)
+	}
+	endpoint := g.api + "/repos/" + g.repository + "/issues/" + number + "/comments"
+	return g.client.do(ctx, http.MethodPost, endpoint, map[string]string{"body": body}, nil)
+}
+
+// jiraIssueTracker uses the Jira REST API v2, whose descriptions and
+// comments are plain text.
+type jiraIssueTracker struct {
+	site      string
+	project   string
+	issueType string
+	client    *issueClient
+}
+
+func newJiraIssueTracker(config *CreateIssueConfig) (IssueTracker, error) {
+	token := config.token(DefaultJiraTokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("jira issues need a token (set %s)", tokenEnvName(config, DefaultJiraTokenEnv))
+	}
+	header := http.Header{}
+	if config.Username != "" {
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(config.Username, token)
+		header.Set("Authorization", req.Header.Get("Authorization"))
+	} else {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	header.Set("Accept", "application/json")
+	issueType := config.IssueType
+	if issueType == "" {
+		issueType = DefaultJiraIssueType
+	}
+	return &jiraIssueTracker{
+		site:      strings.TrimRight(config.URL, "/"),
+		project:   config.Project,
+		issueType: issueType,
+		client:    &issueClient{header: header, client: &http.Client{}},
+	}, nil
+}
+
+func (j *jiraIssueTracker) CreateIssue(ctx context.Context, issue Issue) (IssueRef, error) {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": j.project},
+		"summary":     issue.Title,
+		"description": issue.Body,
+		"issuetype":   map[string]string{"name": j.issueType},
+	}
+	if len(issue.Labels) > 0 {
+		fields["labels"] = issue.Labels
+	}
+	var result struct {
+		Key string `json:"key"`
+	}
+	if err := j.client.do(ctx, http.MethodPost, j.site+"/rest/api/2/issue", map[string]interface{}{"fields": fields}, &result); err != nil {
+		return IssueRef{}, err
+	}
+	return IssueRef{ID: result.Key, URL: j.site + "/browse/" + result.Key}, nil
+}
+
+func (j *jiraIssueTracker) Comment(ctx context.Context, ref IssueRef, body string) error {
+	endpoint := j.site + "/rest/api/2/issue/" + url.PathEscape(ref.ID) + "/comment"
+	return j.client.do(ctx, http.MethodPost, endpoint, map[string]string{"body": body}, nil)
+}
+
+func tokenEnvName(config *CreateIssueConfig, defaultEnv string) string {
+	if config.TokenEnv != "" {
+		return config.TokenEnv
+	}
+	return defaultEnv
+}
@@ -0,0 +1,245 @@
+package dsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateIssueConfigValidate(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: support
+search:
+  to: support@example.com
+output:
+  fields: [uid, subject]
+actions:
+  create_issue:
+    provider: jira
+    url: https://example.atlassian.net
+    project: SUP
+    username: bot@example.com
+    title: "[mail] {{ .Subject }}"
+    comment_replies: true
+`)
+	require.NoError(t, err)
+	assert.Equal(t, "SUP", rule.Actions.CreateIssue.Project)
+	assert.True(t, rule.Actions.CreateIssue.CommentReplies)
+
+	for _, config := range []CreateIssueConfig{
+		{},
+		{Provider: "trello"},
+		{Provider: IssueProviderGitHub, Repository: "acme"},
+		{Provider: IssueProviderGitHub, Repository: "acme/app", URL: "ghe.example.com"},
+		{Provider: IssueProviderGitHub, Repository: "acme/app", Title: "{{ .Nope"},
+		{Provider: IssueProviderJira, Project: "SUP"},
+		{Provider: IssueProviderJira, URL: "https://example.atlassian.net"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestCreateIssueGitHub(t *testing.T) {
+	var created []map[string]interface{}
+	var comments []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var payload map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		switch r.URL.Path {
+		case "/repos/acme/app/issues":
+			created = append(created, payload)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"number": 12, "html_url": "https://github.com/acme/app/issues/12"}`))
+		case "/repos/acme/app/issues/12/comments":
+			comments = append(comments, payload["body"].(string))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	t.Setenv(DefaultGitHubTokenEnv, "secret")
+
+	config := &CreateIssueConfig{
+		Provider:       IssueProviderGitHub,
+		Repository:     "acme/app",
+		URL:            server.URL,
+		Labels:         []string{"support"},
+		CommentReplies: true,
+		State:          filepath.Join(t.TempDir(), "issues.json"),
+	}
+	require.NoError(t, config.Validate())
+
+	first := &EmailMessage{UID: 1, Envelope: &EmailEnvelope{Subject: "Printer on fire", MessageID: "<fire@example.com>"}}
+	reply := &EmailMessage{UID: 2, Envelope: &EmailEnvelope{
+		Subject:   "Re: Printer on fire",
+		MessageID: "<reply@example.com>",
+		InReplyTo: []string{"fire@example.com"},
+	}}
+	details, err := executeCreateIssue([]*EmailMessage{first, reply}, config, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uid 1: opened acme/app#12", "uid 2: commented on acme/app#12"}, details)
+	require.Len(t, created, 1)
+	assert.Equal(t, "Printer on fire", created[0]["title"])
+	assert.Equal(t, []interface{}{"support"}, created[0]["labels"])
+	assert.Len(t, comments, 1)
+
+	// The state keeps the same messages from opening issues again
+	details, err = executeCreateIssue([]*EmailMessage{first}, config, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"uid 1: already acme/app#12"}, details)
+	assert.Len(t, created, 1)
+
+	store, err := LoadIssueStore(config.State)
+	require.NoError(t, err)
+	ref, ok := store.Lookup("github:acme/app", "reply@example.com")
+	require.True(t, ok)
+	assert.Equal(t, "https://github.com/acme/app/issues/12", ref.URL)
+}
+
+func TestCreateIssueJira(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "bot@example.com", username)
+		assert.Equal(t, "token", password)
+		if r.URL.Path != "/rest/api/2/issue" {
+			http.NotFound(w, r)
+			return
+		}
+		var payload struct {
+			Fields struct {
+				Project   map[string]string `json:"project"`
+				Summary   string            `json:"summary"`
+				IssueType map[string]string `json:"issuetype"`
+			} `json:"fields"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, "SUP", payload.Fields.Project["key"])
+		assert.Equal(t, "Task", payload.Fields.IssueType["name"])
+		if payload.Fields.Summary == "fail" {
+			http.Error(w, `{"errorMessages": ["nope"]}`, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id": "10001", "key": "SUP-7"}`))
+	}))
+	defer server.Close()
+	t.Setenv("SUPPORT_JIRA_TOKEN", "token")
+
+	config := &CreateIssueConfig{
+		Provider: IssueProviderJira,
+		URL:      server.URL,
+		Project:  "SUP",
+		Username: "bot@example.com",
+		TokenEnv: "SUPPORT_JIRA_TOKEN",
+		State:    filepath.Join(t.TempDir(), "issues.json"),
+	}
+	require.NoError(t, config.Validate())
+
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Subject: "Login broken", MessageID: "<login@example.com>"}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "fail", MessageID: "<fail@example.com>"}},
+		{UID: 3},
+	}
+	details, err := executeCreateIssue(messages, config, nil)
+	var partial *ActionPartialFailureError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []uint32{1}, partial.Succeeded)
+	assert.Len(t, partial.Failed, 2)
+	assert.Equal(t, []string{"uid 1: opened SUP-7"}, details)
+
+	// Issues opened before the failure are kept
+	store, err := LoadIssueStore(config.State)
+	require.NoError(t, err)
+	ref, ok := store.Lookup("jira:SUP", "login@example.com")
+	require.True(t, ok)
+	assert.Equal(t, server.URL+"/browse/SUP-7", ref.URL)
+}
+
+func TestCreateIssueRenderErrorKeepsState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"number": 3, "html_url": "https://github.com/acme/app/issues/3"}`))
+	}))
+	defer server.Close()
+	t.Setenv(DefaultGitHubTokenEnv, "secret")
+
+	config := &CreateIssueConfig{
+		Provider:   IssueProviderGitHub,
+		Repository: "acme/app",
+		URL:        server.URL,
+		Title:      `{{ if eq .Subject "boom" }}{{ fail "bad subject" }}{{ end }}{{ .Subject }}`,
+		State:      filepath.Join(t.TempDir(), "issues.json"),
+	}
+	require.NoError(t, config.Validate())
+
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Subject: "Printer on fire", MessageID: "<fire@example.com>"}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "boom", MessageID: "<boom@example.com>"}},
+	}
+	details, err := executeCreateIssue(messages, config, nil)
+	var partial *ActionPartialFailureError
+	require.ErrorAs(t, err, &partial)
+	assert.Equal(t, []uint32{1}, partial.Succeeded)
+	require.Len(t, partial.Failed, 1)
+	assert.Equal(t, uint32(2), partial.Failed[0].UID)
+	assert.Equal(t, []string{"uid 1: opened acme/app#3"}, details)
+
+	store, err := LoadIssueStore(config.State)
+	require.NoError(t, err)
+	_, ok := store.Lookup("github:acme/app", "fire@example.com")
+	assert.True(t, ok)
+	_, ok = store.Lookup("github:acme/app", "boom@example.com")
+	assert.False(t, ok)
+}
+
+func TestCreateIssueConcurrentRules(t *testing.T) {
+	var created atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		number := created.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"number": %d, "html_url": "https://github.com/acme/app/issues/%d"}`, number, number)
+	}))
+	defer server.Close()
+	t.Setenv(DefaultGitHubTokenEnv, "secret")
+
+	config := &CreateIssueConfig{
+		Provider:   IssueProviderGitHub,
+		Repository: "acme/app",
+		URL:        server.URL,
+		State:      filepath.Join(t.TempDir(), "issues.json"),
+	}
+	require.NoError(t, config.Validate())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Every rule matches its own message and a shared one
+			messages := []*EmailMessage{
+				{UID: uint32(i + 1), Envelope: &EmailEnvelope{Subject: "Own", MessageID: fmt.Sprintf("<%d@example.com>", i)}},
+				{UID: 100, Envelope: &EmailEnvelope{Subject: "Shared", MessageID: "<shared@example.com>"}},
+			}
+			_, err := executeCreateIssue(messages, config, nil)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(11), created.Load(), "the shared message gets a single issue")
+	store, err := LoadIssueStore(config.State)
+	require.NoError(t, err)
+	assert.Len(t, store.Entries, 11)
+}
@@ -0,0 +1,177 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// KeywordCriteria defines criteria for searching by keywords, the
+// user-defined flags such as $Todo or $Receipt. Unlike flags, keywords are
+// matched verbatim: "seen" is not \Seen.
+type KeywordCriteria struct {
+	Has    []string `yaml:"has,omitempty"`
+	NotHas []string `yaml:"not_has,omitempty"`
+}
+
+// Validate checks that every keyword is an IMAP atom and not a system flag.
+func (k *KeywordCriteria) Validate() error {
+	for _, list := range []struct {
+		name     string
+		keywords []string
+	}{{"has", k.Has}, {"not_has", k.NotHas}} {
+		for _, keyword := range list.keywords {
+			if err := validateKeyword(keyword); err != nil {
+				return fmt.Errorf("invalid keyword in '%s' list: %w", list.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateKeyword(keyword string) error {
+	if keyword == "" {
+		return fmt.Errorf("empty keyword")
+	}
+	if strings.HasPrefix(keyword, "\\") {
+		return fmt.Errorf("%s is a system flag, use flags instead of keywords", keyword)
+	}
+	if strings.ContainsAny(keyword, " \t\r\n(){%*\"]\\") {
+		return fmt.Errorf("%q is not a valid IMAP keyword", keyword)
+	}
+	return nil
+}
+
+// searchedKeywords returns the keywords the search block and its nested
+// conditions match on, sorted and without duplicates.
+func (s SearchConfig) searchedKeywords() []string {
+	seen := make(map[string]bool)
+	var collect func(s SearchConfig)
+	collect = func(s SearchConfig) {
+		if s.Keywords != nil {
+			for _, keyword := range append(append([]string(nil), s.Keywords.Has...), s.Keywords.NotHas...) {
+				seen[keyword] = true
+			}
+		}
+		for _, condition := range s.Conditions {
+			collect(condition.SearchConfig)
+		}
+	}
+	collect(s)
+
+	keywords := make([]string, 0, len(seen))
+	for keyword := range seen {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// MailboxKeywords returns the keywords a selected mailbox lists in its FLAGS
+// and PERMANENTFLAGS, mapped to whether they are permanent, and whether the
+// mailbox accepts new keywords (\* in PERMANENTFLAGS).
+func MailboxKeywords(data *imap.SelectData) (map[string]bool, bool) {
+	keywords := make(map[string]bool)
+	newKeywords := false
+	if data == nil {
+		return keywords, newKeywords
+	}
+	for _, flag := range data.Flags {
+		if !strings.HasPrefix(string(flag), "\\") {
+			keywords[string(flag)] = false
+		}
+	}
+	for _, flag := range data.PermanentFlags {
+		switch {
+		case flag == imap.FlagWildcard:
+			newKeywords = true
+		case !strings.HasPrefix(string(flag), "\\"):
+			keywords[string(flag)] = true
+		}
+	}
+	return keywords, newKeywords
+}
+
+// CheckKeywords checks the keywords the rule searches against the mailbox
+// selected with data. A keyword the mailbox neither lists nor can store
+// matches no message, so it is reported as ErrKeywordUnsupported.
+func (rule *Rule) CheckKeywords(data *imap.SelectData) error {
+	searched := rule.Search.searchedKeywords()
+	if len(searched) == 0 || data == nil {
+		return nil
+	}
+	keywords, newKeywords := MailboxKeywords(data)
+	if newKeywords {
+		return nil
+	}
+	var unsupported []string
+	for _, keyword := range searched {
+		if _, ok := keywords[keyword]; !ok {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("%w: %s (the mailbox permits %s)",
+			ErrKeywordUnsupported, strings.Join(unsupported, ", "), formatFlags(data.PermanentFlags))
+	}
+	return nil
+}
+
+func formatFlags(flags []imap.Flag) string {
+	if len(flags) == 0 {
+		return "no flags"
+	}
+	names := make([]string, len(flags))
+	for i, flag := range flags {
+		names[i] = string(flag)
+	}
+	return strings.Join(names, " ")
+}
+
+// KeywordInfo describes a keyword of a mailbox.
+type KeywordInfo struct {
+	Keyword string `json:"keyword"`
+	// Permanent is whether the keyword is in PERMANENTFLAGS, so that setting
+	// it lasts beyond the session
+	Permanent bool `json:"permanent"`
+	// Messages is the number of messages with the keyword, -1 if not counted
+	Messages int `json:"messages"`
+}
+
+// MailboxKeywordReport lists the keywords of a mailbox.
+type MailboxKeywordReport struct {
+	Mailbox string `json:"mailbox"`
+	// AcceptsNew is whether messages can be given keywords not listed yet
+	AcceptsNew bool          `json:"accepts_new"`
+	Keywords   []KeywordInfo `json:"keywords"`
+}
+
+// ListKeywords lists the keywords of mailbox, opened read-only, and with
+// count the number of messages having each of them.
+func ListKeywords(client *imapclient.Client, mailbox string, count bool) (*MailboxKeywordReport, error) {
+	selectData, err := client.Select(mailbox, &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+	keywords, acceptsNew := MailboxKeywords(selectData)
+
+	report := &MailboxKeywordReport{Mailbox: mailbox, AcceptsNew: acceptsNew}
+	for keyword, permanent := range keywords {
+		info := KeywordInfo{Keyword: keyword, Permanent: permanent, Messages: -1}
+		if count {
+			data, err := client.UIDSearch(&imap.SearchCriteria{Flag: []imap.Flag{imap.Flag(keyword)}}, nil).Wait()
+			if err != nil {
+				return nil, fmt.Errorf("failed to count messages with keyword %s: %w", keyword, wrapSearchError(err))
+			}
+			info.Messages = len(data.AllUIDs())
+		}
+		report.Keywords = append(report.Keywords, info)
+	}
+	sort.Slice(report.Keywords, func(i, j int) bool {
+		return report.Keywords[i].Keyword < report.Keywords[j].Keyword
+	})
+	return report, nil
+}
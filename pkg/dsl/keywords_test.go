@@ -0,0 +1,84 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const keywordRule = `
+name: todo
+search:
+  keywords:
+    has: ["$Todo"]
+    not_has: ["$Done"]
+output:
+  fields: [uid]
+actions:
+  flags:
+    add: [flagged]
+`
+
+func TestKeywordCriteria(t *testing.T) {
+	rule, err := ParseRuleString(keywordRule)
+	require.NoError(t, err)
+
+	criteria, _, err := BuildSearchCriteria(rule.Search, &rule.Output)
+	require.NoError(t, err)
+	assert.Equal(t, []imap.Flag{"$Todo"}, criteria.Flag)
+	assert.Equal(t, []imap.Flag{"$Done"}, criteria.NotFlag)
+
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Contains(t, script, `hasflag "$Todo"`)
+	assert.Contains(t, script, `not hasflag "$Done"`)
+}
+
+func TestSearchedKeywords(t *testing.T) {
+	s := SearchConfig{
+		Operator: OperatorOr,
+		Conditions: []ComplexSearchConfig{
+			{SearchConfig: SearchConfig{Keywords: &KeywordCriteria{Has: []string{"$Todo"}}}},
+			{SearchConfig: SearchConfig{Keywords: &KeywordCriteria{Has: []string{"$Receipt"}, NotHas: []string{"$Todo"}}}},
+		},
+	}
+	assert.Equal(t, []string{"$Receipt", "$Todo"}, s.searchedKeywords())
+}
+
+func TestKeywordValidation(t *testing.T) {
+	for _, keywords := range []string{`["\\Seen"]`, `[""]`, `["two words"]`, `["(x)"]`} {
+		_, err := ParseRuleString(`
+name: r
+search:
+  keywords:
+    has: ` + keywords + `
+output:
+  fields: [uid]
+`)
+		assert.Error(t, err, keywords)
+	}
+}
+
+func TestCheckKeywords(t *testing.T) {
+	rule, err := ParseRuleString(keywordRule)
+	require.NoError(t, err)
+
+	data := &imap.SelectData{
+		Flags:          []imap.Flag{imap.FlagSeen, "$Todo", "$Done"},
+		PermanentFlags: []imap.Flag{imap.FlagSeen, "$Todo"},
+	}
+	keywords, acceptsNew := MailboxKeywords(data)
+	assert.Equal(t, map[string]bool{"$Todo": true, "$Done": false}, keywords)
+	assert.False(t, acceptsNew)
+	assert.NoError(t, rule.CheckKeywords(data))
+
+	data.Flags = []imap.Flag{imap.FlagSeen, "$Todo"}
+	err = rule.CheckKeywords(data)
+	assert.ErrorIs(t, err, ErrKeywordUnsupported)
+	assert.ErrorContains(t, err, "$Done")
+
+	data.PermanentFlags = append(data.PermanentFlags, imap.FlagWildcard)
+	assert.NoError(t, rule.CheckKeywords(data))
+}
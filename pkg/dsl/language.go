@@ -0,0 +1,236 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// LanguageUndetermined is the language of messages too short or too mixed
+// to tell.
+const LanguageUndetermined = "und"
+
+// maxLanguageLetters bounds the text DetectLanguage looks at.
+const maxLanguageLetters = 10000
+
+// languageStopwords are frequent words of the languages written in the Latin
+// script. Words common to several of these languages are left out.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "it", "for", "you", "with", "this", "are", "be", "on", "have", "not", "was", "we", "your", "will", "from", "please", "thanks"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "sie", "mit", "den", "von", "zu", "auf", "für", "ein", "eine", "es", "wir", "auch", "sich", "dem", "bitte", "ihr", "wird", "danke"},
+	"fr": {"le", "la", "les", "et", "est", "des", "une", "un", "pour", "que", "qui", "dans", "pas", "vous", "nous", "sur", "avec", "ce", "du", "au", "je", "merci", "sont", "être"},
+	"es": {"el", "los", "las", "que", "es", "una", "por", "para", "con", "no", "su", "del", "al", "lo", "como", "más", "pero", "sus", "está", "gracias", "usted", "y"},
+	"it": {"il", "di", "che", "è", "per", "una", "non", "sono", "con", "del", "della", "gli", "questo", "anche", "ma", "come", "grazie", "alla", "nel", "ci"},
+	"pt": {"o", "os", "as", "que", "não", "uma", "com", "para", "por", "do", "da", "dos", "em", "você", "obrigado", "são", "mais", "mas", "é", "ao", "seu", "sua"},
+	"nl": {"het", "een", "en", "van", "is", "niet", "ik", "je", "dat", "met", "voor", "op", "zijn", "wij", "u", "ook", "maar", "dit", "bedankt", "naar"},
+	"sv": {"och", "att", "det", "som", "är", "på", "för", "med", "jag", "inte", "till", "av", "har", "vi", "om", "tack", "den", "du", "ett"},
+	"pl": {"i", "w", "nie", "na", "się", "jest", "że", "to", "z", "do", "jak", "dla", "po", "ale", "czy", "dziękuję", "są", "od", "tak"},
+}
+
+// latinLanguages fixes the order ties are broken in.
+var latinLanguages = []string{"en", "de", "fr", "es", "it", "pt", "nl", "sv", "pl"}
+
+var stopwordLanguages = func() map[string][]string {
+	ret := map[string][]string{}
+	for _, lang := range latinLanguages {
+		for _, word := range languageStopwords[lang] {
+			ret[word] = append(ret[word], lang)
+		}
+	}
+	return ret
+}()
+
+// scriptLanguages are the languages recognized by their script alone.
+var scriptLanguages = []struct {
+	lang  string
+	table *unicode.RangeTable
+}{
+	{"ko", unicode.Hangul},
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"zh", unicode.Han},
+	{"ru", unicode.Cyrillic},
+	{"el", unicode.Greek},
+	{"ar", unicode.Arabic},
+	{"he", unicode.Hebrew},
+	{"th", unicode.Thai},
+	{"hi", unicode.Devanagari},
+}
+
+// SupportedLanguages returns the codes DetectLanguage can return.
+func SupportedLanguages() []string {
+	set := map[string]bool{LanguageUndetermined: true, "uk": true, "fa": true}
+	for _, lang := range latinLanguages {
+		set[lang] = true
+	}
+	for _, script := range scriptLanguages {
+		set[script.lang] = true
+	}
+	ret := make([]string, 0, len(set))
+	for lang := range set {
+		ret = append(ret, lang)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// DetectLanguage returns the ISO 639-1 code of the language text is written
+// in, or LanguageUndetermined. Text in a non-Latin script is told by its
+// script, Latin text by counting frequent words of the supported languages.
+func DetectLanguage(text string) string {
+	scripts := map[string]int{}
+	latin, letters := 0, 0
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		if letters >= maxLanguageLetters {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			if r != '\'' {
+				flush()
+			}
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			word.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		flush()
+		for _, script := range scriptLanguages {
+			if unicode.Is(script.table, r) {
+				scripts[script.lang]++
+				break
+			}
+		}
+	}
+	flush()
+	if letters == 0 {
+		return LanguageUndetermined
+	}
+
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]*10 >= scripts["zh"] {
+		scripts["ja"] += scripts["zh"]
+		scripts["zh"] = 0
+	}
+	best, bestCount := "", 0
+	for _, script := range scriptLanguages {
+		if scripts[script.lang] > bestCount {
+			best, bestCount = script.lang, scripts[script.lang]
+		}
+	}
+	if bestCount*2 > letters {
+		switch best {
+		case "ru":
+			if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+				return "uk"
+			}
+		case "ar":
+			if strings.ContainsAny(text, "پچژگ") {
+				return "fa"
+			}
+		}
+		return best
+	}
+	if latin*2 <= letters {
+		return LanguageUndetermined
+	}
+
+	scores := map[string]int{}
+	for _, w := range words {
+		for _, lang := range stopwordLanguages[w] {
+			scores[lang]++
+		}
+	}
+	best, bestCount = LanguageUndetermined, 0
+	for _, lang := range latinLanguages {
+		if scores[lang] > bestCount {
+			best, bestCount = lang, scores[lang]
+		}
+	}
+	if bestCount < 2 {
+		return LanguageUndetermined
+	}
+	return best
+}
+
+// validateLanguages checks the language criterion.
+func (s SearchConfig) validateLanguages() error {
+	supported := SupportedLanguages()
+	for _, lang := range s.Language {
+		i := sort.SearchStrings(supported, lang)
+		if i == len(supported) || supported[i] != lang {
+			return fmt.Errorf("unsupported language %q (supported: %s)", lang, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+// needsLanguage reports whether the rule searches or outputs the language.
+func (rule *Rule) needsLanguage() bool {
+	if len(rule.Search.Language) > 0 {
+		return true
+	}
+	for _, fieldInterface := range rule.Output.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "language" {
+			return true
+		}
+	}
+	return false
+}
+
+// languageFilter returns a predicate for search.language, or nil if the
+// search has none.
+func (rule *Rule) languageFilter() func(*EmailMessage) bool {
+	if len(rule.Search.Language) == 0 {
+		return nil
+	}
+	languages := map[string]bool{}
+	for _, lang := range rule.Search.Language {
+		languages[lang] = true
+	}
+	return func(msg *EmailMessage) bool {
+		return languages[msg.Language]
+	}
+}
+
+// messageLanguage detects the language of a message from its decrypted
+// text, if any, or from text.
+func messageLanguage(msg *EmailMessage, text string) string {
+	if msg.Decrypted && msg.decryptedText != "" {
+		return DetectLanguage(msg.decryptedText)
+	}
+	return DetectLanguage(text)
+}
+
+// fetchMessageTexts fetches the full messages and returns their text by
+// sequence number.
+func fetchMessageTexts(client *imapclient.Client, messages []*imapclient.FetchMessageBuffer) (map[uint32]string, error) {
+	var seqSet imap.SeqSet
+	for _, msg := range messages {
+		seqSet.AddNum(msg.SeqNum)
+	}
+	section := &imap.FetchItemBodySection{Peek: true}
+	fetched, err := client.Fetch(seqSet, &imap.FetchOptions{BodySection: []*imap.FetchItemBodySection{section}}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message text: %w", err)
+	}
+	texts := make(map[uint32]string, len(fetched))
+	for _, msg := range fetched {
+		texts[msg.SeqNum] = rawMessageText(msg.FindBodySection(section))
+	}
+	return texts, nil
+}
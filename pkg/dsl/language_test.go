@@ -0,0 +1,83 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Hi Bob, thanks for the notes. I will send you the report on Friday, please have a look.", "en"},
+		{"Hallo Anna, vielen Dank für die Unterlagen. Ich schicke dir den Bericht am Freitag, es ist nicht fertig.", "de"},
+		{"Bonjour, merci pour les documents. Je vous envoie le rapport vendredi, il n'est pas encore prêt.", "fr"},
+		{"Hola, gracias por los documentos. Te envío el informe el viernes, todavía no está listo para usted.", "es"},
+		{"Ciao, grazie per i documenti. Ti mando il rapporto venerdì, non è ancora pronto ma ci sono quasi.", "it"},
+		{"Olá, obrigado pelos documentos. Envio o relatório na sexta-feira, ainda não está pronto para você.", "pt"},
+		{"Hoi, bedankt voor de documenten. Ik stuur het rapport vrijdag, het is nog niet klaar maar bijna.", "nl"},
+		{"Hej, tack för dokumenten. Jag skickar rapporten på fredag, den är inte klar än men nästan.", "sv"},
+		{"Cześć, dziękuję za dokumenty. Wyślę raport w piątek, nie jest jeszcze gotowy, ale to już blisko.", "pl"},
+		{"Привет, спасибо за документы. Отправлю отчёт в пятницу.", "ru"},
+		{"Привіт, дякую за документи. Надішлю звіт у пʼятницю, він ще не готовий.", "uk"},
+		{"資料ありがとうございます。金曜日にレポートを送ります。", "ja"},
+		{"谢谢你的资料。我星期五把报告发给你。", "zh"},
+		{"자료 감사합니다. 금요일에 보고서를 보내겠습니다.", "ko"},
+		{"Ευχαριστώ για τα έγγραφα. Θα στείλω την αναφορά την Παρασκευή.", "el"},
+		{"شكرا على المستندات. سأرسل التقرير يوم الجمعة.", "ar"},
+		{"OK", LanguageUndetermined},
+		{"12345 -- ###", LanguageUndetermined},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetectLanguage(tt.text))
+		})
+	}
+}
+
+func TestLanguageCriterion(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: english
+search:
+  language: [en]
+output:
+  fields: [uid, language]
+`)
+	require.NoError(t, err)
+	messages, err := rule.FilterLocalMessages(readSampleMbox(t))
+	require.NoError(t, err)
+	var uids []uint32
+	for _, msg := range messages {
+		assert.Equal(t, "en", msg.Language)
+		uids = append(uids, msg.UID)
+	}
+	// The digest is a single line of HTML
+	assert.Equal(t, []uint32{1, 3}, uids)
+
+	_, err = ParseRuleString(`
+name: typo
+search:
+  language: [english]
+output:
+  fields: [uid]
+`)
+	assert.ErrorContains(t, err, `unsupported language "english"`)
+
+	_, err = ParseRuleString(`
+name: nested
+search:
+  operator: or
+  conditions:
+    - language: [de]
+    - from: a@example.com
+output:
+  fields: [uid]
+`)
+	assert.ErrorContains(t, err, "only supported at the top level")
+
+	_, err = CompileSieve(rule)
+	assert.ErrorContains(t, err, "language cannot be compiled to Sieve")
+}
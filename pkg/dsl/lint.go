@@ -0,0 +1,167 @@
+package dsl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severities of a LintIssue, from most to least severe.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Codes of a LintIssue.
+const (
+	LintSubstringMatch  = "substring_match"
+	LintSingleCondition = "single_condition"
+	LintUnboundedDelete = "unbounded_delete"
+	LintDeleteFalse     = "delete_false"
+	LintUnknownField    = "unknown_field"
+	LintAmbiguousDate   = "ambiguous_date"
+)
+
+// LintIssue is a problem Lint found in a valid rule: something that does
+// not do what it reads like.
+type LintIssue struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	// Path locates the problem in the rule file, e.g. search.conditions[1].from
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SeverityRank orders severities: errors rank highest, unknown severities 0.
+func SeverityRank(severity string) int {
+	switch severity {
+	case SeverityError:
+		return 3
+	case SeverityWarning:
+		return 2
+	case SeverityInfo:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// outputFieldNames are the built-in output fields; other names are only
+// valid as paths.
+var outputFieldNames = map[string]bool{
+	"uid": true, "subject": true, "from": true, "to": true, "date": true,
+	"flags": true, "size": true, "body": true, "mime_parts": true,
+	"attachments": true, "encrypted": true, "list_id": true, "label": true,
+	"summary": true, "language": true, "html_tables": true,
+}
+
+// slashDateRe matches the slash dates parseDate reads as month first, then
+// as day first.
+var slashDateRe = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})/\d{4}$`)
+
+// Lint checks the rule for criteria and actions that are valid but likely do
+// not do what their author meant, in the order of the search, output and
+// actions sections.
+func (rule *Rule) Lint() []LintIssue {
+	var issues []LintIssue
+	add := func(severity, code, path, message string, args ...interface{}) {
+		issues = append(issues, LintIssue{Severity: severity, Code: code, Path: path, Message: fmt.Sprintf(message, args...)})
+	}
+
+	lintSearch(rule.Search, "search", add)
+
+	extracted := rule.Output.extractedFields()
+	for i, fieldInterface := range rule.Output.Fields {
+		field, ok := fieldInterface.(Field)
+		if !ok || field.IsPath() || outputFieldNames[field.Name] || extracted[field.Name] {
+			continue
+		}
+		add(SeverityWarning, LintUnknownField, fmt.Sprintf("output.fields[%d]", i),
+			"unknown output field %q is left out of the output", field.Name)
+	}
+
+	lintDelete(&rule.Actions, "actions", rule.Output.Limit, add)
+	return issues
+}
+
+func lintSearch(s SearchConfig, path string, add func(severity, code, path, message string, args ...interface{})) {
+	for _, date := range []struct{ key, value string }{{"since", s.Since}, {"before", s.Before}, {"on", s.On}} {
+		matches := slashDateRe.FindStringSubmatch(date.value)
+		if matches == nil {
+			continue
+		}
+		first, _ := strconv.Atoi(matches[1])
+		second, _ := strconv.Atoi(matches[2])
+		if first <= 12 && second <= 12 && first != second {
+			add(SeverityWarning, LintAmbiguousDate, path+"."+date.key,
+				"%q is read as month/day/year; write dates as YYYY-MM-DD", date.value)
+		}
+	}
+
+	if s.Subject != "" {
+		add(SeverityWarning, LintSubstringMatch, path+".subject",
+			"IMAP matches subjects containing %q, not only this exact subject", s.Subject)
+	}
+	for _, header := range []struct{ key, value string }{{"from", s.From}, {"to", s.To}, {"cc", s.Cc}, {"bcc", s.Bcc}} {
+		if strings.Contains(header.value, "@") {
+			add(SeverityInfo, LintSubstringMatch, path+"."+header.key,
+				"IMAP matches any %s header containing %q, such as x%s", header.key, header.value, header.value)
+		}
+	}
+
+	if (s.Operator == OperatorOr || s.Operator == OperatorAnd) && len(s.Conditions) == 1 {
+		add(SeverityWarning, LintSingleCondition, path+".conditions",
+			"operator %s has a single condition and matches like the condition alone", s.Operator)
+	}
+	for i, condition := range s.Conditions {
+		lintSearch(condition.SearchConfig, fmt.Sprintf("%s.conditions[%d]", path, i), add)
+	}
+}
+
+func lintDelete(actions *ActionConfig, path string, limit int, add func(severity, code, path, message string, args ...interface{})) {
+	if actions == nil {
+		return
+	}
+	if actions.Delete != nil {
+		if deleteValue, ok := actions.Delete.(bool); ok && !deleteValue {
+			add(SeverityError, LintDeleteFalse, path+".delete",
+				"delete: false still deletes the matched messages; remove the action instead")
+		}
+		if limit == 0 {
+			severity, what := SeverityWarning, "moves every matched message to Trash"
+			if !deletesToTrash(actions.Delete) {
+				severity, what = SeverityError, "permanently deletes every matched message"
+			}
+			add(severity, LintUnboundedDelete, path+".delete",
+				"delete %s; set output.limit and check the matches with smailnail test first", what)
+		}
+	}
+
+	labels := make([]string, 0, len(actions.ByLabel))
+	for label := range actions.ByLabel {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		lintDelete(actions.ByLabel[label], path+".by_label."+label, limit, add)
+	}
+}
+
+// deletesToTrash reports whether a delete action, in any of the forms
+// executeDelete accepts, moves messages to Trash instead of expunging them.
+func deletesToTrash(deleteConfig interface{}) bool {
+	switch config := deleteConfig.(type) {
+	case map[string]interface{}:
+		trash, _ := config["trash"].(bool)
+		return trash
+	case DeleteConfig:
+		return config.Trash
+	case *DeleteConfig:
+		return config != nil && config.Trash
+	default:
+		return false
+	}
+}
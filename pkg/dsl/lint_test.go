@@ -0,0 +1,72 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: cleanup
+search:
+  operator: or
+  conditions:
+    - subject: Weekly digest
+      since: 01/02/2024
+    - from: news@example.com
+      before: 13/02/2024
+output:
+  fields: [uid, subjet, "envelope.from[0].address"]
+actions:
+  delete: false
+`)
+	require.NoError(t, err)
+
+	assert.Equal(t, []LintIssue{
+		{Severity: SeverityWarning, Code: LintAmbiguousDate, Path: "search.conditions[0].since", Message: `"01/02/2024" is read as month/day/year; write dates as YYYY-MM-DD`},
+		{Severity: SeverityWarning, Code: LintSubstringMatch, Path: "search.conditions[0].subject", Message: `IMAP matches subjects containing "Weekly digest", not only this exact subject`},
+		{Severity: SeverityInfo, Code: LintSubstringMatch, Path: "search.conditions[1].from", Message: `IMAP matches any from header containing "news@example.com", such as xnews@example.com`},
+		{Severity: SeverityWarning, Code: LintUnknownField, Path: "output.fields[1]", Message: `unknown output field "subjet" is left out of the output`},
+		{Severity: SeverityError, Code: LintDeleteFalse, Path: "actions.delete", Message: "delete: false still deletes the matched messages; remove the action instead"},
+		{Severity: SeverityError, Code: LintUnboundedDelete, Path: "actions.delete", Message: "delete permanently deletes every matched message; set output.limit and check the matches with smailnail test first"},
+	}, rule.Lint())
+}
+
+func TestLintSingleConditionAndTrash(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: spam
+search:
+  operator: or
+  conditions:
+    - from: spammer
+output:
+  limit: 0
+  fields: [uid]
+classify:
+  builtin: true
+actions:
+  by_label:
+    spam:
+      delete:
+        trash: true
+`)
+	require.NoError(t, err)
+
+	issues := rule.Lint()
+	require.Len(t, issues, 2)
+	assert.Equal(t, LintSingleCondition, issues[0].Code)
+	assert.Equal(t, "search.conditions", issues[0].Path)
+	assert.Equal(t, SeverityWarning, issues[1].Severity)
+	assert.Equal(t, "actions.by_label.spam.delete", issues[1].Path)
+
+	rule.Output.Limit = 10
+	assert.Len(t, rule.Lint(), 1)
+}
+
+func TestSeverityRank(t *testing.T) {
+	assert.Greater(t, SeverityRank(SeverityError), SeverityRank(SeverityWarning))
+	assert.Greater(t, SeverityRank(SeverityWarning), SeverityRank(SeverityInfo))
+	assert.Zero(t, SeverityRank("fatal"))
+}
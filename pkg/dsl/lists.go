@@ -0,0 +1,78 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-message/textproto"
+)
+
+// listHeaders are the headers that mark a message as sent through a mailing
+// list or newsletter service (RFC 2919, RFC 2369).
+var listHeaders = []string{"List-Id", "List-Unsubscribe"}
+
+// listIDSection fetches the List-Id header for the list_id output field.
+var listIDSection = &imap.FetchItemBodySection{
+	Specifier:    imap.PartSpecifierHeader,
+	HeaderFields: []string{"List-Id"},
+	Peek:         true,
+}
+
+// addListCriteria adds the is_mailing_list and list_id conditions, both
+// HEADER searches.
+func addListCriteria(criteria *imap.SearchCriteria, config SearchConfig) {
+	if config.IsMailingList != nil {
+		present := make([]imap.SearchCriteria, len(listHeaders))
+		for i, name := range listHeaders {
+			present[i] = imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{{Key: name}}}
+		}
+		if *config.IsMailingList {
+			match := anyOf(present)
+			criteria.And(&match)
+		} else {
+			criteria.Not = append(criteria.Not, present...)
+		}
+	}
+	if config.ListID != "" {
+		criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{
+			Key:   "List-Id",
+			Value: config.ListID,
+		})
+	}
+}
+
+// ListIDValue returns the list identifier of a List-Id header value, the
+// part in angle brackets, without the optional description.
+func ListIDValue(value string) string {
+	value = strings.TrimSpace(value)
+	if start := strings.LastIndexByte(value, '<'); start >= 0 {
+		if end := strings.IndexByte(value[start:], '>'); end > 0 {
+			return value[start+1 : start+end]
+		}
+	}
+	return value
+}
+
+// parseListID extracts the list identifier from a fetched List-Id header.
+func parseListID(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return ""
+	}
+	return ListIDValue(header.Get("List-Id"))
+}
+
+// outputsListID reports whether the rule outputs the list_id field.
+func (rule *Rule) outputsListID() bool {
+	for _, fieldInterface := range rule.Output.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "list_id" {
+			return true
+		}
+	}
+	return false
+}
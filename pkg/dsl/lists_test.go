@@ -0,0 +1,74 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListIDValue(t *testing.T) {
+	assert.Equal(t, "digest.news.example.net", ListIDValue("Weekly Digest <digest.news.example.net>"))
+	assert.Equal(t, "dev.lists.example.org", ListIDValue(`"Dev <team>" <dev.lists.example.org>`))
+	assert.Equal(t, "bare.example.org", ListIDValue(" bare.example.org "))
+	assert.Equal(t, "digest.news.example.net", parseListID([]byte("List-Id: Weekly Digest\r\n <digest.news.example.net>\r\n\r\n")))
+}
+
+func TestListCriteria(t *testing.T) {
+	yes, no := true, false
+
+	criteria, _, err := BuildSearchCriteria(SearchConfig{IsMailingList: &yes}, nil)
+	require.NoError(t, err)
+	require.Len(t, criteria.Or, 1)
+	assert.Equal(t, "List-Id", criteria.Or[0][0].Header[0].Key)
+	assert.Equal(t, "List-Unsubscribe", criteria.Or[0][1].Header[0].Key)
+
+	criteria, _, err = BuildSearchCriteria(SearchConfig{IsMailingList: &no, ListID: "ignored"}, nil)
+	require.NoError(t, err)
+	assert.Len(t, criteria.Not, 2)
+	assert.Equal(t, "ignored", criteria.Header[0].Value)
+
+	messages := readSampleMbox(t)
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   []uint32
+	}{
+		{"lists", SearchConfig{IsMailingList: &yes}, []uint32{2}},
+		{"not lists", SearchConfig{IsMailingList: &no}, []uint32{1, 3}},
+		{"list id", SearchConfig{ListID: "digest.news"}, []uint32{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			var got []uint32
+			for _, msg := range messages {
+				ok, err := rule.MatchLocal(msg)
+				require.NoError(t, err)
+				if ok {
+					got = append(got, msg.Message.UID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+	assert.Equal(t, "digest.news.example.net", messages[1].Message.ListID)
+}
+
+func TestCompileSieveLists(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: lists
+search:
+  is_mailing_list: false
+  list_id: dev.example.org
+output:
+  fields: [uid]
+actions:
+  move_to: Lists
+`)
+	require.NoError(t, err)
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Contains(t, script, `not anyof (exists "List-Id", exists "List-Unsubscribe")`)
+	assert.Contains(t, script, `header :contains "List-Id" "dev.example.org"`)
+}
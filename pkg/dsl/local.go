@@ -0,0 +1,482 @@
+package dsl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// LocalMessage is a message read from a local mbox file or .eml file. It
+// carries everything the local evaluator needs to apply IMAP SEARCH semantics
+// without a server.
+type LocalMessage struct {
+	// Message is the message in the same shape FetchMessages returns. UID and
+	// SeqNum are the 1-based position of the message in its source.
+	Message *EmailMessage
+	// InternalDate stands in for the IMAP internal date: the mbox "From " line
+	// date, the file modification time or, failing both, the Date header.
+	InternalDate time.Time
+	// Source describes where the message was read from (file path and index).
+	Source string
+
+	header     mail.Header
+	headerText string
+	bodyText   string
+}
+
+// ParseLocalMessage parses a raw RFC 5322 message. flags are IMAP flags
+// recovered from the storage format (mbox Status headers, maildir suffixes).
+func ParseLocalMessage(raw []byte, seqNum uint32, flags []string, internalDate time.Time) (*LocalMessage, error) {
+	reader, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, fmt.Errorf("failed to parse message %d: %w", seqNum, err)
+	}
+
+	size, err := checkedUint32FromInt(len(raw), "message_size")
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &EmailMessage{
+		UID:        seqNum,
+		SeqNum:     seqNum,
+		Flags:      flags,
+		Size:       size,
+		RawContent: map[string][]byte{"": raw},
+		Envelope:   &EmailEnvelope{},
+	}
+	local := &LocalMessage{
+		Message:      msg,
+		InternalDate: internalDate,
+		header:       reader.Header,
+	}
+
+	msg.Encrypted = contentTypeEncryption(reader.Header.Get("Content-Type"))
+	msg.Envelope.Subject, _ = reader.Header.Subject()
+	msg.Envelope.Date, _ = reader.Header.Date()
+	msg.Envelope.From = localAddressList(reader.Header, "From")
+	msg.Envelope.To = localAddressList(reader.Header, "To")
+	msg.Envelope.Cc = localAddressList(reader.Header, "Cc")
+	msg.Envelope.Bcc = localAddressList(reader.Header, "Bcc")
+	msg.Envelope.MessageID, _ = reader.Header.MessageID()
+	msg.Envelope.InReplyTo, _ = reader.Header.MsgIDList("In-Reply-To")
+	msg.Envelope.References, _ = reader.Header.MsgIDList("References")
+	msg.ListID = ListIDValue(reader.Header.Get("List-Id"))
+	msg.classifyHeader = reader.Header.Header.Header
+	if local.InternalDate.IsZero() {
+		local.InternalDate = msg.Envelope.Date
+	}
+
+	var headerText strings.Builder
+	fields := reader.Header.Fields()
+	for fields.Next() {
+		value, err := fields.Text()
+		if err != nil {
+			value = fields.Value()
+		}
+		_, _ = fmt.Fprintf(&headerText, "%s: %s\n", fields.Key(), value)
+	}
+	local.headerText = headerText.String()
+
+	var bodyText []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if message.IsUnknownCharset(err) || message.IsUnknownEncoding(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read part of message %d: %w", seqNum, err)
+		}
+
+		content, err := io.ReadAll(part.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part of message %d: %w", seqNum, err)
+		}
+		contentSize, err := checkedUint32FromInt(len(content), "mime_part_size")
+		if err != nil {
+			return nil, err
+		}
+
+		mimePart := MimePart{
+			Content: string(content),
+			Size:    contentSize,
+		}
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			mediaType, params, _ := h.ContentType()
+			mimePart.Type = localMediaType(mediaType)
+			mimePart.Charset = params["charset"]
+			mimePart.Disposition = "inline"
+		case *mail.AttachmentHeader:
+			mediaType, params, _ := h.ContentType()
+			mimePart.Type = localMediaType(mediaType)
+			mimePart.Charset = params["charset"]
+			mimePart.Disposition = "attachment"
+			mimePart.Filename, _ = h.Filename()
+		}
+		if strings.HasPrefix(mimePart.Type, "text/") {
+			bodyText = append(bodyText, mimePart.Content)
+		}
+		if mimePart.Disposition == "attachment" || mimePart.Filename != "" {
+			attachment, err := newAttachment(mimePart.Filename, mimePart.Type, content)
+			if err != nil {
+				return nil, err
+			}
+			msg.Attachments = append(msg.Attachments, attachment)
+		}
+		msg.MimeParts = append(msg.MimeParts, mimePart)
+	}
+	local.bodyText = strings.Join(bodyText, "\n")
+
+	return local, nil
+}
+
+func localMediaType(mediaType string) string {
+	if mediaType == "" {
+		return "text/plain"
+	}
+	return strings.ToLower(mediaType)
+}
+
+func localAddressList(header mail.Header, key string) []EmailAddress {
+	addrs, err := header.AddressList(key)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	ret := make([]EmailAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		ret = append(ret, EmailAddress{Name: addr.Name, Address: addr.Address})
+	}
+	return ret
+}
+
+// MatchCriteria evaluates IMAP search criteria against a local message using
+// the semantics of RFC 9051 SEARCH: substring matches are case-insensitive,
+// dates compare by day only and an empty header value matches any message
+// that has the header.
+func MatchCriteria(criteria *imap.SearchCriteria, msg *LocalMessage) bool {
+	if criteria == nil {
+		return true
+	}
+
+	for _, seqSet := range criteria.SeqNum {
+		if !seqSet.Contains(msg.Message.SeqNum) {
+			return false
+		}
+	}
+	for _, uidSet := range criteria.UID {
+		if !uidSet.Contains(imap.UID(msg.Message.UID)) {
+			return false
+		}
+	}
+
+	if !matchDateRange(msg.InternalDate, criteria.Since, criteria.Before) {
+		return false
+	}
+	if !matchDateRange(msg.Message.Envelope.Date, criteria.SentSince, criteria.SentBefore) {
+		return false
+	}
+
+	for _, field := range criteria.Header {
+		if !msg.matchHeader(field.Key, field.Value) {
+			return false
+		}
+	}
+	for _, s := range criteria.Body {
+		if !containsFold(msg.bodyText, s) {
+			return false
+		}
+	}
+	for _, s := range criteria.Text {
+		if !containsFold(msg.headerText, s) && !containsFold(msg.bodyText, s) {
+			return false
+		}
+	}
+
+	for _, flag := range criteria.Flag {
+		if !hasFlag(msg.Message.Flags, string(flag)) {
+			return false
+		}
+	}
+	for _, flag := range criteria.NotFlag {
+		if hasFlag(msg.Message.Flags, string(flag)) {
+			return false
+		}
+	}
+
+	if criteria.Larger > 0 && int64(msg.Message.Size) <= criteria.Larger {
+		return false
+	}
+	if criteria.Smaller > 0 && int64(msg.Message.Size) >= criteria.Smaller {
+		return false
+	}
+
+	for i := range criteria.Not {
+		if MatchCriteria(&criteria.Not[i], msg) {
+			return false
+		}
+	}
+	for i := range criteria.Or {
+		if !MatchCriteria(&criteria.Or[i][0], msg) && !MatchCriteria(&criteria.Or[i][1], msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MatchLocal reports whether msg matches the rule's search, including the
+// client-side search.expr and address list filters.
+func (rule *Rule) MatchLocal(msg *LocalMessage) (bool, error) {
+	criteria, _, err := rule.buildSearchCriteria()
+	if err != nil {
+		return false, fmt.Errorf("failed to build search criteria: %w", err)
+	}
+	if !MatchCriteria(criteria, msg) {
+		return false, nil
+	}
+	if match := rule.Search.bodyMatcher(); match != nil && !match(msg.bodyText, msg.headerText) {
+		return false, nil
+	}
+	filter, err := rule.clientFilter()
+	if err != nil || filter == nil {
+		return err == nil, err
+	}
+	candidate := msg.Message
+	if rule.Decrypt != nil && candidate.Encrypted != EncryptionNone {
+		decrypted := *candidate
+		rule.decryptMessage(&decrypted, candidate.RawContent[""])
+		candidate = &decrypted
+	}
+	if rule.needsLanguage() {
+		candidate.Language = messageLanguage(candidate, summaryText(msg.Message.MimeParts))
+	}
+	return filter(candidate)
+}
+
+// FilterLocalMessages applies the rule's search to local messages and returns
+// the matches shaped like FetchMessages output: limit and offset count from the
+// most recent (last) message and MIME parts are restricted to what the output
+// configuration asks for.
+func (rule *Rule) FilterLocalMessages(messages []*LocalMessage) ([]*EmailMessage, error) {
+	logger := rule.Logger()
+	criteria, _, err := rule.buildSearchCriteria()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search criteria: %w", err)
+	}
+
+	matchBody := rule.Search.bodyMatcher()
+	var matchedLocal []*LocalMessage
+	for _, msg := range messages {
+		if MatchCriteria(criteria, msg) && (matchBody == nil || matchBody(msg.bodyText, msg.headerText)) {
+			matchedLocal = append(matchedLocal, msg)
+		}
+	}
+	totalFound := len(matchedLocal)
+
+	var matched []*EmailMessage
+	if rule.Output.SortBy != "" {
+		matched, err = rule.sortLocalMessages(matchedLocal)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, msg := range matchedLocal {
+			matched = append(matched, msg.Message)
+		}
+	}
+
+	contentField, needsMimeParts := mimePartsField(rule.Output)
+	totalCount32, err := checkedUint32FromInt(totalFound, "total_found")
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*EmailMessage, 0, len(matched))
+	for _, msg := range matched {
+		shaped := *msg
+		shaped.TotalCount = totalCount32
+		shaped.MimeParts = nil
+		if needsMimeParts {
+			for _, part := range msg.MimeParts {
+				if contentField == nil || contentField.ShouldInclude(part.Type) {
+					shaped.MimeParts = append(shaped.MimeParts, part)
+				}
+			}
+		}
+		if rule.Decrypt != nil && shaped.Encrypted != EncryptionNone {
+			rule.decryptMessage(&shaped, msg.RawContent[""])
+		}
+		if rule.needsLanguage() {
+			shaped.Language = messageLanguage(&shaped, summaryText(msg.MimeParts))
+		}
+		result = append(result, &shaped)
+	}
+
+	// The client-side filter runs before pagination, so that the limit counts
+	// the messages it passes
+	result, err = rule.applyClientFilter(result)
+	if err != nil {
+		return nil, err
+	}
+	result = rule.paginateLocal(result)
+
+	logger.Debug().
+		Int("messages_scanned", len(messages)).
+		Int("total_messages_found", totalFound).
+		Int("messages_returned", len(result)).
+		Msg("Filtered local messages")
+
+	if extract := rule.extract(nil); extract != nil {
+		for _, msg := range result {
+			extract(msg)
+		}
+	}
+	if summarize := rule.summarize(nil); summarize != nil {
+		for _, msg := range result {
+			summarize(msg)
+		}
+	}
+	return result, nil
+}
+
+// paginateLocal applies output.offset and output.limit to messages. Sorted
+// results are paginated from the start, others from the most recent (last)
+// message.
+func (rule *Rule) paginateLocal(messages []*EmailMessage) []*EmailMessage {
+	if rule.Output.SortBy != "" {
+		start := rule.Output.Offset
+		if start > len(messages) {
+			start = len(messages)
+		}
+		end := len(messages)
+		if rule.Output.Limit > 0 && start+rule.Output.Limit < end {
+			end = start + rule.Output.Limit
+		}
+		return messages[start:end]
+	}
+	end := len(messages) - rule.Output.Offset
+	if end < 0 {
+		end = 0
+	}
+	start := 0
+	if rule.Output.Limit > 0 && end-rule.Output.Limit > 0 {
+		start = end - rule.Output.Limit
+	}
+	return messages[start:end]
+}
+
+// sortLocalMessages orders messages by output.sort_by, comparing the same
+// keys as the SORT extension.
+func (rule *Rule) sortLocalMessages(messages []*LocalMessage) ([]*EmailMessage, error) {
+	keys, err := ParseSortBy(rule.Output.SortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	bySeqNum := make(map[uint32]*EmailMessage, len(messages))
+	records := make([]sortRecord, 0, len(messages))
+	for _, msg := range messages {
+		bySeqNum[msg.Message.SeqNum] = msg.Message
+		record := sortRecord{
+			SeqNum:  msg.Message.SeqNum,
+			Arrival: msg.InternalDate,
+			Date:    msg.Message.Envelope.Date,
+			Subject: normalizeSubject(msg.Message.Envelope.Subject),
+			Size:    int64(msg.Message.Size),
+		}
+		record.From, record.DisplayFrom = localSortAddress(msg.Message.Envelope.From)
+		record.To, record.DisplayTo = localSortAddress(msg.Message.Envelope.To)
+		record.Cc, _ = localSortAddress(localAddressList(msg.header, "Cc"))
+		records = append(records, record)
+	}
+	sortRecords(records, keys)
+
+	sorted := make([]*EmailMessage, len(records))
+	for i, record := range records {
+		sorted[i] = bySeqNum[record.SeqNum]
+	}
+	return sorted, nil
+}
+
+func localSortAddress(addrs []EmailAddress) (string, string) {
+	if len(addrs) == 0 {
+		return "", ""
+	}
+	address := strings.ToLower(addrs[0].Address)
+	mailbox, _, _ := strings.Cut(address, "@")
+	display := strings.ToLower(addrs[0].Name)
+	if display == "" {
+		display = address
+	}
+	return mailbox, display
+}
+
+// mimePartsField returns the content configuration of the mime_parts output
+// field and whether the field was requested at all.
+func mimePartsField(config OutputConfig) (*ContentField, bool) {
+	for _, fieldInterface := range config.Fields {
+		field, ok := fieldInterface.(Field)
+		if ok && field.Name == "mime_parts" {
+			return field.Content, true
+		}
+	}
+	return nil, false
+}
+
+func (m *LocalMessage) matchHeader(key, value string) bool {
+	fields := m.header.FieldsByKey(key)
+	for fields.Next() {
+		text, err := fields.Text()
+		if err != nil {
+			text = fields.Value()
+		}
+		if value == "" || containsFold(text, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDateRange checks since <= t < before, comparing calendar days only.
+func matchDateRange(t, since, before time.Time) bool {
+	if since.IsZero() && before.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+	day := truncateToDay(t)
+	if !since.IsZero() && day.Before(truncateToDay(since)) {
+		return false
+	}
+	if !before.IsZero() && !day.Before(truncateToDay(before)) {
+		return false
+	}
+	return true
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
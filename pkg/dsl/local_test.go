@@ -0,0 +1,141 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSampleMbox(t *testing.T) []*LocalMessage {
+	t.Helper()
+	messages, err := ReadLocalMessages(filepath.Join("testdata", "sample.mbox"))
+	require.NoError(t, err)
+	require.Len(t, messages, 3)
+	return messages
+}
+
+func TestReadLocalMessagesMbox(t *testing.T) {
+	messages := readSampleMbox(t)
+
+	first := messages[0].Message
+	assert.Equal(t, uint32(1), first.UID)
+	assert.Equal(t, "Invoice for March", first.Envelope.Subject)
+	assert.Equal(t, []EmailAddress{{Name: "Alice", Address: "alice@example.com"}}, first.Envelope.From)
+	assert.Equal(t, []string{"\\Seen"}, first.Flags)
+	require.Len(t, first.MimeParts, 1)
+	assert.Contains(t, first.MimeParts[0].Content, "\nFrom the accounting team.", "mboxrd quoting should be removed")
+	assert.Equal(t, 3, messages[0].InternalDate.Day())
+
+	second := messages[1].Message
+	assert.Equal(t, []string{"\\Flagged"}, second.Flags)
+	require.Len(t, second.MimeParts, 2)
+	assert.Equal(t, "text/html", second.MimeParts[1].Type)
+
+	assert.Len(t, messages[2].Message.Envelope.To, 2)
+}
+
+func TestReadLocalMessagesEMLDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cur"), 0o755))
+	eml := "From: a@example.com\r\nSubject: hello\r\nDate: Mon, 03 Mar 2025 10:00:00 +0000\r\n\r\nbody\r\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.eml"), []byte(eml), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cur", "1700000000.M1.host:2,FS"), []byte(eml), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0o600))
+
+	messages, err := ReadLocalMessages(dir)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "hello", messages[0].Message.Envelope.Subject)
+	assert.Equal(t, []string{"\\Flagged", "\\Seen"}, messages[1].Message.Flags)
+}
+
+func TestMatchLocal(t *testing.T) {
+	messages := readSampleMbox(t)
+
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   []uint32
+	}{
+		{"from substring", SearchConfig{From: "EXAMPLE.COM"}, []uint32{1, 3}},
+		{"subject", SearchConfig{SubjectContains: "digest"}, []uint32{2}},
+		{"body", SearchConfig{BodyContains: "lunch"}, []uint32{3}},
+		{"text matches headers", SearchConfig{Text: "newsletter@"}, []uint32{2}},
+		{"seen", SearchConfig{Flags: &FlagCriteria{Has: []string{"seen"}}}, []uint32{1}},
+		{"not flagged", SearchConfig{Flags: &FlagCriteria{NotHas: []string{"flagged"}}}, []uint32{1, 3}},
+		{"since", SearchConfig{Since: "2025-03-04"}, []uint32{2, 3}},
+		{"on", SearchConfig{On: "2025-03-04"}, []uint32{2}},
+		{"header", SearchConfig{Header: &HeaderCriteria{Name: "X-Status"}}, []uint32{2}},
+		{"not", SearchConfig{
+			Operator:   OperatorNot,
+			Conditions: []ComplexSearchConfig{{SearchConfig: SearchConfig{From: "carol"}}},
+		}, []uint32{1, 2}},
+		{"expr", SearchConfig{Expr: `len(to) > 1`}, []uint32{3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			var got []uint32
+			for _, msg := range messages {
+				ok, err := rule.MatchLocal(msg)
+				require.NoError(t, err)
+				if ok {
+					got = append(got, msg.Message.UID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFilterLocalMessages(t *testing.T) {
+	messages := readSampleMbox(t)
+
+	rule, err := ParseRuleString(`
+name: recent
+search:
+  to: bob@example.org
+output:
+  limit: 2
+  fields:
+    - uid
+    - mime_parts:
+        mode: text_only
+        show_content: true
+`)
+	require.NoError(t, err)
+
+	got, err := rule.FilterLocalMessages(messages)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, uint32(2), got[0].UID)
+	assert.Equal(t, uint32(3), got[1].UID)
+	assert.Equal(t, uint32(3), got[0].TotalCount)
+	require.Len(t, got[0].MimeParts, 1, "text_only keeps only the text/plain part")
+	assert.Equal(t, "text/plain", got[0].MimeParts[0].Type)
+	assert.Len(t, messages[1].Message.MimeParts, 2, "source messages are not modified")
+}
+
+func TestFilterLocalMessagesLimitAfterExpr(t *testing.T) {
+	messages := readSampleMbox(t)
+
+	rule, err := ParseRuleString(`
+name: example-com
+search:
+  expr: 'from.domain == "example.com"'
+output:
+  limit: 2
+  fields: [uid]
+`)
+	require.NoError(t, err)
+
+	got, err := rule.FilterLocalMessages(messages)
+	require.NoError(t, err)
+	require.Len(t, got, 2, "the limit counts the messages passing the expression")
+	assert.Equal(t, uint32(1), got[0].UID)
+	assert.Equal(t, uint32(3), got[1].UID)
+}
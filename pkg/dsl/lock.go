@@ -0,0 +1,391 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Lock keeps several smailnail instances working on the same account from
+// running destructive rules at the same time. Locks are leases: a holder
+// that disappears without releasing its lock loses it once the lease
+// expires.
+type Lock interface {
+	// Acquire takes the lock for ttl, or extends the lease when the holder
+	// has it already. It returns the other holder when the lock is taken.
+	Acquire(ttl time.Duration) (acquired bool, holder string, err error)
+	// Release gives up the lock
+	Release() error
+}
+
+// DefaultLockHolder names this process as a lock holder: host:pid.
+func DefaultLockHolder() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// lease is a lock held by holder until expires.
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+// live reports whether l is a lease that has not expired.
+func (l *lease) live() bool {
+	return l != nil && time.Now().Before(l.expires)
+}
+
+func (l lease) String() string {
+	return l.holder + " until " + l.expires.UTC().Format(time.RFC3339)
+}
+
+// parseLease reads a lease written by lease.String.
+func parseLease(s string) (lease, bool) {
+	holder, until, ok := strings.Cut(strings.TrimSpace(s), " until ")
+	if !ok || holder == "" {
+		return lease{}, false
+	}
+	expires, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		return lease{}, false
+	}
+	return lease{holder: holder, expires: expires}, true
+}
+
+// FileLock is a Lock stored in a file holding the current lease, for
+// instances sharing a file system. Creating the file exclusively takes the
+// lock.
+type FileLock struct {
+	Path   string
+	Holder string
+}
+
+// NewFileLock creates a lock stored at path for holder.
+func NewFileLock(path string, holder string) *FileLock {
+	return &FileLock{Path: path, Holder: holder}
+}
+
+// fileLockTakeoverTimeout is how long a lock file that holds no lease, or a
+// takeover marker, may exist before other instances consider it abandoned.
+const fileLockTakeoverTimeout = 30 * time.Second
+
+func (l *FileLock) Acquire(ttl time.Duration) (bool, string, error) {
+	next := lease{holder: l.Holder, expires: time.Now().Add(ttl)}
+	// An expired lease is removed once, after which another instance may
+	// win the race to create the lock file
+	for attempt := 0; ; attempt++ {
+		created, err := l.create(next)
+		if err != nil {
+			return false, "", err
+		}
+		if created {
+			return true, l.Holder, nil
+		}
+
+		current, err := l.read()
+		if err != nil {
+			return false, "", err
+		}
+		if current.live() {
+			if current.holder != l.Holder {
+				return false, current.holder, nil
+			}
+			if err := l.renew(next); err != nil {
+				return false, "", err
+			}
+			return true, l.Holder, nil
+		}
+		if attempt > 0 {
+			return false, "", nil
+		}
+		if err := l.removeExpired(); err != nil {
+			return false, "", err
+		}
+	}
+}
+
+func (l *FileLock) Release() error {
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	if current == nil || current.holder != l.Holder {
+		return nil
+	}
+	if err := os.Remove(l.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+// create creates the lock file with next, and reports false when it exists
+// already.
+func (l *FileLock) create(next lease) (bool, error) {
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	_, err = f.WriteString(next.String() + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(l.Path)
+		return false, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return true, nil
+}
+
+// renew replaces the live lease of the holder with next. The lease is
+// written next to the lock and renamed into place, so that readers never
+// see a partial lease.
+func (l *FileLock) renew(next lease) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", l.Path, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(next.String()+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if err := os.Rename(tmp, l.Path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// removeExpired removes the lock file once its lease expired. Instances take
+// over an expired lease one at a time, holding a marker file, so that none of
+// them removes the lease another one just created.
+func (l *FileLock) removeExpired() error {
+	marker := l.Path + ".takeover"
+	f, err := os.OpenFile(marker, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// Another instance is taking over. A marker left by an instance
+		// that died meanwhile is removed for the next attempt.
+		if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) > fileLockTakeoverTimeout {
+			_ = os.Remove(marker)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create lock takeover file: %w", err)
+	}
+	_ = f.Close()
+	defer func() { _ = os.Remove(marker) }()
+
+	current, err := l.read()
+	if err != nil {
+		return err
+	}
+	if current.live() {
+		return nil
+	}
+	if err := os.Remove(l.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove expired lock file: %w", err)
+	}
+	return nil
+}
+
+// read returns the lease in the lock file, nil if there is none.
+func (l *FileLock) read() (*lease, error) {
+	data, err := os.ReadFile(filepath.Clean(l.Path))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	current, ok := parseLease(string(data))
+	if !ok {
+		// A lease being written or a foreign file: it expires some time
+		// after its last change
+		info, err := os.Stat(l.Path)
+		if err != nil {
+			return nil, nil
+		}
+		return &lease{expires: info.ModTime().Add(fileLockTakeoverTimeout)}, nil
+	}
+	return &current, nil
+}
+
+// IMAPLock is a Lock stored as messages in a mailbox of the account, for
+// instances on different hosts. Each holder appends a message with its
+// lease; the live lease with the lowest UID holds the lock.
+type IMAPLock struct {
+	Client  *imapclient.Client
+	Mailbox string
+	Holder  string
+}
+
+// NewIMAPLock creates a lock stored in mailbox for holder. The lock selects
+// mailbox on client.
+func NewIMAPLock(client *imapclient.Client, mailbox string, holder string) *IMAPLock {
+	return &IMAPLock{Client: client, Mailbox: mailbox, Holder: holder}
+}
+
+const imapLockSubject = "smailnail lock "
+
+// imapLease is a lease message in the lock mailbox.
+type imapLease struct {
+	lease
+	uid imap.UID
+}
+
+func (l *IMAPLock) Acquire(ttl time.Duration) (bool, string, error) {
+	// Most likely the mailbox exists already; selecting it reports real
+	// problems
+	_ = l.Client.Create(l.Mailbox, nil).Wait()
+	leases, err := l.leases()
+	if err != nil {
+		return false, "", err
+	}
+	if live := liveLeases(leases); len(live) > 0 && live[0].holder != l.Holder {
+		return false, live[0].holder, nil
+	}
+
+	next := lease{holder: l.Holder, expires: time.Now().Add(ttl)}
+	msg := []byte(fmt.Sprintf("From: smailnail\r\nSubject: %s%s\r\nDate: %s\r\n\r\nLease of the smailnail lock.\r\n",
+		imapLockSubject, next, time.Now().Format(time.RFC1123Z)))
+	cmd := l.Client.Append(l.Mailbox, int64(len(msg)), nil)
+	if _, err := cmd.Write(msg); err != nil {
+		return false, "", fmt.Errorf("failed to append lock message: %w", err)
+	}
+	if err := cmd.Close(); err != nil {
+		return false, "", fmt.Errorf("failed to append lock message: %w", err)
+	}
+	if _, err := cmd.Wait(); err != nil {
+		return false, "", fmt.Errorf("failed to append lock message to %s: %w", l.Mailbox, wrapMailboxError(err, l.Mailbox))
+	}
+
+	// Another holder may have appended its lease at the same time, the
+	// lowest UID wins
+	leases, err = l.leases()
+	if err != nil {
+		return false, "", err
+	}
+	live := liveLeases(leases)
+	if len(live) == 0 || live[0].holder != l.Holder {
+		holder := ""
+		if len(live) > 0 {
+			holder = live[0].holder
+		}
+		if err := l.remove(leases, func(le imapLease) bool { return le.holder == l.Holder }); err != nil {
+			return false, "", err
+		}
+		return false, holder, nil
+	}
+
+	// Older leases of this holder expire on their own: dropping them now
+	// could let a lease appended by another holder in between win
+	now := time.Now()
+	if err := l.remove(leases, func(le imapLease) bool { return !now.Before(le.expires) }); err != nil {
+		return false, "", err
+	}
+	return true, l.Holder, nil
+}
+
+func (l *IMAPLock) Release() error {
+	leases, err := l.leases()
+	if err != nil {
+		return err
+	}
+	return l.remove(leases, func(le imapLease) bool { return le.holder == l.Holder })
+}
+
+// leases selects the lock mailbox and returns its leases by UID.
+func (l *IMAPLock) leases() ([]imapLease, error) {
+	selectData, err := l.Client.Select(l.Mailbox, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to select lock mailbox %s: %w", l.Mailbox, wrapMailboxError(err, l.Mailbox))
+	}
+	if selectData.NumMessages == 0 {
+		return nil, nil
+	}
+
+	var all imap.SeqSet
+	all.AddRange(1, 0)
+	msgs, err := l.Client.Fetch(all, &imap.FetchOptions{UID: true, Envelope: true}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch lock messages: %w", err)
+	}
+	var leases []imapLease
+	for _, msg := range msgs {
+		if msg.Envelope == nil {
+			continue
+		}
+		subject, ok := strings.CutPrefix(msg.Envelope.Subject, imapLockSubject)
+		if !ok {
+			continue
+		}
+		if le, ok := parseLease(subject); ok {
+			leases = append(leases, imapLease{lease: le, uid: msg.UID})
+		}
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].uid < leases[j].uid })
+	return leases, nil
+}
+
+// remove deletes the lease messages matching drop.
+func (l *IMAPLock) remove(leases []imapLease, drop func(imapLease) bool) error {
+	var uids imap.UIDSet
+	for _, le := range leases {
+		if drop(le) {
+			uids.AddNum(le.uid)
+		}
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	storeFlags := &imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+	}
+	if _, err := l.Client.Store(uids, storeFlags, nil).Collect(); err != nil {
+		return fmt.Errorf("failed to mark lock messages as deleted: %w", err)
+	}
+	if err := l.Client.Expunge().Close(); err != nil {
+		return fmt.Errorf("failed to expunge lock messages: %w", err)
+	}
+	return nil
+}
+
+// liveLeases returns the leases that have not expired, by UID.
+func liveLeases(leases []imapLease) []imapLease {
+	now := time.Now()
+	var live []imapLease
+	for _, le := range leases {
+		if now.Before(le.expires) {
+			live = append(live, le)
+		}
+	}
+	return live
+}
+
+// ParseLockSpec creates the lock described by spec: file:PATH for a FileLock
+// or imap:MAILBOX for an IMAPLock on client.
+func ParseLockSpec(spec string, holder string, client *imapclient.Client) (Lock, error) {
+	kind, target, ok := strings.Cut(spec, ":")
+	if !ok || target == "" {
+		return nil, fmt.Errorf("invalid lock %q: expected file:PATH or imap:MAILBOX", spec)
+	}
+	switch kind {
+	case "file":
+		return NewFileLock(target, holder), nil
+	case "imap":
+		return NewIMAPLock(client, target, holder), nil
+	default:
+		return nil, fmt.Errorf("invalid lock %q: unknown backend %q, expected file or imap", spec, kind)
+	}
+}
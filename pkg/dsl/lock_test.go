@@ -0,0 +1,120 @@
+package dsl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smailnail.lock")
+	a, b := NewFileLock(path, "host-a:1"), NewFileLock(path, "host-b:2")
+
+	acquired, holder, err := a.Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, "host-a:1", holder)
+
+	acquired, holder, err = b.Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, "host-a:1", holder)
+
+	// The holder extends its lease
+	acquired, _, err = a.Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	// Releasing someone else's lock does nothing
+	require.NoError(t, b.Release())
+	require.FileExists(t, path)
+	require.NoError(t, a.Release())
+	assert.NoFileExists(t, path)
+
+	// An expired lease can be taken over
+	expired := lease{holder: "host-a:1", expires: time.Now().Add(-time.Second)}
+	require.NoError(t, os.WriteFile(path, []byte(expired.String()), 0o600))
+	acquired, _, err = b.Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestFileLockExclusive(t *testing.T) {
+	for _, expired := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "smailnail.lock")
+		if expired {
+			stale := lease{holder: "gone:1", expires: time.Now().Add(-time.Second)}
+			require.NoError(t, os.WriteFile(path, []byte(stale.String()), 0o600))
+		}
+
+		var wg sync.WaitGroup
+		acquired := make(chan string, 20)
+		for i := 0; i < cap(acquired); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lock := NewFileLock(path, fmt.Sprintf("host:%d", i))
+				ok, _, err := lock.Acquire(time.Minute)
+				assert.NoError(t, err)
+				if ok {
+					acquired <- lock.Holder
+				}
+			}()
+		}
+		wg.Wait()
+		close(acquired)
+
+		var holders []string
+		for holder := range acquired {
+			holders = append(holders, holder)
+		}
+		require.Len(t, holders, 1, "expired lease: %v", expired)
+		current, err := NewFileLock(path, "").read()
+		require.NoError(t, err)
+		assert.Equal(t, holders[0], current.holder)
+	}
+}
+
+func TestFileLockPartialLease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smailnail.lock")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	// A lock file being written is held by someone
+	acquired, _, err := NewFileLock(path, "host-a:1").Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+
+	// Until it is abandoned
+	old := time.Now().Add(-2 * fileLockTakeoverTimeout)
+	require.NoError(t, os.Chtimes(path, old, old))
+	acquired, _, err = NewFileLock(path, "host-a:1").Acquire(time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+func TestParseLockSpec(t *testing.T) {
+	lock, err := ParseLockSpec("file:/tmp/smailnail.lock", "me", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/smailnail.lock", lock.(*FileLock).Path)
+
+	lock, err = ParseLockSpec("imap:Locks", "me", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Locks", lock.(*IMAPLock).Mailbox)
+
+	_, err = ParseLockSpec("redis:lock", "me", nil)
+	assert.ErrorContains(t, err, `unknown backend "redis"`)
+	_, err = ParseLockSpec("file:", "me", nil)
+	assert.ErrorContains(t, err, "expected file:PATH or imap:MAILBOX")
+
+	le, ok := parseLease("host:1 until 2026-01-02T03:04:05Z")
+	require.True(t, ok)
+	assert.Equal(t, "host:1", le.holder)
+	_, ok = parseLease("garbage")
+	assert.False(t, ok)
+}
@@ -0,0 +1,42 @@
+package dsl
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// SetLogger sets the logger the rule's operations log to, instead of the
+// global zerolog logger. Embedders use it to route smailnail's logs into
+// their own logging stack.
+func (rule *Rule) SetLogger(logger zerolog.Logger) {
+	rule.logger = &logger
+}
+
+// HasLogger reports whether a logger was set with SetLogger.
+func (rule *Rule) HasLogger() bool {
+	return rule != nil && rule.logger != nil
+}
+
+// Logger returns the logger of the rule's operations: the one set with
+// SetLogger, or the global zerolog logger, with the rule name as context and
+// the rule's log_level applied. It can be called on a nil rule, for
+// operations run without one.
+func (rule *Rule) Logger() zerolog.Logger {
+	if rule == nil {
+		return log.Logger
+	}
+	logger := log.Logger
+	if rule.logger != nil {
+		logger = *rule.logger
+	}
+	if rule.Name != "" {
+		logger = logger.With().Str("rule", rule.Name).Logger()
+	}
+	if rule.LogLevel != "" {
+		// Validate rejects invalid levels
+		if level, err := zerolog.ParseLevel(rule.LogLevel); err == nil {
+			logger = logger.Level(level)
+		}
+	}
+	return logger
+}
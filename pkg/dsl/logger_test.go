@@ -0,0 +1,44 @@
+package dsl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleLogger(t *testing.T) {
+	var buf bytes.Buffer
+	rule := &Rule{Name: "archive"}
+	assert.False(t, rule.HasLogger())
+	rule.SetLogger(zerolog.New(&buf))
+	assert.True(t, rule.HasLogger())
+
+	logger := rule.Logger()
+	logger.Debug().Msg("hello")
+	assert.Equal(t, `{"level":"debug","rule":"archive","message":"hello"}`+"\n", buf.String())
+
+	buf.Reset()
+	rule.LogLevel = "warn"
+	logger = rule.Logger()
+	logger.Info().Msg("quiet")
+	logger.Warn().Msg("loud")
+	assert.NotContains(t, buf.String(), "quiet")
+	assert.Contains(t, buf.String(), "loud")
+
+	// Operations without a rule fall back to the global logger
+	var nilRule *Rule
+	assert.False(t, nilRule.HasLogger())
+	_ = nilRule.Logger()
+}
+
+func TestRuleLogLevelValidation(t *testing.T) {
+	rule, err := ParseRuleString("name: r\nlog_level: debug\nsearch:\n  subject_contains: x\noutput:\n  fields: [uid]\n")
+	require.NoError(t, err)
+	assert.Equal(t, "debug", rule.LogLevel)
+
+	_, err = ParseRuleString("name: r\nlog_level: loud\nsearch:\n  subject_contains: x\noutput:\n  fields: [uid]\n")
+	assert.ErrorContains(t, err, "log_level")
+}
@@ -0,0 +1,105 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// DefaultMarkReadBatchSize is the number of messages MarkRead flags per
+// STORE.
+const DefaultMarkReadBatchSize = 500
+
+// MarkReadConfig selects the unread messages MarkRead marks as read.
+type MarkReadConfig struct {
+	Mailbox string
+	// OlderThan is an age such as "30d", "2w" or "12h"; messages whose
+	// internal date is at least that old are marked. Empty marks every
+	// unread message.
+	OlderThan string
+	BatchSize int
+	// DryRun only counts the matching messages
+	DryRun bool
+}
+
+// Before returns the date messages have to be older than, or the zero time
+// if OlderThan is empty.
+func (c *MarkReadConfig) Before(now time.Time) (time.Time, error) {
+	if c.OlderThan == "" {
+		return time.Time{}, nil
+	}
+	d, err := parseRelativeDuration(c.OlderThan)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid older-than %q: %w", c.OlderThan, err)
+	}
+	return now.Add(-d), nil
+}
+
+// searchConfig returns the rule search for the unread messages to mark.
+// IMAP compares dates without the time of day, so messages from the day of
+// the cutoff are not included.
+func (c *MarkReadConfig) searchConfig(now time.Time) (SearchConfig, error) {
+	before, err := c.Before(now)
+	if err != nil {
+		return SearchConfig{}, err
+	}
+	unread := true
+	search := SearchConfig{Unread: &unread}
+	if !before.IsZero() {
+		search.Before = before.Format("2006-01-02")
+	}
+	return search, nil
+}
+
+// MarkRead marks the unread messages of the mailbox that match config as
+// read, in batches of config.BatchSize UIDs. progress, if not nil, is called
+// after each batch with the number of messages marked so far and the total.
+// It returns the number of matching messages.
+func MarkRead(client *imapclient.Client, config *MarkReadConfig, progress func(done, total int)) (int, error) {
+	search, err := config.searchConfig(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	criteria, _, err := BuildSearchCriteria(search, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := SelectMailbox(client, config.Mailbox); err != nil {
+		return 0, err
+	}
+	data, err := client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return 0, fmt.Errorf("failed to search unread messages: %w", wrapSearchError(err))
+	}
+	uids := data.AllUIDs()
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	if config.DryRun {
+		return len(uids), nil
+	}
+
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultMarkReadBatchSize
+	}
+	flags := &FlagActions{Add: []string{"seen"}}
+	for start := 0; start < len(uids); start += batchSize {
+		end := start + batchSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		batch := make([]*EmailMessage, 0, end-start)
+		for _, uid := range uids[start:end] {
+			batch = append(batch, &EmailMessage{UID: uint32(uid)})
+		}
+		if err := executeFlags(client, batch, flags, nil); err != nil {
+			return len(uids), err
+		}
+		if progress != nil {
+			progress(end, len(uids))
+		}
+	}
+	return len(uids), nil
+}
@@ -0,0 +1,30 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkReadSearch(t *testing.T) {
+	now := time.Date(2025, 3, 31, 15, 0, 0, 0, time.UTC)
+
+	search, err := (&MarkReadConfig{OlderThan: "30d"}).searchConfig(now)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-03-01", search.Before)
+
+	criteria, _, err := BuildSearchCriteria(search, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), criteria.Before)
+	assert.Equal(t, []imap.Flag{imap.FlagSeen}, criteria.NotFlag)
+
+	search, err = (&MarkReadConfig{}).searchConfig(now)
+	require.NoError(t, err)
+	assert.Empty(t, search.Before)
+
+	_, err = (&MarkReadConfig{OlderThan: "a month"}).searchConfig(now)
+	assert.ErrorContains(t, err, "invalid older-than")
+}
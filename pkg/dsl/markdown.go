@@ -0,0 +1,425 @@
+package dsl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportFormatMarkdown exports each message as a Markdown note with the
+// headers as YAML front matter.
+const ExportFormatMarkdown = "markdown"
+
+// exportExtension returns the file extension of an export format.
+func exportExtension(format string) string {
+	if format == ExportFormatMarkdown {
+		return "md"
+	}
+	return format
+}
+
+// markdownFrontMatter is the YAML front matter of an exported note. The
+// headers are read from the exported message, so they are redacted and
+// anonymized like it.
+type markdownFrontMatter struct {
+	Subject     string               `yaml:"subject"`
+	From        []string             `yaml:"from,omitempty"`
+	To          []string             `yaml:"to,omitempty"`
+	Cc          []string             `yaml:"cc,omitempty"`
+	Date        string               `yaml:"date,omitempty"`
+	MessageID   string               `yaml:"message_id,omitempty"`
+	UID         uint32               `yaml:"uid"`
+	Flags       []string             `yaml:"flags,omitempty"`
+	Attachments []markdownAttachment `yaml:"attachments,omitempty"`
+}
+
+type markdownAttachment struct {
+	Filename string `yaml:"filename,omitempty"`
+	Type     string `yaml:"type"`
+	Size     int    `yaml:"size"`
+	// Link is the blob path relative to the note, set for deduplicated
+	// exports
+	Link string `yaml:"link,omitempty"`
+}
+
+// messageToMarkdown converts raw to a Markdown note: front matter with the
+// headers, the subject as title, the plain text body (or the HTML body
+// converted to Markdown) and the list of attachments. When blobs is not nil,
+// the attachments were moved to the blob store and are linked relative to
+// filename, the path of the note in the export.
+func messageToMarkdown(raw []byte, msg *EmailMessage, filename string, blobs []ExportBlob) ([]byte, error) {
+	reader, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, fmt.Errorf("failed to parse message %d: %w", msg.UID, err)
+	}
+
+	front := markdownFrontMatter{UID: msg.UID, Flags: msg.Flags}
+	front.Subject, _ = reader.Header.Subject()
+	front.From = markdownAddresses(reader.Header, "From")
+	front.To = markdownAddresses(reader.Header, "To")
+	front.Cc = markdownAddresses(reader.Header, "Cc")
+	if date, err := reader.Header.Date(); err == nil && !date.IsZero() {
+		front.Date = date.Format(time.RFC3339)
+	}
+	front.MessageID, _ = reader.Header.MessageID()
+
+	var plain, htmlBody []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if message.IsUnknownCharset(err) || message.IsUnknownEncoding(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read part of message %d: %w", msg.UID, err)
+		}
+		content, err := io.ReadAll(part.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read part of message %d: %w", msg.UID, err)
+		}
+
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			mediaType, _, _ := h.ContentType()
+			switch strings.ToLower(mediaType) {
+			case "text/plain":
+				plain = append(plain, string(content))
+			case "text/html":
+				htmlBody = append(htmlBody, string(content))
+			}
+		case *mail.AttachmentHeader:
+			if blobs != nil {
+				continue
+			}
+			mediaType, _, _ := h.ContentType()
+			name, _ := h.Filename()
+			front.Attachments = append(front.Attachments, markdownAttachment{
+				Filename: name,
+				Type:     strings.ToLower(mediaType),
+				Size:     len(content),
+			})
+		}
+	}
+	if blobs != nil {
+		// Links are relative to the directory of the note
+		up := strings.Repeat("../", strings.Count(path.Clean(filename), "/"))
+		for _, blob := range blobs {
+			front.Attachments = append(front.Attachments, markdownAttachment{
+				Filename: blob.Filename,
+				Type:     blob.Type,
+				Size:     blob.Size,
+				Link:     up + blob.Path,
+			})
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(front); err != nil {
+		return nil, fmt.Errorf("failed to write front matter of message %d: %w", msg.UID, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	b.WriteString("---\n\n")
+
+	if front.Subject != "" {
+		fmt.Fprintf(&b, "# %s\n\n", strings.TrimSpace(front.Subject))
+	}
+	body := strings.TrimSpace(strings.Join(plain, "\n\n"))
+	if body == "" && len(htmlBody) > 0 {
+		body = htmlToMarkdown(strings.Join(htmlBody, "\n"))
+	}
+	if body != "" {
+		b.WriteString(strings.ReplaceAll(body, "\r\n", "\n"))
+		b.WriteString("\n")
+	}
+
+	if len(front.Attachments) > 0 {
+		b.WriteString("\n## Attachments\n\n")
+		for _, a := range front.Attachments {
+			name := a.Filename
+			if name == "" {
+				name = "unnamed"
+			}
+			if a.Link != "" {
+				fmt.Fprintf(&b, "- [%s](%s) (%s, %s)\n", name, a.Link, a.Type, HumanizeSize(uint64(a.Size)))
+			} else {
+				fmt.Fprintf(&b, "- %s (%s, %s)\n", name, a.Type, HumanizeSize(uint64(a.Size)))
+			}
+		}
+	}
+	return b.Bytes(), nil
+}
+
+func markdownAddresses(h mail.Header, key string) []string {
+	addrs, err := h.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	ret := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ret = append(ret, addr.String())
+	}
+	return ret
+}
+
+// htmlToMarkdown converts an HTML body to Markdown, keeping headings,
+// emphasis, links, images, lists, quotes and preformatted text. Other markup
+// is dropped.
+func htmlToMarkdown(input string) string {
+	c := &markdownConverter{}
+	z := html.NewTokenizer(strings.NewReader(input))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			c.text(token.Data)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			c.start(token)
+		case html.EndTagToken:
+			c.end(token)
+		}
+	}
+
+	lines := strings.Split(c.b.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+type markdownList struct {
+	ordered bool
+	n       int
+}
+
+// markdownConverter writes Markdown for a stream of HTML tokens. Line breaks
+// are written lazily, so nested blocks do not pile up empty lines.
+type markdownConverter struct {
+	b     strings.Builder
+	skip  int
+	pre   int
+	quote int
+	lists []markdownList
+	links []string
+	// breaks is the number of line breaks to write before the next text
+	breaks int
+	// space is set when whitespace is pending between two words
+	space bool
+	// open is set after an opening marker, which the next word follows
+	// without space
+	open bool
+}
+
+func (c *markdownConverter) lineBreak(n int) {
+	if n > c.breaks {
+		c.breaks = n
+	}
+	c.space = false
+}
+
+// raw writes s after the pending line breaks, prefixing the new line inside
+// quotes. Blank lines are left empty, so they also separate quotes from the
+// blocks around them.
+func (c *markdownConverter) raw(s string) {
+	if c.breaks > 0 && c.b.Len() > 0 {
+		c.b.WriteString(strings.Repeat("\n", c.breaks) + strings.Repeat("> ", c.quote))
+	}
+	c.breaks = 0
+	c.open = false
+	c.b.WriteString(s)
+}
+
+// opening writes an opening marker.
+func (c *markdownConverter) opening(s string) {
+	c.word(s)
+	c.open = true
+}
+
+// word writes s, separated from the previous word by pending whitespace.
+func (c *markdownConverter) word(s string) {
+	if c.space && c.breaks == 0 && !c.open {
+		s = " " + s
+	}
+	c.space = false
+	c.raw(s)
+}
+
+func (c *markdownConverter) text(data string) {
+	if c.skip > 0 {
+		return
+	}
+	if c.pre > 0 {
+		for i, line := range strings.Split(data, "\n") {
+			if i > 0 {
+				c.lineBreak(1)
+			}
+			c.raw(line)
+		}
+		return
+	}
+	if strings.TrimLeft(data, " \t\r\n") != data {
+		c.space = true
+	}
+	for _, w := range strings.Fields(data) {
+		c.word(w)
+		c.space = true
+	}
+	if strings.TrimRight(data, " \t\r\n") == data {
+		c.space = false
+	}
+}
+
+func htmlAttr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func (c *markdownConverter) start(token html.Token) {
+	switch token.Data {
+	case "script", "style", "head", "title":
+		if token.Type == html.StartTagToken {
+			c.skip++
+		}
+	}
+	if c.skip > 0 {
+		return
+	}
+
+	switch token.Data {
+	case "p", "div", "section", "article", "table", "header", "footer":
+		c.lineBreak(2)
+	case "tr", "br":
+		c.lineBreak(1)
+	case "td", "th":
+		c.space = true
+	case "hr":
+		c.lineBreak(2)
+		c.raw("---")
+		c.lineBreak(2)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.lineBreak(2)
+		c.raw(strings.Repeat("#", int(token.Data[1]-'0')) + " ")
+		c.open = true
+	case "strong", "b":
+		c.opening("**")
+	case "em", "i":
+		c.opening("_")
+	case "code":
+		if c.pre == 0 {
+			c.opening("`")
+		}
+	case "pre":
+		c.lineBreak(2)
+		c.raw("```")
+		c.lineBreak(1)
+		c.pre++
+	case "blockquote":
+		c.lineBreak(2)
+		c.quote++
+	case "ul", "ol":
+		c.lists = append(c.lists, markdownList{ordered: token.Data == "ol"})
+		if len(c.lists) == 1 {
+			c.lineBreak(2)
+		}
+	case "li":
+		c.lineBreak(1)
+		marker := "- "
+		if n := len(c.lists); n > 0 {
+			if c.lists[n-1].ordered {
+				c.lists[n-1].n++
+				marker = fmt.Sprintf("%d. ", c.lists[n-1].n)
+			}
+			marker = strings.Repeat("    ", n-1) + marker
+		}
+		c.raw(marker)
+		c.open = true
+	case "a":
+		c.links = append(c.links, htmlAttr(token, "href"))
+		c.opening("[")
+	case "img":
+		if src := htmlAttr(token, "src"); src != "" && !strings.HasPrefix(src, "cid:") {
+			c.word(fmt.Sprintf("![%s](%s)", htmlAttr(token, "alt"), src))
+		} else if alt := htmlAttr(token, "alt"); alt != "" {
+			c.word(alt)
+		}
+	}
+}
+
+func (c *markdownConverter) end(token html.Token) {
+	switch token.Data {
+	case "script", "style", "head", "title":
+		if c.skip > 0 {
+			c.skip--
+		}
+		return
+	}
+	if c.skip > 0 {
+		return
+	}
+
+	switch token.Data {
+	case "p", "div", "section", "article", "table", "header", "footer",
+		"h1", "h2", "h3", "h4", "h5", "h6":
+		c.lineBreak(2)
+	case "strong", "b":
+		c.raw("**")
+	case "em", "i":
+		c.raw("_")
+	case "code":
+		if c.pre == 0 {
+			c.raw("`")
+		}
+	case "pre":
+		if c.pre > 0 {
+			c.pre--
+		}
+		c.lineBreak(1)
+		c.raw("```")
+		c.lineBreak(2)
+	case "blockquote":
+		if c.quote > 0 {
+			c.quote--
+		}
+		c.lineBreak(2)
+	case "ul", "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		if len(c.lists) == 0 {
+			c.lineBreak(2)
+		}
+	case "a":
+		href := ""
+		if n := len(c.links); n > 0 {
+			href = c.links[n-1]
+			c.links = c.links[:n-1]
+		}
+		if href != "" && !strings.HasPrefix(href, "#") {
+			c.raw("](" + href + ")")
+		} else {
+			c.raw("]")
+		}
+	}
+}
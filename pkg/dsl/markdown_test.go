@@ -0,0 +1,84 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	input := `<html><head><title>Ignored</title><style>p { color: red }</style></head>
+<body>
+<h2>Release notes</h2>
+<p>Version <b>1.2</b> is out, see the
+<a href="https://example.com/changelog">changelog</a>.</p>
+<ul><li>Faster sync</li><li>Fewer <em>bugs</em></li></ul>
+<blockquote><p>Quoted text</p></blockquote>
+<pre>go install ./...
+smailnail --help</pre>
+<p>Thanks &amp; regards<br>The team</p>
+</body></html>`
+
+	assert.Equal(t, "## Release notes\n\n"+
+		"Version **1.2** is out, see the [changelog](https://example.com/changelog).\n\n"+
+		"- Faster sync\n"+
+		"- Fewer _bugs_\n\n"+
+		"> Quoted text\n\n"+
+		"```\ngo install ./...\nsmailnail --help\n```\n\n"+
+		"Thanks & regards\nThe team", htmlToMarkdown(input))
+}
+
+func TestHTMLToMarkdownOrderedList(t *testing.T) {
+	assert.Equal(t, "1. One\n2. Two\n    - Nested", htmlToMarkdown(`<ol><li>One</li><li>Two<ul><li>Nested</li></ul></li></ol>`))
+}
+
+func TestMessageToMarkdown(t *testing.T) {
+	raw := []byte("From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: Weekly report\r\n" +
+		"Date: Mon, 02 Dec 2024 10:00:00 +0000\r\n" +
+		"Message-ID: <report-1@example.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=sep\r\n" +
+		"\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/html; charset=utf-8\r\n" +
+		"\r\n" +
+		"<p>All <b>green</b>.</p>\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/csv; name=report.csv\r\n" +
+		"Content-Disposition: attachment; filename=report.csv\r\n" +
+		"\r\n" +
+		"a,b\r\n" +
+		"--sep--\r\n")
+	msg := &EmailMessage{UID: 42, Flags: []string{"\\Seen"}}
+
+	note, err := messageToMarkdown(raw, msg, "message-42.md", nil)
+	require.NoError(t, err)
+	parts := strings.SplitN(string(note), "---\n", 3)
+	require.Len(t, parts, 3)
+	assert.Empty(t, parts[0])
+
+	var front markdownFrontMatter
+	require.NoError(t, yaml.Unmarshal([]byte(parts[1]), &front))
+	assert.Equal(t, markdownFrontMatter{
+		Subject:     "Weekly report",
+		From:        []string{`"Alice" <alice@example.com>`},
+		To:          []string{"<bob@example.com>"},
+		Date:        "2024-12-02T10:00:00Z",
+		MessageID:   "report-1@example.com",
+		UID:         42,
+		Flags:       []string{"\\Seen"},
+		Attachments: []markdownAttachment{{Filename: "report.csv", Type: "text/csv", Size: 3}},
+	}, front)
+	assert.Equal(t, "\n# Weekly report\n\nAll **green**.\n\n## Attachments\n\n- report.csv (text/csv, 3 B)\n", parts[2])
+
+	blobs := []ExportBlob{{Filename: "report.csv", Type: "text/csv", Size: 3, SHA256: "ab", Path: "blobs/ab/ab"}}
+	note, err = messageToMarkdown(raw, msg, "2024/12/message-42.md", blobs)
+	require.NoError(t, err)
+	assert.Contains(t, string(note), "link: ../../blobs/ab/ab\n")
+	assert.Contains(t, string(note), "- [report.csv](../../blobs/ab/ab) (text/csv, 3 B)\n")
+}
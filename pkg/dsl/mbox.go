@@ -0,0 +1,219 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mboxFromLineLayouts are the date layouts found on mbox "From " separator
+// lines (asctime, with and without a trailing zone).
+var mboxFromLineLayouts = []string{
+	time.ANSIC,
+	"Mon Jan _2 15:04:05 2006 -0700",
+	"Mon Jan _2 15:04:05 MST 2006",
+}
+
+var mboxEscapedFrom = regexp.MustCompile(`^>+From `)
+
+// ReadLocalMessages reads messages from path, which is either an mbox file, a
+// single .eml file, a JSONL corpus (.jsonl) or a directory of .eml files
+// (walked recursively, maildir cur/new layouts included). Messages are
+// numbered in the order they are read.
+func ReadLocalMessages(path string) ([]*LocalMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return readEMLDirectory(path)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".jsonl") {
+		return readCorpusMessages(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if bytes.HasPrefix(data, []byte("From ")) {
+		return readMbox(path, bytes.NewReader(data))
+	}
+
+	msg, err := ParseLocalMessage(data, 1, maildirFlags(path), info.ModTime())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	msg.Source = path
+	return []*LocalMessage{msg}, nil
+}
+
+func readEMLDirectory(dir string) ([]*LocalMessage, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		parent := filepath.Base(filepath.Dir(path))
+		if strings.EqualFold(filepath.Ext(path), ".eml") || parent == "cur" || parent == "new" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	ret := make([]*LocalMessage, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		var modTime time.Time
+		if info, err := os.Stat(path); err == nil {
+			modTime = info.ModTime()
+		}
+		seqNum, err := checkedUint32FromInt(len(ret)+1, "seq_num")
+		if err != nil {
+			return nil, err
+		}
+		msg, err := ParseLocalMessage(data, seqNum, maildirFlags(path), modTime)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		msg.Source = path
+		ret = append(ret, msg)
+	}
+	return ret, nil
+}
+
+// readMbox splits an mboxo/mboxrd stream on "From " lines and unescapes
+// ">From " quoting in message bodies.
+func readMbox(path string, r io.Reader) ([]*LocalMessage, error) {
+	var ret []*LocalMessage
+	var current bytes.Buffer
+	var fromLine string
+	inMessage := false
+
+	flush := func() error {
+		if !inMessage {
+			return nil
+		}
+		raw := bytes.TrimSuffix(current.Bytes(), []byte("\n"))
+		raw = append([]byte(nil), raw...)
+		seqNum, err := checkedUint32FromInt(len(ret)+1, "seq_num")
+		if err != nil {
+			return err
+		}
+		msg, err := ParseLocalMessage(raw, seqNum, mboxFlags(raw), parseMboxFromLine(fromLine))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		msg.Source = fmt.Sprintf("%s#%d", path, seqNum)
+		ret = append(ret, msg)
+		current.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "From ") {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			fromLine = line
+			inMessage = true
+			continue
+		}
+		if mboxEscapedFrom.MatchString(line) {
+			line = line[1:]
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mbox %s: %w", path, err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseMboxFromLine extracts the delivery date from "From sender date".
+func parseMboxFromLine(line string) time.Time {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+	date := strings.Join(fields[2:], " ")
+	for _, layout := range mboxFromLineLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// mboxFlags maps the Status and X-Status headers written by mail clients to
+// IMAP flags.
+func mboxFlags(raw []byte) []string {
+	var flags []string
+	headerEnd := bytes.Index(raw, []byte("\n\n"))
+	if headerEnd < 0 {
+		headerEnd = len(raw)
+	}
+	for _, line := range strings.Split(string(raw[:headerEnd]), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(name) {
+		case "status":
+			if strings.Contains(value, "R") {
+				flags = append(flags, "\\Seen")
+			}
+		case "x-status":
+			for flag, letter := range map[string]string{"\\Answered": "A", "\\Flagged": "F", "\\Deleted": "D", "\\Draft": "T"} {
+				if strings.Contains(value, letter) {
+					flags = append(flags, flag)
+				}
+			}
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+// maildirFlags maps the ":2,<flags>" info suffix of maildir file names to IMAP
+// flags.
+func maildirFlags(path string) []string {
+	_, info, ok := strings.Cut(filepath.Base(path), ":2,")
+	if !ok {
+		return nil
+	}
+	letters := map[rune]string{'S': "\\Seen", 'R': "\\Answered", 'F': "\\Flagged", 'T': "\\Deleted", 'D': "\\Draft"}
+	var flags []string
+	for _, r := range info {
+		if flag, ok := letters[r]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	sort.Strings(flags)
+	return flags
+}
@@ -3,27 +3,78 @@ package dsl
 import (
 	"time"
 
+	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message/textproto"
 )
 
 // EmailMessage represents a fully fetched email message with all its data
 type EmailMessage struct {
-	UID        uint32
-	SeqNum     uint32
-	Envelope   *EmailEnvelope
-	Flags      []string
-	Size       uint32
-	MimeParts  []MimePart
-	RawContent map[string][]byte // Store different body sections by their part specifier
-	TotalCount uint32            // Total number of messages from search
+	UID       uint32
+	SeqNum    uint32
+	Envelope  *EmailEnvelope
+	Flags     []string
+	Size      uint32
+	MimeParts []MimePart
+	// Attachments are only populated when the rule needs them
+	Attachments []Attachment
+	// Encrypted is the encryption scheme (EncryptionPGP, EncryptionSMIME or
+	// EncryptionNone), only set when the rule needs it. Decrypted reports
+	// whether the content was replaced by the decrypted content.
+	Encrypted string
+	Decrypted bool
+	// ListID is the List-Id identifier, only populated when the rule
+	// outputs it
+	ListID string
+	// Label is the classify label, only set when the rule classifies
+	Label string
+	// Summary is the LLM summary, only set when the rule outputs it
+	Summary string
+	// Extracted holds the fields extracted from the body, only set when
+	// the rule's output extracts some
+	Extracted map[string]interface{}
+	// HTMLTables are the tables of the HTML parts, only set when the rule
+	// outputs them
+	HTMLTables []HTMLTable
+	// Language is the detected language, only set when the rule searches or
+	// outputs it
+	Language string
+	// classifyHeader holds the headers the built-in classifier looks at
+	classifyHeader textproto.Header
+	// decryptedText holds the decrypted text parts for body_contains
+	decryptedText string
+	RawContent    map[string][]byte // Store different body sections by their part specifier
+	TotalCount    uint32            // Total number of messages from search
 }
 
 // EmailEnvelope contains the message envelope information
 type EmailEnvelope struct {
-	Subject string
-	From    []EmailAddress
-	To      []EmailAddress
-	Date    time.Time
+	Subject   string
+	From      []EmailAddress
+	To        []EmailAddress
+	Cc        []EmailAddress
+	Bcc       []EmailAddress
+	Date      time.Time
+	MessageID string
+	InReplyTo []string
+	// References is only populated when threading needs it
+	References []string
+}
+
+// convertAddresses converts IMAP envelope addresses, keeping nil for an
+// empty list.
+func convertAddresses(addrs []imap.Address) []EmailAddress {
+	if len(addrs) == 0 {
+		return nil
+	}
+	converted := make([]EmailAddress, len(addrs))
+	for i, addr := range addrs {
+		converted[i] = EmailAddress{
+			Name:    addr.Name,
+			Address: addr.Mailbox + "@" + addr.Host,
+		}
+	}
+	return converted
 }
 
 // EmailAddress represents an email address with optional name
@@ -51,37 +102,35 @@ func NewEmailMessageFromIMAP(msg *imapclient.FetchMessageBuffer, mimeParts []Mim
 		Flags:      flags,
 		Size:       size,
 		MimeParts:  mimeParts,
+		ListID:     parseListID(msg.FindBodySection(listIDSection)),
 		RawContent: make(map[string][]byte),
+
+		classifyHeader: parseClassifyHeader(msg.FindBodySection(classifySection)),
 	}
 
 	if msg.Envelope != nil {
 		email.Envelope = &EmailEnvelope{
-			Subject: msg.Envelope.Subject,
-			Date:    msg.Envelope.Date,
+			Subject:    msg.Envelope.Subject,
+			Date:       msg.Envelope.Date,
+			MessageID:  msg.Envelope.MessageID,
+			InReplyTo:  msg.Envelope.InReplyTo,
+			References: parseReferences(msg.FindBodySection(referencesSection)),
 		}
 
-		// Convert From addresses
-		if len(msg.Envelope.From) > 0 {
-			email.Envelope.From = make([]EmailAddress, len(msg.Envelope.From))
-			for i, addr := range msg.Envelope.From {
-				email.Envelope.From[i] = EmailAddress{
-					Name:    addr.Name,
-					Address: addr.Mailbox + "@" + addr.Host,
-				}
-			}
-		}
-
-		// Convert To addresses
-		if len(msg.Envelope.To) > 0 {
-			email.Envelope.To = make([]EmailAddress, len(msg.Envelope.To))
-			for i, addr := range msg.Envelope.To {
-				email.Envelope.To[i] = EmailAddress{
-					Name:    addr.Name,
-					Address: addr.Mailbox + "@" + addr.Host,
-				}
-			}
-		}
+		email.Envelope.From = convertAddresses(msg.Envelope.From)
+		email.Envelope.To = convertAddresses(msg.Envelope.To)
+		email.Envelope.Cc = convertAddresses(msg.Envelope.Cc)
+		email.Envelope.Bcc = convertAddresses(msg.Envelope.Bcc)
 	}
 
 	return email, nil
 }
+
+// encryption returns the encryption scheme, EncryptionNone when it was not
+// detected.
+func (m *EmailMessage) encryption() string {
+	if m.Encrypted == "" {
+		return EncryptionNone
+	}
+	return m.Encrypted
+}
@@ -0,0 +1,106 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Namespace translates the logical mailbox names of rules into the names of
+// a server. Logical names separate levels with "/" and leave out the
+// personal namespace prefix: INBOX/Receipts is INBOX.Receipts on a server
+// with the prefix "INBOX." and the delimiter ".".
+type Namespace struct {
+	// Prefix is the prefix of the personal namespace, e.g. "INBOX."
+	Prefix string
+	// Delimiter separates the levels of mailbox names, 0 if the server has
+	// a flat namespace
+	Delimiter rune
+	// OtherPrefixes are the prefixes of the other users' and shared
+	// namespaces, whose mailboxes are left as written
+	OtherPrefixes []string
+}
+
+// LoadNamespace reads the personal namespace of the server with NAMESPACE
+// (RFC 2342). Servers without the extension have no prefix, and their
+// hierarchy delimiter is asked with LIST.
+func LoadNamespace(client *imapclient.Client) (*Namespace, error) {
+	if !client.Caps().Has(imap.CapNamespace) {
+		listed, err := client.List("", "", nil).Collect()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the hierarchy delimiter: %w", err)
+		}
+		ns := &Namespace{}
+		if len(listed) > 0 {
+			ns.Delimiter = listed[0].Delim
+		}
+		return ns, nil
+	}
+
+	data, err := client.Namespace().Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespaces: %w", err)
+	}
+	ns := &Namespace{}
+	if len(data.Personal) > 0 {
+		ns.Prefix = data.Personal[0].Prefix
+		ns.Delimiter = data.Personal[0].Delim
+	}
+	for _, descriptors := range [][]imap.NamespaceDescriptor{data.Other, data.Shared} {
+		for _, descriptor := range descriptors {
+			if descriptor.Prefix != "" {
+				ns.OtherPrefixes = append(ns.OtherPrefixes, descriptor.Prefix)
+			}
+		}
+	}
+	return ns, nil
+}
+
+// MailboxName returns the server name of the logical mailbox name. Names
+// already carrying the personal prefix, INBOX itself and mailboxes of the
+// other namespaces are not prefixed, so rules written for the server keep
+// working.
+func (n *Namespace) MailboxName(name string) string {
+	if n == nil || name == "" {
+		return name
+	}
+	for _, prefix := range n.OtherPrefixes {
+		if hasPrefixFold(name, prefix) {
+			return name
+		}
+	}
+
+	if n.Delimiter != 0 && n.Delimiter != '/' {
+		name = strings.ReplaceAll(name, "/", string(n.Delimiter))
+	}
+	if n.Prefix == "" || hasPrefixFold(name, n.Prefix) {
+		return name
+	}
+	if strings.EqualFold(name, "INBOX") || strings.EqualFold(name, strings.TrimSuffix(n.Prefix, string(n.Delimiter))) {
+		return name
+	}
+	return n.Prefix + name
+}
+
+// hasPrefixFold is strings.HasPrefix ignoring case, as INBOX is matched
+// case-insensitively.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// SetNamespace sets the namespace the rule's mailbox, move_to, copy_to,
+// snooze and Trash names are translated with. Without one they are used as
+// written.
+func (rule *Rule) SetNamespace(namespace *Namespace) {
+	rule.namespace = namespace
+}
+
+// MailboxName returns the server name of a mailbox named in the rule.
+func (rule *Rule) MailboxName(name string) string {
+	if rule == nil {
+		return name
+	}
+	return rule.namespace.MailboxName(name)
+}
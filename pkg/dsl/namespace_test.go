@@ -0,0 +1,58 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceMailboxName(t *testing.T) {
+	dovecot := &Namespace{Prefix: "INBOX.", Delimiter: '.', OtherPrefixes: []string{"shared."}}
+	flatDots := &Namespace{Delimiter: '.'}
+	gmail := &Namespace{Delimiter: '/'}
+
+	tests := []struct {
+		name      string
+		namespace *Namespace
+		mailbox   string
+		want      string
+	}{
+		{"logical child of INBOX", dovecot, "INBOX/Receipts", "INBOX.Receipts"},
+		{"prefix added", dovecot, "Receipts", "INBOX.Receipts"},
+		{"nested levels", dovecot, "Archive/2024", "INBOX.Archive.2024"},
+		{"already prefixed", dovecot, "INBOX.Receipts", "INBOX.Receipts"},
+		{"prefix matched case-insensitively", dovecot, "inbox.Receipts", "inbox.Receipts"},
+		{"INBOX itself", dovecot, "INBOX", "INBOX"},
+		{"other namespace left as written", dovecot, "shared.team/Inbox", "shared.team/Inbox"},
+		{"delimiter without prefix", flatDots, "Archive/2024", "Archive.2024"},
+		{"slash delimiter unchanged", gmail, "[Gmail]/Sent Mail", "[Gmail]/Sent Mail"},
+		{"nil namespace", nil, "Archive/2024", "Archive/2024"},
+		{"empty name", dovecot, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.namespace.MailboxName(tt.mailbox))
+		})
+	}
+}
+
+func TestGroupByMailboxTemplateNamespace(t *testing.T) {
+	rule := &Rule{Name: "archive"}
+	rule.SetNamespace(&Namespace{Prefix: "INBOX.", Delimiter: '.'})
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Subject: "a"}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "b"}},
+	}
+
+	groups, err := groupByMailboxTemplate(messages, "Archive/Receipts", rule)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "INBOX.Archive.Receipts", groups[0].mailbox)
+
+	groups, err = groupByMailboxTemplate(messages, "Archive/{{ .Subject }}", rule)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "INBOX.Archive.a", groups[0].mailbox)
+	assert.Equal(t, "INBOX.Archive.b", groups[1].mailbox)
+}
@@ -0,0 +1,324 @@
+package dsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification sink types built into the notify action.
+const (
+	NotifyEmail   = "email"
+	NotifySlack   = "slack"
+	NotifyDiscord = "discord"
+	NotifyWebhook = "webhook"
+	NotifyDesktop = "desktop"
+)
+
+// DefaultNotifyBody is the line each message adds to a notification.
+const DefaultNotifyBody = "{{ .From.Address }}: {{ .Subject }}"
+
+// NotifyConfig alerts humans about the matched messages through one or more
+// sinks. All sinks receive the same title and body. By default the matched
+// messages are sent as a single notification with a line per message;
+// per_message sends one notification per message instead. Title and body
+// are templates rendered against each message (see TemplateContext), so
+// messages need the envelope field for .Subject and .From.
+type NotifyConfig struct {
+	Sinks []NotifySink `yaml:"sinks"`
+	// Title defaults to the rule name and the number of messages
+	Title string `yaml:"title,omitempty"`
+	// Body defaults to DefaultNotifyBody
+	Body       string `yaml:"body,omitempty"`
+	PerMessage bool   `yaml:"per_message,omitempty"`
+}
+
+// NotifySink is where a notification goes.
+type NotifySink struct {
+	// Type is email, slack, discord, webhook, desktop or a type registered
+	// with RegisterNotifier
+	Type string `yaml:"type"`
+	// To is the recipient of email notifications, the sender address
+	// (--smtp-from) by default
+	To string `yaml:"to,omitempty"`
+	// URL is the incoming webhook of slack, discord and webhook sinks
+	URL string `yaml:"url,omitempty"`
+}
+
+// Notification is a rendered notification.
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Rule  string `json:"rule,omitempty"`
+}
+
+// Notifier delivers notifications to one sink.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFactory creates the Notifier of a sink. rule gives access to the
+// rule's Sender; it may be nil.
+type NotifierFactory func(sink NotifySink, rule *Rule) (Notifier, error)
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   = map[string]NotifierFactory{
+		NotifyEmail:   newEmailNotifier,
+		NotifySlack:   newWebhookNotifier(slackPayload),
+		NotifyDiscord: newWebhookNotifier(discordPayload),
+		NotifyWebhook: newWebhookNotifier(func(n Notification) interface{} { return n }),
+		NotifyDesktop: func(NotifySink, *Rule) (Notifier, error) { return desktopNotifier{}, nil },
+	}
+)
+
+// RegisterNotifier adds a sink type to the notify action.
+func RegisterNotifier(kind string, factory NotifierFactory) error {
+	if kind == "" {
+		return fmt.Errorf("notifier type is required")
+	}
+	if factory == nil {
+		return fmt.Errorf("notifier factory for %s is nil", kind)
+	}
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	if _, ok := notifiers[kind]; ok {
+		return fmt.Errorf("notifier %s is already registered", kind)
+	}
+	notifiers[kind] = factory
+	return nil
+}
+
+func lookupNotifier(kind string) (NotifierFactory, bool) {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	factory, ok := notifiers[kind]
+	return factory, ok
+}
+
+// Validate checks if the notify config is valid
+func (n *NotifyConfig) Validate() error {
+	if len(n.Sinks) == 0 {
+		return fmt.Errorf("at least one sink is required")
+	}
+	for i, sink := range n.Sinks {
+		if _, ok := lookupNotifier(sink.Type); !ok {
+			return fmt.Errorf("sink %d: unknown type %q", i+1, sink.Type)
+		}
+		switch sink.Type {
+		case NotifySlack, NotifyDiscord, NotifyWebhook:
+			if sink.URL == "" {
+				return fmt.Errorf("sink %d: %s requires 'url'", i+1, sink.Type)
+			}
+		}
+	}
+	for _, text := range []string{n.Title, n.Body} {
+		if err := ValidateTemplate(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifications renders the notifications for messages.
+func (n *NotifyConfig) notifications(messages []*EmailMessage, rule *Rule) ([]Notification, error) {
+	body := n.Body
+	if body == "" {
+		body = DefaultNotifyBody
+	}
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+
+	var ret []Notification
+	var lines []string
+	for _, msg := range messages {
+		data := NewTemplateContext(msg, rule)
+		line, err := RenderTemplate(body, data)
+		if err != nil {
+			return nil, err
+		}
+		if !n.PerMessage {
+			lines = append(lines, strings.TrimRight(line, "\n"))
+			continue
+		}
+		title := data.Subject
+		if n.Title != "" {
+			if title, err = RenderTemplate(n.Title, data); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, Notification{Title: title, Body: line, Rule: ruleName})
+	}
+	if n.PerMessage || len(lines) == 0 {
+		return ret, nil
+	}
+
+	title := fmt.Sprintf("%s: %d messages", ruleName, len(lines))
+	if len(lines) == 1 {
+		title = fmt.Sprintf("%s: 1 message", ruleName)
+	}
+	if n.Title != "" {
+		var err error
+		if title, err = RenderTemplate(n.Title, NewTemplateContext(messages[0], rule)); err != nil {
+			return nil, err
+		}
+	}
+	return append(ret, Notification{Title: title, Body: strings.Join(lines, "\n"), Rule: ruleName}), nil
+}
+
+// executeNotify sends the notifications for messages to every sink. A sink
+// failing does not keep the others from being notified.
+func executeNotify(messages []*EmailMessage, config *NotifyConfig, rule *Rule) error {
+	logger := rule.Logger()
+	if len(messages) == 0 {
+		return nil
+	}
+	notifications, err := config.notifications(messages, rule)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, sink := range config.Sinks {
+		factory, ok := lookupNotifier(sink.Type)
+		if !ok {
+			return fmt.Errorf("unknown notify sink %q", sink.Type)
+		}
+		notifier, err := factory(sink, rule)
+		if err == nil {
+			for _, n := range notifications {
+				if err = notifier.Notify(context.Background(), n); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			logger.Warn().Err(err).Str("sink", sink.Type).Msg("Failed to notify")
+			failed = append(failed, fmt.Sprintf("%s: %v", sink.Type, err))
+			continue
+		}
+		logger.Debug().Str("sink", sink.Type).Int("notifications", len(notifications)).Msg("Notified")
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("failed to notify %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// emailNotifier mails notifications through the rule's Sender.
+type emailNotifier struct {
+	sender Sender
+	to     string
+}
+
+func newEmailNotifier(sink NotifySink, rule *Rule) (Notifier, error) {
+	if rule == nil || rule.sender == nil {
+		return nil, fmt.Errorf("email notifications need an SMTP server (--smtp-server)")
+	}
+	to := sink.To
+	if to == "" {
+		to = rule.sender.From()
+	}
+	return &emailNotifier{sender: rule.sender, to: to}, nil
+}
+
+func (e *emailNotifier) Notify(_ context.Context, n Notification) error {
+	now := time.Now()
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "From: %s\r\n", e.sender.From())
+	_, _ = fmt.Fprintf(&b, "To: %s\r\n", e.to)
+	_, _ = fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Title))
+	_, _ = fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&b, "Message-ID: <notify.%d@smailnail.invalid>\r\n", now.UnixNano())
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Auto-Submitted: auto-generated\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return e.sender.Send([]string{e.to}, []byte(b.String()))
+}
+
+// webhookNotifier posts notifications as JSON, shaped by payload.
+type webhookNotifier struct {
+	url     string
+	payload func(Notification) interface{}
+}
+
+func newWebhookNotifier(payload func(Notification) interface{}) NotifierFactory {
+	return func(sink NotifySink, _ *Rule) (Notifier, error) {
+		if sink.URL == "" {
+			return nil, fmt.Errorf("%s notifications need a url", sink.Type)
+		}
+		return &webhookNotifier{url: sink.URL, payload: payload}, nil
+	}
+}
+
+func slackPayload(n Notification) interface{} {
+	return map[string]string{"text": "*" + n.Title + "*\n" + n.Body}
+}
+
+func discordPayload(n Notification) interface{} {
+	return map[string]string{"content": "**" + n.Title + "**\n" + n.Body}
+}
+
+func (w *webhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(w.payload(n))
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// desktopNotifier shows notifications with notify-send (DBus) on Linux and
+// the BSDs, and osascript on macOS.
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(ctx context.Context, n Notification) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(n.Body), appleScriptString(n.Title))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	case "windows":
+		return fmt.Errorf("desktop notifications are not supported on windows")
+	default:
+		cmd = exec.CommandContext(ctx, "notify-send", "--app-name=smailnail", "--", n.Title, n.Body)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", cmd.Args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
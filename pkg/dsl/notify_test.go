@@ -0,0 +1,76 @@
+package dsl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	to  []string
+	msg []byte
+}
+
+func (s *recordingSender) From() string { return "me@example.com" }
+
+func (s *recordingSender) Send(to []string, msg []byte) error {
+	s.to, s.msg = to, msg
+	return nil
+}
+
+func TestNotifyAction(t *testing.T) {
+	var payloads []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+
+	rule, err := ParseRuleString(`
+name: vip
+search:
+  from: boss@example.com
+output:
+  fields: [uid, envelope]
+actions:
+  notify:
+    sinks:
+      - type: slack
+        url: ` + server.URL + `
+      - type: discord
+        url: ` + server.URL + `
+      - type: email
+`)
+	require.NoError(t, err)
+	sender := &recordingSender{}
+	rule.SetSender(sender)
+
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Subject: "Budget", From: []EmailAddress{{Address: "boss@example.com"}}}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "Offsite", From: []EmailAddress{{Address: "boss@example.com"}}}},
+	}
+	require.NoError(t, executeNotify(messages, rule.Actions.Notify, rule))
+
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "*vip: 2 messages*\nboss@example.com: Budget\nboss@example.com: Offsite", payloads[0]["text"])
+	assert.Contains(t, payloads[1]["content"], "**vip: 2 messages**")
+	assert.Equal(t, []string{"me@example.com"}, sender.to)
+	assert.Contains(t, string(sender.msg), "Subject: vip: 2 messages\r\n")
+
+	config := &NotifyConfig{Sinks: []NotifySink{{Type: NotifySlack}}}
+	assert.ErrorContains(t, config.Validate(), "slack requires 'url'")
+	config = &NotifyConfig{Sinks: []NotifySink{{Type: "pager"}}}
+	assert.ErrorContains(t, config.Validate(), `unknown type "pager"`)
+
+	config = &NotifyConfig{Sinks: []NotifySink{{Type: NotifyWebhook, URL: server.URL}}, Title: "{{ .Subject }}", PerMessage: true}
+	notifications, err := config.notifications(messages, rule)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "Offsite", notifications[1].Title)
+	assert.Equal(t, "boss@example.com: Offsite", notifications[1].Body)
+}
@@ -1,39 +1,90 @@
 package dsl
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"strings"
-	"time"
 )
 
 // OutputMessages formats and prints a list of email messages
 func OutputMessages(messages []*EmailMessage, config OutputConfig) error {
+	return writeMessages(os.Stdout, messages, config, nil)
+}
+
+// OutputMessages formats and prints messages with the rule's output
+// configuration. Output templates see the rule metadata.
+func (rule *Rule) OutputMessages(messages []*EmailMessage) error {
+	return rule.WriteOutput(os.Stdout, messages)
+}
+
+// WriteOutput writes messages to w as OutputMessages prints them.
+func (rule *Rule) WriteOutput(w io.Writer, messages []*EmailMessage) error {
+	return writeMessages(w, messages, rule.Output, rule)
+}
+
+func writeMessages(w io.Writer, messages []*EmailMessage, config OutputConfig, rule *Rule) error {
+	if config.GroupByThread {
+		return writeThreads(w, GroupThreads(messages), config)
+	}
+	if config.FlagSummary {
+		return writeFlagSummary(w, SummarizeFlags(messages), config)
+	}
+
+	if config.Format == FormatAtom {
+		return WriteAtom(w, messages, AtomFeedForRule(rule))
+	}
+
+	// NDJSON is meant for pipelines: no separators, no summary line
+	if config.Format == "ndjson" {
+		for i, msg := range messages {
+			if err := WriteNDJSON(w, msg, config); err != nil {
+				return fmt.Errorf("failed to format message %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+
 	for i, msg := range messages {
-		output, err := FormatOutput(msg, config)
+		output, err := formatOutput(msg, config, rule)
 		if err != nil {
 			return fmt.Errorf("failed to format message %d: %w", i+1, err)
 		}
 
 		if i > 0 {
-			fmt.Println("----------------------------------------")
+			_, _ = fmt.Fprintln(w, "----------------------------------------")
 		}
-		fmt.Println(output)
+		_, _ = fmt.Fprintln(w, output)
 	}
 
-	fmt.Printf("\nFound %d message(s) matching the criteria\n", len(messages))
-	return nil
+	_, err := fmt.Fprintf(w, "\nFound %d message(s) matching the criteria\n", len(messages))
+	return err
 }
 
 // FormatOutput formats message data according to OutputConfig
 func FormatOutput(msg *EmailMessage, config OutputConfig) (string, error) {
+	return formatOutput(msg, config, nil)
+}
+
+func formatOutput(msg *EmailMessage, config OutputConfig, rule *Rule) (string, error) {
 	switch config.Format {
 	case "json":
 		return formatOutputJSON(msg, config)
+	case "ndjson":
+		data, err := json.Marshal(jsonOutput(msg, config))
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		return string(data), nil
 	case "table":
 		return formatOutputTable(msg, config)
 	default:
-		// Default to text format
+		// Default to text format, rendered through the output template if set
+		if config.Template != "" {
+			return RenderTemplate(config.Template, NewTemplateContext(msg, rule))
+		}
 		return formatOutputText(msg, config)
 	}
 }
@@ -51,10 +102,35 @@ type MimePart struct {
 	Charset     string
 }
 
+// WriteNDJSON writes msg as a single line of JSON to w. Each call produces one
+// complete line, so output can be consumed while a run is still in progress.
+func WriteNDJSON(w io.Writer, msg *EmailMessage, config OutputConfig) error {
+	data, err := json.Marshal(jsonOutput(msg, config))
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write JSON line: %w", err)
+	}
+	return nil
+}
+
 // formatOutputJSON formats message data as JSON
 func formatOutputJSON(msg *EmailMessage, config OutputConfig) (string, error) {
-	// Create a map to hold the output data
-	output := make(map[string]interface{})
+	// Convert to JSON
+	jsonData, err := json.MarshalIndent(jsonOutput(msg, config), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+
+	return string(jsonData), nil
+}
+
+// jsonOutput collects the configured fields of msg for the JSON formats, in
+// field order and under their output names
+func jsonOutput(msg *EmailMessage, config OutputConfig) jsonObject {
+	output := jsonObject{}
 
 	// Process each field
 	for _, fieldInterface := range config.Fields {
@@ -63,44 +139,104 @@ func formatOutputJSON(msg *EmailMessage, config OutputConfig) (string, error) {
 			// Skip fields that couldn't be properly parsed
 			continue
 		}
+		key := field.OutputName()
+
+		if field.IsPath() {
+			value, err := SelectPath(msg, field.Name)
+			if err == nil {
+				output = output.set(key, value)
+			}
+			continue
+		}
 
 		switch field.Name {
 		case "uid":
-			output["uid"] = msg.UID
+			output = output.set(key, msg.UID)
 		case "subject":
 			if msg.Envelope != nil {
-				output["subject"] = msg.Envelope.Subject
+				output = output.set(key, msg.Envelope.Subject)
 			}
 		case "from":
 			if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-				output["from"] = msg.Envelope.From
+				output = output.set(key, msg.Envelope.From)
 			}
 		case "to":
 			if msg.Envelope != nil && len(msg.Envelope.To) > 0 {
-				output["to"] = msg.Envelope.To
+				output = output.set(key, msg.Envelope.To)
 			}
 		case "date":
 			if msg.Envelope != nil {
-				output["date"] = msg.Envelope.Date.Format(time.RFC3339)
+				output = output.set(key, config.FormatDate(msg.Envelope.Date))
 			}
 		case "flags":
-			output["flags"] = msg.Flags
+			output = output.set(key, msg.Flags)
 		case "size":
-			output["size"] = msg.Size
+			output = output.set(key, config.SizeValue(msg.Size))
 		case "mime_parts":
 			if len(msg.MimeParts) > 0 {
-				output["mime_parts"] = msg.MimeParts
+				output = output.set(key, msg.MimeParts)
+			}
+		case "attachments":
+			if len(msg.Attachments) > 0 {
+				output = output.set(key, msg.Attachments)
+			}
+		case "encrypted":
+			output = output.set(key, msg.encryption())
+		case "list_id":
+			output = output.set(key, msg.ListID)
+		case "label":
+			output = output.set(key, msg.Label)
+		case "summary":
+			output = output.set(key, msg.Summary)
+		case "language":
+			output = output.set(key, msg.Language)
+		case "html_tables":
+			if len(msg.HTMLTables) > 0 {
+				output = output.set(key, msg.HTMLTables)
+			}
+		default:
+			if value, ok := msg.Extracted[field.Name]; ok {
+				output = output.set(key, value)
 			}
 		}
 	}
 
-	// Convert to JSON
-	jsonData, err := json.MarshalIndent(output, "", "  ")
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal JSON: %w", err)
-	}
+	return output
+}
 
-	return string(jsonData), nil
+// jsonObject is a JSON object that keeps its keys in insertion order.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Key   string
+	Value interface{}
+}
+
+func (o jsonObject) set(key string, value interface{}) jsonObject {
+	return append(o, jsonMember{Key: key, Value: value})
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, member := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(member.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(member.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
 }
 
 // formatOutputText formats message data as plain text
@@ -115,20 +251,37 @@ func formatOutputText(msg *EmailMessage, config OutputConfig) (string, error) {
 			continue
 		}
 
+		if field.IsPath() {
+			value, err := SelectPath(msg, field.Name)
+			if err != nil {
+				return "", err
+			}
+			_, _ = fmt.Fprintf(&sb, "%s: %v\n", field.OutputName(), value)
+			continue
+		}
+
+		// Aliased fields replace the default label
+		label := func(defaultLabel string) string {
+			if field.As != "" {
+				return field.As
+			}
+			return defaultLabel
+		}
+
 		switch field.Name {
 		case "uid":
-			_, _ = fmt.Fprintf(&sb, "UID: %d\n", msg.UID)
+			_, _ = fmt.Fprintf(&sb, "%s: %d\n", label("UID"), msg.UID)
 		case "subject":
 			if msg.Envelope != nil {
-				_, _ = fmt.Fprintf(&sb, "Subject: %s\n", msg.Envelope.Subject)
+				_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Subject"), msg.Envelope.Subject)
 			}
 		case "from":
 			if msg.Envelope != nil && len(msg.Envelope.From) > 0 {
-				_, _ = fmt.Fprintf(&sb, "From: %s\n", formatEmailAddress(msg.Envelope.From[0]))
+				_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("From"), formatEmailAddress(msg.Envelope.From[0]))
 			}
 		case "to":
 			if msg.Envelope != nil && len(msg.Envelope.To) > 0 {
-				sb.WriteString("To: ")
+				sb.WriteString(label("To") + ": ")
 				for i, addr := range msg.Envelope.To {
 					if i > 0 {
 						sb.WriteString(", ")
@@ -139,12 +292,37 @@ func formatOutputText(msg *EmailMessage, config OutputConfig) (string, error) {
 			}
 		case "date":
 			if msg.Envelope != nil {
-				_, _ = fmt.Fprintf(&sb, "Date: %s\n", msg.Envelope.Date.Format(time.RFC3339))
+				_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Date"), config.FormatDate(msg.Envelope.Date))
 			}
 		case "flags":
-			_, _ = fmt.Fprintf(&sb, "Flags: %v\n", msg.Flags)
+			_, _ = fmt.Fprintf(&sb, "%s: %v\n", label("Flags"), msg.Flags)
 		case "size":
-			_, _ = fmt.Fprintf(&sb, "Size: %d bytes\n", msg.Size)
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Size"), config.sizeText(msg.Size))
+		case "encrypted":
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Encrypted"), msg.encryption())
+		case "list_id":
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("List-Id"), msg.ListID)
+		case "label":
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Label"), msg.Label)
+		case "summary":
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Summary"), msg.Summary)
+		case "language":
+			_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Language"), msg.Language)
+		case "html_tables":
+			for _, table := range msg.HTMLTables {
+				_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Table"), table.Caption)
+				if len(table.Header) > 0 {
+					_, _ = fmt.Fprintf(&sb, "  %s\n", strings.Join(table.Header, " | "))
+				}
+				for _, row := range table.Rows {
+					_, _ = fmt.Fprintf(&sb, "  %s\n", strings.Join(row, " | "))
+				}
+			}
+		case "attachments":
+			for _, attachment := range msg.Attachments {
+				_, _ = fmt.Fprintf(&sb, "%s: %s (%s, %s) sha256:%s\n", label("Attachment"),
+					attachment.Filename, attachment.Type, config.sizeText(attachment.Size), attachment.SHA256)
+			}
 		case "mime_parts":
 			if len(msg.MimeParts) > 0 {
 				for _, part := range msg.MimeParts {
@@ -153,10 +331,14 @@ func formatOutputText(msg *EmailMessage, config OutputConfig) (string, error) {
 						if len(content) > field.Content.MaxLength && field.Content.MaxLength > 0 {
 							content = content[:field.Content.MaxLength] + "..."
 						}
-						_, _ = fmt.Fprintf(&sb, "Content: %s\n", content)
+						_, _ = fmt.Fprintf(&sb, "%s: %s\n", label("Content"), content)
 					}
 				}
 			}
+		default:
+			if value, ok := msg.Extracted[field.Name]; ok {
+				_, _ = fmt.Fprintf(&sb, "%s: %v\n", field.OutputName(), value)
+			}
 		}
 	}
 
@@ -0,0 +1,110 @@
+package dsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteNDJSON(t *testing.T) {
+	config := OutputConfig{
+		Format: "ndjson",
+		Fields: []interface{}{Field{Name: "uid"}, Field{Name: "subject"}},
+	}
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{Subject: "first\nline"}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "second"}},
+	}
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		require.NoError(t, WriteNDJSON(&buf, msg, config))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &decoded))
+		assert.Equal(t, float64(messages[i].UID), decoded["uid"])
+		assert.Equal(t, messages[i].Envelope.Subject, decoded["subject"])
+	}
+
+	formatted, err := FormatOutput(messages[0], config)
+	require.NoError(t, err)
+	assert.NotContains(t, formatted, "\n")
+}
+
+func TestNDJSONFormatValidates(t *testing.T) {
+	_, err := ParseRuleString(`
+name: stream
+output:
+  format: ndjson
+  fields: [uid]
+`)
+	require.NoError(t, err)
+}
+
+func TestHumanizeSize(t *testing.T) {
+	assert.Equal(t, "512 B", HumanizeSize(512))
+	assert.Equal(t, "1.5 KB", HumanizeSize(1536))
+	assert.Equal(t, "2.0 MB", HumanizeSize(2*1024*1024))
+	assert.Equal(t, "3.0 GB", HumanizeSize(3*1024*1024*1024))
+}
+
+func TestOutputDateAndSizeFormat(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: readable
+output:
+  format: text
+  date_format: "2006-01-02 15:04"
+  timezone: America/New_York
+  fields: [date, size]
+`)
+	require.NoError(t, err)
+
+	msg := &EmailMessage{
+		Size:     1536,
+		Envelope: &EmailEnvelope{Date: time.Date(2025, 1, 2, 15, 4, 0, 0, time.UTC)},
+	}
+
+	text, err := FormatOutput(msg, rule.Output)
+	require.NoError(t, err)
+	assert.Contains(t, text, "Date: 2025-01-02 10:04\n")
+	assert.Contains(t, text, "Size: 1.5 KB\n")
+
+	rule.Output.Format = "json"
+	out, err := FormatOutput(msg, rule.Output)
+	require.NoError(t, err)
+	assert.Contains(t, out, `"size": 1536`)
+
+	rule.Output.SizeFormat = SizeFormatHuman
+	out, err = FormatOutput(msg, rule.Output)
+	require.NoError(t, err)
+	assert.Contains(t, out, `"size": "1.5 KB"`)
+}
+
+func TestOutputFormatOptionsValidate(t *testing.T) {
+	_, err := ParseRuleString(`
+name: bad-zone
+output:
+  timezone: Mars/Olympus
+  fields: [uid]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid timezone")
+
+	_, err = ParseRuleString(`
+name: bad-size
+output:
+  size_format: kilobytes
+  fields: [uid]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid size_format")
+}
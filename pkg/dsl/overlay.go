@@ -0,0 +1,135 @@
+package dsl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OverlayPath returns the overlay of a rule file for env: rules.prod.yaml
+// for rules.yaml and prod.
+func OverlayPath(filename string, env string) string {
+	ext := filepath.Ext(filename)
+	return strings.TrimSuffix(filename, ext) + "." + env + ext
+}
+
+// ParseRuleFileForEnv parses a rule file like ParseRuleFile, with the
+// overlay for env (see OverlayPath) merged on top when it exists. Mappings
+// are merged key by key, any other overlay value replaces the base value,
+// and a null value removes it. An overlay naming a rule has to name the rule
+// of the base file. An empty env parses the file alone.
+func ParseRuleFileForEnv(filename string, env string) (*Rule, error) {
+	if env == "" {
+		return ParseRuleFile(filename)
+	}
+
+	// #nosec G304 -- the CLI intentionally accepts a user-specified rule file path.
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+	overlayFile := OverlayPath(filename, env)
+	// #nosec G304 -- the overlay sits next to the user-specified rule file.
+	overlayData, err := os.ReadFile(overlayFile)
+	switch {
+	case os.IsNotExist(err):
+		return ParseRuleFile(filename)
+	case err != nil:
+		return nil, fmt.Errorf("failed to read overlay file: %w", err)
+	}
+
+	merged, err := MergeRuleOverlay(data, overlayData)
+	if err != nil {
+		return nil, fmt.Errorf("overlay %s: %w", overlayFile, err)
+	}
+	rule, err := ParseRuleString(string(merged))
+	if err != nil {
+		return nil, err
+	}
+	rule.Search.resolveListPaths(filepath.Dir(filename))
+	return rule, nil
+}
+
+// MergeRuleOverlay merges the YAML of an overlay onto the YAML of a rule,
+// returning the merged YAML.
+func MergeRuleOverlay(base []byte, overlay []byte) ([]byte, error) {
+	var baseDoc, overlayDoc yaml.Node
+	if err := yaml.Unmarshal(base, &baseDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if err := yaml.Unmarshal(overlay, &overlayDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if len(overlayDoc.Content) == 0 {
+		return base, nil
+	}
+	baseRoot, overlayRoot := documentRoot(&baseDoc), documentRoot(&overlayDoc)
+	if baseRoot == nil || baseRoot.Kind != yaml.MappingNode || overlayRoot.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("rule and overlay must both be mappings")
+	}
+
+	if name := mappingValue(overlayRoot, "name"); name != nil && name.Value != mappingValueString(baseRoot, "name") {
+		return nil, fmt.Errorf("overlay is for rule %q, not %q", name.Value, mappingValueString(baseRoot, "name"))
+	}
+	mergeMappings(baseRoot, overlayRoot)
+
+	merged, err := yaml.Marshal(&baseDoc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write merged YAML: %w", err)
+	}
+	return merged, nil
+}
+
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return nil
+}
+
+// mergeMappings merges the keys of overlay into base.
+func mergeMappings(base *yaml.Node, overlay *yaml.Node) {
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		j := mappingIndex(base, key.Value)
+		switch {
+		case value.Tag == "!!null":
+			if j >= 0 {
+				base.Content = append(base.Content[:j], base.Content[j+2:]...)
+			}
+		case j < 0:
+			base.Content = append(base.Content, key, value)
+		case base.Content[j+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeMappings(base.Content[j+1], value)
+		default:
+			base.Content[j+1] = value
+		}
+	}
+}
+
+// mappingIndex returns the index of key in a mapping node, or -1.
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
+
+func mappingValueString(mapping *yaml.Node, key string) string {
+	if value := mappingValue(mapping, key); value != nil {
+		return value.Value
+	}
+	return ""
+}
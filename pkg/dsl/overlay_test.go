@@ -0,0 +1,54 @@
+package dsl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRuleFileForEnv(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "archive.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+name: archive
+search:
+  subject_contains: invoice
+  from: billing@example.com
+output:
+  fields: [uid]
+  limit: 500
+actions:
+  move_to: Archive
+`), 0o600))
+	assert.Equal(t, filepath.Join(dir, "archive.prod.yaml"), OverlayPath(path, "prod"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "archive.staging.yaml"), []byte(`
+mailbox: Staging
+dry_run: true
+search:
+  from: null
+output:
+  limit: 20
+`), 0o600))
+
+	rule, err := ParseRuleFileForEnv(path, "staging")
+	require.NoError(t, err)
+	assert.Equal(t, "Staging", rule.Mailbox)
+	assert.True(t, rule.DryRun)
+	assert.Equal(t, "invoice", rule.Search.SubjectContains)
+	assert.Empty(t, rule.Search.From)
+	assert.Equal(t, 20, rule.Output.Limit)
+	assert.Equal(t, "Archive", rule.Actions.MoveTo)
+
+	// Without an overlay the rule is used as is
+	rule, err = ParseRuleFileForEnv(path, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, 500, rule.Output.Limit)
+	assert.False(t, rule.DryRun)
+
+	_, err = MergeRuleOverlay([]byte("name: archive\n"), []byte("name: other\n"))
+	assert.ErrorContains(t, err, `overlay is for rule "other", not "archive"`)
+}
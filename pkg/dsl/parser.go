@@ -3,6 +3,7 @@ package dsl
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
@@ -17,7 +18,12 @@ func ParseRuleFile(filename string) (*Rule, error) {
 	}
 
 	// Parse YAML
-	return ParseRuleString(string(data))
+	rule, err := ParseRuleString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	rule.Search.resolveListPaths(filepath.Dir(filename))
+	return rule, nil
 }
 
 // ParseRuleString parses a YAML string into a Rule struct
@@ -30,9 +36,13 @@ func ParseRuleString(yamlStr string) (*Rule, error) {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	if err := rule.Search.ExpandQuery(); err != nil {
+		return nil, fmt.Errorf("%w: invalid search config: %w", ErrInvalidRule, err)
+	}
+
 	// Validate the rule using the Validate method
 	if err := rule.Validate(); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRule, err)
 	}
 
 	// Set default values if needed
@@ -42,3 +52,13 @@ func ParseRuleString(yamlStr string) (*Rule, error) {
 
 	return &rule, nil
 }
+
+// resolveListPaths makes relative address and hash list paths relative to
+// dir, the directory of the rule file.
+func (s *SearchConfig) resolveListPaths(dir string) {
+	for _, path := range []*string{&s.FromInFile, &s.FromNotInFile, &s.AttachmentSHA256InFile} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+}
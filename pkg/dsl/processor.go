@@ -37,62 +37,136 @@ package dsl
 import (
 	"fmt"
 	"io"
+	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
-	"github.com/rs/zerolog/log"
 )
 
 // FetchMessages retrieves messages from IMAP server based on the rule
 func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, error) {
+	var result []*EmailMessage
+	err := rule.fetchMessages(client, 0, func(msg *EmailMessage) error {
+		result = append(result, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Matched returns the number of messages the last FetchMessages,
+// StreamMessages or FetchThreads search matched on the server, before
+// output.limit and client-side filters.
+func (rule *Rule) Matched() int {
+	return rule.matched
+}
+
+// StreamMessages retrieves the messages matching the rule like FetchMessages,
+// but fetches them in batches of StreamBatchSize and calls fn for each message
+// as soon as it has been processed. Returning an error from fn stops the
+// stream.
+func (rule *Rule) StreamMessages(client *imapclient.Client, fn func(*EmailMessage) error) error {
+	return rule.fetchMessages(client, StreamBatchSize, fn)
+}
+
+// StreamBatchSize is the number of messages fetched per round trip by
+// StreamMessages.
+var StreamBatchSize = 50
+
+func (rule *Rule) fetchMessages(client *imapclient.Client, batchSize int, emit func(*EmailMessage) error) (err error) {
+	logger := rule.Logger()
 	startTime := time.Now()
+	stage := StageSearch
 	defer func() {
-		log.Debug().
-			Str("rule", rule.Name).
+		if err != nil {
+			rule.publish(ErrorOccurred{Rule: rule.Name, Stage: stage, Err: err})
+		}
+		logger.Debug().
 			Str("duration", time.Since(startTime).String()).
 			Msg("FetchMessages completed")
 	}()
 
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Interface("search_config", rule.Search).
 		Interface("output_config", rule.Output).
 		Msg("Starting message fetch operation")
 
+	rule.matched = 0
+	rule.fetched = nil
+	if rule.input != nil && len(rule.input.fetched) == 0 {
+		logger.Debug().
+			Str("input_from", rule.input.Name).
+			Msg("Input rule fetched no messages, nothing to search")
+		return nil
+	}
+
 	// 1. Build search criteria
 	criteriaStartTime := time.Now()
-	criteria, options, err := BuildSearchCriteria(rule.Search, &rule.Output)
+	criteria, options, err := rule.buildSearchCriteria()
 	if err != nil {
-		return nil, fmt.Errorf("failed to build search criteria: %w", err)
+		return fmt.Errorf("failed to build search criteria: %w", err)
 	}
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Str("duration", time.Since(criteriaStartTime).String()).
 		Interface("search_options", options).
 		Msg("Built search criteria and options")
+	rule.publish(SearchStarted{Rule: rule.Name, Criteria: criteria})
 
 	// 2. Execute search
 	searchStartTime := time.Now()
+	searchSpan := rule.startSpan("imap.search", AttrMailbox.String(selectedMailbox(client)))
 	searchCmd := client.Search(criteria, options)
 	searchData, err := searchCmd.Wait()
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute search: %w", err)
+		err = fmt.Errorf("failed to execute search: %w", wrapSearchError(err))
+		endSpan(searchSpan, err)
+		return err
 	}
-	searchDuration := time.Since(searchStartTime)
 
 	// 3. Check if we have results
 	seqNums := searchData.AllSeqNums()
 	totalFound := len(seqNums)
-	if searchData.Count > 0 {
+	if rule.Chunking.looksTruncated(searchData) {
+		// The server capped the results, search again in date windows
+		logger.Info().
+			Int("returned", len(seqNums)).
+			Uint32("count", searchData.Count).
+			Msg("Search results look truncated, searching in date windows")
+		seqNums, err = rule.searchInWindows(client, criteria)
+		if err != nil {
+			endSpan(searchSpan, err)
+			return err
+		}
+		totalFound = len(seqNums)
+	} else if searchData.Count > 0 {
 		// If we have count from the server, use that as the total
 		totalFound = int(searchData.Count)
 	}
+	searchDuration := time.Since(searchStartTime)
+	// Body criteria the server does not search are matched on the
+	// downloaded text parts of the candidates
+	if rule.Search.clientSideBody() && len(seqNums) > 0 {
+		seqNums, err = rule.filterBody(client, seqNums)
+		if err != nil {
+			err = fmt.Errorf("failed to match message bodies: %w", err)
+			endSpan(searchSpan, err)
+			return err
+		}
+		totalFound = len(seqNums)
+	}
+	rule.matched = totalFound
+	searchSpan.SetAttributes(AttrMatched.Int(totalFound))
+	searchSpan.End()
+	rule.publish(SearchCompleted{Rule: rule.Name, Matched: totalFound, Duration: searchDuration})
+	stage = StageFetch
 
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Str("duration", searchDuration.String()).
 		Int("total_messages_found", totalFound).
 		Int("seqnums_returned", len(seqNums)).
@@ -103,14 +177,13 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		Msg("Search completed")
 
 	if totalFound == 0 {
-		return nil, nil
+		return nil
 	}
 
 	// If no sequence numbers were returned but we have a count,
 	// we need to fetch the most recent messages manually
 	if len(seqNums) == 0 && totalFound > 0 {
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Int("total_count", totalFound).
 			Msg("No sequence numbers returned but count > 0, fetching most recent messages")
 
@@ -126,12 +199,11 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		// Apply offset if specified
 		offset := rule.Output.Offset
 		if offset > totalFound {
-			log.Warn().
-				Str("rule", rule.Name).
+			logger.Warn().
 				Int("offset", offset).
 				Int("total_messages", totalFound).
 				Msg("Offset exceeds total messages count, no messages will be fetched")
-			return nil, nil
+			return nil
 		}
 
 		// Calculate range based on offset and limit
@@ -141,8 +213,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 			endSeq = 1
 		}
 
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Int("start_seq", startSeq).
 			Int("end_seq", endSeq).
 			Int("will_fetch", startSeq-endSeq+1).
@@ -150,11 +221,11 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 
 		startSeq32, err := checkedUint32FromInt(startSeq, "start_seq")
 		if err != nil {
-			return nil, err
+			return err
 		}
 		endSeq32, err := checkedUint32FromInt(endSeq, "end_seq")
 		if err != nil {
-			return nil, err
+			return err
 		}
 		manualSeqSet.AddRange(endSeq32, startSeq32)
 
@@ -164,11 +235,10 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 
 		uidMessages, err := client.Fetch(manualSeqSet, &uidFetchOptions).Collect()
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch message UIDs: %w", err)
+			return fmt.Errorf("failed to fetch message UIDs: %w", err)
 		}
 
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Int("messages_fetched", len(uidMessages)).
 			Msg("Fetched UIDs for messages")
 
@@ -183,8 +253,179 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		}
 	}
 
-	// 4. Create sequence set from results, respecting the limit and offset if set
-	var seqSet imap.SeqSet
+	// With output.sort_by, order the results before paginating. The selection
+	// below takes the last entries, so the sorted list is reversed to make it
+	// pick the first ones, in sort order.
+	var position map[uint32]int
+	if rule.Output.SortBy != "" {
+		seqNums, err = rule.sortSeqNums(client, criteria, seqNums)
+		if err != nil {
+			return fmt.Errorf("failed to sort messages: %w", err)
+		}
+		position = make(map[uint32]int, len(seqNums))
+		for i, seqNum := range seqNums {
+			position[seqNum] = i
+		}
+		for i, j := 0, len(seqNums)-1; i < j; i, j = i+1, j-1 {
+			seqNums[i], seqNums[j] = seqNums[j], seqNums[i]
+		}
+	}
+
+	// 4. Select sequence numbers from results, respecting the limit and offset
+	// if set. With a client-side filter, the limit and offset count the
+	// messages passing it: every candidate is selected, newest first, and the
+	// batches are fetched until the limit is reached.
+	postFilter := false
+	if rule.Output.Limit > 0 || rule.Output.Offset > 0 {
+		filter, err := rule.clientFilter()
+		if err != nil {
+			return err
+		}
+		postFilter = filter != nil
+	}
+	var selected []uint32
+	batchOrder := position
+	if postFilter {
+		batchOrder = make(map[uint32]int, len(seqNums))
+		for i := len(seqNums) - 1; i >= 0; i-- {
+			batchOrder[seqNums[i]] = len(selected)
+			selected = append(selected, seqNums[i])
+		}
+		if batchSize <= 0 && rule.Output.Limit > 0 {
+			batchSize = rule.Output.Offset + rule.Output.Limit
+		}
+	} else {
+		selected = rule.paginateSeqNums(seqNums)
+		if selected == nil {
+			return nil
+		}
+		// Fetch in ascending order so batches come out in the same order a
+		// single fetch would return them, unless an explicit sort order was
+		// requested
+		if position == nil {
+			sort.Slice(selected, func(i, j int) bool { return selected[i] < selected[j] })
+		}
+	}
+	if batchSize <= 0 {
+		batchSize = len(selected)
+	}
+
+	emitted := 0
+	summarize := rule.summarize(func(msg *EmailMessage) (string, error) {
+		return fetchMessageText(client, msg)
+	})
+	extract := rule.extract(func(msg *EmailMessage) ([]MimePart, error) {
+		return fetchMessageParts(client, msg)
+	})
+	process := func(msg *EmailMessage) error {
+		emitted++
+		rule.fetched.AddNum(imap.UID(msg.UID))
+		if extract != nil {
+			extract(msg)
+		}
+		if summarize != nil {
+			summarize(msg)
+		}
+		return emit(msg)
+	}
+	// With a post-filtered limit, the passing messages are collected and
+	// processed once the page is complete
+	var matches []*EmailMessage
+	skipped := 0
+	limitReached := func() bool {
+		return rule.Output.Limit > 0 && len(matches) >= rule.Output.Limit
+	}
+	next := process
+	if postFilter {
+		next = func(msg *EmailMessage) error {
+			switch {
+			case skipped < rule.Output.Offset:
+				skipped++
+			case !limitReached():
+				matches = append(matches, msg)
+			}
+			return nil
+		}
+	}
+	filtered, err := rule.filterEmitter(next)
+	if err != nil {
+		return err
+	}
+	fetched := 0
+	mailbox := selectedMailbox(client)
+	emitFiltered := func(msg *EmailMessage) error {
+		fetched++
+		rule.publish(MessageFetched{Rule: rule.Name, Message: msg, Fetched: fetched, Total: len(selected)})
+		// An earlier rule of the run stopped processing this message, or
+		// acted on it already
+		if rule.handled.Has(mailbox, msg.UID) || rule.processed.Has(mailbox, msg.UID) {
+			return nil
+		}
+		if postFilter && limitReached() {
+			return nil
+		}
+		return filtered(msg)
+	}
+
+	for start := 0; start < len(selected) && !(postFilter && limitReached()); start += batchSize {
+		end := start + batchSize
+		if end > len(selected) {
+			end = len(selected)
+		}
+		var seqSet imap.SeqSet
+		seqSet.AddNum(selected[start:end]...)
+		if batchOrder == nil {
+			if err := rule.fetchBatch(client, seqSet, totalFound, emitFiltered); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The server returns a batch in sequence order, restore the order of
+		// the selection
+		var batch []*EmailMessage
+		if err := rule.fetchBatch(client, seqSet, totalFound, func(msg *EmailMessage) error {
+			batch = append(batch, msg)
+			return nil
+		}); err != nil {
+			return err
+		}
+		sort.SliceStable(batch, func(i, j int) bool {
+			return batchOrder[batch[i].SeqNum] < batchOrder[batch[j].SeqNum]
+		})
+		for _, msg := range batch {
+			if err := emitFiltered(msg); err != nil {
+				return err
+			}
+		}
+	}
+	if postFilter {
+		// Output the page in sequence order, or in sort order
+		if position == nil {
+			sort.Slice(matches, func(i, j int) bool { return matches[i].SeqNum < matches[j].SeqNum })
+		}
+		for _, msg := range matches {
+			if err := process(msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Info().
+		Int("total_messages_found", totalFound).
+		Int("messages_fetched", len(selected)).
+		Int("messages_processed", emitted).
+		Str("duration", time.Since(startTime).String()).
+		Msg("Fetch messages operation complete")
+
+	return nil
+}
+
+// paginateSeqNums selects the sequence numbers of seqNums respecting
+// output.limit and output.offset, most recent (last) first. It returns nil
+// when there is nothing to fetch.
+func (rule *Rule) paginateSeqNums(seqNums []uint32) []uint32 {
+	logger := rule.Logger()
 	limit := len(seqNums)
 	if rule.Output.Limit > 0 && rule.Output.Limit < limit {
 		limit = rule.Output.Limit
@@ -193,8 +434,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 	// Apply offset if specified
 	offset := rule.Output.Offset
 	if offset > len(seqNums) {
-		log.Warn().
-			Str("rule", rule.Name).
+		logger.Warn().
 			Int("offset", offset).
 			Int("total_messages", len(seqNums)).
 			Msg("Offset exceeds total messages count, no messages will be fetched")
@@ -208,8 +448,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		endIdx = 0
 	}
 
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Int("offset", offset).
 		Int("limit", limit).
 		Int("start_idx", startIdx).
@@ -217,47 +456,166 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		Int("will_fetch", startIdx-endIdx+1).
 		Msg("Pagination parameters")
 
-	if startIdx >= 0 && startIdx < len(seqNums) {
-		for i := startIdx; i >= endIdx; i-- {
-			seqSet.AddNum(seqNums[i])
-		}
-	} else {
-		log.Warn().
-			Str("rule", rule.Name).
+	if startIdx < 0 || startIdx >= len(seqNums) {
+		logger.Warn().
 			Int("start_idx", startIdx).
 			Int("total_messages", len(seqNums)).
 			Msg("Invalid start index, no messages will be fetched")
-		return nil, nil
+		return nil
 	}
+	selected := make([]uint32, 0, startIdx-endIdx+1)
+	for i := startIdx; i >= endIdx; i-- {
+		selected = append(selected, seqNums[i])
+	}
+	return selected
+}
 
-	// 5. Build initial fetch options for metadata and structure
-	fetchOptionsStartTime := time.Now()
+// planFetch returns the items the first fetch of a batch requests: those the
+// output fields, client-side criteria and output modes need, and no more. The
+// MIME parts are fetched separately, once the body structure is known.
+func (rule *Rule) planFetch() (*imap.FetchOptions, error) {
 	fetchOptions, err := BuildFetchOptions(rule.Output)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build fetch options: %w", err)
 	}
-	log.Debug().
-		Str("rule", rule.Name).
-		Str("duration", time.Since(fetchOptionsStartTime).String()).
-		Interface("fetch_options", fetchOptions).
-		Msg("Built fetch options")
 
-	fetchOptions.BodySection = []*imap.FetchItemBodySection{}
+	// Actions and the per-message logs identify messages by UID
+	fetchOptions.UID = true
+
+	// The expression post-filter needs the envelope, flags and size regardless
+	// of the requested output fields
+	if rule.Search.Expr != "" {
+		fetchOptions.Envelope = true
+		fetchOptions.Flags = true
+		fetchOptions.RFC822Size = true
+	}
+	// Attachments and encryption are found through the extended body structure
+	if (rule.needsAttachments() || rule.needsEncryption()) && fetchOptions.BodyStructure == nil {
+		fetchOptions.BodyStructure = &imap.FetchItemBodyStructure{Extended: true}
+	}
+
+	// Large address lists and recipient criteria are matched against the
+	// envelope
+	if rule.Search.FromInFile != "" || rule.Search.FromNotInFile != "" || rule.Search.searchesRecipients() {
+		fetchOptions.Envelope = true
+	}
+
+	if rule.outputsListID() {
+		fetchOptions.BodySection = append(fetchOptions.BodySection, listIDSection)
+	}
+
+	// The default summary prompt shows the sender and subject
+	if rule.outputsSummary() {
+		fetchOptions.Envelope = true
+	}
+
+	// Classifiers look at the envelope and a few headers
+	if rule.classifies() {
+		fetchOptions.Envelope = true
+		fetchOptions.BodySection = append(fetchOptions.BodySection, classifySection)
+	}
+
+	// A flag summary counts the flags of the matched messages
+	if rule.Output.FlagSummary {
+		fetchOptions.Flags = true
+	}
+
+	// Thread grouping links messages through Message-ID, In-Reply-To and
+	// References
+	if rule.Output.GroupByThread {
+		fetchOptions.Envelope = true
+		fetchOptions.BodySection = append(fetchOptions.BodySection, referencesSection)
+	}
+
+	logger := rule.Logger()
+	logger.Debug().
+		Strs("items", fetchItemNames(fetchOptions)).
+		Bool("mime_parts", rule.Output.outputsMimeParts()).
+		Msg("Fetch plan")
+	return fetchOptions, nil
+}
+
+// fetchBatch fetches the metadata and required MIME parts of the messages in
+// seqSet and emits them as EmailMessages.
+func (rule *Rule) fetchBatch(client *imapclient.Client, seqSet imap.SeqSet, totalFound int, emit func(*EmailMessage) error) (err error) {
+	logger := rule.Logger()
+	nums, _ := seqSet.Nums()
+	span := rule.startSpan("imap.fetch",
+		AttrMailbox.String(selectedMailbox(client)),
+		AttrMessages.Int(len(nums)))
+	var transferred int
+	defer func() {
+		span.SetAttributes(AttrBytes.Int(transferred))
+		endSpan(span, err)
+	}()
+
+	// 5. Plan the first fetch: metadata and structure
+	fetchOptions, err := rule.planFetch()
+	if err != nil {
+		return err
+	}
 
 	// 6. First fetch: get metadata and structure
 	firstFetchStartTime := time.Now()
 	messages, err := client.Fetch(seqSet, fetchOptions).Collect()
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+		return fmt.Errorf("failed to fetch messages: %w", err)
 	}
-	log.Debug().
-		Str("rule", rule.Name).
+	for _, msg := range messages {
+		for _, section := range msg.BodySection {
+			transferred += len(section.Bytes)
+		}
+	}
+
+	// Detect the language from the full text, after decryption
+	if rule.needsLanguage() {
+		texts, err := fetchMessageTexts(client, messages)
+		if err != nil {
+			return err
+		}
+		emitMessage := emit
+		emit = func(msg *EmailMessage) error {
+			msg.Language = messageLanguage(msg, texts[msg.SeqNum])
+			return emitMessage(msg)
+		}
+	}
+
+	// Download attachments for the attachment criteria and output field
+	if rule.needsAttachments() {
+		attachments, err := fetchAttachments(client, messages, rule)
+		if err != nil {
+			return err
+		}
+		emitMessage := emit
+		emit = func(msg *EmailMessage) error {
+			msg.Attachments = attachments[msg.SeqNum]
+			return emitMessage(msg)
+		}
+	}
+
+	// Detect encrypted messages and decrypt them if asked to
+	if rule.needsEncryption() {
+		encryption := make(map[uint32]string, len(messages))
+		for _, msg := range messages {
+			encryption[msg.SeqNum] = bodyStructureEncryption(msg.BodyStructure)
+		}
+		emitMessage := emit
+		emit = func(msg *EmailMessage) error {
+			msg.Encrypted = encryption[msg.SeqNum]
+			if rule.Decrypt != nil && msg.Encrypted != EncryptionNone {
+				if err := rule.fetchAndDecrypt(client, msg); err != nil {
+					return err
+				}
+			}
+			return emitMessage(msg)
+		}
+	}
+	logger.Debug().
 		Str("duration", time.Since(firstFetchStartTime).String()).
 		Int("messages_fetched", len(messages)).
 		Msg("Completed first fetch (metadata and structure)")
 
 	// 7. Process messages in batches to reduce round trips
-	result := make([]*EmailMessage, 0, len(messages))
 
 	// First pass: determine all MIME parts we need to fetch
 	type MessageFetchInfo struct {
@@ -269,8 +627,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 	messagesToFetch := make([]MessageFetchInfo, 0, len(messages))
 
 	for msgIdx, msg := range messages {
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Int("msg_index", msgIdx).
 			Uint32("seq_num", msg.SeqNum).
 			Str("uid", fmt.Sprintf("%d", msg.UID)).
@@ -280,7 +637,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		bodyStructure := msg.BodyStructure
 		mimePartMetadata, err := determineRequiredBodySections(bodyStructure, rule.Output)
 		if err != nil {
-			return nil, fmt.Errorf("failed to determine required body sections: %w", err)
+			return fmt.Errorf("failed to determine required body sections: %w", err)
 		}
 
 		// Only add to fetch list if it has MIME parts to fetch
@@ -294,17 +651,18 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 			// If no MIME parts to fetch, process it immediately
 			email, err := NewEmailMessageFromIMAP(msg, nil)
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert message: %w", err)
+				return fmt.Errorf("failed to convert message: %w", err)
 			}
 			totalCount32, err := checkedUint32FromInt(totalFound, "total_found")
 			if err != nil {
-				return nil, err
+				return err
 			}
 			email.TotalCount = totalCount32
-			result = append(result, email)
+			if err := emit(email); err != nil {
+				return err
+			}
 
-			log.Debug().
-				Str("rule", rule.Name).
+			logger.Debug().
 				Int("msg_index", msgIdx).
 				Str("uid", fmt.Sprintf("%d", msg.UID)).
 				Msg("Processed message (no MIME parts)")
@@ -313,10 +671,9 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 
 	// Skip the batch fetch if no messages need MIME parts
 	if len(messagesToFetch) == 0 {
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Msg("No MIME parts needed for any message, skipping content fetch")
-		return result, nil
+		return nil
 	}
 
 	// Second pass: batch fetch MIME parts for all messages
@@ -346,16 +703,10 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		}
 	}
 
-	// Create batch fetch options
-	batchFetchOptions, err := BuildFetchOptions(rule.Output)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build batch fetch options: %w", err)
-	}
-	batchFetchOptions.BodyStructure = &imap.FetchItemBodyStructure{}
-	batchFetchOptions.BodySection = allFetchSections
+	// The metadata came with the first fetch, only the parts are requested
+	batchFetchOptions := &imap.FetchOptions{BodySection: allFetchSections}
 
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Int("messages_to_fetch", len(messagesToFetch)).
 		Int("total_sections", len(allFetchSections)).
 		Msg("Starting batch fetch for MIME parts")
@@ -383,8 +734,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 
 			if data, ok := item.(imapclient.FetchItemDataBodySection); ok {
 				if data.Literal == nil {
-					log.Warn().
-						Str("rule", rule.Name).
+					logger.Warn().
 						Uint32("seq_num", fetchedMsg.SeqNum).
 						Str("section", fmt.Sprintf("%v", data.Section)).
 						Msg("No literal found for body section")
@@ -394,23 +744,23 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 				// Read the body content
 				content, err := io.ReadAll(data.Literal)
 				if err != nil {
-					return nil, fmt.Errorf("failed to read body section: %w", err)
+					return fmt.Errorf("failed to read body section: %w", err)
 				}
 
 				// Create a key from the sequence number and section
 				sectionKey := fmt.Sprintf("%d:%v", fetchedMsg.SeqNum, data.Section.Part)
 				contentMap[sectionKey] = content
+				transferred += len(content)
 			}
 		}
 	}
 
 	err = batchFetchCmd.Close()
 	if err != nil {
-		return nil, fmt.Errorf("failed to close batch fetch command: %w", err)
+		return fmt.Errorf("failed to close batch fetch command: %w", err)
 	}
 
-	log.Debug().
-		Str("rule", rule.Name).
+	logger.Debug().
 		Int("sections_fetched", len(contentMap)).
 		Str("duration", time.Since(batchFetchStartTime).String()).
 		Msg("Completed batch fetch for MIME parts")
@@ -447,22 +797,23 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		// Get content for this message
 		msgContent, exists := messageContents[seqNum]
 		if !exists {
-			log.Warn().
-				Str("rule", rule.Name).
+			logger.Warn().
 				Uint32("seq_num", seqNum).
 				Msg("No content found for message in batch fetch results")
 
 			// Create a message without content
 			email, err := NewEmailMessageFromIMAP(msgInfo.Message, nil)
 			if err != nil {
-				return nil, fmt.Errorf("failed to convert message: %w", err)
+				return fmt.Errorf("failed to convert message: %w", err)
 			}
 			totalCount32, err := checkedUint32FromInt(totalFound, "total_found")
 			if err != nil {
-				return nil, err
+				return err
 			}
 			email.TotalCount = totalCount32
-			result = append(result, email)
+			if err := emit(email); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -473,8 +824,7 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 			content, exists := msgContent[pathKey]
 
 			if !exists {
-				log.Warn().
-					Str("rule", rule.Name).
+				logger.Warn().
 					Uint32("seq_num", seqNum).
 					Str("path", pathKey).
 					Msg("MIME part not found in fetch results")
@@ -483,12 +833,13 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 
 			size, err := checkedUint32FromInt(len(content), "mime_part_size")
 			if err != nil {
-				return nil, err
+				return err
 			}
 
 			mimePart := MimePart{
 				Type:     metadata.Type,
 				Subtype:  metadata.Subtype,
+				Encoding: metadata.Encoding,
 				Content:  string(content),
 				Size:     size,
 				Charset:  metadata.Params["charset"],
@@ -500,20 +851,21 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 		// Convert to our internal format
 		email, err := NewEmailMessageFromIMAP(msgInfo.Message, mimeParts)
 		if err != nil {
-			return nil, fmt.Errorf("failed to convert message: %w", err)
+			return fmt.Errorf("failed to convert message: %w", err)
 		}
 
 		// Set the total count field
 		totalCount32, err := checkedUint32FromInt(totalFound, "total_found")
 		if err != nil {
-			return nil, err
+			return err
 		}
 		email.TotalCount = totalCount32
 
-		result = append(result, email)
+		if err := emit(email); err != nil {
+			return err
+		}
 
-		log.Debug().
-			Str("rule", rule.Name).
+		logger.Debug().
 			Int("msg_index", msgInfo.Index).
 			Str("uid", fmt.Sprintf("%d", msgInfo.Message.UID)).
 			Int("mime_parts_processed", len(mimeParts)).
@@ -521,72 +873,194 @@ func (rule *Rule) FetchMessages(client *imapclient.Client) ([]*EmailMessage, err
 			Msg("Processed message with content")
 	}
 
-	log.Debug().
-		Str("rule", rule.Name).
-		Int("messages_processed", len(result)).
+	logger.Debug().
+		Int("messages_processed", len(messages)).
 		Str("duration", time.Since(processStartTime).String()).
 		Msg("Finished processing all messages")
 
-	log.Info().
-		Str("rule", rule.Name).
-		Int("total_messages_found", totalFound).
-		Int("messages_fetched", len(messages)).
-		Int("messages_processed", len(result)).
-		Str("duration", time.Since(startTime).String()).
-		Msg("Fetch messages operation complete")
+	return nil
+}
 
-	return result, nil
+// clientFilter returns the client-side part of the rule's search: the
+// search.expr predicate, attachment criteria, recipient criteria, language,
+// address lists too large for the server-side search and body_contains on
+// decrypted messages. Messages are classified before search.expr, which can test their
+// label. It returns nil if everything was handled by the server and the rule
+// does not classify.
+func (rule *Rule) clientFilter() (func(*EmailMessage) (bool, error), error) {
+	var expr *ExprFilter
+	if rule.Search.Expr != "" {
+		var err error
+		expr, err = CompileExpr(rule.Search.Expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addresses, err := addressListFilter(rule.Search)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := attachmentFilter(rule.Search)
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := rule.recipientFilter()
+	if err != nil {
+		return nil, err
+	}
+	decryptedBody := rule.decryptedBodyFilter()
+	language := rule.languageFilter()
+	label := rule.labeler()
+	if expr == nil && addresses == nil && attachments == nil && recipients == nil && decryptedBody == nil && language == nil && label == nil {
+		return nil, nil
+	}
+	return func(msg *EmailMessage) (bool, error) {
+		if addresses != nil && !addresses(msg) {
+			return false, nil
+		}
+		if recipients != nil && !recipients(msg) {
+			return false, nil
+		}
+		if decryptedBody != nil && !decryptedBody(msg) {
+			return false, nil
+		}
+		if language != nil && !language(msg) {
+			return false, nil
+		}
+		if attachments != nil {
+			ok, err := attachments(msg)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		if label != nil {
+			label(msg)
+		}
+		if expr != nil {
+			return expr.Match(msg)
+		}
+		return true, nil
+	}, nil
+}
+
+// filterEmitter wraps emit so that only messages passing the rule's
+// client-side filter, if any, are passed through.
+func (rule *Rule) filterEmitter(emit func(*EmailMessage) error) (func(*EmailMessage) error, error) {
+	logger := rule.Logger()
+	filter, err := rule.clientFilter()
+	if err != nil || filter == nil {
+		return emit, err
+	}
+	return func(msg *EmailMessage) error {
+		ok, err := filter(msg)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Debug().
+				Uint32("uid", msg.UID).
+				Msg("Message rejected by client-side filter")
+			return nil
+		}
+		return emit(msg)
+	}, nil
+}
+
+// applyClientFilter runs the rule's client-side filter, if any, over
+// messages. It runs before pagination, so that output.limit counts the
+// messages passing it.
+func (rule *Rule) applyClientFilter(messages []*EmailMessage) ([]*EmailMessage, error) {
+	logger := rule.Logger()
+	filter, err := rule.clientFilter()
+	if err != nil || filter == nil {
+		return messages, err
+	}
+	filtered := make([]*EmailMessage, 0, len(messages))
+	for _, msg := range messages {
+		ok, err := filter(msg)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filtered = append(filtered, msg)
+		}
+	}
+	logger.Debug().
+		Int("messages_before", len(messages)).
+		Int("messages_after", len(filtered)).
+		Msg("Applied client-side filter")
+	return filtered, nil
 }
 
 // ProcessRule executes an IMAP rule
 func ProcessRule(client *imapclient.Client, rule *Rule) error {
+	logger := rule.Logger()
 	startTime := time.Now()
-	log.Info().
-		Str("rule", rule.Name).
+	logger.Info().
 		Msg("Processing rule")
 
-	// 1. Fetch messages
-	messages, err := rule.FetchMessages(client)
+	// 1. Fetch messages. NDJSON output is streamed while messages are fetched,
+	// unless messages have to be grouped into threads or summarized first.
+	streaming := rule.Output.Format == "ndjson" && !rule.Output.GroupByThread && !rule.Output.FlagSummary
+	var messages []*EmailMessage
+	var threads []*Thread
+	var err error
+	switch {
+	case streaming:
+		err = rule.StreamMessages(client, func(msg *EmailMessage) error {
+			messages = append(messages, msg)
+			return WriteNDJSON(os.Stdout, msg, rule.Output)
+		})
+	case rule.Output.GroupByThread:
+		threads, err = rule.FetchThreads(client)
+		for _, thread := range threads {
+			messages = append(messages, thread.Messages...)
+		}
+	default:
+		messages, err = rule.FetchMessages(client)
+	}
 	if err != nil {
 		return err
 	}
 
 	if len(messages) == 0 {
-		log.Warn().
-			Str("rule", rule.Name).
+		logger.Warn().
 			Msg("No messages found matching the criteria")
 		return nil
 	}
 
 	// 2. Output messages
-	outputStartTime := time.Now()
-	err = OutputMessages(messages, rule.Output)
-	if err != nil {
-		return fmt.Errorf("failed to output messages: %w", err)
-	}
+	if !streaming {
+		outputStartTime := time.Now()
+		if threads != nil {
+			err = OutputThreads(threads, rule.Output)
+		} else {
+			err = rule.OutputMessages(messages)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to output messages: %w", err)
+		}
 
-	log.Info().
-		Str("rule", rule.Name).
-		Int("messages_output", len(messages)).
-		Str("output_duration", time.Since(outputStartTime).String()).
-		Msg("Messages output complete")
+		logger.Info().
+			Int("messages_output", len(messages)).
+			Str("output_duration", time.Since(outputStartTime).String()).
+			Msg("Messages output complete")
+	}
 
 	// 3. Execute actions if specified
 	if !reflect.DeepEqual(rule.Actions, ActionConfig{}) {
 		actionsStartTime := time.Now()
-		err = ExecuteActions(client, messages, &rule.Actions)
+		err = rule.ExecuteActions(client, messages)
 		if err != nil {
 			return fmt.Errorf("failed to execute actions: %w", err)
 		}
 
-		log.Info().
-			Str("rule", rule.Name).
+		logger.Info().
 			Str("actions_duration", time.Since(actionsStartTime).String()).
 			Msg("Actions executed successfully")
 	}
 
-	log.Info().
-		Str("rule", rule.Name).
+	logger.Info().
 		Int("messages_processed", len(messages)).
 		Str("total_duration", time.Since(startTime).String()).
 		Msg("Rule processing complete")
@@ -0,0 +1,78 @@
+package dsl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultProtectedMailboxes are the mailboxes rules may not remove messages
+// from unless they allow it with allow_protected.
+var DefaultProtectedMailboxes = []string{"Sent", "Drafts"}
+
+// removingActions returns the names of the actions that remove messages from
+// the mailbox the rule runs on.
+func (a *ActionConfig) removingActions() []string {
+	var ret []string
+	if a.Delete != nil && a.Delete != false {
+		ret = append(ret, "delete")
+	}
+	if a.MoveTo != "" {
+		ret = append(ret, "move_to")
+	}
+	if a.Snooze != nil {
+		ret = append(ret, "snooze")
+	}
+	for _, label := range sortedLabels(a.ByLabel) {
+		for _, action := range a.ByLabel[label].removingActions() {
+			ret = append(ret, "by_label."+label+"."+action)
+		}
+	}
+	return ret
+}
+
+// RemovesMessages reports whether the rule deletes, moves or snoozes
+// messages, the actions that --lock serializes.
+func (r *Rule) RemovesMessages() bool {
+	return len(r.Actions.removingActions()) > 0
+}
+
+// matchMailbox reports whether mailbox matches one of the case-insensitive
+// glob patterns, returning the pattern.
+func matchMailbox(patterns []string, mailbox string) (string, bool) {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(mailbox)); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// ValidateMailboxPatterns checks that patterns are valid globs.
+func ValidateMailboxPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid mailbox pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// CheckProtected returns an error when the rule would delete, move or snooze
+// messages out of mailbox while it matches one of protected or the rule's
+// protected_mailboxes, unless the rule's allow_protected matches it too.
+func (r *Rule) CheckProtected(mailbox string, protected []string) error {
+	actions := r.Actions.removingActions()
+	if len(actions) == 0 {
+		return nil
+	}
+	pattern, ok := matchMailbox(append(append([]string{}, protected...), r.ProtectedMailboxes...), mailbox)
+	if !ok {
+		return nil
+	}
+	if _, allowed := matchMailbox(r.AllowProtected, mailbox); allowed {
+		return nil
+	}
+	return fmt.Errorf("rule %s would %s messages in protected mailbox %s (matches %q), add it to allow_protected to proceed",
+		r.Name, strings.Join(actions, "/"), mailbox, pattern)
+}
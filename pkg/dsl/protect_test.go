@@ -0,0 +1,52 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckProtected(t *testing.T) {
+	rule := &Rule{Name: "cleanup", Actions: ActionConfig{Delete: true}}
+	err := rule.CheckProtected("sent", DefaultProtectedMailboxes)
+	assert.ErrorContains(t, err, `rule cleanup would delete messages in protected mailbox sent (matches "Sent")`)
+	assert.NoError(t, rule.CheckProtected("INBOX", DefaultProtectedMailboxes))
+
+	// Actions that leave the messages in place are fine
+	rule = &Rule{Name: "tag", Actions: ActionConfig{Flags: &FlagActions{Add: []string{"seen"}}, CopyTo: "Archive"}}
+	assert.NoError(t, rule.CheckProtected("Sent", DefaultProtectedMailboxes))
+	rule = &Rule{Name: "keep", Actions: ActionConfig{Delete: false}}
+	assert.NoError(t, rule.CheckProtected("Sent", DefaultProtectedMailboxes))
+
+	rule = &Rule{Name: "archive", Actions: ActionConfig{MoveTo: "Archive"}, ProtectedMailboxes: []string{"Receipts/*"}}
+	assert.ErrorContains(t, rule.CheckProtected("Receipts/2025", nil), "would move_to messages")
+
+	rule.AllowProtected = []string{"receipts/*"}
+	assert.NoError(t, rule.CheckProtected("Receipts/2025", nil))
+}
+
+func TestRuleProtectedMailboxesParse(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: purge-drafts
+allow_protected: [Drafts]
+search:
+  before: "2024-01-01"
+output:
+  fields: [uid]
+actions:
+  delete: true
+`)
+	require.NoError(t, err)
+	assert.NoError(t, rule.CheckProtected("Drafts", DefaultProtectedMailboxes))
+
+	_, err = ParseRuleString(`
+name: broken
+protected_mailboxes: ["[Sent"]
+search:
+  all: true
+output:
+  fields: [uid]
+`)
+	assert.ErrorContains(t, err, "invalid protected_mailboxes")
+}
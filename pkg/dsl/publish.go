@@ -0,0 +1,593 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Brokers built into the publish action.
+const (
+	BrokerKafka = "kafka"
+	BrokerNATS  = "nats"
+)
+
+// Serializations of published events.
+const (
+	SerializationJSON = "json"
+	SerializationAvro = "avro"
+)
+
+const defaultPublishTimeout = 30 * time.Second
+
+// MessageEventAvroSchema is the Avro schema of MessageEvent, used by the avro
+// serialization.
+const MessageEventAvroSchema = `{"type":"record","name":"MessageEvent","namespace":"smailnail",` +
+	`"fields":[` +
+	`{"name":"rule","type":"string"},` +
+	`{"name":"mailbox","type":"string"},` +
+	`{"name":"uid","type":"long"},` +
+	`{"name":"message_id","type":"string"},` +
+	`{"name":"subject","type":"string"},` +
+	`{"name":"from","type":"string"},` +
+	`{"name":"to","type":{"type":"array","items":"string"}},` +
+	`{"name":"date","type":"string"},` +
+	`{"name":"flags","type":{"type":"array","items":"string"}},` +
+	`{"name":"size","type":"long"},` +
+	`{"name":"label","type":"string"},` +
+	`{"name":"published_at","type":"string"}]}`
+
+// PublishConfig publishes a structured event per matched message to a
+// streaming broker. Topic and key are templates rendered against each
+// message (see TemplateContext); most event fields need the envelope field.
+type PublishConfig struct {
+	// Broker is kafka, nats or a broker registered with RegisterPublisher
+	Broker string `yaml:"broker"`
+	// URL is the Kafka REST Proxy (http or https) or the NATS server
+	// (nats:// or tls://, with optional user:password@ or token@)
+	URL string `yaml:"url"`
+	// Topic is the Kafka topic or NATS subject
+	Topic string `yaml:"topic"`
+	// Key is the Kafka record key or the NATS Nats-Msg-Id header, which
+	// JetStream deduplicates on
+	Key string `yaml:"key,omitempty"`
+	// Serialization is json (default) or avro, with MessageEventAvroSchema
+	Serialization string `yaml:"serialization,omitempty"`
+	// Timeout bounds each publish (default: 30s)
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// MessageEvent is the event published for a message.
+type MessageEvent struct {
+	Rule        string   `json:"rule"`
+	Mailbox     string   `json:"mailbox"`
+	UID         uint32   `json:"uid"`
+	MessageID   string   `json:"message_id"`
+	Subject     string   `json:"subject"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	Date        string   `json:"date"`
+	Flags       []string `json:"flags"`
+	Size        uint32   `json:"size"`
+	Label       string   `json:"label"`
+	PublishedAt string   `json:"published_at"`
+}
+
+// PublishRecord is an event with its rendered key.
+type PublishRecord struct {
+	Key   string
+	Event *MessageEvent
+}
+
+// Publisher sends events to a broker. Publish sends all records to topic
+// and returns once the broker accepted them.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, records []PublishRecord) error
+	Close() error
+}
+
+// PublisherFactory creates the Publisher of a publish action.
+type PublisherFactory func(config *PublishConfig) (Publisher, error)
+
+var (
+	publishersMu sync.RWMutex
+	publishers   = map[string]PublisherFactory{
+		BrokerKafka: newKafkaRESTPublisher,
+		BrokerNATS:  newNATSPublisher,
+	}
+)
+
+// RegisterPublisher adds a broker to the publish action, e.g. a native
+// Kafka client in programs that embed smailnail.
+func RegisterPublisher(broker string, factory PublisherFactory) error {
+	if broker == "" {
+		return fmt.Errorf("broker name is required")
+	}
+	if factory == nil {
+		return fmt.Errorf("publisher factory for %s is nil", broker)
+	}
+	publishersMu.Lock()
+	defer publishersMu.Unlock()
+	if _, ok := publishers[broker]; ok {
+		return fmt.Errorf("broker %s is already registered", broker)
+	}
+	publishers[broker] = factory
+	return nil
+}
+
+func lookupPublisher(broker string) (PublisherFactory, bool) {
+	publishersMu.RLock()
+	defer publishersMu.RUnlock()
+	factory, ok := publishers[broker]
+	return factory, ok
+}
+
+// Validate checks if the publish config is valid
+func (c *PublishConfig) Validate() error {
+	if _, ok := lookupPublisher(c.Broker); !ok {
+		return fmt.Errorf("unknown broker %q", c.Broker)
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid url %q", c.URL)
+	}
+	switch c.Broker {
+	case BrokerKafka:
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("kafka url must be the http or https URL of a Kafka REST Proxy, got %q", c.URL)
+		}
+	case BrokerNATS:
+		if u.Scheme != "nats" && u.Scheme != "tls" {
+			return fmt.Errorf("nats url must use the nats:// or tls:// scheme, got %q", c.URL)
+		}
+	}
+	for _, text := range []string{c.Topic, c.Key} {
+		if err := ValidateTemplate(text); err != nil {
+			return err
+		}
+	}
+	switch c.Serialization {
+	case "", SerializationJSON, SerializationAvro:
+	default:
+		return fmt.Errorf("invalid serialization: %s (must be 'json' or 'avro')", c.Serialization)
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func (c *PublishConfig) serialization() string {
+	if c.Serialization != "" {
+		return c.Serialization
+	}
+	return SerializationJSON
+}
+
+func (c *PublishConfig) timeout() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && c.Timeout != "" {
+		return d
+	}
+	return defaultPublishTimeout
+}
+
+// newMessageEvent builds the event of msg.
+func newMessageEvent(msg *EmailMessage, mailbox string, rule *Rule, now time.Time) *MessageEvent {
+	event := &MessageEvent{
+		Mailbox:     mailbox,
+		UID:         msg.UID,
+		Flags:       msg.Flags,
+		Size:        msg.Size,
+		Label:       msg.Label,
+		To:          []string{},
+		PublishedAt: now.UTC().Format(time.RFC3339),
+	}
+	if event.Flags == nil {
+		event.Flags = []string{}
+	}
+	if rule != nil {
+		event.Rule = rule.Name
+	}
+	if env := msg.Envelope; env != nil {
+		event.MessageID = env.MessageID
+		event.Subject = env.Subject
+		if len(env.From) > 0 {
+			event.From = env.From[0].Address
+		}
+		for _, addr := range env.To {
+			event.To = append(event.To, addr.Address)
+		}
+		if !env.Date.IsZero() {
+			event.Date = env.Date.UTC().Format(time.RFC3339)
+		}
+	}
+	return event
+}
+
+// executePublish publishes an event per message, one batch per topic.
+func executePublish(client *imapclient.Client, messages []*EmailMessage, config *PublishConfig, rule *Rule) error {
+	logger := rule.Logger()
+	if len(messages) == 0 {
+		return nil
+	}
+	factory, ok := lookupPublisher(config.Broker)
+	if !ok {
+		return fmt.Errorf("unknown broker %q", config.Broker)
+	}
+
+	mailbox := ""
+	if client != nil {
+		mailbox = selectedMailbox(client)
+	}
+	now := time.Now()
+	topics := map[string][]PublishRecord{}
+	uids := map[string][]uint32{}
+	for _, msg := range messages {
+		data := NewTemplateContext(msg, rule)
+		topic, err := RenderTemplate(config.Topic, data)
+		if err != nil {
+			return fmt.Errorf("failed to render topic: %w", err)
+		}
+		key, err := RenderTemplate(config.Key, data)
+		if err != nil {
+			return fmt.Errorf("failed to render key: %w", err)
+		}
+		topic = strings.TrimSpace(topic)
+		topics[topic] = append(topics[topic], PublishRecord{Key: key, Event: newMessageEvent(msg, mailbox, rule, now)})
+		uids[topic] = append(uids[topic], msg.UID)
+	}
+
+	publisher, err := factory(config)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = publisher.Close() }()
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+
+	partial := &ActionPartialFailureError{Action: "publish"}
+	for _, topic := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), config.timeout())
+		err := publisher.Publish(ctx, topic, topics[topic])
+		cancel()
+		if err != nil {
+			logger.Warn().Err(err).Str("topic", topic).Msg("Failed to publish events")
+			for _, uid := range uids[topic] {
+				partial.Failed = append(partial.Failed, UIDError{UID: uid, Err: fmt.Errorf("%s: %w", topic, err)})
+			}
+			continue
+		}
+		partial.Succeeded = append(partial.Succeeded, uids[topic]...)
+		logger.Debug().Str("broker", config.Broker).Str("topic", topic).Int("events", len(topics[topic])).Msg("Published events")
+	}
+	if len(partial.Failed) > 0 {
+		return partial
+	}
+	return nil
+}
+
+// encodeMessageEvent serializes event as JSON or as an Avro binary datum of
+// MessageEventAvroSchema.
+func encodeMessageEvent(event *MessageEvent, serialization string) ([]byte, error) {
+	if serialization != SerializationAvro {
+		return json.Marshal(event)
+	}
+	var b []byte
+	for _, s := range []string{event.Rule, event.Mailbox} {
+		b = avroString(b, s)
+	}
+	b = binary.AppendVarint(b, int64(event.UID))
+	for _, s := range []string{event.MessageID, event.Subject, event.From} {
+		b = avroString(b, s)
+	}
+	b = avroStrings(b, event.To)
+	b = avroString(b, event.Date)
+	b = avroStrings(b, event.Flags)
+	b = binary.AppendVarint(b, int64(event.Size))
+	b = avroString(b, event.Label)
+	b = avroString(b, event.PublishedAt)
+	return b, nil
+}
+
+// Avro longs are zig-zag varints, which binary.AppendVarint writes.
+func avroString(b []byte, s string) []byte {
+	b = binary.AppendVarint(b, int64(len(s)))
+	return append(b, s...)
+}
+
+func avroStrings(b []byte, values []string) []byte {
+	if len(values) > 0 {
+		b = binary.AppendVarint(b, int64(len(values)))
+		for _, s := range values {
+			b = avroString(b, s)
+		}
+	}
+	return binary.AppendVarint(b, 0)
+}
+
+// kafkaRESTPublisher produces to Kafka through a Kafka REST Proxy (v2 API),
+// which encodes avro records with the schema sent along.
+type kafkaRESTPublisher struct {
+	url           *url.URL
+	serialization string
+	client        *http.Client
+}
+
+func newKafkaRESTPublisher(config *PublishConfig) (Publisher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %w", config.URL, err)
+	}
+	return &kafkaRESTPublisher{
+		url:           u,
+		serialization: config.serialization(),
+		client:        &http.Client{},
+	}, nil
+}
+
+type kafkaRESTRecord struct {
+	Key   *string       `json:"key,omitempty"`
+	Value *MessageEvent `json:"value"`
+}
+
+type kafkaRESTRequest struct {
+	KeySchema   string            `json:"key_schema,omitempty"`
+	ValueSchema string            `json:"value_schema,omitempty"`
+	Records     []kafkaRESTRecord `json:"records"`
+}
+
+type kafkaRESTResponse struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+func (k *kafkaRESTPublisher) Publish(ctx context.Context, topic string, records []PublishRecord) error {
+	request := kafkaRESTRequest{}
+	contentType := "application/vnd.kafka.json.v2+json"
+	if k.serialization == SerializationAvro {
+		contentType = "application/vnd.kafka.avro.v2+json"
+		request.ValueSchema = MessageEventAvroSchema
+	}
+	for _, record := range records {
+		r := kafkaRESTRecord{Value: record.Event}
+		if record.Key != "" {
+			key := record.Key
+			r.Key = &key
+			if k.serialization == SerializationAvro {
+				request.KeySchema = `"string"`
+			}
+		}
+		request.Records = append(request.Records, r)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal records: %w", err)
+	}
+
+	endpoint := k.url.JoinPath("topics", topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create produce request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.url.User != nil {
+		password, _ := k.url.User.Password()
+		req.SetBasicAuth(k.url.User.Username(), password)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Kafka REST Proxy: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read produce response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the Kafka REST Proxy returned %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	var result kafkaRESTResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("failed to parse produce response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("produce failed with error code %d: %s", *offset.ErrorCode, offset.Error)
+		}
+	}
+	return nil
+}
+
+func (k *kafkaRESTPublisher) Close() error { return nil }
+
+// natsPublisher speaks the NATS client protocol. Keys are sent as the
+// Nats-Msg-Id header, which needs a server with headers support (2.2+).
+type natsPublisher struct {
+	url           *url.URL
+	serialization string
+	timeout       time.Duration
+	conn          net.Conn
+	reader        *bufio.Reader
+	headers       bool
+}
+
+type natsInfo struct {
+	Headers     bool `json:"headers"`
+	TLSRequired bool `json:"tls_required"`
+}
+
+func newNATSPublisher(config *PublishConfig) (Publisher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q: %w", config.URL, err)
+	}
+	return &natsPublisher{url: u, serialization: config.serialization(), timeout: config.timeout()}, nil
+}
+
+// connect dials the server and authenticates, upgrading to TLS when the
+// URL uses tls:// or the server requires it.
+func (n *natsPublisher) connect(ctx context.Context) error {
+	host := n.url.Host
+	if n.url.Port() == "" {
+		host = net.JoinHostPort(n.url.Hostname(), "4222")
+	}
+	dialer := &net.Dialer{Timeout: n.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to read NATS server info: %w", err)
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info) != nil {
+		_ = conn.Close()
+		return fmt.Errorf("unexpected NATS greeting: %s", strings.TrimSpace(line))
+	}
+	if n.url.Scheme == "tls" || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: n.url.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return fmt.Errorf("NATS TLS handshake failed: %w", err)
+		}
+		conn, reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "smailnail",
+		"lang":     "go",
+		"protocol": 1,
+		"headers":  info.Headers,
+	}
+	if user := n.url.User; user != nil {
+		if password, ok := user.Password(); ok {
+			options["user"], options["pass"] = user.Username(), password
+		} else {
+			options["auth_token"] = user.Username()
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to send NATS CONNECT: %w", err)
+	}
+	n.conn, n.reader, n.headers = conn, reader, info.Headers
+	return nil
+}
+
+func (n *natsPublisher) Publish(ctx context.Context, subject string, records []PublishRecord) error {
+	if err := checkNATSSubject(subject); err != nil {
+		return err
+	}
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = n.conn.SetDeadline(deadline)
+	}
+	w := bufio.NewWriter(n.conn)
+	for _, record := range records {
+		payload, err := encodeMessageEvent(record.Event, n.serialization)
+		if err != nil {
+			return fmt.Errorf("failed to encode event of message %d: %w", record.Event.UID, err)
+		}
+		if record.Key == "" {
+			_, _ = fmt.Fprintf(w, "PUB %s %d\r\n", subject, len(payload))
+		} else {
+			if !n.headers {
+				return fmt.Errorf("the NATS server does not support headers, which keys need")
+			}
+			header := "NATS/1.0\r\nNats-Msg-Id: " + strings.NewReplacer("\r", "", "\n", "").Replace(record.Key) + "\r\n\r\n"
+			_, _ = fmt.Fprintf(w, "HPUB %s %d %d\r\n%s", subject, len(header), len(header)+len(payload), header)
+		}
+		_, _ = w.Write(payload)
+		_, _ = w.WriteString("\r\n")
+	}
+	// The PONG answering this PING confirms the server processed the
+	// messages; errors like permission violations arrive before it
+	_, _ = w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read NATS response: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := io.WriteString(n.conn, "PONG\r\n"); err != nil {
+				return fmt.Errorf("failed to answer NATS PING: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// checkNATSSubject refuses subjects the protocol cannot carry. Whitespace
+// would end the subject early and a line break would start another command.
+func checkNATSSubject(subject string) error {
+	if subject == "" {
+		return fmt.Errorf("empty NATS subject")
+	}
+	for _, r := range subject {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("invalid NATS subject %q: whitespace and control characters are not allowed", subject)
+		}
+	}
+	return nil
+}
+
+func (n *natsPublisher) Close() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn = nil
+	return err
+}
@@ -0,0 +1,177 @@
+package dsl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingPublisher struct {
+	topics map[string][]PublishRecord
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, topic string, records []PublishRecord) error {
+	p.topics[topic] = append(p.topics[topic], records...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func TestPublishAction(t *testing.T) {
+	publisher := &recordingPublisher{topics: map[string][]PublishRecord{}}
+	require.NoError(t, RegisterPublisher("test-broker", func(*PublishConfig) (Publisher, error) {
+		return publisher, nil
+	}))
+
+	rule, err := ParseRuleString(`
+name: invoices
+search:
+  subject_contains: invoice
+output:
+  fields: [uid, envelope]
+actions:
+  publish:
+    broker: test-broker
+    url: http://localhost:1
+    topic: "mail.{{ .From.Address | splitList \"@\" | last }}"
+    key: "{{ .Message.Envelope.MessageID }}"
+`)
+	require.NoError(t, err)
+	messages := []*EmailMessage{
+		{UID: 1, Size: 10, Envelope: &EmailEnvelope{Subject: "Invoice 1", MessageID: "a@acme.com", From: []EmailAddress{{Address: "billing@acme.com"}}}},
+		{UID: 2, Envelope: &EmailEnvelope{Subject: "Invoice 2", MessageID: "b@corp.com", From: []EmailAddress{{Address: "ap@corp.com"}}}},
+	}
+	require.NoError(t, executePublish(nil, messages, rule.Actions.Publish, rule))
+
+	require.Len(t, publisher.topics["mail.acme.com"], 1)
+	record := publisher.topics["mail.acme.com"][0]
+	assert.Equal(t, "a@acme.com", record.Key)
+	assert.Equal(t, "invoices", record.Event.Rule)
+	assert.Equal(t, "Invoice 1", record.Event.Subject)
+	assert.Equal(t, "billing@acme.com", record.Event.From)
+	assert.Len(t, publisher.topics["mail.corp.com"], 1)
+}
+
+func TestPublishConfigValidate(t *testing.T) {
+	for _, config := range []PublishConfig{
+		{Broker: "carrier-pigeon", URL: "http://localhost", Topic: "mail"},
+		{Broker: BrokerKafka, URL: "http://localhost"},
+		{Broker: BrokerKafka, URL: "kafka://localhost:9092", Topic: "mail"},
+		{Broker: BrokerNATS, URL: "http://localhost:4222", Topic: "mail"},
+		{Broker: BrokerNATS, URL: "nats://localhost", Topic: "mail", Serialization: "protobuf"},
+	} {
+		assert.Error(t, config.Validate(), "%+v", config)
+	}
+	assert.NoError(t, (&PublishConfig{Broker: BrokerNATS, URL: "nats://token@localhost", Topic: "mail", Serialization: SerializationAvro}).Validate())
+}
+
+func TestEncodeMessageEventAvro(t *testing.T) {
+	event := &MessageEvent{Rule: "r", UID: 300, To: []string{"a"}, Size: 1}
+	b, err := encodeMessageEvent(event, SerializationAvro)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		2, 'r', // rule
+		0,          // mailbox
+		0xd8, 0x04, // uid 300
+		0, 0, 0, // message_id, subject, from
+		2, 2, 'a', 0, // to: one block of one item, then the end
+		0,    // date
+		0,    // flags: empty array
+		2,    // size 1
+		0, 0, // label, published_at
+	}, b)
+}
+
+func TestKafkaRESTPublisher(t *testing.T) {
+	var request kafkaRESTRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/mail", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.avro.v2+json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = w.Write([]byte(`{"offsets":[{"partition":0,"offset":1,"error_code":null,"error":null}]}`))
+	}))
+	defer server.Close()
+
+	publisher, err := newKafkaRESTPublisher(&PublishConfig{URL: server.URL, Serialization: SerializationAvro})
+	require.NoError(t, err)
+	require.NoError(t, publisher.Publish(context.Background(), "mail", []PublishRecord{
+		{Key: "k", Event: &MessageEvent{UID: 1, Subject: "Hello"}},
+	}))
+	assert.Equal(t, MessageEventAvroSchema, request.ValueSchema)
+	assert.Equal(t, `"string"`, request.KeySchema)
+	require.Len(t, request.Records, 1)
+	assert.Equal(t, "Hello", request.Records[0].Value.Subject)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = listener.Close() }()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = io.WriteString(conn, "INFO {\"headers\":true}\r\n")
+		reader := bufio.NewReader(conn)
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			var subject string
+			var size int
+			if n, _ := fmt.Sscanf(line, "PUB %s %d", &subject, &size); n == 2 {
+				payload := make([]byte, size+2)
+				_, _ = io.ReadFull(reader, payload)
+				lines = append(lines, string(payload[:size]))
+			}
+			if line == "PING" {
+				_, _ = io.WriteString(conn, "PONG\r\n")
+				received <- lines
+				return
+			}
+		}
+	}()
+
+	publisher, err := newNATSPublisher(&PublishConfig{URL: "nats://secret@" + listener.Addr().String(), Timeout: "5s"})
+	require.NoError(t, err)
+	defer func() { _ = publisher.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, publisher.Publish(ctx, "mail.new", []PublishRecord{{Event: &MessageEvent{UID: 7}}}))
+
+	lines := <-received
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], `"auth_token":"secret"`)
+	assert.Equal(t, "PUB mail.new "+fmt.Sprint(len(lines[2])), lines[1])
+	assert.Contains(t, lines[2], `"uid":7`)
+}
+
+func TestNATSPublisherRejectsSubjects(t *testing.T) {
+	// Nothing listens there: the subject is refused before connecting
+	publisher, err := newNATSPublisher(&PublishConfig{URL: "nats://127.0.0.1:1", Timeout: "1s"})
+	require.NoError(t, err)
+	records := []PublishRecord{{Event: &MessageEvent{UID: 7}}}
+	for _, subject := range []string{"", "mail new", "mail.new\r\nPUB evil 1", "mail\tnew", "mail.\x00"} {
+		err := publisher.Publish(context.Background(), subject, records)
+		assert.ErrorContains(t, err, "NATS subject", "%q", subject)
+	}
+}
@@ -0,0 +1,326 @@
+package dsl
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// queryTerm is a term of a query string: key:value, or a bare word searched
+// as text, negated with a leading "-".
+type queryTerm struct {
+	negate bool
+	key    string
+	value  string
+}
+
+// queryKey compiles the terms of one query key into search criteria.
+type queryKey struct {
+	// set applies the term to s, returning false if s already has the
+	// criterion, in which case the term becomes a condition of its own
+	set func(s *SearchConfig, value string, negate bool) (bool, error)
+	// negatable keys handle negation in set (-flag:seen is flags.not_has);
+	// other negated terms become a not condition
+	negatable bool
+}
+
+var relativeDateRe = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// setString returns a queryKey setting the string field of SearchConfig
+// field returns.
+func setString(field func(s *SearchConfig) *string) queryKey {
+	return queryKey{set: func(s *SearchConfig, value string, _ bool) (bool, error) {
+		target := field(s)
+		if *target != "" {
+			return false, nil
+		}
+		*target = value
+		return true, nil
+	}}
+}
+
+// setBool returns a queryKey setting the flag shorthand field returns to
+// value, or its opposite when negated.
+func setBool(field func(s *SearchConfig) **bool, value bool) func(s *SearchConfig, negate bool) bool {
+	return func(s *SearchConfig, negate bool) bool {
+		target := field(s)
+		if *target != nil {
+			return false
+		}
+		v := value != negate
+		*target = &v
+		return true
+	}
+}
+
+var queryIsValues = map[string]func(s *SearchConfig, negate bool) bool{
+	"unread":   setBool(func(s *SearchConfig) **bool { return &s.Unread }, true),
+	"read":     setBool(func(s *SearchConfig) **bool { return &s.Unread }, false),
+	"flagged":  setBool(func(s *SearchConfig) **bool { return &s.Flagged }, true),
+	"answered": setBool(func(s *SearchConfig) **bool { return &s.Answered }, true),
+	"list":     setBool(func(s *SearchConfig) **bool { return &s.IsMailingList }, true),
+}
+
+var queryKeys = map[string]queryKey{
+	"from":    setString(func(s *SearchConfig) *string { return &s.From }),
+	"to":      setString(func(s *SearchConfig) *string { return &s.To }),
+	"cc":      setString(func(s *SearchConfig) *string { return &s.Cc }),
+	"bcc":     setString(func(s *SearchConfig) *string { return &s.Bcc }),
+	"subject": setString(func(s *SearchConfig) *string { return &s.SubjectContains }),
+	"body":    setString(func(s *SearchConfig) *string { return &s.BodyContains }),
+	"text":    setString(func(s *SearchConfig) *string { return &s.Text }),
+	"list":    setString(func(s *SearchConfig) *string { return &s.ListID }),
+	"on":      setString(func(s *SearchConfig) *string { return &s.On }),
+	"flag": {
+		negatable: true,
+		set: func(s *SearchConfig, value string, negate bool) (bool, error) {
+			if s.Flags == nil {
+				s.Flags = &FlagCriteria{}
+			}
+			if negate {
+				s.Flags.NotHas = append(s.Flags.NotHas, value)
+			} else {
+				s.Flags.Has = append(s.Flags.Has, value)
+			}
+			return true, nil
+		},
+	},
+	"is": {
+		negatable: true,
+		set: func(s *SearchConfig, value string, negate bool) (bool, error) {
+			set, ok := queryIsValues[strings.ToLower(value)]
+			if !ok {
+				return false, fmt.Errorf("unknown value %q for is: (must be unread, read, flagged, answered or list)", value)
+			}
+			return set(s, negate), nil
+		},
+	},
+	"since": {set: func(s *SearchConfig, value string, _ bool) (bool, error) {
+		if s.Since != "" || s.WithinDays != 0 {
+			return false, nil
+		}
+		if days, ok := relativeDays(value); ok {
+			s.WithinDays = days
+			return true, nil
+		}
+		s.Since = value
+		return true, nil
+	}},
+	"before": {set: func(s *SearchConfig, value string, _ bool) (bool, error) {
+		if s.Before != "" {
+			return false, nil
+		}
+		if days, ok := relativeDays(value); ok {
+			// Relative dates are resolved when the query is parsed
+			value = time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+		}
+		s.Before = value
+		return true, nil
+	}},
+	"size": {set: func(s *SearchConfig, value string, _ bool) (bool, error) {
+		if s.Size == nil {
+			s.Size = &SizeCriteria{}
+		}
+		target := &s.Size.LargerThan
+		switch {
+		case strings.HasPrefix(value, ">"):
+			value = value[1:]
+		case strings.HasPrefix(value, "<"):
+			value = value[1:]
+			target = &s.Size.SmallerThan
+		}
+		if *target != "" {
+			return false, nil
+		}
+		*target = normalizeQuerySize(value)
+		return true, nil
+	}},
+	"header": {
+		negatable: true,
+		set: func(s *SearchConfig, value string, negate bool) (bool, error) {
+			name, headerValue, hasValue := strings.Cut(value, ":")
+			if negate {
+				if hasValue {
+					return false, fmt.Errorf("-header: takes a header name without value, got %q", value)
+				}
+				s.HeaderMissing = append(s.HeaderMissing, name)
+				return true, nil
+			}
+			if s.Header != nil {
+				return false, nil
+			}
+			s.Header = &HeaderCriteria{Name: name, Value: headerValue}
+			return true, nil
+		},
+	},
+}
+
+// ParseQuery compiles a query string into search criteria, for instance
+//
+//	from:boss@example.com subject:"quarterly report" -flag:seen since:7d size:>5M
+//
+// Terms are key:value pairs or bare words, which search the text of the
+// message, and all of them must match. A leading "-" negates a term. Values
+// with spaces are quoted. The keys are from, to, cc, bcc, subject, body,
+// text, list (the List-Id), header (header:X-Spam:yes), flag, is (unread,
+// read, flagged, answered, list), since and before (a date or a number of
+// days or weeks ago: 7d, 2w), on, and size (>5M, <100K).
+//
+// Repeated and negated terms compile into operator conditions; the others
+// into the plain fields of the SearchConfig.
+func ParseQuery(query string) (SearchConfig, error) {
+	terms, err := tokenizeQuery(query)
+	if err != nil {
+		return SearchConfig{}, err
+	}
+	if len(terms) == 0 {
+		return SearchConfig{}, fmt.Errorf("empty query")
+	}
+
+	var flat SearchConfig
+	var conditions []ComplexSearchConfig
+	for _, term := range terms {
+		key := term.key
+		if key == "" {
+			key = "text"
+		}
+		spec, ok := queryKeys[key]
+		if !ok {
+			return SearchConfig{}, fmt.Errorf("unknown query key %q", term.key)
+		}
+
+		if term.negate && !spec.negatable {
+			var c SearchConfig
+			if _, err := spec.set(&c, term.value, false); err != nil {
+				return SearchConfig{}, err
+			}
+			conditions = append(conditions, ComplexSearchConfig{SearchConfig: SearchConfig{
+				Operator:   OperatorNot,
+				Conditions: []ComplexSearchConfig{{SearchConfig: c}},
+			}})
+			continue
+		}
+
+		set, err := spec.set(&flat, term.value, term.negate)
+		if err != nil {
+			return SearchConfig{}, err
+		}
+		if !set {
+			var c SearchConfig
+			if _, err := spec.set(&c, term.value, term.negate); err != nil {
+				return SearchConfig{}, err
+			}
+			conditions = append(conditions, ComplexSearchConfig{SearchConfig: c})
+		}
+	}
+
+	if len(conditions) == 0 {
+		return flat, nil
+	}
+	if !reflect.DeepEqual(flat, SearchConfig{}) {
+		conditions = append([]ComplexSearchConfig{{SearchConfig: flat}}, conditions...)
+	}
+	if len(conditions) == 1 {
+		return conditions[0].SearchConfig, nil
+	}
+	return SearchConfig{Operator: OperatorAnd, Conditions: conditions}, nil
+}
+
+// ExpandQuery replaces a search block holding a query with the criteria the
+// query compiles to. ParseRuleString expands queries before validating the
+// rule; a query cannot be combined with other search criteria.
+func (s *SearchConfig) ExpandQuery() error {
+	if s.Query == "" {
+		return nil
+	}
+	rest := *s
+	rest.Query = ""
+	if !reflect.DeepEqual(rest, SearchConfig{}) {
+		return fmt.Errorf("'query' cannot be combined with other search criteria")
+	}
+	expanded, err := ParseQuery(s.Query)
+	if err != nil {
+		return fmt.Errorf("invalid 'query': %w", err)
+	}
+	*s = expanded
+	return nil
+}
+
+// tokenizeQuery splits a query string into terms. Double quotes group words
+// and backslash escapes a quote inside them.
+func tokenizeQuery(query string) ([]queryTerm, error) {
+	var terms []queryTerm
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var term queryTerm
+		if runes[i] == '-' {
+			term.negate = true
+			i++
+		}
+		var b strings.Builder
+		quoted, hasKey, wasQuoted := false, false, false
+	scan:
+		for ; i < len(runes); i++ {
+			r := runes[i]
+			switch {
+			case quoted && r == '\\' && i+1 < len(runes):
+				i++
+				b.WriteRune(runes[i])
+			case r == '"':
+				quoted = !quoted
+				wasQuoted = true
+			case !quoted && unicode.IsSpace(r):
+				break scan
+			case !quoted && r == ':' && !hasKey && !wasQuoted && b.Len() > 0:
+				term.key = strings.ToLower(b.String())
+				hasKey = true
+				b.Reset()
+			default:
+				b.WriteRune(r)
+			}
+		}
+		if quoted {
+			return nil, fmt.Errorf("unterminated quote in query")
+		}
+		term.value = b.String()
+		if term.value == "" {
+			return nil, fmt.Errorf("empty query term at offset %d", i)
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// relativeDays parses a relative date: 7d is 7 days ago, 2w two weeks ago.
+func relativeDays(value string) (int, bool) {
+	matches := relativeDateRe.FindStringSubmatch(value)
+	if matches == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, false
+	}
+	if matches[2] == "w" {
+		n *= 7
+	}
+	return n, true
+}
+
+// normalizeQuerySize accepts sizes such as 5m and 5MB in queries, as 5M.
+func normalizeQuerySize(value string) string {
+	value = strings.ToUpper(value)
+	if len(value) > 2 && strings.HasSuffix(value, "B") && strings.ContainsAny(value[len(value)-2:len(value)-1], "KMG") {
+		value = value[:len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,127 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuery(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		query string
+		want  SearchConfig
+	}{
+		{
+			name:  "flat",
+			query: `from:boss@x subject:"quarterly report" -flag:seen since:7d size:>5M`,
+			want: SearchConfig{
+				From:            "boss@x",
+				SubjectContains: "quarterly report",
+				Flags:           &FlagCriteria{NotHas: []string{"seen"}},
+				WithinDays:      7,
+				Size:            &SizeCriteria{LargerThan: "5M"},
+			},
+		},
+		{
+			name:  "bare words and is",
+			query: `invoice is:unread -is:flagged size:<100kb`,
+			want: SearchConfig{
+				Text:    "invoice",
+				Unread:  &yes,
+				Flagged: &no,
+				Size:    &SizeCriteria{SmallerThan: "100K"},
+			},
+		},
+		{
+			name:  "headers",
+			query: `header:X-Spam:yes -header:DKIM-Signature since:2024-01-01`,
+			want: SearchConfig{
+				Header:        &HeaderCriteria{Name: "X-Spam", Value: "yes"},
+				HeaderMissing: []string{"DKIM-Signature"},
+				Since:         "2024-01-01",
+			},
+		},
+		{
+			name:  "quoted colon",
+			query: `"re: hello \"there\""`,
+			want:  SearchConfig{Text: `re: hello "there"`},
+		},
+		{
+			name:  "negated term",
+			query: `-from:noreply`,
+			want: SearchConfig{
+				Operator:   OperatorNot,
+				Conditions: []ComplexSearchConfig{{SearchConfig: SearchConfig{From: "noreply"}}},
+			},
+		},
+		{
+			name:  "repeated and negated terms",
+			query: `invoice march -from:noreply`,
+			want: SearchConfig{
+				Operator: OperatorAnd,
+				Conditions: []ComplexSearchConfig{
+					{SearchConfig: SearchConfig{Text: "invoice"}},
+					{SearchConfig: SearchConfig{Text: "march"}},
+					{SearchConfig: SearchConfig{
+						Operator:   OperatorNot,
+						Conditions: []ComplexSearchConfig{{SearchConfig: SearchConfig{From: "noreply"}}},
+					}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			require.NoError(t, got.Validate())
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		``,
+		`   `,
+		`from:`,
+		`subject:"unterminated`,
+		`unknown:value`,
+		`is:spam`,
+		`-header:X-Spam:yes`,
+	} {
+		_, err := ParseQuery(query)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestRuleQuery(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: invoices
+search:
+  query: 'subject:invoice is:read'
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	assert.Empty(t, rule.Search.Query)
+	assert.Equal(t, "invoice", rule.Search.SubjectContains)
+
+	got, err := rule.FilterLocalMessages(readSampleMbox(t))
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, uint32(1), got[0].UID)
+
+	_, err = ParseRuleString(`
+name: mixed
+search:
+  query: 'subject:invoice'
+  from: example.com
+output:
+  fields: [uid]
+`)
+	assert.ErrorContains(t, err, "cannot be combined")
+}
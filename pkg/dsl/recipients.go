@@ -0,0 +1,85 @@
+package dsl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// searchesRecipients reports whether the search has recipient conditions.
+func (s SearchConfig) searchesRecipients() bool {
+	return s.MinRecipients > 0 || s.MaxRecipients > 0 || s.ToMe != nil
+}
+
+// validateRecipients checks the recipient conditions.
+func (s SearchConfig) validateRecipients() error {
+	if s.MinRecipients < 0 || s.MaxRecipients < 0 {
+		return fmt.Errorf("min_recipients and max_recipients must not be negative")
+	}
+	if s.MaxRecipients > 0 && s.MinRecipients > s.MaxRecipients {
+		return fmt.Errorf("min_recipients (%d) is greater than max_recipients (%d)", s.MinRecipients, s.MaxRecipients)
+	}
+	return nil
+}
+
+// SetMyAddresses sets your own addresses, which search.to_me compares the
+// recipients against.
+func (rule *Rule) SetMyAddresses(addresses []string) {
+	rule.me = addresses
+}
+
+// recipientCount returns the number of distinct To, Cc and Bcc addresses.
+func recipientCount(env *EmailEnvelope) int {
+	seen := map[string]bool{}
+	for _, list := range [][]EmailAddress{env.To, env.Cc, env.Bcc} {
+		for _, addr := range list {
+			if addr.Address != "" {
+				seen[strings.ToLower(addr.Address)] = true
+			}
+		}
+	}
+	return len(seen)
+}
+
+// recipientFilter returns a predicate for min_recipients, max_recipients and
+// to_me, evaluated on the envelope, or nil if the search has none.
+func (rule *Rule) recipientFilter() (func(*EmailMessage) bool, error) {
+	search := rule.Search
+	if !search.searchesRecipients() {
+		return nil, nil
+	}
+	me := make(map[string]bool, len(rule.me))
+	for _, addr := range rule.me {
+		me[strings.ToLower(strings.TrimSpace(addr))] = true
+	}
+	if search.ToMe != nil && len(me) == 0 {
+		return nil, fmt.Errorf("to_me needs your own addresses (--me, or an IMAP username that is an address)")
+	}
+
+	return func(msg *EmailMessage) bool {
+		env := msg.Envelope
+		if env == nil {
+			env = &EmailEnvelope{}
+		}
+		count := recipientCount(env)
+		if search.MinRecipients > 0 && count < search.MinRecipients {
+			return false
+		}
+		if search.MaxRecipients > 0 && count > search.MaxRecipients {
+			return false
+		}
+		if search.ToMe != nil {
+			toMe := false
+			for _, list := range [][]EmailAddress{env.To, env.Cc} {
+				for _, addr := range list {
+					if me[strings.ToLower(addr.Address)] {
+						toMe = true
+					}
+				}
+			}
+			if toMe != *search.ToMe {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
@@ -0,0 +1,62 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipientFilter(t *testing.T) {
+	addrs := func(addresses ...string) []EmailAddress {
+		ret := make([]EmailAddress, len(addresses))
+		for i, addr := range addresses {
+			ret[i] = EmailAddress{Address: addr}
+		}
+		return ret
+	}
+	direct := &EmailMessage{UID: 1, Envelope: &EmailEnvelope{To: addrs("Me@example.com")}}
+	copied := &EmailMessage{UID: 2, Envelope: &EmailEnvelope{To: addrs("bob@example.com"), Cc: addrs("me@example.com", "carol@example.com")}}
+	mass := &EmailMessage{UID: 3, Envelope: &EmailEnvelope{
+		To:  addrs("list@example.com", "a@example.com", "b@example.com"),
+		Bcc: addrs("me@example.com", "LIST@example.com"),
+	}}
+	messages := []*EmailMessage{direct, copied, mass}
+
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   []uint32
+	}{
+		{"min", SearchConfig{MinRecipients: 3}, []uint32{2, 3}},
+		{"max", SearchConfig{MaxRecipients: 1}, []uint32{1}},
+		{"to me", SearchConfig{ToMe: &yes}, []uint32{1, 2}},
+		{"mass mail not to me", SearchConfig{ToMe: &no, MinRecipients: 2}, []uint32{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			rule.SetMyAddresses([]string{"me@example.com"})
+			filtered, err := rule.applyClientFilter(messages)
+			require.NoError(t, err)
+			var got []uint32
+			for _, msg := range filtered {
+				got = append(got, msg.UID)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	rule := &Rule{Name: "no addresses", Search: SearchConfig{ToMe: &yes}}
+	_, err := rule.applyClientFilter(messages)
+	assert.ErrorContains(t, err, "to_me needs your own addresses")
+}
+
+func TestRecipientCriteriaValidate(t *testing.T) {
+	assert.ErrorContains(t, (&SearchConfig{MinRecipients: 5, MaxRecipients: 2}).Validate(), "greater than max_recipients")
+	assert.ErrorContains(t, (&SearchConfig{
+		Operator:   OperatorOr,
+		Conditions: []ComplexSearchConfig{{SearchConfig: SearchConfig{MaxRecipients: 1}}},
+	}).Validate(), "recipient criteria are only supported at the top level")
+}
@@ -0,0 +1,111 @@
+package dsl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactReplacement replaces redacted header values and body matches.
+const DefaultRedactReplacement = "[REDACTED]"
+
+// RedactConfig scrubs exported messages before they are written.
+//
+//	export:
+//	  directory: evidence
+//	  redact:
+//	    headers: [Received, X-Originating-IP, "X-Mailer*"]
+//	    patterns:
+//	      - '\b(?:\d[ -]?){13,16}\b'  # card numbers
+//	      - 'ghp_[A-Za-z0-9]{36}'      # GitHub tokens
+//
+// Headers are replaced entirely; a trailing * matches a name prefix.
+// Patterns are applied to the decoded text of header values and text/* body
+// parts, see rewriteMessage.
+type RedactConfig struct {
+	Headers     []string `yaml:"headers,omitempty"`
+	Patterns    []string `yaml:"patterns,omitempty"`
+	Replacement string   `yaml:"replacement,omitempty"`
+}
+
+// Validate checks if the redaction config is valid
+func (r *RedactConfig) Validate() error {
+	if len(r.Headers) == 0 && len(r.Patterns) == 0 {
+		return fmt.Errorf("redact requires headers or patterns")
+	}
+	for _, header := range r.Headers {
+		if strings.TrimSuffix(header, "*") == "" {
+			return fmt.Errorf("invalid redact header %q", header)
+		}
+		if strings.HasPrefix(strings.ToLower(header), "content-") || strings.EqualFold(header, "mime-version") {
+			return fmt.Errorf("redact header %q would break the MIME structure", header)
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+type redactor struct {
+	headers     []string
+	patterns    []*regexp.Regexp
+	replacement string
+}
+
+func (r *RedactConfig) redactor() (*redactor, error) {
+	ret := &redactor{replacement: r.Replacement}
+	if ret.replacement == "" {
+		ret.replacement = DefaultRedactReplacement
+	}
+	for _, header := range r.Headers {
+		ret.headers = append(ret.headers, strings.ToLower(header))
+	}
+	for _, pattern := range r.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+		}
+		ret.patterns = append(ret.patterns, re)
+	}
+	return ret, nil
+}
+
+// Redact returns a copy of the raw message with the configured headers and
+// patterns redacted.
+func (r *RedactConfig) Redact(raw []byte) ([]byte, error) {
+	red, err := r.redactor()
+	if err != nil {
+		return nil, err
+	}
+	return rewriteMessage(raw, red)
+}
+
+func (r *redactor) rewriteHeader(lower, value string) string {
+	if r.redactsHeader(lower) {
+		return r.replacement
+	}
+	return string(r.rewriteText([]byte(value)))
+}
+
+func (r *redactor) redactsHeader(lower string) bool {
+	for _, name := range r.headers {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			if strings.HasPrefix(lower, prefix) {
+				return true
+			}
+		} else if lower == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redactor) rewriteText(content []byte) []byte {
+	for _, re := range r.patterns {
+		content = re.ReplaceAllLiteral(content, []byte(r.replacement))
+	}
+	return content
+}
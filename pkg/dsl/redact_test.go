@@ -0,0 +1,78 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	raw := "Received: from mail.example.com by mx.example.net\r\n" +
+		"X-Mailer-Version: 1.2\r\n" +
+		"From: alice@example.com\r\n" +
+		"Subject: =?utf-8?q?token_ghp=5Fabc123?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=sep\r\n" +
+		"\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"card 4111 1111 1111 1111, caf=C3=A9\r\n" +
+		"--sep\r\n" +
+		"Content-Type: text/html\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"PHA+Z2hwX3NlY3JldDwvcD4=\r\n" +
+		"--sep\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"Z2hwX3NlY3JldA==\r\n" +
+		"--sep--\r\n"
+
+	config := &RedactConfig{
+		Headers:  []string{"Received", "X-Mailer*"},
+		Patterns: []string{`\b(?:\d[ -]?){13,16}\b`, `ghp_[a-z0-9]+`},
+	}
+	require.NoError(t, config.Validate())
+
+	redacted, err := config.Redact([]byte(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "Received: [REDACTED]\r\n"+
+		"X-Mailer-Version: [REDACTED]\r\n"+
+		"From: alice@example.com\r\n"+
+		"Subject: token [REDACTED]\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: multipart/mixed; boundary=sep\r\n"+
+		"\r\n"+
+		"--sep\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"Content-Transfer-Encoding: quoted-printable\r\n"+
+		"\r\n"+
+		"card [REDACTED], caf=C3=A9\r\n"+
+		"--sep\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Content-Transfer-Encoding: base64\r\n"+
+		"\r\n"+
+		"PHA+W1JFREFDVEVEXTwvcD4=\r\n"+
+		"\r\n"+
+		"--sep\r\n"+
+		"Content-Type: application/octet-stream\r\n"+
+		"Content-Transfer-Encoding: base64\r\n"+
+		"\r\n"+
+		"Z2hwX3NlY3JldA==\r\n"+
+		"--sep--\r\n", string(redacted))
+
+	local, err := ParseLocalMessage(redacted, 1, nil, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "token [REDACTED]", local.Message.Envelope.Subject)
+}
+
+func TestRedactConfigValidation(t *testing.T) {
+	assert.ErrorContains(t, (&RedactConfig{}).Validate(), "requires headers or patterns")
+	assert.ErrorContains(t, (&RedactConfig{Headers: []string{"Content-Type"}}).Validate(), "would break the MIME structure")
+	assert.ErrorContains(t, (&RedactConfig{Patterns: []string{"("}}).Validate(), "invalid redact pattern")
+}
@@ -0,0 +1,45 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	smailnailimap "github.com/go-go-golems/smailnail/pkg/imap"
+)
+
+// replayClient returns a client logged in to a replay of the fixture, and
+// the channel receiving the replay's result.
+func replayClient(t *testing.T, fixture string) (*imapclient.Client, <-chan error) {
+	t.Helper()
+	f, err := smailnailimap.LoadFixture(fixture)
+	require.NoError(t, err)
+	conn, done := f.Pipe()
+	client := imapclient.New(conn, nil)
+	t.Cleanup(func() { _ = client.Close() })
+	require.NoError(t, client.Login("user", "password").Wait())
+	return client, done
+}
+
+// The fixture was recorded with --record-imap against a test server
+// running the same rule.
+func TestReplayMoveInvoices(t *testing.T) {
+	rule, err := ParseRuleFile("testdata/imap/move_invoices.yaml")
+	require.NoError(t, err)
+	client, done := replayClient(t, "testdata/imap/move_invoices.imap")
+
+	_, err = SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages, err := rule.FetchMessages(client)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, uint32(2), messages[0].UID)
+	assert.Equal(t, "invoice 1", messages[0].Envelope.Subject)
+	assert.Equal(t, "invoice 2", messages[1].Envelope.Subject)
+
+	require.NoError(t, rule.ExecuteActions(client, messages))
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the recorded session")
+}
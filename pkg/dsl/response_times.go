@@ -0,0 +1,111 @@
+package dsl
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ResponseTimes summarizes how long you took to reply to a correspondent.
+type ResponseTimes struct {
+	// Correspondent is the address, or with byDomain the domain, replied to
+	Correspondent string
+	// Latencies are the reply times, fastest first
+	Latencies []time.Duration
+}
+
+// Replies returns the number of replies.
+func (r *ResponseTimes) Replies() int {
+	return len(r.Latencies)
+}
+
+// Percentile returns the reply time p percent of the replies were at least
+// as fast as (nearest rank), e.g. Percentile(50) is the median.
+func (r *ResponseTimes) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(r.Latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(r.Latencies) {
+		rank = len(r.Latencies) - 1
+	}
+	return r.Latencies[rank]
+}
+
+// Mean returns the average reply time.
+func (r *ResponseTimes) Mean() time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, latency := range r.Latencies {
+		total += latency
+	}
+	return total / time.Duration(len(r.Latencies))
+}
+
+// CollectResponseTimes groups messages into threads and measures, for each
+// of your messages (from an address of me), the time since the message it
+// answers: the one its In-Reply-To names, or else the latest message from
+// someone else since your previous message. Pass received and sent messages
+// together. Correspondents with the most replies come first.
+func CollectResponseTimes(messages []*EmailMessage, me []string, byDomain bool) []*ResponseTimes {
+	stats := make(map[string]*ResponseTimes)
+	for _, thread := range GroupThreads(messages) {
+		byID := make(map[string]*EmailMessage)
+		var pending *EmailMessage
+		for _, msg := range thread.Messages {
+			env := msg.Envelope
+			if env == nil || len(env.From) == 0 || env.Date.IsZero() {
+				continue
+			}
+			if !isOwnAddress(env.From[0].Address, me) {
+				if env.MessageID != "" {
+					byID[env.MessageID] = msg
+				}
+				pending = msg
+				continue
+			}
+
+			answered := pending
+			for _, id := range env.InReplyTo {
+				if parent, ok := byID[id]; ok {
+					answered = parent
+					break
+				}
+			}
+			pending = nil
+			if answered == nil || env.Date.Before(answered.Envelope.Date) {
+				continue
+			}
+
+			correspondent := strings.ToLower(answered.Envelope.From[0].Address)
+			if byDomain {
+				correspondent = correspondent[strings.LastIndex(correspondent, "@")+1:]
+			}
+			stat, ok := stats[correspondent]
+			if !ok {
+				stat = &ResponseTimes{Correspondent: correspondent}
+				stats[correspondent] = stat
+			}
+			stat.Latencies = append(stat.Latencies, env.Date.Sub(answered.Envelope.Date))
+		}
+	}
+
+	ret := make([]*ResponseTimes, 0, len(stats))
+	for _, stat := range stats {
+		sort.Slice(stat.Latencies, func(i, j int) bool { return stat.Latencies[i] < stat.Latencies[j] })
+		ret = append(ret, stat)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Replies() != ret[j].Replies() {
+			return ret[i].Replies() > ret[j].Replies()
+		}
+		return ret[i].Correspondent < ret[j].Correspondent
+	})
+	return ret
+}
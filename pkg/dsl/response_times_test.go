@@ -0,0 +1,48 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectResponseTimes(t *testing.T) {
+	messages := []*EmailMessage{
+		threadMessage(1, 1, "a@x", "Budget", "alice@example.com", nil, nil),
+		threadMessage(2, 2, "b@x", "Re: Budget", "me@corp.example", []string{"a@x"}, []string{"a@x"}),
+		threadMessage(3, 3, "c@x", "Re: Budget", "Alice@example.com", []string{"b@x"}, []string{"a@x", "b@x"}),
+		threadMessage(4, 4, "d@x", "Re: Budget", "bob@example.com", []string{"b@x"}, []string{"a@x", "b@x"}),
+		// In-Reply-To names Alice's message, not Bob's later one
+		threadMessage(5, 6, "e@x", "Re: Budget", "me@corp.example", []string{"c@x"}, []string{"a@x", "b@x", "c@x"}),
+
+		// Without In-Reply-To, the latest message since the last reply counts
+		threadMessage(6, 1, "f@x", "Offer", "carol@example.com", nil, nil),
+		threadMessage(7, 2, "g@x", "Offer", "carol@example.com", nil, []string{"f@x"}),
+		threadMessage(8, 5, "h@x", "Re: Offer", "me@corp.example", nil, []string{"f@x"}),
+
+		// Nothing to answer, or not answered
+		threadMessage(9, 1, "i@x", "Note", "me@corp.example", nil, nil),
+		threadMessage(10, 2, "j@x", "Re: Note", "dave@example.com", []string{"i@x"}, []string{"i@x"}),
+	}
+	day := 24 * time.Hour
+
+	stats := CollectResponseTimes(messages, []string{"me@corp.example"}, false)
+	require.Len(t, stats, 2)
+	alice := stats[0]
+	assert.Equal(t, "alice@example.com", alice.Correspondent)
+	assert.Equal(t, []time.Duration{day, 3 * day}, alice.Latencies)
+	assert.Equal(t, 2, alice.Replies())
+	assert.Equal(t, day, alice.Percentile(50))
+	assert.Equal(t, 3*day, alice.Percentile(90))
+	assert.Equal(t, 2*day, alice.Mean())
+	assert.Equal(t, "carol@example.com", stats[1].Correspondent)
+	assert.Equal(t, []time.Duration{3 * day}, stats[1].Latencies)
+
+	byDomain := CollectResponseTimes(messages, []string{"me@corp.example"}, true)
+	require.Len(t, byDomain, 1)
+	assert.Equal(t, "example.com", byDomain[0].Correspondent)
+	assert.Equal(t, 3, byDomain[0].Replies())
+	assert.Equal(t, 3*day, byDomain[0].Percentile(50))
+}
@@ -0,0 +1,154 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// messageRewriter rewrites the header values and text of a message while
+// rewriteMessage keeps its MIME structure intact.
+type messageRewriter interface {
+	// rewriteHeader receives the lowercased field name and the decoded
+	// value. Content-* and MIME-Version fields are never passed.
+	rewriteHeader(lower, value string) string
+	// rewriteText receives the transfer-decoded content of text/* parts.
+	rewriteText(content []byte) []byte
+}
+
+// rewriteMessage returns a copy of raw rewritten by rw. Text parts are
+// re-encoded with their original transfer encoding, other parts (images,
+// attachments) are copied unchanged.
+func rewriteMessage(raw []byte, rw messageRewriter) ([]byte, error) {
+	br := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message header: %w", err)
+	}
+	var b bytes.Buffer
+	if err := rewriteEntity(&b, header, br, rw); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func rewriteEntity(w io.Writer, header textproto.Header, body io.Reader, rw messageRewriter) error {
+	if err := writeRewrittenHeader(w, header, rw); err != nil {
+		return err
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		boundary := params["boundary"]
+		mr := textproto.NewMultipartReader(body, boundary)
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read MIME part: %w", err)
+			}
+			if _, err := io.WriteString(w, "--"+boundary+"\r\n"); err != nil {
+				return err
+			}
+			if err := rewriteEntity(w, part.Header, part, rw); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, "\r\n"); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "--"+boundary+"--\r\n")
+		return err
+	}
+
+	if !strings.HasPrefix(mediaType, "text/") && mediaType != "" {
+		_, err := io.Copy(w, body)
+		return err
+	}
+
+	// text/* and parts without a Content-Type, which default to text/plain
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding")))
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("failed to read MIME part: %w", err)
+	}
+	decoded, err := decodeTransferEncoding(content, encoding)
+	if err != nil {
+		// Leave undecodable parts alone rather than corrupting them
+		_, err = w.Write(content)
+		return err
+	}
+	return encodeTransferEncoding(w, rw.rewriteText(decoded), encoding)
+}
+
+// writeRewrittenHeader writes header with rewritten fields. Unchanged fields
+// keep their original formatting.
+func writeRewrittenHeader(w io.Writer, header textproto.Header, rw messageRewriter) error {
+	decoder := mime.WordDecoder{}
+	fields := header.Fields()
+	for fields.Next() {
+		lower := strings.ToLower(fields.Key())
+		structural := strings.HasPrefix(lower, "content-") || lower == "mime-version"
+
+		value := fields.Value()
+		if !structural {
+			text, err := decoder.DecodeHeader(value)
+			if err != nil {
+				text = value
+			}
+			if rewritten := rw.rewriteHeader(lower, text); rewritten != text {
+				value = mime.QEncoding.Encode("utf-8", rewritten)
+			}
+		}
+
+		if value == fields.Value() {
+			raw, err := fields.Raw()
+			if err != nil {
+				return fmt.Errorf("failed to write header %s: %w", fields.Key(), err)
+			}
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\r\n", fields.Key(), value); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// encodeTransferEncoding is the inverse of decodeTransferEncoding.
+func encodeTransferEncoding(w io.Writer, content []byte, encoding string) error {
+	switch encoding {
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString(content)
+		for len(encoded) > 76 {
+			if _, err := io.WriteString(w, encoded[:76]+"\r\n"); err != nil {
+				return err
+			}
+			encoded = encoded[76:]
+		}
+		_, err := io.WriteString(w, encoded+"\r\n")
+		return err
+	case "quoted-printable":
+		qw := quotedprintable.NewWriter(w)
+		if _, err := qw.Write(content); err != nil {
+			return err
+		}
+		return qw.Close()
+	default:
+		_, err := w.Write(content)
+		return err
+	}
+}
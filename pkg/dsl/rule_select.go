@@ -0,0 +1,98 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// validTag reports whether tag is a non-empty word usable in --tags lists.
+func validTag(tag string) bool {
+	return tag != "" && !strings.ContainsFunc(tag, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// IsEnabled reports whether the rule runs: unless it sets enabled: false.
+func (r *Rule) IsEnabled() bool {
+	return r.Enabled == nil || *r.Enabled
+}
+
+// HasAnyTag reports whether the rule has one of tags, ignoring case.
+func (r *Rule) HasAnyTag(tags []string) bool {
+	for _, want := range tags {
+		for _, tag := range r.Tags {
+			if strings.EqualFold(tag, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SelectRules returns the rules to run: the enabled rules with one of tags,
+// or all enabled rules when tags is empty, plus the rules they depend on, in
+// the order of rules. skipped lists the disabled rules that were left out.
+// A selected rule depending on a disabled rule is an error, since it could
+// never run.
+func SelectRules(rules []*Rule, tags []string) (selected []*Rule, skipped []*Rule, err error) {
+	byName := make(map[string]*Rule, len(rules))
+	for _, rule := range rules {
+		byName[rule.Name] = rule
+	}
+
+	chosen := make(map[*Rule]bool)
+	var choose func(rule *Rule) error
+	choose = func(rule *Rule) error {
+		if chosen[rule] {
+			return nil
+		}
+		chosen[rule] = true
+		for _, name := range rule.Dependencies() {
+			dep, ok := byName[name]
+			if !ok {
+				// Left to ValidateDependencies
+				continue
+			}
+			if !dep.IsEnabled() {
+				return fmt.Errorf("rule %q depends on disabled rule %q", rule.Name, dep.Name)
+			}
+			if err := choose(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, rule := range rules {
+		if !rule.IsEnabled() {
+			continue
+		}
+		if len(tags) > 0 && !rule.HasAnyTag(tags) {
+			continue
+		}
+		if err := choose(rule); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, rule := range rules {
+		switch {
+		case chosen[rule]:
+			selected = append(selected, rule)
+		case !rule.IsEnabled():
+			skipped = append(skipped, rule)
+		}
+	}
+	return selected, skipped, nil
+}
+
+// SortRulesByPriority orders rules by priority, lowest first, keeping the
+// order of rules with the same priority. RunRules runs the rules sharing a
+// mailbox in this order.
+func SortRulesByPriority(rules []*Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+}
@@ -0,0 +1,120 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSelectRules(t *testing.T) {
+	off := false
+	names := func(rules []*Rule) []string {
+		var ret []string
+		for _, rule := range rules {
+			ret = append(ret, rule.Name)
+		}
+		return ret
+	}
+	rules := []*Rule{
+		{Name: "archive", Tags: []string{"cleanup"}},
+		{Name: "report", Tags: []string{"Weekly"}, DependsOn: []string{"label"}},
+		{Name: "label"},
+		{Name: "old", Tags: []string{"cleanup"}, Enabled: &off},
+	}
+
+	selected, skipped, err := SelectRules(rules, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"archive", "report", "label"}, names(selected))
+	assert.Equal(t, []string{"old"}, names(skipped))
+
+	// Dependencies of tagged rules run too
+	selected, _, err = SelectRules(rules, []string{"weekly"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"report", "label"}, names(selected))
+
+	selected, _, err = SelectRules(rules, []string{"cleanup", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"archive"}, names(selected))
+
+	rules[2].Enabled = &off
+	_, _, err = SelectRules(rules, nil)
+	assert.ErrorContains(t, err, `rule "report" depends on disabled rule "label"`)
+}
+
+func TestRuleMetadataYAML(t *testing.T) {
+	var rule Rule
+	require.NoError(t, yaml.Unmarshal([]byte(`
+name: cleanup
+tags: [cleanup, weekly]
+owner: ops@example.com
+enabled: false
+search:
+  within_days: 7
+`), &rule))
+	assert.Equal(t, []string{"cleanup", "weekly"}, rule.Tags)
+	assert.Equal(t, "ops@example.com", rule.Owner)
+	assert.False(t, rule.IsEnabled())
+	assert.True(t, (&Rule{}).IsEnabled())
+
+	rule.Tags = []string{"two words"}
+	assert.ErrorContains(t, rule.Validate(), `invalid tag "two words"`)
+}
+
+func TestSortRulesByPriority(t *testing.T) {
+	rules := []*Rule{
+		{Name: "archive"},
+		{Name: "late", Priority: 10},
+		{Name: "vip", Priority: -5},
+		{Name: "label"},
+	}
+	SortRulesByPriority(rules)
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.Name)
+	}
+	assert.Equal(t, []string{"vip", "archive", "label", "late"}, names)
+}
+
+func TestStopAction(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: vip
+search:
+  from: boss@example.com
+output:
+  fields: [uid]
+actions:
+  stop: true
+`)
+	require.NoError(t, err)
+	assert.True(t, rule.Actions.Stop)
+	assert.Empty(t, rule.Actions.Custom)
+
+	rule.Actions.ByLabel = map[string]*ActionConfig{"spam": {Stop: true}}
+	assert.ErrorContains(t, rule.Validate(), "by_label spam cannot contain stop")
+
+	handled := NewHandledMessages()
+	handled.Add("INBOX", []*EmailMessage{{UID: 3}, {UID: 7}})
+	assert.True(t, handled.Has("INBOX", 7))
+	assert.False(t, handled.Has("Archive", 7))
+	assert.False(t, handled.Has("INBOX", 4))
+	assert.Equal(t, 2, handled.Len())
+	assert.False(t, (*HandledMessages)(nil).Has("INBOX", 3))
+}
+
+func TestDedupOptOut(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: archive
+dedup: false
+search:
+  subject_contains: invoice
+output:
+  fields: [uid]
+actions:
+  move_to: Archive
+`)
+	require.NoError(t, err)
+	assert.False(t, rule.Deduplicates())
+	assert.True(t, (&Rule{}).Deduplicates())
+}
@@ -0,0 +1,240 @@
+package dsl
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// SourceMailbox returns the mailbox the rule searches: its own mailbox if
+// set, defaultMailbox otherwise.
+func (r *Rule) SourceMailbox(defaultMailbox string) string {
+	if r.Mailbox != "" {
+		return r.Mailbox
+	}
+	return defaultMailbox
+}
+
+// Mailboxes returns the server names of the mailboxes the rule acts on: the
+// one it searches and the fixed move_to, copy_to and snooze targets.
+// Templated targets are only known per message and are left out.
+func (r *Rule) Mailboxes(defaultMailbox string) []string {
+	set := map[string]bool{r.MailboxName(r.SourceMailbox(defaultMailbox)): true}
+	actions := []*ActionConfig{&r.Actions}
+	for _, label := range sortedLabels(r.Actions.ByLabel) {
+		actions = append(actions, r.Actions.ByLabel[label])
+	}
+	for _, a := range actions {
+		for _, mailbox := range []string{a.MoveTo, a.CopyTo} {
+			if mailbox != "" && !isTemplate(mailbox) {
+				set[r.MailboxName(mailbox)] = true
+			}
+		}
+		if a.Snooze != nil {
+			set[r.MailboxName(a.Snooze.folder())] = true
+		}
+	}
+
+	ret := make([]string, 0, len(set))
+	for mailbox := range set {
+		ret = append(ret, mailbox)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Dependencies returns the rules that must succeed before this one runs:
+// depends_on and input_from.
+func (r *Rule) Dependencies() []string {
+	if r.InputFrom == "" || slices.Contains(r.DependsOn, r.InputFrom) {
+		return r.DependsOn
+	}
+	return append(slices.Clip(r.DependsOn), r.InputFrom)
+}
+
+// SetInput restricts the rule's search to the messages from returned the
+// last time it fetched messages, for input_from. from must have run first,
+// on the same mailbox: RunRules takes care of the order.
+func (r *Rule) SetInput(from *Rule) {
+	r.input = from
+}
+
+// ValidateDependencies checks that rule names are unique and that depends_on
+// and input_from name existing rules without forming a cycle.
+func ValidateDependencies(rules []*Rule) error {
+	index := map[string]int{}
+	for i, rule := range rules {
+		if _, ok := index[rule.Name]; ok {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		index[rule.Name] = i
+	}
+	for _, rule := range rules {
+		for _, dep := range rule.Dependencies() {
+			if _, ok := index[dep]; !ok {
+				return fmt.Errorf("rule %q depends on unknown rule %q", rule.Name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(rules))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		path = append(path, rules[i].Name)
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, dep := range rules[i].Dependencies() {
+			if err := visit(index[dep], path); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range rules {
+		if err := visit(i, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RuleRunResult is the outcome of one rule run by RunRules.
+type RuleRunResult struct {
+	Rule *Rule
+	Err  error
+	// Skipped is set when a dependency failed and the rule was not run
+	Skipped bool
+}
+
+// RunRules runs rules concurrently, at most concurrency at a time. A rule
+// starts once the rules it depends on have succeeded and no running rule
+// acts on one of its mailboxes (see Rule.Mailboxes), so rules sharing a
+// mailbox run one after the other, in the order given. Rules whose
+// dependencies failed are skipped. Results are in the order of rules.
+func RunRules(
+	ctx context.Context,
+	rules []*Rule,
+	defaultMailbox string,
+	concurrency int,
+	run func(ctx context.Context, rule *Rule) error,
+) ([]RuleRunResult, error) {
+	if err := ValidateDependencies(rules); err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	index := map[string]int{}
+	mailboxes := make([][]string, len(rules))
+	results := make([]RuleRunResult, len(rules))
+	for i, rule := range rules {
+		index[rule.Name] = i
+		mailboxes[i] = rule.Mailboxes(defaultMailbox)
+		results[i].Rule = rule
+	}
+
+	const (
+		pending = iota
+		running
+		finished
+	)
+	state := make([]int, len(rules))
+	busy := map[string]bool{}
+	done := make(chan int)
+	active, remaining := 0, len(rules)
+
+	finish := func(i int, err error, skipped bool) {
+		state[i] = finished
+		results[i].Err = err
+		results[i].Skipped = skipped
+		remaining--
+	}
+
+	// ready reports whether the dependencies of rule i succeeded, and the
+	// first one that failed
+	ready := func(i int) (bool, string) {
+		ok := true
+		for _, dep := range rules[i].Dependencies() {
+			j := index[dep]
+			switch {
+			case state[j] != finished:
+				ok = false
+			case results[j].Err != nil:
+				return false, dep
+			}
+		}
+		return ok, ""
+	}
+
+	available := func(i int) bool {
+		for _, mailbox := range mailboxes[i] {
+			if busy[mailbox] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for remaining > 0 {
+		// Skipping a rule can unblock rules listed before it, loop until
+		// nothing changes
+		for changed := true; changed; {
+			changed = false
+			for i := range rules {
+				if state[i] != pending {
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					finish(i, err, true)
+					changed = true
+					continue
+				}
+				ok, failed := ready(i)
+				if failed != "" {
+					finish(i, fmt.Errorf("skipped: dependency %s failed", failed), true)
+					changed = true
+					continue
+				}
+				if !ok || active >= concurrency || !available(i) {
+					continue
+				}
+
+				state[i] = running
+				active++
+				for _, mailbox := range mailboxes[i] {
+					busy[mailbox] = true
+				}
+				go func(i int) {
+					results[i].Err = run(ctx, rules[i])
+					done <- i
+				}(i)
+			}
+		}
+		if active == 0 {
+			break
+		}
+
+		i := <-done
+		active--
+		for _, mailbox := range mailboxes[i] {
+			delete(busy, mailbox)
+		}
+		finish(i, results[i].Err, false)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,137 @@
+package dsl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleMailboxes(t *testing.T) {
+	rule := &Rule{Name: "r", Actions: ActionConfig{MoveTo: "Archive", CopyTo: "{{ .From }}"}}
+	assert.Equal(t, []string{"Archive", "INBOX"}, rule.Mailboxes("INBOX"))
+
+	rule = &Rule{Name: "r", Mailbox: "Lists", Actions: ActionConfig{Snooze: &SnoozeConfig{Until: "+1d"}}}
+	assert.Equal(t, []string{"Lists", "Snoozed"}, rule.Mailboxes("INBOX"))
+}
+
+func TestValidateDependencies(t *testing.T) {
+	err := ValidateDependencies([]*Rule{{Name: "a", DependsOn: []string{"b"}}})
+	assert.ErrorContains(t, err, `unknown rule "b"`)
+
+	err = ValidateDependencies([]*Rule{{Name: "a"}, {Name: "a"}})
+	assert.ErrorContains(t, err, "duplicate rule name")
+
+	err = ValidateDependencies([]*Rule{
+		{Name: "a", DependsOn: []string{"c"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c", DependsOn: []string{"b"}},
+	})
+	assert.ErrorContains(t, err, "dependency cycle: a -> c -> b -> a")
+}
+
+func TestInputFrom(t *testing.T) {
+	rule := &Rule{Name: "attachments", DependsOn: []string{"label"}, InputFrom: "big"}
+	assert.Equal(t, []string{"label", "big"}, rule.Dependencies())
+	assert.Equal(t, []string{"label"}, rule.DependsOn)
+	rule.DependsOn = []string{"big"}
+	assert.Equal(t, []string{"big"}, rule.Dependencies())
+
+	err := ValidateDependencies([]*Rule{{Name: "a", InputFrom: "b"}})
+	assert.ErrorContains(t, err, `unknown rule "b"`)
+
+	big := &Rule{Name: "big"}
+	big.fetched.AddNum(3, 7)
+	rule = &Rule{Name: "attachments", InputFrom: "big", Output: OutputConfig{Fields: []interface{}{"uid"}}}
+	rule.SetInput(big)
+	criteria, _, err := rule.buildSearchCriteria()
+	require.NoError(t, err)
+	require.Len(t, criteria.UID, 1)
+	assert.Equal(t, "3,7", criteria.UID[0].String())
+}
+
+// scheduleRecorder records which rules ran and how many ran at once.
+type scheduleRecorder struct {
+	mu      sync.Mutex
+	order   []string
+	active  map[string]bool
+	maxSeen int
+	overlap [][2]string
+}
+
+func (s *scheduleRecorder) run(fail ...string) func(ctx context.Context, rule *Rule) error {
+	return func(ctx context.Context, rule *Rule) error {
+		s.mu.Lock()
+		for name := range s.active {
+			s.overlap = append(s.overlap, [2]string{name, rule.Name})
+		}
+		s.active[rule.Name] = true
+		s.maxSeen = max(s.maxSeen, len(s.active))
+		s.mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		s.mu.Lock()
+		delete(s.active, rule.Name)
+		s.order = append(s.order, rule.Name)
+		s.mu.Unlock()
+		for _, name := range fail {
+			if name == rule.Name {
+				return fmt.Errorf("%s failed", name)
+			}
+		}
+		return nil
+	}
+}
+
+func TestRunRulesConcurrency(t *testing.T) {
+	rules := []*Rule{
+		{Name: "a", Mailbox: "A"},
+		{Name: "b", Mailbox: "B"},
+		{Name: "c", Mailbox: "C"},
+		{Name: "d", Mailbox: "A"},
+	}
+	rec := &scheduleRecorder{active: map[string]bool{}}
+	results, err := RunRules(context.Background(), rules, "INBOX", 2, rec.run())
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, rec.maxSeen)
+	for _, pair := range rec.overlap {
+		assert.NotEqual(t, [2]string{"a", "d"}, pair, "rules on the same mailbox overlapped")
+		assert.NotEqual(t, [2]string{"d", "a"}, pair, "rules on the same mailbox overlapped")
+	}
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+}
+
+func TestRunRulesDependencies(t *testing.T) {
+	rules := []*Rule{
+		{Name: "report", Mailbox: "R", DependsOn: []string{"archive"}},
+		{Name: "archive", Mailbox: "A", DependsOn: []string{"tag"}},
+		{Name: "tag", Mailbox: "T"},
+		{Name: "other", Mailbox: "O", DependsOn: []string{"broken"}},
+		{Name: "broken", Mailbox: "B"},
+	}
+	rec := &scheduleRecorder{active: map[string]bool{}}
+	results, err := RunRules(context.Background(), rules, "INBOX", 4, rec.run("broken"))
+	require.NoError(t, err)
+
+	pos := map[string]int{}
+	for i, name := range rec.order {
+		pos[name] = i
+	}
+	assert.Less(t, pos["tag"], pos["archive"])
+	assert.Less(t, pos["archive"], pos["report"])
+	assert.NotContains(t, rec.order, "other")
+
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[3].Skipped)
+	assert.ErrorContains(t, results[3].Err, "dependency broken failed")
+	assert.False(t, results[4].Skipped)
+	assert.ErrorContains(t, results[4].Err, "broken failed")
+}
@@ -32,6 +32,7 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/rs/zerolog/log"
 )
 
@@ -56,7 +57,18 @@ func BuildSearchCriteria(config SearchConfig, outputConfig *OutputConfig) (*imap
 			}
 		}
 
-		return buildComplexSearchCriteria(config, outputConfig)
+		criteria, options, err := buildComplexSearchCriteria(config, outputConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Address lists, list and missing header criteria apply on top of the
+		// operator's conditions
+		addListCriteria(criteria, config)
+		addMissingHeaderCriteria(criteria, config)
+		if err := addAddressListCriteria(criteria, config); err != nil {
+			return nil, nil, err
+		}
+		return criteria, options, nil
 	}
 
 	// Process date criteria
@@ -143,10 +155,22 @@ func BuildSearchCriteria(config SearchConfig, outputConfig *OutputConfig) (*imap
 	}
 
 	if config.Header != nil && config.Header.Name != "" {
-		criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{
+		field := imap.SearchCriteriaHeaderField{
 			Key:   config.Header.Name,
 			Value: config.Header.Value,
-		})
+		}
+		if config.Header.Absent {
+			criteria.Not = append(criteria.Not, imap.SearchCriteria{Header: []imap.SearchCriteriaHeaderField{field}})
+		} else {
+			criteria.Header = append(criteria.Header, field)
+		}
+	}
+
+	addListCriteria(criteria, config)
+	addMissingHeaderCriteria(criteria, config)
+
+	if err := addAddressListCriteria(criteria, config); err != nil {
+		return nil, nil, err
 	}
 
 	// Process content-based search criteria
@@ -177,6 +201,36 @@ func BuildSearchCriteria(config SearchConfig, outputConfig *OutputConfig) (*imap
 		}
 	}
 
+	// Keywords are sent as they are, without the flag name conversion
+	if config.Keywords != nil {
+		for _, keyword := range config.Keywords.Has {
+			criteria.Flag = append(criteria.Flag, imap.Flag(keyword))
+		}
+		for _, keyword := range config.Keywords.NotHas {
+			criteria.NotFlag = append(criteria.NotFlag, imap.Flag(keyword))
+		}
+	}
+
+	// Process flag shorthands
+	for _, shorthand := range []struct {
+		value *bool
+		flag  imap.Flag
+		want  bool
+	}{
+		{config.Unread, imap.FlagSeen, false},
+		{config.Answered, imap.FlagAnswered, true},
+		{config.Flagged, imap.FlagFlagged, true},
+	} {
+		if shorthand.value == nil {
+			continue
+		}
+		if *shorthand.value == shorthand.want {
+			criteria.Flag = append(criteria.Flag, shorthand.flag)
+		} else {
+			criteria.NotFlag = append(criteria.NotFlag, shorthand.flag)
+		}
+	}
+
 	// Process size-based search criteria
 	if config.Size != nil {
 		if config.Size.LargerThan != "" {
@@ -240,6 +294,39 @@ func BuildSearchCriteria(config SearchConfig, outputConfig *OutputConfig) (*imap
 	return criteria, options, nil
 }
 
+// addMissingHeaderCriteria adds a NOT HEADER condition for each
+// header_missing name. An empty HEADER value matches any message that has
+// the header.
+func addMissingHeaderCriteria(criteria *imap.SearchCriteria, config SearchConfig) {
+	for _, name := range config.HeaderMissing {
+		criteria.Not = append(criteria.Not, imap.SearchCriteria{
+			Header: []imap.SearchCriteriaHeaderField{{Key: name}},
+		})
+	}
+}
+
+// SearchUIDs runs a UID SEARCH for config on the selected mailbox and returns
+// the matching UIDs. Output-related options (limit, UID ranges) are not applied,
+// nor are the client-side search.expr and large address list filters.
+// client_side_body is ignored, the server searches the body; body_regex is
+// rejected.
+func SearchUIDs(client *imapclient.Client, config SearchConfig) ([]imap.UID, error) {
+	if config.BodyRegex != "" {
+		return nil, fmt.Errorf("body_regex is only supported when running rules")
+	}
+	criteria, _, err := BuildSearchCriteria(config, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search criteria: %w", err)
+	}
+
+	data, err := client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute search: %w", wrapSearchError(err))
+	}
+
+	return data.AllUIDs(), nil
+}
+
 // buildComplexSearchCriteria handles the conversion of complex nested conditions
 func buildComplexSearchCriteria(config SearchConfig, outputConfig *OutputConfig) (*imap.SearchCriteria, *imap.SearchOptions, error) {
 	options := &imap.SearchOptions{}
@@ -301,56 +388,41 @@ func buildAndCondition(conditions []ComplexSearchConfig, outputConfig *OutputCon
 	return mainCriteria, options, nil
 }
 
-// buildOrCondition creates a criteria with OR logic for multiple conditions
+// buildOrCondition creates a criteria with OR logic for multiple conditions.
+// IMAP OR takes exactly two keys, so n conditions become the right-nested
+// chain OR c1 (OR c2 (... (OR cn-1 cn))).
 func buildOrCondition(conditions []ComplexSearchConfig, outputConfig *OutputConfig) (*imap.SearchCriteria, *imap.SearchOptions, error) {
 	if len(conditions) == 0 {
 		return nil, nil, fmt.Errorf("empty conditions list for OR operator")
 	}
 
-	// Handle special case of a single condition
+	// Options come from the first condition, like in buildAndCondition
+	first, options, err := buildSingleCondition(conditions[0], outputConfig)
+	if err != nil {
+		return nil, nil, err
+	}
 	if len(conditions) == 1 {
-		return buildSingleCondition(conditions[0], outputConfig)
+		return first, options, nil
 	}
 
-	// Create the result criteria
-	resultCriteria := &imap.SearchCriteria{}
-	var options *imap.SearchOptions
-
-	// Process each pair of conditions and create OR operations
-	for i := 0; i < len(conditions); i += 2 {
-		// Get first condition
-		c1, opts, err := buildSingleCondition(conditions[i], nil)
+	criteria := make([]*imap.SearchCriteria, len(conditions))
+	criteria[0] = first
+	for i := 1; i < len(conditions); i++ {
+		criteria[i], _, err = buildSingleCondition(conditions[i], nil)
 		if err != nil {
 			return nil, nil, err
 		}
+	}
 
-		// Save options from first iteration
-		if i == 0 && outputConfig != nil {
-			options = opts
-		}
-
-		// If we have an odd number of conditions and this is the last one
-		if i == len(conditions)-1 {
-			// Handle special case: last single condition in odd-length list
-			// Create an empty criteria for the second part of the OR pair
-			emptyC2 := imap.SearchCriteria{}
-			orPair := [2]imap.SearchCriteria{*c1, emptyC2}
-			resultCriteria.Or = append(resultCriteria.Or, orPair)
-			continue
-		}
-
-		// Get second condition
-		c2, _, err := buildSingleCondition(conditions[i+1], nil)
-		if err != nil {
-			return nil, nil, err
+	// Fold from the right: the last two conditions form the innermost pair
+	result := criteria[len(criteria)-1]
+	for i := len(criteria) - 2; i >= 0; i-- {
+		result = &imap.SearchCriteria{
+			Or: [][2]imap.SearchCriteria{{*criteria[i], *result}},
 		}
-
-		// Create OR pair
-		orPair := [2]imap.SearchCriteria{*c1, *c2}
-		resultCriteria.Or = append(resultCriteria.Or, orPair)
 	}
 
-	return resultCriteria, options, nil
+	return result, options, nil
 }
 
 // buildNotCondition creates a criteria with NOT logic for a condition
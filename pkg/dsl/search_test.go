@@ -6,6 +6,7 @@ import (
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestBuildComplexSearchCriteria(t *testing.T) {
@@ -327,3 +328,128 @@ func TestComplexSearchConfigValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestFlagShorthands(t *testing.T) {
+	yes, no := true, false
+
+	criteria, _, err := BuildSearchCriteria(SearchConfig{Unread: &yes, Answered: &no, Flagged: &yes}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []imap.Flag{imap.FlagFlagged}, criteria.Flag)
+	assert.Equal(t, []imap.Flag{imap.FlagSeen, imap.FlagAnswered}, criteria.NotFlag)
+
+	criteria, _, err = BuildSearchCriteria(SearchConfig{Unread: &no}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []imap.Flag{imap.FlagSeen}, criteria.Flag)
+	assert.Empty(t, criteria.NotFlag)
+}
+
+func TestMissingHeaderCriteria(t *testing.T) {
+	criteria, _, err := BuildSearchCriteria(SearchConfig{
+		Header:        &HeaderCriteria{Name: "DKIM-Signature", Absent: true},
+		HeaderMissing: []string{"List-Unsubscribe"},
+	}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, criteria.Header)
+	require.Len(t, criteria.Not, 2)
+	assert.Equal(t, []imap.SearchCriteriaHeaderField{{Key: "DKIM-Signature"}}, criteria.Not[0].Header)
+	assert.Equal(t, []imap.SearchCriteriaHeaderField{{Key: "List-Unsubscribe"}}, criteria.Not[1].Header)
+
+	messages := readSampleMbox(t)
+	tests := []struct {
+		name   string
+		search SearchConfig
+		want   []uint32
+	}{
+		{"missing list headers", SearchConfig{HeaderMissing: []string{"List-Id", "List-Unsubscribe"}}, []uint32{1, 3}},
+		{"absent", SearchConfig{Header: &HeaderCriteria{Name: "List-Id", Absent: true}}, []uint32{1, 3}},
+		{"in a condition", SearchConfig{Operator: OperatorOr, Conditions: []ComplexSearchConfig{
+			{SearchConfig: SearchConfig{HeaderMissing: []string{"List-Id"}, SubjectContains: "invoice"}},
+			{SearchConfig: SearchConfig{SubjectContains: "digest"}},
+		}}, []uint32{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := &Rule{Name: tt.name, Search: tt.search}
+			var got []uint32
+			for _, msg := range messages {
+				ok, err := rule.MatchLocal(msg)
+				require.NoError(t, err)
+				if ok {
+					got = append(got, msg.Message.UID)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.ErrorContains(t, (&SearchConfig{Header: &HeaderCriteria{Name: "X", Value: "y", Absent: true}}).Validate(), "cannot be combined with absent")
+	assert.ErrorContains(t, (&SearchConfig{HeaderMissing: []string{"DKIM-Signature:"}}).Validate(), "invalid header name")
+}
+
+func TestCompileSieveMissingHeaders(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: unsigned
+search:
+  header:
+    name: DKIM-Signature
+    absent: true
+  header_missing: [List-Unsubscribe]
+output:
+  fields: [uid]
+actions:
+  move_to: Suspicious
+`)
+	require.NoError(t, err)
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Contains(t, script, `not exists "DKIM-Signature"`)
+	assert.Contains(t, script, `not exists "List-Unsubscribe"`)
+}
+
+func TestBuildOrConditionNAry(t *testing.T) {
+	from := func(values ...string) []ComplexSearchConfig {
+		conditions := make([]ComplexSearchConfig, len(values))
+		for i, value := range values {
+			conditions[i] = ComplexSearchConfig{SearchConfig: SearchConfig{From: value}}
+		}
+		return conditions
+	}
+	messages := readSampleMbox(t)
+
+	tests := []struct {
+		name       string
+		conditions []ComplexSearchConfig
+		matches    []uint32
+	}{
+		{"3-way", from("alice", "carol", "nobody"), []uint32{1, 3}},
+		{"3-way matching the last", from("nobody", "nobody-else", "carol"), []uint32{3}},
+		{"4-way", from("nobody", "news", "nobody-else", "alice"), []uint32{1, 2}},
+		{"5-way", from("a1", "a2", "a3", "a4", "carol"), []uint32{3}},
+		{"5-way without matches", from("a1", "a2", "a3", "a4", "a5"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			criteria, _, err := BuildSearchCriteria(SearchConfig{Operator: OperatorOr, Conditions: tt.conditions}, nil)
+			require.NoError(t, err)
+
+			// Walk the right-nested chain: OR c1 (OR c2 (... (OR cn-1 cn)))
+			node := criteria
+			for i, condition := range tt.conditions[:len(tt.conditions)-1] {
+				require.Len(t, node.Or, 1, "level %d", i)
+				assert.Empty(t, node.Header, "level %d", i)
+				assert.Equal(t, []imap.SearchCriteriaHeaderField{{Key: "From", Value: condition.From}}, node.Or[0][0].Header)
+				node = &node.Or[0][1]
+			}
+			assert.Empty(t, node.Or)
+			assert.Equal(t, []imap.SearchCriteriaHeaderField{{Key: "From", Value: tt.conditions[len(tt.conditions)-1].From}}, node.Header)
+
+			var got []uint32
+			for _, msg := range messages {
+				if MatchCriteria(criteria, msg) {
+					got = append(got, msg.Message.SeqNum)
+				}
+			}
+			assert.Equal(t, tt.matches, got)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SenderStat counts the messages of a sender domain in one time bucket.
+type SenderStat struct {
+	Domain string
+	// Bucket is the start of the bucket: a day, a Monday or the first of a
+	// month, in UTC
+	Bucket   time.Time
+	Messages int
+	Bytes    uint64
+	// Senders counts the distinct sender addresses of the domain
+	Senders int
+	// Change is the difference to the messages of the domain in the previous
+	// bucket, which counts as 0 when the domain sent nothing then
+	Change int
+}
+
+// ValidateBucket checks a bucket size: day, week or month.
+func ValidateBucket(bucket string) error {
+	if windowIndex(bucket) < 0 {
+		return fmt.Errorf("bucket must be day, week or month, got %q", bucket)
+	}
+	return nil
+}
+
+// WindowDays converts a report window such as 90d, 12w or 720h to whole
+// days, rounding up, for SearchConfig.WithinDays.
+func WindowDays(window string) (int, error) {
+	d, err := parseRelativeDuration(window)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window: %w", err)
+	}
+	day := 24 * time.Hour
+	return int((d + day - 1) / day), nil
+}
+
+// bucketStart returns the start of the bucket t falls in. Weeks start on
+// Mondays, like the windows of chunked searches.
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch bucket {
+	case WindowMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case WindowWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	default:
+		return day
+	}
+}
+
+func previousBucket(start time.Time, bucket string) time.Time {
+	switch bucket {
+	case WindowMonth:
+		return start.AddDate(0, -1, 0)
+	case WindowWeek:
+		return start.AddDate(0, 0, -7)
+	default:
+		return start.AddDate(0, 0, -1)
+	}
+}
+
+// senderDomain returns the lowercased domain of the first From address.
+func senderDomain(msg *EmailMessage) (string, string, bool) {
+	if msg.Envelope == nil || len(msg.Envelope.From) == 0 {
+		return "", "", false
+	}
+	address := strings.ToLower(msg.Envelope.From[0].Address)
+	at := strings.LastIndex(address, "@")
+	if at < 0 || at == len(address)-1 {
+		return "", "", false
+	}
+	return address[at+1:], address, true
+}
+
+// CollectSenderStats buckets messages by the domain of their sender and the
+// Date header. Stats are ordered by bucket, then by messages, most first.
+// With top > 0, only the top domains by total messages are kept. Messages
+// without a sender address or date are skipped.
+func CollectSenderStats(messages []*EmailMessage, bucket string, top int) []*SenderStat {
+	type key struct {
+		domain string
+		bucket time.Time
+	}
+	stats := make(map[key]*SenderStat)
+	senders := make(map[key]map[string]bool)
+	totals := make(map[string]int)
+	for _, msg := range messages {
+		domain, address, ok := senderDomain(msg)
+		if !ok || msg.Envelope.Date.IsZero() {
+			continue
+		}
+		k := key{domain: domain, bucket: bucketStart(msg.Envelope.Date, bucket)}
+		stat, ok := stats[k]
+		if !ok {
+			stat = &SenderStat{Domain: domain, Bucket: k.bucket}
+			stats[k] = stat
+			senders[k] = make(map[string]bool)
+		}
+		stat.Messages++
+		stat.Bytes += uint64(msg.Size)
+		senders[k][address] = true
+		totals[domain]++
+	}
+
+	keep := func(string) bool { return true }
+	if top > 0 && len(totals) > top {
+		domains := make([]string, 0, len(totals))
+		for domain := range totals {
+			domains = append(domains, domain)
+		}
+		sort.Slice(domains, func(i, j int) bool {
+			if totals[domains[i]] != totals[domains[j]] {
+				return totals[domains[i]] > totals[domains[j]]
+			}
+			return domains[i] < domains[j]
+		})
+		kept := make(map[string]bool, top)
+		for _, domain := range domains[:top] {
+			kept[domain] = true
+		}
+		keep = func(domain string) bool { return kept[domain] }
+	}
+
+	ret := make([]*SenderStat, 0, len(stats))
+	for k, stat := range stats {
+		if !keep(k.domain) {
+			continue
+		}
+		stat.Senders = len(senders[k])
+		stat.Change = stat.Messages
+		if prev, ok := stats[key{domain: k.domain, bucket: previousBucket(k.bucket, bucket)}]; ok {
+			stat.Change -= prev.Messages
+		}
+		ret = append(ret, stat)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if !a.Bucket.Equal(b.Bucket) {
+			return a.Bucket.Before(b.Bucket)
+		}
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Domain < b.Domain
+	})
+	return ret
+}
@@ -0,0 +1,53 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectSenderStats(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 9, 0, 0, 0, time.UTC) }
+	message := func(date time.Time, from string, size uint32) *EmailMessage {
+		return &EmailMessage{Size: size, Envelope: &EmailEnvelope{Date: date, From: []EmailAddress{{Address: from}}}}
+	}
+	messages := []*EmailMessage{
+		message(day(3), "a@x.com", 100),
+		message(day(5), "B@X.com", 200),
+		message(day(4), "c@y.com", 50),
+		message(day(10), "a@x.com", 300),
+		message(day(1), "z@z.com", 10),
+		{Size: 5, Envelope: &EmailEnvelope{Date: day(2)}},
+	}
+
+	stats := CollectSenderStats(messages, WindowWeek, 0)
+	require.Len(t, stats, 4)
+	assert.Equal(t, &SenderStat{Domain: "z.com", Bucket: time.Date(2025, 2, 24, 0, 0, 0, 0, time.UTC), Messages: 1, Bytes: 10, Senders: 1, Change: 1}, stats[0])
+	assert.Equal(t, &SenderStat{Domain: "x.com", Bucket: time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), Messages: 2, Bytes: 300, Senders: 2, Change: 2}, stats[1])
+	assert.Equal(t, "y.com", stats[2].Domain)
+	assert.Equal(t, &SenderStat{Domain: "x.com", Bucket: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), Messages: 1, Bytes: 300, Senders: 1, Change: -1}, stats[3])
+
+	top := CollectSenderStats(messages, WindowWeek, 1)
+	require.Len(t, top, 2)
+	assert.Equal(t, "x.com", top[0].Domain)
+	assert.Equal(t, "x.com", top[1].Domain)
+
+	monthly := CollectSenderStats(messages, WindowMonth, 0)
+	require.Len(t, monthly, 3)
+	assert.Equal(t, "x.com", monthly[0].Domain)
+	assert.Equal(t, 3, monthly[0].Messages)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), monthly[0].Bucket)
+}
+
+func TestWindowDays(t *testing.T) {
+	for window, want := range map[string]int{"90d": 90, "2w": 14, "36h": 2} {
+		days, err := WindowDays(window)
+		require.NoError(t, err, window)
+		assert.Equal(t, want, days, window)
+	}
+	_, err := WindowDays("soon")
+	assert.Error(t, err)
+	assert.Error(t, ValidateBucket("year"))
+}
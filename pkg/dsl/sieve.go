@@ -0,0 +1,444 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sieveScript collects the tests, actions and extensions of a compiled
+// script.
+type sieveScript struct {
+	requires map[string]bool
+	lines    []string
+}
+
+// CompileSieve translates rules into a Sieve script (RFC 5228) that performs
+// their actions at delivery time. Search criteria become tests and the
+// flags, copy_to, move_to, delete and stop actions become
+// addflag/removeflag, fileinto, discard and stop. Rules using anything without a Sieve equivalent
+// (expr, within_days, templated mailboxes, export, snooze, follow_up, custom
+// actions) are rejected rather than compiled into something that behaves
+// differently. Rules are compiled in priority order.
+func CompileSieve(rules ...*Rule) (string, error) {
+	script := &sieveScript{requires: make(map[string]bool)}
+	rules = append([]*Rule(nil), rules...)
+	SortRulesByPriority(rules)
+	for _, rule := range rules {
+		if err := script.addRule(rule); err != nil {
+			return "", fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by smailnail\n")
+	if len(script.requires) > 0 {
+		exts := make([]string, 0, len(script.requires))
+		for ext := range script.requires {
+			exts = append(exts, sieveQuote(ext))
+		}
+		sort.Strings(exts)
+		_, _ = fmt.Fprintf(&b, "require [%s];\n", strings.Join(exts, ", "))
+	}
+	for _, line := range script.lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+func (s *sieveScript) addRule(rule *Rule) error {
+	if rule.Decrypt != nil {
+		return fmt.Errorf("decrypt cannot be compiled to Sieve")
+	}
+	if rule.Classify != nil {
+		return fmt.Errorf("classify cannot be compiled to Sieve")
+	}
+	test, err := s.searchTest(rule.Search)
+	if err != nil {
+		return err
+	}
+	actions, err := s.actions(&rule.Actions)
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		return fmt.Errorf("no actions to compile")
+	}
+
+	s.lines = append(s.lines, "")
+	s.lines = append(s.lines, "# "+rule.Name)
+	if rule.Description != "" {
+		s.lines = append(s.lines, "# "+strings.ReplaceAll(strings.TrimSpace(rule.Description), "\n", "\n# "))
+	}
+	s.lines = append(s.lines, fmt.Sprintf("if %s {", test))
+	for _, action := range actions {
+		s.lines = append(s.lines, "  "+action)
+	}
+	s.lines = append(s.lines, "}")
+	return nil
+}
+
+// searchTest compiles a search config into a single Sieve test. Like
+// BuildSearchCriteria, an operator with conditions replaces the flat fields.
+func (s *sieveScript) searchTest(config SearchConfig) (string, error) {
+	if config.Operator != "" {
+		if len(config.Conditions) == 0 {
+			return "", fmt.Errorf("operator '%s' requires at least one condition", config.Operator)
+		}
+		tests := make([]string, 0, len(config.Conditions))
+		for _, condition := range config.Conditions {
+			test, err := s.searchTest(condition.SearchConfig)
+			if err != nil {
+				return "", err
+			}
+			tests = append(tests, test)
+		}
+		switch config.Operator {
+		case OperatorAnd:
+			return sieveAllOf(tests), nil
+		case OperatorOr:
+			if len(tests) == 1 {
+				return tests[0], nil
+			}
+			return fmt.Sprintf("anyof(%s)", strings.Join(tests, ", ")), nil
+		case OperatorNot:
+			if len(tests) > 1 {
+				return "", fmt.Errorf("operator 'not' can only have one condition, but %d were provided", len(tests))
+			}
+			return "not " + tests[0], nil
+		default:
+			return "", fmt.Errorf("unsupported operator: %s", config.Operator)
+		}
+	}
+
+	if config.Expr != "" {
+		return "", fmt.Errorf("search.expr cannot be compiled to Sieve")
+	}
+	if config.searchesAttachments() {
+		return "", fmt.Errorf("attachment criteria cannot be compiled to Sieve")
+	}
+	if config.searchesRecipients() {
+		return "", fmt.Errorf("recipient criteria cannot be compiled to Sieve")
+	}
+	if len(config.Language) > 0 {
+		return "", fmt.Errorf("language cannot be compiled to Sieve")
+	}
+	if config.BodyRegex != "" {
+		return "", fmt.Errorf("body_regex cannot be compiled to Sieve")
+	}
+	if config.WithinDays > 0 {
+		return "", fmt.Errorf("within_days is relative to the run time and cannot be compiled to Sieve")
+	}
+
+	var tests []string
+
+	dateTest := func(match, comparator, value string) error {
+		date, err := parseDate(value)
+		if err != nil {
+			return err
+		}
+		s.requires["date"] = true
+		if comparator != "" {
+			s.requires["relational"] = true
+			match = fmt.Sprintf(":value %s", sieveQuote(comparator))
+		}
+		tests = append(tests, fmt.Sprintf("date %s \"date\" \"date\" %s", match, sieveQuote(date.Format("2006-01-02"))))
+		return nil
+	}
+	if config.Since != "" {
+		if err := dateTest("", "ge", config.Since); err != nil {
+			return "", fmt.Errorf("invalid 'since' date: %w", err)
+		}
+	}
+	if config.Before != "" {
+		if err := dateTest("", "lt", config.Before); err != nil {
+			return "", fmt.Errorf("invalid 'before' date: %w", err)
+		}
+	}
+	if config.On != "" {
+		if err := dateTest(":is", "", config.On); err != nil {
+			return "", fmt.Errorf("invalid 'on' date: %w", err)
+		}
+	}
+
+	headerTest := func(name, value string) {
+		tests = append(tests, fmt.Sprintf("header :contains %s %s", sieveQuote(name), sieveQuote(value)))
+	}
+	if config.From != "" {
+		headerTest("from", config.From)
+	}
+	if config.To != "" {
+		headerTest("to", config.To)
+	}
+	if config.Cc != "" {
+		headerTest("cc", config.Cc)
+	}
+	if config.Bcc != "" {
+		headerTest("bcc", config.Bcc)
+	}
+	if config.Subject != "" {
+		headerTest("subject", config.Subject)
+	}
+	if config.SubjectContains != "" {
+		headerTest("subject", config.SubjectContains)
+	}
+	if config.FromInFile != "" {
+		test, err := sieveAddressListTest(config.FromInFile)
+		if err != nil {
+			return "", fmt.Errorf("invalid 'from_in_file': %w", err)
+		}
+		tests = append(tests, test)
+	}
+	if config.FromNotInFile != "" {
+		test, err := sieveAddressListTest(config.FromNotInFile)
+		if err != nil {
+			return "", fmt.Errorf("invalid 'from_not_in_file': %w", err)
+		}
+		tests = append(tests, "not "+test)
+	}
+	if config.Header != nil {
+		switch {
+		case config.Header.Absent:
+			tests = append(tests, fmt.Sprintf("not exists %s", sieveQuote(config.Header.Name)))
+		case config.Header.Value == "":
+			tests = append(tests, fmt.Sprintf("exists %s", sieveQuote(config.Header.Name)))
+		default:
+			headerTest(config.Header.Name, config.Header.Value)
+		}
+	}
+	for _, name := range config.HeaderMissing {
+		tests = append(tests, fmt.Sprintf("not exists %s", sieveQuote(name)))
+	}
+
+	if config.IsMailingList != nil {
+		test := fmt.Sprintf("anyof (exists %s, exists %s)", sieveQuote(listHeaders[0]), sieveQuote(listHeaders[1]))
+		if !*config.IsMailingList {
+			test = "not " + test
+		}
+		tests = append(tests, test)
+	}
+	if config.ListID != "" {
+		headerTest("List-Id", config.ListID)
+	}
+
+	if config.BodyContains != "" {
+		s.requires["body"] = true
+		tests = append(tests, fmt.Sprintf("body :text :contains %s", sieveQuote(config.BodyContains)))
+	}
+	if config.Text != "" {
+		// TEXT searches the headers and the body
+		s.requires["body"] = true
+		tests = append(tests, fmt.Sprintf("anyof(header :contains [\"from\", \"to\", \"cc\", \"subject\"] %s, body :text :contains %s)",
+			sieveQuote(config.Text), sieveQuote(config.Text)))
+	}
+
+	var has, notHas []string
+	if config.Flags != nil {
+		has = append(has, config.Flags.Has...)
+		notHas = append(notHas, config.Flags.NotHas...)
+	}
+	for flag, value := range map[string]*bool{"seen": invertBool(config.Unread), "answered": config.Answered, "flagged": config.Flagged} {
+		if value == nil {
+			continue
+		}
+		if *value {
+			has = append(has, flag)
+		} else {
+			notHas = append(notHas, flag)
+		}
+	}
+	sort.Strings(has)
+	sort.Strings(notHas)
+	for _, flag := range has {
+		s.requires["imap4flags"] = true
+		tests = append(tests, fmt.Sprintf("hasflag %s", sieveQuote(convertToIMAPFlag(flag))))
+	}
+	for _, flag := range notHas {
+		s.requires["imap4flags"] = true
+		tests = append(tests, fmt.Sprintf("not hasflag %s", sieveQuote(convertToIMAPFlag(flag))))
+	}
+	if config.Keywords != nil {
+		for _, keyword := range config.Keywords.Has {
+			s.requires["imap4flags"] = true
+			tests = append(tests, fmt.Sprintf("hasflag %s", sieveQuote(keyword)))
+		}
+		for _, keyword := range config.Keywords.NotHas {
+			s.requires["imap4flags"] = true
+			tests = append(tests, fmt.Sprintf("not hasflag %s", sieveQuote(keyword)))
+		}
+	}
+
+	if config.Size != nil {
+		if config.Size.LargerThan != "" {
+			size, err := parseSize(config.Size.LargerThan)
+			if err != nil {
+				return "", fmt.Errorf("invalid 'larger_than' size: %w", err)
+			}
+			tests = append(tests, fmt.Sprintf("size :over %d", size))
+		}
+		if config.Size.SmallerThan != "" {
+			size, err := parseSize(config.Size.SmallerThan)
+			if err != nil {
+				return "", fmt.Errorf("invalid 'smaller_than' size: %w", err)
+			}
+			tests = append(tests, fmt.Sprintf("size :under %d", size))
+		}
+	}
+
+	return sieveAllOf(tests), nil
+}
+
+// actions compiles the rule's actions in the order executeActions runs
+// them.
+func (s *sieveScript) actions(actions *ActionConfig) ([]string, error) {
+	switch {
+	case actions.Export != nil:
+		return nil, fmt.Errorf("the export action cannot be compiled to Sieve")
+	case actions.Snooze != nil:
+		return nil, fmt.Errorf("the snooze action cannot be compiled to Sieve")
+	case actions.FollowUp != nil:
+		return nil, fmt.Errorf("the follow_up action cannot be compiled to Sieve")
+	case actions.Unsubscribe != nil:
+		return nil, fmt.Errorf("the unsubscribe action cannot be compiled to Sieve")
+	case actions.Notify != nil:
+		return nil, fmt.Errorf("the notify action cannot be compiled to Sieve")
+	case actions.IndexElasticsearch != nil:
+		return nil, fmt.Errorf("the index_elasticsearch action cannot be compiled to Sieve")
+	case actions.Publish != nil:
+		return nil, fmt.Errorf("the publish action cannot be compiled to Sieve")
+	case actions.Database != nil:
+		return nil, fmt.Errorf("the database action cannot be compiled to Sieve")
+	case actions.CreateIssue != nil:
+		return nil, fmt.Errorf("the create_issue action cannot be compiled to Sieve")
+	case len(actions.ByLabel) > 0:
+		return nil, fmt.Errorf("by_label actions cannot be compiled to Sieve")
+	case len(actions.Custom) > 0:
+		names := make([]string, 0, len(actions.Custom))
+		for name := range actions.Custom {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("custom actions cannot be compiled to Sieve: %s", strings.Join(names, ", "))
+	case isTemplate(actions.CopyTo) || isTemplate(actions.MoveTo):
+		return nil, fmt.Errorf("templated mailboxes cannot be compiled to Sieve")
+	}
+
+	var lines []string
+	if actions.Flags != nil {
+		if len(actions.Flags.Add) > 0 {
+			s.requires["imap4flags"] = true
+			lines = append(lines, fmt.Sprintf("addflag %s;", sieveFlagList(actions.Flags.Add)))
+		}
+		if len(actions.Flags.Remove) > 0 {
+			s.requires["imap4flags"] = true
+			lines = append(lines, fmt.Sprintf("removeflag %s;", sieveFlagList(actions.Flags.Remove)))
+		}
+	}
+	if actions.CopyTo != "" {
+		s.requires["fileinto"] = true
+		s.requires["copy"] = true
+		lines = append(lines, fmt.Sprintf("fileinto :copy %s;", sieveQuote(actions.CopyTo)))
+	}
+	switch {
+	case actions.MoveTo != "":
+		// Moving ends the action list, a delete after it is never reached
+		s.requires["fileinto"] = true
+		lines = append(lines, fmt.Sprintf("fileinto %s;", sieveQuote(actions.MoveTo)))
+	case actions.Delete != nil:
+		trash, deleted, err := sieveDelete(actions.Delete)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case trash:
+			s.requires["fileinto"] = true
+			lines = append(lines, "fileinto \"Trash\";")
+		case deleted:
+			lines = append(lines, "discard;")
+		}
+	}
+	if actions.Stop {
+		lines = append(lines, "stop;")
+	}
+	return lines, nil
+}
+
+// sieveDelete reads the delete action the way executeDelete does.
+func sieveDelete(deleteConfig interface{}) (trash bool, deleted bool, err error) {
+	switch config := deleteConfig.(type) {
+	case bool:
+		return false, config, nil
+	case map[string]interface{}:
+		trash, _ := config["trash"].(bool)
+		return trash, true, nil
+	case DeleteConfig:
+		return config.Trash, true, nil
+	default:
+		return false, false, fmt.Errorf("invalid delete configuration type: %T", deleteConfig)
+	}
+}
+
+func sieveAllOf(tests []string) string {
+	switch len(tests) {
+	case 0:
+		return "true"
+	case 1:
+		return tests[0]
+	default:
+		return fmt.Sprintf("allof(%s)", strings.Join(tests, ", "))
+	}
+}
+
+// sieveAddressListTest matches the From address against an address list.
+// Sieve handles long lists fine, so they are always inlined.
+func sieveAddressListTest(path string) (string, error) {
+	list, err := LoadAddressList(path)
+	if err != nil {
+		return "", err
+	}
+	var tests []string
+	if len(list.Addresses) > 0 {
+		tests = append(tests, fmt.Sprintf("address :all :is \"from\" %s", sieveStringList(list.Addresses)))
+	}
+	if len(list.Domains) > 0 {
+		tests = append(tests, fmt.Sprintf("address :domain :is \"from\" %s", sieveStringList(list.Domains)))
+	}
+	if len(tests) == 1 {
+		return tests[0], nil
+	}
+	return fmt.Sprintf("anyof(%s)", strings.Join(tests, ", ")), nil
+}
+
+func sieveStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = sieveQuote(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func sieveFlagList(flags []string) string {
+	if len(flags) == 1 {
+		return sieveQuote(convertToIMAPFlag(flags[0]))
+	}
+	quoted := make([]string, len(flags))
+	for i, flag := range flags {
+		quoted[i] = sieveQuote(convertToIMAPFlag(flag))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// sieveQuote returns s as a Sieve quoted string, where only backslash and
+// double quote are escaped.
+func sieveQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func invertBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	inverted := !*b
+	return &inverted
+}
@@ -0,0 +1,186 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileSieve(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: newsletters
+description: File newsletters away
+search:
+  from: news@example.com
+  subject_contains: 'Weekly "digest"'
+  unread: true
+  size:
+    larger_than: 1K
+output:
+  fields: [uid]
+actions:
+  flags:
+    add: [flagged, $Newsletter]
+  move_to: Newsletters
+`)
+	require.NoError(t, err)
+
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by smailnail
+require ["fileinto", "imap4flags"];
+
+# newsletters
+# File newsletters away
+if allof(header :contains "from" "news@example.com", header :contains "subject" "Weekly \"digest\"", not hasflag "\\Seen", size :over 1024) {
+  addflag ["\\Flagged", "$Newsletter"];
+  fileinto "Newsletters";
+}
+`, script)
+}
+
+func TestCompileSieveOperators(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: cleanup
+search:
+  operator: or
+  conditions:
+    - from: spam@example.com
+    - operator: not
+      conditions:
+        - header:
+            name: List-Id
+    - since: 2025-01-01
+output:
+  fields: [uid]
+actions:
+  copy_to: Audit
+  delete: true
+`)
+	require.NoError(t, err)
+
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Contains(t, script, `require ["copy", "date", "fileinto", "relational"];`)
+	assert.Contains(t, script, `if anyof(header :contains "from" "spam@example.com", not exists "List-Id", date :value "ge" "date" "date" "2025-01-01") {`)
+	assert.Contains(t, script, "  fileinto :copy \"Audit\";\n  discard;\n")
+}
+
+func TestCompileSieveUnsupported(t *testing.T) {
+	tests := []struct {
+		name   string
+		yaml   string
+		errMsg string
+	}{
+		{"expr", `
+name: r
+search:
+  expr: 'size > 10'
+output:
+  fields: [uid]
+actions:
+  move_to: X
+`, "search.expr"},
+		{"within_days", `
+name: r
+search:
+  within_days: 7
+output:
+  fields: [uid]
+actions:
+  move_to: X
+`, "within_days"},
+		{"export", `
+name: r
+output:
+  fields: [uid]
+actions:
+  export:
+    directory: out
+`, "export action"},
+		{"templated mailbox", `
+name: r
+output:
+  fields: [uid]
+actions:
+  move_to: "Archive/{{ .Date.Year }}"
+`, "templated mailboxes"},
+		{"no actions", `
+name: r
+search:
+  from: a@example.com
+output:
+  fields: [uid]
+`, "no actions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRuleString(tt.yaml)
+			require.NoError(t, err)
+			_, err = CompileSieve(rule)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestCompileSieveAddressLists(t *testing.T) {
+	dir := t.TempDir()
+	rule := &Rule{
+		Name: "vip",
+		Search: SearchConfig{
+			FromInFile:    writeAddressList(t, dir, "vip.txt", "alice@example.com", "partner.org"),
+			FromNotInFile: writeAddressList(t, dir, "blocked.txt", "spam.example.com"),
+		},
+		Actions: ActionConfig{Flags: &FlagActions{Add: []string{"flagged"}}},
+	}
+
+	script, err := CompileSieve(rule)
+	require.NoError(t, err)
+	assert.Contains(t, script, `if allof(anyof(address :all :is "from" ["alice@example.com"], address :domain :is "from" ["partner.org"]), `+
+		`not address :domain :is "from" ["spam.example.com"]) {`)
+}
+
+func TestCompileSieveStop(t *testing.T) {
+	archive, err := ParseRuleString(`
+name: archive
+search:
+  subject_contains: report
+output:
+  fields: [uid]
+actions:
+  move_to: Archive
+`)
+	require.NoError(t, err)
+	vip, err := ParseRuleString(`
+name: vip
+priority: -1
+search:
+  from: boss@example.com
+output:
+  fields: [uid]
+actions:
+  flags:
+    add: [flagged]
+  stop: true
+`)
+	require.NoError(t, err)
+
+	script, err := CompileSieve(archive, vip)
+	require.NoError(t, err)
+	assert.Equal(t, `# Generated by smailnail
+require ["fileinto", "imap4flags"];
+
+# vip
+if header :contains "from" "boss@example.com" {
+  addflag "\\Flagged";
+  stop;
+}
+
+# archive
+if header :contains "subject" "report" {
+  fileinto "Archive";
+}
+`, script)
+}
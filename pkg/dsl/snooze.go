@@ -0,0 +1,427 @@
+package dsl
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults for the snooze action.
+const (
+	DefaultSnoozeFolder   = "Snoozed"
+	DefaultSnoozeReturnTo = "INBOX"
+)
+
+// SnoozeConfig moves messages to a holding folder until a wake time, when
+// WakeSnoozed moves them back and marks them unread.
+type SnoozeConfig struct {
+	// Until is a relative duration ("+3d", "+2h", "+1w") or an absolute date
+	Until    string `yaml:"until"`
+	Folder   string `yaml:"folder,omitempty"`
+	ReturnTo string `yaml:"return_to,omitempty"`
+	// State overrides the path of the snooze state file
+	State string `yaml:"state,omitempty"`
+}
+
+// Validate checks if the snooze config is valid
+func (s *SnoozeConfig) Validate() error {
+	if s.Until == "" {
+		return fmt.Errorf("snooze requires 'until'")
+	}
+	if _, err := s.WakeTime(time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WakeTime resolves Until relative to now.
+func (s *SnoozeConfig) WakeTime(now time.Time) (time.Time, error) {
+	until := strings.TrimSpace(s.Until)
+	if strings.HasPrefix(until, "+") {
+		d, err := parseRelativeDuration(until[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid snooze 'until' %q: %w", s.Until, err)
+		}
+		return now.Add(d), nil
+	}
+	t, err := parseDate(until)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid snooze 'until' %q: %w", s.Until, err)
+	}
+	return t, nil
+}
+
+func (s *SnoozeConfig) folder() string {
+	if s.Folder == "" {
+		return DefaultSnoozeFolder
+	}
+	return s.Folder
+}
+
+func (s *SnoozeConfig) returnTo() string {
+	if s.ReturnTo == "" {
+		return DefaultSnoozeReturnTo
+	}
+	return s.ReturnTo
+}
+
+// parseRelativeDuration accepts Go durations plus day (d) and week (w) units.
+func parseRelativeDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+	unit := s[len(s)-1]
+	if unit == 'd' || unit == 'w' {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		days := n
+		if unit == 'w' {
+			days *= 7
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// SnoozeEntry records a snoozed message. Messages are found again by their
+// Message-ID, or by UID when the server reported it on MOVE (UIDPLUS) and the
+// folder's UIDVALIDITY is unchanged.
+type SnoozeEntry struct {
+	MessageID   string    `json:"message_id,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Folder      string    `json:"folder"`
+	ReturnTo    string    `json:"return_to"`
+	WakeAt      time.Time `json:"wake_at"`
+	Rule        string    `json:"rule,omitempty"`
+	UID         uint32    `json:"uid,omitempty"`
+	UIDValidity uint32    `json:"uid_validity,omitempty"`
+}
+
+// SnoozeStore is the JSON file holding the snoozed messages.
+type SnoozeStore struct {
+	Path    string
+	Entries []SnoozeEntry
+}
+
+// DefaultSnoozeStatePath returns the default snooze state file in
+// DefaultStateDir.
+func DefaultSnoozeStatePath() string {
+	return filepath.Join(DefaultStateDir(), "snoozed.json")
+}
+
+// LoadSnoozeStore reads the store at path. A missing file is an empty store.
+func LoadSnoozeStore(path string) (*SnoozeStore, error) {
+	if path == "" {
+		path = DefaultSnoozeStatePath()
+	}
+	store := &SnoozeStore{Path: path}
+	if err := readStateFile(path, &store.Entries); err != nil {
+		return nil, fmt.Errorf("failed to load snooze state: %w", err)
+	}
+	return store, nil
+}
+
+// Save writes the store atomically.
+func (s *SnoozeStore) Save() error {
+	sort.SliceStable(s.Entries, func(i, j int) bool {
+		return s.Entries[i].WakeAt.Before(s.Entries[j].WakeAt)
+	})
+	if err := writeStateFile(s.Path, s.Entries); err != nil {
+		return fmt.Errorf("failed to save snooze state: %w", err)
+	}
+	return nil
+}
+
+// Due returns the entries whose wake time is not after now.
+func (s *SnoozeStore) Due(now time.Time) []SnoozeEntry {
+	var due []SnoozeEntry
+	for _, entry := range s.Entries {
+		if !entry.WakeAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	return due
+}
+
+// remove drops the entries matching fn.
+func (s *SnoozeStore) remove(fn func(SnoozeEntry) bool) {
+	kept := s.Entries[:0]
+	for _, entry := range s.Entries {
+		if !fn(entry) {
+			kept = append(kept, entry)
+		}
+	}
+	s.Entries = kept
+}
+
+// executeSnooze moves messages to the snooze folder and records their wake
+// time in the state store.
+func executeSnooze(client *imapclient.Client, messages []*EmailMessage, config *SnoozeConfig, rule *Rule) error {
+	logger := rule.Logger()
+	wakeAt, err := config.WakeTime(time.Now())
+	if err != nil {
+		return err
+	}
+	// The store is loaded first so a broken state file fails before the
+	// messages are moved
+	snoozeMu.Lock()
+	_, err = LoadSnoozeStore(config.State)
+	snoozeMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	messageIDs, err := fetchMessageIDs(client, messages)
+	if err != nil {
+		return err
+	}
+
+	folder := rule.MailboxName(config.folder())
+	if err := client.Create(folder, nil).Wait(); err != nil {
+		// Most likely the folder already exists; the move reports real problems
+		logger.Debug().Err(err).Str("folder", folder).Msg("Could not create snooze folder")
+	}
+
+	moveData, err := client.Move(buildUIDSet(messages), folder).Wait()
+	if err != nil {
+		return fmt.Errorf("failed to move messages to %s: %w", folder, wrapMailboxError(err, folder))
+	}
+	destUIDs := moveDestUIDs(moveData)
+
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+	entries := make([]SnoozeEntry, 0, len(messages))
+	for _, msg := range messages {
+		entry := SnoozeEntry{
+			MessageID: messageIDs[msg.UID],
+			Folder:    folder,
+			ReturnTo:  rule.MailboxName(config.returnTo()),
+			WakeAt:    wakeAt,
+			Rule:      ruleName,
+		}
+		if msg.Envelope != nil {
+			entry.Subject = msg.Envelope.Subject
+		}
+		if uid, ok := destUIDs[msg.UID]; ok {
+			entry.UID = uid
+			entry.UIDValidity = moveData.UIDValidity
+		}
+		if entry.MessageID == "" && entry.UID == 0 {
+			logger.Warn().
+				Uint32("uid", msg.UID).
+				Msg("Snoozed message has no Message-ID and the server did not report its new UID, it will not be woken")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	logger.Debug().
+		Str("folder", folder).
+		Time("wake_at", wakeAt).
+		Int("message_count", len(messages)).
+		Msg("Snoozed messages")
+
+	return addSnoozeEntries(config.State, entries)
+}
+
+// snoozeMu serializes the updates of snooze state files by rules running
+// concurrently.
+var snoozeMu sync.Mutex
+
+// addSnoozeEntries adds entries to the snooze state file at path.
+func addSnoozeEntries(path string, entries []SnoozeEntry) error {
+	snoozeMu.Lock()
+	defer snoozeMu.Unlock()
+	store, err := LoadSnoozeStore(path)
+	if err != nil {
+		return err
+	}
+	store.Entries = append(store.Entries, entries...)
+	return store.Save()
+}
+
+// fetchMessageIDs returns the Message-ID of each message by UID, fetching
+// envelopes for messages that were fetched without one.
+func fetchMessageIDs(client *imapclient.Client, messages []*EmailMessage) (map[uint32]string, error) {
+	ids := make(map[uint32]string, len(messages))
+	var missing imap.UIDSet
+	for _, msg := range messages {
+		if msg.Envelope != nil && msg.Envelope.MessageID != "" {
+			ids[msg.UID] = msg.Envelope.MessageID
+			continue
+		}
+		missing.AddNum(imap.UID(msg.UID))
+	}
+	if len(missing) == 0 {
+		return ids, nil
+	}
+
+	fetched, err := client.Fetch(missing, &imap.FetchOptions{UID: true, Envelope: true}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message IDs: %w", err)
+	}
+	for _, buf := range fetched {
+		if buf.Envelope != nil {
+			ids[uint32(buf.UID)] = buf.Envelope.MessageID
+		}
+	}
+	return ids, nil
+}
+
+// moveDestUIDs maps source to destination UIDs from a MOVE response (UIDPLUS).
+func moveDestUIDs(data *imapclient.MoveData) map[uint32]uint32 {
+	ret := make(map[uint32]uint32)
+	if data == nil || data.SourceUIDs == nil || data.DestUIDs == nil {
+		return ret
+	}
+	source, ok := data.SourceUIDs.(imap.UIDSet)
+	if !ok {
+		return ret
+	}
+	dest, ok := data.DestUIDs.(imap.UIDSet)
+	if !ok {
+		return ret
+	}
+	sourceUIDs, ok := source.Nums()
+	if !ok {
+		return ret
+	}
+	destUIDs, ok := dest.Nums()
+	if !ok || len(sourceUIDs) != len(destUIDs) {
+		return ret
+	}
+	for i := range sourceUIDs {
+		ret[uint32(sourceUIDs[i])] = uint32(destUIDs[i])
+	}
+	return ret
+}
+
+// WokenMessage reports a message moved back by WakeSnoozed.
+type WokenMessage struct {
+	Entry SnoozeEntry
+	// Found is false when the message was no longer in the snooze folder
+	Found bool
+}
+
+// WakeSnoozed moves the messages of store that are due at now back to their
+// return mailbox and marks them unread. Entries are removed once handled,
+// including those whose message has disappeared from the snooze folder.
+// The selected mailbox changes; callers have to reselect.
+func WakeSnoozed(client *imapclient.Client, store *SnoozeStore, now time.Time) ([]WokenMessage, error) {
+	due := store.Due(now)
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	byFolder := make(map[string][]SnoozeEntry)
+	var folders []string
+	for _, entry := range due {
+		if _, ok := byFolder[entry.Folder]; !ok {
+			folders = append(folders, entry.Folder)
+		}
+		byFolder[entry.Folder] = append(byFolder[entry.Folder], entry)
+	}
+
+	var woken []WokenMessage
+	handled := make(map[SnoozeEntry]bool)
+	for _, folder := range folders {
+		selectData, err := SelectMailbox(client, folder)
+		if err != nil {
+			return woken, err
+		}
+
+		// Group by return mailbox so each group is a single MOVE
+		byReturn := make(map[string]imap.UIDSet)
+		var returns []string
+		for _, entry := range byFolder[folder] {
+			uid, err := findSnoozedUID(client, entry, selectData.UIDValidity)
+			if err != nil {
+				return woken, err
+			}
+			handled[entry] = true
+			if uid == 0 {
+				log.Warn().
+					Str("folder", folder).
+					Str("message_id", entry.MessageID).
+					Msg("Snoozed message not found, dropping it")
+				woken = append(woken, WokenMessage{Entry: entry})
+				continue
+			}
+			set, ok := byReturn[entry.ReturnTo]
+			if !ok {
+				returns = append(returns, entry.ReturnTo)
+			}
+			set.AddNum(imap.UID(uid))
+			byReturn[entry.ReturnTo] = set
+			woken = append(woken, WokenMessage{Entry: entry, Found: true})
+		}
+
+		for _, returnTo := range returns {
+			uids := byReturn[returnTo]
+			// Flags travel with the message, so mark unread before moving
+			if _, err := client.Store(uids, &imap.StoreFlags{
+				Op:     imap.StoreFlagsDel,
+				Silent: true,
+				Flags:  []imap.Flag{imap.FlagSeen},
+			}, nil).Collect(); err != nil {
+				return woken, fmt.Errorf("failed to mark snoozed messages unread: %w", err)
+			}
+			if _, err := client.Move(uids, returnTo).Wait(); err != nil {
+				return woken, fmt.Errorf("failed to move messages to %s: %w", returnTo, wrapMailboxError(err, returnTo))
+			}
+		}
+	}
+
+	store.remove(func(entry SnoozeEntry) bool { return handled[entry] })
+	return woken, store.Save()
+}
+
+// findSnoozedUID locates entry in the selected snooze folder, returning 0 if
+// it is gone.
+func findSnoozedUID(client *imapclient.Client, entry SnoozeEntry, uidValidity uint32) (uint32, error) {
+	if entry.UID != 0 && entry.UIDValidity == uidValidity {
+		data, err := client.UIDSearch(&imap.SearchCriteria{
+			UID: []imap.UIDSet{imap.UIDSetNum(imap.UID(entry.UID))},
+		}, nil).Wait()
+		if err != nil {
+			return 0, fmt.Errorf("failed to search snoozed message: %w", wrapSearchError(err))
+		}
+		if uids := data.AllUIDs(); len(uids) > 0 {
+			return uint32(uids[0]), nil
+		}
+	}
+	if entry.MessageID == "" {
+		return 0, nil
+	}
+
+	data, err := client.UIDSearch(&imap.SearchCriteria{
+		Header: []imap.SearchCriteriaHeaderField{{Key: "Message-ID", Value: entry.MessageID}},
+	}, nil).Wait()
+	if err != nil {
+		return 0, fmt.Errorf("failed to search snoozed message: %w", wrapSearchError(err))
+	}
+	uids := data.AllUIDs()
+	if len(uids) == 0 {
+		return 0, nil
+	}
+	return uint32(uids[len(uids)-1]), nil
+}
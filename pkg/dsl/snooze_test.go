@@ -0,0 +1,151 @@
+package dsl
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnoozeWakeTime(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		until string
+		want  time.Time
+	}{
+		{"+3d", now.AddDate(0, 0, 3)},
+		{"+1w", now.AddDate(0, 0, 7)},
+		{"+90m", now.Add(90 * time.Minute)},
+		{"2025-04-01", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.until, func(t *testing.T) {
+			got, err := (&SnoozeConfig{Until: tt.until}).WakeTime(now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := (&SnoozeConfig{Until: "+soon"}).WakeTime(now)
+	assert.Error(t, err)
+}
+
+func TestSnoozeActionValidation(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: later
+search:
+  from: newsletter@example.com
+output:
+  fields: [uid]
+actions:
+  snooze:
+    until: "+3d"
+    folder: Later
+`)
+	require.NoError(t, err)
+	require.NotNil(t, rule.Actions.Snooze)
+	assert.Equal(t, "Later", rule.Actions.Snooze.folder())
+	assert.Equal(t, DefaultSnoozeReturnTo, rule.Actions.Snooze.returnTo())
+	assert.NotContains(t, rule.Actions.Custom, "snooze")
+
+	_, err = ParseRuleString(`
+name: both
+output:
+  fields: [uid]
+actions:
+  move_to: Archive
+  snooze:
+    until: "+1d"
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "snooze cannot be combined with move_to")
+}
+
+func TestSnoozeStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "snoozed.json")
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := LoadSnoozeStore(path)
+	require.NoError(t, err)
+	assert.Empty(t, store.Entries)
+
+	store.Entries = append(store.Entries,
+		SnoozeEntry{MessageID: "late@x", Folder: "Snoozed", ReturnTo: "INBOX", WakeAt: now.Add(time.Hour)},
+		SnoozeEntry{MessageID: "due@x", Folder: "Snoozed", ReturnTo: "INBOX", WakeAt: now.Add(-time.Hour)},
+	)
+	require.NoError(t, store.Save())
+
+	loaded, err := LoadSnoozeStore(path)
+	require.NoError(t, err)
+	require.Len(t, loaded.Entries, 2)
+	assert.Equal(t, "due@x", loaded.Entries[0].MessageID, "entries are saved in wake order")
+
+	due := loaded.Due(now)
+	require.Len(t, due, 1)
+	assert.Equal(t, "due@x", due[0].MessageID)
+
+	loaded.remove(func(e SnoozeEntry) bool { return e.MessageID == "due@x" })
+	assert.Len(t, loaded.Entries, 1)
+}
+
+func TestMoveDestUIDs(t *testing.T) {
+	data := &imapclient.MoveData{
+		UIDValidity: 7,
+		SourceUIDs:  imap.UIDSetNum(10, 12),
+		DestUIDs:    imap.UIDSetNum(100, 101),
+	}
+	assert.Equal(t, map[uint32]uint32{10: 100, 12: 101}, moveDestUIDs(data))
+	assert.Empty(t, moveDestUIDs(nil))
+}
+
+func TestAddSnoozeEntriesConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snoozed.json")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := SnoozeEntry{MessageID: fmt.Sprintf("<%d@example.com>", i), Folder: DefaultSnoozeFolder}
+			assert.NoError(t, addSnoozeEntries(path, []SnoozeEntry{entry}))
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := LoadSnoozeStore(path)
+	require.NoError(t, err)
+	assert.Len(t, store.Entries, 20, "no rule loses the entries of another")
+}
+
+// The fixture is hand-written: the server keeps personal mailboxes under
+// INBOX., so the snooze folder is INBOX.Snoozed.
+func TestReplaySnoozeNamespace(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "snoozed.json")
+	rule := &Rule{Name: "later", Actions: ActionConfig{Snooze: &SnoozeConfig{Until: "+1d", State: state}}}
+	rule.SetNamespace(&Namespace{Prefix: "INBOX.", Delimiter: '.'})
+	assert.Equal(t, []string{"INBOX", "INBOX.Snoozed"}, rule.Mailboxes("INBOX"))
+	assert.Contains(t, rule.RequiredRights("INBOX"), RequiredRight{Mailbox: "INBOX.Snoozed", Right: "i", Reason: "snooze"})
+
+	client, done := replayClient(t, "testdata/imap/snooze_namespace.imap")
+	_, err := SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages := []*EmailMessage{{UID: 5, Envelope: &EmailEnvelope{MessageID: "<m5@example.com>"}}}
+	require.NoError(t, executeSnooze(client, messages, rule.Actions.Snooze, rule))
+
+	store, err := LoadSnoozeStore(state)
+	require.NoError(t, err)
+	require.Len(t, store.Entries, 1)
+	assert.Equal(t, "INBOX.Snoozed", store.Entries[0].Folder)
+	assert.Equal(t, "INBOX", store.Entries[0].ReturnTo)
+	assert.Equal(t, uint32(100), store.Entries[0].UID)
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
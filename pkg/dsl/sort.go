@@ -0,0 +1,232 @@
+package dsl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// sortKeys maps output.sort_by keys to their SORT (RFC 5256, RFC 5957) keys.
+var sortKeys = map[string]imapclient.SortKey{
+	"arrival":      imapclient.SortKeyArrival,
+	"date":         imapclient.SortKeyDate,
+	"from":         imapclient.SortKeyFrom,
+	"to":           imapclient.SortKeyTo,
+	"cc":           imapclient.SortKeyCc,
+	"subject":      imapclient.SortKeySubject,
+	"size":         imapclient.SortKeySize,
+	"display_from": "DISPLAYFROM",
+	"display_to":   "DISPLAYTO",
+}
+
+// SortKey is one key of output.sort_by. A leading "-" reverses it.
+type SortKey struct {
+	Name    string
+	Reverse bool
+}
+
+// ParseSortBy parses output.sort_by, a comma separated list of keys such as
+// "from,-date".
+func ParseSortBy(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" {
+			continue
+		}
+		key := SortKey{Name: strings.TrimPrefix(part, "-"), Reverse: strings.HasPrefix(part, "-")}
+		if _, ok := sortKeys[key.Name]; !ok {
+			return nil, fmt.Errorf("unknown sort key: %s", key.Name)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// imapSortCriteria converts keys for the SORT command. Display keys fall back
+// to their address counterparts when the server lacks SORT=DISPLAY.
+func imapSortCriteria(keys []SortKey, caps imap.CapSet) []imapclient.SortCriterion {
+	criteria := make([]imapclient.SortCriterion, 0, len(keys))
+	for _, key := range keys {
+		name := key.Name
+		if strings.HasPrefix(name, "display_") && !caps.Has(imap.CapSortDisplay) {
+			name = strings.TrimPrefix(name, "display_")
+		}
+		criteria = append(criteria, imapclient.SortCriterion{Key: sortKeys[name], Reverse: key.Reverse})
+	}
+	return criteria
+}
+
+// sortSeqNums orders the search results seqNums by output.sort_by, using the
+// server's SORT extension when available and fetching the sort keys to sort
+// client-side otherwise.
+func (rule *Rule) sortSeqNums(client *imapclient.Client, criteria *imap.SearchCriteria, seqNums []uint32) ([]uint32, error) {
+	logger := rule.Logger()
+	keys, err := ParseSortBy(rule.Output.SortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(seqNums) == 0 {
+		return seqNums, nil
+	}
+
+	caps := client.Caps()
+	if caps.Has(imap.CapSort) {
+		sorted, err := client.Sort(&imapclient.SortOptions{
+			SearchCriteria: criteria,
+			SortCriteria:   imapSortCriteria(keys, caps),
+		}).Wait()
+		if err == nil {
+			// SORT runs the search criteria again: it knows nothing of the
+			// client-side body matching or of the date window searches
+			sorted = keepSeqNums(sorted, seqNums)
+			logger.Debug().
+				Int("messages", len(sorted)).
+				Msg("Sorted messages on the server")
+			return sorted, nil
+		}
+		logger.Warn().Err(err).Msg("SORT failed, sorting client-side")
+	}
+
+	var seqSet imap.SeqSet
+	seqSet.AddNum(seqNums...)
+	buffers, err := client.Fetch(seqSet, &imap.FetchOptions{
+		Envelope:     true,
+		InternalDate: true,
+		RFC822Size:   true,
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sort keys: %w", err)
+	}
+
+	records := make([]sortRecord, 0, len(buffers))
+	for _, buf := range buffers {
+		records = append(records, sortRecordFromIMAP(buf))
+	}
+	sortRecords(records, keys)
+
+	sorted := make([]uint32, len(records))
+	for i, record := range records {
+		sorted[i] = record.SeqNum
+	}
+	return sorted, nil
+}
+
+// keepSeqNums returns the sequence numbers of sorted that are in seqNums, in
+// the order of sorted.
+func keepSeqNums(sorted []uint32, seqNums []uint32) []uint32 {
+	keep := make(map[uint32]bool, len(seqNums))
+	for _, seqNum := range seqNums {
+		keep[seqNum] = true
+	}
+	ret := make([]uint32, 0, len(seqNums))
+	for _, seqNum := range sorted {
+		if keep[seqNum] {
+			ret = append(ret, seqNum)
+		}
+	}
+	return ret
+}
+
+// sortRecord holds the values compared by client-side sorting.
+type sortRecord struct {
+	SeqNum      uint32
+	Arrival     time.Time
+	Date        time.Time
+	From        string
+	To          string
+	Cc          string
+	DisplayFrom string
+	DisplayTo   string
+	Subject     string
+	Size        int64
+}
+
+func sortRecordFromIMAP(buf *imapclient.FetchMessageBuffer) sortRecord {
+	record := sortRecord{
+		SeqNum:  buf.SeqNum,
+		Arrival: buf.InternalDate,
+		Size:    buf.RFC822Size,
+	}
+	if env := buf.Envelope; env != nil {
+		record.Date = env.Date
+		record.Subject = normalizeSubject(env.Subject)
+		record.From, record.DisplayFrom = sortAddress(env.From)
+		record.To, record.DisplayTo = sortAddress(env.To)
+		record.Cc, _ = sortAddress(env.Cc)
+	}
+	return record
+}
+
+// sortAddress returns the mailbox and the display name (falling back to the
+// full address) of the first address, lower-cased, as RFC 5256/5957 compare
+// them.
+func sortAddress(addrs []imap.Address) (string, string) {
+	if len(addrs) == 0 {
+		return "", ""
+	}
+	mailbox := strings.ToLower(addrs[0].Mailbox)
+	display := strings.ToLower(addrs[0].Name)
+	if display == "" {
+		display = strings.ToLower(addrs[0].Addr())
+	}
+	return mailbox, display
+}
+
+// sortRecords sorts records by keys. Ties keep their sequence number order.
+func sortRecords(records []sortRecord, keys []SortKey) {
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, key := range keys {
+			c := compareSortRecords(records[i], records[j], key.Name)
+			if key.Reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareSortRecords(a, b sortRecord, key string) int {
+	switch key {
+	case "arrival":
+		return a.Arrival.Compare(b.Arrival)
+	case "date":
+		// Messages without a Date header sort by their arrival time
+		da, db := a.Date, b.Date
+		if da.IsZero() {
+			da = a.Arrival
+		}
+		if db.IsZero() {
+			db = b.Arrival
+		}
+		return da.Compare(db)
+	case "from":
+		return strings.Compare(a.From, b.From)
+	case "to":
+		return strings.Compare(a.To, b.To)
+	case "cc":
+		return strings.Compare(a.Cc, b.Cc)
+	case "display_from":
+		return strings.Compare(a.DisplayFrom, b.DisplayFrom)
+	case "display_to":
+		return strings.Compare(a.DisplayTo, b.DisplayTo)
+	case "subject":
+		return strings.Compare(strings.ToLower(a.Subject), strings.ToLower(b.Subject))
+	case "size":
+		switch {
+		case a.Size < b.Size:
+			return -1
+		case a.Size > b.Size:
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,115 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSortBy(t *testing.T) {
+	keys, err := ParseSortBy("from, -Date")
+	require.NoError(t, err)
+	assert.Equal(t, []SortKey{{Name: "from"}, {Name: "date", Reverse: true}}, keys)
+
+	_, err = ParseSortBy("priority")
+	assert.Error(t, err)
+
+	_, err = ParseRuleString(`
+name: bad-sort
+output:
+  sort_by: priority
+  fields: [uid]
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid sort_by")
+}
+
+func TestIMAPSortCriteriaDisplayFallback(t *testing.T) {
+	keys := []SortKey{{Name: "display_from", Reverse: true}, {Name: "size"}}
+
+	withDisplay := imapSortCriteria(keys, imap.CapSet{imap.CapSort: {}, imap.CapSortDisplay: {}})
+	assert.Equal(t, []imapclient.SortCriterion{
+		{Key: "DISPLAYFROM", Reverse: true},
+		{Key: imapclient.SortKeySize},
+	}, withDisplay)
+
+	withoutDisplay := imapSortCriteria(keys, imap.CapSet{imap.CapSort: {}})
+	assert.Equal(t, imapclient.SortKeyFrom, withoutDisplay[0].Key)
+}
+
+func TestSortRecords(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 0, 0, 0, 0, time.UTC) }
+	records := []sortRecord{
+		{SeqNum: 1, From: "bob", Date: day(2), Size: 10},
+		{SeqNum: 2, From: "alice", Date: day(1), Size: 30},
+		{SeqNum: 3, From: "bob", Arrival: day(3), Size: 20},
+	}
+
+	seqNums := func() []uint32 {
+		var ret []uint32
+		for _, r := range records {
+			ret = append(ret, r.SeqNum)
+		}
+		return ret
+	}
+
+	sortRecords(records, []SortKey{{Name: "from"}, {Name: "date", Reverse: true}})
+	assert.Equal(t, []uint32{2, 3, 1}, seqNums(), "missing dates fall back to arrival")
+
+	sortRecords(records, []SortKey{{Name: "size", Reverse: true}})
+	assert.Equal(t, []uint32{2, 3, 1}, seqNums())
+}
+
+func TestFilterLocalMessagesSorted(t *testing.T) {
+	messages := readSampleMbox(t)
+
+	rule, err := ParseRuleString(`
+name: sorted
+search:
+  since: 2025-01-01
+output:
+  sort_by: from
+  limit: 2
+  fields: [uid]
+`)
+	require.NoError(t, err)
+
+	got, err := rule.FilterLocalMessages(messages)
+	require.NoError(t, err)
+	var uids []uint32
+	for _, msg := range got {
+		uids = append(uids, msg.UID)
+	}
+	assert.Equal(t, []uint32{1, 3}, uids)
+}
+
+// The fixture is hand-written: SORT returns message 2, which the body regex
+// left out, and the results keep the server order of the others.
+func TestReplaySortKeepsClientSideMatches(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: sorted-reports
+search:
+  subject_contains: report
+  body_regex: "^Numbers"
+output:
+  sort_by: subject
+  fields: [uid, subject]
+`)
+	require.NoError(t, err)
+	client, done := replayClient(t, "testdata/imap/sort_body_regex.imap")
+
+	_, err = SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages, err := rule.FetchMessages(client)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, uint32(3), messages[0].UID)
+	assert.Equal(t, uint32(1), messages[1].UID)
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
@@ -0,0 +1,60 @@
+package dsl
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaleThread is a conversation whose last message came from someone else
+// and got no reply.
+type StaleThread struct {
+	*Thread
+	// Last is the unanswered message
+	Last *EmailMessage
+	// Waiting is how long Last has been waiting for a reply
+	Waiting time.Duration
+}
+
+// isOwnAddress reports whether address is one of me. Entries starting with
+// "@" match a whole domain.
+func isOwnAddress(address string, me []string) bool {
+	address = strings.ToLower(address)
+	for _, own := range me {
+		own = strings.ToLower(own)
+		if strings.HasPrefix(own, "@") {
+			if strings.HasSuffix(address, own) {
+				return true
+			}
+		} else if address == own {
+			return true
+		}
+	}
+	return false
+}
+
+// FindStaleThreads groups messages into threads and returns those whose last
+// message is from an address outside me and at least olderThan before now.
+// Pass received and sent messages together, so that replies close threads.
+// The threads waiting longest come first.
+func FindStaleThreads(messages []*EmailMessage, me []string, olderThan time.Duration, now time.Time) []*StaleThread {
+	var ret []*StaleThread
+	for _, thread := range GroupThreads(messages) {
+		last := thread.Messages[len(thread.Messages)-1]
+		if last.Envelope == nil || len(last.Envelope.From) == 0 || last.Envelope.Date.IsZero() {
+			continue
+		}
+		if isOwnAddress(last.Envelope.From[0].Address, me) {
+			continue
+		}
+		waiting := now.Sub(last.Envelope.Date)
+		if waiting < olderThan {
+			continue
+		}
+		ret = append(ret, &StaleThread{Thread: thread, Last: last, Waiting: waiting})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Waiting > ret[j].Waiting
+	})
+	return ret
+}
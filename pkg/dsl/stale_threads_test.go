@@ -0,0 +1,37 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindStaleThreads(t *testing.T) {
+	messages := []*EmailMessage{
+		// Answered from the Sent folder
+		threadMessage(1, 1, "a@x", "Budget", "alice@example.com", nil, nil),
+		threadMessage(2, 2, "b@x", "Re: Budget", "me@example.com", []string{"a@x"}, []string{"a@x"}),
+		// Answered, then followed up on: the ball is back with me
+		threadMessage(3, 1, "c@x", "Offer", "vendor@shop.com", nil, nil),
+		threadMessage(4, 2, "d@x", "Re: Offer", "me@example.com", []string{"c@x"}, []string{"c@x"}),
+		threadMessage(5, 4, "e@x", "Re: Offer", "vendor@shop.com", []string{"d@x"}, []string{"c@x", "d@x"}),
+		// Never answered
+		threadMessage(6, 2, "f@x", "Invoice", "billing@shop.com", nil, nil),
+		// From a colleague of my domain
+		threadMessage(7, 1, "g@x", "Lunch", "carol@corp.example", nil, nil),
+		// Too recent
+		threadMessage(8, 9, "h@x", "Hello", "dave@shop.com", nil, nil),
+	}
+	now := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	stale := FindStaleThreads(messages, []string{"ME@example.com", "@corp.example"}, 3*24*time.Hour, now)
+	require.Len(t, stale, 2)
+	assert.Equal(t, "Invoice", stale[0].Subject)
+	assert.Equal(t, uint32(6), stale[0].Last.UID)
+	assert.Equal(t, 8*24*time.Hour, stale[0].Waiting)
+	assert.Equal(t, "Offer", stale[1].Subject)
+	assert.Equal(t, uint32(5), stale[1].Last.UID)
+	assert.Equal(t, []uint32{3, 4, 5}, stale[1].UIDs())
+}
@@ -0,0 +1,67 @@
+package dsl
+
+import (
+	"fmt"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// StatusCheck lets a rule short-circuit on the mailbox STATUS, which is much
+// cheaper than a search, e.g. to skip scheduled runs when nothing is unread.
+type StatusCheck struct {
+	SkipIfUnseenBelow   int `yaml:"skip_if_unseen_below,omitempty"`
+	SkipIfMessagesBelow int `yaml:"skip_if_messages_below,omitempty"`
+}
+
+// Validate checks if the status check is valid
+func (s *StatusCheck) Validate() error {
+	if s.SkipIfUnseenBelow < 0 {
+		return fmt.Errorf("skip_if_unseen_below must be non-negative")
+	}
+	if s.SkipIfMessagesBelow < 0 {
+		return fmt.Errorf("skip_if_messages_below must be non-negative")
+	}
+	return nil
+}
+
+// skipReason returns why a mailbox with the given STATUS should be skipped, or
+// "" if the rule should run.
+func (s *StatusCheck) skipReason(data *imap.StatusData) string {
+	if data.NumUnseen != nil && int(*data.NumUnseen) < s.SkipIfUnseenBelow {
+		return fmt.Sprintf("%d unseen message(s), below %d", *data.NumUnseen, s.SkipIfUnseenBelow)
+	}
+	if data.NumMessages != nil && int(*data.NumMessages) < s.SkipIfMessagesBelow {
+		return fmt.Sprintf("%d message(s), below %d", *data.NumMessages, s.SkipIfMessagesBelow)
+	}
+	return ""
+}
+
+// ShouldSkip queries the STATUS of mailbox and reports whether the rule's
+// status check says to skip it. Rules without a status check never skip.
+// RFC 3501 discourages STATUS on the selected mailbox, so callers should check
+// before selecting it where they can.
+func (rule *Rule) ShouldSkip(client *imapclient.Client, mailbox string) (bool, error) {
+	logger := rule.Logger()
+	if rule.Status == nil || (rule.Status.SkipIfUnseenBelow == 0 && rule.Status.SkipIfMessagesBelow == 0) {
+		return false, nil
+	}
+
+	data, err := client.Status(mailbox, &imap.StatusOptions{
+		NumMessages: true,
+		NumUnseen:   true,
+	}).Wait()
+	if err != nil {
+		return false, fmt.Errorf("failed to get status of mailbox %q: %w", mailbox, wrapMailboxError(err, mailbox))
+	}
+
+	reason := rule.Status.skipReason(data)
+	if reason == "" {
+		return false, nil
+	}
+	logger.Info().
+		Str("mailbox", mailbox).
+		Str("reason", reason).
+		Msg("Skipping rule after mailbox status check")
+	return true, nil
+}
@@ -0,0 +1,49 @@
+package dsl
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusCheckSkipReason(t *testing.T) {
+	count := func(n uint32) *uint32 { return &n }
+
+	check := &StatusCheck{SkipIfUnseenBelow: 1}
+	assert.NotEmpty(t, check.skipReason(&imap.StatusData{NumUnseen: count(0), NumMessages: count(10)}))
+	assert.Empty(t, check.skipReason(&imap.StatusData{NumUnseen: count(2), NumMessages: count(10)}))
+
+	check = &StatusCheck{SkipIfMessagesBelow: 100}
+	assert.Contains(t, check.skipReason(&imap.StatusData{NumMessages: count(10)}), "10 message(s)")
+}
+
+func TestRuleStatusParses(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: scheduled
+status:
+  skip_if_unseen_below: 1
+search:
+  unread: true
+  answered: false
+output:
+  fields: [uid]
+`)
+	require.NoError(t, err)
+	require.NotNil(t, rule.Status)
+	assert.Equal(t, 1, rule.Status.SkipIfUnseenBelow)
+	require.NotNil(t, rule.Search.Unread)
+	assert.True(t, *rule.Search.Unread)
+	require.NotNil(t, rule.Search.Answered)
+	assert.False(t, *rule.Search.Answered)
+
+	_, err = ParseRuleString(`
+name: negative
+status:
+  skip_if_unseen_below: -1
+output:
+  fields: [uid]
+`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package dsl
+
+import "sync"
+
+// HandledMessages records the messages claimed by rules with actions.stop,
+// or acted on by any rule when deduplicating, so that the later rules of a
+// run skip them. It is safe for concurrent use
+// by rules running on different mailboxes.
+type HandledMessages struct {
+	mu   sync.Mutex
+	uids map[string]map[uint32]bool
+}
+
+// NewHandledMessages creates an empty set of handled messages.
+func NewHandledMessages() *HandledMessages {
+	return &HandledMessages{uids: make(map[string]map[uint32]bool)}
+}
+
+// Add marks messages of mailbox as handled.
+func (h *HandledMessages) Add(mailbox string, messages []*EmailMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	uids := h.uids[mailbox]
+	if uids == nil {
+		uids = make(map[uint32]bool)
+		h.uids[mailbox] = uids
+	}
+	for _, msg := range messages {
+		uids[msg.UID] = true
+	}
+}
+
+// Has reports whether the message with uid in mailbox was handled. A nil set
+// has no messages.
+func (h *HandledMessages) Has(mailbox string, uid uint32) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.uids[mailbox][uid]
+}
+
+// Len returns the number of handled messages.
+func (h *HandledMessages) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, uids := range h.uids {
+		n += len(uids)
+	}
+	return n
+}
+
+// SetHandled shares the handled messages of a run with the rule: it skips
+// the messages already in the set, and adds the ones its actions handled
+// when it sets actions.stop.
+func (rule *Rule) SetHandled(handled *HandledMessages) {
+	rule.handled = handled
+}
+
+// Deduplicates reports whether the rule takes part in deduplication: unless
+// it sets dedup: false.
+func (rule *Rule) Deduplicates() bool {
+	return rule.Dedup == nil || *rule.Dedup
+}
+
+// SetProcessed shares the messages acted on during a run with the rule: it
+// skips the messages already in the set, and adds the ones its actions
+// succeeded on, so two rules never act on the same message.
+func (rule *Rule) SetProcessed(processed *HandledMessages) {
+	rule.processed = processed
+}
@@ -0,0 +1,264 @@
+package dsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// Defaults of the summarize output config.
+const (
+	DefaultSummarizeEndpoint  = "https://api.openai.com/v1"
+	DefaultSummarizeModel     = "gpt-4o-mini"
+	DefaultSummarizeAPIKeyEnv = "OPENAI_API_KEY"
+	DefaultSummarizePrompt    = `Summarize this email in one or two sentences.
+
+From: {{ .From.Address }}
+Subject: {{ .Subject }}
+
+{{ truncate 8000 .Body }}`
+)
+
+const defaultSummarizeTimeout = 60 * time.Second
+
+// Summarizer turns a prompt into a summary.
+type Summarizer interface {
+	Summarize(prompt string) (string, error)
+}
+
+// SetSummarizer replaces the OpenAI-compatible client used for the summary
+// output field.
+func (rule *Rule) SetSummarizer(summarizer Summarizer) {
+	rule.summarizer = summarizer
+}
+
+// SummarizeConfig configures the summary output field: the prompt is
+// rendered for each message and sent to an OpenAI-compatible chat
+// completions API.
+type SummarizeConfig struct {
+	// Endpoint is the API base URL (default: https://api.openai.com/v1)
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Model is the model name (default: gpt-4o-mini)
+	Model string `yaml:"model,omitempty"`
+	// APIKeyEnv names the environment variable holding the API key
+	// (default: OPENAI_API_KEY); no key is sent when it is unset
+	APIKeyEnv string `yaml:"api_key_env,omitempty"`
+	// Prompt is a template rendered with the message, whose text parts are
+	// .Body
+	Prompt string `yaml:"prompt,omitempty"`
+	// MaxTokens bounds the length of the summary
+	MaxTokens int `yaml:"max_tokens,omitempty"`
+	// Timeout bounds each request (default: 60s)
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// Validate checks if the summarize configuration is valid
+func (c *SummarizeConfig) Validate() error {
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint must be an http or https URL, got %q", c.Endpoint)
+		}
+	}
+	if c.Prompt != "" {
+		if err := ValidateTemplate(c.Prompt); err != nil {
+			return fmt.Errorf("invalid prompt: %w", err)
+		}
+	}
+	if c.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens cannot be negative")
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", c.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func (c *SummarizeConfig) prompt() string {
+	if c.Prompt != "" {
+		return c.Prompt
+	}
+	return DefaultSummarizePrompt
+}
+
+// chatSummarizer calls an OpenAI-compatible chat completions API.
+type chatSummarizer struct {
+	endpoint  string
+	model     string
+	apiKey    string
+	maxTokens int
+	client    *http.Client
+}
+
+func newChatSummarizer(c *SummarizeConfig) *chatSummarizer {
+	s := &chatSummarizer{
+		endpoint:  DefaultSummarizeEndpoint,
+		model:     DefaultSummarizeModel,
+		maxTokens: c.MaxTokens,
+		client:    &http.Client{Timeout: defaultSummarizeTimeout},
+	}
+	if c.Endpoint != "" {
+		s.endpoint = c.Endpoint
+	}
+	if c.Model != "" {
+		s.model = c.Model
+	}
+	keyEnv := DefaultSummarizeAPIKeyEnv
+	if c.APIKeyEnv != "" {
+		keyEnv = c.APIKeyEnv
+	}
+	s.apiKey = os.Getenv(keyEnv)
+	if d, err := time.ParseDuration(c.Timeout); err == nil && c.Timeout != "" {
+		s.client.Timeout = d
+	}
+	return s
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model     string        `json:"model"`
+	Messages  []chatMessage `json:"messages"`
+	MaxTokens int           `json:"max_tokens,omitempty"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+func (s *chatSummarizer) Summarize(prompt string) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model:     s.model,
+		Messages:  []chatMessage{{Role: "user", Content: prompt}},
+		MaxTokens: s.maxTokens,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(s.endpoint, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create summary request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("summary request to %s returned %s", req.URL.Host, resp.Status)
+	}
+	var result chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid summary response: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return "", fmt.Errorf("summary response has no choices")
+	}
+	return strings.TrimSpace(result.Choices[0].Message.Content), nil
+}
+
+// outputsSummary reports whether the rule outputs the summary field.
+func (rule *Rule) outputsSummary() bool {
+	for _, fieldInterface := range rule.Output.Fields {
+		if field, ok := fieldInterface.(Field); ok && field.Name == "summary" {
+			return true
+		}
+	}
+	return false
+}
+
+// summarize returns the function setting the summary of messages, or nil if
+// the rule does not output it. fetchText retrieves the text of messages
+// whose text parts were not fetched; it may be nil. Failures are logged and
+// leave the summary empty.
+func (rule *Rule) summarize(fetchText func(*EmailMessage) (string, error)) func(*EmailMessage) {
+	logger := rule.Logger()
+	if !rule.outputsSummary() {
+		return nil
+	}
+	config := rule.Output.Summarize
+	if config == nil {
+		config = &SummarizeConfig{}
+	}
+	summarizer := rule.summarizer
+	if summarizer == nil {
+		summarizer = newChatSummarizer(config)
+	}
+	prompt := config.prompt()
+
+	return func(msg *EmailMessage) {
+		ctx := NewTemplateContext(msg, rule)
+		ctx.Body = summaryText(msg.MimeParts)
+		if ctx.Body == "" {
+			ctx.Body = rawMessageText(msg.RawContent[""])
+		}
+		if ctx.Body == "" && fetchText != nil {
+			text, err := fetchText(msg)
+			if err != nil {
+				logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Failed to fetch message text for summary")
+				return
+			}
+			ctx.Body = text
+		}
+		text, err := RenderTemplate(prompt, ctx)
+		if err != nil {
+			logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Failed to render summary prompt")
+			return
+		}
+		summary, err := summarizer.Summarize(text)
+		if err != nil {
+			logger.Warn().Err(err).Uint32("uid", msg.UID).Msg("Failed to summarize message")
+			return
+		}
+		msg.Summary = summary
+	}
+}
+
+// summaryText returns the text/plain parts, or all text parts if there are
+// none.
+func summaryText(parts []MimePart) string {
+	var plain []MimePart
+	for _, part := range parts {
+		if strings.HasPrefix(strings.ToLower(part.Type), "text/plain") {
+			plain = append(plain, part)
+		}
+	}
+	if len(plain) > 0 {
+		return exprBody(plain)
+	}
+	return exprBody(parts)
+}
+
+// rawMessageText returns the text of a raw message, as summaryText.
+func rawMessageText(raw []byte) string {
+	return summaryText(rawMessageParts(raw))
+}
+
+// fetchMessageText fetches a full message and returns its text parts.
+func fetchMessageText(client *imapclient.Client, msg *EmailMessage) (string, error) {
+	parts, err := fetchMessageParts(client, msg)
+	if err != nil {
+		return "", err
+	}
+	return summaryText(parts), nil
+}
@@ -0,0 +1,89 @@
+package dsl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type promptRecorder struct {
+	prompts []string
+	err     error
+}
+
+func (r *promptRecorder) Summarize(prompt string) (string, error) {
+	r.prompts = append(r.prompts, prompt)
+	return "a short summary", r.err
+}
+
+func TestChatSummarizer(t *testing.T) {
+	var request chatRequest
+	var auth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, path = r.Header.Get("Authorization"), r.URL.Path
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, _ = w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": " Lunch on Friday. \n"}}]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SUMMARY_TEST_KEY", "secret")
+	summarizer := newChatSummarizer(&SummarizeConfig{Endpoint: server.URL + "/v1/", Model: "local", APIKeyEnv: "SUMMARY_TEST_KEY", MaxTokens: 50})
+	summary, err := summarizer.Summarize("Summarize: lunch?")
+	require.NoError(t, err)
+	assert.Equal(t, "Lunch on Friday.", summary)
+	assert.Equal(t, "/v1/chat/completions", path)
+	assert.Equal(t, "Bearer secret", auth)
+	assert.Equal(t, "local", request.Model)
+	assert.Equal(t, 50, request.MaxTokens)
+	assert.Equal(t, []chatMessage{{Role: "user", Content: "Summarize: lunch?"}}, request.Messages)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer failing.Close()
+	_, err = newChatSummarizer(&SummarizeConfig{Endpoint: failing.URL}).Summarize("x")
+	assert.ErrorContains(t, err, "429")
+}
+
+func TestSummaryField(t *testing.T) {
+	rule, err := ParseRuleString(`
+name: summaries
+search:
+  subject_contains: Digest
+output:
+  fields: [uid, summary]
+  summarize:
+    model: local
+    prompt: "Summarize {{ .Subject }}: {{ .Body }}"
+`)
+	require.NoError(t, err)
+	recorder := &promptRecorder{}
+	rule.SetSummarizer(recorder)
+
+	messages, err := rule.FilterLocalMessages(readSampleMbox(t))
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "a short summary", messages[0].Summary)
+	require.Len(t, recorder.prompts, 1)
+	assert.True(t, strings.HasPrefix(recorder.prompts[0], "Summarize "+messages[0].Envelope.Subject+": "))
+
+	// Failures leave the summary empty
+	recorder.err = errors.New("model overloaded")
+	messages, err = rule.FilterLocalMessages(readSampleMbox(t))
+	require.NoError(t, err)
+	assert.Equal(t, "", messages[0].Summary)
+}
+
+func TestSummarizeConfigValidate(t *testing.T) {
+	assert.NoError(t, (&SummarizeConfig{}).Validate())
+	assert.ErrorContains(t, (&SummarizeConfig{Endpoint: "localhost:11434"}).Validate(), "endpoint must be")
+	assert.ErrorContains(t, (&SummarizeConfig{Prompt: "{{ .Subject"}).Validate(), "invalid prompt")
+	assert.ErrorContains(t, (&SummarizeConfig{Timeout: "1 minute"}).Validate(), "invalid timeout")
+	assert.ErrorContains(t, (&SummarizeConfig{MaxTokens: -1}).Validate(), "max_tokens")
+}
@@ -0,0 +1,22 @@
+package dsl
+
+// RunSummary is the machine-readable outcome of running a rule against a
+// mailbox, for scripts and CI.
+type RunSummary struct {
+	Rule    string `json:"rule"`
+	Mailbox string `json:"mailbox"`
+	// Skipped is set when the rule's status check found nothing to do
+	Skipped bool `json:"skipped,omitempty"`
+	// Backfill is set when the run processed a batch of the rule's backfill
+	// instead of its usual search
+	Backfill bool `json:"backfill,omitempty"`
+	// Matched is the number of messages the server search matched
+	Matched int `json:"matched"`
+	// Fetched is the number of messages output and acted on, after
+	// output.limit and client-side filters
+	Fetched    int            `json:"fetched"`
+	Actions    []ActionResult `json:"actions,omitempty"`
+	Errors     []string       `json:"errors,omitempty"`
+	DurationMs int64          `json:"duration_ms"`
+	ExitCode   int            `json:"exit_code"`
+}
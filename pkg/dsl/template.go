@@ -0,0 +1,206 @@
+package dsl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Masterminds/sprig/v3"
+)
+
+// maxFilenameLength bounds the output of sanitizeFilename (in bytes) so that
+// rendered names stay below common filesystem limits.
+const maxFilenameLength = 200
+
+// TemplateRule is the rule metadata exposed to templates as .Rule.
+type TemplateRule struct {
+	Name        string
+	Description string
+}
+
+// TemplateContext is the data passed to every template rendered by the dsl:
+// export filenames, move_to/copy_to targets, the text output template and
+// custom actions that opt into templating.
+type TemplateContext struct {
+	UID     uint32
+	SeqNum  uint32
+	Subject string
+	From    EmailAddress
+	To      []EmailAddress
+	Date    time.Time
+	Size    uint32
+	Flags   []string
+	// Label is the classify label
+	Label string
+	// Body holds the text MIME parts that were fetched for the message.
+	Body    string
+	Message *EmailMessage
+	Rule    TemplateRule
+	Env     map[string]string
+	Now     time.Time
+}
+
+// NewTemplateContext builds the template context for msg. rule may be nil.
+func NewTemplateContext(msg *EmailMessage, rule *Rule) *TemplateContext {
+	ctx := &TemplateContext{
+		Env: environMap(),
+		Now: time.Now(),
+	}
+	if rule != nil {
+		ctx.Rule = TemplateRule{Name: rule.Name, Description: rule.Description}
+	}
+	if msg == nil {
+		return ctx
+	}
+
+	ctx.UID = msg.UID
+	ctx.SeqNum = msg.SeqNum
+	ctx.Size = msg.Size
+	ctx.Flags = msg.Flags
+	ctx.Label = msg.Label
+	ctx.Body = exprBody(msg.MimeParts)
+	ctx.Message = msg
+	if msg.Envelope != nil {
+		ctx.Subject = msg.Envelope.Subject
+		ctx.Date = msg.Envelope.Date
+		ctx.To = msg.Envelope.To
+		if len(msg.Envelope.From) > 0 {
+			ctx.From = msg.Envelope.From[0]
+		}
+	}
+	return ctx
+}
+
+// TemplateFuncs returns the function map available in dsl templates: the sprig
+// functions plus sanitizeFilename, truncate, dateFormat and hash.
+func TemplateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["sanitizeFilename"] = sanitizeFilename
+	funcs["truncate"] = truncate
+	funcs["dateFormat"] = dateFormat
+	funcs["hash"] = hashString
+	return funcs
+}
+
+// ValidateTemplate checks that text parses as a dsl template.
+func ValidateTemplate(text string) error {
+	_, err := parseTemplate(text)
+	return err
+}
+
+// RenderTemplate renders text against data.
+func RenderTemplate(text string, data *TemplateContext) (string, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render template %q: %w", text, err)
+	}
+	return sb.String(), nil
+}
+
+// isTemplate reports whether s contains template actions and needs rendering.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("dsl").Funcs(TemplateFuncs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q: %w", text, err)
+	}
+	return tmpl, nil
+}
+
+func environMap() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// sanitizeFilename replaces characters that are unsafe in file names
+// (path separators, reserved characters, control characters) with '_'.
+func sanitizeFilename(s string) string {
+	var sb strings.Builder
+	lastUnderscore := false
+	for _, r := range s {
+		if r == '/' || r == '\\' || strings.ContainsRune(`:*?"<>|`, r) || unicode.IsControl(r) {
+			r = '_'
+		}
+		if r == '_' && lastUnderscore {
+			continue
+		}
+		lastUnderscore = r == '_'
+		sb.WriteRune(r)
+	}
+
+	ret := strings.Trim(sb.String(), " ._")
+	for len(ret) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(ret)
+		ret = ret[:len(ret)-size]
+	}
+	if ret == "" {
+		return "_"
+	}
+	return ret
+}
+
+// truncate shortens s to at most n runes. The argument order allows
+// `{{ .Subject | truncate 40 }}`.
+func truncate(n int, s string) string {
+	if n < 0 {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+// dateFormat formats a time.Time (or an RFC 3339 string) with a Go layout,
+// e.g. `{{ dateFormat "2006-01-02" .Date }}`. Zero dates render as "".
+func dateFormat(layout string, value interface{}) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v != nil {
+			t = *v
+		}
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", fmt.Errorf("dateFormat: %w", err)
+		}
+		t = parsed
+	default:
+		return "", fmt.Errorf("dateFormat: unsupported value of type %T", value)
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(layout), nil
+}
+
+// hashString returns the hex encoded SHA-256 of s.
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package dsl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func templateTestMessage() *EmailMessage {
+	return &EmailMessage{
+		UID:   17,
+		Size:  1234,
+		Flags: []string{"\\Seen"},
+		Envelope: &EmailEnvelope{
+			Subject: "Invoice: March/April <final>",
+			Date:    time.Date(2025, 4, 2, 9, 30, 0, 0, time.UTC),
+			From:    []EmailAddress{{Name: "Billing", Address: "billing@example.com"}},
+		},
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	t.Setenv("SMAILNAIL_TEMPLATE_TEST", "archive")
+	rule := &Rule{Name: "invoices", Description: "Invoice archive"}
+	ctx := NewTemplateContext(templateTestMessage(), rule)
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"fields", "{{ .UID }} {{ .From.Address }} {{ .Size }}", "17 billing@example.com 1234"},
+		{"rule", "{{ .Rule.Name }}: {{ .Rule.Description }}", "invoices: Invoice archive"},
+		{"env", "{{ .Env.SMAILNAIL_TEMPLATE_TEST }}", "archive"},
+		{"sanitizeFilename", "{{ .Subject | sanitizeFilename }}", "Invoice_ March_April _final"},
+		{"truncate", "{{ .Subject | truncate 7 }}", "Invoice"},
+		{"dateFormat", `{{ dateFormat "2006/01" .Date }}`, "2025/04"},
+		{"sprig", `{{ .From.Address | upper }}`, "BILLING@EXAMPLE.COM"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTemplate(tt.template, ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	assert.Equal(t, "a_b_c", sanitizeFilename("a/b\\c"))
+	assert.Equal(t, "_", sanitizeFilename("../"))
+	assert.Len(t, sanitizeFilename(strings.Repeat("x", 500)), maxFilenameLength)
+
+	assert.Equal(t, "héll", truncate(4, "héllo"))
+	assert.Equal(t, "short", truncate(10, "short"))
+
+	formatted, err := dateFormat("2006-01-02", "2025-04-02T09:30:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04-02", formatted)
+	formatted, err = dateFormat("2006-01-02", time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, formatted)
+	_, err = dateFormat("2006", 42)
+	assert.Error(t, err)
+
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", hashString("hello"))
+}
+
+func TestGroupByMailboxTemplate(t *testing.T) {
+	messages := []*EmailMessage{
+		{UID: 1, Envelope: &EmailEnvelope{From: []EmailAddress{{Address: "a@one.com"}}}},
+		{UID: 2, Envelope: &EmailEnvelope{From: []EmailAddress{{Address: "b@two.com"}}}},
+		{UID: 3, Envelope: &EmailEnvelope{From: []EmailAddress{{Address: "c@one.com"}}}},
+	}
+
+	groups, err := groupByMailboxTemplate(messages, `Archive/{{ index (splitList "@" .From.Address) 1 }}`, nil)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "Archive/one.com", groups[0].mailbox)
+	assert.Equal(t, []*EmailMessage{messages[0], messages[2]}, groups[0].messages)
+	assert.Equal(t, "Archive/two.com", groups[1].mailbox)
+
+	groups, err = groupByMailboxTemplate(messages, "Archive", nil)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Len(t, groups[0].messages, 3)
+}
+
+func TestRenderExportFilename(t *testing.T) {
+	msg := templateTestMessage()
+	config := &ExportConfig{Format: "eml", FilenameTemplate: `{{ dateFormat "2006-01" .Date }}/{{ .UID }}-{{ .Subject | sanitizeFilename | truncate 7 }}`}
+
+	filename, err := renderExportFilename(config, msg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04/17-Invoice.eml", filename)
+
+	config.FilenameTemplate = "../{{ .UID }}.eml"
+	_, err = renderExportFilename(config, msg, nil)
+	assert.Error(t, err)
+}
+
+func TestTemplateValidationInRules(t *testing.T) {
+	_, err := ParseRuleString(`
+name: bad-template
+output:
+  fields: [uid]
+actions:
+  move_to: "Archive/{{ .From.Address"
+`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "move_to")
+
+	rule, err := ParseRuleString(`
+name: output-template
+output:
+  format: text
+  template: "{{ .UID }} {{ .Subject | truncate 7 }}"
+  fields: [uid, subject]
+`)
+	require.NoError(t, err)
+
+	out, err := formatOutput(templateTestMessage(), rule.Output, rule)
+	require.NoError(t, err)
+	assert.Equal(t, "17 Invoice", out)
+}
@@ -0,0 +1,409 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// referencesSection fetches the References header, which the IMAP envelope
+// does not carry.
+var referencesSection = &imap.FetchItemBodySection{
+	Specifier:    imap.PartSpecifierHeader,
+	HeaderFields: []string{"References"},
+	Peek:         true,
+}
+
+// parseReferences extracts the message IDs from a fetched References header.
+func parseReferences(raw []byte) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil
+	}
+	ids, err := (&mail.Header{Header: message.Header{Header: header}}).MsgIDList("References")
+	if err != nil {
+		return nil
+	}
+	return ids
+}
+
+// Thread is a conversation assembled from matched messages.
+type Thread struct {
+	// ID is the Message-ID of the earliest known message of the conversation
+	ID           string
+	Subject      string
+	Participants []EmailAddress
+	FirstDate    time.Time
+	LatestDate   time.Time
+	Messages     []*EmailMessage
+}
+
+// GroupThreads groups messages into conversations linked by Message-ID,
+// In-Reply-To and References. Messages without any of these headers form
+// their own thread. Threads are returned most recent first; messages within a
+// thread are sorted by date.
+func GroupThreads(messages []*EmailMessage) []*Thread {
+	parent := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		p, ok := parent[id]
+		if !ok || p == id {
+			parent[id] = id
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[rb] = ra
+		}
+	}
+
+	keys := make([]string, len(messages))
+	for i, msg := range messages {
+		ids := threadIDs(msg)
+		if len(ids) == 0 {
+			// Not linkable, keep the message on its own
+			keys[i] = fmt.Sprintf("\x00uid:%d:%d", msg.UID, i)
+			continue
+		}
+		keys[i] = ids[0]
+		for _, id := range ids[1:] {
+			union(ids[0], id)
+		}
+	}
+
+	groupOf := make(map[string]int)
+	var groups [][]*EmailMessage
+	for i, msg := range messages {
+		root := keys[i]
+		if !strings.HasPrefix(root, "\x00") {
+			root = find(root)
+		}
+		idx, ok := groupOf[root]
+		if !ok {
+			idx = len(groups)
+			groupOf[root] = idx
+			groups = append(groups, nil)
+		}
+		groups[idx] = append(groups[idx], msg)
+	}
+
+	return newThreads(groups)
+}
+
+// groupServerThreads groups messages following the result of a THREAD command
+// on sequence numbers. Messages the server did not mention form their own
+// thread.
+func groupServerThreads(messages []*EmailMessage, data []imapclient.ThreadData) []*Thread {
+	groupOf := make(map[uint32]int)
+	var collect func(idx int, thread imapclient.ThreadData)
+	collect = func(idx int, thread imapclient.ThreadData) {
+		for _, seqNum := range thread.Chain {
+			groupOf[seqNum] = idx
+		}
+		for _, sub := range thread.SubThreads {
+			collect(idx, sub)
+		}
+	}
+	for i, thread := range data {
+		collect(i, thread)
+	}
+
+	groups := make([][]*EmailMessage, len(data))
+	for _, msg := range messages {
+		idx, ok := groupOf[msg.SeqNum]
+		if !ok {
+			groups = append(groups, []*EmailMessage{msg})
+			continue
+		}
+		groups[idx] = append(groups[idx], msg)
+	}
+
+	return newThreads(groups)
+}
+
+// newThreads builds the threads of the non-empty groups, most recent first.
+func newThreads(groups [][]*EmailMessage) []*Thread {
+	threads := make([]*Thread, 0, len(groups))
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		thread := &Thread{Messages: group}
+		thread.summarize()
+		threads = append(threads, thread)
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].LatestDate.After(threads[j].LatestDate)
+	})
+	return threads
+}
+
+// Values of output.thread_algorithm.
+const (
+	ThreadAlgorithmReferences     = "references"
+	ThreadAlgorithmOrderedSubject = "orderedsubject"
+	ThreadAlgorithmClient         = "client"
+)
+
+// FetchThreads retrieves the messages matching the rule and groups them into
+// conversations. When the server advertises the THREAD extension for the
+// configured algorithm (REFERENCES by default) it does the grouping;
+// otherwise, or with thread_algorithm: client, threads are built from the
+// messages' Message-ID, In-Reply-To and References headers.
+func (rule *Rule) FetchThreads(client *imapclient.Client) ([]*Thread, error) {
+	logger := rule.Logger()
+	messages, err := rule.FetchMessages(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	algorithm, ok := rule.serverThreadAlgorithm(client.Caps())
+	if !ok {
+		return GroupThreads(messages), nil
+	}
+
+	criteria, _, err := rule.buildSearchCriteria()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build search criteria: %w", err)
+	}
+	data, err := client.Thread(&imapclient.ThreadOptions{
+		Algorithm:      algorithm,
+		SearchCriteria: criteria,
+	}).Wait()
+	if err != nil {
+		logger.Warn().Err(err).Msg("THREAD failed, grouping threads client-side")
+		return GroupThreads(messages), nil
+	}
+
+	logger.Debug().
+		Str("algorithm", string(algorithm)).
+		Int("threads", len(data)).
+		Msg("Grouped threads on the server")
+	return groupServerThreads(messages, data), nil
+}
+
+// serverThreadAlgorithm returns the THREAD algorithm to use, if the server
+// supports the configured one.
+func (rule *Rule) serverThreadAlgorithm(caps imap.CapSet) (imap.ThreadAlgorithm, bool) {
+	var want imap.ThreadAlgorithm
+	switch rule.Output.ThreadAlgorithm {
+	case ThreadAlgorithmClient:
+		return "", false
+	case ThreadAlgorithmOrderedSubject:
+		want = imap.ThreadOrderedSubject
+	default:
+		want = imap.ThreadReferences
+	}
+	for _, algorithm := range caps.ThreadAlgorithms() {
+		if strings.EqualFold(string(algorithm), string(want)) {
+			return want, true
+		}
+	}
+	return "", false
+}
+
+// threadIDs returns the message IDs linking msg to its conversation, its own
+// Message-ID first.
+func threadIDs(msg *EmailMessage) []string {
+	if msg.Envelope == nil {
+		return nil
+	}
+	var ids []string
+	if msg.Envelope.MessageID != "" {
+		ids = append(ids, msg.Envelope.MessageID)
+	}
+	ids = append(ids, msg.Envelope.References...)
+	ids = append(ids, msg.Envelope.InReplyTo...)
+	return ids
+}
+
+func (t *Thread) summarize() {
+	sort.SliceStable(t.Messages, func(i, j int) bool {
+		return messageDate(t.Messages[i]).Before(messageDate(t.Messages[j]))
+	})
+
+	seen := make(map[string]bool)
+	for _, msg := range t.Messages {
+		if msg.Envelope == nil {
+			continue
+		}
+		if t.ID == "" {
+			t.ID = msg.Envelope.MessageID
+		}
+		if t.Subject == "" {
+			t.Subject = normalizeSubject(msg.Envelope.Subject)
+		}
+		date := msg.Envelope.Date
+		if t.FirstDate.IsZero() || date.Before(t.FirstDate) {
+			t.FirstDate = date
+		}
+		if date.After(t.LatestDate) {
+			t.LatestDate = date
+		}
+		for _, addr := range append(append([]EmailAddress{}, msg.Envelope.From...), msg.Envelope.To...) {
+			key := strings.ToLower(addr.Address)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			t.Participants = append(t.Participants, addr)
+		}
+	}
+}
+
+// UIDs returns the UIDs of the thread's messages in date order.
+func (t *Thread) UIDs() []uint32 {
+	uids := make([]uint32, len(t.Messages))
+	for i, msg := range t.Messages {
+		uids[i] = msg.UID
+	}
+	return uids
+}
+
+func messageDate(msg *EmailMessage) time.Time {
+	if msg.Envelope == nil {
+		return time.Time{}
+	}
+	return msg.Envelope.Date
+}
+
+// normalizeSubject strips reply and forward prefixes ("Re:", "Fwd: Re:").
+func normalizeSubject(subject string) string {
+	for {
+		trimmed := strings.TrimSpace(subject)
+		lower := strings.ToLower(trimmed)
+		stripped := false
+		for _, prefix := range []string{"re:", "fw:", "fwd:", "aw:"} {
+			if strings.HasPrefix(lower, prefix) {
+				trimmed = trimmed[len(prefix):]
+				stripped = true
+				break
+			}
+		}
+		if !stripped {
+			return trimmed
+		}
+		subject = trimmed
+	}
+}
+
+// threadOutput collects the summary of a thread for the JSON formats.
+func threadOutput(thread *Thread, config OutputConfig) jsonObject {
+	participants := make([]string, len(thread.Participants))
+	for i, addr := range thread.Participants {
+		participants[i] = formatEmailAddress(addr)
+	}
+	return jsonObject{}.
+		set("thread_id", thread.ID).
+		set("subject", thread.Subject).
+		set("message_count", len(thread.Messages)).
+		set("participants", participants).
+		set("first_date", config.FormatDate(thread.FirstDate)).
+		set("latest_date", config.FormatDate(thread.LatestDate)).
+		set("uids", thread.UIDs())
+}
+
+// WriteThreadNDJSON writes the summary of thread as a single line of JSON to w.
+func WriteThreadNDJSON(w io.Writer, thread *Thread, config OutputConfig) error {
+	data, err := json.Marshal(threadOutput(thread, config))
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write JSON line: %w", err)
+	}
+	return nil
+}
+
+// FormatThread formats the summary of thread according to config.
+func FormatThread(thread *Thread, config OutputConfig) (string, error) {
+	switch config.Format {
+	case "json":
+		data, err := json.MarshalIndent(threadOutput(thread, config), "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		return string(data), nil
+	case "ndjson":
+		data, err := json.Marshal(threadOutput(thread, config))
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		return string(data), nil
+	default:
+		var sb strings.Builder
+		participants := make([]string, len(thread.Participants))
+		for i, addr := range thread.Participants {
+			participants[i] = formatEmailAddress(addr)
+		}
+		uids := make([]string, len(thread.Messages))
+		for i, uid := range thread.UIDs() {
+			uids[i] = fmt.Sprintf("%d", uid)
+		}
+		_, _ = fmt.Fprintf(&sb, "Thread: %s\n", thread.Subject)
+		_, _ = fmt.Fprintf(&sb, "Messages: %d\n", len(thread.Messages))
+		_, _ = fmt.Fprintf(&sb, "Participants: %s\n", strings.Join(participants, ", "))
+		_, _ = fmt.Fprintf(&sb, "First: %s\n", config.FormatDate(thread.FirstDate))
+		_, _ = fmt.Fprintf(&sb, "Latest: %s\n", config.FormatDate(thread.LatestDate))
+		_, _ = fmt.Fprintf(&sb, "UIDs: %s\n", strings.Join(uids, ", "))
+		return sb.String(), nil
+	}
+}
+
+// OutputThreads prints one summary per thread.
+func OutputThreads(threads []*Thread, config OutputConfig) error {
+	return writeThreads(os.Stdout, threads, config)
+}
+
+func writeThreads(w io.Writer, threads []*Thread, config OutputConfig) error {
+	if config.Format == "ndjson" {
+		for i, thread := range threads {
+			if err := WriteThreadNDJSON(w, thread, config); err != nil {
+				return fmt.Errorf("failed to format thread %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}
+
+	for i, thread := range threads {
+		output, err := FormatThread(thread, config)
+		if err != nil {
+			return fmt.Errorf("failed to format thread %d: %w", i+1, err)
+		}
+		if i > 0 {
+			_, _ = fmt.Fprintln(w, "----------------------------------------")
+		}
+		_, _ = fmt.Fprintln(w, output)
+	}
+
+	messageCount := 0
+	for _, thread := range threads {
+		messageCount += len(thread.Messages)
+	}
+	_, err := fmt.Fprintf(w, "\nFound %d thread(s) in %d message(s) matching the criteria\n", len(threads), messageCount)
+	return err
+}
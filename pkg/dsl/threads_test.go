@@ -0,0 +1,118 @@
+package dsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func threadMessage(uid uint32, day int, id, subject, from string, inReplyTo []string, references []string) *EmailMessage {
+	return &EmailMessage{
+		UID: uid,
+		Envelope: &EmailEnvelope{
+			Subject:    subject,
+			Date:       time.Date(2025, 3, day, 9, 0, 0, 0, time.UTC),
+			From:       []EmailAddress{{Address: from}},
+			To:         []EmailAddress{{Address: "team@example.com"}},
+			MessageID:  id,
+			InReplyTo:  inReplyTo,
+			References: references,
+		},
+	}
+}
+
+func TestGroupThreads(t *testing.T) {
+	messages := []*EmailMessage{
+		threadMessage(1, 1, "a@x", "Budget", "alice@example.com", nil, nil),
+		threadMessage(2, 2, "b@x", "Re: Budget", "bob@example.com", []string{"a@x"}, []string{"a@x"}),
+		threadMessage(3, 3, "other@x", "Lunch?", "carol@example.com", nil, nil),
+		// Replies to a message that was not matched still join through References
+		threadMessage(4, 5, "d@x", "RE: Fwd: Budget", "ALICE@example.com", []string{"c@x"}, []string{"a@x", "c@x"}),
+		threadMessage(5, 4, "", "no headers", "dave@example.com", nil, nil),
+	}
+
+	threads := GroupThreads(messages)
+	require.Len(t, threads, 3)
+
+	budget := threads[0]
+	assert.Equal(t, "a@x", budget.ID)
+	assert.Equal(t, "Budget", budget.Subject)
+	assert.Equal(t, []uint32{1, 2, 4}, budget.UIDs())
+	assert.Equal(t, 1, budget.FirstDate.Day())
+	assert.Equal(t, 5, budget.LatestDate.Day())
+	assert.Equal(t, []EmailAddress{
+		{Address: "alice@example.com"},
+		{Address: "team@example.com"},
+		{Address: "bob@example.com"},
+	}, budget.Participants)
+
+	assert.Equal(t, []uint32{5}, threads[1].UIDs())
+	assert.Equal(t, []uint32{3}, threads[2].UIDs())
+}
+
+func TestParseReferences(t *testing.T) {
+	raw := []byte("References: <a@x>\r\n <b@y>\r\n\r\n")
+	assert.Equal(t, []string{"a@x", "b@y"}, parseReferences(raw))
+	assert.Nil(t, parseReferences(nil))
+}
+
+func TestFormatThread(t *testing.T) {
+	threads := GroupThreads([]*EmailMessage{
+		threadMessage(1, 1, "a@x", "Budget", "alice@example.com", nil, nil),
+		threadMessage(2, 2, "b@x", "Re: Budget", "bob@example.com", []string{"a@x"}, nil),
+	})
+	require.Len(t, threads, 1)
+
+	text, err := FormatThread(threads[0], OutputConfig{Format: "text", DateFormat: "2006-01-02"})
+	require.NoError(t, err)
+	assert.Contains(t, text, "Thread: Budget\n")
+	assert.Contains(t, text, "Messages: 2\n")
+	assert.Contains(t, text, "Latest: 2025-03-02\n")
+
+	out, err := FormatThread(threads[0], OutputConfig{Format: "ndjson"})
+	require.NoError(t, err)
+	assert.Contains(t, out, `"message_count":2`)
+	assert.Contains(t, out, `"uids":[1,2]`)
+}
+
+func TestGroupServerThreads(t *testing.T) {
+	messages := []*EmailMessage{
+		threadMessage(1, 1, "a@x", "Budget", "alice@example.com", nil, nil),
+		threadMessage(2, 2, "b@x", "Lunch", "bob@example.com", nil, nil),
+		threadMessage(3, 3, "c@x", "Re: Budget", "carol@example.com", nil, nil),
+		threadMessage(4, 4, "d@x", "Unlisted", "dave@example.com", nil, nil),
+	}
+	for _, msg := range messages {
+		msg.SeqNum = msg.UID
+	}
+
+	threads := groupServerThreads(messages, []imapclient.ThreadData{
+		{Chain: []uint32{1}, SubThreads: []imapclient.ThreadData{{Chain: []uint32{3}}}},
+		{Chain: []uint32{2}},
+	})
+	require.Len(t, threads, 3)
+	assert.Equal(t, []uint32{4}, threads[0].UIDs())
+	assert.Equal(t, []uint32{1, 3}, threads[1].UIDs())
+	assert.Equal(t, []uint32{2}, threads[2].UIDs())
+}
+
+func TestServerThreadAlgorithm(t *testing.T) {
+	caps := imap.CapSet{"THREAD=REFERENCES": {}}
+
+	rule := &Rule{}
+	algorithm, ok := rule.serverThreadAlgorithm(caps)
+	assert.True(t, ok)
+	assert.Equal(t, imap.ThreadReferences, algorithm)
+
+	rule.Output.ThreadAlgorithm = ThreadAlgorithmOrderedSubject
+	_, ok = rule.serverThreadAlgorithm(caps)
+	assert.False(t, ok, "falls back when the server lacks the algorithm")
+
+	rule.Output.ThreadAlgorithm = ThreadAlgorithmClient
+	_, ok = rule.serverThreadAlgorithm(caps)
+	assert.False(t, ok)
+}
@@ -0,0 +1,73 @@
+package dsl
+
+import (
+	"context"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation scope of the rule spans.
+const TracerName = "github.com/go-go-golems/smailnail/pkg/dsl"
+
+// Span attributes set on the rule spans.
+const (
+	AttrRule     = attribute.Key("smailnail.rule")
+	AttrMailbox  = attribute.Key("imap.mailbox")
+	AttrMatched  = attribute.Key("smailnail.messages.matched")
+	AttrMessages = attribute.Key("smailnail.messages")
+	AttrFailed   = attribute.Key("smailnail.messages.failed")
+	AttrBytes    = attribute.Key("imap.bytes")
+	AttrAction   = attribute.Key("smailnail.action")
+)
+
+// SetTracerProvider sets the OpenTelemetry tracer provider the rule's
+// search, fetch batches and actions are traced with, instead of the global
+// one.
+func (rule *Rule) SetTracerProvider(provider trace.TracerProvider) {
+	rule.tracerProvider = provider
+}
+
+// SetTraceContext sets the context holding the parent of the rule's spans,
+// e.g. a span covering the whole rule run.
+func (rule *Rule) SetTraceContext(ctx context.Context) {
+	rule.traceContext = ctx
+}
+
+// startSpan starts a span of the rule, tagged with the rule name.
+func (rule *Rule) startSpan(name string, attrs ...attribute.KeyValue) trace.Span {
+	provider := rule.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	ctx := rule.traceContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if rule.Name != "" {
+		attrs = append(attrs, AttrRule.String(rule.Name))
+	}
+	_, span := provider.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endSpan ends span, recording err if not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// selectedMailbox returns the name of the mailbox selected on client, for
+// span attributes.
+func selectedMailbox(client *imapclient.Client) string {
+	if mailbox := client.Mailbox(); mailbox != nil {
+		return mailbox.Name
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package dsl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestRuleSpans(t *testing.T) {
+	rule, err := ParseRuleFile("testdata/imap/move_invoices.yaml")
+	require.NoError(t, err)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	rule.SetTracerProvider(provider)
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "run")
+	rule.SetTraceContext(ctx)
+
+	client, done := replayClient(t, "testdata/imap/move_invoices.imap")
+	_, err = SelectMailbox(client, "INBOX")
+	require.NoError(t, err)
+	messages, err := rule.FetchMessages(client)
+	require.NoError(t, err)
+	require.NoError(t, rule.ExecuteActions(client, messages))
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 5)
+	for _, span := range spans[:4] {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, "move-invoices", spanAttributes(span)[AttrRule].AsString())
+	}
+
+	assert.Equal(t, "imap.search", spans[0].Name())
+	search := spanAttributes(spans[0])
+	assert.Equal(t, "INBOX", search[AttrMailbox].AsString())
+	assert.Equal(t, int64(2), search[AttrMatched].AsInt64())
+
+	assert.Equal(t, "imap.fetch", spans[1].Name())
+	assert.Equal(t, int64(2), spanAttributes(spans[1])[AttrMessages].AsInt64())
+
+	assert.Equal(t, "smailnail.action", spans[2].Name())
+	assert.Equal(t, "flags", spanAttributes(spans[2])[AttrAction].AsString())
+	assert.Equal(t, "move_to", spanAttributes(spans[3])[AttrAction].AsString())
+	assert.Equal(t, int64(2), spanAttributes(spans[3])[AttrMessages].AsInt64())
+}
+
+func TestActionSpanError(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	rule := &Rule{Name: "r"}
+	rule.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	rec := &actionRecorder{messages: 2, rule: rule}
+	err := rec.run("move_to", func() error { return errors.New("no such mailbox") })
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "no such mailbox", spans[0].Status().Description)
+	assert.Equal(t, int64(2), spanAttributes(spans[0])[AttrFailed].AsInt64())
+}
@@ -1,12 +1,16 @@
 package dsl
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/emersion/go-imap/v2"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Operator represents a boolean logic operator
@@ -25,6 +29,84 @@ type Rule struct {
 	Search      SearchConfig `yaml:"search"`
 	Output      OutputConfig `yaml:"output"`
 	Actions     ActionConfig `yaml:"actions,omitempty"`
+	// Status is checked against the mailbox STATUS before searching
+	Status *StatusCheck `yaml:"status,omitempty"`
+	// Chunking splits the search into date windows on servers that cap
+	// SEARCH results
+	Chunking *ChunkingConfig `yaml:"chunking,omitempty"`
+	// Decrypt enables decryption of PGP/MIME and S/MIME messages
+	Decrypt *DecryptConfig `yaml:"decrypt,omitempty"`
+	// Mailbox is the mailbox the rule searches, overriding --mailbox
+	Mailbox string `yaml:"mailbox,omitempty"`
+	// DependsOn names rules that must succeed before this one runs when
+	// several rules are run together
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// InputFrom names a rule whose fetched messages this rule searches
+	// among, instead of the whole mailbox. It implies depends_on.
+	InputFrom string `yaml:"input_from,omitempty"`
+	// ProtectedMailboxes are glob patterns of mailboxes this rule may not
+	// delete, move or snooze messages out of, on top of the global list
+	ProtectedMailboxes []string `yaml:"protected_mailboxes,omitempty"`
+	// AllowProtected are glob patterns of protected mailboxes this rule may
+	// remove messages from anyway
+	AllowProtected []string `yaml:"allow_protected,omitempty"`
+	// Classify labels the matched messages for output, search.expr and
+	// actions.by_label
+	Classify *ClassifyConfig `yaml:"classify,omitempty"`
+	// LogLevel is the minimum level of the rule's log messages, e.g. debug
+	// to trace a single rule. The global log level still applies.
+	LogLevel string `yaml:"log_level,omitempty"`
+	// Expect lists what the rule should do to fixture messages, checked by
+	// smailnail test and ignored when the rule runs
+	Expect []Expectation `yaml:"expect,omitempty"`
+	// Tags group rules, so that --tags runs a selection of them
+	Tags []string `yaml:"tags,omitempty"`
+	// Owner is who to ask about the rule
+	Owner string `yaml:"owner,omitempty"`
+	// Enabled false keeps the rule from running; rules are enabled by
+	// default
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Priority orders the rules of a run: lower priorities run first, rules
+	// of the same priority keep their order
+	Priority int `yaml:"priority,omitempty"`
+	// Dedup false lets the rule act on messages other rules already acted on
+	// in a run with --dedup
+	Dedup *bool `yaml:"dedup,omitempty"`
+	// DryRun fetches and outputs the matched messages without running the
+	// actions, e.g. in the overlay of a test environment
+	DryRun bool `yaml:"dry_run,omitempty"`
+	// Backfill processes the older mail of the mailbox in batches before
+	// the rule runs its usual search
+	Backfill *BackfillConfig `yaml:"backfill,omitempty"`
+
+	// matched is the number of messages the last search matched
+	matched int
+	// me are your own addresses, for search.to_me
+	me []string
+	// sender delivers the messages of actions that send mail
+	sender Sender
+	// handled is set with SetHandled
+	handled *HandledMessages
+	// processed is set with SetProcessed
+	processed *HandledMessages
+	// input is set with SetInput
+	input *Rule
+	// fetched are the UIDs of the messages the last fetch returned
+	fetched imap.UIDSet
+	// classifiers are added with AddClassifier
+	classifiers []Classifier
+	// summarizer replaces the chat API client for the summary field
+	summarizer Summarizer
+	// namespace is set with SetNamespace
+	namespace *Namespace
+	// logger is set with SetLogger
+	logger *zerolog.Logger
+	// subscribers are added with Subscribe
+	subscribers []Subscriber
+	// tracerProvider is set with SetTracerProvider
+	tracerProvider trace.TracerProvider
+	// traceContext is set with SetTraceContext
+	traceContext context.Context
 }
 
 // Validate checks if the rule is valid
@@ -32,25 +114,94 @@ func (r *Rule) Validate() error {
 	if r.Name == "" {
 		return fmt.Errorf("rule name is required")
 	}
+	for _, tag := range r.Tags {
+		if !validTag(tag) {
+			return fmt.Errorf("invalid tag %q: tags are single words without commas", tag)
+		}
+	}
 
 	if err := r.Search.Validate(); err != nil {
 		return fmt.Errorf("invalid search config: %w", err)
 	}
 
+	if r.Status != nil {
+		if err := r.Status.Validate(); err != nil {
+			return fmt.Errorf("invalid status check: %w", err)
+		}
+	}
+
+	if r.Chunking != nil {
+		if err := r.Chunking.Validate(); err != nil {
+			return fmt.Errorf("invalid chunking config: %w", err)
+		}
+	}
+
 	if err := r.Output.Validate(); err != nil {
 		return fmt.Errorf("invalid output config: %w", err)
 	}
 
+	if r.Decrypt != nil {
+		if err := r.Decrypt.Validate(); err != nil {
+			return fmt.Errorf("invalid decrypt config: %w", err)
+		}
+	}
+
+	if err := ValidateMailboxPatterns(r.ProtectedMailboxes); err != nil {
+		return fmt.Errorf("invalid protected_mailboxes: %w", err)
+	}
+	if err := ValidateMailboxPatterns(r.AllowProtected); err != nil {
+		return fmt.Errorf("invalid allow_protected: %w", err)
+	}
+
+	if r.LogLevel != "" {
+		if _, err := zerolog.ParseLevel(r.LogLevel); err != nil {
+			return fmt.Errorf("invalid log_level %q", r.LogLevel)
+		}
+	}
+
+	if r.Backfill != nil {
+		if err := r.Backfill.Validate(); err != nil {
+			return fmt.Errorf("invalid backfill config: %w", err)
+		}
+	}
+
+	if r.Classify != nil {
+		if err := r.Classify.Validate(); err != nil {
+			return fmt.Errorf("invalid classify config: %w", err)
+		}
+	}
+
 	// Validate actions if present
 	if err := r.Actions.Validate(); err != nil {
 		return fmt.Errorf("invalid actions config: %w", err)
 	}
+	if r.Decrypt != nil && r.Search.clientSideBody() {
+		return fmt.Errorf("invalid search config: 'client_side_body' and 'body_regex' cannot be combined with decrypt")
+	}
+	if len(r.Actions.ByLabel) > 0 && r.Classify == nil {
+		return fmt.Errorf("invalid actions config: by_label needs a classify section")
+	}
+	if r.Actions.Database != nil {
+		if err := r.Actions.Database.checkFields(r.Output); err != nil {
+			return fmt.Errorf("invalid database action: %w", err)
+		}
+	}
+
+	for i := range r.Expect {
+		if err := r.Expect[i].Validate(); err != nil {
+			return fmt.Errorf("invalid expect entry %d: %w", i+1, err)
+		}
+	}
 
 	return nil
 }
 
 // SearchConfig defines search criteria
 type SearchConfig struct {
+	// Query is a query string compiled into the other fields (see
+	// ParseQuery), e.g. from:boss@example.com -flag:seen since:7d
+	Query string `yaml:"query,omitempty"`
+
 	// Date-based search
 	Since      string `yaml:"since,omitempty"`
 	Before     string `yaml:"before,omitempty"`
@@ -65,20 +216,72 @@ type SearchConfig struct {
 	Subject         string          `yaml:"subject,omitempty"`
 	SubjectContains string          `yaml:"subject_contains,omitempty"`
 	Header          *HeaderCriteria `yaml:"header,omitempty"`
+	// HeaderMissing matches messages that have none of these headers
+	HeaderMissing []string `yaml:"header_missing,omitempty"`
+
+	// Mailing list search: is_mailing_list matches messages with a List-Id
+	// or List-Unsubscribe header, list_id searches the List-Id header
+	IsMailingList *bool  `yaml:"is_mailing_list,omitempty"`
+	ListID        string `yaml:"list_id,omitempty"`
+
+	// Address lists: files with one address or domain per line (see
+	// AddressList). Relative paths are relative to the rule file.
+	FromInFile    string `yaml:"from_in_file,omitempty"`
+	FromNotInFile string `yaml:"from_not_in_file,omitempty"`
 
 	// Content-based search
 	BodyContains string `yaml:"body_contains,omitempty"`
 	Text         string `yaml:"text,omitempty"`
+	// BodyRegex is a regular expression matched against the decoded text
+	// parts, always on the client (see ClientSideBody)
+	BodyRegex string `yaml:"body_regex,omitempty"`
+	// ClientSideBody matches body_contains and text on the client, against
+	// the downloaded text parts of the messages the other criteria found, for
+	// servers whose body search is unreliable
+	ClientSideBody bool `yaml:"client_side_body,omitempty"`
+	// ClientSideBodyConcurrency is the number of text part downloads in
+	// flight, DefaultClientSideBodyConcurrency if 0
+	ClientSideBodyConcurrency int `yaml:"client_side_body_concurrency,omitempty"`
 
 	// Flag-based search
 	Flags *FlagCriteria `yaml:"flags,omitempty"`
+	// Keywords searches user-defined flags such as $Todo verbatim, checked
+	// against the PERMANENTFLAGS of the mailbox (see Rule.CheckKeywords)
+	Keywords *KeywordCriteria `yaml:"keywords,omitempty"`
+
+	// Flag shorthands: unread: true is flags.not_has: [seen], answered: false
+	// is flags.not_has: [answered]
+	Unread   *bool `yaml:"unread,omitempty"`
+	Answered *bool `yaml:"answered,omitempty"`
+	Flagged  *bool `yaml:"flagged,omitempty"`
 
 	// Size-based search
 	Size *SizeCriteria `yaml:"size,omitempty"`
 
+	// Attachment-based search, evaluated client-side against the downloaded
+	// attachments (see AttachmentCriteria)
+	AttachmentSHA256In     []string            `yaml:"attachment_sha256_in,omitempty"`
+	AttachmentSHA256InFile string              `yaml:"attachment_sha256_in_file,omitempty"`
+	Attachment             *AttachmentCriteria `yaml:"attachment,omitempty"`
+
+	// Recipient criteria, evaluated client-side on the envelope: the number
+	// of distinct To, Cc and Bcc addresses, and whether one of your own
+	// addresses (see Rule.SetMyAddresses) is in To or Cc
+	MinRecipients int   `yaml:"min_recipients,omitempty"`
+	MaxRecipients int   `yaml:"max_recipients,omitempty"`
+	ToMe          *bool `yaml:"to_me,omitempty"`
+
+	// Language keeps messages whose text is detected to be in one of these
+	// languages (see DetectLanguage), evaluated client-side
+	Language []string `yaml:"language,omitempty"`
+
 	// Complex conditions with boolean operators
 	Operator   Operator              `yaml:"operator,omitempty"`
 	Conditions []ComplexSearchConfig `yaml:"conditions,omitempty"`
+
+	// Client-side predicate evaluated against fetched messages after the
+	// server-side search (see ExprFilter)
+	Expr string `yaml:"expr,omitempty"`
 }
 
 // ComplexSearchConfig defines a search condition that can contain nested conditions
@@ -91,6 +294,8 @@ type ComplexSearchConfig struct {
 type HeaderCriteria struct {
 	Name  string `yaml:"name"`
 	Value string `yaml:"value"`
+	// Absent matches messages without the header
+	Absent bool `yaml:"absent,omitempty"`
 }
 
 // FlagCriteria defines criteria for searching by flags
@@ -107,6 +312,10 @@ type SizeCriteria struct {
 
 // Validate checks if the search config is valid
 func (s *SearchConfig) Validate() error {
+	if s.Query != "" {
+		return fmt.Errorf("'query' must be expanded with ExpandQuery before validation")
+	}
+
 	// Check date criteria
 	if s.Since != "" {
 		if _, err := parseDate(s.Since); err != nil {
@@ -131,6 +340,14 @@ func (s *SearchConfig) Validate() error {
 		if s.Header.Name == "" {
 			return fmt.Errorf("header name is required when using header search")
 		}
+		if s.Header.Absent && s.Header.Value != "" {
+			return fmt.Errorf("header value cannot be combined with absent")
+		}
+	}
+	for _, name := range s.HeaderMissing {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, ": \t") {
+			return fmt.Errorf("invalid header name in 'header_missing': %q", name)
+		}
 	}
 
 	// Check flag criteria
@@ -148,6 +365,12 @@ func (s *SearchConfig) Validate() error {
 		}
 	}
 
+	if s.Keywords != nil {
+		if err := s.Keywords.Validate(); err != nil {
+			return err
+		}
+	}
+
 	// Check size criteria
 	if s.Size != nil {
 		if s.Size.LargerThan != "" {
@@ -180,12 +403,61 @@ func (s *SearchConfig) Validate() error {
 
 		// Validate each nested condition
 		for i, condition := range s.Conditions {
+			if condition.Query != "" {
+				return fmt.Errorf("invalid condition at index %d: 'query' is only supported at the top level of search", i)
+			}
+			if condition.Expr != "" {
+				return fmt.Errorf("invalid condition at index %d: 'expr' is only supported at the top level of search", i)
+			}
+			if condition.FromInFile != "" || condition.FromNotInFile != "" {
+				return fmt.Errorf("invalid condition at index %d: 'from_in_file' and 'from_not_in_file' are only supported at the top level of search", i)
+			}
+			if condition.searchesAttachments() {
+				return fmt.Errorf("invalid condition at index %d: attachment criteria are only supported at the top level of search", i)
+			}
+			if condition.searchesRecipients() {
+				return fmt.Errorf("invalid condition at index %d: recipient criteria are only supported at the top level of search", i)
+			}
+			if len(condition.Language) > 0 {
+				return fmt.Errorf("invalid condition at index %d: 'language' is only supported at the top level of search", i)
+			}
+			if condition.clientSideBody() {
+				return fmt.Errorf("invalid condition at index %d: 'client_side_body' and 'body_regex' are only supported at the top level of search", i)
+			}
 			if err := condition.Validate(); err != nil {
 				return fmt.Errorf("invalid condition at index %d: %w", i, err)
 			}
 		}
 	}
 
+	for _, hash := range s.AttachmentSHA256In {
+		if err := validateSHA256(hash); err != nil {
+			return fmt.Errorf("invalid 'attachment_sha256_in': %w", err)
+		}
+	}
+	if s.Attachment != nil {
+		if err := s.Attachment.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.validateRecipients(); err != nil {
+		return err
+	}
+	if err := s.validateLanguages(); err != nil {
+		return err
+	}
+	if err := s.validateClientSideBody(); err != nil {
+		return err
+	}
+
+	// Check client-side expression
+	if s.Expr != "" {
+		if _, err := CompileExpr(s.Expr); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -197,35 +469,139 @@ func (c *ComplexSearchConfig) Validate() error {
 
 // OutputConfig defines output formatting
 type OutputConfig struct {
-	Format    string        `yaml:"format,omitempty"`     // json, text, table
+	Format    string        `yaml:"format,omitempty"`     // json, ndjson, text, table, atom
 	Limit     int           `yaml:"limit,omitempty"`      // Maximum number of messages to return
 	Offset    int           `yaml:"offset,omitempty"`     // Number of messages to skip for pagination
 	AfterUID  uint32        `yaml:"after_uid,omitempty"`  // Fetch messages with UIDs greater than this value
 	BeforeUID uint32        `yaml:"before_uid,omitempty"` // Fetch messages with UIDs less than this value
 	Fields    []interface{} `yaml:"fields,omitempty"`
+	Template  string        `yaml:"template,omitempty"` // Go template used by the text format
+
+	DateFormat string `yaml:"date_format,omitempty"` // Go layout for dates, RFC 3339 by default
+	Timezone   string `yaml:"timezone,omitempty"`    // IANA zone or "Local" for dates
+	SizeFormat string `yaml:"size_format,omitempty"` // human or bytes
+
+	// GroupByThread emits one summary per conversation instead of one entry
+	// per message
+	GroupByThread bool `yaml:"group_by_thread,omitempty"`
+	// ThreadAlgorithm selects the server THREAD algorithm (references,
+	// orderedsubject) or client-side threading (client)
+	ThreadAlgorithm string `yaml:"thread_algorithm,omitempty"`
+
+	// SortBy orders results by comma separated keys, "-" reverses a key
+	SortBy string `yaml:"sort_by,omitempty"`
+
+	// FlagSummary emits the number of matched messages per flag and keyword
+	// instead of the messages
+	FlagSummary bool `yaml:"flag_summary,omitempty"`
+	// FlagSummaryPerMailbox counts the flags of each mailbox apart
+	FlagSummaryPerMailbox bool `yaml:"flag_summary_per_mailbox,omitempty"`
+
+	// Summarize configures the LLM behind the summary field
+	Summarize *SummarizeConfig `yaml:"summarize,omitempty"`
+
+	// Extract pulls fields out of the message bodies with regular
+	// expressions and CSS selectors; they are output like built-in fields
+	Extract []ExtractConfig `yaml:"extract,omitempty"`
+
+	// HTMLTables configures the html_tables field
+	HTMLTables *HTMLTablesConfig `yaml:"html_tables,omitempty"`
 }
 
 // Validate checks if the output config is valid
 func (o *OutputConfig) Validate() error {
-	if o.Format != "" && o.Format != "json" && o.Format != "text" && o.Format != "table" {
-		return fmt.Errorf("invalid format: %s (must be 'json', 'text', or 'table')", o.Format)
+	if o.Format != "" && o.Format != "json" && o.Format != "ndjson" && o.Format != "text" && o.Format != "table" && o.Format != FormatAtom {
+		return fmt.Errorf("invalid format: %s (must be 'json', 'ndjson', 'text', 'table' or 'atom')", o.Format)
 	}
 
-	if len(o.Fields) == 0 {
+	// A flag summary has no per-message fields
+	if len(o.Fields) == 0 && !o.FlagSummary {
 		return fmt.Errorf("at least one output field is required")
 	}
 
+	if o.Template != "" {
+		if err := ValidateTemplate(o.Template); err != nil {
+			return fmt.Errorf("invalid output template: %w", err)
+		}
+	}
+
+	if _, err := o.location(); err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	if o.SizeFormat != "" && o.SizeFormat != SizeFormatHuman && o.SizeFormat != SizeFormatBytes {
+		return fmt.Errorf("invalid size_format: %s (must be 'human' or 'bytes')", o.SizeFormat)
+	}
+
+	if o.GroupByThread && o.Template != "" {
+		return fmt.Errorf("output template cannot be combined with group_by_thread")
+	}
+
+	if o.FlagSummary && (o.GroupByThread || o.Template != "") {
+		return fmt.Errorf("flag_summary cannot be combined with group_by_thread or an output template")
+	}
+	if o.FlagSummaryPerMailbox && !o.FlagSummary {
+		return fmt.Errorf("flag_summary_per_mailbox requires flag_summary")
+	}
+
+	switch o.ThreadAlgorithm {
+	case "", ThreadAlgorithmReferences, ThreadAlgorithmOrderedSubject, ThreadAlgorithmClient:
+	default:
+		return fmt.Errorf("invalid thread_algorithm: %s (must be 'references', 'orderedsubject' or 'client')", o.ThreadAlgorithm)
+	}
+
+	if _, err := ParseSortBy(o.SortBy); err != nil {
+		return fmt.Errorf("invalid sort_by: %w", err)
+	}
+
 	if o.Limit < 0 {
 		return fmt.Errorf("limit cannot be negative")
 	}
 
+	if o.Summarize != nil {
+		if err := o.Summarize.Validate(); err != nil {
+			return fmt.Errorf("invalid summarize config: %w", err)
+		}
+	}
+
+	if o.HTMLTables != nil {
+		if err := o.HTMLTables.Validate(); err != nil {
+			return fmt.Errorf("invalid html_tables config: %w", err)
+		}
+	}
+
+	extracted := make(map[string]bool)
+	for i := range o.Extract {
+		e, err := o.Extract[i].compile()
+		if err != nil {
+			return fmt.Errorf("invalid extract %d: %w", i, err)
+		}
+		for _, name := range e.fields {
+			if extracted[name] {
+				return fmt.Errorf("field %s is extracted twice", name)
+			}
+			extracted[name] = true
+		}
+	}
+
 	// Validate fields
+	outputNames := make(map[string]bool)
 	for _, fieldInterface := range o.Fields {
 		field, ok := fieldInterface.(Field)
 		if !ok {
 			continue
 		}
 
+		if field.IsPath() {
+			if err := validateFieldPath(field.Name); err != nil {
+				return err
+			}
+		}
+		if outputNames[field.OutputName()] {
+			return fmt.Errorf("duplicate output field name: %s", field.OutputName())
+		}
+		outputNames[field.OutputName()] = true
+
 		// Validate mime_parts field
 		if field.Name == "mime_parts" && field.Content != nil {
 			if field.Content.Mode != "" &&
@@ -248,9 +624,26 @@ func (o *OutputConfig) Validate() error {
 func (o *OutputConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// Define a temporary struct to unmarshal into
 	type tempOutputConfig struct {
-		Format string        `yaml:"format"`
-		Limit  int           `yaml:"limit"`
-		Fields []interface{} `yaml:"fields"`
+		Format   string        `yaml:"format"`
+		Limit    int           `yaml:"limit"`
+		Fields   []interface{} `yaml:"fields"`
+		Template string        `yaml:"template"`
+
+		DateFormat string `yaml:"date_format"`
+		Timezone   string `yaml:"timezone"`
+		SizeFormat string `yaml:"size_format"`
+
+		GroupByThread   bool   `yaml:"group_by_thread"`
+		ThreadAlgorithm string `yaml:"thread_algorithm"`
+		SortBy          string `yaml:"sort_by"`
+
+		FlagSummary           bool `yaml:"flag_summary"`
+		FlagSummaryPerMailbox bool `yaml:"flag_summary_per_mailbox"`
+
+		Summarize *SummarizeConfig `yaml:"summarize"`
+		Extract   []ExtractConfig  `yaml:"extract"`
+
+		HTMLTables *HTMLTablesConfig `yaml:"html_tables"`
 	}
 
 	// Unmarshal into the temporary struct
@@ -262,14 +655,27 @@ func (o *OutputConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// Copy the simple fields
 	o.Format = temp.Format
 	o.Limit = temp.Limit
+	o.Template = temp.Template
+	o.DateFormat = temp.DateFormat
+	o.Timezone = temp.Timezone
+	o.SizeFormat = temp.SizeFormat
+	o.GroupByThread = temp.GroupByThread
+	o.ThreadAlgorithm = temp.ThreadAlgorithm
+	o.SortBy = temp.SortBy
+	o.FlagSummary = temp.FlagSummary
+	o.FlagSummaryPerMailbox = temp.FlagSummaryPerMailbox
+	o.Summarize = temp.Summarize
+	o.Extract = temp.Extract
+	o.HTMLTables = temp.HTMLTables
 	o.Fields = make([]interface{}, len(temp.Fields))
 
 	// Process each field
 	for i, field := range temp.Fields {
 		switch f := field.(type) {
 		case string:
-			// Simple field like "subject", "from", etc.
-			o.Fields[i] = Field{Name: f}
+			// Simple field like "subject", "from", etc., or a dotted path,
+			// optionally aliased: "envelope.from[0].address as sender"
+			o.Fields[i] = parseFieldSpec(f)
 		case map[string]interface{}:
 			// Complex field like body: {type: "text/plain", max_length: 1000}
 			if contentMap, ok := f["body"].(map[string]interface{}); ok {
@@ -356,8 +762,23 @@ func (o *OutputConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			// Just store as is
 			o.Fields[i] = field
 		}
+
+		// Alias and explicit position can be given on any map form
+		if f, ok := field.(map[string]interface{}); ok {
+			if parsed, ok := o.Fields[i].(Field); ok {
+				if as, ok := f["as"].(string); ok {
+					parsed.As = as
+				}
+				if order, ok := f["order"].(int); ok {
+					parsed.Order = order
+				}
+				o.Fields[i] = parsed
+			}
+		}
 	}
 
+	sortFieldsByOrder(o.Fields)
+
 	return nil
 }
 
@@ -365,6 +786,10 @@ func (o *OutputConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 type Field struct {
 	Name    string        `yaml:"name"`
 	Content *ContentField `yaml:"content,omitempty"`
+	// As renames the field in the output ("envelope.from[0].address as sender")
+	As string `yaml:"as,omitempty"`
+	// Order places the field explicitly; fields without an order follow
+	Order int `yaml:"order,omitempty"`
 	// More field types will be added later
 }
 
@@ -468,6 +893,11 @@ func parseSize(sizeStr string) (int64, error) {
 	return size, nil
 }
 
+// ParseSize parses a size with the units of size criteria: 100B, 10K, 5M, 1G.
+func ParseSize(sizeStr string) (int64, error) {
+	return parseSize(sizeStr)
+}
+
 // isValidFlag checks if a flag name is valid
 func isValidFlag(flag string) bool {
 	// Standard IMAP flags
@@ -513,6 +943,44 @@ type ActionConfig struct {
 
 	// Export operation
 	Export *ExportConfig `yaml:"export,omitempty"`
+
+	// Snooze operation: move to a holding folder until a wake time
+	Snooze *SnoozeConfig `yaml:"snooze,omitempty"`
+
+	// Follow-up operation: flag as awaiting a reply and remind if none arrives
+	FollowUp *FollowUpConfig `yaml:"follow_up,omitempty"`
+
+	// Unsubscribe operation: follow the List-Unsubscribe headers
+	Unsubscribe *UnsubscribeConfig `yaml:"unsubscribe,omitempty"`
+
+	// Notify operation: alert humans by email, chat webhook or desktop
+	// notification
+	Notify *NotifyConfig `yaml:"notify,omitempty"`
+
+	// IndexElasticsearch operation: bulk-index the messages as JSON
+	// documents in Elasticsearch or OpenSearch
+	IndexElasticsearch *ElasticsearchConfig `yaml:"index_elasticsearch,omitempty"`
+
+	// Publish operation: send an event per message to Kafka or NATS
+	Publish *PublishConfig `yaml:"publish,omitempty"`
+
+	// Database operation: upsert a row per message into a SQL table
+	Database *DatabaseConfig `yaml:"database,omitempty"`
+
+	// CreateIssue operation: open a GitHub or Jira issue per message
+	CreateIssue *CreateIssueConfig `yaml:"create_issue,omitempty"`
+
+	// ByLabel runs other actions on the messages with a given label, in
+	// place of the ones above
+	ByLabel map[string]*ActionConfig `yaml:"by_label,omitempty"`
+
+	// Stop keeps the later rules of the run from seeing the messages once
+	// the actions above succeeded
+	Stop bool `yaml:"stop,omitempty"`
+
+	// Custom actions registered through an ActionRegistry, keyed by name.
+	// Each value is the raw config blob passed to the handler.
+	Custom map[string]interface{} `yaml:",inline"`
 }
 
 // FlagActions defines add/remove flag operations
@@ -530,6 +998,63 @@ func (a *ActionConfig) Validate() error {
 		}
 	}
 
+	if a.Snooze != nil {
+		if err := a.Snooze.Validate(); err != nil {
+			return fmt.Errorf("invalid snooze action: %w", err)
+		}
+		if a.MoveTo != "" {
+			return fmt.Errorf("snooze cannot be combined with move_to")
+		}
+	}
+
+	if a.FollowUp != nil {
+		if err := a.FollowUp.Validate(); err != nil {
+			return fmt.Errorf("invalid follow_up action: %w", err)
+		}
+	}
+
+	if a.Notify != nil {
+		if err := a.Notify.Validate(); err != nil {
+			return fmt.Errorf("invalid notify action: %w", err)
+		}
+	}
+
+	if a.IndexElasticsearch != nil {
+		if err := a.IndexElasticsearch.Validate(); err != nil {
+			return fmt.Errorf("invalid index_elasticsearch action: %w", err)
+		}
+	}
+
+	if a.Publish != nil {
+		if err := a.Publish.Validate(); err != nil {
+			return fmt.Errorf("invalid publish action: %w", err)
+		}
+	}
+
+	if a.Database != nil {
+		if err := a.Database.Validate(); err != nil {
+			return fmt.Errorf("invalid database action: %w", err)
+		}
+	}
+
+	if a.CreateIssue != nil {
+		if err := a.CreateIssue.Validate(); err != nil {
+			return fmt.Errorf("invalid create_issue action: %w", err)
+		}
+	}
+
+	// Validate templated mailbox targets
+	if isTemplate(a.CopyTo) {
+		if err := ValidateTemplate(a.CopyTo); err != nil {
+			return fmt.Errorf("invalid copy_to: %w", err)
+		}
+	}
+	if isTemplate(a.MoveTo) {
+		if err := ValidateTemplate(a.MoveTo); err != nil {
+			return fmt.Errorf("invalid move_to: %w", err)
+		}
+	}
+
 	// Validate export config
 	if a.Export != nil {
 		if err := a.Export.Validate(); err != nil {
@@ -537,6 +1062,33 @@ func (a *ActionConfig) Validate() error {
 		}
 	}
 
+	// Validate the per-label actions, which cannot nest
+	for label, actions := range a.ByLabel {
+		if actions == nil {
+			return fmt.Errorf("by_label %s has no actions", label)
+		}
+		if len(actions.ByLabel) > 0 {
+			return fmt.Errorf("by_label %s cannot contain by_label", label)
+		}
+		if actions.Stop {
+			return fmt.Errorf("by_label %s cannot contain stop, set it on the rule's actions", label)
+		}
+		if err := actions.Validate(); err != nil {
+			return fmt.Errorf("invalid by_label %s: %w", label, err)
+		}
+	}
+
+	// Validate custom actions against the registry
+	for _, name := range sortedCustomActionNames(a.Custom) {
+		handler, ok := DefaultActionRegistry.Lookup(name)
+		if !ok {
+			return fmt.Errorf("unknown action: %s", name)
+		}
+		if err := handler.Validate(a.Custom[name]); err != nil {
+			return fmt.Errorf("invalid config for action %s: %w", name, err)
+		}
+	}
+
 	// Validate delete configuration
 	if a.Delete != nil {
 		switch deleteConfig := a.Delete.(type) {
@@ -577,8 +1129,45 @@ func (f *FlagActions) Validate() error {
 // Validate checks if the export config is valid
 func (e *ExportConfig) Validate() error {
 	// Validate format
-	if e.Format != "" && e.Format != "eml" && e.Format != "mbox" {
-		return fmt.Errorf("invalid format: %s (must be 'eml' or 'mbox')", e.Format)
+	if e.Format != "" && !isExportFormat(e.Format) {
+		return fmt.Errorf("invalid format: %s (must be %s)", e.Format, exportFormatNames())
+	}
+	if e.Format == ExportFormatJSONL && e.FilenameTemplate != "" {
+		return fmt.Errorf("filename_template cannot be used with the jsonl format, which writes a single %s", CorpusFile)
+	}
+	store, isStore := lookupExportStore(e.Format)
+	if isStore && e.FilenameTemplate != "" {
+		return fmt.Errorf("filename_template cannot be used with the %s format, which writes a single %s", e.Format, store.file)
+	}
+	if e.BodyChars < 0 {
+		return fmt.Errorf("body_chars must not be negative")
+	}
+	if e.BodyChars > 0 && !isStore {
+		return fmt.Errorf("body_chars can only be used with formats that write message metadata, like parquet")
+	}
+
+	if e.FilenameTemplate != "" {
+		if err := ValidateTemplate(e.FilenameTemplate); err != nil {
+			return fmt.Errorf("invalid filename_template: %w", err)
+		}
+	}
+
+	if e.Redact != nil {
+		if err := e.Redact.Validate(); err != nil {
+			return fmt.Errorf("invalid redact config: %w", err)
+		}
+	}
+
+	if e.Anonymize != nil {
+		if err := e.Anonymize.Validate(); err != nil {
+			return fmt.Errorf("invalid anonymize config: %w", err)
+		}
+	}
+
+	if e.Archive != "" {
+		if _, err := archiveFormat(e.Archive); err != nil {
+			return err
+		}
 	}
 
 	// If no format is specified, default to "eml"
@@ -596,7 +1185,21 @@ type DeleteConfig struct {
 
 // ExportConfig defines options for exporting messages
 type ExportConfig struct {
-	Format           string `yaml:"format,omitempty"`            // eml, mbox
+	Format           string `yaml:"format,omitempty"`            // eml, mbox, markdown, jsonl, or a registered format like sqlite
 	Directory        string `yaml:"directory,omitempty"`         // Where to save files
 	FilenameTemplate string `yaml:"filename_template,omitempty"` // Template for filenames
+	// Redact scrubs headers and body patterns before writing
+	Redact *RedactConfig `yaml:"redact,omitempty"`
+	// Anonymize pseudonymizes addresses, names and domains before writing
+	Anonymize *AnonymizeConfig `yaml:"anonymize,omitempty"`
+	// DedupAttachments stores each attachment once by hash under blobs/,
+	// with a manifest next to each message listing the blobs it references
+	DedupAttachments bool `yaml:"dedup_attachments,omitempty"`
+	// Archive writes all files into a single .zip or .tar.gz archive with
+	// an index.json instead of into Directory
+	Archive string `yaml:"archive,omitempty"`
+	// BodyChars includes the body of each message, truncated to that many
+	// characters, in formats that write message metadata like parquet. 0
+	// leaves bodies out.
+	BodyChars int `yaml:"body_chars,omitempty"`
 }
@@ -0,0 +1,295 @@
+package dsl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Sender delivers the messages of actions that send mail, such as mailto:
+// unsubscribes.
+type Sender interface {
+	// From is the address messages are sent from
+	From() string
+	// Send delivers msg, a complete RFC 5322 message, to the recipients
+	Send(to []string, msg []byte) error
+}
+
+// SetSender sets the Sender used by actions that send mail.
+func (rule *Rule) SetSender(sender Sender) {
+	rule.sender = sender
+}
+
+// UnsubscribeConfig unsubscribes from the lists the matched messages came
+// from, following their List-Unsubscribe headers. `unsubscribe: true` is
+// short for an empty config.
+type UnsubscribeConfig struct {
+	// DryRun reports what would be triggered without doing it
+	DryRun bool `yaml:"dry_run,omitempty"`
+
+	// disabled is set by `unsubscribe: false`
+	disabled bool
+}
+
+// UnmarshalYAML accepts a boolean or a config object.
+func (u *UnsubscribeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		u.disabled = !enabled
+		return nil
+	}
+	type plain UnsubscribeConfig
+	return unmarshal((*plain)(u))
+}
+
+// MarshalYAML writes the boolean form when there are no options.
+func (u UnsubscribeConfig) MarshalYAML() (interface{}, error) {
+	if u.disabled {
+		return false, nil
+	}
+	if !u.DryRun {
+		return true, nil
+	}
+	type plain UnsubscribeConfig
+	return plain(u), nil
+}
+
+// Unsubscribe methods, in order of preference.
+const (
+	// UnsubscribeOneClick is the RFC 8058 HTTPS POST
+	UnsubscribeOneClick = "one-click"
+	// UnsubscribeMailto sends a message to the mailto: address
+	UnsubscribeMailto = "mailto"
+)
+
+// unsubscribeSection fetches the headers the unsubscribe action follows.
+var unsubscribeSection = &imap.FetchItemBodySection{
+	Specifier:    imap.PartSpecifierHeader,
+	HeaderFields: []string{"List-Unsubscribe", "List-Unsubscribe-Post"},
+	Peek:         true,
+}
+
+// unsubscribeHTTPClient performs one-click unsubscribes.
+var unsubscribeHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+var angleURIPattern = regexp.MustCompile(`<([^>]*)>`)
+
+// UnsubscribeTarget is how to unsubscribe from the list a message came from.
+type UnsubscribeTarget struct {
+	Method string
+	URL    *url.URL
+}
+
+func (t *UnsubscribeTarget) String() string {
+	if t.Method == UnsubscribeOneClick {
+		return "POST " + t.URL.String()
+	}
+	return t.URL.String()
+}
+
+// ParseListUnsubscribe picks the unsubscribe method from the
+// List-Unsubscribe and List-Unsubscribe-Post header values: the HTTPS
+// one-click POST when the list supports it, the mailto: URI otherwise. Plain
+// HTTPS links need a browser and are not followed; nil is returned when there
+// is nothing to trigger.
+func ParseListUnsubscribe(listUnsubscribe, listUnsubscribePost string) *UnsubscribeTarget {
+	oneClick := strings.EqualFold(strings.TrimSpace(listUnsubscribePost), "List-Unsubscribe=One-Click")
+
+	var mailto *url.URL
+	for _, m := range angleURIPattern.FindAllStringSubmatch(listUnsubscribe, -1) {
+		u, err := url.Parse(strings.TrimSpace(m[1]))
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(u.Scheme) {
+		case "https":
+			if oneClick {
+				return &UnsubscribeTarget{Method: UnsubscribeOneClick, URL: u}
+			}
+		case "mailto":
+			if mailto == nil {
+				mailto = u
+			}
+		}
+	}
+	if mailto != nil {
+		return &UnsubscribeTarget{Method: UnsubscribeMailto, URL: mailto}
+	}
+	return nil
+}
+
+// unsubscribeMessage builds the message for a mailto: target, using its
+// subject and body parameters.
+func unsubscribeMessage(from string, target *url.URL, now time.Time) ([]string, []byte, error) {
+	addresses, err := url.PathUnescape(target.Opaque)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid mailto URI %s: %w", target, err)
+	}
+	var to []string
+	for _, addr := range strings.Split(addresses, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return nil, nil, fmt.Errorf("mailto URI %s has no address", target)
+	}
+
+	query := target.Query()
+	subject := query.Get("subject")
+	if subject == "" {
+		subject = "unsubscribe"
+	}
+	body := query.Get("body")
+	if body == "" {
+		body = "unsubscribe"
+	}
+
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "From: %s\r\n", from)
+	_, _ = fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	_, _ = fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	_, _ = fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(&b, "Message-ID: <unsubscribe.%d@smailnail.invalid>\r\n", now.UnixNano())
+	_, _ = fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	_, _ = fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	_, _ = fmt.Fprintf(&b, "\r\n")
+	_, _ = fmt.Fprintf(&b, "%s\r\n", strings.ReplaceAll(body, "\n", "\r\n"))
+	return to, []byte(b.String()), nil
+}
+
+// oneClickUnsubscribe sends the RFC 8058 POST.
+func oneClickUnsubscribe(ctx context.Context, target *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return fmt.Errorf("failed to create unsubscribe request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := unsubscribeHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unsubscribe request to %s returned %s", target.Host, resp.Status)
+	}
+	return nil
+}
+
+// fetchUnsubscribeTargets fetches the unsubscribe headers of messages.
+func fetchUnsubscribeTargets(client *imapclient.Client, messages []*EmailMessage) (map[uint32]*UnsubscribeTarget, error) {
+	fetched, err := client.Fetch(buildUIDSet(messages), &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{unsubscribeSection},
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch List-Unsubscribe headers: %w", err)
+	}
+
+	targets := make(map[uint32]*UnsubscribeTarget, len(fetched))
+	for _, buf := range fetched {
+		raw := buf.FindBodySection(unsubscribeSection)
+		if len(raw) == 0 {
+			continue
+		}
+		header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			continue
+		}
+		if target := ParseListUnsubscribe(header.Get("List-Unsubscribe"), header.Get("List-Unsubscribe-Post")); target != nil {
+			targets[uint32(buf.UID)] = target
+		}
+	}
+	return targets, nil
+}
+
+// executeUnsubscribe unsubscribes from the lists of the matched messages,
+// once per distinct target, and returns what was triggered, or would be
+// with dry_run.
+func executeUnsubscribe(client *imapclient.Client, messages []*EmailMessage, config *UnsubscribeConfig, rule *Rule) ([]string, error) {
+	logger := rule.Logger()
+	if config.disabled || len(messages) == 0 {
+		return nil, nil
+	}
+	targets, err := fetchUnsubscribeTargets(client, messages)
+	if err != nil {
+		return nil, err
+	}
+
+	var sender Sender
+	if rule != nil {
+		sender = rule.sender
+	}
+
+	var details []string
+	partial := &ActionPartialFailureError{Action: "unsubscribe"}
+	done := map[string]error{}
+	for _, msg := range messages {
+		target, ok := targets[msg.UID]
+		if !ok {
+			details = append(details, fmt.Sprintf("uid %d: no one-click or mailto unsubscribe", msg.UID))
+			continue
+		}
+		key := target.String()
+		if err, seen := done[key]; seen {
+			if err != nil {
+				partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: err})
+			} else {
+				partial.Succeeded = append(partial.Succeeded, msg.UID)
+			}
+			continue
+		}
+
+		if config.DryRun {
+			details = append(details, fmt.Sprintf("uid %d: would %s %s", msg.UID, target.Method, key))
+			done[key] = nil
+			partial.Succeeded = append(partial.Succeeded, msg.UID)
+			continue
+		}
+
+		switch target.Method {
+		case UnsubscribeOneClick:
+			err = oneClickUnsubscribe(context.Background(), target.URL)
+		case UnsubscribeMailto:
+			if sender == nil {
+				err = fmt.Errorf("mailto unsubscribe needs an SMTP server (--smtp-server)")
+				break
+			}
+			var to []string
+			var msgData []byte
+			to, msgData, err = unsubscribeMessage(sender.From(), target.URL, time.Now())
+			if err == nil {
+				err = sender.Send(to, msgData)
+			}
+		}
+		done[key] = err
+		if err != nil {
+			logger.Warn().Err(err).Uint32("uid", msg.UID).Str("target", key).Msg("Failed to unsubscribe")
+			details = append(details, fmt.Sprintf("uid %d: %s %s failed: %v", msg.UID, target.Method, key, err))
+			partial.Failed = append(partial.Failed, UIDError{UID: msg.UID, Err: err})
+			continue
+		}
+		logger.Info().Uint32("uid", msg.UID).Str("target", key).Msg("Unsubscribed")
+		details = append(details, fmt.Sprintf("uid %d: %s %s", msg.UID, target.Method, key))
+		partial.Succeeded = append(partial.Succeeded, msg.UID)
+	}
+
+	if len(partial.Failed) > 0 {
+		if len(partial.Succeeded) == 0 {
+			return details, partial.Failed[0].Err
+		}
+		return details, partial
+	}
+	return details, nil
+}
@@ -0,0 +1,102 @@
+package dsl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseListUnsubscribe(t *testing.T) {
+	header := "<mailto:leave@lists.example.org?subject=unsubscribe%20me>, <https://lists.example.org/u/abc>"
+
+	target := ParseListUnsubscribe(header, "List-Unsubscribe=One-Click")
+	require.NotNil(t, target)
+	assert.Equal(t, UnsubscribeOneClick, target.Method)
+	assert.Equal(t, "POST https://lists.example.org/u/abc", target.String())
+
+	// Without List-Unsubscribe-Post the link needs a browser
+	target = ParseListUnsubscribe(header, "")
+	require.NotNil(t, target)
+	assert.Equal(t, UnsubscribeMailto, target.Method)
+
+	assert.Nil(t, ParseListUnsubscribe("<https://lists.example.org/u/abc>", ""))
+	assert.Nil(t, ParseListUnsubscribe("<http://lists.example.org/u/abc>", "List-Unsubscribe=One-Click"))
+	assert.Nil(t, ParseListUnsubscribe("", ""))
+}
+
+func TestUnsubscribeMessage(t *testing.T) {
+	target, err := url.Parse("mailto:leave@lists.example.org?subject=unsubscribe%20me")
+	require.NoError(t, err)
+
+	to, msg, err := unsubscribeMessage("me@example.com", target, time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"leave@lists.example.org"}, to)
+	text := string(msg)
+	assert.Contains(t, text, "From: me@example.com\r\n")
+	assert.Contains(t, text, "To: leave@lists.example.org\r\n")
+	assert.Contains(t, text, "Subject: unsubscribe me\r\n")
+	assert.True(t, strings.HasSuffix(text, "\r\n\r\nunsubscribe\r\n"))
+
+	target, err = url.Parse("mailto:?subject=x")
+	require.NoError(t, err)
+	_, _, err = unsubscribeMessage("me@example.com", target, time.Now())
+	assert.ErrorContains(t, err, "has no address")
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	var body, contentType string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		contentType = r.Header.Get("Content-Type")
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer server.Close()
+
+	previous := unsubscribeHTTPClient
+	unsubscribeHTTPClient = server.Client()
+	defer func() { unsubscribeHTTPClient = previous }()
+
+	target, err := url.Parse(server.URL + "/u/abc")
+	require.NoError(t, err)
+	require.NoError(t, oneClickUnsubscribe(context.Background(), target))
+	assert.Equal(t, "List-Unsubscribe=One-Click", body)
+	assert.Equal(t, "application/x-www-form-urlencoded", contentType)
+
+	target, err = url.Parse(server.URL + "/gone")
+	require.NoError(t, err)
+	assert.ErrorContains(t, oneClickUnsubscribe(context.Background(), target), "410 Gone")
+}
+
+func TestUnsubscribeConfigYAML(t *testing.T) {
+	var actions ActionConfig
+	require.NoError(t, yaml.Unmarshal([]byte("unsubscribe: true"), &actions))
+	require.NotNil(t, actions.Unsubscribe)
+	assert.False(t, actions.Unsubscribe.disabled)
+	assert.False(t, actions.Unsubscribe.DryRun)
+
+	actions = ActionConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte("unsubscribe: {dry_run: true}"), &actions))
+	assert.True(t, actions.Unsubscribe.DryRun)
+	data, err := yaml.Marshal(actions)
+	require.NoError(t, err)
+	assert.Equal(t, "unsubscribe:\n    dry_run: true\n", string(data))
+
+	actions = ActionConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte("unsubscribe: false"), &actions))
+	assert.True(t, actions.Unsubscribe.disabled)
+	data, err = yaml.Marshal(actions)
+	require.NoError(t, err)
+	assert.Equal(t, "unsubscribe: false\n", string(data))
+}
@@ -0,0 +1,233 @@
+package filterimport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// Gmail folders as seen over IMAP.
+const (
+	gmailAllMail = "[Gmail]/All Mail"
+	gmailTrash   = "[Gmail]/Trash"
+)
+
+// gmailCriteria are the criteria properties gmailSearch converts.
+var gmailCriteria = map[string]bool{
+	"from": true, "to": true, "subject": true, "hasTheWord": true, "doesNotHaveTheWord": true,
+	"hasAttachment": true, "size": true, "sizeOperator": true, "sizeUnit": true, "excludeChats": true,
+}
+
+// gmailActionProperties are the action properties, converted or warned
+// about by gmailActions.
+var gmailActionProperties = map[string]bool{
+	"label": true, "shouldArchive": true, "shouldMarkAsRead": true, "shouldStar": true, "shouldTrash": true,
+	"shouldAlwaysMarkAsImportant": true, "shouldNeverMarkAsImportant": true,
+	"forwardTo": true, "shouldNeverSpam": true, "smartLabelToApply": true, "cannedResponse": true,
+}
+
+// gmailFeed is the Atom feed written by Gmail's "Export" filter button.
+type gmailFeed struct {
+	Entries []gmailEntry `xml:"entry"`
+}
+
+type gmailEntry struct {
+	ID         string          `xml:"id"`
+	Properties []gmailProperty `xml:"property"`
+}
+
+type gmailProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// ImportGmail converts a Gmail filter export (mailFilters.xml).
+func ImportGmail(r io.Reader) (*Result, error) {
+	var feed gmailFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to parse Gmail filters: %w", err)
+	}
+
+	result := &Result{}
+	for i, entry := range feed.Entries {
+		props := make(map[string]string, len(entry.Properties))
+		var order []string
+		for _, prop := range entry.Properties {
+			props[prop.Name] = prop.Value
+			order = append(order, prop.Name)
+		}
+		filter := fmt.Sprintf("gmail filter %d", i+1)
+
+		search, describe, ok := gmailSearch(result, filter, props, order)
+		if !ok {
+			continue
+		}
+		actions := gmailActions(result, filter, props)
+
+		rule := &dsl.Rule{
+			Name:        uniqueName(result, ruleName(append([]string{"gmail"}, describe...)...)),
+			Description: "Imported from Gmail filter " + entry.ID,
+			Search:      search,
+			Output:      defaultOutput(),
+			Actions:     actions,
+		}
+		if rule.Name == "gmail" {
+			rule.Name = uniqueName(result, fmt.Sprintf("gmail-filter-%d", i+1))
+		}
+		if validate(result, filter, rule) {
+			result.Rules = append(result.Rules, rule)
+		}
+	}
+	return result, nil
+}
+
+// gmailSearch converts the criteria properties. It also returns words
+// describing the filter for its rule name.
+func gmailSearch(result *Result, filter string, props map[string]string, order []string) (dsl.SearchConfig, []string, bool) {
+	var conditions []dsl.SearchConfig
+	var describe []string
+
+	addresses := func(name string, set func(*dsl.SearchConfig, string)) {
+		value, ok := props[name]
+		if !ok || value == "" {
+			return
+		}
+		var alternatives []dsl.SearchConfig
+		for _, alt := range gmailAlternatives(value) {
+			var condition dsl.SearchConfig
+			set(&condition, alt)
+			alternatives = append(alternatives, condition)
+		}
+		conditions = append(conditions, combine(dsl.OperatorOr, alternatives))
+		describe = append(describe, name, value)
+	}
+	addresses("from", func(c *dsl.SearchConfig, v string) { c.From = v })
+	addresses("to", func(c *dsl.SearchConfig, v string) { c.To = v })
+	addresses("subject", func(c *dsl.SearchConfig, v string) { c.Subject = v })
+
+	if query := props["hasTheWord"]; query != "" {
+		condition, err := gmailQuery(query)
+		if err != nil {
+			result.warn(filter, "skipped, %v", err)
+			return dsl.SearchConfig{}, nil, false
+		}
+		conditions = append(conditions, condition)
+		describe = append(describe, query)
+	}
+	if query := props["doesNotHaveTheWord"]; query != "" {
+		condition, err := gmailQuery(query)
+		if err != nil {
+			result.warn(filter, "skipped, %v", err)
+			return dsl.SearchConfig{}, nil, false
+		}
+		conditions = append(conditions, negate(condition))
+	}
+
+	if props["hasAttachment"] == "true" {
+		result.warn(filter, "hasAttachment approximated by a multipart/mixed Content-Type")
+		conditions = append(conditions, attachmentCondition())
+	}
+
+	if size := props["size"]; size != "" {
+		unit := map[string]string{"s_smb": "M", "s_skb": "K", "s_sb": ""}[props["sizeUnit"]]
+		var condition dsl.SearchConfig
+		switch props["sizeOperator"] {
+		case "s_ss":
+			condition.Size = &dsl.SizeCriteria{SmallerThan: size + unit}
+		default:
+			condition.Size = &dsl.SizeCriteria{LargerThan: size + unit}
+		}
+		conditions = append(conditions, condition)
+	}
+
+	for _, name := range order {
+		if !gmailCriteria[name] && !gmailActionProperties[name] {
+			result.warn(filter, "skipped, unsupported criterion %q", name)
+			return dsl.SearchConfig{}, nil, false
+		}
+	}
+
+	if len(conditions) == 0 {
+		result.warn(filter, "skipped, no criteria to import")
+		return dsl.SearchConfig{}, nil, false
+	}
+	return combine(dsl.OperatorAnd, conditions), describe, true
+}
+
+// gmailQuery converts a hasTheWord query. Plain words become a full text
+// search and list: a List-Id match; other search operators are rejected.
+func gmailQuery(query string) (dsl.SearchConfig, error) {
+	query = strings.TrimSpace(query)
+	if rest, ok := strings.CutPrefix(query, "list:"); ok && !strings.ContainsAny(rest, " :") {
+		rest = strings.Trim(rest, "()<>")
+		return dsl.SearchConfig{Header: &dsl.HeaderCriteria{Name: "List-Id", Value: rest}}, nil
+	}
+	if strings.Contains(query, ":") || strings.ContainsAny(query, "{}()") || strings.HasPrefix(query, "-") {
+		return dsl.SearchConfig{}, fmt.Errorf("Gmail search query %q cannot be translated", query)
+	}
+	var alternatives []dsl.SearchConfig
+	for _, alt := range gmailAlternatives(query) {
+		alternatives = append(alternatives, dsl.SearchConfig{Text: strings.Trim(alt, `"`)})
+	}
+	return combine(dsl.OperatorOr, alternatives), nil
+}
+
+// gmailAlternatives splits "a OR b" and "{a b}" values into their
+// alternatives.
+func gmailAlternatives(value string) []string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "{") && strings.HasSuffix(value, "}") {
+		return strings.Fields(value[1 : len(value)-1])
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "("), ")")
+	var alternatives []string
+	for _, part := range strings.Split(value, " OR ") {
+		if part = strings.TrimSpace(part); part != "" {
+			alternatives = append(alternatives, part)
+		}
+	}
+	return alternatives
+}
+
+func gmailActions(result *Result, filter string, props map[string]string) dsl.ActionConfig {
+	var actions dsl.ActionConfig
+
+	label := props["label"]
+	if strings.HasPrefix(label, "^") {
+		result.warn(filter, "system label %q is not supported", label)
+		label = ""
+	}
+	switch {
+	case props["shouldTrash"] == "true":
+		actions.MoveTo = gmailTrash
+	case props["shouldArchive"] == "true" && label != "":
+		actions.MoveTo = label
+	case props["shouldArchive"] == "true":
+		actions.MoveTo = gmailAllMail
+	case label != "":
+		actions.CopyTo = label
+	}
+
+	if props["shouldMarkAsRead"] == "true" {
+		addFlag(&actions, "seen")
+	}
+	if props["shouldStar"] == "true" {
+		addFlag(&actions, "flagged")
+	}
+	if props["shouldAlwaysMarkAsImportant"] == "true" {
+		addFlag(&actions, "important")
+	}
+	if props["shouldNeverMarkAsImportant"] == "true" {
+		removeFlag(&actions, "important")
+	}
+
+	for _, name := range []string{"forwardTo", "shouldNeverSpam", "smartLabelToApply", "cannedResponse"} {
+		if value, ok := props[name]; ok && value != "" && value != "false" {
+			result.warn(filter, "action %q is not supported", name)
+		}
+	}
+	return actions
+}
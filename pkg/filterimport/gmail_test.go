@@ -0,0 +1,89 @@
+package filterimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+const gmailExport = `<?xml version='1.0' encoding='UTF-8'?>
+<feed xmlns='http://www.w3.org/2005/Atom' xmlns:apps='http://schemas.google.com/apps/2006'>
+  <title>Mail Filters</title>
+  <entry>
+    <category term='filter'></category>
+    <title>Mail Filter</title>
+    <id>tag:mail.google.com,2008:filter:1</id>
+    <apps:property name='from' value='news@example.com OR digest@example.com'/>
+    <apps:property name='label' value='Newsletters'/>
+    <apps:property name='shouldArchive' value='true'/>
+    <apps:property name='shouldMarkAsRead' value='true'/>
+  </entry>
+  <entry>
+    <id>tag:mail.google.com,2008:filter:2</id>
+    <apps:property name='hasTheWord' value='list:dev.lists.example.com'/>
+    <apps:property name='size' value='5'/>
+    <apps:property name='sizeOperator' value='s_sl'/>
+    <apps:property name='sizeUnit' value='s_smb'/>
+    <apps:property name='label' value='Lists/Dev'/>
+    <apps:property name='forwardTo' value='me@example.org'/>
+  </entry>
+  <entry>
+    <id>tag:mail.google.com,2008:filter:3</id>
+    <apps:property name='hasTheWord' value='is:starred older_than:1y'/>
+    <apps:property name='shouldTrash' value='true'/>
+  </entry>
+</feed>`
+
+func TestImportGmail(t *testing.T) {
+	result, err := ImportGmail(strings.NewReader(gmailExport))
+	require.NoError(t, err)
+	require.Len(t, result.Rules, 2)
+
+	newsletters := result.Rules[0]
+	assert.Equal(t, "gmail-from-news-example-com-or-digest-example-com", newsletters.Name)
+	assert.Equal(t, dsl.OperatorOr, newsletters.Search.Operator)
+	require.Len(t, newsletters.Search.Conditions, 2)
+	assert.Equal(t, "news@example.com", newsletters.Search.Conditions[0].From)
+	assert.Equal(t, "digest@example.com", newsletters.Search.Conditions[1].From)
+	assert.Equal(t, "Newsletters", newsletters.Actions.MoveTo)
+	assert.Equal(t, []string{"seen"}, newsletters.Actions.Flags.Add)
+
+	lists := result.Rules[1]
+	assert.Equal(t, dsl.OperatorAnd, lists.Search.Operator)
+	require.Len(t, lists.Search.Conditions, 2)
+	assert.Equal(t, &dsl.HeaderCriteria{Name: "List-Id", Value: "dev.lists.example.com"}, lists.Search.Conditions[0].Header)
+	assert.Equal(t, "5M", lists.Search.Conditions[1].Size.LargerThan)
+	assert.Equal(t, "Lists/Dev", lists.Actions.CopyTo)
+
+	var messages []string
+	for _, w := range result.Warnings {
+		messages = append(messages, w.String())
+	}
+	assert.Contains(t, messages, `gmail filter 2: action "forwardTo" is not supported`)
+	assert.Contains(t, messages, `gmail filter 3: skipped, Gmail search query "is:starred older_than:1y" cannot be translated`)
+}
+
+func TestImportedRulesRoundTrip(t *testing.T) {
+	result, err := ImportGmail(strings.NewReader(gmailExport))
+	require.NoError(t, err)
+
+	for _, rule := range result.Rules {
+		data, err := yaml.Marshal(rule)
+		require.NoError(t, err)
+		parsed, err := dsl.ParseRuleString(string(data))
+		require.NoError(t, err, string(data))
+		assert.Equal(t, rule.Search, parsed.Search)
+		assert.Equal(t, rule.Actions, parsed.Actions)
+	}
+}
+
+func TestGmailAlternatives(t *testing.T) {
+	assert.Equal(t, []string{"a@x", "b@x"}, gmailAlternatives("a@x OR b@x"))
+	assert.Equal(t, []string{"a@x", "b@x"}, gmailAlternatives("{a@x b@x}"))
+	assert.Equal(t, []string{"a@x"}, gmailAlternatives("(a@x)"))
+}
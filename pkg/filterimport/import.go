@@ -0,0 +1,169 @@
+// Package filterimport converts filter definitions of other mail clients into
+// smailnail rules.
+//
+// Conversion is best-effort. A filter whose criteria cannot be translated is
+// skipped, since dropping a criterion would make the rule match (and act on)
+// more mail than the original filter. Actions without an equivalent are
+// dropped from the rule. Both cases are reported as warnings.
+package filterimport
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// Formats accepted by Import.
+const (
+	FormatGmail       = "gmail"
+	FormatThunderbird = "thunderbird"
+)
+
+// Warning describes a construct that was not imported.
+type Warning struct {
+	Filter  string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Filter, w.Message)
+}
+
+// Result holds the imported rules and the warnings collected along the way.
+type Result struct {
+	Rules    []*dsl.Rule
+	Warnings []Warning
+}
+
+func (r *Result) warn(filter, format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, Warning{Filter: filter, Message: fmt.Sprintf(format, args...)})
+}
+
+// defaultOutput is the output of imported rules, which only carry criteria
+// and actions.
+func defaultOutput() dsl.OutputConfig {
+	return dsl.OutputConfig{
+		Format: "table",
+		Fields: []interface{}{"uid", "subject", "from", "date"},
+	}
+}
+
+// Import reads the filters in path. format is FormatGmail, FormatThunderbird
+// or empty to detect it from the file.
+func Import(path, format string) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filters: %w", err)
+	}
+	if format == "" {
+		format = DetectFormat(path, data)
+	}
+	switch format {
+	case FormatGmail:
+		return ImportGmail(bytes.NewReader(data))
+	case FormatThunderbird:
+		return ImportThunderbird(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unknown filter format: %q (must be '%s' or '%s')", format, FormatGmail, FormatThunderbird)
+	}
+}
+
+// DetectFormat guesses the format of a filter file from its name and content.
+func DetectFormat(path string, data []byte) string {
+	if strings.EqualFold(filepath.Ext(path), ".xml") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return FormatGmail
+	}
+	return FormatThunderbird
+}
+
+// validate checks an imported rule the way ParseRuleFile would.
+func validate(result *Result, filter string, rule *dsl.Rule) bool {
+	if err := rule.Validate(); err != nil {
+		result.warn(filter, "skipped, the converted rule is invalid: %v", err)
+		return false
+	}
+	return true
+}
+
+// ruleName turns the parts into a rule name such as "gmail-from-news-example-com".
+func ruleName(parts ...string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.Join(parts, "-")) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "-")
+	if len(name) > 60 {
+		name = strings.TrimSuffix(name[:60], "-")
+	}
+	return name
+}
+
+// uniqueName appends a counter to names already used in the result.
+func uniqueName(result *Result, name string) string {
+	used := make(map[string]bool, len(result.Rules))
+	for _, rule := range result.Rules {
+		used[rule.Name] = true
+	}
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+	return candidate
+}
+
+// combine returns the conditions joined with op, collapsing single
+// conditions. OR is nested two conditions at a time, which every search
+// backend evaluates the same way.
+func combine(op dsl.Operator, conditions []dsl.SearchConfig) dsl.SearchConfig {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	if op == dsl.OperatorOr && len(conditions) > 2 {
+		return combine(op, []dsl.SearchConfig{conditions[0], combine(op, conditions[1:])})
+	}
+	search := dsl.SearchConfig{Operator: op}
+	for _, condition := range conditions {
+		search.Conditions = append(search.Conditions, dsl.ComplexSearchConfig{SearchConfig: condition})
+	}
+	return search
+}
+
+func negate(condition dsl.SearchConfig) dsl.SearchConfig {
+	return dsl.SearchConfig{
+		Operator:   dsl.OperatorNot,
+		Conditions: []dsl.ComplexSearchConfig{{SearchConfig: condition}},
+	}
+}
+
+// attachmentCondition approximates "has an attachment", which IMAP SEARCH
+// cannot express, by a multipart/mixed Content-Type.
+func attachmentCondition() dsl.SearchConfig {
+	return dsl.SearchConfig{Header: &dsl.HeaderCriteria{Name: "Content-Type", Value: "multipart/mixed"}}
+}
+
+func addFlag(actions *dsl.ActionConfig, flag string) {
+	if actions.Flags == nil {
+		actions.Flags = &dsl.FlagActions{}
+	}
+	actions.Flags.Add = append(actions.Flags.Add, flag)
+}
+
+func removeFlag(actions *dsl.ActionConfig, flag string) {
+	if actions.Flags == nil {
+		actions.Flags = &dsl.FlagActions{}
+	}
+	actions.Flags.Remove = append(actions.Flags.Remove, flag)
+}
@@ -0,0 +1,393 @@
+package filterimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+// thunderbirdFilter is one filter of a msgFilterRules.dat file.
+type thunderbirdFilter struct {
+	Name      string
+	Enabled   bool
+	Condition string
+	Actions   []thunderbirdAction
+}
+
+type thunderbirdAction struct {
+	Name  string
+	Value string
+}
+
+// thunderbirdTerm is one "(attribute,operator,value)" of a filter condition.
+type thunderbirdTerm struct {
+	Attribute string
+	Operator  string
+	Value     string
+	// CustomHeader is set for quoted attributes, which name a header
+	CustomHeader bool
+}
+
+// thunderbirdHeaders maps condition attributes to the search field they set.
+var thunderbirdHeaders = map[string]func(*dsl.SearchConfig, string){
+	"from":    func(c *dsl.SearchConfig, v string) { c.From = v },
+	"to":      func(c *dsl.SearchConfig, v string) { c.To = v },
+	"cc":      func(c *dsl.SearchConfig, v string) { c.Cc = v },
+	"subject": func(c *dsl.SearchConfig, v string) { c.Subject = v },
+	"body":    func(c *dsl.SearchConfig, v string) { c.BodyContains = v },
+}
+
+// thunderbirdStatus maps status values to flags.
+var thunderbirdStatus = map[string]string{
+	"read":    "seen",
+	"replied": "answered",
+	"flagged": "flagged",
+}
+
+// ImportThunderbird converts a Thunderbird msgFilterRules.dat file.
+func ImportThunderbird(r io.Reader) (*Result, error) {
+	filters, err := parseThunderbirdFilters(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, tf := range filters {
+		filter := fmt.Sprintf("thunderbird filter %q", tf.Name)
+		if !tf.Enabled {
+			result.warn(filter, "skipped, the filter is disabled")
+			continue
+		}
+
+		search, err := thunderbirdSearch(result, filter, tf.Condition)
+		if err != nil {
+			result.warn(filter, "skipped, %v", err)
+			continue
+		}
+
+		rule := &dsl.Rule{
+			Name:        uniqueName(result, ruleName("thunderbird", tf.Name)),
+			Description: "Imported from Thunderbird filter " + strconv.Quote(tf.Name),
+			Search:      search,
+			Output:      defaultOutput(),
+			Actions:     thunderbirdActions(result, filter, tf.Actions),
+		}
+		if validate(result, filter, rule) {
+			result.Rules = append(result.Rules, rule)
+		}
+	}
+	return result, nil
+}
+
+// parseThunderbirdFilters reads the key="value" lines of the file. Each
+// name= line starts a filter; action= lines are followed by their
+// actionValue=.
+func parseThunderbirdFilters(r io.Reader) ([]*thunderbirdFilter, error) {
+	var filters []*thunderbirdFilter
+	var current *thunderbirdFilter
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key=\"value\"", lineNo)
+		}
+		value, err := unquoteThunderbird(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		if key == "name" {
+			current = &thunderbirdFilter{Name: value, Enabled: true}
+			filters = append(filters, current)
+			continue
+		}
+		if current == nil {
+			// version= and logging= precede the first filter
+			continue
+		}
+		switch key {
+		case "enabled":
+			current.Enabled = value == "yes"
+		case "condition":
+			current.Condition = value
+		case "action":
+			current.Actions = append(current.Actions, thunderbirdAction{Name: value})
+		case "actionValue":
+			if len(current.Actions) > 0 {
+				current.Actions[len(current.Actions)-1].Value = value
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Thunderbird filters: %w", err)
+	}
+	return filters, nil
+}
+
+// unquoteThunderbird strips the quotes around a value and its backslash
+// escapes.
+func unquoteThunderbird(raw string) (string, error) {
+	if len(raw) < 2 || raw[0] != '"' || raw[len(raw)-1] != '"' {
+		return "", fmt.Errorf("value %s is not quoted", raw)
+	}
+	raw = raw[1 : len(raw)-1]
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '\\' && i+1 < len(raw) {
+			i++
+		}
+		b.WriteByte(raw[i])
+	}
+	return b.String(), nil
+}
+
+// parseThunderbirdCondition splits "AND (from,contains,a) AND (subject,is,b)"
+// into its operator and terms. "ALL" has no terms.
+func parseThunderbirdCondition(condition string) (dsl.Operator, []thunderbirdTerm, error) {
+	condition = strings.TrimSpace(condition)
+	if condition == "ALL" || condition == "" {
+		return dsl.OperatorAnd, nil, nil
+	}
+
+	var op dsl.Operator
+	var terms []thunderbirdTerm
+	rest := condition
+	for rest != "" {
+		word, tail, _ := strings.Cut(rest, " ")
+		var termOp dsl.Operator
+		switch word {
+		case "AND":
+			termOp = dsl.OperatorAnd
+		case "OR":
+			termOp = dsl.OperatorOr
+		default:
+			return "", nil, fmt.Errorf("unexpected %q in condition %q", word, condition)
+		}
+		if op != "" && op != termOp {
+			return "", nil, fmt.Errorf("condition %q mixes AND and OR", condition)
+		}
+		op = termOp
+
+		term, remaining, err := parseThunderbirdTerm(strings.TrimSpace(tail))
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid condition %q: %w", condition, err)
+		}
+		terms = append(terms, term)
+		rest = strings.TrimSpace(remaining)
+	}
+	return op, terms, nil
+}
+
+// parseThunderbirdTerm reads one parenthesized term. The attribute and the
+// value may be quoted, in which case they can contain commas and
+// parentheses.
+func parseThunderbirdTerm(s string) (thunderbirdTerm, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return thunderbirdTerm{}, "", fmt.Errorf("expected '(' at %q", s)
+	}
+	pos := 1
+	quoted := false
+	read := func(stop byte) (string, error) {
+		quoted = pos < len(s) && s[pos] == '"'
+		if quoted {
+			end := pos + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return "", fmt.Errorf("unterminated quote in %q", s)
+			}
+			value, _ := unquoteThunderbird(s[pos : end+1])
+			pos = end + 1
+			if pos >= len(s) || s[pos] != stop {
+				return "", fmt.Errorf("expected %q after quoted value in %q", stop, s)
+			}
+			pos++
+			return value, nil
+		}
+		end := strings.IndexByte(s[pos:], stop)
+		if end < 0 {
+			return "", fmt.Errorf("expected %q in %q", stop, s)
+		}
+		value := s[pos : pos+end]
+		pos += end + 1
+		return value, nil
+	}
+
+	var term thunderbirdTerm
+	var err error
+	if term.Attribute, err = read(','); err != nil {
+		return term, "", err
+	}
+	term.CustomHeader = quoted
+	if term.Operator, err = read(','); err != nil {
+		return term, "", err
+	}
+	if term.Value, err = read(')'); err != nil {
+		return term, "", err
+	}
+	return term, s[pos:], nil
+}
+
+func thunderbirdSearch(result *Result, filter, condition string) (dsl.SearchConfig, error) {
+	op, terms, err := parseThunderbirdCondition(condition)
+	if err != nil {
+		return dsl.SearchConfig{}, err
+	}
+	if len(terms) == 0 {
+		return dsl.SearchConfig{}, fmt.Errorf("matching all messages is not imported")
+	}
+
+	conditions := make([]dsl.SearchConfig, 0, len(terms))
+	for _, term := range terms {
+		condition, err := thunderbirdTermSearch(result, filter, term)
+		if err != nil {
+			return dsl.SearchConfig{}, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return combine(op, conditions), nil
+}
+
+func thunderbirdTermSearch(result *Result, filter string, term thunderbirdTerm) (dsl.SearchConfig, error) {
+	unsupported := fmt.Errorf("unsupported condition (%s,%s,%s)", term.Attribute, term.Operator, term.Value)
+
+	// contains-like operators and their negations
+	match := func(set func(*dsl.SearchConfig, string)) (dsl.SearchConfig, error) {
+		var condition dsl.SearchConfig
+		set(&condition, term.Value)
+		switch term.Operator {
+		case "contains":
+			return condition, nil
+		case "doesn't contain":
+			return negate(condition), nil
+		case "is", "begins with", "ends with":
+			result.warn(filter, "(%s,%s) approximated as contains", term.Attribute, term.Operator)
+			return condition, nil
+		case "isn't":
+			result.warn(filter, "(%s,%s) approximated as doesn't contain", term.Attribute, term.Operator)
+			return negate(condition), nil
+		}
+		return dsl.SearchConfig{}, unsupported
+	}
+
+	attribute := strings.ToLower(term.Attribute)
+	if set, ok := thunderbirdHeaders[attribute]; ok {
+		return match(set)
+	}
+
+	switch attribute {
+	case "to or cc":
+		return match(func(c *dsl.SearchConfig, v string) {
+			*c = combine(dsl.OperatorOr, []dsl.SearchConfig{{To: v}, {Cc: v}})
+		})
+	case "all addresses":
+		return match(func(c *dsl.SearchConfig, v string) {
+			*c = combine(dsl.OperatorOr, []dsl.SearchConfig{{From: v}, {To: v}, {Cc: v}, {Bcc: v}})
+		})
+	case "date":
+		date, err := time.Parse("02-Jan-2006", term.Value)
+		if err != nil {
+			return dsl.SearchConfig{}, fmt.Errorf("invalid date %q", term.Value)
+		}
+		switch term.Operator {
+		case "is before":
+			return dsl.SearchConfig{Before: date.Format("2006-01-02")}, nil
+		case "is after":
+			return dsl.SearchConfig{Since: date.AddDate(0, 0, 1).Format("2006-01-02")}, nil
+		case "is":
+			return dsl.SearchConfig{On: date.Format("2006-01-02")}, nil
+		}
+	case "size":
+		// Thunderbird sizes are in KB
+		if _, err := strconv.Atoi(term.Value); err != nil {
+			return dsl.SearchConfig{}, fmt.Errorf("invalid size %q", term.Value)
+		}
+		switch term.Operator {
+		case "is greater than":
+			return dsl.SearchConfig{Size: &dsl.SizeCriteria{LargerThan: term.Value + "K"}}, nil
+		case "is less than":
+			return dsl.SearchConfig{Size: &dsl.SizeCriteria{SmallerThan: term.Value + "K"}}, nil
+		}
+	case "status":
+		flag, ok := thunderbirdStatus[strings.ToLower(term.Value)]
+		if !ok {
+			return dsl.SearchConfig{}, unsupported
+		}
+		switch term.Operator {
+		case "is":
+			return dsl.SearchConfig{Flags: &dsl.FlagCriteria{Has: []string{flag}}}, nil
+		case "isn't":
+			return dsl.SearchConfig{Flags: &dsl.FlagCriteria{NotHas: []string{flag}}}, nil
+		}
+	case "has attachment status":
+		result.warn(filter, "attachment status approximated by a multipart/mixed Content-Type")
+		switch {
+		case term.Operator == "is" && term.Value == "true", term.Operator == "isn't" && term.Value == "false":
+			return attachmentCondition(), nil
+		case term.Operator == "is" && term.Value == "false", term.Operator == "isn't" && term.Value == "true":
+			return negate(attachmentCondition()), nil
+		}
+	default:
+		if term.CustomHeader {
+			return match(func(c *dsl.SearchConfig, v string) {
+				c.Header = &dsl.HeaderCriteria{Name: term.Attribute, Value: v}
+			})
+		}
+	}
+	return dsl.SearchConfig{}, unsupported
+}
+
+func thunderbirdActions(result *Result, filter string, actions []thunderbirdAction) dsl.ActionConfig {
+	var config dsl.ActionConfig
+	for _, action := range actions {
+		switch action.Name {
+		case "Move to folder":
+			config.MoveTo = thunderbirdFolder(result, filter, action.Value)
+		case "Copy to folder":
+			config.CopyTo = thunderbirdFolder(result, filter, action.Value)
+		case "Mark read":
+			addFlag(&config, "seen")
+		case "Mark unread":
+			removeFlag(&config, "seen")
+		case "Mark flagged":
+			addFlag(&config, "flagged")
+		case "Add tag":
+			addFlag(&config, action.Value)
+		case "Delete":
+			config.Delete = map[string]interface{}{"trash": true}
+		default:
+			result.warn(filter, "action %q is not supported", action.Name)
+		}
+	}
+	return config
+}
+
+// thunderbirdFolder returns the mailbox of a folder URI such as
+// imap://user@host/INBOX/Lists.
+func thunderbirdFolder(result *Result, filter, uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Path == "" {
+		result.warn(filter, "folder %q is not a folder URI, used as is", uri)
+		return uri
+	}
+	if u.Scheme != "imap" {
+		result.warn(filter, "folder %q is not on an IMAP server", uri)
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
@@ -0,0 +1,87 @@
+package filterimport
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+const thunderbirdRules = `version="9"
+logging="no"
+name="Mailing lists"
+enabled="yes"
+type="17"
+action="Move to folder"
+actionValue="imap://me%40example.com@imap.example.com/INBOX/Lists"
+action="Mark read"
+condition="OR (to or cc,contains,list@example.com) OR (\"List-Id\",contains,\"dev, announce\")"
+name="Old invoices"
+enabled="yes"
+type="17"
+action="Delete"
+action="Forward"
+actionValue="archive@example.com"
+condition="AND (subject,begins with,Invoice) AND (date,is before,01-Jan-2024) AND (status,isn't,flagged)"
+name="Disabled"
+enabled="no"
+type="17"
+action="Delete"
+condition="ALL"
+name="By age"
+enabled="yes"
+type="17"
+action="Delete"
+condition="AND (age in days,is greater than,30)"
+`
+
+func TestImportThunderbird(t *testing.T) {
+	result, err := ImportThunderbird(strings.NewReader(thunderbirdRules))
+	require.NoError(t, err)
+	require.Len(t, result.Rules, 2)
+
+	lists := result.Rules[0]
+	assert.Equal(t, "thunderbird-mailing-lists", lists.Name)
+	assert.Equal(t, "INBOX/Lists", lists.Actions.MoveTo)
+	assert.Equal(t, []string{"seen"}, lists.Actions.Flags.Add)
+	assert.Equal(t, dsl.OperatorOr, lists.Search.Operator)
+	require.Len(t, lists.Search.Conditions, 2)
+	toOrCc := lists.Search.Conditions[0]
+	assert.Equal(t, dsl.OperatorOr, toOrCc.Operator)
+	assert.Equal(t, "list@example.com", toOrCc.Conditions[0].To)
+	assert.Equal(t, "list@example.com", toOrCc.Conditions[1].Cc)
+	assert.Equal(t, &dsl.HeaderCriteria{Name: "List-Id", Value: "dev, announce"}, lists.Search.Conditions[1].Header)
+
+	invoices := result.Rules[1]
+	require.Len(t, invoices.Search.Conditions, 3)
+	assert.Equal(t, "Invoice", invoices.Search.Conditions[0].Subject)
+	assert.Equal(t, "2024-01-01", invoices.Search.Conditions[1].Before)
+	assert.Equal(t, []string{"flagged"}, invoices.Search.Conditions[2].Flags.NotHas)
+	assert.Equal(t, map[string]interface{}{"trash": true}, invoices.Actions.Delete)
+
+	var messages []string
+	for _, w := range result.Warnings {
+		messages = append(messages, w.String())
+	}
+	assert.Contains(t, messages, `thunderbird filter "Old invoices": (subject,begins with) approximated as contains`)
+	assert.Contains(t, messages, `thunderbird filter "Old invoices": action "Forward" is not supported`)
+	assert.Contains(t, messages, `thunderbird filter "Disabled": skipped, the filter is disabled`)
+	assert.Contains(t, messages, `thunderbird filter "By age": skipped, unsupported condition (age in days,is greater than,30)`)
+}
+
+func TestParseThunderbirdConditionErrors(t *testing.T) {
+	_, _, err := parseThunderbirdCondition("AND (from,contains,a) OR (to,contains,b)")
+	assert.ErrorContains(t, err, "mixes AND and OR")
+
+	_, _, err = parseThunderbirdCondition(`AND (subject,contains,"open`)
+	assert.ErrorContains(t, err, "unterminated quote")
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatGmail, DetectFormat("mailFilters.xml", nil))
+	assert.Equal(t, FormatGmail, DetectFormat("filters", []byte("  <?xml version='1.0'?>")))
+	assert.Equal(t, FormatThunderbird, DetectFormat("msgFilterRules.dat", []byte(`version="9"`)))
+}
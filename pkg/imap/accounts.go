@@ -0,0 +1,187 @@
+package imap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/values"
+	"gopkg.in/yaml.v3"
+)
+
+// Account is a named set of IMAP connection settings, from the accounts map
+// of the config file.
+type Account struct {
+	Server   string `yaml:"server,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// PasswordEnv is an environment variable the password is read from, to
+	// keep it out of the config file
+	PasswordEnv string `yaml:"password_env,omitempty"`
+	Mailbox     string `yaml:"mailbox,omitempty"`
+	Insecure    bool   `yaml:"insecure,omitempty"`
+}
+
+// accountsFile is the part of the config file holding the accounts.
+type accountsFile struct {
+	Accounts map[string]Account `yaml:"accounts"`
+}
+
+// DefaultAccountsFiles are the config files the accounts are read from
+// without --accounts-file, the first existing one being used.
+func DefaultAccountsFiles() []string {
+	var files []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		files = append(files, filepath.Join(dir, "smailnail", "config.yaml"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".smailnail", "config.yaml"))
+	}
+	return files
+}
+
+// LoadAccounts reads the accounts map of the config file at path.
+func LoadAccounts(path string) (map[string]Account, error) {
+	// #nosec G304 -- the accounts file is chosen by the user.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts file: %w", err)
+	}
+	var file accountsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts file %s: %w", path, err)
+	}
+	return file.Accounts, nil
+}
+
+// SaveAccount adds account to the accounts map of the config file at path
+// under name, replacing an account of that name. The other contents of the
+// file are kept. The file is created with owner-only permissions if it does
+// not exist, as it may hold passwords.
+func SaveAccount(path string, name string, account Account) error {
+	config := map[string]interface{}{}
+	// #nosec G304 -- the accounts file is chosen by the user.
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if config == nil {
+			config = map[string]interface{}{}
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	accounts, ok := config["accounts"].(map[string]interface{})
+	if !ok {
+		if config["accounts"] != nil {
+			return fmt.Errorf("accounts in %s is not a map", path)
+		}
+		accounts = map[string]interface{}{}
+	}
+	accounts[name] = account
+	config["accounts"] = accounts
+
+	data, err = yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// findAccountsFile returns the file the accounts are read from: path if set,
+// otherwise the first of DefaultAccountsFiles that exists.
+func findAccountsFile(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	for _, candidate := range DefaultAccountsFiles() {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no config file with accounts found (looked for %s)", strings.Join(DefaultAccountsFiles(), ", "))
+}
+
+// ApplyAccount fills in the settings left unset by flags and environment
+// variables from the --account profile, then applies the defaults of the
+// port and mailbox. It does nothing else without --account.
+func (s *IMAPSettings) ApplyAccount() error {
+	if s.Account != "" {
+		path, err := findAccountsFile(s.AccountsFile)
+		if err != nil {
+			return err
+		}
+		accounts, err := LoadAccounts(path)
+		if err != nil {
+			return err
+		}
+		account, ok := accounts[s.Account]
+		if !ok {
+			names := make([]string, 0, len(accounts))
+			for name := range accounts {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown account %q in %s (known accounts: %s)", s.Account, path, strings.Join(names, ", "))
+		}
+		if err := s.applyAccount(account); err != nil {
+			return fmt.Errorf("account %q: %w", s.Account, err)
+		}
+	}
+
+	if s.Port == 0 {
+		s.Port = 993
+	}
+	if s.Mailbox == "" {
+		s.Mailbox = "INBOX"
+	}
+	return nil
+}
+
+func (s *IMAPSettings) applyAccount(account Account) error {
+	if s.Server == "" {
+		s.Server = account.Server
+	}
+	if s.Port == 0 {
+		s.Port = account.Port
+	}
+	if s.Username == "" {
+		s.Username = account.Username
+	}
+	if s.Password == "" {
+		s.Password = account.Password
+		if account.PasswordEnv != "" {
+			password, ok := os.LookupEnv(account.PasswordEnv)
+			if !ok {
+				return fmt.Errorf("password_env %s is not set", account.PasswordEnv)
+			}
+			s.Password = password
+		}
+	}
+	if s.Mailbox == "" {
+		s.Mailbox = account.Mailbox
+	}
+	s.Insecure = s.Insecure || account.Insecure
+	return nil
+}
+
+// DecodeIMAPSettings decodes the IMAP section of parsedValues into s and
+// applies the --account profile.
+func DecodeIMAPSettings(parsedValues *values.Values, s *IMAPSettings) error {
+	if err := parsedValues.DecodeSectionInto(IMAPSectionSlug, s); err != nil {
+		return err
+	}
+	return s.ApplyAccount()
+}
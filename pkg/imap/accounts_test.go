@@ -0,0 +1,92 @@
+package imap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAccountsFile(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+accounts:
+  work:
+    server: imap.work.example.com
+    username: me@work.example.com
+    password_env: WORK_IMAP_PASSWORD
+    mailbox: Projects
+  personal:
+    server: imap.example.org
+    port: 143
+    username: me@example.org
+    password: secret
+    insecure: true
+`), 0o600))
+	return path
+}
+
+func TestApplyAccount(t *testing.T) {
+	path := writeAccountsFile(t)
+	t.Setenv("WORK_IMAP_PASSWORD", "from-env")
+
+	s := &IMAPSettings{Account: "work", AccountsFile: path}
+	require.NoError(t, s.ApplyAccount())
+	assert.Equal(t, "imap.work.example.com", s.Server)
+	assert.Equal(t, 993, s.Port)
+	assert.Equal(t, "me@work.example.com", s.Username)
+	assert.Equal(t, "from-env", s.Password)
+	assert.Equal(t, "Projects", s.Mailbox)
+	assert.False(t, s.Insecure)
+
+	// Flags win over the profile
+	s = &IMAPSettings{Account: "personal", AccountsFile: path, Username: "other@example.org", Mailbox: "Archive"}
+	require.NoError(t, s.ApplyAccount())
+	assert.Equal(t, "imap.example.org", s.Server)
+	assert.Equal(t, 143, s.Port)
+	assert.Equal(t, "other@example.org", s.Username)
+	assert.Equal(t, "secret", s.Password)
+	assert.Equal(t, "Archive", s.Mailbox)
+	assert.True(t, s.Insecure)
+}
+
+func TestApplyAccountErrors(t *testing.T) {
+	path := writeAccountsFile(t)
+
+	s := &IMAPSettings{Account: "school", AccountsFile: path}
+	assert.ErrorContains(t, s.ApplyAccount(), `unknown account "school"`)
+	assert.ErrorContains(t, s.ApplyAccount(), "known accounts: personal, work")
+
+	s = &IMAPSettings{Account: "work", AccountsFile: path}
+	assert.ErrorContains(t, s.ApplyAccount(), "password_env WORK_IMAP_PASSWORD is not set")
+}
+
+func TestApplyAccountDefaults(t *testing.T) {
+	s := &IMAPSettings{Server: "imap.example.com"}
+	require.NoError(t, s.ApplyAccount())
+	assert.Equal(t, 993, s.Port)
+	assert.Equal(t, "INBOX", s.Mailbox)
+}
+
+func TestSaveAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smailnail", "config.yaml")
+	require.NoError(t, SaveAccount(path, "work", Account{Server: "imap.work.example.com", Port: 993}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	// Other accounts and settings are kept
+	require.NoError(t, os.WriteFile(path, []byte("imap:\n  insecure: true\naccounts:\n  work:\n    server: old.example.com\n  personal:\n    server: imap.example.org\n"), 0o600))
+	require.NoError(t, SaveAccount(path, "work", Account{Server: "imap.work.example.com", Username: "me"}))
+	accounts, err := LoadAccounts(path)
+	require.NoError(t, err)
+	assert.Equal(t, Account{Server: "imap.work.example.com", Username: "me"}, accounts["work"])
+	assert.Equal(t, "imap.example.org", accounts["personal"].Server)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "insecure: true")
+}
@@ -0,0 +1,255 @@
+package imap
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServerConfig is the IMAP server of an email address, as found by
+// DiscoverServer.
+type ServerConfig struct {
+	Server string
+	Port   int
+	// Security is SecurityTLS, SecurityStartTLS or SecurityNone
+	Security string
+	// Username is the login of the address on the server, usually the
+	// address itself
+	Username string
+	// Source tells how the server was found: "known provider", the SRV
+	// record or the URL of the autoconfig file
+	Source string
+}
+
+// Connection security of a ServerConfig.
+const (
+	// SecurityTLS is implicit TLS, the only security smailnail connects with
+	SecurityTLS      = "tls"
+	SecurityStartTLS = "starttls"
+	SecurityNone     = "none"
+)
+
+// knownProviders are the IMAP servers of common providers, by email domain.
+var knownProviders = map[string]ServerConfig{
+	"gmail.com":      {Server: "imap.gmail.com", Port: 993, Security: SecurityTLS},
+	"googlemail.com": {Server: "imap.gmail.com", Port: 993, Security: SecurityTLS},
+	"outlook.com":    {Server: "outlook.office365.com", Port: 993, Security: SecurityTLS},
+	"hotmail.com":    {Server: "outlook.office365.com", Port: 993, Security: SecurityTLS},
+	"live.com":       {Server: "outlook.office365.com", Port: 993, Security: SecurityTLS},
+	"msn.com":        {Server: "outlook.office365.com", Port: 993, Security: SecurityTLS},
+	"office365.com":  {Server: "outlook.office365.com", Port: 993, Security: SecurityTLS},
+	"fastmail.com":   {Server: "imap.fastmail.com", Port: 993, Security: SecurityTLS},
+	"fastmail.fm":    {Server: "imap.fastmail.com", Port: 993, Security: SecurityTLS},
+	"icloud.com":     {Server: "imap.mail.me.com", Port: 993, Security: SecurityTLS},
+	"me.com":         {Server: "imap.mail.me.com", Port: 993, Security: SecurityTLS},
+	"yahoo.com":      {Server: "imap.mail.yahoo.com", Port: 993, Security: SecurityTLS},
+}
+
+// Discoverer finds the IMAP server of an email address. The zero value uses
+// the system resolver and the public autoconfig locations.
+type Discoverer struct {
+	// LookupSRV resolves SRV records, net.DefaultResolver.LookupSRV if nil
+	LookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	// HTTPClient fetches the autoconfig files, a client with a 10 second
+	// timeout if nil
+	HTTPClient *http.Client
+	// AutoconfigURLs are the autoconfig file locations tried in order, with
+	// {domain} and {email} placeholders, DefaultAutoconfigURLs if nil
+	AutoconfigURLs []string
+}
+
+// DefaultAutoconfigURLs are the domain's own autoconfig file, then the
+// Thunderbird ISP database.
+var DefaultAutoconfigURLs = []string{
+	"https://autoconfig.{domain}/mail/config-v1.1.xml?emailaddress={email}",
+	"https://{domain}/.well-known/autoconfig/mail/config-v1.1.xml?emailaddress={email}",
+	"https://autoconfig.thunderbird.net/v1.1/{domain}",
+}
+
+// DiscoverServer finds the IMAP server of email with the zero Discoverer.
+func DiscoverServer(ctx context.Context, email string) (*ServerConfig, error) {
+	return (&Discoverer{}).Discover(ctx, email)
+}
+
+// Discover returns the first server of DiscoverAll accepting implicit TLS,
+// as that is how smailnail connects.
+func (d *Discoverer) Discover(ctx context.Context, email string) (*ServerConfig, error) {
+	configs, err := d.DiscoverAll(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if configs[i].Security == SecurityTLS {
+			return &configs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s only offers IMAP without implicit TLS (%s:%d, %s), which smailnail does not support",
+		emailDomain(email), configs[0].Server, configs[0].Port, configs[0].Security)
+}
+
+// DiscoverAll finds the IMAP servers of email: from the known providers, or
+// else from the _imaps._tcp and _imap._tcp SRV records of the domain
+// (RFC 6186) and the first autoconfig file found. Servers accepting implicit
+// TLS come first.
+func (d *Discoverer) DiscoverAll(ctx context.Context, email string) ([]ServerConfig, error) {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return nil, fmt.Errorf("invalid email address %q", email)
+	}
+	domain := emailDomain(email)
+
+	if known, ok := knownProviders[domain]; ok {
+		known.Username = email
+		known.Source = "known provider"
+		return []ServerConfig{known}, nil
+	}
+
+	configs := d.discoverSRV(ctx, domain)
+	for i := range configs {
+		configs[i].Username = email
+	}
+
+	urls := d.AutoconfigURLs
+	if urls == nil {
+		urls = DefaultAutoconfigURLs
+	}
+	for _, template := range urls {
+		u := strings.NewReplacer("{domain}", domain, "{email}", url.QueryEscape(email)).Replace(template)
+		found, err := d.fetchAutoconfig(ctx, u, email)
+		if err == nil && len(found) > 0 {
+			for i := range found {
+				found[i].Source = u
+			}
+			configs = append(configs, found...)
+			break
+		}
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("could not find the IMAP server of %s", domain)
+	}
+	sort.SliceStable(configs, func(i, j int) bool {
+		return configs[i].Security == SecurityTLS && configs[j].Security != SecurityTLS
+	})
+	return configs, nil
+}
+
+func emailDomain(email string) string {
+	return strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+}
+
+// srvServices are the RFC 6186 services looked up, with the security they
+// imply.
+var srvServices = []struct {
+	service  string
+	security string
+}{
+	{"imaps", SecurityTLS},
+	{"imap", SecurityStartTLS},
+}
+
+func (d *Discoverer) discoverSRV(ctx context.Context, domain string) []ServerConfig {
+	lookup := d.LookupSRV
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupSRV
+	}
+	var configs []ServerConfig
+	for _, srv := range srvServices {
+		_, records, err := lookup(ctx, srv.service, "tcp", domain)
+		if err != nil {
+			continue
+		}
+		// Records are sorted by priority and weight; "." means no service
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			if target == "" || record.Port == 0 {
+				continue
+			}
+			configs = append(configs, ServerConfig{
+				Server:   target,
+				Port:     int(record.Port),
+				Security: srv.security,
+				Source:   "SRV _" + srv.service + "._tcp",
+			})
+		}
+	}
+	return configs
+}
+
+// autoconfigFile is the part of a Mozilla autoconfig file describing the
+// incoming servers.
+type autoconfigFile struct {
+	IncomingServers []struct {
+		Type       string `xml:"type,attr"`
+		Hostname   string `xml:"hostname"`
+		Port       int    `xml:"port"`
+		SocketType string `xml:"socketType"`
+		Username   string `xml:"username"`
+	} `xml:"emailProvider>incomingServer"`
+}
+
+// socketTypes maps the socketType of autoconfig files to a security.
+var socketTypes = map[string]string{
+	"SSL":      SecurityTLS,
+	"STARTTLS": SecurityStartTLS,
+	"plain":    SecurityNone,
+}
+
+func (d *Discoverer) fetchAutoconfig(ctx context.Context, u string, email string) ([]ServerConfig, error) {
+	client := d.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var file autoconfigFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid autoconfig file %s: %w", u, err)
+	}
+	localPart := email[:strings.LastIndex(email, "@")]
+	var configs []ServerConfig
+	for _, server := range file.IncomingServers {
+		security, ok := socketTypes[server.SocketType]
+		if server.Type != "imap" || !ok {
+			continue
+		}
+		username := strings.NewReplacer(
+			"%EMAILADDRESS%", email,
+			"%EMAILLOCALPART%", localPart,
+		).Replace(server.Username)
+		if username == "" {
+			username = email
+		}
+		port := server.Port
+		if port == 0 {
+			port = 143
+			if security == SecurityTLS {
+				port = 993
+			}
+		}
+		configs = append(configs, ServerConfig{Server: server.Hostname, Port: port, Security: security, Username: username})
+	}
+	return configs, nil
+}
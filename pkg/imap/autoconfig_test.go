@@ -0,0 +1,105 @@
+package imap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func noSRV(context.Context, string, string, string) (string, []*net.SRV, error) {
+	return "", nil, errors.New("no such host")
+}
+
+func TestDiscoverKnownProvider(t *testing.T) {
+	d := &Discoverer{LookupSRV: noSRV, AutoconfigURLs: []string{}}
+	config, err := d.Discover(context.Background(), "me@GMail.com")
+	require.NoError(t, err)
+	assert.Equal(t, "imap.gmail.com", config.Server)
+	assert.Equal(t, 993, config.Port)
+	assert.Equal(t, "me@GMail.com", config.Username)
+}
+
+func TestDiscoverSRV(t *testing.T) {
+	d := &Discoverer{
+		LookupSRV: func(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			assert.Equal(t, "example.org", name)
+			if service == "imap" {
+				return "", []*net.SRV{{Target: "mail.example.org.", Port: 143}}, nil
+			}
+			return "", []*net.SRV{{Target: ".", Port: 0}, {Target: "mail.example.org.", Port: 993}}, nil
+		},
+		AutoconfigURLs: []string{},
+	}
+	config, err := d.Discover(context.Background(), "me@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "mail.example.org", config.Server)
+	assert.Equal(t, 993, config.Port)
+	assert.Equal(t, SecurityTLS, config.Security)
+	assert.Equal(t, "SRV _imaps._tcp", config.Source)
+
+	configs, err := d.DiscoverAll(context.Background(), "me@example.org")
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, ServerConfig{Server: "mail.example.org", Port: 143, Security: SecurityStartTLS, Username: "me@example.org", Source: "SRV _imap._tcp"}, configs[1])
+}
+
+func TestDiscoverAutoconfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/example.org" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.org">
+    <incomingServer type="pop3">
+      <hostname>pop.example.org</hostname>
+      <port>995</port>
+      <socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.org</hostname>
+      <port>143</port>
+      <socketType>STARTTLS</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.org</hostname>
+      <port>993</port>
+      <socketType>SSL</socketType>
+      <username>%EMAILLOCALPART%</username>
+    </incomingServer>
+  </emailProvider>
+</clientConfig>`))
+	}))
+	defer server.Close()
+
+	d := &Discoverer{
+		LookupSRV:      noSRV,
+		HTTPClient:     server.Client(),
+		AutoconfigURLs: []string{server.URL + "/missing/{domain}", server.URL + "/{domain}"},
+	}
+	config, err := d.Discover(context.Background(), "me@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, "imap.example.org", config.Server)
+	assert.Equal(t, 993, config.Port)
+	assert.Equal(t, "me", config.Username)
+	assert.Equal(t, server.URL+"/example.org", config.Source)
+
+	configs, err := d.DiscoverAll(context.Background(), "me@example.org")
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, SecurityTLS, configs[0].Security)
+	assert.Equal(t, SecurityStartTLS, configs[1].Security)
+	assert.Equal(t, 143, configs[1].Port)
+
+	_, err = d.Discover(context.Background(), "me@example.net")
+	assert.ErrorContains(t, err, "could not find the IMAP server of example.net")
+	_, err = d.Discover(context.Background(), "example.net")
+	assert.ErrorContains(t, err, "invalid email address")
+}
@@ -0,0 +1,97 @@
+package imap
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// CapabilityFeature describes how smailnail uses an IMAP extension: what it
+// does when the server advertises it, and the fallback otherwise.
+type CapabilityFeature struct {
+	Capability string
+	Feature    string
+	Native     string
+	Fallback   string
+	// supported reports whether caps provide the extension, by default
+	// caps.Has(Capability)
+	supported func(caps imap.CapSet) bool
+}
+
+// Supported reports whether caps provide the extension.
+func (f *CapabilityFeature) Supported(caps imap.CapSet) bool {
+	if f.supported != nil {
+		return f.supported(caps)
+	}
+	return caps.Has(imap.Cap(f.Capability))
+}
+
+// CapabilityFeatures is the feature matrix of the extensions smailnail
+// cares about.
+var CapabilityFeatures = []CapabilityFeature{
+	{
+		Capability: "MOVE",
+		Feature:    "move_to and trash actions, snooze",
+		Native:     "MOVE",
+		Fallback:   `COPY, STORE +FLAGS \Deleted and EXPUNGE`,
+	},
+	{
+		Capability: "UIDPLUS",
+		Feature:    "snooze, MOVE fallback, expunge command",
+		Native:     "snoozed messages are found again by UID; the MOVE fallback and expunge remove only their own messages",
+		Fallback:   `snoozed messages are found again by Message-ID; the MOVE fallback expunges every \Deleted message; expunge refuses to run while other messages are \Deleted`,
+	},
+	{
+		Capability: "CONDSTORE",
+		Feature:    "mirror",
+		Native:     "not used yet: mirror fetches only new UIDs",
+		Fallback:   "mirror fetches only new UIDs; flag changes on mirrored messages are not picked up",
+	},
+	{
+		Capability: "IDLE",
+		Feature:    "snooze wake-ups, mirror",
+		Native:     "not used yet: commands check the mailbox when run",
+		Fallback:   "commands check the mailbox when run",
+	},
+	{
+		Capability: "SORT",
+		Feature:    "output.sort_by",
+		Native:     "SORT on the server (SORT=DISPLAY for display_from/display_to)",
+		Fallback:   "envelopes are fetched and sorted client-side",
+	},
+	{
+		Capability: "THREAD",
+		Feature:    "output.group_by_thread",
+		Native:     "THREAD on the server",
+		Fallback:   "threads are built client-side from Message-ID, In-Reply-To and References",
+		supported: func(caps imap.CapSet) bool {
+			return len(caps.ThreadAlgorithms()) > 0
+		},
+	},
+	{
+		Capability: "QUOTA",
+		Feature:    "-",
+		Native:     "not used yet",
+		Fallback:   "-",
+	},
+	{
+		Capability: "COMPRESS=DEFLATE",
+		Feature:    "all connections",
+		Native:     "not used yet: connections are not compressed",
+		Fallback:   "connections are not compressed",
+	},
+}
+
+// SortedCapabilities returns the advertised capabilities in alphabetical
+// order.
+func SortedCapabilities(caps imap.CapSet) []string {
+	ret := make([]string, 0, len(caps))
+	for c := range caps {
+		ret = append(ret, string(c))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.ToUpper(ret[i]) < strings.ToUpper(ret[j])
+	})
+	return ret
+}
@@ -0,0 +1,34 @@
+package imap
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilityFeaturesSupported(t *testing.T) {
+	supported := func(caps imap.CapSet) map[string]bool {
+		ret := map[string]bool{}
+		for _, feature := range CapabilityFeatures {
+			ret[feature.Capability] = feature.Supported(caps)
+		}
+		return ret
+	}
+
+	caps := imap.CapSet{imap.CapIMAP4rev1: {}, "THREAD=REFERENCES": {}, imap.CapSort: {}}
+	got := supported(caps)
+	assert.True(t, got["THREAD"])
+	assert.True(t, got["SORT"])
+	assert.False(t, got["MOVE"])
+	assert.False(t, got["UIDPLUS"])
+
+	// IMAP4rev2 implies MOVE and UIDPLUS, QRESYNC implies CONDSTORE
+	got = supported(imap.CapSet{imap.CapIMAP4rev2: {}, imap.CapQResync: {}})
+	assert.True(t, got["MOVE"])
+	assert.True(t, got["UIDPLUS"])
+	assert.True(t, got["CONDSTORE"])
+	assert.False(t, got["THREAD"])
+
+	assert.Equal(t, []string{"IMAP4rev1", "SORT", "THREAD=REFERENCES"}, SortedCapabilities(caps))
+}
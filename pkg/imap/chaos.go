@@ -0,0 +1,272 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ChaosConfig configures the faults ChaosProxy injects into the server's
+// responses. Faults are drawn from a random source seeded with Seed plus the
+// connection number, so a run with the same seed and the same traffic
+// injects the same faults.
+type ChaosConfig struct {
+	// Latency delays every server response, plus up to Jitter more
+	Latency time.Duration
+	Jitter  time.Duration
+	// DropRate is the probability of closing the connection instead of
+	// forwarding a response
+	DropRate float64
+	// DropAfter closes each connection after forwarding this many responses,
+	// the greeting included; 0 disables it
+	DropAfter int
+	// MalformedRate is the probability of sending a malformed untagged
+	// response before a response
+	MalformedRate float64
+	Seed          int64
+}
+
+// Validate checks if the chaos configuration is valid
+func (c ChaosConfig) Validate() error {
+	if c.Latency < 0 || c.Jitter < 0 {
+		return fmt.Errorf("latency and jitter cannot be negative")
+	}
+	if c.DropRate < 0 || c.DropRate > 1 {
+		return fmt.Errorf("drop rate must be between 0 and 1, got %v", c.DropRate)
+	}
+	if c.MalformedRate < 0 || c.MalformedRate > 1 {
+		return fmt.Errorf("malformed rate must be between 0 and 1, got %v", c.MalformedRate)
+	}
+	if c.DropAfter < 0 {
+		return fmt.Errorf("drop after cannot be negative")
+	}
+	return nil
+}
+
+// malformedResponses are the untagged responses injected by MalformedRate.
+var malformedResponses = []string{
+	"* 1 FETCH (UID\r\n",
+	"* OK [UNKNOWN-CODE\r\n",
+	"* 4294967296 EXISTS\r\n",
+	"* \x00\x01 BOGUS\r\n",
+	"* 1 FETCH (BODY[] {99}\r\n",
+}
+
+// ChaosProxy forwards IMAP connections to Upstream, injecting the faults of
+// Config into the server's responses. Client commands are forwarded as is.
+// The greeting is only ever delayed, so clients always get to log in.
+type ChaosProxy struct {
+	Upstream string
+	// UpstreamTLS dials Upstream with TLS, without verifying its certificate
+	// if UpstreamInsecure
+	UpstreamTLS      bool
+	UpstreamInsecure bool
+	// TLSConfig, if not nil, makes the proxy accept TLS connections
+	TLSConfig *tls.Config
+	Config    ChaosConfig
+
+	conns atomic.Int64
+}
+
+// errChaosDrop ends a connection dropped on purpose.
+var errChaosDrop = errors.New("connection dropped by chaos proxy")
+
+// Serve accepts connections on ln until ctx is done.
+func (p *ChaosProxy) Serve(ctx context.Context, ln net.Listener) error {
+	if err := p.Config.Validate(); err != nil {
+		return err
+	}
+	if p.TLSConfig != nil {
+		ln = tls.NewListener(ln, p.TLSConfig)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		id := p.conns.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.handle(ctx, int(id), conn)
+		}()
+	}
+}
+
+func (p *ChaosProxy) dialUpstream(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !p.UpstreamTLS {
+		return dialer.DialContext(ctx, "tcp", p.Upstream)
+	}
+	tlsDialer := &tls.Dialer{
+		NetDialer: dialer,
+		// #nosec G402 -- the proxy is a test tool, --upstream-insecure is an explicit opt-in.
+		Config: &tls.Config{InsecureSkipVerify: p.UpstreamInsecure},
+	}
+	return tlsDialer.DialContext(ctx, "tcp", p.Upstream)
+}
+
+func (p *ChaosProxy) handle(ctx context.Context, id int, client net.Conn) {
+	logger := log.With().Int("conn", id).Logger()
+	upstream, err := p.dialUpstream(ctx)
+	if err != nil {
+		logger.Error().Err(err).Str("upstream", p.Upstream).Msg("Failed to connect to upstream")
+		_ = client.Close()
+		return
+	}
+	logger.Info().Str("client", client.RemoteAddr().String()).Msg("Proxying connection")
+
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			_ = client.Close()
+			_ = upstream.Close()
+		})
+	}
+	defer closeBoth()
+	stop := context.AfterFunc(ctx, closeBoth)
+	defer stop()
+
+	go func() {
+		_, _ = io.Copy(upstream, client)
+		closeBoth()
+	}()
+
+	rng := mathrand.New(mathrand.NewSource(p.Config.Seed + int64(id)))
+	err = p.forwardResponses(rng, bufio.NewReader(upstream), client, func(fault string) {
+		logger.Info().Str("fault", fault).Msg("Injected fault")
+	})
+	if errors.Is(err, errChaosDrop) {
+		logger.Info().Msg("Dropped connection")
+	}
+}
+
+// forwardResponses copies the server's responses to client, injecting
+// faults between them.
+func (p *ChaosProxy) forwardResponses(rng *mathrand.Rand, server *bufio.Reader, client io.Writer, logFault func(string)) error {
+	c := p.Config
+	for n := 1; ; n++ {
+		resp, err := readServerResponse(server)
+		if err != nil {
+			return err
+		}
+
+		delay := c.Latency
+		if c.Jitter > 0 {
+			delay += time.Duration(rng.Int63n(int64(c.Jitter) + 1))
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		// Leave the greeting alone so that clients get to send commands
+		if n > 1 {
+			if (c.DropAfter > 0 && n > c.DropAfter) || (c.DropRate > 0 && rng.Float64() < c.DropRate) {
+				logFault("drop")
+				return errChaosDrop
+			}
+			if c.MalformedRate > 0 && rng.Float64() < c.MalformedRate {
+				malformed := malformedResponses[rng.Intn(len(malformedResponses))]
+				logFault("malformed " + strconv.Quote(malformed))
+				if _, err := io.WriteString(client, malformed); err != nil {
+					return err
+				}
+			}
+		}
+
+		if _, err := client.Write(resp); err != nil {
+			return err
+		}
+	}
+}
+
+var literalSuffix = regexp.MustCompile(`\{(\d+)\+?\}\r?\n$`)
+
+// readServerResponse reads one response line with its literals.
+func readServerResponse(r *bufio.Reader) ([]byte, error) {
+	var resp []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		resp = append(resp, line...)
+		if err != nil {
+			if err == io.EOF && len(resp) > 0 {
+				return resp, nil
+			}
+			return nil, err
+		}
+		m := literalSuffix.FindSubmatch(line)
+		if m == nil {
+			return resp, nil
+		}
+		size, err := strconv.Atoi(string(m[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid literal size %q", m[1])
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(r, literal); err != nil {
+			return nil, err
+		}
+		resp = append(resp, literal...)
+	}
+}
+
+// SelfSignedTLSConfig returns a TLS configuration with a fresh self-signed
+// certificate for hosts, for test servers that clients reach with
+// --insecure.
+func SelfSignedTLSConfig(hosts ...string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "smailnail chaos proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,114 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	mathrand "math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadServerResponse(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("* 1 FETCH (BODY[] {9}\r\nab\r\n{3}\r\n)\r\na1 OK done\r\n"))
+	resp, err := readServerResponse(r)
+	require.NoError(t, err)
+	assert.Equal(t, "* 1 FETCH (BODY[] {9}\r\nab\r\n{3}\r\n)\r\n", string(resp))
+	resp, err = readServerResponse(r)
+	require.NoError(t, err)
+	assert.Equal(t, "a1 OK done\r\n", string(resp))
+	_, err = readServerResponse(r)
+	assert.Error(t, err)
+}
+
+func forwardChaos(t *testing.T, config ChaosConfig, server string) (string, []string, error) {
+	t.Helper()
+	p := &ChaosProxy{Config: config}
+	var out bytes.Buffer
+	var faults []string
+	err := p.forwardResponses(mathrand.New(mathrand.NewSource(config.Seed)), bufio.NewReader(strings.NewReader(server)), &out, func(fault string) {
+		faults = append(faults, fault)
+	})
+	return out.String(), faults, err
+}
+
+func TestChaosFaults(t *testing.T) {
+	server := "* OK ready\r\na1 OK one\r\na2 OK two\r\na3 OK three\r\n"
+
+	out, faults, err := forwardChaos(t, ChaosConfig{DropAfter: 2}, server)
+	assert.ErrorIs(t, err, errChaosDrop)
+	assert.Equal(t, "* OK ready\r\na1 OK one\r\n", out)
+	assert.Equal(t, []string{"drop"}, faults)
+
+	// The greeting is never dropped
+	out, _, err = forwardChaos(t, ChaosConfig{DropRate: 1}, server)
+	assert.ErrorIs(t, err, errChaosDrop)
+	assert.Equal(t, "* OK ready\r\n", out)
+
+	out, faults, err = forwardChaos(t, ChaosConfig{MalformedRate: 1}, server)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(out, "* OK ready\r\n"))
+	assert.Len(t, faults, 3)
+	for _, response := range []string{"a1 OK one", "a2 OK two", "a3 OK three"} {
+		assert.Contains(t, out, response)
+	}
+
+	// The same seed injects the same faults
+	config := ChaosConfig{DropRate: 0.3, MalformedRate: 0.5, Seed: 42}
+	first, _, _ := forwardChaos(t, config, server)
+	second, _, _ := forwardChaos(t, config, server)
+	assert.Equal(t, first, second)
+
+	start := time.Now()
+	_, _, _ = forwardChaos(t, ChaosConfig{Latency: 5 * time.Millisecond}, server)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	assert.Error(t, ChaosConfig{DropRate: 2}.Validate())
+	assert.Error(t, ChaosConfig{Latency: -time.Second}.Validate())
+}
+
+func TestChaosProxyServe(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { _ = upstream.Close() }()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		fakeServer(t, conn, "TAG OK noop\r\n", "TAG OK noop\r\n")
+	}()
+
+	tlsConfig, err := SelfSignedTLSConfig("127.0.0.1")
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	proxy := &ChaosProxy{Upstream: upstream.Addr().String(), TLSConfig: tlsConfig, Config: ChaosConfig{DropAfter: 2}}
+	done := make(chan error, 1)
+	go func() { done <- proxy.Serve(ctx, ln) }()
+
+	// #nosec G402 -- the test proxy uses a self-signed certificate.
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, err)
+	client, err := NewRawClient(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "* OK ready", client.Greeting)
+
+	resp, err := client.Command("NOOP")
+	require.NoError(t, err)
+	assert.Equal(t, "OK", resp.Status)
+
+	_, err = client.Command("NOOP")
+	assert.Error(t, err, "the connection is dropped after two responses")
+	_ = client.Close()
+
+	cancel()
+	require.NoError(t, <-done)
+}
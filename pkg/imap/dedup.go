@@ -0,0 +1,35 @@
+package imap
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// MessageIDExists reports whether mailbox holds a message with the given
+// Message-ID, written with or without angle brackets, through SEARCH HEADER
+// Message-ID. It selects mailbox unless it is selected already. An empty
+// Message-ID never exists.
+func MessageIDExists(client *imapclient.Client, mailbox, messageID string) (bool, error) {
+	id := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(messageID), "<"), ">")
+	if id == "" {
+		return false, nil
+	}
+
+	if selected := client.Mailbox(); selected == nil || selected.Name != mailbox {
+		if _, err := client.Select(mailbox, nil).Wait(); err != nil {
+			return false, fmt.Errorf("failed to select mailbox %q: %w", mailbox, err)
+		}
+	}
+
+	// The brackets keep <a@example.com> from matching <aa@example.com>
+	data, err := client.UIDSearch(&imap.SearchCriteria{
+		Header: []imap.SearchCriteriaHeaderField{{Key: "Message-ID", Value: "<" + id + ">"}},
+	}, nil).Wait()
+	if err != nil {
+		return false, fmt.Errorf("failed to search for Message-ID <%s>: %w", id, err)
+	}
+	return len(data.AllUIDs()) > 0, nil
+}
@@ -0,0 +1,60 @@
+package imap
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The fixture is hand-written: <a@example.com> is in INBOX,
+// <b@example.com> is not.
+const messageIDFixture = `S: * OK [CAPABILITY IMAP4rev1 AUTH=PLAIN] IMAP server ready
+C: T1 LOGIN <redacted>
+S: T1 OK [CAPABILITY IMAP4rev1] Logged in
+C: T2 SELECT INBOX
+S: * 2 EXISTS
+S: * OK [UIDVALIDITY 1] UIDs valid
+S: * FLAGS ()
+S: T2 OK [READ-WRITE] SELECT completed
+C: T3 UID SEARCH HEADER "Message-ID" "<a@example.com>"
+S: * SEARCH 4
+S: T3 OK SEARCH completed
+C: T4 UID SEARCH HEADER "Message-ID" "<b@example.com>"
+S: * SEARCH
+S: T4 OK SEARCH completed
+C: T5 LOGOUT
+S: * BYE Logging out
+S: T5 OK LOGOUT completed
+`
+
+func TestMessageIDExists(t *testing.T) {
+	fixture, err := ParseFixture(strings.NewReader(messageIDFixture))
+	require.NoError(t, err)
+	conn, done := fixture.Pipe()
+	client := imapclient.New(conn, nil)
+	defer func() { _ = client.Close() }()
+	require.NoError(t, client.Login("user", "password").Wait())
+
+	// Selects INBOX, then finds the message with or without brackets
+	exists, err := MessageIDExists(client, "INBOX", "a@example.com")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// INBOX stays selected
+	exists, err = MessageIDExists(client, "INBOX", " <b@example.com> ")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Without a Message-ID nothing is searched
+	for _, id := range []string{"", "<>", "  "} {
+		exists, err = MessageIDExists(client, "INBOX", id)
+		require.NoError(t, err)
+		assert.False(t, exists, "%q", id)
+	}
+
+	require.NoError(t, client.Logout().Wait())
+	require.NoError(t, <-done, "the client diverged from the fixture")
+}
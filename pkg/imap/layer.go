@@ -3,6 +3,7 @@ package imap
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 
 	"github.com/emersion/go-imap/v2/imapclient"
 	"github.com/go-go-golems/glazed/pkg/cmds/fields"
@@ -17,6 +18,16 @@ type IMAPSettings struct {
 	Password string `glazed:"password"`
 	Mailbox  string `glazed:"mailbox"`
 	Insecure bool   `glazed:"insecure"`
+	// TraceIMAP is a file the protocol exchange is appended to
+	TraceIMAP         string `glazed:"trace-imap"`
+	TraceLiteralBytes int    `glazed:"trace-imap-literal-bytes"`
+	// RecordIMAP is a fixture file the session is recorded to, for replay
+	// in tests
+	RecordIMAP string `glazed:"record-imap"`
+	// Account names a profile of the accounts file filling in the settings
+	// not given otherwise, see ApplyAccount
+	Account      string `glazed:"account"`
+	AccountsFile string `glazed:"accounts-file"`
 }
 
 const IMAPSectionSlug = "imap"
@@ -35,8 +46,7 @@ func NewIMAPSection() (schema.Section, error) {
 			fields.New(
 				"port",
 				fields.TypeInteger,
-				fields.WithHelp("IMAP server port"),
-				fields.WithDefault(993),
+				fields.WithHelp("IMAP server port (default: 993)"),
 			),
 			fields.New(
 				"username",
@@ -51,8 +61,7 @@ func NewIMAPSection() (schema.Section, error) {
 			fields.New(
 				"mailbox",
 				fields.TypeString,
-				fields.WithHelp("Mailbox to search in"),
-				fields.WithDefault("INBOX"),
+				fields.WithHelp("Mailbox to search in (default: INBOX)"),
 			),
 			fields.New(
 				"insecure",
@@ -60,11 +69,44 @@ func NewIMAPSection() (schema.Section, error) {
 				fields.WithHelp("Skip TLS verification"),
 				fields.WithDefault(false),
 			),
+			fields.New(
+				"trace-imap",
+				fields.TypeString,
+				fields.WithHelp("Append the raw IMAP exchange to this file, with credentials redacted"),
+			),
+			fields.New(
+				"trace-imap-literal-bytes",
+				fields.TypeInteger,
+				fields.WithHelp("Bytes of each literal (message content) to trace, -1 for all"),
+				fields.WithDefault(256),
+			),
+			fields.New(
+				"record-imap",
+				fields.TypeString,
+				fields.WithHelp("Record the IMAP session to this fixture file for replay in tests, with credentials redacted"),
+			),
+			fields.New(
+				"account",
+				fields.TypeString,
+				fields.WithHelp("Account profile from the accounts file providing the connection settings not given as flags"),
+			),
+			fields.New(
+				"accounts-file",
+				fields.TypeString,
+				fields.WithHelp("Config file with the accounts map (default: smailnail/config.yaml in the user config directory, or ~/.smailnail/config.yaml)"),
+			),
 		),
 	)
 }
 
 func (s *IMAPSettings) ConnectToIMAPServer() (*imapclient.Client, error) {
+	return s.ConnectWithHandler(nil)
+}
+
+// ConnectWithHandler connects and logs in like ConnectToIMAPServer, with
+// handler receiving the unsolicited responses of the server, e.g. the
+// EXISTS sent during IDLE.
+func (s *IMAPSettings) ConnectWithHandler(handler *imapclient.UnilateralDataHandler) (*imapclient.Client, error) {
 	serverAddr := fmt.Sprintf("%s:%d", s.Server, s.Port)
 
 	options := &imapclient.Options{
@@ -72,9 +114,23 @@ func (s *IMAPSettings) ConnectToIMAPServer() (*imapclient.Client, error) {
 			// #nosec G402 -- this is an explicit user-controlled dev/test escape hatch exposed as --insecure.
 			InsecureSkipVerify: s.Insecure,
 		},
+		UnilateralDataHandler: handler,
 	}
 
-	client, err := imapclient.DialTLS(serverAddr, options)
+	var client *imapclient.Client
+	var err error
+	if s.TraceIMAP == "" && s.RecordIMAP == "" {
+		client, err = imapclient.DialTLS(serverAddr, options)
+	} else {
+		var conn net.Conn
+		conn, err = tls.Dial("tcp", serverAddr, options.TLSConfig)
+		if err == nil {
+			conn, err = s.wrapConn(conn)
+		}
+		if err == nil {
+			client = imapclient.New(conn, options)
+		}
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
 	}
@@ -86,3 +142,33 @@ func (s *IMAPSettings) ConnectToIMAPServer() (*imapclient.Client, error) {
 
 	return client, nil
 }
+
+// Tracer returns the tracer for --trace-imap, or nil if tracing is off.
+func (s *IMAPSettings) Tracer() (*Tracer, error) {
+	if s.TraceIMAP == "" {
+		return nil, nil
+	}
+	return openTracer(s.TraceIMAP, s.TraceLiteralBytes)
+}
+
+// wrapConn applies --record-imap and --trace-imap to conn. conn is closed on
+// error.
+func (s *IMAPSettings) wrapConn(conn net.Conn) (net.Conn, error) {
+	if s.RecordIMAP != "" {
+		recorded, err := openRecorder(s.RecordIMAP).Wrap(conn)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = recorded
+	}
+	tracer, err := s.Tracer()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if tracer != nil {
+		conn = tracer.Wrap(conn)
+	}
+	return conn, nil
+}
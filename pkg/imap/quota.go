@@ -0,0 +1,136 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+)
+
+// ErrOverQuota is matched by the errors of appends the server refused
+// because the mailbox is over quota, and of quota checks that found the
+// messages would not fit.
+var ErrOverQuota = errors.New("over quota")
+
+// Quota check modes: skip the check, log a warning when the messages will
+// not fit, or refuse to store them.
+const (
+	QuotaCheckOff   = "off"
+	QuotaCheckWarn  = "warn"
+	QuotaCheckAbort = "abort"
+)
+
+// StorageQuota is the STORAGE resource of the quota root of a mailbox, in
+// bytes.
+type StorageQuota struct {
+	Root  string
+	Usage int64
+	Limit int64
+}
+
+// Available returns the bytes that can still be stored, never negative.
+func (q *StorageQuota) Available() int64 {
+	return max(q.Limit-q.Usage, 0)
+}
+
+// OverQuotaError explains why messages cannot be stored in a mailbox. It
+// matches ErrOverQuota.
+type OverQuotaError struct {
+	Mailbox string
+	// Quota is the storage quota of the mailbox, nil if the server does not
+	// report it
+	Quota *StorageQuota
+	// Needed is the estimated size of the messages to store, 0 if unknown
+	Needed int64
+	// Err is the server's refusal, nil for a failed CheckQuota
+	Err error
+}
+
+func (e *OverQuotaError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "mailbox %q is over quota", e.Mailbox)
+	if e.Quota != nil {
+		fmt.Fprintf(&b, " (quota root %q: %s of %s used", e.Quota.Root, formatBytes(e.Quota.Usage), formatBytes(e.Quota.Limit))
+		if e.Needed > 0 {
+			fmt.Fprintf(&b, ", %s more needed", formatBytes(e.Needed))
+		}
+		b.WriteString(")")
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	b.WriteString("; free space by expunging deleted messages or emptying Trash, store fewer or smaller messages, or ask for a larger quota")
+	return b.String()
+}
+
+func (e *OverQuotaError) Is(target error) bool {
+	return target == ErrOverQuota
+}
+
+func (e *OverQuotaError) Unwrap() error {
+	return e.Err
+}
+
+// WrapAppendError turns an APPEND, COPY or MOVE refused with the OVERQUOTA
+// response code (RFC 9208) into an *OverQuotaError. Other errors are
+// returned unchanged.
+func WrapAppendError(err error, mailbox string) error {
+	if err == nil {
+		return nil
+	}
+	var imapErr *imap.Error
+	if !errors.As(err, &imapErr) {
+		return err
+	}
+	if imapErr.Code != imap.ResponseCodeOverQuota && !strings.Contains(strings.ToLower(imapErr.Text), "quota exceeded") {
+		return err
+	}
+	return &OverQuotaError{Mailbox: mailbox, Err: err}
+}
+
+// GetStorageQuota returns the storage quota of mailbox, nil if the server
+// does not support QUOTA or sets no storage limit on the mailbox.
+func GetStorageQuota(client *imapclient.Client, mailbox string) (*StorageQuota, error) {
+	if !client.Caps().Has(imap.CapQuota) {
+		return nil, nil
+	}
+	roots, err := client.GetQuotaRoot(mailbox).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the quota of mailbox %q: %w", mailbox, err)
+	}
+	for _, root := range roots {
+		storage, ok := root.Resources[imap.QuotaResourceStorage]
+		if !ok {
+			continue
+		}
+		// STORAGE is counted in units of 1024 octets
+		return &StorageQuota{Root: root.Root, Usage: storage.Usage * 1024, Limit: storage.Limit * 1024}, nil
+	}
+	return nil, nil
+}
+
+// CheckQuota checks that needed bytes fit in the storage quota of mailbox
+// before they are appended, returning an *OverQuotaError along with the
+// quota when they do not. Servers without QUOTA are not checked.
+func CheckQuota(client *imapclient.Client, mailbox string, needed int64) (*StorageQuota, error) {
+	quota, err := GetStorageQuota(client, mailbox)
+	if err != nil || quota == nil || needed <= quota.Available() {
+		return quota, err
+	}
+	return quota, &OverQuotaError{Mailbox: mailbox, Quota: quota, Needed: needed}
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
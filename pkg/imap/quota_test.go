@@ -0,0 +1,40 @@
+package imap
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapAppendError(t *testing.T) {
+	overQuota := &imap.Error{Type: imap.StatusResponseTypeNo, Code: imap.ResponseCodeOverQuota, Text: "Quota exceeded (mailbox for user is full)"}
+	err := WrapAppendError(fmt.Errorf("append: %w", overQuota), "INBOX")
+	assert.True(t, errors.Is(err, ErrOverQuota))
+	var quotaErr *OverQuotaError
+	if assert.True(t, errors.As(err, &quotaErr)) {
+		assert.Equal(t, "INBOX", quotaErr.Mailbox)
+	}
+	assert.Contains(t, err.Error(), "emptying Trash")
+
+	// Servers without the response code still say so in the text
+	noCode := &imap.Error{Type: imap.StatusResponseTypeNo, Text: "Quota exceeded"}
+	assert.True(t, errors.Is(WrapAppendError(noCode, "INBOX"), ErrOverQuota))
+
+	other := &imap.Error{Type: imap.StatusResponseTypeNo, Code: imap.ResponseCodeTryCreate, Text: "No such mailbox"}
+	assert.Equal(t, error(other), WrapAppendError(other, "Archive"))
+	assert.NoError(t, WrapAppendError(nil, "INBOX"))
+}
+
+func TestOverQuotaErrorMessage(t *testing.T) {
+	err := &OverQuotaError{
+		Mailbox: "INBOX",
+		Quota:   &StorageQuota{Root: "", Usage: 9 << 20, Limit: 10 << 20},
+		Needed:  3 << 20,
+	}
+	assert.Equal(t, int64(1<<20), err.Quota.Available())
+	assert.Contains(t, err.Error(), `mailbox "INBOX" is over quota (quota root "": 9.0 MiB of 10.0 MiB used, 3.0 MiB more needed)`)
+	assert.Equal(t, int64(0), (&StorageQuota{Usage: 12, Limit: 10}).Available())
+}
@@ -0,0 +1,154 @@
+package imap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RawResponse is the server's answer to one command sent by a RawClient.
+type RawResponse struct {
+	Tag string
+	// Lines are the untagged responses, with literals inlined
+	Lines []string
+	// Status is the tagged status: OK, NO or BAD
+	Status string
+	// Text is the rest of the tagged response, e.g. "SEARCH completed"
+	Text string
+}
+
+// Line returns the tagged response line.
+func (r *RawResponse) Line() string {
+	return strings.TrimSpace(r.Tag + " " + r.Status + " " + r.Text)
+}
+
+// RawClient sends IMAP commands verbatim and returns the server's responses
+// unparsed, for debugging server behavior the DSL abstracts away.
+type RawClient struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	tag      int
+	Greeting string
+}
+
+// DialRaw opens a TLS connection for a RawClient and logs in.
+func (s *IMAPSettings) DialRaw() (*RawClient, error) {
+	serverAddr := net.JoinHostPort(s.Server, strconv.Itoa(s.Port))
+	conn, err := tls.Dial("tcp", serverAddr, &tls.Config{
+		ServerName: s.Server,
+		// #nosec G402 -- this is an explicit user-controlled dev/test escape hatch exposed as --insecure.
+		InsecureSkipVerify: s.Insecure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+	traced, err := s.wrapConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewRawClient(traced)
+	if err != nil {
+		_ = traced.Close()
+		return nil, err
+	}
+	resp, err := c.Command("LOGIN " + QuoteString(s.Username) + " " + QuoteString(s.Password))
+	if err != nil {
+		_ = traced.Close()
+		return nil, fmt.Errorf("failed to login: %w", err)
+	}
+	if resp.Status != "OK" {
+		_ = traced.Close()
+		return nil, fmt.Errorf("failed to login: %s", resp.Line())
+	}
+	return c, nil
+}
+
+// NewRawClient reads the server greeting from conn.
+func NewRawClient(conn net.Conn) (*RawClient, error) {
+	c := &RawClient{conn: conn, r: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("error reading greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		return nil, fmt.Errorf("unexpected greeting: %s", greeting)
+	}
+	c.Greeting = greeting
+	return c, nil
+}
+
+// Command sends cmd with a fresh tag and reads responses up to the tagged
+// one. A NO or BAD status is returned in the response, not as an error.
+// Commands waiting for continuation data (literals, IDLE, AUTHENTICATE)
+// are not supported.
+func (c *RawClient) Command(cmd string) (*RawResponse, error) {
+	c.tag++
+	resp := &RawResponse{Tag: fmt.Sprintf("a%d", c.tag)}
+	if _, err := io.WriteString(c.conn, resp.Tag+" "+cmd+"\r\n"); err != nil {
+		return nil, fmt.Errorf("error sending command: %w", err)
+	}
+
+	for {
+		line, err := c.readResponse()
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		switch {
+		case strings.HasPrefix(line, resp.Tag+" "):
+			resp.Status, resp.Text, _ = strings.Cut(strings.TrimPrefix(line, resp.Tag+" "), " ")
+			return resp, nil
+		case strings.HasPrefix(line, "+"):
+			return nil, fmt.Errorf("server requested continuation data, which raw commands do not support: %s", line)
+		default:
+			resp.Lines = append(resp.Lines, line)
+		}
+	}
+}
+
+// Close logs out and closes the connection.
+func (c *RawClient) Close() error {
+	_, _ = c.Command("LOGOUT")
+	return c.conn.Close()
+}
+
+var literalPattern = regexp.MustCompile(`\{(\d+)\+?\}$`)
+
+// readResponse reads one response line, including the literals it
+// announces with {n}.
+func (c *RawClient) readResponse() (string, error) {
+	var b strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		b.WriteString(line)
+
+		m := literalPattern.FindStringSubmatch(line)
+		if m == nil {
+			return b.String(), nil
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return "", fmt.Errorf("invalid literal size %q", m[1])
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", fmt.Errorf("error reading literal: %w", err)
+		}
+		b.WriteString("\r\n")
+		b.Write(literal)
+	}
+}
+
+// QuoteString returns s as an IMAP quoted string.
+func QuoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,78 @@
+package imap
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer answers each command line with the given responses, in which
+// "TAG" is replaced by the command's tag.
+func fakeServer(t *testing.T, conn net.Conn, responses ...string) {
+	t.Helper()
+	go func() {
+		defer func() { _ = conn.Close() }()
+		r := bufio.NewReader(conn)
+		if _, err := conn.Write([]byte("* OK ready\r\n")); err != nil {
+			return
+		}
+		for _, response := range responses {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, _, _ := strings.Cut(line, " ")
+			if _, err := conn.Write([]byte(strings.ReplaceAll(response, "TAG", tag))); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestRawClientCommand(t *testing.T) {
+	client, server := net.Pipe()
+	fakeServer(t, server,
+		"* SEARCH 2 4\r\nTAG OK SEARCH completed\r\n",
+		"* 2 FETCH (UID 2 BODY[HEADER] {15}\r\nSubject: hi\r\n\r\n)\r\nTAG OK done\r\n",
+		"TAG NO [NONEXISTENT] no such mailbox\r\n",
+	)
+
+	c, err := NewRawClient(client)
+	require.NoError(t, err)
+	assert.Equal(t, "* OK ready", c.Greeting)
+
+	resp, err := c.Command("UID SEARCH ALL")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"* SEARCH 2 4"}, resp.Lines)
+	assert.Equal(t, "OK", resp.Status)
+	assert.Equal(t, "a1 OK SEARCH completed", resp.Line())
+
+	resp, err = c.Command("UID FETCH 2 BODY.PEEK[HEADER]")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"* 2 FETCH (UID 2 BODY[HEADER] {15}\r\nSubject: hi\r\n\r\n)"}, resp.Lines)
+
+	resp, err = c.Command("SELECT Missing")
+	require.NoError(t, err)
+	assert.Empty(t, resp.Lines)
+	assert.Equal(t, "NO", resp.Status)
+	assert.Equal(t, "[NONEXISTENT] no such mailbox", resp.Text)
+}
+
+func TestRawClientContinuation(t *testing.T) {
+	client, server := net.Pipe()
+	fakeServer(t, server, "+ idling\r\n")
+
+	c, err := NewRawClient(client)
+	require.NoError(t, err)
+	_, err = c.Command("IDLE")
+	assert.ErrorContains(t, err, "continuation")
+}
+
+func TestQuoteString(t *testing.T) {
+	assert.Equal(t, `"user@example.com"`, QuoteString("user@example.com"))
+	assert.Equal(t, `"a\"b\\c"`, QuoteString(`a"b\c`))
+}
@@ -0,0 +1,436 @@
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fixture is a recorded IMAP session: the lines the client sent and the
+// server answered, in order. Literal data is split into lines like the rest.
+//
+// In the fixture file each line is prefixed with "C: " or "S: " and stored
+// without its CRLF. Lines that do not end in CRLF or hold control characters
+// are stored Go-quoted, including their line ending, after "C= " or "S= ".
+// Credentials are recorded as <redacted>.
+type Fixture struct {
+	Events []FixtureEvent
+}
+
+// FixtureEvent is one line of a recorded session, with its line ending.
+type FixtureEvent struct {
+	Client bool
+	Line   []byte
+}
+
+// redacted replaces recorded credentials.
+const redacted = "<redacted>"
+
+// LoadFixture reads a fixture file.
+func LoadFixture(path string) (*Fixture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IMAP fixture: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fixture, err := ParseFixture(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP fixture %s: %w", path, err)
+	}
+	return fixture, nil
+}
+
+// ParseFixture reads a fixture in the file format.
+func ParseFixture(r io.Reader) (*Fixture, error) {
+	fixture := &Fixture{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if len(line) < 3 || (line[0] != 'C' && line[0] != 'S') || (line[1] != ':' && line[1] != '=') || line[2] != ' ' {
+			return nil, fmt.Errorf("line %d: expected a C: or S: prefix", n)
+		}
+		event := FixtureEvent{Client: line[0] == 'C'}
+		if line[1] == '=' {
+			unquoted, err := strconv.Unquote(line[3:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			event.Line = []byte(unquoted)
+		} else {
+			event.Line = []byte(line[3:] + "\r\n")
+		}
+		fixture.Events = append(fixture.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fixture, nil
+}
+
+// formatFixtureLine returns the file line of an event.
+func formatFixtureLine(client bool, line []byte) string {
+	prefix := "S"
+	if client {
+		prefix = "C"
+	}
+	text, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if ok && !bytes.ContainsFunc(text, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		return prefix + ": " + string(text) + "\n"
+	}
+	return prefix + "= " + strconv.Quote(string(line)) + "\n"
+}
+
+// Recorder writes the sessions of the connections it wraps to fixture
+// files: the first connection to Path, the next ones to Path.2, Path.3 and
+// so on.
+type Recorder struct {
+	Path string
+
+	mu    sync.Mutex
+	conns int
+}
+
+// NewRecorder returns a Recorder writing to path.
+func NewRecorder(path string) *Recorder {
+	return &Recorder{Path: path}
+}
+
+var (
+	recordersMu sync.Mutex
+	recorders   = map[string]*Recorder{}
+)
+
+// openRecorder returns the Recorder writing to path, shared by every
+// connection of the process so that they get numbered files.
+func openRecorder(path string) *Recorder {
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+	if r, ok := recorders[path]; ok {
+		return r
+	}
+	r := NewRecorder(path)
+	recorders[path] = r
+	return r
+}
+
+// Wrap returns conn with its session recorded.
+func (r *Recorder) Wrap(conn net.Conn) (net.Conn, error) {
+	r.mu.Lock()
+	r.conns++
+	path := r.Path
+	if r.conns > 1 {
+		path = fmt.Sprintf("%s.%d", r.Path, r.conns)
+	}
+	r.mu.Unlock()
+
+	// Fixtures hold message content, keep them private
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IMAP fixture: %w", err)
+	}
+	rc := &recordingConn{Conn: conn, w: f, file: f}
+	rc.client = &recordStream{conn: rc, client: true}
+	rc.server = &recordStream{conn: rc}
+	return rc, nil
+}
+
+type recordingConn struct {
+	net.Conn
+	mu             sync.Mutex
+	w              io.Writer
+	file           *os.File
+	client, server *recordStream
+	// authTag is the tag of a running AUTHENTICATE, whose client lines are
+	// redacted until the server completes it
+	authTag string
+	// Clients may send commands before reading the greeting; their lines
+	// are held back until the greeting is recorded, so that replays greet
+	// first
+	greeted   bool
+	pending   []string
+	closeOnce sync.Once
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.server.write(p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.client.write(p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.client.flush()
+		c.server.flush()
+		for _, line := range c.pending {
+			_, _ = io.WriteString(c.w, line)
+		}
+		_ = c.file.Close()
+		c.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// recordStream splits one direction of a connection into lines.
+type recordStream struct {
+	conn   *recordingConn
+	client bool
+	line   []byte
+	// redactLiterals hides the literals of a LOGIN command
+	redactLiterals bool
+}
+
+func (s *recordStream) write(p []byte) {
+	s.conn.mu.Lock()
+	defer s.conn.mu.Unlock()
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.line = append(s.line, p...)
+			return
+		}
+		s.line = append(s.line, p[:i+1]...)
+		p = p[i+1:]
+		s.emit(s.line)
+		s.line = nil
+	}
+}
+
+func (s *recordStream) flush() {
+	if len(s.line) > 0 {
+		s.emit(s.line)
+		s.line = nil
+	}
+}
+
+func (s *recordStream) emit(line []byte) {
+	c := s.conn
+	if s.client {
+		line = s.redact(line)
+	} else if c.authTag != "" && bytes.HasPrefix(line, []byte(c.authTag+" ")) {
+		c.authTag = ""
+	}
+	formatted := formatFixtureLine(s.client, line)
+	if s.client && !c.greeted {
+		c.pending = append(c.pending, formatted)
+		return
+	}
+	_, _ = io.WriteString(c.w, formatted)
+	if !c.greeted {
+		c.greeted = true
+		for _, line := range c.pending {
+			_, _ = io.WriteString(c.w, line)
+		}
+		c.pending = nil
+	}
+}
+
+// redact hides the credentials of LOGIN and AUTHENTICATE.
+func (s *recordStream) redact(line []byte) []byte {
+	c := s.conn
+	text := string(line)
+	if s.redactLiterals || c.authTag != "" {
+		s.redactLiterals = s.redactLiterals && literalSuffix.MatchString(text)
+		return []byte(redacted + "\r\n")
+	}
+	if m := loginPattern.FindStringSubmatch(text); m != nil {
+		s.redactLiterals = literalSuffix.MatchString(text)
+		return []byte(m[1] + " " + redacted + "\r\n")
+	}
+	if m := authenticatePattern.FindStringSubmatch(text); m != nil {
+		c.authTag, _, _ = strings.Cut(text, " ")
+		if strings.TrimRight(text, "\r\n") == m[1] {
+			return line
+		}
+		return []byte(m[1] + " " + redacted + "\r\n")
+	}
+	return line
+}
+
+// ReplayMismatchError reports a client line that differs from the fixture.
+type ReplayMismatchError struct {
+	Event    int
+	Expected string
+	Got      string
+}
+
+func (e *ReplayMismatchError) Error() string {
+	return fmt.Sprintf("IMAP fixture event %d: expected client line %q, got %q", e.Event, e.Expected, e.Got)
+}
+
+// Replay plays the server side of the fixture on conn: server lines are
+// sent as recorded and client lines are checked against the recording.
+// Tags and the order of FETCH items may differ from the recording;
+// credentials are not checked. On a
+// mismatch the client gets a BYE and Replay returns a *ReplayMismatchError.
+// Replay returns nil once the fixture is played through.
+func (f *Fixture) Replay(conn net.Conn) error {
+	defer func() {
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	tags := map[string]string{}
+	for i, event := range f.Events {
+		if !event.Client {
+			line := event.Line
+			if tag, rest, ok := bytes.Cut(line, []byte(" ")); ok {
+				if actual, ok := tags[string(tag)]; ok {
+					line = append([]byte(actual+" "), rest...)
+				}
+			}
+			if _, err := conn.Write(line); err != nil {
+				return fmt.Errorf("IMAP fixture event %d: %w", i+1, err)
+			}
+			continue
+		}
+
+		got, err := r.ReadBytes('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && len(got) > 0) {
+			return fmt.Errorf("IMAP fixture event %d: client went away: %w", i+1, err)
+		}
+		if !matchClientLine(event.Line, got, tags) {
+			_, _ = conn.Write([]byte("* BYE fixture mismatch\r\n"))
+			return &ReplayMismatchError{Event: i + 1, Expected: string(event.Line), Got: string(got)}
+		}
+	}
+	return nil
+}
+
+// matchClientLine reports whether got matches the recorded line, learning
+// the tag mapping from command lines.
+func matchClientLine(expected, got []byte, tags map[string]string) bool {
+	if bytes.Equal(expected, got) || string(expected) == redacted+"\r\n" {
+		return true
+	}
+	expTag, expRest, ok1 := strings.Cut(string(expected), " ")
+	gotTag, gotRest, ok2 := strings.Cut(string(got), " ")
+	if !ok1 || !ok2 {
+		return false
+	}
+	if mapped, ok := tags[expTag]; ok && mapped != gotTag {
+		return false
+	}
+	match := expRest == gotRest || normalizeCommand(expRest) == normalizeCommand(gotRest)
+	if !match {
+		// Credentials were redacted: only the command has to agree
+		if prefix, ok := strings.CutSuffix(expRest, " "+redacted+"\r\n"); ok {
+			match = strings.HasPrefix(strings.ToUpper(gotRest), strings.ToUpper(prefix)+" ")
+		}
+	}
+	if match {
+		tags[expTag] = gotTag
+	}
+	return match
+}
+
+// normalizeCommand puts the parts of command that go-imap sends in random
+// order in a fixed order.
+func normalizeCommand(command string) string {
+	return normalizeSearchReturn(normalizeFetchItems(command))
+}
+
+var fetchCommand = regexp.MustCompile(`(?i)^((?:UID )?FETCH \S+ )\((.*)\)(\r\n)$`)
+
+// normalizeFetchItems sorts the items of a FETCH command: go-imap sends them
+// in random order.
+func normalizeFetchItems(command string) string {
+	m := fetchCommand.FindStringSubmatch(command)
+	if m == nil {
+		return command
+	}
+	var items []string
+	depth, start := 0, 0
+	for i, r := range m[2] {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ' ':
+			if depth == 0 {
+				items = append(items, m[2][start:i])
+				start = i + 1
+			}
+		}
+	}
+	items = append(items, m[2][start:])
+	sort.Strings(items)
+	return m[1] + "(" + strings.Join(items, " ") + ")" + m[3]
+}
+
+var searchReturnCommand = regexp.MustCompile(`(?i)^((?:UID )?SEARCH RETURN )\(([^()]*)\)( .*\r\n)$`)
+
+// normalizeSearchReturn sorts the RETURN options of an ESEARCH command:
+// go-imap sends them in random order.
+func normalizeSearchReturn(command string) string {
+	m := searchReturnCommand.FindStringSubmatch(command)
+	if m == nil {
+		return command
+	}
+	options := strings.Fields(m[2])
+	sort.Strings(options)
+	return m[1] + "(" + strings.Join(options, " ") + ")" + m[3]
+}
+
+// Pipe returns a connection to a replay of the fixture, and a channel that
+// receives the result of Replay once the session ends. It is meant for
+// tests, with imapclient.New.
+func (f *Fixture) Pipe() (net.Conn, <-chan error) {
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Replay(server)
+	}()
+	return client, done
+}
+
+// ServeFixture replays the fixture to every connection accepted on ln until
+// ctx is done. Mismatches are passed to onError, if not nil.
+func ServeFixture(ctx context.Context, ln net.Listener, fixture *Fixture, onError func(error)) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+			defer stop()
+			if err := fixture.Replay(conn); err != nil && onError != nil {
+				onError(err)
+			}
+		}()
+	}
+}
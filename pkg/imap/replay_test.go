@@ -0,0 +1,116 @@
+package imap
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixtureFormat(t *testing.T) {
+	fixture, err := ParseFixture(strings.NewReader("S: * OK ready\nC: a1 NOOP\n\nS= \"partial\\x00\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []FixtureEvent{
+		{Line: []byte("* OK ready\r\n")},
+		{Client: true, Line: []byte("a1 NOOP\r\n")},
+		{Line: []byte("partial\x00")},
+	}, fixture.Events)
+
+	assert.Equal(t, "C: a1 NOOP\n", formatFixtureLine(true, []byte("a1 NOOP\r\n")))
+	assert.Equal(t, "S= \"bare\\n\"\n", formatFixtureLine(false, []byte("bare\n")))
+
+	_, err = ParseFixture(strings.NewReader("X: nope\n"))
+	assert.Error(t, err)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.imap")
+	recorder := NewRecorder(path)
+
+	client, server := net.Pipe()
+	fakeServer(t, server,
+		"TAG OK logged in\r\n",
+		"* 1 FETCH (UID 7 BODY[] {4}\r\nhi\r\n)\r\nTAG OK done\r\n",
+	)
+	recorded, err := recorder.Wrap(client)
+	require.NoError(t, err)
+	c, err := NewRawClient(recorded)
+	require.NoError(t, err)
+	_, err = c.Command(`LOGIN "user" "secret"`)
+	require.NoError(t, err)
+	_, err = c.Command("UID FETCH 7 BODY.PEEK[]")
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.Equal(t, `S: * OK ready
+C: a1 LOGIN <redacted>
+S: a1 OK logged in
+C: a2 UID FETCH 7 BODY.PEEK[]
+S: * 1 FETCH (UID 7 BODY[] {4}
+S: hi
+S: )
+S: a2 OK done
+`, string(data))
+
+	fixture, err := LoadFixture(path)
+	require.NoError(t, err)
+	conn, done := fixture.Pipe()
+	c, err = NewRawClient(conn)
+	require.NoError(t, err)
+	resp, err := c.Command(`LOGIN "other" "password"`)
+	require.NoError(t, err)
+	assert.Equal(t, "OK", resp.Status)
+	resp, err = c.Command("UID FETCH 7 BODY.PEEK[]")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"* 1 FETCH (UID 7 BODY[] {4}\r\nhi\r\n)"}, resp.Lines)
+	require.NoError(t, <-done)
+	_ = c.Close()
+}
+
+func TestReplayTagsAndMismatch(t *testing.T) {
+	fixture, err := ParseFixture(strings.NewReader("S: * OK ready\nC: T7 NOOP\nS: T7 OK noop\nC: T8 SELECT INBOX\nS: T8 OK selected\n"))
+	require.NoError(t, err)
+
+	// Recorded tags are mapped to the client's
+	conn, done := fixture.Pipe()
+	c, err := NewRawClient(conn)
+	require.NoError(t, err)
+	resp, err := c.Command("NOOP")
+	require.NoError(t, err)
+	assert.Equal(t, "a1 OK noop", resp.Line())
+
+	_, err = c.Command("SELECT Archive")
+	assert.Error(t, err, "the replay says BYE and hangs up")
+	err = <-done
+	var mismatch *ReplayMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.Equal(t, 4, mismatch.Event)
+	assert.Equal(t, "a2 SELECT Archive\r\n", mismatch.Got)
+	_ = c.Close()
+}
+
+func TestNormalizeFetchItems(t *testing.T) {
+	assert.Equal(t,
+		normalizeFetchItems("FETCH 2:3 (UID ENVELOPE FLAGS BODY.PEEK[HEADER.FIELDS (TO FROM)])\r\n"),
+		normalizeFetchItems("FETCH 2:3 (UID BODY.PEEK[HEADER.FIELDS (TO FROM)] FLAGS ENVELOPE)\r\n"))
+	assert.NotEqual(t,
+		normalizeFetchItems("UID FETCH 2 (UID FLAGS)\r\n"),
+		normalizeFetchItems("UID FETCH 3 (UID FLAGS)\r\n"))
+	assert.Equal(t, "SELECT INBOX\r\n", normalizeFetchItems("SELECT INBOX\r\n"))
+}
+
+func TestNormalizeSearchReturn(t *testing.T) {
+	assert.Equal(t,
+		normalizeSearchReturn("SEARCH RETURN (ALL COUNT) SUBJECT \"invoice\"\r\n"),
+		normalizeSearchReturn("SEARCH RETURN (COUNT ALL) SUBJECT \"invoice\"\r\n"))
+	assert.NotEqual(t,
+		normalizeSearchReturn("UID SEARCH RETURN (MIN) ALL\r\n"),
+		normalizeSearchReturn("UID SEARCH RETURN (MAX) ALL\r\n"))
+}
@@ -0,0 +1,220 @@
+package imap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer logs the IMAP exchange of the connections it wraps, one protocol
+// line per log line, prefixed with a timestamp, a connection number and the
+// direction ("C:" client, "S:" server). LOGIN and AUTHENTICATE credentials
+// are redacted and literals are truncated to MaxLiteral bytes.
+type Tracer struct {
+	// MaxLiteral is the number of literal bytes logged, 0 logs none and a
+	// negative value logs everything
+	MaxLiteral int
+
+	mu    sync.Mutex
+	w     io.Writer
+	conns int
+	now   func() time.Time
+}
+
+// NewTracer returns a Tracer writing to w.
+func NewTracer(w io.Writer, maxLiteral int) *Tracer {
+	return &Tracer{w: w, MaxLiteral: maxLiteral, now: time.Now}
+}
+
+var (
+	tracersMu sync.Mutex
+	tracers   = map[string]*Tracer{}
+)
+
+// openTracer returns the Tracer appending to path, shared by every
+// connection of the process. The file stays open until the process exits.
+func openTracer(path string, maxLiteral int) (*Tracer, error) {
+	tracersMu.Lock()
+	defer tracersMu.Unlock()
+	if t, ok := tracers[path]; ok {
+		return t, nil
+	}
+	// The trace holds message content, keep it private
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IMAP trace file: %w", err)
+	}
+	t := NewTracer(f, maxLiteral)
+	tracers[path] = t
+	return t, nil
+}
+
+// Wrap returns conn with its traffic logged.
+func (t *Tracer) Wrap(conn net.Conn) net.Conn {
+	t.mu.Lock()
+	t.conns++
+	id := t.conns
+	t.mu.Unlock()
+
+	t.log(id, "*", fmt.Sprintf("connected to %s", conn.RemoteAddr()))
+	return &tracedConn{
+		Conn:   conn,
+		t:      t,
+		id:     id,
+		client: &traceStream{t: t, id: id, prefix: "C:", client: true},
+		server: &traceStream{t: t, id: id, prefix: "S:"},
+	}
+}
+
+func (t *Tracer) log(id int, prefix, line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = fmt.Fprintf(t.w, "%s [%d] %s %s\n", t.now().UTC().Format("2006-01-02T15:04:05.000Z"), id, prefix, line)
+}
+
+type tracedConn struct {
+	net.Conn
+	t              *Tracer
+	id             int
+	client, server *traceStream
+	closeOnce      sync.Once
+}
+
+func (c *tracedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.server.write(p[:n])
+	return n, err
+}
+
+func (c *tracedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.client.write(p[:n])
+	return n, err
+}
+
+func (c *tracedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.t.log(c.id, "*", "closed")
+	})
+	return c.Conn.Close()
+}
+
+var (
+	loginPattern        = regexp.MustCompile(`(?i)^(\S+ LOGIN)\b`)
+	authenticatePattern = regexp.MustCompile(`(?i)^(\S+ AUTHENTICATE \S+)`)
+)
+
+// traceStream splits one direction of a connection into protocol lines
+// and literals.
+type traceStream struct {
+	t      *Tracer
+	id     int
+	prefix string
+	client bool
+
+	line []byte
+	// literalLeft is the number of literal bytes still expected
+	literalLeft int
+	literal     []byte
+	omitted     int
+	// redacting hides the rest of a LOGIN command, including its literals
+	redacting bool
+	// authenticating hides the client's SASL responses
+	authenticating bool
+}
+
+func (s *traceStream) write(p []byte) {
+	for len(p) > 0 {
+		if s.literalLeft > 0 {
+			n := min(len(p), s.literalLeft)
+			keep := n
+			if s.t.MaxLiteral >= 0 {
+				keep = max(0, min(n, s.t.MaxLiteral-len(s.literal)))
+			}
+			s.literal = append(s.literal, p[:keep]...)
+			s.omitted += n - keep
+			s.literalLeft -= n
+			p = p[n:]
+			if s.literalLeft == 0 {
+				s.flushLiteral()
+			}
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			s.line = append(s.line, p...)
+			return
+		}
+		s.line = append(s.line, p[:i+1]...)
+		p = p[i+1:]
+		s.flushLine()
+	}
+}
+
+func (s *traceStream) flushLine() {
+	line := strings.TrimRight(string(s.line), "\r\n")
+	s.line = s.line[:0]
+
+	literal := 0
+	if m := literalPattern.FindStringSubmatch(line); m != nil {
+		literal, _ = strconv.Atoi(m[1])
+	}
+	s.t.log(s.id, s.prefix, s.redact(line))
+	s.literalLeft = literal
+	if literal == 0 {
+		s.redacting = false
+	}
+}
+
+// redact hides credentials in client lines.
+func (s *traceStream) redact(line string) string {
+	if !s.client {
+		return line
+	}
+	switch {
+	case s.redacting:
+		return "***"
+	case s.authenticating && !strings.Contains(line, " "):
+		// SASL responses are a single base64 token
+		return "***"
+	}
+	s.authenticating = false
+
+	if m := loginPattern.FindStringSubmatch(line); m != nil {
+		s.redacting = true
+		return m[1] + " ***"
+	}
+	if m := authenticatePattern.FindStringSubmatch(line); m != nil {
+		s.authenticating = true
+		if len(line) > len(m[1]) {
+			// SASL-IR initial response
+			return m[1] + " ***"
+		}
+		return line
+	}
+	return line
+}
+
+func (s *traceStream) flushLiteral() {
+	switch {
+	case s.redacting:
+		s.t.log(s.id, s.prefix, "***")
+	case len(s.literal) > 0:
+		for _, line := range strings.Split(strings.TrimSuffix(string(s.literal), "\r\n"), "\n") {
+			s.t.log(s.id, s.prefix, "| "+strings.TrimSuffix(line, "\r"))
+		}
+	}
+	if s.omitted > 0 && !s.redacting {
+		s.t.log(s.id, s.prefix, fmt.Sprintf("| ... %d more literal bytes", s.omitted))
+	}
+	s.literal = s.literal[:0]
+	s.omitted = 0
+}
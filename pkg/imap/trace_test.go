@@ -0,0 +1,67 @@
+package imap
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, 8)
+	tracer.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	client, server := net.Pipe()
+	fakeServer(t, server,
+		"TAG OK logged in\r\n",
+		"* 1 FETCH (BODY[] {26}\r\nSubject: hi\r\n\r\nsecret body)\r\nTAG OK done\r\n",
+		"TAG OK authenticated\r\n",
+	)
+
+	c, err := NewRawClient(tracer.Wrap(client))
+	require.NoError(t, err)
+	_, err = c.Command(`LOGIN "user" "hunter2"`)
+	require.NoError(t, err)
+	_, err = c.Command("FETCH 1 BODY[]")
+	require.NoError(t, err)
+	_, err = c.Command("AUTHENTICATE PLAIN AHVzZXIAaHVudGVyMg==")
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimPrefix(lines[i], "2025-01-02T03:04:05.000Z [1] ")
+	}
+	assert.Equal(t, []string{
+		"* connected to pipe",
+		"S: * OK ready",
+		"C: a1 LOGIN ***",
+		"S: a1 OK logged in",
+		"C: a2 FETCH 1 BODY[]",
+		"S: * 1 FETCH (BODY[] {26}",
+		"S: | Subject:",
+		"S: | ... 18 more literal bytes",
+		"S: )",
+		"S: a2 OK done",
+		"C: a3 AUTHENTICATE PLAIN ***",
+		"S: a3 OK authenticated",
+	}, lines)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.NotContains(t, buf.String(), "AHVzZXIAaHVudGVyMg")
+}
+
+func TestTracerRedactsLoginLiterals(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, -1)
+	stream := &traceStream{t: tracer, id: 1, prefix: "C:", client: true}
+
+	stream.write([]byte("a1 LOGIN {4}\r\nuser {7}\r\nhunter2\r\na2 NOOP\r\n"))
+	out := buf.String()
+	assert.NotContains(t, out, "user")
+	assert.NotContains(t, out, "hunter2")
+	assert.Contains(t, out, "C: a2 NOOP")
+}
@@ -0,0 +1,59 @@
+package mailgen
+
+import (
+	"path/filepath"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// LoadCorpus adds the messages of the corpus of each rule as variations,
+// with the keys subject, from, to, cc, body, date, message_id, in_reply_to
+// and references. Relative corpus paths are resolved against baseDir, the
+// directory of the config file. Values are escaped, so templates can use
+// them as {{ .subject }} without rendering the mail's own braces.
+func LoadCorpus(config *types.TemplateConfig, baseDir string) error {
+	for name, rule := range config.Rules {
+		if rule.Corpus == "" {
+			continue
+		}
+		path := rule.Corpus
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		records, err := dsl.ReadCorpus(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load corpus of rule '%s'", name)
+		}
+		if len(records) == 0 {
+			return errors.Errorf("corpus '%s' of rule '%s' is empty", rule.Corpus, name)
+		}
+		for _, record := range records {
+			rule.Variations = append(rule.Variations, corpusVariation(record))
+		}
+		config.Rules[name] = rule
+	}
+	return nil
+}
+
+func corpusVariation(record *dsl.CorpusRecord) map[string]string {
+	variation := map[string]string{}
+	for key, value := range map[string]string{
+		"subject":     record.Subject,
+		"from":        record.From,
+		"to":          record.To,
+		"cc":          record.Cc,
+		"body":        record.Body,
+		"date":        record.Date,
+		"message_id":  record.MessageID,
+		"in_reply_to": record.InReplyTo,
+		"references":  record.References,
+	} {
+		// mailgen rejects empty variation values
+		if value != "" {
+			variation[key] = templateLiteral(value)
+		}
+	}
+	return variation
+}
@@ -0,0 +1,68 @@
+package mailgen
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/types"
+)
+
+func TestLoadCorpus(t *testing.T) {
+	dir := t.TempDir()
+	var data []byte
+	for _, record := range []dsl.CorpusRecord{
+		{UID: 1, Subject: "Build {{ red }}", From: "ci@example.com", To: "Dev <dev@example.com>", Body: "Broken", Date: "2024-12-02T10:00:00Z"},
+		{UID: 2, Subject: "Build green", From: "ci@example.com", Body: "Fixed"},
+	} {
+		line, err := json.Marshal(record)
+		require.NoError(t, err)
+		data = append(append(data, line...), '\n')
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "corpus.jsonl"), data, 0600))
+
+	config := &types.TemplateConfig{
+		Templates: map[string]types.EmailTemplate{
+			"replay": {
+				Subject:      "{{ .subject }}",
+				From:         "{{ .from }}",
+				To:           `{{ .to | default "" }}`,
+				Body:         "{{ .body }}",
+				InternalDate: `{{ .date | default "" }}`,
+			},
+		},
+		Rules: map[string]types.RuleConfig{
+			"real": {Template: "replay", Corpus: "corpus.jsonl"},
+		},
+		Generate: []types.GenerateConfig{{Rule: "real", Count: 2}},
+	}
+	assert.Error(t, config.Validate(), "the corpus is not loaded yet")
+
+	require.NoError(t, LoadCorpus(config, dir))
+	assert.Equal(t, []map[string]string{
+		{"subject": `Build {{ "{{" }} red }}`, "from": "ci@example.com", "to": "Dev <dev@example.com>", "body": "Broken", "date": "2024-12-02T10:00:00Z"},
+		{"subject": "Build green", "from": "ci@example.com", "body": "Fixed"},
+	}, config.Rules["real"].Variations)
+
+	emails, err := NewMailGenerator(config).Generate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 2)
+	assert.Equal(t, "Build {{ red }}", emails[0].Subject)
+	assert.Equal(t, "Dev <dev@example.com>", emails[0].To)
+	assert.Equal(t, 2, emails[0].InternalDate.Day())
+	assert.Equal(t, "", emails[1].To)
+	assert.True(t, emails[1].InternalDate.IsZero())
+}
+
+func TestLoadCorpusMissingFile(t *testing.T) {
+	config := &types.TemplateConfig{
+		Rules: map[string]types.RuleConfig{"real": {Template: "replay", Corpus: "missing.jsonl"}},
+	}
+	assert.Error(t, LoadCorpus(config, t.TempDir()))
+}
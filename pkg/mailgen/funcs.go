@@ -17,6 +17,7 @@ var (
 func builtinFuncs() map[string]interface{} {
 	return map[string]interface{}{
 		"pickRandom": pickRandom,
+		"randomDate": randomDate,
 	}
 }
 
@@ -61,3 +62,21 @@ func pickRandom(items interface{}) (interface{}, error) {
 
 	return item.Interface(), nil
 }
+
+// randomDate returns a random time between start and end (dates or RFC3339
+// times) in RFC3339 format, e.g. for internal_date.
+func randomDate(start, end string) (string, error) {
+	from, err := parseInternalDate(start)
+	if err != nil {
+		return "", err
+	}
+	to, err := parseInternalDate(end)
+	if err != nil {
+		return "", err
+	}
+	if !to.After(from) {
+		return "", fmt.Errorf("randomDate end %s is not after start %s", end, start)
+	}
+	offset := time.Duration(rnd.Int63n(int64(to.Sub(from))))
+	return from.Add(offset).Format(time.RFC3339), nil
+}
@@ -0,0 +1,293 @@
+package mailgen
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/types"
+)
+
+// LearnOptions configures Learn.
+type LearnOptions struct {
+	// MaxVariations caps the example messages kept per template
+	MaxVariations int
+	// MinClusterSize is the number of messages a sender/subject pattern needs
+	// to get its own template. Smaller clusters are merged into one template
+	// per sender, and senders still below the size into an "other" template.
+	MinClusterSize int
+	// BodyChars truncates the example bodies, 0 leaves bodies out
+	BodyChars int
+}
+
+var (
+	replyPrefixPattern = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw)\s*:\s*)+`)
+	numberPattern      = regexp.MustCompile(`\d+`)
+)
+
+type cluster struct {
+	sender   string // lowercased sender address, "" for the catch-all
+	pattern  string // subject with numbers replaced by "#", "" for any subject
+	messages []*dsl.EmailMessage
+}
+
+// Learn clusters messages by sender and subject pattern and returns a
+// mailgen configuration with one template and rule per cluster, example
+// messages as variations and generate counts matching the observed
+// frequencies. The result is a skeleton to edit, not a faithful model.
+func Learn(messages []*dsl.EmailMessage, opts LearnOptions) *types.TemplateConfig {
+	if opts.MaxVariations <= 0 {
+		opts.MaxVariations = 5
+	}
+
+	clusters := clusterMessages(messages, opts.MinClusterSize)
+
+	config := &types.TemplateConfig{
+		Variables: map[string]interface{}{},
+		Templates: map[string]types.EmailTemplate{},
+		Rules:     map[string]types.RuleConfig{},
+	}
+	for _, c := range clusters {
+		name := clusterName(c)
+		for i := 2; ; i++ {
+			if _, ok := config.Templates[name]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s-%d", clusterName(c), i)
+		}
+
+		template, variations := learnTemplate(c, opts)
+		config.Templates[name] = template
+		config.Rules[name] = types.RuleConfig{Template: name, Variations: variations}
+		config.Generate = append(config.Generate, types.GenerateConfig{Rule: name, Count: len(c.messages)})
+	}
+	return config
+}
+
+func clusterMessages(messages []*dsl.EmailMessage, minSize int) []*cluster {
+	var ordered []*cluster
+	byKey := map[string]*cluster{}
+	add := func(sender, pattern string, msgs ...*dsl.EmailMessage) {
+		key := sender + "\x00" + strings.ToLower(pattern)
+		c, ok := byKey[key]
+		if !ok {
+			c = &cluster{sender: sender, pattern: pattern}
+			byKey[key] = c
+			ordered = append(ordered, c)
+		}
+		c.messages = append(c.messages, msgs...)
+	}
+
+	for _, msg := range messages {
+		if msg.Envelope == nil {
+			continue
+		}
+		add(senderAddress(msg), subjectPattern(msg.Envelope.Subject), msg)
+	}
+
+	// Merge small clusters per sender, then small senders into "other"
+	for _, merge := range []func(c *cluster) (string, string){
+		func(c *cluster) (string, string) { return c.sender, "" },
+		func(c *cluster) (string, string) { return "", "" },
+	} {
+		clusters := ordered
+		ordered = nil
+		byKey = map[string]*cluster{}
+		var small []*cluster
+		for _, c := range clusters {
+			if len(c.messages) < minSize {
+				small = append(small, c)
+				continue
+			}
+			add(c.sender, c.pattern, c.messages...)
+		}
+		for _, c := range small {
+			sender, pattern := merge(c)
+			add(sender, pattern, c.messages...)
+		}
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return len(ordered[i].messages) > len(ordered[j].messages)
+	})
+	return ordered
+}
+
+func senderAddress(msg *dsl.EmailMessage) string {
+	if len(msg.Envelope.From) == 0 {
+		return ""
+	}
+	return strings.ToLower(msg.Envelope.From[0].Address)
+}
+
+// subjectPattern strips reply prefixes and replaces numbers with "#", so
+// "Re: Invoice 1234" and "Invoice 1235" share the pattern "Invoice #".
+func subjectPattern(subject string) string {
+	subject = replyPrefixPattern.ReplaceAllString(subject, "")
+	return strings.TrimSpace(numberPattern.ReplaceAllString(subject, "#"))
+}
+
+func clusterName(c *cluster) string {
+	if c.sender == "" {
+		return "other"
+	}
+	local, domain, _ := strings.Cut(c.sender, "@")
+	parts := []string{local, strings.Split(domain, ".")[0]}
+	if c.pattern != "" {
+		words := strings.Fields(strings.ReplaceAll(c.pattern, "#", ""))
+		if len(words) > 3 {
+			words = words[:3]
+		}
+		parts = append(parts, words...)
+	}
+	name := slug(strings.Join(parts, "-"))
+	if name == "" {
+		return "other"
+	}
+	return name
+}
+
+// slug turns s into a lowercase template name such as "news-example-weekly".
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(b.String(), "-")
+	if len(name) > 60 {
+		name = strings.TrimSuffix(name[:60], "-")
+	}
+	return name
+}
+
+// learnTemplate builds the template of a cluster. Values shared by every
+// message are written into the template, the others become variation keys
+// filled from up to opts.MaxVariations example messages.
+func learnTemplate(c *cluster, opts LearnOptions) (types.EmailTemplate, []map[string]string) {
+	examples := c.messages
+	if len(examples) > opts.MaxVariations {
+		examples = sampleMessages(examples, opts.MaxVariations)
+	}
+
+	var template types.EmailTemplate
+	variations := make([]map[string]string, len(examples))
+	for i := range variations {
+		variations[i] = map[string]string{}
+	}
+
+	// field sets a template field to the shared value, or to a variation key
+	field := func(key string, value func(*dsl.EmailMessage) string) string {
+		values := make([]string, len(examples))
+		shared, empty := true, true
+		for i, msg := range examples {
+			values[i] = value(msg)
+			shared = shared && values[i] == values[0]
+			empty = empty && values[i] == ""
+		}
+		switch {
+		case empty:
+			return ""
+		case shared:
+			return templateLiteral(values[0])
+		}
+		for i, v := range values {
+			if v == "" {
+				// mailgen rejects empty variation values
+				v = " "
+			}
+			variations[i][key] = templateLiteral(v)
+		}
+		return "{{ ." + key + " }}"
+	}
+
+	template.Subject = field("subject", func(msg *dsl.EmailMessage) string { return msg.Envelope.Subject })
+	template.From = field("from", func(msg *dsl.EmailMessage) string { return formatAddresses(msg.Envelope.From) })
+	template.To = field("to", func(msg *dsl.EmailMessage) string { return formatAddresses(msg.Envelope.To) })
+	template.Cc = field("cc", func(msg *dsl.EmailMessage) string { return formatAddresses(msg.Envelope.Cc) })
+	if opts.BodyChars > 0 {
+		template.Body = field("body", func(msg *dsl.EmailMessage) string { return bodyExcerpt(msg, opts.BodyChars) })
+	}
+	if template.Body == "" {
+		template.Body = "..."
+	}
+
+	if len(variations[0]) == 0 {
+		// Every example is the same, one empty variation keeps the rule valid
+		variations = variations[:1]
+	}
+	return template, variations
+}
+
+// sampleMessages picks n messages spread evenly over msgs.
+func sampleMessages(msgs []*dsl.EmailMessage, n int) []*dsl.EmailMessage {
+	ret := make([]*dsl.EmailMessage, n)
+	for i := range ret {
+		ret[i] = msgs[i*len(msgs)/n]
+	}
+	return ret
+}
+
+func formatAddresses(addrs []dsl.EmailAddress) string {
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if addr.Name != "" {
+			parts[i] = fmt.Sprintf("%s <%s>", addr.Name, addr.Address)
+		} else {
+			parts[i] = addr.Address
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// bodyExcerpt returns the first text/plain part (or any text part) of msg,
+// truncated to n characters.
+func bodyExcerpt(msg *dsl.EmailMessage, n int) string {
+	var plain, other string
+	var walk func(parts []dsl.MimePart)
+	walk = func(parts []dsl.MimePart) {
+		for _, part := range parts {
+			walk(part.Children)
+			// Local messages carry the full media type in Type
+			mediaType := strings.ToLower(part.Type)
+			if part.Subtype != "" {
+				mediaType += "/" + strings.ToLower(part.Subtype)
+			}
+			if !strings.HasPrefix(mediaType, "text/") || part.Content == "" {
+				continue
+			}
+			if plain == "" && mediaType == "text/plain" {
+				plain = part.Content
+			} else if other == "" {
+				other = part.Content
+			}
+		}
+	}
+	walk(msg.MimeParts)
+
+	body := plain
+	if body == "" {
+		body = other
+	}
+	body = strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+	if runes := []rune(body); len(runes) > n {
+		body = strings.TrimSpace(string(runes[:n])) + "..."
+	}
+	return body
+}
+
+// templateLiteral escapes s for use in a mailgen template, which renders
+// both templates and variation values.
+func templateLiteral(s string) string {
+	return strings.ReplaceAll(s, "{{", `{{ "{{" }}`)
+}
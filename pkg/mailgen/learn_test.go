@@ -0,0 +1,76 @@
+package mailgen
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+func learnMessage(from, subject, body string) *dsl.EmailMessage {
+	return &dsl.EmailMessage{
+		Envelope: &dsl.EmailEnvelope{
+			Subject: subject,
+			From:    []dsl.EmailAddress{{Address: from}},
+			To:      []dsl.EmailAddress{{Name: "Me", Address: "me@example.com"}},
+		},
+		MimeParts: []dsl.MimePart{
+			{Type: "text/html", Content: "<p>" + body + "</p>"},
+			{Type: "text/plain", Content: body + "\r\n"},
+		},
+	}
+}
+
+func TestLearn(t *testing.T) {
+	var messages []*dsl.EmailMessage
+	for i := 1; i <= 4; i++ {
+		messages = append(messages, learnMessage("billing@shop.com", fmt.Sprintf("Invoice %d", 100+i), fmt.Sprintf("Amount: {{ %d }} EUR", i)))
+	}
+	messages = append(messages,
+		learnMessage("billing@shop.com", "Re: Invoice 7", "Thanks"),
+		learnMessage("billing@shop.com", "Your account", "Welcome"),
+		learnMessage("bob@example.org", "Lunch?", "Noon works"),
+	)
+
+	config := Learn(messages, LearnOptions{MaxVariations: 3, MinClusterSize: 2, BodyChars: 12})
+	require.NoError(t, config.Validate())
+
+	require.Len(t, config.Generate, 2)
+	assert.Equal(t, "billing-shop-invoice", config.Generate[0].Rule)
+	assert.Equal(t, 5, config.Generate[0].Count)
+	assert.Equal(t, "other", config.Generate[1].Rule)
+	assert.Equal(t, 2, config.Generate[1].Count)
+
+	invoice := config.Templates["billing-shop-invoice"]
+	assert.Equal(t, "{{ .subject }}", invoice.Subject)
+	assert.Equal(t, "billing@shop.com", invoice.From)
+	assert.Equal(t, "Me <me@example.com>", invoice.To)
+	assert.Equal(t, "{{ .body }}", invoice.Body)
+
+	variations := config.Rules["billing-shop-invoice"].Variations
+	require.Len(t, variations, 3)
+	assert.Equal(t, "Invoice 101", variations[0]["subject"])
+	assert.Equal(t, `Amount: {{ "{{" }} 1...`, variations[0]["body"])
+
+	other := config.Templates["other"]
+	assert.Equal(t, "{{ .from }}", other.From)
+	assert.Equal(t, []map[string]string{
+		{"subject": "Your account", "from": "billing@shop.com", "body": "Welcome"},
+		{"subject": "Lunch?", "from": "bob@example.org", "body": "Noon works"},
+	}, config.Rules["other"].Variations)
+
+	emails, err := NewMailGenerator(config).Generate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 7)
+	assert.Equal(t, "Amount: {{ 1...", emails[0].Body)
+	assert.Equal(t, "Lunch?", emails[6].Subject)
+}
+
+func TestSubjectPattern(t *testing.T) {
+	assert.Equal(t, "Invoice #", subjectPattern("RE: Fwd: Invoice 1234"))
+	assert.Equal(t, "Build # of #.#", subjectPattern("Build 17 of 2.3"))
+}
@@ -3,7 +3,10 @@ package mailgen
 import (
 	"bytes"
 	"context"
+	"strings"
 	"text/template"
+	"time"
+	"unicode"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/go-go-golems/smailnail/pkg/types"
@@ -166,6 +169,30 @@ func (g *MailGenerator) processEmailTemplate(emailTemplate types.EmailTemplate,
 		email.ReplyTo = replyTo
 	}
 
+	if emailTemplate.MessageID != "" {
+		messageID, err := g.processTemplate("message_id", emailTemplate.MessageID, ctx)
+		if err != nil {
+			return nil, err
+		}
+		email.MessageID = strings.TrimSpace(messageID)
+	}
+
+	if emailTemplate.InReplyTo != "" {
+		inReplyTo, err := g.processTemplate("in_reply_to", emailTemplate.InReplyTo, ctx)
+		if err != nil {
+			return nil, err
+		}
+		email.InReplyTo = strings.TrimSpace(inReplyTo)
+	}
+
+	if emailTemplate.References != "" {
+		references, err := g.processTemplate("references", emailTemplate.References, ctx)
+		if err != nil {
+			return nil, err
+		}
+		email.References = strings.TrimSpace(references)
+	}
+
 	// Process body
 	body, err := g.processTemplate("body", emailTemplate.Body, ctx)
 	if err != nil {
@@ -173,9 +200,64 @@ func (g *MailGenerator) processEmailTemplate(emailTemplate types.EmailTemplate,
 	}
 	email.Body = body
 
+	// Variation values named flags and internal_date override the template
+	flags, ok := ctx["flags"].(string)
+	if !ok {
+		flags = defaultFlags
+		if emailTemplate.Flags != "" {
+			flags, err = g.processTemplate("flags", emailTemplate.Flags, ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	email.Flags = parseFlags(flags)
+
+	internalDate, ok := ctx["internal_date"].(string)
+	if !ok && emailTemplate.InternalDate != "" {
+		internalDate, err = g.processTemplate("internal_date", emailTemplate.InternalDate, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if strings.TrimSpace(internalDate) != "" {
+		email.InternalDate, err = parseInternalDate(internalDate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return email, nil
 }
 
+// defaultFlags marks generated messages as read unless the template sets
+// flags.
+const defaultFlags = `\Seen`
+
+// parseFlags splits a comma or space separated flag list.
+func parseFlags(flags string) []string {
+	return strings.FieldsFunc(flags, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+var internalDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+}
+
+func parseInternalDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, format := range internalDateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid internal_date %q (use RFC3339, \"2006-01-02 15:04:05\" or \"2006-01-02\")", value)
+}
+
 // processTemplate processes a template string with the given context
 func (g *MailGenerator) processTemplate(name, tmpl string, ctx map[string]interface{}) (string, error) {
 	// Parse the template
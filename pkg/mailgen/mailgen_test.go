@@ -0,0 +1,91 @@
+package mailgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/go-go-golems/smailnail/pkg/types"
+)
+
+func TestGenerateFlagsAndInternalDate(t *testing.T) {
+	config := &types.TemplateConfig{
+		Templates: map[string]types.EmailTemplate{
+			"plain": {Subject: "hi", From: "a@example.com", Body: "body"},
+			"state": {
+				Subject:      "hi",
+				From:         "a@example.com",
+				Body:         "body",
+				Flags:        `{{ if eq (mod .index 2) 0 }}\Seen, \Flagged{{ end }}`,
+				InternalDate: `{{ randomDate "2025-01-01" "2025-01-31" }}`,
+			},
+		},
+		Rules: map[string]types.RuleConfig{
+			"plain": {Template: "plain", Variations: []map[string]string{{}}},
+			"state": {Template: "state", Variations: []map[string]string{{}}},
+			"override": {Template: "state", Variations: []map[string]string{
+				{"flags": "$Work", "internal_date": "2024-06-01 08:30:00"},
+			}},
+		},
+		Generate: []types.GenerateConfig{
+			{Rule: "plain", Count: 1},
+			{Rule: "state", Count: 2},
+			{Rule: "override", Count: 1},
+		},
+	}
+
+	emails, err := NewMailGenerator(config).Generate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 4)
+
+	assert.Equal(t, []string{`\Seen`}, emails[0].Flags, "default")
+	assert.True(t, emails[0].InternalDate.IsZero())
+
+	assert.Equal(t, []string{`\Seen`, `\Flagged`}, emails[1].Flags)
+	assert.Empty(t, emails[2].Flags, "an empty flags result leaves the message unread")
+	for _, email := range emails[1:3] {
+		assert.False(t, email.InternalDate.Before(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.True(t, email.InternalDate.Before(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)))
+	}
+
+	assert.Equal(t, []string{"$Work"}, emails[3].Flags)
+	assert.Equal(t, time.Date(2024, 6, 1, 8, 30, 0, 0, time.UTC), emails[3].InternalDate)
+
+	config.Templates["plain"] = types.EmailTemplate{Subject: "hi", From: "a@example.com", Body: "body", InternalDate: "last week"}
+	_, err = NewMailGenerator(config).Generate(context.Background())
+	assert.ErrorContains(t, err, `invalid internal_date "last week"`)
+}
+
+func TestGenerateThreadHeaders(t *testing.T) {
+	config := &types.TemplateConfig{
+		Templates: map[string]types.EmailTemplate{
+			"thread": {
+				Subject:    `{{ if gt .index 0 }}Re: {{ end }}Plan`,
+				From:       "a@example.com",
+				Body:       "body",
+				MessageID:  "<plan-{{ .index }}@example.com>",
+				InReplyTo:  `{{ if gt .index 0 }}<plan-{{ sub .index 1 }}@example.com>{{ end }}`,
+				References: `{{ range $i := until .index }}<plan-{{ $i }}@example.com> {{ end }}`,
+			},
+		},
+		Rules: map[string]types.RuleConfig{
+			"thread": {Template: "thread", Variations: []map[string]string{{}}},
+		},
+		Generate: []types.GenerateConfig{{Rule: "thread", Count: 3}},
+	}
+
+	emails, err := NewMailGenerator(config).Generate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, emails, 3)
+
+	assert.Equal(t, "<plan-0@example.com>", emails[0].MessageID)
+	assert.Empty(t, emails[0].InReplyTo)
+	assert.Empty(t, emails[0].References)
+
+	assert.Equal(t, "<plan-2@example.com>", emails[2].MessageID)
+	assert.Equal(t, "<plan-1@example.com>", emails[2].InReplyTo)
+	assert.Equal(t, "<plan-0@example.com> <plan-1@example.com>", emails[2].References)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -26,6 +27,8 @@ type IMAPOptions struct {
 	Insecure bool
 	Username string
 	Password string
+	// WrapConn, if set, wraps the network connection, e.g. to trace it
+	WrapConn func(net.Conn) net.Conn
 }
 
 // MailboxInfo is a lightweight descriptor returned by LIST.
@@ -92,7 +95,7 @@ type IMAPClient struct {
 
 // Connect opens an IMAP connection and logs in.
 func Connect(_ context.Context, opts IMAPOptions) (*IMAPClient, error) {
-	addr := fmt.Sprintf("%s:%d", opts.Host, opts.Port)
+	addr := net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))
 	log.Debug().Str("addr", addr).Msg("connecting to IMAP")
 
 	var (
@@ -100,14 +103,26 @@ func Connect(_ context.Context, opts IMAPOptions) (*IMAPClient, error) {
 		err error
 	)
 
-	if opts.TLS {
+	switch {
+	case opts.WrapConn != nil:
+		var conn net.Conn
+		if opts.TLS {
+			// #nosec G402 -- explicit caller-controlled option for local/self-signed test fixtures.
+			conn, err = tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: opts.Insecure})
+		} else {
+			conn, err = net.Dial("tcp", addr)
+		}
+		if err == nil {
+			c = imapclient.New(opts.WrapConn(conn), nil)
+		}
+	case opts.TLS:
 		c, err = imapclient.DialTLS(addr, &imapclient.Options{
 			TLSConfig: &tls.Config{
 				// #nosec G402 -- explicit caller-controlled option for local/self-signed test fixtures.
 				InsecureSkipVerify: opts.Insecure,
 			},
 		})
-	} else {
+	default:
 		c, err = imapclient.DialInsecure(addr, nil)
 	}
 	if err != nil {
@@ -2,6 +2,7 @@ package mailruntime
 
 import (
 	"bufio"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net"
@@ -18,6 +19,9 @@ type SieveOptions struct {
 	Port     int
 	Username string
 	Password string
+	// StartTLS upgrades the connection before authenticating.
+	StartTLS bool
+	Insecure bool
 }
 
 // ScriptInfo describes a Sieve script on the server.
@@ -63,19 +67,53 @@ func ConnectSieve(opts SieveOptions) (*SieveClient, error) {
 		return nil, errors.Wrap(err, "reading greeting")
 	}
 
+	if opts.StartTLS {
+		if err := sc.startTLS(opts.Host, opts.Insecure); err != nil {
+			_ = sc.conn.Close()
+			return nil, err
+		}
+	}
+
 	authStr := base64.StdEncoding.EncodeToString([]byte("\x00" + opts.Username + "\x00" + opts.Password))
 	if err := sc.sendLine(fmt.Sprintf("AUTHENTICATE \"PLAIN\" %q", authStr)); err != nil {
-		_ = conn.Close()
+		_ = sc.conn.Close()
 		return nil, errors.Wrap(err, "send AUTHENTICATE")
 	}
 	if err := sc.expectOK(); err != nil {
-		_ = conn.Close()
+		_ = sc.conn.Close()
 		return nil, &MailError{Name: "AuthError", Message: err.Error(), Source: "sieve"}
 	}
 
 	return sc, nil
 }
 
+// startTLS upgrades the connection and reads the capabilities the server
+// sends again afterwards (RFC 5804 section 2.2).
+func (sc *SieveClient) startTLS(host string, insecure bool) error {
+	if !sc.caps.StartTLS {
+		return errors.New("ManageSieve server does not support STARTTLS")
+	}
+	if err := sc.sendLine("STARTTLS"); err != nil {
+		return errors.Wrap(err, "send STARTTLS")
+	}
+	if err := sc.expectOK(); err != nil {
+		return errors.Wrap(err, "STARTTLS")
+	}
+
+	// #nosec G402 -- InsecureSkipVerify is only set from an explicit user-controlled --insecure flag.
+	tlsConn := tls.Client(sc.conn, &tls.Config{ServerName: host, InsecureSkipVerify: insecure})
+	if err := tlsConn.Handshake(); err != nil {
+		return errors.Wrap(err, "TLS handshake")
+	}
+	sc.conn = tlsConn
+	sc.r = bufio.NewReader(tlsConn)
+	sc.caps = SieveCapabilities{}
+	if err := sc.readCapabilities(); err != nil {
+		return errors.Wrap(err, "reading capabilities after STARTTLS")
+	}
+	return nil
+}
+
 func (sc *SieveClient) Capabilities() SieveCapabilities {
 	return sc.caps
 }
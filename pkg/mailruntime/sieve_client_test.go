@@ -0,0 +1,131 @@
+package mailruntime
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate returns a self-signed certificate for 127.0.0.1.
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fakeSieveServer accepts one connection and runs serve on it. The error of
+// serve is sent on the returned channel.
+func fakeSieveServer(t *testing.T, serve func(net.Conn) error) (SieveOptions, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		done <- serve(conn)
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return SieveOptions{Host: "127.0.0.1", Port: addr.Port, Username: "user", Password: "secret"}, done
+}
+
+// expectLine reads a command line and checks its prefix.
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", prefix, err)
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("expected %s, got %q", prefix, line)
+	}
+	return nil
+}
+
+func TestConnectSieveStartTLS(t *testing.T) {
+	cert := testCertificate(t)
+	opts, done := fakeSieveServer(t, func(conn net.Conn) error {
+		_, _ = fmt.Fprint(conn, "\"IMPLEMENTATION\" \"fake\"\r\n\"SASL\" \"\"\r\n\"STARTTLS\"\r\nOK\r\n")
+		r := bufio.NewReader(conn)
+		if err := expectLine(r, "STARTTLS"); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprint(conn, "OK \"Begin TLS negotiation now\"\r\n")
+
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		// The capabilities are sent again, now offering authentication
+		_, _ = fmt.Fprint(tlsConn, "\"IMPLEMENTATION\" \"fake\"\r\n\"SASL\" \"PLAIN\"\r\n\"SIEVE\" \"fileinto vacation\"\r\nOK\r\n")
+		r = bufio.NewReader(tlsConn)
+		if err := expectLine(r, "AUTHENTICATE \"PLAIN\""); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprint(tlsConn, "OK\r\n")
+		if err := expectLine(r, "LOGOUT"); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprint(tlsConn, "OK\r\n")
+		return nil
+	})
+	opts.StartTLS = true
+	opts.Insecure = true
+
+	client, err := ConnectSieve(opts)
+	require.NoError(t, err)
+	caps := client.Capabilities()
+	assert.False(t, caps.StartTLS, "the capabilities are those sent after the upgrade")
+	assert.Equal(t, []string{"PLAIN"}, caps.SASL)
+	assert.Equal(t, []string{"fileinto", "vacation"}, caps.Sieve)
+	_, ok := client.conn.(*tls.Conn)
+	assert.True(t, ok)
+
+	require.NoError(t, client.Logout())
+	require.NoError(t, <-done)
+}
+
+func TestConnectSieveStartTLSNotOffered(t *testing.T) {
+	opts, done := fakeSieveServer(t, func(conn net.Conn) error {
+		_, _ = fmt.Fprint(conn, "\"IMPLEMENTATION\" \"fake\"\r\n\"SASL\" \"PLAIN\"\r\nOK\r\n")
+		// The client must hang up rather than authenticate in clear text
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err == nil {
+			return fmt.Errorf("unexpected command %q", line)
+		}
+		return nil
+	})
+	opts.StartTLS = true
+
+	_, err := ConnectSieve(opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not support STARTTLS")
+	require.NoError(t, <-done)
+}
@@ -20,3 +20,19 @@ func SetSingleAddress(header *mail.Header, fieldName string, value string) error
 	header.SetAddressList(fieldName, []*mail.Address{address})
 	return nil
 }
+
+// SetAddressList parses a comma-separated RFC 5322 address list such as
+// "Alice <a@example.com>, b@example.com" and writes it as an address header.
+func SetAddressList(header *mail.Header, fieldName string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	addresses, err := mail.ParseAddressList(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s address list %q: %w", fieldName, value, err)
+	}
+
+	header.SetAddressList(fieldName, addresses)
+	return nil
+}
@@ -37,3 +37,35 @@ func TestSetSingleAddressRejectsInvalidAddress(t *testing.T) {
 		t.Fatalf("expected invalid address error")
 	}
 }
+
+func TestSetAddressListParsesMultipleAddresses(t *testing.T) {
+	header := mail.Header{}
+
+	err := SetAddressList(&header, "To", `Alice <alice@example.com>, "Doe, Bob" <bob@example.com>, carol@example.com`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	addresses, err := header.AddressList("To")
+	if err != nil {
+		t.Fatalf("expected to parse serialized header, got %v", err)
+	}
+	if len(addresses) != 3 {
+		t.Fatalf("expected 3 addresses, got %d", len(addresses))
+	}
+	if addresses[1].Name != "Doe, Bob" || addresses[1].Address != "bob@example.com" {
+		t.Fatalf("expected Doe, Bob <bob@example.com>, got %q <%s>", addresses[1].Name, addresses[1].Address)
+	}
+	if addresses[2].Name != "" || addresses[2].Address != "carol@example.com" {
+		t.Fatalf("expected bare carol@example.com, got %q <%s>", addresses[2].Name, addresses[2].Address)
+	}
+}
+
+func TestSetAddressListRejectsInvalidList(t *testing.T) {
+	header := mail.Header{}
+
+	err := SetAddressList(&header, "Cc", "alice@example.com, Bob <bob@example.com")
+	if err == nil {
+		t.Fatalf("expected invalid address list error")
+	}
+}
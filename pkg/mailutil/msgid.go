@@ -0,0 +1,59 @@
+package mailutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// ParseMsgIDs splits a list of message identifiers separated by whitespace
+// or commas, such as "<a@example.com> <b@example.com>", and strips the
+// angle brackets.
+func ParseMsgIDs(value string) []string {
+	var ids []string
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}) {
+		if id := strings.TrimSuffix(strings.TrimPrefix(field, "<"), ">"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetThreadHeaders writes the Message-ID, In-Reply-To and References fields.
+// Identifiers may be given with or without angle brackets; empty values are
+// skipped. A reply without references references the message it replies to,
+// as mail clients do.
+func SetThreadHeaders(header *mail.Header, messageID, inReplyTo string, references []string) error {
+	messageIDs := ParseMsgIDs(messageID)
+	inReplyToIDs := ParseMsgIDs(inReplyTo)
+	var referenceIDs []string
+	for _, reference := range references {
+		referenceIDs = append(referenceIDs, ParseMsgIDs(reference)...)
+	}
+
+	if len(messageIDs) > 1 {
+		return fmt.Errorf("invalid Message-ID %q: expected a single identifier", messageID)
+	}
+	for _, id := range append(append(append([]string{}, messageIDs...), inReplyToIDs...), referenceIDs...) {
+		if !strings.Contains(id, "@") {
+			return fmt.Errorf("invalid message identifier %q: expected <id@domain>", id)
+		}
+	}
+
+	if len(referenceIDs) == 0 {
+		referenceIDs = inReplyToIDs
+	}
+	if len(messageIDs) == 1 {
+		header.SetMessageID(messageIDs[0])
+	}
+	if len(inReplyToIDs) > 0 {
+		header.SetMsgIDList("In-Reply-To", inReplyToIDs)
+	}
+	if len(referenceIDs) > 0 {
+		header.SetMsgIDList("References", referenceIDs)
+	}
+	return nil
+}
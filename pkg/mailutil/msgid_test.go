@@ -0,0 +1,61 @@
+package mailutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestParseMsgIDs(t *testing.T) {
+	got := ParseMsgIDs("<a@example.com> b@example.com,<c@example.com>")
+	want := []string{"a@example.com", "b@example.com", "c@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSetThreadHeadersBuildsReplyChain(t *testing.T) {
+	header := mail.Header{}
+
+	err := SetThreadHeaders(&header, "<c@example.com>", "b@example.com", []string{"<a@example.com> <b@example.com>"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := header.Get("Message-Id"); got != "<c@example.com>" {
+		t.Fatalf("expected Message-Id <c@example.com>, got %q", got)
+	}
+	if got := header.Get("In-Reply-To"); got != "<b@example.com>" {
+		t.Fatalf("expected In-Reply-To <b@example.com>, got %q", got)
+	}
+	references, err := header.MsgIDList("References")
+	if err != nil {
+		t.Fatalf("expected to parse References, got %v", err)
+	}
+	if !reflect.DeepEqual(references, []string{"a@example.com", "b@example.com"}) {
+		t.Fatalf("unexpected References %v", references)
+	}
+}
+
+func TestSetThreadHeadersDefaultsReferencesToInReplyTo(t *testing.T) {
+	header := mail.Header{}
+
+	if err := SetThreadHeaders(&header, "", "<a@example.com>", nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := header.Get("References"); got != "<a@example.com>" {
+		t.Fatalf("expected References <a@example.com>, got %q", got)
+	}
+	if got := header.Get("Message-Id"); got != "" {
+		t.Fatalf("expected no Message-Id, got %q", got)
+	}
+}
+
+func TestSetThreadHeadersRejectsInvalidIdentifier(t *testing.T) {
+	header := mail.Header{}
+
+	if err := SetThreadHeaders(&header, "", "not-an-id", nil); err == nil {
+		t.Fatalf("expected invalid identifier error")
+	}
+}
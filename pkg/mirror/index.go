@@ -0,0 +1,108 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSearchLimit is the number of hits Search returns when no limit is set.
+const DefaultSearchLimit = 20
+
+// searchRankWeights are the bm25 weights of the messages_fts columns, in
+// table order: matches in the subject and senders rank above body matches,
+// and the account and mailbox names are not ranked.
+const searchRankWeights = "0.0, 0.0, 10.0, 5.0, 3.0, 3.0, 1.0, 0.5, 1.0, 0.5"
+
+// IndexReport describes a rebuild of the full-text index.
+type IndexReport struct {
+	SQLitePath  string `json:"sqlitePath"`
+	RowsIndexed int    `json:"rowsIndexed"`
+}
+
+// SearchOptions selects the messages a full-text search ranks.
+type SearchOptions struct {
+	// Query is an FTS5 query, e.g. `invoice AND subject:march` or `"exact phrase"`
+	Query          string
+	AccountKey     string
+	Mailbox        string
+	Limit          int
+	IncludeDeleted bool
+}
+
+// SearchHit is a message matching a full-text search. Lower ranks are
+// better matches.
+type SearchHit struct {
+	ID          int64   `db:"id" json:"id"`
+	AccountKey  string  `db:"account_key" json:"accountKey"`
+	MailboxName string  `db:"mailbox_name" json:"mailboxName"`
+	UIDValidity uint32  `db:"uidvalidity" json:"uidvalidity"`
+	UID         uint32  `db:"uid" json:"uid"`
+	MessageID   string  `db:"message_id" json:"messageId"`
+	SentDate    string  `db:"sent_date" json:"sentDate"`
+	Subject     string  `db:"subject" json:"subject"`
+	FromSummary string  `db:"from_summary" json:"fromSummary"`
+	Rank        float64 `db:"rank" json:"rank"`
+	Snippet     string  `db:"snippet" json:"snippet"`
+}
+
+// RebuildIndex rebuilds the full-text index of the mirrored headers and
+// bodies from the messages table. The store must be bootstrapped.
+func (s *Store) RebuildIndex(ctx context.Context) (*IndexReport, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("store is not open")
+	}
+	rows, err := rebuildMessagesFTS(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexReport{
+		SQLitePath:  s.path,
+		RowsIndexed: rows,
+	}, nil
+}
+
+// Search runs a full-text query against the index and returns the matching
+// messages, best match first.
+func (s *Store) Search(ctx context.Context, opts SearchOptions) ([]SearchHit, error) {
+	if s == nil || s.db == nil {
+		return nil, fmt.Errorf("store is not open")
+	}
+	if strings.TrimSpace(opts.Query) == "" {
+		return nil, fmt.Errorf("search query is empty")
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	query := `SELECT
+		m.id, m.account_key, m.mailbox_name, m.uidvalidity, m.uid, m.message_id, m.sent_date, m.subject, m.from_summary,
+		bm25(messages_fts, ` + searchRankWeights + `) AS rank,
+		snippet(messages_fts, -1, '[', ']', '...', 12) AS snippet
+	FROM messages_fts
+	JOIN messages m ON m.id = messages_fts.rowid
+	WHERE messages_fts MATCH ?`
+	args := []interface{}{opts.Query}
+	if opts.AccountKey != "" {
+		query += ` AND m.account_key = ?`
+		args = append(args, opts.AccountKey)
+	}
+	if opts.Mailbox != "" {
+		query += ` AND m.mailbox_name = ?`
+		args = append(args, opts.Mailbox)
+	}
+	if !opts.IncludeDeleted {
+		query += ` AND m.remote_deleted = FALSE`
+	}
+	query += ` ORDER BY rank, m.id LIMIT ?`
+	args = append(args, limit)
+
+	hits := []SearchHit{}
+	if err := s.db.SelectContext(ctx, &hits, query, args...); err != nil {
+		return nil, errors.Wrap(err, "search messages_fts")
+	}
+	return hits, nil
+}
@@ -0,0 +1,133 @@
+package mirror
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreSearchRanksSubjectMatchesFirst(t *testing.T) {
+	store := openIndexTestStore(t)
+	insertIndexTestMessage(t, store, "INBOX", 1, "Lunch on Friday", "The invoice is attached.", `{"X-Mailer":["mutt"]}`)
+	insertIndexTestMessage(t, store, "INBOX", 2, "Invoice for March", "Please find it attached.", `{}`)
+	insertIndexTestMessage(t, store, "Archive", 3, "Old invoice", "Paid.", `{}`)
+
+	hits, err := store.Search(t.Context(), SearchOptions{Query: "invoice", Mailbox: "INBOX"})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 hits, got %+v", hits)
+	}
+	if hits[0].UID != 2 || hits[1].UID != 1 {
+		t.Fatalf("expected the subject match first, got %+v", hits)
+	}
+	if hits[0].Rank > hits[1].Rank {
+		t.Fatalf("expected ascending ranks, got %+v", hits)
+	}
+	if hits[1].Snippet == "" {
+		t.Fatalf("expected a snippet, got %+v", hits[1])
+	}
+
+	hits, err = store.Search(t.Context(), SearchOptions{Query: "mutt"})
+	if err != nil {
+		t.Fatalf("Search() error = %v", err)
+	}
+	if len(hits) != 1 || hits[0].UID != 1 {
+		t.Fatalf("expected headers to be indexed, got %+v", hits)
+	}
+
+	if _, err := store.Search(t.Context(), SearchOptions{Query: " "}); err == nil {
+		t.Fatal("expected an empty query to fail")
+	}
+}
+
+func TestStoreRebuildIndex(t *testing.T) {
+	store := openIndexTestStore(t)
+	insertIndexTestMessage(t, store, "INBOX", 1, "Invoice", "Body", `{}`)
+	if _, err := store.db.ExecContext(t.Context(), `DELETE FROM messages_fts`); err != nil {
+		t.Fatalf("clear messages_fts error = %v", err)
+	}
+
+	report, err := store.RebuildIndex(t.Context())
+	if err != nil {
+		t.Fatalf("RebuildIndex() error = %v", err)
+	}
+	if report.RowsIndexed != 1 {
+		t.Fatalf("expected 1 indexed row, got %+v", report)
+	}
+	if !ftsMatches(t, store.db, "invoice") {
+		t.Fatal("expected the rebuilt index to match")
+	}
+}
+
+func TestBootstrapRebuildsIndexWithoutHeaders(t *testing.T) {
+	store := openIndexTestStore(t)
+	insertIndexTestMessage(t, store, "INBOX", 1, "Invoice", "Body", `{"X-Mailer":["mutt"]}`)
+	ctx := t.Context()
+	if _, err := store.db.ExecContext(ctx, `DROP TABLE messages_fts`); err != nil {
+		t.Fatalf("drop messages_fts error = %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx, `CREATE VIRTUAL TABLE messages_fts USING fts5(
+		account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text
+	)`); err != nil {
+		t.Fatalf("create legacy messages_fts error = %v", err)
+	}
+
+	if _, err := store.Bootstrap(ctx, filepath.Join(t.TempDir(), "root")); err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	if !ftsMatches(t, store.db, "headers_json:mutt") {
+		t.Fatal("expected bootstrap to rebuild the index with headers")
+	}
+}
+
+func openIndexTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	store, err := OpenStore(filepath.Join(t.TempDir(), "mirror.sqlite"))
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.Bootstrap(t.Context(), filepath.Join(t.TempDir(), "root")); err != nil {
+		t.Fatalf("Bootstrap() error = %v", err)
+	}
+	return store
+}
+
+func insertIndexTestMessage(t *testing.T, store *Store, mailboxName string, uid uint32, subject string, body string, headersJSON string) {
+	t.Helper()
+
+	now := time.Now().UTC()
+	record := MessageRecord{
+		AccountKey:   "account",
+		MailboxName:  mailboxName,
+		UIDValidity:  1,
+		UID:          uid,
+		Subject:      subject,
+		FromSummary:  "Tester <test@example.com>",
+		FlagsJSON:    "[]",
+		HeadersJSON:  headersJSON,
+		PartsJSON:    "[]",
+		BodyText:     body,
+		SearchText:   subject + "\n" + body,
+		RawPath:      "raw/message.eml",
+		FirstSeenAt:  &now,
+		LastSyncedAt: &now,
+	}
+	tx, err := store.db.BeginTxx(t.Context(), nil)
+	if err != nil {
+		t.Fatalf("BeginTxx() error = %v", err)
+	}
+	rowID, err := upsertMessageRecord(t.Context(), tx, record)
+	if err != nil {
+		t.Fatalf("upsertMessageRecord() error = %v", err)
+	}
+	if err := upsertMessageFTS(t.Context(), tx, rowID, record); err != nil {
+		t.Fatalf("upsertMessageFTS() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+}
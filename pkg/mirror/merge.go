@@ -653,9 +653,9 @@ func rebuildMessagesFTS(ctx context.Context, db *sqlx.DB) (int, error) {
 		return 0, errors.Wrap(err, "clear messages_fts")
 	}
 	if _, err := tx.ExecContext(ctx, `INSERT INTO messages_fts (
-		rowid, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text
+		rowid, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text, headers_json
 	) SELECT
-		id, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text
+		id, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text, headers_json
 	  FROM messages`); err != nil {
 		return 0, errors.Wrap(err, "rebuild messages_fts")
 	}
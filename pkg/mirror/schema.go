@@ -143,6 +143,19 @@ func bootstrapSchema(ctx context.Context, db *sqlx.DB) (BootstrapReport, error)
 }
 
 func bootstrapFTS(ctx context.Context, db *sqlx.DB) (bool, string, error) {
+	// FTS5 tables cannot be altered: indexes created before headers were
+	// indexed are dropped and rebuilt from messages.
+	var columns []string
+	if err := db.SelectContext(ctx, &columns, `SELECT name FROM pragma_table_info('messages_fts')`); err != nil {
+		return false, "", errors.Wrap(err, "inspect messages_fts columns")
+	}
+	rebuild := len(columns) > 0 && !containsString(columns, "headers_json")
+	if rebuild {
+		if _, err := db.ExecContext(ctx, `DROP TABLE messages_fts`); err != nil {
+			return false, "", errors.Wrap(err, "drop outdated messages_fts")
+		}
+	}
+
 	_, err := db.ExecContext(ctx, `CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
 		account_key,
 		mailbox_name,
@@ -152,11 +165,17 @@ func bootstrapFTS(ctx context.Context, db *sqlx.DB) (bool, string, error) {
 		cc_summary,
 		body_text,
 		body_html,
-		search_text
+		search_text,
+		headers_json
 	)`)
 	if err != nil {
 		return false, "", fmt.Errorf("fts5 is required but unavailable: %w", err)
 	}
+	if rebuild {
+		if _, err := rebuildMessagesFTS(ctx, db); err != nil {
+			return false, "", err
+		}
+	}
 
 	if err := setMetadataValue(ctx, db, "fts5_status", "available"); err != nil {
 		return false, "", err
@@ -212,3 +231,12 @@ func isIgnorableMigrationError(err error) bool {
 
 	return strings.Contains(strings.ToLower(err.Error()), "duplicate column name")
 }
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
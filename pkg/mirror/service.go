@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net"
 	"path"
 	"sort"
 	"strings"
@@ -37,6 +38,8 @@ type SyncOptions struct {
 	StopOnError           bool
 	ResetMailboxState     bool
 	ReconcileFull         bool
+	// WrapConn is passed on to mailruntime.IMAPOptions
+	WrapConn func(net.Conn) net.Conn
 }
 
 type imapSession interface {
@@ -107,6 +110,7 @@ func (s *Service) Sync(ctx context.Context, opts SyncOptions) (*SyncReport, erro
 		Insecure: normalized.Insecure,
 		Username: normalized.Username,
 		Password: normalized.Password,
+		WrapConn: normalized.WrapConn,
 	})
 	if err != nil {
 		return nil, err
@@ -1112,8 +1116,8 @@ func upsertMessageFTS(ctx context.Context, tx *sqlx.Tx, rowID int64, record Mess
 	if _, err := tx.ExecContext(
 		ctx,
 		`INSERT INTO messages_fts (
-			rowid, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rowid, account_key, mailbox_name, subject, from_summary, to_summary, cc_summary, body_text, body_html, search_text, headers_json
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		rowID,
 		record.AccountKey,
 		record.MailboxName,
@@ -1124,6 +1128,7 @@ func upsertMessageFTS(ctx context.Context, tx *sqlx.Tx, rowID int64, record Mess
 		record.BodyText,
 		record.BodyHTML,
 		record.SearchText,
+		record.HeadersJSON,
 	); err != nil {
 		return errors.Wrap(err, "upsert mirrored messages_fts row")
 	}
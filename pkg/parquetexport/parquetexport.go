@@ -0,0 +1,288 @@
+// Package parquetexport adds the parquet format to the export action: the
+// metadata of the exported messages, and optionally their truncated bodies,
+// is written as one Parquet file with a stable schema, ready for DuckDB or
+// Spark. Importing the package registers the format.
+package parquetexport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/emersion/go-message/mail"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+const (
+	// Format is the export format name, export.format: parquet
+	Format = "parquet"
+	// File is the file an export writes, in the export directory or archive
+	File = "messages.parquet"
+
+	// SchemaVersion is stored in the file metadata under
+	// smailnail.schema_version. Columns are only ever added.
+	SchemaVersion = "1"
+
+	// batchSize is the number of rows buffered before they are written
+	batchSize = 1024
+)
+
+func init() {
+	if err := dsl.RegisterExportStore(Format, File, func(path string, config *dsl.ExportConfig) (dsl.ExportStore, error) {
+		return Create(path, config.BodyChars)
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// messageRow holds the values of a message, computed before any column is
+// appended so that a record never leaves a partial row behind.
+type messageRow struct {
+	record          *dsl.CorpusRecord
+	fromName        string
+	fromAddress     string
+	to              []string
+	cc              []string
+	sentDate        time.Time
+	references      []string
+	attachmentNames []string
+	body            string
+	hasBody         bool
+	bodyTruncated   bool
+}
+
+type column struct {
+	field  arrow.Field
+	append func(b array.Builder, row *messageRow)
+}
+
+var stringList = arrow.ListOf(arrow.BinaryTypes.String)
+
+// columns are the columns of the file, in order.
+var columns = []column{
+	{arrow.Field{Name: "uid", Type: arrow.PrimitiveTypes.Uint32}, func(b array.Builder, row *messageRow) {
+		b.(*array.Uint32Builder).Append(row.record.UID)
+	}},
+	{arrow.Field{Name: "message_id", Type: arrow.BinaryTypes.String}, func(b array.Builder, row *messageRow) {
+		appendString(b, row.record.MessageID)
+	}},
+	{arrow.Field{Name: "subject", Type: arrow.BinaryTypes.String}, func(b array.Builder, row *messageRow) {
+		appendString(b, row.record.Subject)
+	}},
+	{arrow.Field{Name: "from_name", Type: arrow.BinaryTypes.String}, func(b array.Builder, row *messageRow) {
+		appendString(b, row.fromName)
+	}},
+	{arrow.Field{Name: "from_address", Type: arrow.BinaryTypes.String}, func(b array.Builder, row *messageRow) {
+		appendString(b, row.fromAddress)
+	}},
+	{arrow.Field{Name: "to_addresses", Type: stringList}, func(b array.Builder, row *messageRow) {
+		appendStrings(b, row.to)
+	}},
+	{arrow.Field{Name: "cc_addresses", Type: stringList}, func(b array.Builder, row *messageRow) {
+		appendStrings(b, row.cc)
+	}},
+	{arrow.Field{Name: "sent_date", Type: arrow.FixedWidthTypes.Timestamp_us, Nullable: true}, func(b array.Builder, row *messageRow) {
+		appendTime(b, row.sentDate)
+	}},
+	{arrow.Field{Name: "internal_date", Type: arrow.FixedWidthTypes.Timestamp_us, Nullable: true}, func(b array.Builder, row *messageRow) {
+		appendTime(b, row.record.InternalDate)
+	}},
+	{arrow.Field{Name: "in_reply_to", Type: arrow.BinaryTypes.String}, func(b array.Builder, row *messageRow) {
+		appendString(b, row.record.InReplyTo)
+	}},
+	{arrow.Field{Name: "message_references", Type: stringList}, func(b array.Builder, row *messageRow) {
+		appendStrings(b, row.references)
+	}},
+	{arrow.Field{Name: "flags", Type: stringList}, func(b array.Builder, row *messageRow) {
+		appendStrings(b, row.record.Flags)
+	}},
+	{arrow.Field{Name: "size_bytes", Type: arrow.PrimitiveTypes.Int64}, func(b array.Builder, row *messageRow) {
+		b.(*array.Int64Builder).Append(int64(len(row.record.Raw)))
+	}},
+	{arrow.Field{Name: "part_count", Type: arrow.PrimitiveTypes.Int32}, func(b array.Builder, row *messageRow) {
+		b.(*array.Int32Builder).Append(int32(len(row.record.Parts)))
+	}},
+	{arrow.Field{Name: "attachment_names", Type: stringList}, func(b array.Builder, row *messageRow) {
+		appendStrings(b, row.attachmentNames)
+	}},
+	{arrow.Field{Name: "body", Type: arrow.BinaryTypes.String, Nullable: true}, func(b array.Builder, row *messageRow) {
+		if !row.hasBody {
+			b.AppendNull()
+			return
+		}
+		appendString(b, row.body)
+	}},
+	{arrow.Field{Name: "body_truncated", Type: arrow.FixedWidthTypes.Boolean}, func(b array.Builder, row *messageRow) {
+		b.(*array.BooleanBuilder).Append(row.bodyTruncated)
+	}},
+}
+
+// Schema is the Arrow schema of the file.
+var Schema = newSchema()
+
+func newSchema() *arrow.Schema {
+	fields := make([]arrow.Field, len(columns))
+	for i, c := range columns {
+		fields[i] = c.field
+	}
+	metadata := arrow.NewMetadata([]string{"smailnail.schema_version"}, []string{SchemaVersion})
+	return arrow.NewSchema(fields, &metadata)
+}
+
+// Store writes exported messages into a Parquet file. It implements
+// dsl.ExportStore; rows are written in batches, the file is complete once
+// Close returns.
+type Store struct {
+	file      *os.File
+	writer    *pqarrow.FileWriter
+	builder   *array.RecordBuilder
+	bodyChars int
+}
+
+// Create creates the file at path. bodyChars is the number of characters
+// of each body to include, 0 to leave bodies out.
+func Create(path string, bodyChars int) (*Store, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create parquet file: %w", err)
+	}
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Zstd))
+	writer, err := pqarrow.NewFileWriter(Schema, file, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("create parquet writer: %w", err)
+	}
+	return &Store{
+		file:      file,
+		writer:    writer,
+		builder:   array.NewRecordBuilder(memory.DefaultAllocator, Schema),
+		bodyChars: bodyChars,
+	}, nil
+}
+
+// Add appends a row for record.
+func (s *Store) Add(record *dsl.CorpusRecord) error {
+	row := s.newRow(record)
+	for i, c := range columns {
+		c.append(s.builder.Field(i), row)
+	}
+	if s.builder.Field(0).Len() >= batchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *Store) newRow(record *dsl.CorpusRecord) *messageRow {
+	row := &messageRow{record: record}
+
+	var header mail.Header
+	for _, h := range record.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	if from, err := header.AddressList("From"); err == nil && len(from) > 0 {
+		row.fromName, row.fromAddress = from[0].Name, from[0].Address
+	}
+	row.to = addresses(header, "To")
+	row.cc = addresses(header, "Cc")
+	if record.Date != "" {
+		if date, err := time.Parse(time.RFC3339, record.Date); err == nil {
+			row.sentDate = date
+		}
+	}
+	row.references = strings.Fields(record.References)
+
+	for _, part := range record.Parts {
+		if part.Disposition == "attachment" || (part.Filename != "" && !strings.HasPrefix(part.Type, "multipart/")) {
+			row.attachmentNames = append(row.attachmentNames, part.Filename)
+		}
+	}
+
+	if s.bodyChars > 0 {
+		row.hasBody = true
+		row.body, row.bodyTruncated = truncate(record.Body, s.bodyChars)
+	}
+	return row
+}
+
+func (s *Store) flush() error {
+	if s.builder.Field(0).Len() == 0 {
+		return nil
+	}
+	batch := s.builder.NewRecord()
+	defer batch.Release()
+	if err := s.writer.Write(batch); err != nil {
+		return fmt.Errorf("write parquet rows: %w", err)
+	}
+	return nil
+}
+
+// Close writes the buffered rows and the file footer.
+func (s *Store) Close() error {
+	if s.writer == nil {
+		return nil
+	}
+	defer s.builder.Release()
+	err := s.flush()
+	if closeErr := s.writer.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("close parquet writer: %w", closeErr)
+	}
+	s.writer = nil
+	// The writer may already have closed the file
+	if closeErr := s.file.Close(); err == nil && closeErr != nil && !errors.Is(closeErr, os.ErrClosed) {
+		err = fmt.Errorf("close parquet file: %w", closeErr)
+	}
+	return err
+}
+
+func addresses(header mail.Header, key string) []string {
+	list, err := header.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	ret := make([]string, len(list))
+	for i, addr := range list {
+		ret[i] = addr.Address
+	}
+	return ret
+}
+
+// truncate cuts s to at most n characters.
+func truncate(s string, n int) (string, bool) {
+	if utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	return string([]rune(s)[:n]), true
+}
+
+func appendString(b array.Builder, v string) {
+	b.(*array.StringBuilder).Append(v)
+}
+
+func appendStrings(b array.Builder, vs []string) {
+	list := b.(*array.ListBuilder)
+	list.Append(true)
+	values := list.ValueBuilder().(*array.StringBuilder)
+	for _, v := range vs {
+		values.Append(v)
+	}
+}
+
+func appendTime(b array.Builder, t time.Time) {
+	if t.IsZero() {
+		b.AppendNull()
+		return
+	}
+	b.(*array.TimestampBuilder).Append(arrow.Timestamp(t.UnixMicro()))
+}
+
+var _ dsl.ExportStore = (*Store)(nil)
@@ -0,0 +1,116 @@
+package parquetexport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), File)
+	store, err := Create(path, 9)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	record := &dsl.CorpusRecord{
+		UID:          7,
+		Flags:        []string{"\\Seen"},
+		InternalDate: time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+		Subject:      "Quarterly report",
+		Date:         "2025-03-03T08:59:00Z",
+		MessageID:    "<report@example.com>",
+		References:   "<a@example.com> <b@example.com>",
+		Headers: []dsl.CorpusHeader{
+			{Name: "From", Value: "Alice <alice@example.com>"},
+			{Name: "To", Value: "bob@example.com, Carol <carol@example.com>"},
+		},
+		Body: "The numbers look great.",
+		Parts: []dsl.CorpusPart{
+			{Path: "", Type: "multipart/mixed"},
+			{Path: "1", Type: "text/plain", Size: 25},
+			{Path: "2", Type: "text/csv", Disposition: "attachment", Filename: "q3.csv", Size: 5},
+		},
+		Raw: "raw message",
+	}
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := store.Add(&dsl.CorpusRecord{UID: 8}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	table, err := pqarrow.ReadTable(context.Background(), f, parquet.NewReaderProperties(memory.DefaultAllocator),
+		pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("ReadTable: %v", err)
+	}
+	defer table.Release()
+
+	if table.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", table.NumRows())
+	}
+	if int(table.NumCols()) != len(columns) {
+		t.Fatalf("expected %d columns, got %d", len(columns), table.NumCols())
+	}
+	column := func(name string) any {
+		t.Helper()
+		indices := table.Schema().FieldIndices(name)
+		if len(indices) != 1 {
+			t.Fatalf("missing column %s", name)
+		}
+		return table.Column(indices[0]).Data().Chunk(0)
+	}
+
+	if got := column("from_address").(*array.String).Value(0); got != "alice@example.com" {
+		t.Fatalf("unexpected from_address %q", got)
+	}
+	to := column("to_addresses").(*array.List)
+	if start, end := to.ValueOffsets(0); end-start != 2 {
+		t.Fatalf("expected 2 to addresses, got %d", end-start)
+	}
+	names := column("attachment_names").(*array.List)
+	if got := names.ListValues().(*array.String).Value(0); got != "q3.csv" {
+		t.Fatalf("unexpected attachment name %q", got)
+	}
+	body := column("body").(*array.String)
+	if body.Value(0) != "The numbe" {
+		t.Fatalf("unexpected body %q", body.Value(0))
+	}
+	if !column("body_truncated").(*array.Boolean).Value(0) {
+		t.Fatalf("expected body to be truncated")
+	}
+	if !column("sent_date").(*array.Timestamp).IsNull(1) {
+		t.Fatalf("expected missing sent_date to be null")
+	}
+}
+
+func TestCreateWithoutBodies(t *testing.T) {
+	store, err := Create(filepath.Join(t.TempDir(), File), 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	row := store.newRow(&dsl.CorpusRecord{Body: "hidden"})
+	if row.hasBody || row.body != "" {
+		t.Fatalf("expected bodies to be left out, got %q", row.body)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
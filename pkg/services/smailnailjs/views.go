@@ -40,6 +40,8 @@ type OutputView struct {
 
 type FieldView struct {
 	Name    string       `json:"name"`
+	As      string       `json:"as,omitempty"`
+	Order   int          `json:"order,omitempty"`
 	Content *ContentView `json:"content,omitempty"`
 }
 
@@ -132,7 +134,7 @@ func fieldsToViews(fields []interface{}) []FieldView {
 			continue
 		}
 
-		view := FieldView{Name: field.Name}
+		view := FieldView{Name: field.Name, As: field.As, Order: field.Order}
 		if field.Content != nil {
 			view.Content = &ContentView{
 				Type:        field.Content.Type,
@@ -0,0 +1,310 @@
+/*
+Package smailnail exposes smailnail's rule engine as a Go library.
+
+The Client type wraps an authenticated IMAP connection together with the pkg/dsl
+search, fetch and action machinery, so other Go programs can run smailnail rules
+without going through the CLI:
+
+	client, err := smailnail.New(imap.IMAPSettings{
+		Server:   "imap.example.com",
+		Port:     993,
+		Username: "user",
+		Password: "secret",
+		Mailbox:  "INBOX",
+	}, smailnail.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	rule, err := dsl.ParseRuleFile("rules/archive.yaml")
+	if err != nil {
+		return err
+	}
+	result, err := client.RunRule(ctx, rule)
+
+The connection is opened lazily on first use and the configured mailbox is
+selected automatically. Errors returned by the client wrap the sentinels
+defined in pkg/dsl (dsl.ErrMailboxNotFound, dsl.ErrSearchUnsupported, ...).
+*/
+package smailnail
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithLogger sets the logger used by the client. Defaults to the global
+// zerolog logger. Rules run by the client log to it too, with their name as
+// context, unless they were given a logger with Rule.SetLogger. The
+// package-level helpers of pkg/dsl and pkg/imap the rules call, such as the
+// search criteria builder and the connection setup, keep logging to the
+// global logger.
+func WithLogger(logger zerolog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithIMAPClient makes the facade use an already connected and authenticated
+// go-imap client instead of dialing the server from the settings. The client
+// is not closed by Close.
+func WithIMAPClient(client *imapclient.Client) Option {
+	return func(c *Client) {
+		c.imapClient = client
+		c.ownsConnection = false
+	}
+}
+
+// Client is the entry point for embedding smailnail in other Go programs.
+type Client struct {
+	settings       imap.IMAPSettings
+	logger         zerolog.Logger
+	imapClient     *imapclient.Client
+	ownsConnection bool
+	selected       string
+}
+
+// RunResult summarizes the execution of a rule.
+type RunResult struct {
+	Rule           string
+	Mailbox        string
+	Messages       []*dsl.EmailMessage
+	ActionsApplied bool
+	// Skipped is set when the rule's status check short-circuited the run
+	Skipped  bool
+	Duration time.Duration
+}
+
+// New creates a Client from IMAP settings. No connection is opened until the
+// first operation.
+func New(settings imap.IMAPSettings, opts ...Option) (*Client, error) {
+	if settings.Mailbox == "" {
+		settings.Mailbox = "INBOX"
+	}
+	if settings.Port == 0 {
+		settings.Port = 993
+	}
+
+	c := &Client{
+		settings:       settings,
+		logger:         log.Logger,
+		ownsConnection: true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.imapClient == nil && settings.Server == "" {
+		return nil, fmt.Errorf("IMAP server is required")
+	}
+
+	return c, nil
+}
+
+// Settings returns the IMAP settings the client was created with.
+func (c *Client) Settings() imap.IMAPSettings {
+	return c.settings
+}
+
+// Logger returns the logger used by the client.
+func (c *Client) Logger() zerolog.Logger {
+	return c.logger
+}
+
+// Connect dials and authenticates against the server and selects the
+// configured mailbox. It is called implicitly by the other methods.
+func (c *Client) Connect(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.imapClient == nil {
+		if c.settings.Password == "" {
+			return fmt.Errorf("IMAP password is required")
+		}
+		c.logger.Debug().
+			Str("server", c.settings.Server).
+			Int("port", c.settings.Port).
+			Str("username", c.settings.Username).
+			Msg("Connecting to IMAP server")
+
+		client, err := c.settings.ConnectToIMAPServer()
+		if err != nil {
+			return err
+		}
+		c.imapClient = client
+		c.ownsConnection = true
+	}
+	if c.selected == "" {
+		return c.SelectMailbox(ctx, c.settings.Mailbox)
+	}
+	return nil
+}
+
+// SelectMailbox selects the mailbox subsequent operations run against.
+func (c *Client) SelectMailbox(ctx context.Context, mailbox string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.imapClient == nil {
+		if err := c.Connect(ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := dsl.SelectMailbox(c.imapClient, mailbox); err != nil {
+		return err
+	}
+	c.selected = mailbox
+	c.logger.Debug().Str("mailbox", mailbox).Msg("Selected mailbox")
+	return nil
+}
+
+// IMAP returns the underlying go-imap client, connecting if needed. It is an
+// escape hatch for operations the facade does not cover.
+func (c *Client) IMAP(ctx context.Context) (*imapclient.Client, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	return c.imapClient, nil
+}
+
+// Close logs out and closes the connection if the client opened it. The
+// connection is closed even when the server does not answer the logout.
+func (c *Client) Close() error {
+	if c.imapClient == nil || !c.ownsConnection {
+		return nil
+	}
+	var err error
+	if logoutErr := c.imapClient.Logout().Wait(); logoutErr != nil {
+		c.logger.Debug().Err(logoutErr).Msg("Failed to log out")
+		err = c.imapClient.Close()
+	} else {
+		// The server ended the session, closing only releases the connection
+		_ = c.imapClient.Close()
+	}
+	c.imapClient = nil
+	c.selected = ""
+	return err
+}
+
+// Search runs the search part of a rule and returns the matching UIDs.
+func (c *Client) Search(ctx context.Context, search dsl.SearchConfig) ([]uint32, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	uids, err := dsl.SearchUIDs(c.imapClient, search)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		ret = append(ret, uint32(uid))
+	}
+	c.logger.Debug().Int("matches", len(ret)).Msg("Search completed")
+	return ret, nil
+}
+
+// Fetch runs the rule's search and returns the messages shaped by its output
+// configuration. Actions are not executed.
+func (c *Client) Fetch(ctx context.Context, rule *dsl.Rule) ([]*dsl.EmailMessage, error) {
+	if rule == nil {
+		return nil, fmt.Errorf("rule is nil")
+	}
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	c.adoptRule(rule)
+	return rule.FetchMessages(c.imapClient)
+}
+
+// Act applies actions to messages previously returned by Fetch.
+func (c *Client) Act(ctx context.Context, messages []*dsl.EmailMessage, actions *dsl.ActionConfig) error {
+	if actions == nil || len(messages) == 0 {
+		return nil
+	}
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	// An unnamed rule acts like the package-level dsl.ExecuteActions
+	rule := &dsl.Rule{Actions: *actions}
+	rule.SetLogger(c.logger)
+	return rule.ExecuteActions(c.imapClient, messages)
+}
+
+// adoptRule makes rule log to the client's logger unless it has its own.
+func (c *Client) adoptRule(rule *dsl.Rule) {
+	if !rule.HasLogger() {
+		rule.SetLogger(c.logger)
+	}
+}
+
+// RunRule fetches the messages matching rule and applies its actions.
+func (c *Client) RunRule(ctx context.Context, rule *dsl.Rule) (*RunResult, error) {
+	if rule == nil {
+		return nil, fmt.Errorf("rule is nil")
+	}
+	start := time.Now()
+	c.adoptRule(rule)
+	logger := rule.Logger()
+
+	if rule.Status != nil {
+		if err := c.Connect(ctx); err != nil {
+			return nil, err
+		}
+		skip, err := rule.ShouldSkip(c.imapClient, c.selected)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check status for rule %s: %w", rule.Name, err)
+		}
+		if skip {
+			return &RunResult{
+				Rule:     rule.Name,
+				Mailbox:  c.selected,
+				Skipped:  true,
+				Duration: time.Since(start),
+			}, nil
+		}
+	}
+
+	messages, err := c.Fetch(ctx, rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages for rule %s: %w", rule.Name, err)
+	}
+
+	result := &RunResult{
+		Rule:     rule.Name,
+		Mailbox:  c.selected,
+		Messages: messages,
+	}
+
+	if len(messages) > 0 && !reflect.DeepEqual(rule.Actions, dsl.ActionConfig{}) {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := rule.ExecuteActions(c.imapClient, messages); err != nil {
+			return result, fmt.Errorf("failed to execute actions for rule %s: %w", rule.Name, err)
+		}
+		result.ActionsApplied = true
+	}
+
+	result.Duration = time.Since(start)
+	logger.Info().
+		Int("messages", len(messages)).
+		Bool("actions_applied", result.ActionsApplied).
+		Str("duration", result.Duration.String()).
+		Msg("Rule run complete")
+
+	return result, nil
+}
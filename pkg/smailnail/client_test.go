@@ -0,0 +1,100 @@
+package smailnail
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/go-go-golems/smailnail/pkg/imap"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAppliesDefaults(t *testing.T) {
+	client, err := New(imap.IMAPSettings{Server: "imap.example.com"})
+	require.NoError(t, err)
+
+	settings := client.Settings()
+	assert.Equal(t, "INBOX", settings.Mailbox)
+	assert.Equal(t, 993, settings.Port)
+}
+
+func TestNewRequiresServer(t *testing.T) {
+	_, err := New(imap.IMAPSettings{})
+	require.Error(t, err)
+}
+
+func TestWithLoggerIsUsed(t *testing.T) {
+	var buf bytes.Buffer
+	logger := zerolog.New(&buf)
+
+	client, err := New(imap.IMAPSettings{Server: "imap.example.com"}, WithLogger(logger))
+	require.NoError(t, err)
+
+	logger = client.Logger()
+	logger.Info().Msg("hello")
+	assert.Contains(t, buf.String(), "hello")
+}
+
+func TestRunRuleLogsToClientLogger(t *testing.T) {
+	fixture, err := imap.LoadFixture("../dsl/testdata/imap/move_invoices.imap")
+	require.NoError(t, err)
+	conn, _ := fixture.Pipe()
+	imapClient := imapclient.New(conn, nil)
+	defer func() { _ = imapClient.Close() }()
+	require.NoError(t, imapClient.Login("user", "password").Wait())
+
+	var buf bytes.Buffer
+	client, err := New(imap.IMAPSettings{}, WithIMAPClient(imapClient), WithLogger(zerolog.New(&buf)))
+	require.NoError(t, err)
+
+	rule, err := dsl.ParseRuleFile("../dsl/testdata/imap/move_invoices.yaml")
+	require.NoError(t, err)
+	result, err := client.RunRule(context.Background(), rule)
+	require.NoError(t, err)
+	assert.True(t, result.ActionsApplied)
+
+	// Rules run by the client log to its logger, with the rule name
+	assert.Contains(t, buf.String(), `"rule":"move-invoices","message_count":2,"message":"Starting to execute actions on messages"`)
+	assert.Contains(t, buf.String(), `"rule":"move-invoices","target_mailbox":"Archive"`)
+	assert.Contains(t, buf.String(), `"rule":"move-invoices","messages":2,"actions_applied":true`)
+}
+
+func TestConnectRequiresPassword(t *testing.T) {
+	client, err := New(imap.IMAPSettings{Server: "imap.example.com", Username: "user"})
+	require.NoError(t, err)
+
+	err = client.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "password")
+}
+
+func TestCloseWithoutConnectionIsNoop(t *testing.T) {
+	client, err := New(imap.IMAPSettings{Server: "imap.example.com"})
+	require.NoError(t, err)
+	assert.NoError(t, client.Close())
+}
+
+func TestCloseLogsOut(t *testing.T) {
+	fixture, err := imap.ParseFixture(strings.NewReader(`S: * OK [CAPABILITY IMAP4rev2 AUTH=PLAIN] IMAP server ready
+C: T1 LOGIN <redacted>
+S: T1 OK [CAPABILITY IMAP4rev2] Logged in
+C: T2 LOGOUT
+S: * BYE Logging out
+S: T2 OK LOGOUT completed
+`))
+	require.NoError(t, err)
+	conn, done := fixture.Pipe()
+	imapClient := imapclient.New(conn, nil)
+	require.NoError(t, imapClient.Login("user", "password").Wait())
+
+	// As if Connect had dialed the server
+	client := &Client{imapClient: imapClient, ownsConnection: true, logger: zerolog.Nop()}
+	require.NoError(t, client.Close())
+	require.NoError(t, <-done, "Close did not log out")
+	assert.Nil(t, client.imapClient)
+}
@@ -0,0 +1,316 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+	"github.com/rs/zerolog/log"
+)
+
+// PrefetchOptions configures the background prefetcher.
+type PrefetchOptions struct {
+	// Interval between two refreshes of a mailbox, prefetching is disabled
+	// when zero
+	Interval time.Duration
+	// Mailboxes are prefetched for every account, in addition to its
+	// default mailbox
+	Mailboxes []string
+}
+
+// cacheKey identifies a prefetched mailbox.
+type cacheKey struct {
+	accountID string
+	mailbox   string
+}
+
+// cachedMailbox holds the envelopes, flags and sizes of the messages of a
+// mailbox, sorted by UID.
+type cachedMailbox struct {
+	uidValidity uint32
+	messages    []*dsl.EmailMessage
+	// expiresAt is when listings stop being answered from the cache, when
+	// refreshes fail
+	expiresAt time.Time
+}
+
+// mailboxCache holds the prefetched mailboxes. It is safe for concurrent use.
+type mailboxCache struct {
+	mu        sync.RWMutex
+	mailboxes map[cacheKey]*cachedMailbox
+}
+
+func newMailboxCache() *mailboxCache {
+	return &mailboxCache{mailboxes: make(map[cacheKey]*cachedMailbox)}
+}
+
+// get returns the cached mailbox, nil if it is not prefetched.
+func (c *mailboxCache) get(key cacheKey) *cachedMailbox {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.mailboxes[key]
+}
+
+func (c *mailboxCache) put(key cacheKey, cached *cachedMailbox) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mailboxes[key] = cached
+}
+
+// forget drops the mailboxes of an account, after it was changed or deleted.
+func (c *mailboxCache) forget(accountID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.mailboxes {
+		if key.accountID == accountID {
+			delete(c.mailboxes, key)
+		}
+	}
+}
+
+// retain drops the mailboxes that are no longer prefetched.
+func (c *mailboxCache) retain(keys map[cacheKey]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.mailboxes {
+		if !keys[key] {
+			delete(c.mailboxes, key)
+		}
+	}
+}
+
+// merge returns the mailbox after a refresh: the new messages are appended,
+// the flags of known messages replaced and the messages missing from flags,
+// which were expunged, dropped. A changed UIDVALIDITY invalidates the known
+// messages.
+func (c *cachedMailbox) merge(uidValidity uint32, flags map[uint32][]string, fresh []*dsl.EmailMessage, expiresAt time.Time) *cachedMailbox {
+	merged := &cachedMailbox{uidValidity: uidValidity, expiresAt: expiresAt}
+	if c != nil && c.uidValidity == uidValidity {
+		for _, msg := range c.messages {
+			msgFlags, ok := flags[msg.UID]
+			if !ok {
+				continue
+			}
+			updated := *msg
+			updated.Flags = msgFlags
+			merged.messages = append(merged.messages, &updated)
+		}
+	}
+	lastUID := merged.lastUID()
+	for _, msg := range fresh {
+		if msg.UID > lastUID {
+			merged.messages = append(merged.messages, msg)
+		}
+	}
+	sort.Slice(merged.messages, func(i, j int) bool {
+		return merged.messages[i].UID < merged.messages[j].UID
+	})
+	return merged
+}
+
+func (c *cachedMailbox) lastUID() uint32 {
+	if c == nil || len(c.messages) == 0 {
+		return 0
+	}
+	return c.messages[len(c.messages)-1].UID
+}
+
+// preview answers a message listing from the cache the way the live preview
+// rule does: the most recent matching messages, oldest first.
+func (c *cachedMailbox) preview(input ListMessagesInput) []MessageView {
+	query := strings.ToLower(strings.TrimSpace(input.Query))
+	var matched []*dsl.EmailMessage
+	for _, msg := range c.messages {
+		if query != "" && (msg.Envelope == nil || !strings.Contains(strings.ToLower(msg.Envelope.Subject), query)) {
+			continue
+		}
+		if input.UnreadOnly && hasFlag(msg.Flags, string(imap.FlagSeen)) {
+			continue
+		}
+		matched = append(matched, msg)
+	}
+
+	end := len(matched) - max(input.Offset, 0)
+	if end < 0 {
+		end = 0
+	}
+	start := max(end-previewLimit(input.Limit), 0)
+
+	total := uint32(len(matched))
+	ret := make([]MessageView, 0, end-start)
+	for _, msg := range matched[start:end] {
+		view := messageToView(msg)
+		view.TotalCount = total
+		ret = append(ret, view)
+	}
+	return ret
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefetcher keeps the envelopes and flags of the configured mailboxes of
+// every account warm, so that message listings are answered without a live
+// FETCH.
+type Prefetcher struct {
+	service *Service
+	options PrefetchOptions
+}
+
+// NewPrefetcher returns a prefetcher filling the cache of service.
+func NewPrefetcher(service *Service, options PrefetchOptions) *Prefetcher {
+	return &Prefetcher{service: service, options: options}
+}
+
+// Run refreshes the mailboxes every interval until ctx is done. Errors are
+// logged and the mailbox is retried at the next refresh.
+func (p *Prefetcher) Run(ctx context.Context) {
+	if p.options.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.options.Interval)
+	defer ticker.Stop()
+	for {
+		p.RefreshAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshAll refreshes the prefetched mailboxes of every account once.
+func (p *Prefetcher) RefreshAll(ctx context.Context) {
+	accounts, err := p.service.repo.ListAll(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list accounts to prefetch")
+		return
+	}
+
+	keys := make(map[cacheKey]bool)
+	for _, account := range accounts {
+		mailboxes := append([]string{normalizeMailbox(account.MailboxDefault)}, p.options.Mailboxes...)
+		for _, mailbox := range mailboxes {
+			keys[cacheKey{accountID: account.ID, mailbox: normalizeMailbox(mailbox)}] = true
+		}
+	}
+	p.service.cache.retain(keys)
+
+	for _, account := range accounts {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := p.refreshAccount(ctx, account, keys); err != nil {
+			log.Warn().Err(err).
+				Str("account_id", account.ID).
+				Msg("Failed to prefetch account")
+		}
+	}
+}
+
+func (p *Prefetcher) refreshAccount(ctx context.Context, account Account, keys map[cacheKey]bool) error {
+	connection, err := p.service.ResolveConnection(ctx, account.UserID, account.ID)
+	if err != nil {
+		return err
+	}
+	client, stage, err := dialAndLogin(connection)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrIMAP, stage, err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for key := range keys {
+		if key.accountID != account.ID {
+			continue
+		}
+		start := time.Now()
+		cached, err := p.refreshMailbox(client, key)
+		if err != nil {
+			log.Warn().Err(err).
+				Str("account_id", account.ID).
+				Str("mailbox", key.mailbox).
+				Msg("Failed to prefetch mailbox")
+			continue
+		}
+		log.Debug().
+			Str("account_id", account.ID).
+			Str("mailbox", key.mailbox).
+			Int("messages", len(cached.messages)).
+			Str("duration", time.Since(start).String()).
+			Msg("Prefetched mailbox")
+	}
+	return nil
+}
+
+// refreshMailbox fetches the messages that arrived since the last refresh and
+// the flags of the known ones.
+func (p *Prefetcher) refreshMailbox(client *imapclient.Client, key cacheKey) (*cachedMailbox, error) {
+	selected, err := client.Select(key.mailbox, &imap.SelectOptions{ReadOnly: true}).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("%w: select mailbox %q: %v", ErrIMAP, key.mailbox, err)
+	}
+
+	previous := p.service.cache.get(key)
+	if previous != nil && previous.uidValidity != selected.UIDValidity {
+		previous = nil
+	}
+
+	flags := make(map[uint32][]string)
+	var fresh []*dsl.EmailMessage
+	if selected.NumMessages > 0 {
+		lastUID := previous.lastUID()
+		if lastUID > 0 {
+			var known imap.UIDSet
+			known.AddRange(1, imap.UID(lastUID))
+			buffers, err := client.Fetch(known, &imap.FetchOptions{UID: true, Flags: true}).Collect()
+			if err != nil {
+				return nil, fmt.Errorf("%w: fetch flags: %v", ErrIMAP, err)
+			}
+			for _, buf := range buffers {
+				msgFlags := make([]string, len(buf.Flags))
+				for i, flag := range buf.Flags {
+					msgFlags[i] = string(flag)
+				}
+				flags[uint32(buf.UID)] = msgFlags
+			}
+		}
+
+		var newer imap.UIDSet
+		newer.AddRange(imap.UID(lastUID+1), 0)
+		buffers, err := client.Fetch(newer, &imap.FetchOptions{
+			UID:        true,
+			Flags:      true,
+			Envelope:   true,
+			RFC822Size: true,
+		}).Collect()
+		if err != nil {
+			return nil, fmt.Errorf("%w: fetch new messages: %v", ErrIMAP, err)
+		}
+		for _, buf := range buffers {
+			msg, err := dsl.NewEmailMessageFromIMAP(buf, nil)
+			if err != nil {
+				return nil, err
+			}
+			fresh = append(fresh, msg)
+		}
+	}
+
+	// Listings fall back to live fetches after two missed refreshes
+	expiresAt := p.service.now().Add(2 * p.options.Interval)
+	cached := previous.merge(selected.UIDValidity, flags, fresh, expiresAt)
+	p.service.cache.put(key, cached)
+	return cached, nil
+}
@@ -0,0 +1,82 @@
+package accounts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+func prefetchedMessage(uid uint32, subject string, flags ...string) *dsl.EmailMessage {
+	return &dsl.EmailMessage{
+		UID:      uid,
+		Envelope: &dsl.EmailEnvelope{Subject: subject},
+		Flags:    flags,
+	}
+}
+
+func TestCachedMailboxMerge(t *testing.T) {
+	expiresAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var cached *cachedMailbox
+	cached = cached.merge(7, nil, []*dsl.EmailMessage{
+		prefetchedMessage(1, "first"),
+		prefetchedMessage(2, "second"),
+		prefetchedMessage(3, "third"),
+	}, expiresAt)
+	if cached.lastUID() != 3 {
+		t.Fatalf("lastUID() = %d, want 3", cached.lastUID())
+	}
+
+	// UID 2 was expunged, UID 1 read, and UID 4 arrived; the newest message is
+	// returned again by the UID 4:* fetch
+	cached = cached.merge(7, map[uint32][]string{1: {"\\Seen"}, 3: {}}, []*dsl.EmailMessage{
+		prefetchedMessage(3, "third"),
+		prefetchedMessage(4, "fourth"),
+	}, expiresAt)
+	var uids []uint32
+	for _, msg := range cached.messages {
+		uids = append(uids, msg.UID)
+	}
+	if len(uids) != 3 || uids[0] != 1 || uids[1] != 3 || uids[2] != 4 {
+		t.Fatalf("uids = %v, want [1 3 4]", uids)
+	}
+	if len(cached.messages[0].Flags) != 1 || cached.messages[0].Flags[0] != "\\Seen" {
+		t.Fatalf("flags of UID 1 = %v, want [\\Seen]", cached.messages[0].Flags)
+	}
+
+	cached = cached.merge(8, nil, []*dsl.EmailMessage{prefetchedMessage(1, "renumbered")}, expiresAt)
+	if len(cached.messages) != 1 || cached.messages[0].Envelope.Subject != "renumbered" {
+		t.Fatalf("expected a new UIDVALIDITY to drop the known messages, got %d messages", len(cached.messages))
+	}
+}
+
+func TestCachedMailboxPreview(t *testing.T) {
+	cached := &cachedMailbox{messages: []*dsl.EmailMessage{
+		prefetchedMessage(1, "Invoice March", "\\Seen"),
+		prefetchedMessage(2, "Lunch"),
+		prefetchedMessage(3, "Invoice April"),
+		prefetchedMessage(4, "invoice May", "\\Seen"),
+	}}
+
+	views := cached.preview(ListMessagesInput{Limit: 2})
+	if len(views) != 2 || views[0].UID != 3 || views[1].UID != 4 {
+		t.Fatalf("preview() = %+v, want the two most recent messages", views)
+	}
+	if views[0].TotalCount != 4 {
+		t.Fatalf("TotalCount = %d, want 4", views[0].TotalCount)
+	}
+
+	views = cached.preview(ListMessagesInput{Query: "INVOICE", Offset: 1})
+	if len(views) != 2 || views[0].UID != 1 || views[1].UID != 3 {
+		t.Fatalf("preview(query, offset) = %+v, want UIDs 1 and 3", views)
+	}
+
+	views = cached.preview(ListMessagesInput{UnreadOnly: true})
+	if len(views) != 2 || views[0].UID != 2 || views[1].UID != 3 {
+		t.Fatalf("preview(unread) = %+v, want UIDs 2 and 3", views)
+	}
+
+	if views := cached.preview(ListMessagesInput{Offset: 10}); len(views) != 0 {
+		t.Fatalf("preview(offset past the end) = %+v, want none", views)
+	}
+}
@@ -95,6 +95,33 @@ func (r *Repository) ListByUser(ctx context.Context, userID string) ([]Account,
 	return ret, err
 }
 
+// ListAll lists the accounts of all users, for background work such as
+// prefetching.
+func (r *Repository) ListAll(ctx context.Context) ([]Account, error) {
+	var ret []Account
+	err := r.db.SelectContext(ctx, &ret, `SELECT
+		id,
+		user_id,
+		label,
+		provider_hint,
+		server,
+		port,
+		username,
+		mailbox_default,
+		insecure,
+		auth_kind,
+		secret_ciphertext,
+		secret_nonce,
+		secret_key_id,
+		is_default,
+		mcp_enabled,
+		created_at,
+		updated_at
+	FROM imap_accounts
+	ORDER BY user_id ASC, created_at ASC`)
+	return ret, err
+}
+
 func (r *Repository) GetByID(ctx context.Context, userID, accountID string) (*Account, error) {
 	var account Account
 	err := r.db.GetContext(ctx, &account, r.db.Rebind(`SELECT
@@ -34,6 +34,8 @@ type Service struct {
 	now              func() time.Time
 	newID            func() string
 	runReadOnlyProbe func(connection *ConnectionDetails) (*readOnlyProbeResult, string, error)
+	// cache holds the mailboxes a Prefetcher keeps warm
+	cache *mailboxCache
 }
 
 type CreateInput struct {
@@ -163,6 +165,7 @@ func NewService(repo *Repository, secretConfig *secrets.Config) *Service {
 		now:              func() time.Time { return time.Now().UTC() },
 		newID:            uuid.NewString,
 		runReadOnlyProbe: runReadOnlyProbe,
+		cache:            newMailboxCache(),
 	}
 }
 
@@ -300,10 +303,13 @@ func (s *Service) Update(ctx context.Context, userID, accountID string, input Up
 	if err := s.repo.UpdateAtomic(ctx, account, account.IsDefault); err != nil {
 		return nil, err
 	}
+	// The server or credentials may have changed
+	s.cache.forget(accountID)
 	return s.Get(ctx, userID, accountID)
 }
 
 func (s *Service) Delete(ctx context.Context, userID, accountID string) error {
+	s.cache.forget(accountID)
 	return s.repo.Delete(ctx, userID, accountID)
 }
 
@@ -410,6 +416,14 @@ func (s *Service) ListMailboxes(ctx context.Context, userID, accountID string) (
 }
 
 func (s *Service) ListMessages(ctx context.Context, userID, accountID string, input ListMessagesInput) ([]MessageView, string, error) {
+	// Answer from the prefetched envelopes when they are fresh
+	if cached := s.cache.get(cacheKey{accountID: accountID, mailbox: normalizeMailbox(input.Mailbox)}); cached != nil && s.now().Before(cached.expiresAt) {
+		if _, err := s.repo.GetByID(ctx, userID, accountID); err != nil {
+			return nil, "", err
+		}
+		return cached.preview(input), normalizeMailbox(input.Mailbox), nil
+	}
+
 	client, mailbox, err := s.openSelectedMailbox(ctx, userID, accountID, input.Mailbox)
 	if err != nil {
 		return nil, "", err
@@ -698,14 +712,20 @@ func classifyFailure(account *Account, stage string, err error) (string, string,
 	return errorCode, warningCode, err.Error()
 }
 
-func buildPreviewRule(input ListMessagesInput) *dsl.Rule {
-	limit := input.Limit
+// previewLimit bounds the number of messages a listing returns.
+func previewLimit(limit int) int {
 	switch {
 	case limit <= 0:
-		limit = 20
+		return 20
 	case limit > 100:
-		limit = 100
+		return 100
+	default:
+		return limit
 	}
+}
+
+func buildPreviewRule(input ListMessagesInput) *dsl.Rule {
+	limit := previewLimit(input.Limit)
 
 	search := dsl.SearchConfig{}
 	if query := strings.TrimSpace(input.Query); query != "" {
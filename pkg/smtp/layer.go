@@ -0,0 +1,158 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	netsmtp "net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+)
+
+// SMTPSettings represents the settings for sending mail through an SMTP
+// server
+type SMTPSettings struct {
+	Server   string `glazed:"smtp-server"`
+	Port     int    `glazed:"smtp-port"`
+	Username string `glazed:"smtp-username"`
+	Password string `glazed:"smtp-password"`
+	From     string `glazed:"smtp-from"`
+	Insecure bool   `glazed:"smtp-insecure"`
+}
+
+const SMTPSectionSlug = "smtp"
+
+// NewSMTPSection creates a new section for SMTP server settings.
+func NewSMTPSection() (schema.Section, error) {
+	return schema.NewSection(
+		SMTPSectionSlug,
+		"SMTP Server Settings",
+		schema.WithFields(
+			fields.New(
+				"smtp-server",
+				fields.TypeString,
+				fields.WithHelp("SMTP server address, for actions that send mail"),
+			),
+			fields.New(
+				"smtp-port",
+				fields.TypeInteger,
+				fields.WithHelp("SMTP server port: 465 for implicit TLS, otherwise STARTTLS when offered"),
+				fields.WithDefault(587),
+			),
+			fields.New(
+				"smtp-username",
+				fields.TypeString,
+				fields.WithHelp("SMTP username (default: the IMAP username)"),
+			),
+			fields.New(
+				"smtp-password",
+				fields.TypeString,
+				fields.WithHelp("SMTP password (default: the IMAP password)"),
+			),
+			fields.New(
+				"smtp-from",
+				fields.TypeString,
+				fields.WithHelp("Sender address (default: the SMTP username)"),
+			),
+			fields.New(
+				"smtp-insecure",
+				fields.TypeBool,
+				fields.WithHelp("Skip TLS verification"),
+				fields.WithDefault(false),
+			),
+		),
+	)
+}
+
+// Sender delivers messages through the SMTP server of its settings.
+type Sender struct {
+	settings SMTPSettings
+}
+
+// NewSender returns a Sender for s. Each Send opens its own connection.
+func (s *SMTPSettings) NewSender() *Sender {
+	return &Sender{settings: *s}
+}
+
+// From returns the sender address.
+func (s *Sender) From() string {
+	if s.settings.From != "" {
+		return s.settings.From
+	}
+	return s.settings.Username
+}
+
+// Send delivers msg to the recipients.
+func (s *Sender) Send(to []string, msg []byte) error {
+	client, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	if s.settings.Username != "" {
+		auth := netsmtp.PlainAuth("", s.settings.Username, s.settings.Password, s.settings.Server)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate to SMTP server: %w", err)
+		}
+	}
+	if err := client.Mail(s.From()); err != nil {
+		return fmt.Errorf("SMTP server refused sender %s: %w", s.From(), err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("SMTP server refused recipient %s: %w", rcpt, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial connects with implicit TLS on port 465, and upgrades with STARTTLS
+// on other ports when the server offers it.
+func (s *Sender) dial() (*netsmtp.Client, error) {
+	addr := net.JoinHostPort(s.settings.Server, strconv.Itoa(s.settings.Port))
+	tlsConfig := &tls.Config{
+		ServerName: s.settings.Server,
+		// #nosec G402 -- this is an explicit user-controlled dev/test escape hatch exposed as --smtp-insecure.
+		InsecureSkipVerify: s.settings.Insecure,
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if s.settings.Port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	client, err := netsmtp.NewClient(conn, s.settings.Server)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	if ok, _ := client.Extension("STARTTLS"); ok && s.settings.Port != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,91 @@
+package smtp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer accepts one SMTP session without TLS or auth and returns the
+// commands and message data it received.
+func fakeServer(t *testing.T) (int, <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = fmt.Fprintf(conn, "%s\r\n", line) }
+
+		var lines []string
+		reply("220 fake ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData:
+				if line == "." {
+					inData = false
+					reply("250 queued")
+				}
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 fake")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		received <- lines
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSenderSend(t *testing.T) {
+	port, received := fakeServer(t)
+	settings := &SMTPSettings{Server: "127.0.0.1", Port: port, From: "me@example.com"}
+	sender := settings.NewSender()
+	assert.Equal(t, "me@example.com", sender.From())
+
+	msg := "From: me@example.com\r\nTo: leave@lists.example.org\r\nSubject: unsubscribe\r\n\r\nunsubscribe\r\n"
+	require.NoError(t, sender.Send([]string{"leave@lists.example.org"}, []byte(msg)))
+
+	lines := <-received
+	assert.Contains(t, lines, "MAIL FROM:<me@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<leave@lists.example.org>")
+	assert.Contains(t, lines, "Subject: unsubscribe")
+}
+
+func TestSenderConnectError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	require.NoError(t, ln.Close())
+
+	sender := (&SMTPSettings{Server: "127.0.0.1", Port: port, Username: "me@example.com"}).NewSender()
+	assert.Equal(t, "me@example.com", sender.From())
+	err = sender.Send([]string{"x@example.org"}, []byte("\r\n"))
+	assert.ErrorContains(t, err, "failed to connect to SMTP server")
+}
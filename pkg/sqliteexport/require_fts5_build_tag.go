@@ -0,0 +1,5 @@
+//go:build !sqlite_fts5 && !fts5
+
+package sqliteexport
+
+var _ = requires_sqlite_fts5_build_tag
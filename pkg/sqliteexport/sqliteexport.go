@@ -0,0 +1,317 @@
+// Package sqliteexport adds the sqlite format to the export action: the
+// exported messages are written into a normalized SQLite database, with a
+// full-text index on their subjects and bodies, for ad-hoc SQL over a
+// mailbox snapshot. Importing the package registers the format.
+package sqliteexport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+const (
+	// Format is the export format name, export.format: sqlite
+	Format = "sqlite"
+	// File is the database an export writes, in the export directory or
+	// archive
+	File = "messages.sqlite"
+
+	schemaVersion = 1
+)
+
+func init() {
+	if err := dsl.RegisterExportStore(Format, File, func(path string, config *dsl.ExportConfig) (dsl.ExportStore, error) {
+		if config.BodyChars != 0 {
+			return nil, fmt.Errorf("body_chars does not apply to the sqlite format, which stores whole bodies")
+		}
+		return Create(path)
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// recipientHeaders are the address headers stored in the recipients table.
+var recipientHeaders = []string{"From", "Sender", "Reply-To", "To", "Cc", "Bcc"}
+
+// Schema creates the tables of an export. Every table refers to its message
+// through message_row, the id of the messages row.
+var Schema = []string{
+	`CREATE TABLE export_metadata (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE messages (
+		id INTEGER PRIMARY KEY,
+		uid INTEGER NOT NULL,
+		message_id TEXT NOT NULL DEFAULT '',
+		subject TEXT NOT NULL DEFAULT '',
+		sent_date TEXT NOT NULL DEFAULT '',
+		internal_date TEXT NOT NULL DEFAULT '',
+		in_reply_to TEXT NOT NULL DEFAULT '',
+		message_references TEXT NOT NULL DEFAULT '',
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		body_text TEXT NOT NULL DEFAULT '',
+		raw BLOB NOT NULL
+	)`,
+	`CREATE INDEX idx_messages_message_id ON messages(message_id)`,
+	`CREATE INDEX idx_messages_sent_date ON messages(sent_date)`,
+	`CREATE TABLE flags (
+		message_row INTEGER NOT NULL REFERENCES messages(id),
+		flag TEXT NOT NULL,
+		PRIMARY KEY (message_row, flag)
+	)`,
+	`CREATE TABLE headers (
+		message_row INTEGER NOT NULL REFERENCES messages(id),
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (message_row, position)
+	)`,
+	`CREATE INDEX idx_headers_name ON headers(name COLLATE NOCASE)`,
+	`CREATE TABLE recipients (
+		message_row INTEGER NOT NULL REFERENCES messages(id),
+		kind TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		address TEXT NOT NULL,
+		PRIMARY KEY (message_row, kind, position)
+	)`,
+	`CREATE INDEX idx_recipients_address ON recipients(address COLLATE NOCASE)`,
+	`CREATE TABLE parts (
+		message_row INTEGER NOT NULL REFERENCES messages(id),
+		path TEXT NOT NULL,
+		type TEXT NOT NULL,
+		charset TEXT NOT NULL DEFAULT '',
+		disposition TEXT NOT NULL DEFAULT '',
+		filename TEXT NOT NULL DEFAULT '',
+		encoding TEXT NOT NULL DEFAULT '',
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (message_row, path)
+	)`,
+	`CREATE TABLE attachments (
+		message_row INTEGER NOT NULL REFERENCES messages(id),
+		part_path TEXT NOT NULL,
+		filename TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		sha256 TEXT NOT NULL,
+		content BLOB,
+		PRIMARY KEY (message_row, part_path)
+	)`,
+	`CREATE INDEX idx_attachments_sha256 ON attachments(sha256)`,
+	`CREATE VIRTUAL TABLE messages_fts USING fts5(
+		subject,
+		body_text,
+		content='messages',
+		content_rowid='id'
+	)`,
+}
+
+// Store writes exported messages into a SQLite database. It implements
+// dsl.ExportStore; all messages are added in one transaction, committed by
+// Close.
+type Store struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+}
+
+// Create creates the database at path with the export schema.
+func Create(path string) (*Store, error) {
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("open export database: %w", err)
+	}
+	ctx := context.Background()
+	for _, statement := range Schema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			_ = db.Close()
+			if strings.Contains(statement, "fts5") {
+				return nil, fmt.Errorf("fts5 is required but unavailable: %w", err)
+			}
+			return nil, fmt.Errorf("create export schema: %w", err)
+		}
+	}
+	metadata := map[string]string{
+		"schema_version": fmt.Sprint(schemaVersion),
+		"exported_at":    time.Now().UTC().Format(time.RFC3339),
+	}
+	for key, value := range metadata {
+		if _, err := db.ExecContext(ctx, `INSERT INTO export_metadata (key, value) VALUES (?, ?)`, key, value); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("write export metadata: %w", err)
+		}
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("begin export transaction: %w", err)
+	}
+	return &Store{db: db, tx: tx}, nil
+}
+
+// Add writes record and its flags, headers, recipients, parts and
+// attachments. A record that fails leaves nothing behind, so the export
+// goes on with the next message.
+func (s *Store) Add(record *dsl.CorpusRecord) error {
+	if _, err := s.tx.Exec(`SAVEPOINT record`); err != nil {
+		return fmt.Errorf("start record: %w", err)
+	}
+	if err := s.add(record); err != nil {
+		_, _ = s.tx.Exec(`ROLLBACK TO record`)
+		_, _ = s.tx.Exec(`RELEASE record`)
+		return err
+	}
+	if _, err := s.tx.Exec(`RELEASE record`); err != nil {
+		return fmt.Errorf("finish record: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) add(record *dsl.CorpusRecord) error {
+	internalDate := ""
+	if !record.InternalDate.IsZero() {
+		internalDate = record.InternalDate.UTC().Format(time.RFC3339)
+	}
+	result, err := s.tx.Exec(`INSERT INTO messages (
+		uid, message_id, subject, sent_date, internal_date, in_reply_to,
+		message_references, size_bytes, body_text, raw
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.UID, record.MessageID, record.Subject, record.Date, internalDate, record.InReplyTo,
+		record.References, len(record.Raw), record.Body, []byte(record.Raw))
+	if err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+	row, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("read message id: %w", err)
+	}
+	if _, err := s.tx.Exec(`INSERT INTO messages_fts (rowid, subject, body_text) VALUES (?, ?, ?)`,
+		row, record.Subject, record.Body); err != nil {
+		return fmt.Errorf("index message: %w", err)
+	}
+
+	for _, flag := range record.Flags {
+		if _, err := s.tx.Exec(`INSERT OR IGNORE INTO flags (message_row, flag) VALUES (?, ?)`, row, flag); err != nil {
+			return fmt.Errorf("insert flag: %w", err)
+		}
+	}
+
+	var header mail.Header
+	for i, h := range record.Headers {
+		if _, err := s.tx.Exec(`INSERT INTO headers (message_row, position, name, value) VALUES (?, ?, ?, ?)`,
+			row, i, h.Name, h.Value); err != nil {
+			return fmt.Errorf("insert header: %w", err)
+		}
+		header.Add(h.Name, h.Value)
+	}
+
+	for _, kind := range recipientHeaders {
+		addrs, err := header.AddressList(kind)
+		if err != nil {
+			// Unparsable addresses stay available in the headers table
+			continue
+		}
+		for i, addr := range addrs {
+			if _, err := s.tx.Exec(`INSERT INTO recipients (message_row, kind, position, name, address) VALUES (?, ?, ?, ?, ?)`,
+				row, strings.ToLower(kind), i, addr.Name, addr.Address); err != nil {
+				return fmt.Errorf("insert recipient: %w", err)
+			}
+		}
+	}
+
+	for _, part := range record.Parts {
+		if _, err := s.tx.Exec(`INSERT INTO parts (
+			message_row, path, type, charset, disposition, filename, encoding, size_bytes
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			row, part.Path, part.Type, part.Charset, part.Disposition, part.Filename, part.Encoding, part.Size); err != nil {
+			return fmt.Errorf("insert part: %w", err)
+		}
+	}
+
+	return s.addAttachments(row, record)
+}
+
+// addAttachments stores the content of the attachment parts of record.
+// Attachments moved to the blob store by dedup_attachments are stored
+// without content, under the hash of their blob.
+func (s *Store) addAttachments(row int64, record *dsl.CorpusRecord) error {
+	attachments := map[string]dsl.CorpusPart{}
+	for _, part := range record.Parts {
+		if part.Disposition == "attachment" || (part.Filename != "" && !strings.HasPrefix(part.Type, "multipart/")) {
+			attachments[part.Path] = part
+		}
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	entity, err := message.Read(bytes.NewReader([]byte(record.Raw)))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return fmt.Errorf("parse message: %w", err)
+	}
+	return entity.Walk(func(path []int, entity *message.Entity, err error) error {
+		if err != nil {
+			if message.IsUnknownCharset(err) || message.IsUnknownEncoding(err) {
+				return nil
+			}
+			return err
+		}
+		part, ok := attachments[partPath(path)]
+		if !ok {
+			return nil
+		}
+		var content []byte
+		hash := part.SHA256
+		if hash == "" {
+			if content, err = io.ReadAll(entity.Body); err != nil {
+				return fmt.Errorf("read attachment %s: %w", part.Path, err)
+			}
+			sum := sha256.Sum256(content)
+			hash = hex.EncodeToString(sum[:])
+		}
+		if _, err := s.tx.Exec(`INSERT INTO attachments (
+			message_row, part_path, filename, type, size_bytes, sha256, content
+		) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			row, part.Path, part.Filename, part.Type, part.Size, hash, content); err != nil {
+			return fmt.Errorf("insert attachment: %w", err)
+		}
+		return nil
+	})
+}
+
+// Close commits the export and closes the database.
+func (s *Store) Close() error {
+	if s.tx != nil {
+		err := s.tx.Commit()
+		s.tx = nil
+		if err != nil {
+			_ = s.db.Close()
+			return fmt.Errorf("commit export: %w", err)
+		}
+	}
+	return s.db.Close()
+}
+
+// partPath formats path like dsl.CorpusPart paths ("1.2").
+func partPath(path []int) string {
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = fmt.Sprint(n + 1)
+	}
+	return strings.Join(parts, ".")
+}
+
+var _ dsl.ExportStore = (*Store)(nil)
@@ -0,0 +1,98 @@
+package sqliteexport
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/go-go-golems/smailnail/pkg/dsl"
+)
+
+const testMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com, Carol <carol@example.com>\r\n" +
+	"Subject: Quarterly report\r\n" +
+	"Message-ID: <report@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"The numbers look great.\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/csv\r\n" +
+	"Content-Disposition: attachment; filename=q3.csv\r\n" +
+	"\r\n" +
+	"a,b\r\n" +
+	"--b--\r\n"
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), File)
+	store, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	record := &dsl.CorpusRecord{
+		UID:          7,
+		Flags:        []string{"\\Seen", "\\Seen"},
+		InternalDate: time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+		Subject:      "Quarterly report",
+		MessageID:    "<report@example.com>",
+		Headers: []dsl.CorpusHeader{
+			{Name: "From", Value: "Alice <alice@example.com>"},
+			{Name: "To", Value: "bob@example.com, Carol <carol@example.com>"},
+			{Name: "Subject", Value: "Quarterly report"},
+		},
+		Body: "The numbers look great.",
+		Parts: []dsl.CorpusPart{
+			{Path: "", Type: "multipart/mixed"},
+			{Path: "1", Type: "text/plain", Size: 25},
+			{Path: "2", Type: "text/csv", Disposition: "attachment", Filename: "q3.csv", Size: 5},
+		},
+		Raw: testMessage,
+	}
+	if err := store.Add(record); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	if n := count(`SELECT COUNT(*) FROM messages WHERE uid = 7 AND internal_date = '2025-03-03T09:00:00Z'`); n != 1 {
+		t.Fatalf("expected 1 message, got %d", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM flags`); n != 1 {
+		t.Fatalf("expected duplicate flags to be stored once, got %d", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM recipients WHERE kind = 'to'`); n != 2 {
+		t.Fatalf("expected 2 to recipients, got %d", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM messages_fts WHERE messages_fts MATCH 'numbers'`); n != 1 {
+		t.Fatalf("expected the body to be searchable, got %d", n)
+	}
+
+	var content string
+	if err := db.Get(&content, `SELECT content FROM attachments WHERE filename = 'q3.csv'`); err != nil {
+		t.Fatalf("attachment: %v", err)
+	}
+	if strings.TrimSpace(content) != "a,b" {
+		t.Fatalf("unexpected attachment content %q", content)
+	}
+}
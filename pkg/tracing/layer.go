@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/fields"
+	"github.com/go-go-golems/glazed/pkg/cmds/schema"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// TracingSettings represents the settings for exporting OpenTelemetry
+// traces
+type TracingSettings struct {
+	// OTLPEndpoint is the URL of the OTLP/HTTP collector, e.g.
+	// http://localhost:4318. Tracing is off without it.
+	OTLPEndpoint string `glazed:"otlp-endpoint"`
+	ServiceName  string `glazed:"otlp-service-name"`
+	// SampleRatio is the fraction of traces exported
+	SampleRatio float64 `glazed:"otlp-sample-ratio"`
+}
+
+const TracingSectionSlug = "tracing"
+
+// NewTracingSection creates a new section for OpenTelemetry tracing
+// settings.
+func NewTracingSection() (schema.Section, error) {
+	return schema.NewSection(
+		TracingSectionSlug,
+		"OpenTelemetry Tracing Settings",
+		schema.WithFields(
+			fields.New(
+				"otlp-endpoint",
+				fields.TypeString,
+				fields.WithHelp("Export traces of IMAP operations to this OTLP/HTTP collector URL, e.g. http://localhost:4318"),
+			),
+			fields.New(
+				"otlp-service-name",
+				fields.TypeString,
+				fields.WithHelp("Service name the traces are reported under"),
+				fields.WithDefault("smailnail"),
+			),
+			fields.New(
+				"otlp-sample-ratio",
+				fields.TypeFloat,
+				fields.WithHelp("Fraction of traces to export, between 0 and 1"),
+				fields.WithDefault(1.0),
+			),
+		),
+	)
+}
+
+// Setup installs a global tracer provider exporting to the OTLP endpoint.
+// The returned function flushes the pending spans and must be called before
+// exiting. Without an endpoint, Setup does nothing.
+func (s *TracingSettings) Setup(ctx context.Context) (func(context.Context) error, error) {
+	if s.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if s.SampleRatio < 0 || s.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid --otlp-sample-ratio %v, must be between 0 and 1", s.SampleRatio)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(s.OTLPEndpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	serviceName := s.ServiceName
+	if serviceName == "" {
+		serviceName = "smailnail"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(s.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -2,11 +2,14 @@ package types
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-// EmailTemplate defines the structure of an email template
+// EmailTemplate defines the structure of an email template. The address
+// fields may render to comma-separated lists such as
+// "Alice <alice@example.com>, bob@example.com".
 type EmailTemplate struct {
 	Subject string `yaml:"subject"`
 	From    string `yaml:"from"`
@@ -15,6 +18,21 @@ type EmailTemplate struct {
 	Bcc     string `yaml:"bcc,omitempty"`
 	ReplyTo string `yaml:"reply_to,omitempty"`
 	Body    string `yaml:"body"`
+	// Flags is a template rendering to a comma or space separated flag list
+	// such as "\\Seen \\Flagged" (default "\\Seen"); an empty result
+	// leaves the message unread.
+	Flags string `yaml:"flags,omitempty"`
+	// InternalDate is a template rendering to the IMAP internal date and
+	// Date header, in RFC3339, "2006-01-02 15:04:05" or "2006-01-02" format
+	// (default now).
+	InternalDate string `yaml:"internal_date,omitempty"`
+	// MessageID, InReplyTo and References are templates rendering to
+	// message IDs such as "<thread-1@example.com>"; References may render
+	// to a space separated list and defaults to InReplyTo. They build reply
+	// chains, e.g. in_reply_to: "<msg-{{ .parent }}@example.com>".
+	MessageID  string `yaml:"message_id,omitempty"`
+	InReplyTo  string `yaml:"in_reply_to,omitempty"`
+	References string `yaml:"references,omitempty"`
 }
 
 // TemplateConfig defines the structure of the YAML configuration file
@@ -29,6 +47,9 @@ type TemplateConfig struct {
 type RuleConfig struct {
 	Template   string              `yaml:"template"`
 	Variations []map[string]string `yaml:"variations"` // All values must be strings
+	// Corpus is a JSONL corpus exported by smailnail (export.format: jsonl)
+	// whose messages are added as variations, relative to the config file
+	Corpus string `yaml:"corpus,omitempty"`
 }
 
 // GenerateConfig defines how many emails to generate using a particular rule
@@ -40,13 +61,19 @@ type GenerateConfig struct {
 
 // Email represents a generated email
 type Email struct {
-	Subject string `json:"subject"`
-	From    string `json:"from"`
-	To      string `json:"to,omitempty"`
-	Cc      string `json:"cc,omitempty"`
-	Bcc     string `json:"bcc,omitempty"`
-	ReplyTo string `json:"reply_to,omitempty"`
-	Body    string `json:"body"`
+	Subject string   `json:"subject"`
+	From    string   `json:"from"`
+	To      string   `json:"to,omitempty"`
+	Cc      string   `json:"cc,omitempty"`
+	Bcc     string   `json:"bcc,omitempty"`
+	ReplyTo string   `json:"reply_to,omitempty"`
+	Body    string   `json:"body"`
+	Flags   []string `json:"flags"`
+	// InternalDate is zero when the template sets no internal_date
+	InternalDate time.Time `json:"internal_date,omitempty"`
+	MessageID    string    `json:"message_id,omitempty"`
+	InReplyTo    string    `json:"in_reply_to,omitempty"`
+	References   string    `json:"references,omitempty"`
 }
 
 // validateVariables ensures all values in the variables map are either strings or []string
@@ -108,6 +135,13 @@ func (tc *TemplateConfig) Validate() error {
 			return errors.Errorf("rule '%s' refers to undefined template '%s'", ruleName, rule.Template)
 		}
 
+		if len(rule.Variations) == 0 {
+			if rule.Corpus != "" {
+				return errors.Errorf("rule '%s' has no variations, load its corpus '%s' first", ruleName, rule.Corpus)
+			}
+			return errors.Errorf("rule '%s' has no variations", ruleName)
+		}
+
 		// Validate that all variation values are strings
 		for i, variation := range rule.Variations {
 			for key, value := range variation {